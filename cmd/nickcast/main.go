@@ -1,18 +1,65 @@
 package main
 
 import (
+    "context"
+    "errors"
     "fmt"
     "log"
     "nickcast/config"
+    "nickcast/internal/crash"
     "nickcast/internal/server"
+    "nickcast/internal/supervisor"
+    "os"
+    "os/signal"
+    "runtime/debug"
+    "syscall"
 )
 
 func main() {
+    // `nickcast source` is a source client, not a server.
+    if len(os.Args) > 1 && os.Args[1] == "source" {
+        os.Exit(runSource(os.Args[2:]))
+    }
+
+    crash.CaptureLog()
+    // A panic nothing recovers still kills the process; make sure stderr
+    // then gets every goroutine, not just the one that panicked.
+    debug.SetTraceback("all")
+
     err := config.LoadConfig()
     if err != nil {
         log.Fatalf("Failed to load config: %v", err)
     }
 
+    crash.Configure(config.AppConfig.CrashDir, config.AppConfig.CrashReportURL)
+    crash.AddSection("config.txt", config.AppConfig.Redacted)
+    defer func() {
+        if v := recover(); v != nil {
+            crash.Report(fmt.Sprintf("panic: %v", v), debug.Stack())
+            panic(v)
+        }
+    }()
+
+    // Stop everything cleanly on Ctrl-C or a service manager's SIGTERM.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    sup := supervisor.New(ctx)
+    sup.OnPanic(func(service string, v interface{}, stack []byte) {
+        // The service restarts meanwhile; the bundle can take its time.
+        go crash.Report(fmt.Sprintf("panic in %s: %v", service, v), stack)
+    })
+
     fmt.Println("Starting stream server on", config.AppConfig.ListenAddress)
-    server.Start()
+    if err := server.Start(sup); err != nil {
+        log.Fatalf("Failed to start server: %v", err)
+    }
+
+    // Start failures are configuration problems, reported on the spot;
+    // a critical service failing later is the kind worth a bundle.
+    if err := sup.Wait(); !errors.Is(err, supervisor.ErrShutdown) {
+        crash.Report(fmt.Sprintf("server stopped: %v", err), nil)
+        log.Fatalf("Server stopped: %v", err)
+    }
+    log.Println("Shut down cleanly")
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"nickcast/pkg/client"
+)
+
+// runSource is `nickcast source`: a source client that streams its
+// standard input to a mount, so a cron job or a pipeline such as
+//
+//	ffmpeg -re -i show.flac -f mp3 - | nickcast source --from-stdin --url https://radio.example.org/stream --user alice
+//
+// can go on air without an Icecast source client. The password comes from
+// NICKCAST_PASSWORD, or --password, which other users of the machine can
+// see. It returns the process's exit status.
+func runSource(args []string) int {
+	fs := flag.NewFlagSet("nickcast source", flag.ContinueOnError)
+	fromStdin := fs.Bool("from-stdin", false, "stream the audio on standard input")
+	rawURL := fs.String("url", "", "the mount's source URL, e.g. https://radio.example.org/stream")
+	user := fs.String("user", "", "NickServ account to stream as")
+	password := fs.String("password", "", "the account's password (default $NICKCAST_PASSWORD)")
+	var opts client.StreamOptions
+	fs.StringVar(&opts.ContentType, "content-type", "audio/mpeg", "the audio's content type")
+	fs.StringVar(&opts.Name, "name", "", "stream name shown to listeners")
+	fs.StringVar(&opts.Description, "description", "", "stream description shown to listeners")
+	fs.StringVar(&opts.Genre, "genre", "", "stream genre")
+	fs.BoolVar(&opts.Takeover, "takeover", false, "replace your own stale session (admins only)")
+	fs.BoolVar(&opts.Override, "override", false, "stream over someone else's booking (admins only)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !*fromStdin {
+		fmt.Fprintln(os.Stderr, "nickcast source: nothing to stream; use --from-stdin")
+		return 2
+	}
+	u, err := url.Parse(*rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path == "" {
+		fmt.Fprintln(os.Stderr, "nickcast source: --url must be a mount's full source URL, e.g. https://radio.example.org/stream")
+		return 2
+	}
+	if *user == "" {
+		fmt.Fprintln(os.Stderr, "nickcast source: --user is required")
+		return 2
+	}
+	if *password == "" {
+		*password = os.Getenv("NICKCAST_PASSWORD")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := client.New(u.Scheme+"://"+u.Host, *user, *password)
+	log.Printf("Streaming standard input to %s as %s", u.Redacted(), *user)
+	err = c.Stream(ctx, u.RequestURI(), os.Stdin, opts)
+	switch {
+	case err == nil:
+		log.Println("End of input; stream finished")
+		return 0
+	case errors.Is(err, context.Canceled):
+		log.Println("Interrupted; stream stopped")
+		return 1
+	}
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		log.Printf("Server refused the stream: %s", apiErr.Message)
+	} else {
+		log.Printf("Stream failed: %v", err)
+	}
+	return 1
+}
@@ -2,22 +2,690 @@ package config
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"nickcast/internal/metadata"
+	"nickcast/internal/nowplaying"
+	"nickcast/internal/purge"
+	"nickcast/internal/schedule"
+	"nickcast/internal/snmp"
 )
 
+// DefaultMountName is the mount that always exists and keeps the original
+// /stream and /listen paths.
+const DefaultMountName = "default"
+
+// DefaultStationName is the station that owns every mount not assigned to
+// another one. It uses the global auth_url, api_token and admins.
+const DefaultStationName = "default"
+
 // Config holds configuration values loaded from nickcast.conf
 type Config struct {
 	ListenAddress string
 	AuthURL       string
 	APIToken      string
+	WebhookURL    string
+	RecordDir     string
+	FFmpegPath    string // optional; enables loudness analysis and archive transcoding
+
+	// ShadowAuthURL and ShadowAPIToken name a second backend speaking
+	// the same protocol, consulted alongside auth_url without being
+	// enforced, to try out a replacement before switching over.
+	ShadowAuthURL  string
+	ShadowAPIToken string
+
+	// Archive serves RecordDir over HTTP at /archive/.
+	Archive        bool
+	ArchiveBitrate int // default kbps for transcoded archive downloads
+	ArchiveMaxRate int // kbps each archive download is held to; 0 is unlimited
+
+	// Churn protection for listeners stuck in reconnect loops.
+	ChurnLimit    int // connections per minute per IP+User-Agent; 0 disables
+	ChurnMaxDelay int // seconds; a penalty longer than this becomes a ban
+	ChurnBan      int // seconds a churning client stays banned
+
+	// Pre-recorded show uploads, aired by the scheduler in their slot.
+	ShowsDir          string // empty disables uploads
+	UploadMaxSize     int    // bytes
+	UploadMaxDuration int    // seconds
+
+	// Text-to-speech for announcements: a command reading text on stdin and
+	// writing audio to stdout, or an HTTP endpoint doing the same.
+	TTSCommand string
+	TTSURL     string
+
+	// Track recognition for sources that send no titles: a command or an
+	// HTTP endpoint given a few seconds of the stream, answering with the
+	// track, tried every FingerprintInterval seconds.
+	FingerprintCommand  string
+	FingerprintURL      string
+	FingerprintInterval int
+
+	// Live captions for mounts with transcribe set: a command or an HTTP
+	// endpoint given every TranscribeInterval seconds of the broadcast,
+	// answering with what was said.
+	TranscribeCommand  string
+	TranscribeURL      string
+	TranscribeInterval int
+
+	// Policy script: a Lua file whose functions decide listener admission,
+	// rewrite titles and react to events.
+	PolicyScript  string
+	PolicyTimeout int // milliseconds a hook may run
+
+	// NickServ accounts allowed to use the admin API.
+	Admins []string
+
+	// SourceIPs binds streamer accounts to the addresses they may
+	// broadcast from, for the default station; see Station.SourceIPs.
+	SourceIPs map[string][]netip.Prefix
+
+	// MaxPriorities caps the priority streamer accounts may ask for, for
+	// the default station; see Station.MaxPriorities.
+	MaxPriorities map[string]int
+
+	// Per-IP limits and bans aggregate addresses to these prefix lengths.
+	IPv4Prefix        int
+	IPv6Prefix        int
+	MaxListenersPerIP int      // 0 means unlimited
+	Bans              []string // addresses or CIDR prefixes
+
+	// MemoryBudget caps the audio buffered for mounts and listeners, in
+	// bytes; past it new listeners are turned away. 0 means no cap.
+	MemoryBudget int
+
+	// Soft limits on goroutines and open file descriptors; passing one
+	// logs a warning with per-subsystem counts. 0 disables the check.
+	GoroutineSoftLimit int
+	FDSoftLimit        int
+
+	// SNMP. With SNMPListen set, a read-only SNMP v1/v2c agent answers on
+	// that UDP address to SNMPCommunity, serving listener counts, uptime
+	// and traffic under SNMPOID for monitoring that speaks nothing else.
+	// SNMPAllow, if set, lists the addresses and CIDR ranges it answers;
+	// without it, the community must be something other than public.
+	SNMPListen    string
+	SNMPCommunity string
+	SNMPOID       string
+	SNMPAllow     []string
+
+	// Crash bundles are written to CrashDir on a panic or fatal error and,
+	// if CrashReportURL is set, POSTed there too.
+	CrashDir       string
+	CrashReportURL string
+
+	// GeoIP lookups for per-mount country restrictions.
+	GeoIPDB       string // CSV range database (DB-IP / IP2Location LITE format)
+	GeoIPHeader   string // trusted country header set by a CDN, e.g. CF-IPCountry
+	GeoIPDenyPage string // html/template file shown to denied listeners
+
+	// LocaleDir holds message catalogs (de.txt, pt-br.txt...) translating
+	// what listeners see; see the i18n package.
+	LocaleDir string
+
+	// Location is the time zone that broadcast windows are written in.
+	Location *time.Location
+
+	// HTTPS listener. TLSCert/TLSKey is the certificate for hosts that
+	// don't belong to a station with its own.
+	TLSListen string
+	TLSCert   string
+	TLSKey    string
+
+	// TapSocket is a Unix socket serving /internal/tap, which mirrors a
+	// mount's raw source input to one local consumer at a time.
+	TapSocket string
+
+	// SHOUTcast v1 sources. With ShoutcastMount set, encoders that only
+	// speak SHOUTcast v1 connect to ShoutcastListen (by default the listen
+	// port plus one, where they expect it) and feed that mount.
+	ShoutcastMount  string
+	ShoutcastListen string
+
+	// SRT sources. With SRTListen set, broadcasters push audio over SRT to
+	// that UDP address, with lost packets resent for up to SRTLatency
+	// milliseconds.
+	SRTListen  string
+	SRTLatency int
+
+	// RTMP sources. With RTMPListen set, broadcasting tools that only
+	// speak RTMP (OBS among them) publish to that address, and the audio
+	// track of what they send feeds the mount.
+	RTMPListen string
+
+	// Hot standby. A primary with ReplicateTo (the standby's base URL) set
+	// pushes every encoder's stream on to the standby's matching mount as
+	// it arrives; the standby takes pushes carrying ReplicationToken in
+	// place of NickServ credentials.
+	ReplicateTo      string
+	ReplicationToken string
+
+	// Master/slave relaying. A master with RelayToken set lists its mounts
+	// for slaves and lets them listen past listener checks; a slave with
+	// Master (the master's base URL) and the same RelayToken relays every
+	// mount the master has.
+	Master     string
+	RelayToken string
+
+	// ImportState is a snapshot from another host's /admin/state, applied
+	// once at startup when moving the server.
+	ImportState string
+
+	// Store is where runtime bans, bookings and slots are kept so they
+	// survive a restart: a directory, or a redis:// URL for servers that
+	// share them. With StoreSync set, they are read back from it that
+	// often (seconds), to pick up other servers' changes.
+	Store     string
+	StoreSync int
+
+	// Stations are independent tenants sharing the process, each with its
+	// own mounts, NickServ backend, admins and branding. The default
+	// station is always first.
+	Stations []Station
+
+	// NowPlaying services receive every title update, for playlist sites
+	// such as Spinitron or Radio.co.
+	NowPlaying []nowplaying.Service
+
+	// Devices are listener buffering profiles, matched against the
+	// User-Agent in order; the first match wins. There are none unless
+	// the config has [device] sections, or sets DeviceProfiles for the
+	// built-in DefaultDevices.
+	Devices        []DeviceProfile
+	DeviceProfiles bool
+
+	// Proxies put other local services (a web chat, the station's site)
+	// on NickCast's own port, so a small station needs one public address.
+	Proxies []Proxy
+
+	// Moves send listeners who come back to a path that is no longer
+	// served, after a mount was renamed or removed or a variant dropped,
+	// to its replacement, or tell them it is gone.
+	Moves []Move
+
+	// ResponseHeaders are set on the responses of a group of endpoints,
+	// over whatever NickCast would send: security headers, X-Robots-Tag,
+	// or the cache rules a fronting CDN needs, which differ between
+	// streams and the API.
+	ResponseHeaders []ResponseHeader
+
+	// CDN cache purging. With PurgeProvider (cloudflare or fastly) set,
+	// pages that change, such as the title on air, the show schedule and
+	// the recordings list, are purged under each PurgeBase URL, the
+	// station's public addresses through the CDN. PurgeZone is the
+	// Cloudflare zone; PurgeToken the API token or key.
+	PurgeProvider string
+	PurgeZone     string
+	PurgeToken    string
+	PurgeBase     []string
+
+	// HLS origin push. With HLSPushURL set, the segments and playlists of
+	// mounts with hls are also written to that S3-compatible bucket as
+	// they are cut, for a CDN to serve from, signed with HLSPushAccessKey
+	// and HLSPushSecretKey for HLSPushRegion.
+	HLSPushURL       string
+	HLSPushRegion    string
+	HLSPushAccessKey string
+	HLSPushSecretKey string
+
+	// MountDefaults holds the global values of the per-mount knobs. Every
+	// mount starts from a copy of these and applies its own overrides.
+	MountDefaults MountConfig
+	Mounts        []MountConfig
+
+	// text is the config file as loaded, for Redacted.
+	text []byte
+}
+
+// Station is one tenant: typically one IRC community.
+type Station struct {
+	Name     string
+	AuthURL  string
+	APIToken string
+	Admins   []string
+
+	// ShadowAuthURL and ShadowAPIToken are the station's shadow backend;
+	// see Config.
+	ShadowAuthURL  string
+	ShadowAPIToken string
+
+	// SourceIPs binds streamer accounts to the addresses they may
+	// broadcast from: an account listed here is refused as a source from
+	// anywhere else, even with the right password. Accounts not listed may
+	// broadcast from anywhere.
+	SourceIPs map[string][]netip.Prefix
+
+	// MaxPriorities caps the priority each streamer account may ask for
+	// to take over a live mount. Accounts not listed may ask for 0 at
+	// most, so only those trusted with it can cut in; admins aren't
+	// capped.
+	MaxPriorities map[string]int
+
+	// Branding, sent to listeners as icy-name, icy-description, icy-url
+	// and icy-genre.
+	Title       string
+	Description string
+	URL         string
+	Genre       string
+
+	// The station's /player page: PlayerAccent is a CSS color for its
+	// buttons, PlayerLogo an image URL shown above them, and
+	// PlayerTemplate an html/template file replacing the built-in page.
+	PlayerAccent   string
+	PlayerLogo     string
+	PlayerTemplate string
+
+	// Hosts are the station's own domains. Requests for them reach its
+	// mounts at /stream and /listen, without the /<station> prefix, and
+	// HTTPS connections for them use TLSCert/TLSKey if set.
+	Hosts   []string
+	TLSCert string
+	TLSKey  string
+
+	// Quotas keep one busy station from starving the others; zero means
+	// unlimited.
+	MaxListeners  int   // listeners across all of the station's mounts
+	MaxBandwidth  int   // outgoing listener traffic, kbit/s
+	MaxRecordDisk int64 // bytes of recordings in record_dir
+	MaxMounts     int
 }
 
+// MountConfig holds the settings that can differ between mounts.
+type MountConfig struct {
+	Name         string
+	Station      string // owning station; mounts named "<station>/<mount>" belong to it
+	SourcePath   string
+	ListenPath   string
+	Aliases      []string // extra listen paths, e.g. legacy SHOUTcast "/;"
+	BurstSize    int      // bytes of recent audio sent to new listeners
+	BurstSizeSet bool     // burst_size was given, so device profiles leave it be
+	Timeshift    int      // bytes of recent audio kept for clips; 0 uses the burst buffer
+	MaxListeners int      // 0 means unlimited
+	ContentType  string   // sent to listeners
+	Language     string   // for listeners whose Accept-Language has no catalog, and stream title notices
+	Fallback     string   // mount to serve when this one has no source
+	ListenerAuth bool     // require NickServ credentials from listeners
+	Record       bool     // write each stream session to RecordDir
+	MetaInt      int      // ICY metadata interval in bytes; 0 disables ICY metadata
+	Bitrate      int      // advertised to listeners as icy-br (kbps); 0 omits it
+	RetryAfter   int      // seconds players should wait before retrying a 503
+	KeepAlive    int      // Keep-Alive timeout hint in seconds; 0 omits it
+	IdleTimeout  int      // seconds a listener may go without reading before it is dropped; 0 never
+
+	// IntroFile is played to each new listener before the live stream.
+	IntroFile string
+
+	// OfflineFile is looped to listeners while nobody is streaming and the
+	// fallback mount isn't live either.
+	OfflineFile string
+
+	// ListenerParams are the /listen query parameters (burst, intro, meta)
+	// players may use to tailor how their stream starts.
+	ListenerParams []string
+
+	// Shaping holds each listener to the stream bitrate plus
+	// ShapingHeadroom percent once their initial burst is sent.
+	Shaping         bool
+	ShapingHeadroom int
+
+	// Dead air: a connected source that sends nothing for DeadAirTimeout
+	// seconds triggers an alert, and listeners get the fallback mount or
+	// DeadAirFile looped until the source recovers.
+	DeadAirTimeout int
+	DeadAirFile    string
+
+	// Silence: an MP3 source that keeps sending nothing but silence for
+	// SilenceTimeout seconds, such as an encoder whose input is unplugged,
+	// is reported and, with SilenceAction "disconnect", dropped so the
+	// fallback can take over; "alert" only reports it. 0 disables it.
+	SilenceTimeout int
+	SilenceAction  string
+
+	// MaxDrift is how many seconds an MP3 source may run ahead of real time
+	// before frames are trimmed; 0 disables drift compensation.
+	MaxDrift int
+
+	// IngestLimit caps how fast a source may send, as a multiple of the
+	// stream's bitrate. Anything faster is held back, so a broken or
+	// hostile source can't flood the buffers; 0 disables the limit.
+	// IngestBurst is how many seconds ahead of that rate a source may get
+	// first, for encoders that send their buffer on connecting.
+	IngestLimit float64
+	IngestBurst int
+
+	// ReadSize is how many bytes are read from a source at a time; larger
+	// reads cost less for high-bitrate sources. A source that sends nothing
+	// for IngestTimeout seconds is disconnected, so a stalled encoder frees
+	// the mount for another; 0 waits for the connection to drop. With
+	// IngestMinBytes set, a source must also send at least that much in
+	// every IngestTimeout seconds, which catches encoders that trickle.
+	ReadSize       int
+	IngestTimeout  int
+	IngestMinBytes int
+
+	// Source gaps. SilenceFill is how many seconds of silent MP3 frames
+	// listeners get when the source pauses or drops, so players' buffers
+	// don't run dry; ReconnectGrace is how long listeners are kept after
+	// the source disconnects, for it (or another) to pick up the stream.
+	// With ReconnectReserve, the default, only the account that dropped
+	// may.
+	SilenceFill      int
+	ReconnectGrace   int
+	ReconnectReserve bool
+
+	// Takeover lets a source that connects with a priority higher than the
+	// live one's replace it, listeners and all, rather than be refused.
+	Takeover bool
+
+	// Handoff lets the next DJ ask for the mount while someone is live:
+	// the live DJ is warned, and HandoffWarning seconds later the new
+	// source carries on the stream. MP3 then goes out in whole frames,
+	// so the switch falls between two.
+	Handoff        bool
+	HandoffWarning int
+
+	// AutoDJDir is a directory of audio in the mount's format that is
+	// played, shuffled, whenever no source is connected. Any source takes
+	// over from it.
+	AutoDJDir string
+
+	// Relay is an upstream Icecast or SHOUTcast stream URL the mount pulls
+	// and republishes, reconnecting whenever it drops. Credentials go in
+	// the URL.
+	Relay string
+
+	// MonoBitrate (kbit/s) adds a mono MP3 copy of the mount at that
+	// bitrate, for listeners on slow mobile connections: a mount named
+	// <name>-mono, listened to at the mount's listen path plus "-mono",
+	// that ffmpeg feeds while the mount is live. DownmixOf is set on those
+	// generated mounts to the mount they copy.
+	MonoBitrate int
+	DownmixOf   string
+
+	// UDP sources. With UDPListen set, the mount takes audio sent as RTP
+	// packets (or, with UDPFormat "raw", bare datagrams) to that address,
+	// unicast or a multicast group, from UDPAllow senders only. UDP has no
+	// way to log in, so the stream goes out as UDPAccount.
+	UDPListen  string
+	UDPFormat  string
+	UDPAllow   []string
+	UDPAccount string
+
+	// RTP output. With RTPOutput set, whatever the mount broadcasts is also
+	// sent as RTP to that address, typically a multicast group, so a LAN
+	// can take the stream without a connection per receiver. RTPTTL is the
+	// multicast time-to-live: 1 keeps it on the local network.
+	RTPOutput string
+	RTPTTL    int
+
+	// HLS output. With HLS set, the stream is also cut into segments of
+	// about HLSSegment seconds for HTTP Live Streaming, served under /hls
+	// with the last HLSWindow of them in the playlist, for players that
+	// won't take a progressive stream.
+	HLS        bool
+	HLSSegment int
+	HLSWindow  int
+
+	// MPEG-DASH output, the same for players standardized on DASH: with
+	// DASH set, segments of about DASHSegment seconds are served under
+	// /dash with the last DASHWindow of them in the manifest.
+	DASH        bool
+	DASHSegment int
+	DASHWindow  int
+
+	// Transcribe has what is said on the mount written down by the
+	// transcribe_command or transcribe_url, for live captions and a
+	// transcript next to each recording.
+	Transcribe bool
+
+	// SourceHeaders are "Name: value" lines a source connection must carry
+	// on top of valid NickServ credentials; a name listed more than once
+	// accepts any of its values. A mount with source_header lines of its
+	// own replaces the global ones.
+	SourceHeaders []string
+
+	// HeartbeatTimeout is how long a source that connected with a session
+	// token may go without pinging /api/source/heartbeat before it is
+	// disconnected.
+	HeartbeatTimeout int
+
+	// Icecast-style URL authentication: ListenerAddURL decides whether each
+	// listener may connect, and ListenerRemoveURL is told when they leave.
+	ListenerAddURL    string
+	ListenerRemoveURL string
+
+	AllowCountries []string // if set, only these ISO country codes may listen
+	DenyCountries  []string // these ISO country codes may never listen
+
+	// TitleFilters clean up titles from encoders, in order. A mount with
+	// title_filter lines of its own replaces the global pipeline.
+	TitleFilters metadata.Pipeline
+
+	// Windows restricts when the mount may broadcast and be listened to,
+	// e.g. a community license that only covers 18:00-24:00. Empty means
+	// always open.
+	Windows schedule.Windows
+
+	// Bookings reserve weekly time slots on the mount for one account
+	// each: while a booking is on, only its account may broadcast. With
+	// BookedOnly, nobody may broadcast outside a booking either.
+	Bookings   []Booking
+	BookedOnly bool
+
+	// Variants send a share of the mount's listeners elsewhere, to trial
+	// another delivery format or mount; the rest stay on the mount. Their
+	// weights add up to 100 at most.
+	Variants []Variant
+}
+
+// Booking reserves Windows on a mount for Account's broadcasts.
+type Booking struct {
+	Account string
+	Windows schedule.Windows
+}
+
+// parseBooking parses "<nick> <windows>".
+func parseBooking(value string) (Booking, error) {
+	f := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	if len(f) != 2 {
+		return Booking{}, fmt.Errorf("expected <nick> <windows>, e.g. alice Fri 20:00-22:00")
+	}
+	ws, err := schedule.ParseWindows(f[1])
+	if err != nil {
+		return Booking{}, err
+	}
+	if len(ws) == 0 {
+		return Booking{}, fmt.Errorf("no windows given")
+	}
+	return Booking{Account: f[0], Windows: ws}, nil
+}
+
+// Variant is one arm of a listener trial: Weight percent of a mount's
+// listeners are redirected to Target.
+type Variant struct {
+	Name   string
+	Weight int    // percent of listeners
+	Target string // a path on this server or an http(s) URL
+}
+
+// ControlVariant names the listeners a mount with variants keeps.
+const ControlVariant = "control"
+
+// parseVariant parses "<name> <weight>% <target>".
+func parseVariant(value string) (Variant, error) {
+	f := strings.Fields(value)
+	if len(f) != 3 {
+		return Variant{}, fmt.Errorf("expected <name> <weight>%% <target>")
+	}
+	v := Variant{Name: f[0], Target: f[2]}
+	if v.Name == ControlVariant {
+		return Variant{}, fmt.Errorf("%s names the listeners that stay on the mount", ControlVariant)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(f[1], "%"))
+	if err != nil || n <= 0 || n > 100 {
+		return Variant{}, fmt.Errorf("weight must be a percentage from 1 to 100")
+	}
+	v.Weight = n
+	if !strings.HasPrefix(v.Target, "/") {
+		u, err := url.Parse(v.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Variant{}, fmt.Errorf("target must be a path or an http(s) URL")
+		}
+	}
+	return v, nil
+}
+
+// Move is a listen path that is no longer served. Its listeners are
+// redirected to To, a path here or an http(s) URL, or told the stream is
+// gone if To is empty.
+type Move struct {
+	From string
+	To   string
+}
+
+// parseMove parses "<old path> [<new path or URL>]".
+func parseMove(value string) (Move, error) {
+	f := strings.Fields(value)
+	if len(f) == 0 || len(f) > 2 {
+		return Move{}, fmt.Errorf("expected <old path> [<new path or URL>]")
+	}
+	mv := Move{From: f[0]}
+	if !strings.HasPrefix(mv.From, "/") || mv.From == "/" {
+		return Move{}, fmt.Errorf("the old path must start with /")
+	}
+	if len(f) == 2 {
+		mv.To = f[1]
+		if !strings.HasPrefix(mv.To, "/") {
+			u, err := url.Parse(mv.To)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return Move{}, fmt.Errorf("the new location must be a path or an http(s) URL")
+			}
+		}
+	}
+	return mv, nil
+}
+
+// DeviceProfile tunes buffering for one class of listener device. Zero
+// values leave the mount's own setting in place.
+type DeviceProfile struct {
+	Name      string
+	Match     []string // case-insensitive User-Agent substrings
+	BurstSize int
+	KeepAlive int
+}
+
+// DefaultDevices apply when device_profiles is set and the config has no
+// [device] sections. Hardware
+// radios have small decode buffers and little patience, so they get a big
+// burst and a long keep-alive; desktop browsers buffer plenty themselves,
+// so a small burst keeps their latency down. Mobile sits in between and
+// must be matched before "Mozilla", which mobile browsers also send.
+var DefaultDevices = []DeviceProfile{
+	{Name: "radio", Match: []string{"Frontier Silicon", "Reciva", "vTuner", "Sonos", "Roku", "Grundig", "Bose", "Denon", "Yamaha", "Libratone", "Teufel", "NSPlayer", "WinampMPEG"}, BurstSize: 256 * 1024, KeepAlive: 60},
+	{Name: "mobile", Match: []string{"iPhone", "iPad", "Android", "Mobile"}, BurstSize: 128 * 1024},
+	{Name: "browser", Match: []string{"Mozilla"}, BurstSize: 32 * 1024},
+}
+
+// Proxy is a [proxy] section: requests under Path are passed to Target.
+type Proxy struct {
+	Name      string
+	Path      string // always ends in /
+	Target    string // http:// or https:// URL
+	StripPath bool   // Target sees paths without Path's prefix
+}
+
+// ResponseHeader is a response_header line: "<group> Name: value".
+type ResponseHeader struct {
+	Group string // one of ResponseHeaderGroups, or a path prefix
+	Name  string
+	Value string // empty removes the header
+}
+
+// ResponseHeaderGroups are the endpoint groups response_header knows by
+// name: everything, mounts' listen paths and aliases, /api/, the admin
+// endpoints and /metrics, and recordings (/archive and /clips).
+var ResponseHeaderGroups = []string{"all", "listen", "api", "admin", "archive"}
+
+// parseResponseHeader parses a response_header value.
+func parseResponseHeader(value string) (ResponseHeader, error) {
+	group, header, ok := strings.Cut(value, " ")
+	name, val, colon := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || !colon || name == "" || strings.ContainsAny(name, " \t()<>@,;:\\\"/[]?={}") {
+		return ResponseHeader{}, fmt.Errorf("must look like <group> Name: value")
+	}
+	known := strings.HasPrefix(group, "/")
+	for _, g := range ResponseHeaderGroups {
+		known = known || g == group
+	}
+	if !known {
+		return ResponseHeader{}, fmt.Errorf("group must be a path prefix or one of %s", strings.Join(ResponseHeaderGroups, ", "))
+	}
+	return ResponseHeader{Group: group, Name: http.CanonicalHeaderKey(name), Value: strings.TrimSpace(val)}, nil
+}
+
+// reservedPaths are NickCast's own endpoints, which a proxy may not cover.
+var reservedPaths = []string{"/admin", "/api", "/metrics", "/archive", "/clips", "/admin.cgi", "/hls", "/dash", "/player", "/nowplaying.txt"}
+
+// ReservedPath reports whether p is one of NickCast's own endpoints, or
+// under one.
+func ReservedPath(p string) bool {
+	for _, r := range reservedPaths {
+		if p == r || strings.HasPrefix(p, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// WSSourcePath follows a mount's source path for WebSocket sources:
+// /stream/ws for the default mount.
+const WSSourcePath = "/ws"
+
 // AppConfig is the global config used throughout the application
 var AppConfig Config
 
+// Station returns the named station, or nil if there isn't one.
+func (c *Config) Station(name string) *Station {
+	for i := range c.Stations {
+		if c.Stations[i].Name == name {
+			return &c.Stations[i]
+		}
+	}
+	return nil
+}
+
+// Mount returns the named mount, or nil if there isn't one.
+func (c *Config) Mount(name string) *MountConfig {
+	for i := range c.Mounts {
+		if c.Mounts[i].Name == name {
+			return &c.Mounts[i]
+		}
+	}
+	return nil
+}
+
+// mountSection collects the raw key/value lines of one [mount name],
+// [device name], [station name] or [nowplaying name] section. They are
+// applied after the whole file is read so that global defaults win
+// regardless of where they appear.
+type mountSection struct {
+	kind  string // "mount", "device", "station" or "nowplaying"
+	name  string
+	lines [][2]string
+}
+
 // LoadConfig reads nickcast.conf from the binary's directory
 func LoadConfig() error {
 	execPath, err := os.Executable()
@@ -27,21 +695,96 @@ func LoadConfig() error {
 
 	configPath := filepath.Join(filepath.Dir(execPath), "nickcast.conf")
 
-	file, err := os.Open(configPath)
+	text, err := os.ReadFile(configPath)
 	if err != nil {
 		return fmt.Errorf("error opening config file (%s): %w", configPath, err)
 	}
-	defer file.Close()
 
-	cfg := Config{}
+	cfg := Config{
+		RecordDir:      filepath.Join(filepath.Dir(execPath), "recordings"),
+		CrashDir:       filepath.Join(filepath.Dir(execPath), "crashes"),
+		ChurnLimit:     10,
+		ChurnMaxDelay:  30,
+		ChurnBan:       300,
+		ArchiveBitrate: 128,
+		IPv4Prefix:     32,
+		IPv6Prefix:     64,
+		Location:       time.Local,
+
+		UploadMaxSize:     512 * 1024 * 1024,
+		UploadMaxDuration: 4 * 60 * 60, // long enough for any regular show
+		PolicyTimeout:     250,
+		SRTLatency:        120,
+		text:              text,
 
-	scanner := bufio.NewScanner(file)
+		FingerprintInterval: 60,
+		TranscribeInterval:  10,
+
+		HLSPushRegion: "us-east-1",
+
+		SNMPCommunity: defaultSNMPCommunity,
+		SNMPOID:       "1.3.6.1.4.1.8072.9999.9999",
+
+		MountDefaults: MountConfig{
+			BurstSize:   128 * 1024,
+			ContentType: "audio/mpeg",
+			Language:    "en",
+			MetaInt:     16000,
+			RetryAfter:  10,
+			IdleTimeout: 60,
+			UDPFormat:   "rtp",
+			RTPTTL:      1,
+			HLSSegment:  6,
+			HLSWindow:   6,
+			DASHSegment: 6,
+			DASHWindow:  6,
+
+			SilenceAction: "disconnect",
+
+			ListenerParams: []string{"burst", "intro", "meta"},
+
+			HeartbeatTimeout: 15,
+			IngestLimit:      4,
+			IngestBurst:      10,
+			ReadSize:         1024,
+			HandoffWarning:   30,
+			ReconnectReserve: true,
+
+			ShapingHeadroom: 25,
+		},
+	}
+
+	var sections, devices, stationSections, services, proxies []*mountSection
+	var current *mountSection
+
+	scanner := bufio.NewScanner(bytes.NewReader(text))
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			fields := strings.Fields(line[1 : len(line)-1])
+			if len(fields) != 2 || (fields[0] != "mount" && fields[0] != "device" && fields[0] != "station" && fields[0] != "nowplaying" && fields[0] != "proxy") {
+				return fmt.Errorf("invalid section %s (expected [mount <name>], [device <name>], [station <name>], [nowplaying <name>] or [proxy <name>])", line)
+			}
+			current = &mountSection{kind: fields[0], name: fields[1]}
+			switch current.kind {
+			case "device":
+				devices = append(devices, current)
+			case "station":
+				stationSections = append(stationSections, current)
+			case "nowplaying":
+				services = append(services, current)
+			case "proxy":
+				proxies = append(proxies, current)
+			default:
+				sections = append(sections, current)
+			}
+			continue
+		}
+
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
 			continue
@@ -50,6 +793,11 @@ func LoadConfig() error {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
+		if current != nil {
+			current.lines = append(current.lines, [2]string{key, value})
+			continue
+		}
+
 		switch key {
 		case "listen":
 			cfg.ListenAddress = value
@@ -57,6 +805,186 @@ func LoadConfig() error {
 			cfg.AuthURL = value
 		case "api_token":
 			cfg.APIToken = value
+		case "shadow_auth_url":
+			cfg.ShadowAuthURL = value
+		case "shadow_api_token":
+			cfg.ShadowAPIToken = value
+		case "webhook_url":
+			cfg.WebhookURL = value
+		case "purge_provider":
+			cfg.PurgeProvider = strings.ToLower(value)
+		case "purge_zone":
+			cfg.PurgeZone = value
+		case "purge_token":
+			cfg.PurgeToken = value
+		case "purge_base":
+			cfg.PurgeBase = nil
+			for _, base := range splitList(value) {
+				cfg.PurgeBase = append(cfg.PurgeBase, strings.TrimRight(base, "/"))
+			}
+		case "hls_push_url":
+			cfg.HLSPushURL = strings.TrimRight(value, "/")
+		case "hls_push_region":
+			cfg.HLSPushRegion = value
+		case "hls_push_access_key":
+			cfg.HLSPushAccessKey = value
+		case "hls_push_secret_key":
+			cfg.HLSPushSecretKey = value
+		case "record_dir":
+			cfg.RecordDir = value
+		case "ffmpeg":
+			cfg.FFmpegPath = value
+		case "crash_dir":
+			cfg.CrashDir = value
+		case "crash_report_url":
+			cfg.CrashReportURL = value
+		case "device_profiles":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for device_profiles (%q): %w", value, err)
+			}
+			cfg.DeviceProfiles = b
+		case "archive":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for archive (%q): %w", value, err)
+			}
+			cfg.Archive = b
+		case "archive_bitrate":
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "k"))
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid value for archive_bitrate (%q)", value)
+			}
+			cfg.ArchiveBitrate = n
+		case "shows_dir":
+			cfg.ShowsDir = value
+		case "upload_max_size":
+			n, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for upload_max_size (%q): %w", value, err)
+			}
+			cfg.UploadMaxSize = n
+		case "memory_budget":
+			n, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for memory_budget (%q): %w", value, err)
+			}
+			cfg.MemoryBudget = n
+		case "tts_command":
+			cfg.TTSCommand = value
+		case "tts_url":
+			cfg.TTSURL = value
+		case "fingerprint_command":
+			cfg.FingerprintCommand = value
+		case "fingerprint_url":
+			cfg.FingerprintURL = value
+		case "transcribe_command":
+			cfg.TranscribeCommand = value
+		case "transcribe_url":
+			cfg.TranscribeURL = value
+		case "policy_script":
+			cfg.PolicyScript = value
+		case "admins":
+			cfg.Admins = splitList(value)
+		case "source_ip":
+			if cfg.SourceIPs == nil {
+				cfg.SourceIPs = make(map[string][]netip.Prefix)
+			}
+			if err := parseSourceIP(cfg.SourceIPs, value); err != nil {
+				return fmt.Errorf("invalid value for source_ip (%q): %w", value, err)
+			}
+		case "max_priority":
+			if cfg.MaxPriorities == nil {
+				cfg.MaxPriorities = make(map[string]int)
+			}
+			if err := parseMaxPriority(cfg.MaxPriorities, value); err != nil {
+				return fmt.Errorf("invalid value for max_priority (%q): %w", value, err)
+			}
+		case "bans":
+			cfg.Bans = splitList(value)
+		case "geoip_db":
+			cfg.GeoIPDB = value
+		case "geoip_header":
+			cfg.GeoIPHeader = value
+		case "geoip_deny_page":
+			cfg.GeoIPDenyPage = value
+		case "locale_dir":
+			cfg.LocaleDir = value
+		case "tls_listen":
+			cfg.TLSListen = value
+		case "tap_socket":
+			cfg.TapSocket = value
+		case "shoutcast_mount":
+			cfg.ShoutcastMount = value
+		case "shoutcast_listen":
+			cfg.ShoutcastListen = value
+		case "srt_listen":
+			cfg.SRTListen = value
+		case "rtmp_listen":
+			cfg.RTMPListen = value
+		case "response_header":
+			h, err := parseResponseHeader(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for response_header (%q): %w", value, err)
+			}
+			cfg.ResponseHeaders = append(cfg.ResponseHeaders, h)
+		case "moved":
+			mv, err := parseMove(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for moved (%q): %w", value, err)
+			}
+			cfg.Moves = append(cfg.Moves, mv)
+		case "snmp_listen":
+			cfg.SNMPListen = value
+		case "snmp_community":
+			if value == "" {
+				return fmt.Errorf("snmp_community must not be empty")
+			}
+			cfg.SNMPCommunity = value
+		case "snmp_allow":
+			cfg.SNMPAllow = splitList(value)
+			if err := checkAddrList(cfg.SNMPAllow); err != nil {
+				return fmt.Errorf("invalid value for snmp_allow: %w", err)
+			}
+		case "snmp_oid":
+			if _, err := snmp.ParseOID(value); err != nil {
+				return err
+			}
+			cfg.SNMPOID = strings.TrimPrefix(value, ".")
+		case "replicate_to":
+			cfg.ReplicateTo = strings.TrimRight(value, "/")
+		case "replication_token":
+			cfg.ReplicationToken = value
+		case "master":
+			cfg.Master = strings.TrimRight(value, "/")
+		case "relay_token":
+			cfg.RelayToken = value
+		case "import_state":
+			cfg.ImportState = value
+		case "store":
+			cfg.Store = value
+		case "tls_cert":
+			cfg.TLSCert = value
+		case "tls_key":
+			cfg.TLSKey = value
+		case "timezone":
+			loc, err := time.LoadLocation(value)
+			if err != nil {
+				return fmt.Errorf("invalid timezone %q: %w", value, err)
+			}
+			cfg.Location = loc
+		case "churn_limit", "churn_max_delay", "churn_ban",
+			"ipv4_prefix", "ipv6_prefix", "max_listeners_per_ip",
+			"upload_max_duration", "policy_timeout", "archive_max_rate",
+			"goroutine_soft_limit", "fd_soft_limit", "srt_latency",
+			"fingerprint_interval", "store_sync", "transcribe_interval":
+			if err := setInt(&cfg, key, value); err != nil {
+				return err
+			}
+		default:
+			if _, err := setMountOption(&cfg.MountDefaults, key, value); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -74,6 +1002,1098 @@ func LoadConfig() error {
 		return fmt.Errorf("api_token must be specified in nickcast.conf")
 	}
 
+	if err := buildStations(&cfg, stationSections); err != nil {
+		return err
+	}
+	if err := buildMounts(&cfg, sections); err != nil {
+		return err
+	}
+	if err := buildDevices(&cfg, devices); err != nil {
+		return err
+	}
+	if err := buildNowPlaying(&cfg, services); err != nil {
+		return err
+	}
+	if err := buildProxies(&cfg, proxies); err != nil {
+		return err
+	}
+	if err := checkMoves(&cfg); err != nil {
+		return err
+	}
+	if err := checkTLS(&cfg); err != nil {
+		return err
+	}
+	if err := checkShoutcast(&cfg); err != nil {
+		return err
+	}
+	if err := checkReplication(&cfg); err != nil {
+		return err
+	}
+	if err := checkMaster(&cfg); err != nil {
+		return err
+	}
+	if err := checkSNMP(&cfg); err != nil {
+		return err
+	}
+	if err := checkPurge(&cfg); err != nil {
+		return err
+	}
+	if err := checkHLSPush(&cfg); err != nil {
+		return err
+	}
+
 	AppConfig = cfg
 	return nil
 }
+
+// checkPurge validates the CDN purge settings.
+func checkPurge(cfg *Config) error {
+	switch cfg.PurgeProvider {
+	case "":
+		return nil
+	case purge.Cloudflare:
+		if cfg.PurgeZone == "" {
+			return fmt.Errorf("purge_provider cloudflare needs purge_zone")
+		}
+	case purge.Fastly:
+	default:
+		return fmt.Errorf("purge_provider must be %s or %s", purge.Cloudflare, purge.Fastly)
+	}
+	if cfg.PurgeToken == "" {
+		return fmt.Errorf("purge_provider needs purge_token")
+	}
+	if len(cfg.PurgeBase) == 0 {
+		return fmt.Errorf("purge_provider needs purge_base, the public URLs to purge under")
+	}
+	for _, base := range cfg.PurgeBase {
+		u, err := url.Parse(base)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("purge_base %q must be an http(s) URL", base)
+		}
+	}
+	return nil
+}
+
+// checkHLSPush validates the HLS origin push settings.
+func checkHLSPush(cfg *Config) error {
+	if cfg.HLSPushURL == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.HLSPushURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("hls_push_url must be an http(s) URL")
+	}
+	if cfg.HLSPushAccessKey == "" || cfg.HLSPushSecretKey == "" {
+		return fmt.Errorf("hls_push_url needs hls_push_access_key and hls_push_secret_key")
+	}
+	if cfg.HLSPushRegion == "" {
+		return fmt.Errorf("hls_push_region must not be empty")
+	}
+	for _, m := range cfg.Mounts {
+		if m.HLS {
+			return nil
+		}
+	}
+	return fmt.Errorf("hls_push_url needs a mount with hls = true")
+}
+
+// checkMoves makes sure moved paths aren't still served, by a mount or by
+// NickCast itself, and that moves to a path here lead to a mount.
+func checkMoves(cfg *Config) error {
+	served := make(map[string]bool)
+	sources := make(map[string]bool)
+	for _, m := range cfg.Mounts {
+		for _, p := range append([]string{m.SourcePath, m.ListenPath}, m.Aliases...) {
+			served[p] = true
+		}
+		sources[m.SourcePath+WSSourcePath] = true
+	}
+	from := make(map[string]bool)
+	for _, mv := range cfg.Moves {
+		if served[mv.From] || sources[mv.From] {
+			return fmt.Errorf("moved: %s is still a mount's path", mv.From)
+		}
+		if ReservedPath(mv.From) {
+			return fmt.Errorf("moved: %s is one of NickCast's own paths", mv.From)
+		}
+		for _, p := range cfg.Proxies {
+			if strings.HasPrefix(mv.From, p.Path) {
+				return fmt.Errorf("moved: %s is under proxy %s", mv.From, p.Name)
+			}
+		}
+		if from[mv.From] {
+			return fmt.Errorf("moved: %s is moved more than once", mv.From)
+		}
+		from[mv.From] = true
+		if path, _, _ := strings.Cut(mv.To, "?"); strings.HasPrefix(mv.To, "/") && !served[path] {
+			return fmt.Errorf("moved: %s moves to %s, which no mount serves", mv.From, mv.To)
+		}
+	}
+	return nil
+}
+
+// checkMaster validates the master/slave relay settings.
+func checkMaster(cfg *Config) error {
+	if cfg.RelayToken != "" && len(cfg.RelayToken) < 16 {
+		return fmt.Errorf("relay_token must be at least 16 characters")
+	}
+	if cfg.Master == "" {
+		return nil
+	}
+	if cfg.RelayToken == "" {
+		return fmt.Errorf("master needs relay_token, the same as the master's")
+	}
+	u, err := url.Parse(cfg.Master)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("master must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+// hasStreamHeaders reports whether contentType is a format whose stream
+// starts with headers a decoder needs, so that audio cut from the middle
+// of it can't be decoded on its own: Ogg and FLAC.
+func hasStreamHeaders(contentType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])) {
+	case "audio/ogg", "application/ogg", "audio/flac":
+		return true
+	}
+	return false
+}
+
+// checkAddrList checks each of list is an address or a CIDR range.
+func checkAddrList(list []string) error {
+	for _, a := range list {
+		if _, err := netip.ParsePrefix(a); err != nil {
+			if _, err := netip.ParseAddr(a); err != nil {
+				return fmt.Errorf("%q is not an address or CIDR range", a)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultSNMPCommunity is the community pollers try first, and anyone
+// scanning for agents to abuse.
+const defaultSNMPCommunity = "public"
+
+// checkSNMP validates the SNMP agent's settings, and binds it to localhost
+// when snmp_listen gives only a port. An agent anyone can poll with the
+// default community answers spoofed requests with bigger replies, so
+// without snmp_allow the community must be changed.
+func checkSNMP(cfg *Config) error {
+	if cfg.SNMPListen == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(cfg.SNMPListen)
+	if err != nil {
+		return fmt.Errorf("snmp_listen must be an address and port, e.g. 127.0.0.1:1161")
+	}
+	if host == "" {
+		cfg.SNMPListen = net.JoinHostPort("127.0.0.1", port)
+	}
+	if len(cfg.SNMPAllow) == 0 && cfg.SNMPCommunity == defaultSNMPCommunity {
+		return fmt.Errorf("snmp_listen needs snmp_allow, or an snmp_community other than %s", defaultSNMPCommunity)
+	}
+	return nil
+}
+
+// checkReplication validates the hot standby settings.
+func checkReplication(cfg *Config) error {
+	if cfg.ReplicationToken != "" && len(cfg.ReplicationToken) < 16 {
+		return fmt.Errorf("replication_token must be at least 16 characters")
+	}
+	if cfg.ReplicateTo == "" {
+		return nil
+	}
+	if cfg.ReplicationToken == "" {
+		return fmt.Errorf("replicate_to needs replication_token, the same on both instances")
+	}
+	u, err := url.Parse(cfg.ReplicateTo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("replicate_to must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+// checkShoutcast validates the SHOUTcast v1 source settings and works out
+// the default address.
+func checkShoutcast(cfg *Config) error {
+	if cfg.ShoutcastMount == "" {
+		return nil
+	}
+	mc := cfg.Mount(cfg.ShoutcastMount)
+	if mc == nil {
+		return fmt.Errorf("shoutcast_mount %s does not exist", cfg.ShoutcastMount)
+	}
+	if len(mc.SourceHeaders) > 0 {
+		return fmt.Errorf("shoutcast_mount %s has source_header set, which SHOUTcast v1 encoders cannot send", mc.Name)
+	}
+	if cfg.ShoutcastListen == "" {
+		host, port, err := net.SplitHostPort(cfg.ListenAddress)
+		n, perr := strconv.Atoi(port)
+		if err != nil || perr != nil {
+			return fmt.Errorf("set shoutcast_listen: cannot work out port+1 from listen = %s", cfg.ListenAddress)
+		}
+		cfg.ShoutcastListen = net.JoinHostPort(host, strconv.Itoa(n+1))
+	}
+	return nil
+}
+
+// setInt parses one of the global integer settings.
+func setInt(cfg *Config, key, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s (%q): %w", key, value, err)
+	}
+	switch key {
+	case "churn_limit":
+		cfg.ChurnLimit = n
+	case "churn_max_delay":
+		cfg.ChurnMaxDelay = n
+	case "churn_ban":
+		cfg.ChurnBan = n
+	case "ipv4_prefix":
+		if n < 0 || n > 32 {
+			return fmt.Errorf("ipv4_prefix must be between 0 and 32")
+		}
+		cfg.IPv4Prefix = n
+	case "ipv6_prefix":
+		if n < 0 || n > 128 {
+			return fmt.Errorf("ipv6_prefix must be between 0 and 128")
+		}
+		cfg.IPv6Prefix = n
+	case "max_listeners_per_ip":
+		cfg.MaxListenersPerIP = n
+	case "upload_max_duration":
+		cfg.UploadMaxDuration = n
+	case "archive_max_rate":
+		cfg.ArchiveMaxRate = n
+	case "goroutine_soft_limit":
+		cfg.GoroutineSoftLimit = n
+	case "fd_soft_limit":
+		cfg.FDSoftLimit = n
+	case "srt_latency":
+		if n <= 0 || n > 0xFFFF {
+			return fmt.Errorf("srt_latency must be between 1 and 65535 milliseconds")
+		}
+		cfg.SRTLatency = n
+	case "policy_timeout":
+		if n <= 0 {
+			return fmt.Errorf("policy_timeout must be positive")
+		}
+		cfg.PolicyTimeout = n
+	case "fingerprint_interval":
+		if n < 10 {
+			return fmt.Errorf("fingerprint_interval must be at least 10 seconds")
+		}
+		cfg.FingerprintInterval = n
+	case "store_sync":
+		if n < 0 {
+			return fmt.Errorf("store_sync must not be negative")
+		}
+		cfg.StoreSync = n
+	case "transcribe_interval":
+		if n < 2 || n > 60 {
+			return fmt.Errorf("transcribe_interval must be between 2 and 60 seconds")
+		}
+		cfg.TranscribeInterval = n
+	}
+	return nil
+}
+
+// setMountOption applies one per-mount key. It reports false for keys that
+// aren't mount settings so callers can decide whether that's an error.
+func setMountOption(m *MountConfig, key, value string) (bool, error) {
+	var err error
+	switch key {
+	case "source_path":
+		m.SourcePath = value
+	case "listen_path":
+		m.ListenPath = value
+	case "aliases":
+		m.Aliases = splitList(value)
+	case "burst_size":
+		m.BurstSize, err = parseSize(value)
+		m.BurstSizeSet = true
+	case "timeshift":
+		m.Timeshift, err = parseSize(value)
+	case "max_listeners":
+		m.MaxListeners, err = strconv.Atoi(value)
+	case "content_type":
+		m.ContentType = value
+	case "language":
+		m.Language = strings.ToLower(value)
+	case "fallback":
+		m.Fallback = value
+	case "listener_auth":
+		m.ListenerAuth, err = strconv.ParseBool(value)
+	case "listener_add_url":
+		m.ListenerAddURL = value
+	case "listener_remove_url":
+		m.ListenerRemoveURL = value
+	case "record":
+		m.Record, err = strconv.ParseBool(value)
+	case "icy_metaint":
+		m.MetaInt, err = strconv.Atoi(value)
+	case "bitrate":
+		m.Bitrate, err = strconv.Atoi(value)
+	case "retry_after":
+		m.RetryAfter, err = strconv.Atoi(value)
+	case "keepalive_timeout":
+		m.KeepAlive, err = strconv.Atoi(value)
+	case "listener_idle_timeout":
+		m.IdleTimeout, err = strconv.Atoi(value)
+	case "shaping":
+		m.Shaping, err = strconv.ParseBool(value)
+	case "shaping_headroom":
+		m.ShapingHeadroom, err = strconv.Atoi(value)
+		if err == nil && m.ShapingHeadroom < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	case "dead_air_timeout":
+		m.DeadAirTimeout, err = strconv.Atoi(value)
+	case "intro_file":
+		m.IntroFile = value
+	case "offline_file":
+		m.OfflineFile = value
+	case "listener_params":
+		m.ListenerParams = splitList(value)
+		for _, p := range m.ListenerParams {
+			if p != "burst" && p != "intro" && p != "meta" {
+				err = fmt.Errorf("unknown parameter %s", p)
+			}
+		}
+	case "dead_air_file":
+		m.DeadAirFile = value
+	case "silence_timeout":
+		m.SilenceTimeout, err = strconv.Atoi(value)
+		if err == nil && m.SilenceTimeout < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	case "silence_action":
+		if value != "disconnect" && value != "alert" {
+			err = fmt.Errorf("must be disconnect or alert")
+		}
+		m.SilenceAction = value
+	case "max_drift":
+		m.MaxDrift, err = strconv.Atoi(value)
+	case "ingest_limit":
+		m.IngestLimit, err = strconv.ParseFloat(value, 64)
+		if err == nil && (m.IngestLimit < 0 || m.IngestLimit > 0 && m.IngestLimit < 1) {
+			err = fmt.Errorf("must be 0 (no limit) or at least 1, real time")
+		}
+	case "ingest_burst":
+		m.IngestBurst, err = strconv.Atoi(value)
+		if err == nil && m.IngestBurst < 1 {
+			err = fmt.Errorf("must be at least 1")
+		}
+	case "read_size":
+		m.ReadSize, err = parseSize(value)
+		if err == nil && (m.ReadSize < 512 || m.ReadSize > 1<<20) {
+			err = fmt.Errorf("must be between 512 bytes and 1M")
+		}
+	case "ingest_timeout":
+		m.IngestTimeout, err = strconv.Atoi(value)
+		if err == nil && m.IngestTimeout < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	case "ingest_min_bytes":
+		m.IngestMinBytes, err = parseSize(value)
+	case "silence_fill":
+		m.SilenceFill, err = strconv.Atoi(value)
+	case "reconnect_grace":
+		m.ReconnectGrace, err = strconv.Atoi(value)
+	case "reconnect_reserve":
+		m.ReconnectReserve, err = strconv.ParseBool(value)
+	case "heartbeat_timeout":
+		m.HeartbeatTimeout, err = strconv.Atoi(value)
+	case "takeover":
+		m.Takeover, err = strconv.ParseBool(value)
+	case "handoff":
+		m.Handoff, err = strconv.ParseBool(value)
+	case "handoff_warning":
+		m.HandoffWarning, err = strconv.Atoi(value)
+		if err == nil && m.HandoffWarning < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	case "autodj_dir":
+		m.AutoDJDir = value
+	case "mono_bitrate":
+		m.MonoBitrate, err = strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "k"))
+		if err == nil && m.MonoBitrate != 0 && (m.MonoBitrate < 8 || m.MonoBitrate > 128) {
+			err = fmt.Errorf("must be 0 (off) or between 8 and 128 kbit/s")
+		}
+	case "udp_listen":
+		m.UDPListen = value
+	case "udp_format":
+		if value != "rtp" && value != "raw" {
+			err = fmt.Errorf("must be rtp or raw")
+		}
+		m.UDPFormat = value
+	case "udp_allow":
+		m.UDPAllow = splitList(value)
+		err = checkAddrList(m.UDPAllow)
+	case "udp_account":
+		m.UDPAccount = value
+	case "rtp_output":
+		if _, perr := netip.ParseAddrPort(value); perr != nil && value != "" {
+			err = fmt.Errorf("must be an address and port, e.g. 239.1.1.2:5004")
+		}
+		m.RTPOutput = value
+	case "rtp_ttl":
+		m.RTPTTL, err = strconv.Atoi(value)
+		if err == nil && (m.RTPTTL < 1 || m.RTPTTL > 255) {
+			err = fmt.Errorf("must be between 1 and 255")
+		}
+	case "hls":
+		m.HLS, err = strconv.ParseBool(value)
+	case "hls_segment":
+		m.HLSSegment, err = strconv.Atoi(value)
+		if err == nil && (m.HLSSegment < 1 || m.HLSSegment > 30) {
+			err = fmt.Errorf("must be between 1 and 30 seconds")
+		}
+	case "hls_window":
+		m.HLSWindow, err = strconv.Atoi(value)
+		if err == nil && (m.HLSWindow < 3 || m.HLSWindow > 100) {
+			err = fmt.Errorf("must be between 3 and 100 segments")
+		}
+	case "dash":
+		m.DASH, err = strconv.ParseBool(value)
+	case "transcribe":
+		m.Transcribe, err = strconv.ParseBool(value)
+	case "dash_segment":
+		m.DASHSegment, err = strconv.Atoi(value)
+		if err == nil && (m.DASHSegment < 1 || m.DASHSegment > 30) {
+			err = fmt.Errorf("must be between 1 and 30 seconds")
+		}
+	case "dash_window":
+		m.DASHWindow, err = strconv.Atoi(value)
+		if err == nil && (m.DASHWindow < 3 || m.DASHWindow > 100) {
+			err = fmt.Errorf("must be between 3 and 100 segments")
+		}
+	case "relay":
+		var u *url.URL
+		if u, err = url.Parse(value); err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = fmt.Errorf("must be an http:// or https:// URL")
+		}
+		m.Relay = value
+	case "allow_countries":
+		m.AllowCountries = splitList(value)
+	case "deny_countries":
+		m.DenyCountries = splitList(value)
+	case "windows":
+		m.Windows, err = schedule.ParseWindows(value)
+	case "book":
+		var b Booking
+		if b, err = parseBooking(value); err == nil {
+			m.Bookings = append(m.Bookings, b)
+		}
+	case "booked_only":
+		m.BookedOnly, err = strconv.ParseBool(value)
+	case "source_header":
+		if i := strings.Index(value, ":"); i <= 0 || strings.TrimSpace(value[i+1:]) == "" {
+			err = fmt.Errorf("must look like Name: value")
+		} else {
+			m.SourceHeaders = append(m.SourceHeaders, value)
+		}
+	case "variant":
+		var v Variant
+		if v, err = parseVariant(value); err == nil {
+			m.Variants = append(m.Variants, v)
+		}
+	case "title_filter":
+		var f metadata.Filter
+		if f, err = metadata.ParseFilter(value); err == nil {
+			m.TitleFilters = append(m.TitleFilters, f)
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("invalid value for %s (%q): %w", key, value, err)
+	}
+	return true, nil
+}
+
+// checkVariants makes sure a mount's variants have distinct names, leave
+// it no more than 100% of its listeners to give away and don't send any
+// back to the mount itself.
+func checkVariants(m *MountConfig) error {
+	total := 0
+	seen := make(map[string]bool)
+	for _, v := range m.Variants {
+		if seen[v.Name] {
+			return fmt.Errorf("variant %s is defined more than once", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Target == m.ListenPath {
+			return fmt.Errorf("variant %s sends listeners back to the mount", v.Name)
+		}
+		total += v.Weight
+	}
+	if total > 100 {
+		return fmt.Errorf("variant weights add up to %d%%", total)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// parseSize accepts plain byte counts or a K/M/G suffix (e.g. 256K).
+func parseSize(value string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(value, "K"), strings.HasSuffix(value, "k"):
+		mult = 1024
+	case strings.HasSuffix(value, "M"), strings.HasSuffix(value, "m"):
+		mult = 1024 * 1024
+	case strings.HasSuffix(value, "G"), strings.HasSuffix(value, "g"):
+		mult = 1024 * 1024 * 1024
+	}
+	if mult != 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return n * mult, nil
+}
+
+// cssColor matches the colors player_accent takes: hex or a named color.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// buildStations sets up the default station from the global settings plus
+// any [station] sections. A [station default] section may add branding or
+// override the global backend for the default station.
+func buildStations(cfg *Config, sections []*mountSection) error {
+	cfg.Stations = []Station{{
+		Name:      DefaultStationName,
+		AuthURL:   cfg.AuthURL,
+		APIToken:  cfg.APIToken,
+		Admins:    cfg.Admins,
+		SourceIPs: cfg.SourceIPs,
+
+		MaxPriorities: cfg.MaxPriorities,
+
+		ShadowAuthURL:  cfg.ShadowAuthURL,
+		ShadowAPIToken: cfg.ShadowAPIToken,
+	}}
+	for _, sec := range sections {
+		st := cfg.Station(sec.name)
+		if st == nil {
+			if strings.Contains(sec.name, "/") {
+				return fmt.Errorf("station %s: name must not contain /", sec.name)
+			}
+			cfg.Stations = append(cfg.Stations, Station{Name: sec.name})
+			st = &cfg.Stations[len(cfg.Stations)-1]
+		} else if sec.name != DefaultStationName {
+			return fmt.Errorf("station %s is defined more than once", sec.name)
+		}
+		// A station's own source_ip and max_priority lines replace the
+		// global ones.
+		ownSourceIPs, ownPriorities := false, false
+		for _, kv := range sec.lines {
+			switch kv[0] {
+			case "auth_url":
+				st.AuthURL = kv[1]
+			case "api_token":
+				st.APIToken = kv[1]
+			case "shadow_auth_url":
+				st.ShadowAuthURL = kv[1]
+			case "shadow_api_token":
+				st.ShadowAPIToken = kv[1]
+			case "admins":
+				st.Admins = splitList(kv[1])
+			case "source_ip":
+				if st.SourceIPs == nil || !ownSourceIPs {
+					st.SourceIPs = make(map[string][]netip.Prefix)
+					ownSourceIPs = true
+				}
+				if err := parseSourceIP(st.SourceIPs, kv[1]); err != nil {
+					return fmt.Errorf("station %s: invalid source_ip %q: %w", sec.name, kv[1], err)
+				}
+			case "max_priority":
+				if st.MaxPriorities == nil || !ownPriorities {
+					st.MaxPriorities = make(map[string]int)
+					ownPriorities = true
+				}
+				if err := parseMaxPriority(st.MaxPriorities, kv[1]); err != nil {
+					return fmt.Errorf("station %s: invalid max_priority %q: %w", sec.name, kv[1], err)
+				}
+			case "title":
+				st.Title = kv[1]
+			case "description":
+				st.Description = kv[1]
+			case "url":
+				st.URL = kv[1]
+			case "genre":
+				st.Genre = kv[1]
+			case "player_accent":
+				if !cssColor.MatchString(kv[1]) {
+					return fmt.Errorf("station %s: player_accent must be a CSS color such as #6a1b9a or purple, not %q", sec.name, kv[1])
+				}
+				st.PlayerAccent = kv[1]
+			case "player_logo":
+				st.PlayerLogo = kv[1]
+			case "player_template":
+				st.PlayerTemplate = kv[1]
+			case "hosts":
+				st.Hosts = nil
+				for _, h := range splitList(kv[1]) {
+					st.Hosts = append(st.Hosts, strings.ToLower(h))
+				}
+			case "tls_cert":
+				st.TLSCert = kv[1]
+			case "tls_key":
+				st.TLSKey = kv[1]
+			case "max_listeners", "max_bandwidth", "max_mounts":
+				n, err := strconv.Atoi(kv[1])
+				if err != nil || n < 0 {
+					return fmt.Errorf("station %s: invalid %s %q", sec.name, kv[0], kv[1])
+				}
+				switch kv[0] {
+				case "max_listeners":
+					st.MaxListeners = n
+				case "max_bandwidth":
+					st.MaxBandwidth = n
+				default:
+					st.MaxMounts = n
+				}
+			case "max_record_disk":
+				n, err := parseSize(kv[1])
+				if err != nil {
+					return fmt.Errorf("station %s: invalid max_record_disk %q", sec.name, kv[1])
+				}
+				st.MaxRecordDisk = int64(n)
+			default:
+				return fmt.Errorf("station %s: unknown setting %s", sec.name, kv[0])
+			}
+		}
+		if st.AuthURL == "" || st.APIToken == "" {
+			return fmt.Errorf("station %s: auth_url and api_token must be set", sec.name)
+		}
+	}
+	for _, st := range cfg.Stations {
+		if (st.ShadowAuthURL == "") != (st.ShadowAPIToken == "") {
+			return fmt.Errorf("station %s: shadow_auth_url and shadow_api_token go together", st.Name)
+		}
+	}
+	return nil
+}
+
+// parseSourceIP adds a "nick address, address..." source_ip line to ips.
+// Addresses may be CIDR ranges; a bare address stands for itself.
+func parseSourceIP(ips map[string][]netip.Prefix, value string) error {
+	nick, list, ok := strings.Cut(value, " ")
+	if !ok || strings.TrimSpace(list) == "" {
+		return fmt.Errorf("must look like <nick> <address or range>, ...")
+	}
+	for _, a := range splitList(list) {
+		p, err := netip.ParsePrefix(a)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(a)
+			if aerr != nil {
+				return fmt.Errorf("%q is not an address or CIDR range", a)
+			}
+			addr = addr.Unmap()
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		ips[nick] = append(ips[nick], p.Masked())
+	}
+	return nil
+}
+
+// parseMaxPriority adds a "nick priority" max_priority line to caps.
+func parseMaxPriority(caps map[string]int, value string) error {
+	nick, n, ok := strings.Cut(value, " ")
+	if !ok {
+		return fmt.Errorf("must look like <nick> <priority>")
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || max < 0 {
+		return fmt.Errorf("%q is not a whole number, 0 or more", strings.TrimSpace(n))
+	}
+	caps[nick] = max
+	return nil
+}
+
+// downmixMount returns the mono copy of m that mono_bitrate asks for. It
+// has m's listener rules, but none of its sources, files or recording.
+func downmixMount(cfg *Config, m MountConfig) MountConfig {
+	d := cfg.MountDefaults
+	d.Name = m.Name + "-mono"
+	d.Station = m.Station
+	d.SourcePath, d.ListenPath = m.SourcePath+"-mono", m.ListenPath+"-mono"
+	d.Aliases = nil
+	d.ContentType = "audio/mpeg"
+	d.Bitrate = m.MonoBitrate
+	d.DownmixOf = m.Name
+	d.MonoBitrate = 0
+
+	d.MaxListeners = m.MaxListeners
+	d.ListenerAuth = m.ListenerAuth
+	d.AllowCountries, d.DenyCountries = m.AllowCountries, m.DenyCountries
+	d.Windows = m.Windows
+	d.TitleFilters = nil
+
+	d.Record = false
+	d.Fallback = ""
+	d.IntroFile, d.OfflineFile, d.DeadAirFile = "", "", ""
+	d.AutoDJDir, d.Relay, d.UDPListen, d.RTPOutput = "", "", "", ""
+	d.Variants = nil
+	d.Bookings, d.BookedOnly = nil, false
+	return d
+}
+
+// checkTLS validates the HTTPS settings: every certificate needs its key,
+// certificates need somewhere to be served, and a host can only belong to
+// one station.
+func checkTLS(cfg *Config) error {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	hasCert := cfg.TLSCert != ""
+	owners := make(map[string]string)
+	for _, st := range cfg.Stations {
+		if (st.TLSCert == "") != (st.TLSKey == "") {
+			return fmt.Errorf("station %s: tls_cert and tls_key must be set together", st.Name)
+		}
+		if st.TLSCert != "" {
+			if len(st.Hosts) == 0 {
+				return fmt.Errorf("station %s: tls_cert needs hosts to serve it for", st.Name)
+			}
+			hasCert = true
+		}
+		for _, h := range st.Hosts {
+			if other, dup := owners[h]; dup {
+				return fmt.Errorf("station %s: host %s already belongs to station %s", st.Name, h, other)
+			}
+			owners[h] = st.Name
+		}
+	}
+	if hasCert && cfg.TLSListen == "" {
+		return fmt.Errorf("tls_listen must be set to serve TLS certificates")
+	}
+	if cfg.TLSListen != "" && !hasCert {
+		return fmt.Errorf("tls_listen needs tls_cert and tls_key, or a station with its own")
+	}
+	return nil
+}
+
+// buildDevices turns [device] sections into listener profiles, in the order
+// they appear, falling back to DefaultDevices if there are none and
+// device_profiles is set.
+func buildDevices(cfg *Config, sections []*mountSection) error {
+	if len(sections) == 0 {
+		if cfg.DeviceProfiles {
+			cfg.Devices = DefaultDevices
+		}
+		return nil
+	}
+	for _, sec := range sections {
+		d := DeviceProfile{Name: sec.name}
+		for _, kv := range sec.lines {
+			var err error
+			switch kv[0] {
+			case "match":
+				d.Match = splitList(kv[1])
+			case "burst_size":
+				d.BurstSize, err = parseSize(kv[1])
+			case "keepalive_timeout":
+				d.KeepAlive, err = strconv.Atoi(kv[1])
+			default:
+				return fmt.Errorf("device %s: unknown setting %s", sec.name, kv[0])
+			}
+			if err != nil {
+				return fmt.Errorf("device %s: invalid value for %s (%q): %w", sec.name, kv[0], kv[1], err)
+			}
+		}
+		if len(d.Match) == 0 {
+			return fmt.Errorf("device %s: match must list at least one User-Agent substring", sec.name)
+		}
+		cfg.Devices = append(cfg.Devices, d)
+	}
+	return nil
+}
+
+// buildProxies turns [proxy] sections into reverse-proxy routes. Their
+// paths must stay clear of the mounts' and NickCast's own, so this runs
+// after buildMounts.
+func buildProxies(cfg *Config, sections []*mountSection) error {
+	for _, sec := range sections {
+		p := Proxy{Name: sec.name, StripPath: true}
+		for _, kv := range sec.lines {
+			var err error
+			switch kv[0] {
+			case "path":
+				p.Path = kv[1]
+			case "target":
+				p.Target = kv[1]
+			case "strip_path":
+				p.StripPath, err = strconv.ParseBool(kv[1])
+			default:
+				return fmt.Errorf("proxy %s: unknown setting %s", sec.name, kv[0])
+			}
+			if err != nil {
+				return fmt.Errorf("proxy %s: invalid value for %s (%q): %w", sec.name, kv[0], kv[1], err)
+			}
+		}
+		if !strings.HasPrefix(p.Path, "/") || p.Path == "/" {
+			return fmt.Errorf("proxy %s: path must start with / and name a directory, such as /chat", sec.name)
+		}
+		if !strings.HasSuffix(p.Path, "/") {
+			p.Path += "/"
+		}
+		u, err := url.Parse(p.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("proxy %s: target must be an http:// or https:// URL", sec.name)
+		}
+		covers := func(path string) bool {
+			return path+"/" == p.Path || strings.HasPrefix(path, p.Path) || strings.HasPrefix(p.Path, path+"/")
+		}
+		for _, r := range reservedPaths {
+			if covers(r) {
+				return fmt.Errorf("proxy %s: path %s overlaps NickCast's own %s", sec.name, p.Path, r)
+			}
+		}
+		for _, m := range cfg.Mounts {
+			for _, path := range append([]string{m.SourcePath, m.ListenPath}, m.Aliases...) {
+				if covers(path) {
+					return fmt.Errorf("proxy %s: path %s overlaps mount %s (%s)", sec.name, p.Path, m.Name, path)
+				}
+			}
+		}
+		for _, other := range cfg.Proxies {
+			if other.Path == p.Path {
+				return fmt.Errorf("proxy %s: path %s is already used by proxy %s", sec.name, p.Path, other.Name)
+			}
+		}
+		cfg.Proxies = append(cfg.Proxies, p)
+	}
+	return nil
+}
+
+// buildNowPlaying turns [nowplaying] sections into playlist services. The
+// mounts they report must exist, so this runs after buildMounts.
+func buildNowPlaying(cfg *Config, sections []*mountSection) error {
+	for _, sec := range sections {
+		s := nowplaying.Service{
+			Name:        sec.name,
+			Method:      "POST",
+			ContentType: "application/json",
+			Retries:     3,
+		}
+		url, body := "", nowplaying.DefaultTemplate
+		for _, kv := range sec.lines {
+			switch kv[0] {
+			case "url":
+				url = kv[1]
+			case "method":
+				s.Method = strings.ToUpper(kv[1])
+			case "content_type":
+				s.ContentType = kv[1]
+			case "template":
+				body = kv[1]
+			case "template_file":
+				b, err := os.ReadFile(kv[1])
+				if err != nil {
+					return fmt.Errorf("nowplaying %s: %w", sec.name, err)
+				}
+				body = string(b)
+			case "user":
+				s.User = kv[1]
+			case "password":
+				s.Password = kv[1]
+			case "token":
+				s.Token = kv[1]
+			case "header":
+				if !strings.Contains(kv[1], ":") {
+					return fmt.Errorf("nowplaying %s: header %q must look like Name: value", sec.name, kv[1])
+				}
+				s.Headers = append(s.Headers, kv[1])
+			case "mounts":
+				s.Mounts = splitList(kv[1])
+			case "retries":
+				n, err := strconv.Atoi(kv[1])
+				if err != nil || n < 0 {
+					return fmt.Errorf("nowplaying %s: invalid retries %q", sec.name, kv[1])
+				}
+				s.Retries = n
+			default:
+				return fmt.Errorf("nowplaying %s: unknown setting %s", sec.name, kv[0])
+			}
+		}
+		if url == "" {
+			return fmt.Errorf("nowplaying %s: url must be set", sec.name)
+		}
+		var err error
+		if s.URL, err = nowplaying.ParseTemplate("url", url); err != nil {
+			return fmt.Errorf("nowplaying %s: invalid url: %w", sec.name, err)
+		}
+		if s.Body, err = nowplaying.ParseTemplate("template", body); err != nil {
+			return fmt.Errorf("nowplaying %s: invalid template: %w", sec.name, err)
+		}
+		for _, m := range s.Mounts {
+			if cfg.Mount(m) == nil {
+				return fmt.Errorf("nowplaying %s: mount %s does not exist", sec.name, m)
+			}
+		}
+		cfg.NowPlaying = append(cfg.NowPlaying, s)
+	}
+	return nil
+}
+
+// buildMounts turns the global defaults plus each [mount] section into the
+// final mount list. The default mount always exists, even with no sections.
+func buildMounts(cfg *Config, sections []*mountSection) error {
+	hasDefault := false
+	for _, sec := range sections {
+		if sec.name == DefaultMountName {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		sections = append([]*mountSection{{name: DefaultMountName}}, sections...)
+	}
+
+	seenPaths := make(map[string]string)
+	for _, sec := range sections {
+		if cfg.Mount(sec.name) != nil {
+			return fmt.Errorf("mount %s is defined more than once", sec.name)
+		}
+
+		m := cfg.MountDefaults
+		m.Name = sec.name
+		m.Station = DefaultStationName
+		// Mounts of other stations live under /<station>/, so every
+		// station gets the same familiar /stream and /listen layout.
+		prefix, local := "", sec.name
+		if i := strings.Index(sec.name, "/"); i >= 0 {
+			m.Station, local = sec.name[:i], sec.name[i+1:]
+			if cfg.Station(m.Station) == nil {
+				return fmt.Errorf("mount %s: station %s is not defined", sec.name, m.Station)
+			}
+			if local == "" || strings.Contains(local, "/") {
+				return fmt.Errorf("mount %s: expected <station>/<mount>", sec.name)
+			}
+			if m.Station != DefaultStationName {
+				prefix = "/" + m.Station
+			}
+		}
+		if local == DefaultMountName {
+			m.SourcePath, m.ListenPath = prefix+"/stream", prefix+"/listen"
+		} else {
+			m.SourcePath, m.ListenPath = prefix+"/stream/"+local, prefix+"/listen/"+local
+		}
+
+		for _, kv := range sec.lines {
+			switch kv[0] {
+			case "title_filter":
+				m.TitleFilters = nil
+			case "source_header":
+				m.SourceHeaders = nil
+			case "variant":
+				m.Variants = nil
+			}
+		}
+		for _, kv := range sec.lines {
+			ok, err := setMountOption(&m, kv[0], kv[1])
+			if err != nil {
+				return fmt.Errorf("mount %s: %w", sec.name, err)
+			}
+			if !ok {
+				return fmt.Errorf("mount %s: unknown setting %s", sec.name, kv[0])
+			}
+		}
+
+		if m.Fallback == m.Name {
+			// A global fallback shouldn't make its own target fall back to itself.
+			m.Fallback = ""
+		}
+		if err := checkVariants(&m); err != nil {
+			return fmt.Errorf("mount %s: %w", m.Name, err)
+		}
+		if m.HLS && (m.ListenerAuth || m.ListenerAddURL != "") {
+			return fmt.Errorf("mount %s: hls segments are fetched without credentials, so it can't be used with listener_auth or listener_add_url", m.Name)
+		}
+		if m.DASH && (m.ListenerAuth || m.ListenerAddURL != "") {
+			return fmt.Errorf("mount %s: dash segments are fetched without credentials, so it can't be used with listener_auth or listener_add_url", m.Name)
+		}
+		if m.Transcribe && cfg.TranscribeCommand == "" && cfg.TranscribeURL == "" {
+			return fmt.Errorf("mount %s: transcribe needs transcribe_command or transcribe_url", m.Name)
+		}
+		if m.Transcribe && (m.ListenerAuth || m.ListenerAddURL != "") {
+			return fmt.Errorf("mount %s: captions are served without credentials, so transcribe can't be used with listener_auth or listener_add_url", m.Name)
+		}
+		if m.Transcribe && hasStreamHeaders(m.ContentType) {
+			return fmt.Errorf("mount %s: transcribe can't be used with %s, as only the start of the stream has the headers the transcriber would need for each piece", m.Name, m.ContentType)
+		}
+		if m.IngestMinBytes > 0 && m.IngestTimeout == 0 {
+			return fmt.Errorf("mount %s: ingest_min_bytes is counted over ingest_timeout, so it needs ingest_timeout set", m.Name)
+		}
+		if m.UDPListen != "" && (m.UDPAccount == "" || len(m.UDPAllow) == 0) {
+			return fmt.Errorf("mount %s: udp_listen needs udp_account to broadcast as and udp_allow to say who may send", m.Name)
+		}
+
+		paths := append([]string{m.SourcePath, m.ListenPath}, m.Aliases...)
+		for _, p := range paths {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("mount %s: path %q must start with /", m.Name, p)
+			}
+			if other, dup := seenPaths[p]; dup {
+				return fmt.Errorf("mount %s: path %s is already used by mount %s", m.Name, p, other)
+			}
+			seenPaths[p] = m.Name
+		}
+
+		cfg.Mounts = append(cfg.Mounts, m)
+	}
+
+	for _, m := range cfg.Mounts {
+		if m.MonoBitrate == 0 {
+			continue
+		}
+		if cfg.FFmpegPath == "" {
+			return fmt.Errorf("mount %s: mono_bitrate needs ffmpeg to be set", m.Name)
+		}
+		d := downmixMount(cfg, m)
+		if cfg.Mount(d.Name) != nil {
+			return fmt.Errorf("mount %s: mono_bitrate would add mount %s, which already exists", m.Name, d.Name)
+		}
+		for _, p := range []string{d.SourcePath, d.ListenPath} {
+			if other, dup := seenPaths[p]; dup {
+				return fmt.Errorf("mount %s: mono_bitrate would add path %s, which mount %s uses", m.Name, p, other)
+			}
+			seenPaths[p] = d.Name
+		}
+		cfg.Mounts = append(cfg.Mounts, d)
+	}
+
+	perStation := make(map[string]int)
+	for _, m := range cfg.Mounts {
+		if m.Fallback != "" && cfg.Mount(m.Fallback) == nil {
+			return fmt.Errorf("mount %s: fallback mount %s does not exist", m.Name, m.Fallback)
+		}
+		perStation[m.Station]++
+	}
+	for _, st := range cfg.Stations {
+		if st.MaxMounts > 0 && perStation[st.Name] > st.MaxMounts {
+			return fmt.Errorf("station %s has %d mounts but max_mounts is %d", st.Name, perStation[st.Name], st.MaxMounts)
+		}
+	}
+	return nil
+}
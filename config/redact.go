@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// redactedValue replaces secrets in Redacted output.
+const redactedValue = "<redacted>"
+
+// Redacted writes the config file as it was loaded with its secrets
+// masked, so it can be shared in a bug report: values of keys that name a
+// secret (api_token, password, token, ...), the value half of header
+// lines, passwords in URLs and URL query parameters that look like
+// credentials. Comments are left out, since people paste secrets into
+// them too.
+func (c *Config) Redacted(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	scanner := bufio.NewScanner(bytes.NewReader(c.text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 && !strings.HasPrefix(line, "[") {
+			key := strings.TrimSpace(parts[0])
+			line = key + " = " + redactValue(key, strings.TrimSpace(parts[1]))
+		}
+		bw.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func redactValue(key, value string) string {
+	switch {
+	case value == "":
+		return value
+	case secretKey(key):
+		return redactedValue
+	case key == "header" || key == "source_header":
+		// "Name: value"; the name is useful, the value often a credential.
+		if i := strings.Index(value, ":"); i >= 0 {
+			return value[:i+1] + " " + redactedValue
+		}
+		return redactedValue
+	case strings.Contains(value, "://"):
+		return redactURL(value)
+	}
+	return value
+}
+
+// redactURL masks the password and credential-like query parameters of a
+// URL, leaving anything that doesn't parse alone.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return value
+	}
+	if q := u.Query(); len(q) > 0 {
+		for name := range q {
+			if secretParam(name) {
+				// Masked the way URL.Redacted masks passwords, which
+				// survives query escaping.
+				q.Set(name, "xxxxx")
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// secretKey reports whether a config key holds a credential.
+func secretKey(key string) bool {
+	return strings.Contains(key, "token") || strings.Contains(key, "password") || strings.Contains(key, "secret")
+}
+
+// secretParam reports whether a URL query parameter looks like it carries
+// a credential. Gateways name them all sorts of things, so this errs on
+// the side of masking.
+func secretParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"token", "secret", "pass", "key", "auth", "sig"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package NickServAuth
 import (
     "bytes"
     "encoding/json"
+    "errors"
     "fmt"
     "net/http"
     "time"
@@ -26,6 +27,10 @@ func NewAuthClient(apiURL, token string) *AuthClient {
     }
 }
 
+// ErrRejected is wrapped by the error Authenticate returns when the backend
+// turned the credentials down with a reason, as opposed to failing to answer.
+var ErrRejected = errors.New("NickServ authentication failed")
+
 type AuthRequest struct {
     AccountName string `json:"accountName"`
     Passphrase  string `json:"passphrase"`
@@ -72,7 +77,7 @@ func (a *AuthClient) Authenticate(accountName, passphrase string) (bool, error)
     }
 
     if !authResp.Success && authResp.Message != "" {
-        return false, fmt.Errorf("NickServ authentication failed: %s", authResp.Message)
+        return false, fmt.Errorf("%w: %s", ErrRejected, authResp.Message)
     }
 
     return authResp.Success, nil
@@ -0,0 +1,94 @@
+// Package aac finds AAC frames in an ADTS stream, the way NickCast's
+// sources send AAC. Like package mp3, it reads frame headers only and
+// never decodes audio.
+package aac
+
+import "time"
+
+const (
+	// HeaderSize is the length of an ADTS header without its CRC.
+	HeaderSize = 7
+
+	// maxPending bounds the unparsed data carried between writes; an ADTS
+	// frame is at most 8191 bytes.
+	maxPending = 8192
+)
+
+var sampleRates = [16]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// Header is a decoded ADTS frame header.
+type Header struct {
+	ObjectType int // MPEG-4 audio object type; 2 is AAC-LC
+	RateIndex  int // sampling frequency index, as in the header
+	SampleRate int // Hz
+	Channels   int // channel configuration; 0 means it is given in-band
+	FrameSize  int // bytes, including the header
+	HeaderSize int // bytes before the raw audio: 7, or 9 with a CRC
+	Samples    int // samples per channel in the frame
+}
+
+// Duration is how much audio one frame carries.
+func (h Header) Duration() time.Duration {
+	return time.Duration(h.Samples) * time.Second / time.Duration(h.SampleRate)
+}
+
+// Config returns the AudioSpecificConfig describing frames like this one,
+// which MP4 and DASH players are given in place of ADTS headers.
+func (h Header) Config() []byte {
+	return []byte{
+		byte(h.ObjectType<<3 | h.RateIndex>>1),
+		byte(h.RateIndex&1<<7 | h.Channels<<3),
+	}
+}
+
+// ParseHeader decodes the ADTS header at the start of b.
+func ParseHeader(b []byte) (Header, bool) {
+	if len(b) < HeaderSize || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return Header{}, false
+	}
+	h := Header{
+		ObjectType: int(b[2]>>6) + 1,
+		RateIndex:  int(b[2]>>2) & 0x0F,
+		Channels:   int(b[2]&0x01)<<2 | int(b[3]>>6),
+		FrameSize:  int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5,
+		HeaderSize: HeaderSize,
+		Samples:    1024 * (int(b[6]&0x03) + 1),
+	}
+	if b[1]&0x01 == 0 {
+		h.HeaderSize += 2 // CRC
+	}
+	h.SampleRate = sampleRates[h.RateIndex]
+	if h.SampleRate == 0 || h.FrameSize < h.HeaderSize {
+		return Header{}, false
+	}
+	return h, true
+}
+
+// Splitter finds ADTS frames in a stream arriving in arbitrary chunks, as
+// mp3.Analyzer does for MPEG audio. It is not safe for concurrent use.
+type Splitter struct {
+	pending []byte
+}
+
+// Feed calls fn with each complete frame in p, which is only valid until
+// fn returns. Data that isn't part of a frame is dropped.
+func (s *Splitter) Feed(p []byte, fn func(frame []byte, h Header)) {
+	s.pending = append(s.pending, p...)
+	buf := s.pending
+	for len(buf) >= HeaderSize {
+		h, ok := ParseHeader(buf)
+		if !ok {
+			buf = buf[1:]
+			continue
+		}
+		if h.FrameSize > len(buf) {
+			break // frame continues in the next chunk
+		}
+		fn(buf[:h.FrameSize], h)
+		buf = buf[h.FrameSize:]
+	}
+	if len(buf) > maxPending {
+		buf = buf[len(buf)-maxPending:]
+	}
+	s.pending = append(s.pending[:0], buf...)
+}
@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheDirName is the subdirectory of the recordings directory that holds
+// transcoded copies and other derived files.
+const CacheDirName = ".cache"
+
+// Entry is one recording in the archive.
+type Entry struct {
+	ID       string    `json:"id"`
+	Format   string    `json:"format"` // file extension without the dot
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Sidecar  *Sidecar  `json:"sidecar,omitempty"`
+	Peaks    bool      `json:"peaks"`    // waveform peaks have been generated
+	Captions bool      `json:"captions"` // a transcript was made while it was on air
+}
+
+// audioExts are the recording extensions the archive recognises.
+var audioExts = map[string]bool{".mp3": true, ".ogg": true, ".aac": true, ".flac": true, ".opus": true}
+
+// List returns every recording in dir, newest first.
+func List(dir string) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || !audioExts[ext] {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		e := Entry{
+			ID:       strings.TrimSuffix(f.Name(), ext),
+			Format:   ext[1:],
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		}
+		if sc, err := ReadSidecar(filepath.Join(dir, f.Name())); err == nil {
+			e.Sidecar = sc
+		}
+		if _, err := os.Stat(PeaksPath(filepath.Join(dir, CacheDirName), e.ID)); err == nil {
+			e.Peaks = true
+		}
+		if _, err := os.Stat(CaptionsPath(filepath.Join(dir, f.Name()))); err == nil {
+			e.Captions = true
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Modified.After(entries[j].Modified)
+	})
+	return entries, nil
+}
+
+// Find returns the path of the original recording with the given ID.
+func Find(dir, id string) (string, bool) {
+	if !ValidID(id) {
+		return "", false
+	}
+	for ext := range audioExts {
+		p := filepath.Join(dir, id+ext)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// ValidID rejects anything that could escape the archive directory.
+func ValidID(id string) bool {
+	if id == "" || strings.HasPrefix(id, ".") {
+		return false
+	}
+	return !strings.ContainsAny(id, `/\`) && id == filepath.Base(id)
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CaptionsExt is appended to a recording's file name to get its captions:
+// a WebVTT transcript written while it was on air.
+const CaptionsExt = ".vtt"
+
+// CaptionsPath returns the captions file for a recording.
+func CaptionsPath(recording string) string {
+	return recording + CaptionsExt
+}
+
+// vttEscaper keeps cue text from being read as markup or a cue timing.
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// AppendCaption adds a cue with text, from and to being offsets into the
+// recording, to the recording's captions, starting the file if need be.
+func AppendCaption(recording string, from, to time.Duration, text string) error {
+	f, err := os.OpenFile(CaptionsPath(recording), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		b.WriteString("WEBVTT\n\n")
+	}
+	fmt.Fprintf(&b, "%s --> %s\n%s\n\n", vttTime(from), vttTime(to), vttEscaper.Replace(text))
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// vttTime formats d as a WebVTT timestamp, hh:mm:ss.ttt.
+func vttTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
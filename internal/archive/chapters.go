@@ -0,0 +1,46 @@
+package archive
+
+// ChaptersExt is the suffix of chapter manifest URLs in the archive API.
+const ChaptersExt = ".chapters.json"
+
+// Chapter is one stretch of a recording between two metadata changes.
+type Chapter struct {
+	Number    int     `json:"number"`
+	Title     string  `json:"title"`
+	Start     float64 `json:"start"` // seconds
+	End       float64 `json:"end"`
+	StartByte int64   `json:"start_byte"`
+	EndByte   int64   `json:"end_byte"` // exclusive
+}
+
+// Chapters splits a recording at its metadata-change boundaries. size is the
+// recording's length in bytes; anything before the first title becomes an
+// untitled opening chapter. Chapters with no audio are dropped.
+func Chapters(sc *Sidecar, size int64) []Chapter {
+	duration := 0.0
+	if !sc.End.IsZero() {
+		duration = sc.End.Sub(sc.Start).Seconds()
+	}
+
+	tracks := sc.Tracks
+	if len(tracks) == 0 || tracks[0].Byte > 0 {
+		tracks = append([]Track{{Title: "", Offset: 0, Byte: 0}}, tracks...)
+	}
+
+	var out []Chapter
+	for i, t := range tracks {
+		c := Chapter{Title: t.Title, Start: t.Offset, StartByte: t.Byte, End: duration, EndByte: size}
+		if i+1 < len(tracks) {
+			c.End, c.EndByte = tracks[i+1].Offset, tracks[i+1].Byte
+		}
+		if c.EndByte <= c.StartByte || c.StartByte >= size {
+			continue
+		}
+		if c.EndByte > size {
+			c.EndByte = size
+		}
+		c.Number = len(out) + 1
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MeasureLoudness runs ffmpeg's ebur128 filter over a recording and parses
+// the summary it prints at the end.
+func MeasureLoudness(ctx context.Context, ffmpeg, path string) (*Loudness, error) {
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-nostats", "-i", path,
+		"-af", "ebur128=peak=true", "-f", "null", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg loudness analysis failed: %w", err)
+	}
+	return parseEBUR128Summary(stderr.String())
+}
+
+// parseEBUR128Summary extracts the I, LRA and true peak values from the
+// "Summary:" block of ffmpeg's ebur128 output.
+func parseEBUR128Summary(out string) (*Loudness, error) {
+	idx := strings.LastIndex(out, "Summary:")
+	if idx < 0 {
+		return nil, fmt.Errorf("no ebur128 summary in ffmpeg output")
+	}
+
+	l := &Loudness{}
+	var gotI bool
+	sc := bufio.NewScanner(strings.NewReader(out[idx:]))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "I:":
+			l.Integrated, gotI = v, true
+		case "LRA:":
+			l.Range = v
+		case "Peak:":
+			l.TruePeak = v
+		}
+	}
+	if !gotI {
+		return nil, fmt.Errorf("no integrated loudness in ebur128 summary")
+	}
+	return l, nil
+}
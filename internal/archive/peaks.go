@@ -0,0 +1,122 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// peaksSampleRate is the rate recordings are decoded at for peak
+	// extraction. Waveform rendering doesn't need full bandwidth.
+	peaksSampleRate = 8000
+	// peaksSamplesPerPixel gives 10 min/max pairs per second of audio.
+	peaksSamplesPerPixel = 800
+)
+
+// PeaksExt is the suffix of peak files in the cache directory, and of their
+// URLs in the archive API.
+const PeaksExt = ".peaks.json"
+
+// Peaks is the audiowaveform JSON format (version 2, 8-bit, mono), which
+// web players such as peaks.js and wavesurfer load directly.
+type Peaks struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// PeaksPath is where the peaks for recording id are cached.
+func PeaksPath(cacheDir, id string) string {
+	return filepath.Join(cacheDir, id+PeaksExt)
+}
+
+// GeneratePeaks decodes src with ffmpeg and writes its waveform peaks to dst.
+func GeneratePeaks(ctx context.Context, ffmpeg, src, dst string) error {
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-nostats", "-i", src,
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(peaksSampleRate), "-f", "s16le", "-")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	peaks, readErr := readPeaks(bufio.NewReader(stdout))
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg decoding failed: %w", err)
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	data, err := json.Marshal(peaks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp := dst + ".part"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// readPeaks reduces 16-bit little-endian mono PCM to per-pixel min/max pairs
+// scaled to 8 bits.
+func readPeaks(r io.Reader) (*Peaks, error) {
+	p := &Peaks{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      peaksSampleRate,
+		SamplesPerPixel: peaksSamplesPerPixel,
+		Bits:            8,
+		Data:            []int8{},
+	}
+
+	var sample [2]byte
+	count := 0
+	var lo, hi int16
+	flush := func() {
+		p.Data = append(p.Data, int8(lo>>8), int8(hi>>8))
+		p.Length++
+		count, lo, hi = 0, 0, 0
+	}
+	for {
+		if _, err := io.ReadFull(r, sample[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		v := int16(binary.LittleEndian.Uint16(sample[:]))
+		if count == 0 || v < lo {
+			lo = v
+		}
+		if count == 0 || v > hi {
+			hi = v
+		}
+		count++
+		if count == peaksSamplesPerPixel {
+			flush()
+		}
+	}
+	if count > 0 {
+		flush()
+	}
+	return p, nil
+}
@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SidecarExt is appended to a recording's file name to get its sidecar.
+const SidecarExt = ".json"
+
+// Sidecar describes one recording so archive consumers don't have to
+// re-derive who was on air, what played, and how loud it was.
+type Sidecar struct {
+	Mount         string    `json:"mount"`
+	Account       string    `json:"account"`
+	Show          string    `json:"show,omitempty"`
+	SessionID     string    `json:"session_id,omitempty"`
+	ContentType   string    `json:"content_type"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end,omitempty"`
+	Bytes         int64     `json:"bytes"`
+	PeakListeners int       `json:"peak_listeners"`
+	Tracks        []Track   `json:"tracks"`
+	Loudness      *Loudness `json:"loudness,omitempty"`
+}
+
+// Track is one metadata change during the recording.
+type Track struct {
+	Title  string  `json:"title"`
+	Offset float64 `json:"offset"` // seconds since the recording started
+	Byte   int64   `json:"byte"`   // byte offset into the recording
+}
+
+// Loudness holds EBU R128 measurements of the whole recording.
+type Loudness struct {
+	Integrated float64 `json:"integrated_lufs"`
+	Range      float64 `json:"range_lu"`
+	TruePeak   float64 `json:"true_peak_dbfs"`
+}
+
+// SidecarPath returns the sidecar file for a recording.
+func SidecarPath(recording string) string {
+	return recording + SidecarExt
+}
+
+// WriteSidecar atomically writes sc next to the recording at path.
+func WriteSidecar(recording string, sc *Sidecar) error {
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sidecar: %w", err)
+	}
+	path := SidecarPath(recording)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write sidecar: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write sidecar: %w", err)
+	}
+	return nil
+}
+
+// ReadSidecar loads the sidecar of the recording at path.
+func ReadSidecar(recording string) (*Sidecar, error) {
+	data, err := os.ReadFile(SidecarPath(recording))
+	if err != nil {
+		return nil, err
+	}
+	var sc Sidecar
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("invalid sidecar for %s: %w", recording, err)
+	}
+	return &sc, nil
+}
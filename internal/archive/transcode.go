@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Format describes an output format ffmpeg can transcode archives into.
+type Format struct {
+	Ext         string
+	ContentType string
+	codecArgs   []string
+}
+
+// Formats lists the alternate formats offered for archived recordings.
+var Formats = map[string]Format{
+	"mp3":  {Ext: "mp3", ContentType: "audio/mpeg", codecArgs: []string{"-c:a", "libmp3lame", "-f", "mp3"}},
+	"ogg":  {Ext: "ogg", ContentType: "audio/ogg", codecArgs: []string{"-c:a", "libvorbis", "-f", "ogg"}},
+	"opus": {Ext: "opus", ContentType: "audio/ogg; codecs=opus", codecArgs: []string{"-c:a", "libopus", "-f", "ogg"}},
+	"aac":  {Ext: "aac", ContentType: "audio/aac", codecArgs: []string{"-c:a", "aac", "-f", "adts"}},
+}
+
+// ParseBitrate accepts "64k" or "64" (kbps) within a sensible range.
+func ParseBitrate(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "k"))
+	if err != nil || n < 16 || n > 320 {
+		return 0, fmt.Errorf("bitrate must be between 16k and 320k")
+	}
+	return n, nil
+}
+
+// Transcoder converts recordings on demand and caches the results, so each
+// format/bitrate combination is only ever encoded once.
+type Transcoder struct {
+	FFmpeg   string
+	CacheDir string
+
+	mu       sync.Mutex
+	inflight map[string]*transcodeCall
+}
+
+type transcodeCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTranscoder returns a transcoder caching into cacheDir.
+func NewTranscoder(ffmpeg, cacheDir string) *Transcoder {
+	return &Transcoder{FFmpeg: ffmpeg, CacheDir: cacheDir, inflight: make(map[string]*transcodeCall)}
+}
+
+// CachePath is where the transcoded copy of id would live.
+func (t *Transcoder) CachePath(id string, f Format, kbps int) string {
+	return filepath.Join(t.CacheDir, fmt.Sprintf("%s-%dk.%s", id, kbps, f.Ext))
+}
+
+// Get returns the path of a transcoded copy of src, creating it if it's not
+// in the cache yet. Concurrent requests for the same output share one ffmpeg
+// run. The transcode is not tied to ctx's lifetime beyond waiting: a client
+// that gives up still leaves a warm cache for the next one.
+func (t *Transcoder) Get(ctx context.Context, src, id string, f Format, kbps int) (string, error) {
+	dst := t.CachePath(id, f, kbps)
+	if fresh(dst, src) {
+		return dst, nil
+	}
+
+	t.mu.Lock()
+	call, running := t.inflight[dst]
+	if !running {
+		call = &transcodeCall{done: make(chan struct{})}
+		t.inflight[dst] = call
+		go func() {
+			call.err = t.run(src, dst, f, kbps)
+			t.mu.Lock()
+			delete(t.inflight, dst)
+			t.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return dst, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (t *Transcoder) run(src, dst string, f Format, kbps int) error {
+	if err := os.MkdirAll(t.CacheDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	tmp := dst + ".part"
+	args := []string{"-hide_banner", "-nostats", "-y", "-i", src, "-vn", "-b:a", strconv.Itoa(kbps) + "k"}
+	args = append(args, f.codecArgs...)
+	args = append(args, tmp)
+
+	cmd := exec.Command(t.FFmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndex(msg, "\n"); i >= 0 {
+			msg = msg[i+1:]
+		}
+		return fmt.Errorf("ffmpeg failed: %w (%s)", err, msg)
+	}
+	return os.Rename(tmp, dst)
+}
+
+// fresh reports whether the cached file exists and is newer than its source.
+func fresh(cached, src string) bool {
+	ci, err := os.Stat(cached)
+	if err != nil {
+		return false
+	}
+	si, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	return !ci.ModTime().Before(si.ModTime())
+}
@@ -0,0 +1,274 @@
+// Package crash writes crash bundles: everything needed to debug a panic
+// or fatal error on somebody else's server, in one file that can be
+// attached to a bug report. A bundle is a .tar.gz holding the panicking
+// goroutine's stack, every goroutine's stack, the last part of the log and
+// whatever sections the rest of the program registers (the redacted config,
+// metrics, and so on). Bundles go to a directory and, optionally, are
+// POSTed to a collection endpoint.
+package crash
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nickcast/internal/clock"
+)
+
+const (
+	// logRingSize is how much of the most recent log output a bundle holds.
+	logRingSize = 256 * 1024
+
+	// minInterval spaces bundles out, so a handler that panics on every
+	// request doesn't fill the disk or flood the endpoint.
+	minInterval = time.Minute
+
+	// keep is how many bundles stay in the directory; older ones are
+	// removed as new ones are written.
+	keep = 20
+
+	postTimeout = 30 * time.Second
+)
+
+var (
+	mu       sync.Mutex
+	dir      string
+	postURL  string
+	sections []section
+	last     time.Time
+	started  = clock.Default.Now()
+
+	ring = &logRing{}
+)
+
+type section struct {
+	name  string
+	write func(io.Writer) error
+}
+
+// logRing keeps the tail of the log output.
+type logRing struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = append(r.buf, p...)
+	if over := len(r.buf) - logRingSize; over > 0 {
+		// Drop whole lines so the bundle's log doesn't start mid-line.
+		if i := bytes.IndexByte(r.buf[over:], '\n'); i >= 0 {
+			over += i + 1
+		}
+		r.buf = append(r.buf[:0], r.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (r *logRing) bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.buf...)
+}
+
+// CaptureLog copies standard logger output into the ring that bundles take
+// their log from, still writing it to stderr as before.
+func CaptureLog() {
+	log.SetOutput(io.MultiWriter(os.Stderr, ring))
+}
+
+// Configure sets where bundles are written and, if url is not empty, where
+// they are POSTed.
+func Configure(directory, url string) {
+	mu.Lock()
+	dir, postURL = directory, url
+	mu.Unlock()
+}
+
+// AddSection adds a file called name to every bundle, filled in by write
+// when the bundle is made. write runs while the program is in trouble, so
+// it should take as few locks as it can.
+func AddSection(name string, write func(io.Writer) error) {
+	mu.Lock()
+	sections = append(sections, section{name, write})
+	mu.Unlock()
+}
+
+// Report writes a bundle for the given reason, with stack (from
+// debug.Stack in the goroutine that failed) if there is one, and POSTs it
+// if configured to. It returns the bundle's path, or "" if none was
+// written. Reports within minInterval of the last one are skipped.
+func Report(reason string, stack []byte) string {
+	mu.Lock()
+	now := clock.Default.Now()
+	if dir == "" {
+		mu.Unlock()
+		return ""
+	}
+	if !last.IsZero() && now.Sub(last) < minInterval {
+		mu.Unlock()
+		log.Printf("Crash report skipped (%s): the last one was written %s ago", reason, now.Sub(last).Round(time.Second))
+		return ""
+	}
+	last = now
+	directory, url := dir, postURL
+	extra := append([]section(nil), sections...)
+	mu.Unlock()
+
+	bundle, err := build(now, reason, stack, extra)
+	if err != nil {
+		log.Printf("Crash report failed: %v", err)
+		return ""
+	}
+	name := "crash-" + now.Format("20060102-150405") + ".tar.gz"
+	path := filepath.Join(directory, name)
+	if err := save(path, bundle); err != nil {
+		log.Printf("Crash report failed: %v", err)
+		return ""
+	}
+	log.Printf("Crash report written to %s", path)
+	prune(directory)
+
+	if url != "" {
+		if err := post(url, name, bundle); err != nil {
+			log.Printf("Crash report upload to %s failed: %v", url, err)
+		} else {
+			log.Printf("Crash report %s sent to %s", name, url)
+		}
+	}
+	return path
+}
+
+// build assembles the bundle in memory.
+func build(now time.Time, reason string, stack []byte, extra []section) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	host, _ := os.Hostname()
+	summary := fmt.Sprintf("Reason: %s\nTime: %s\nUptime: %s\nHost: %s\nPID: %d\nGo: %s %s/%s\nGoroutines: %d\n",
+		reason, now.Format(time.RFC3339), now.Sub(started).Round(time.Second), host, os.Getpid(),
+		runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.NumGoroutine())
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"crash.txt", []byte(summary)},
+		{"stack.txt", stack},
+		{"goroutines.txt", allStacks()},
+		{"log.txt", ring.bytes()},
+	}
+	for _, f := range files {
+		if f.name == "stack.txt" && len(f.data) == 0 {
+			continue
+		}
+		if err := add(f.name, f.data); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range extra {
+		var b bytes.Buffer
+		if err := s.write(&b); err != nil {
+			// A broken section shouldn't cost us the rest of the bundle.
+			fmt.Fprintf(&b, "\n(error: %v)\n", err)
+		}
+		if err := add(s.name, b.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// allStacks returns every goroutine's stack, growing the buffer until it
+// fits.
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// save writes the bundle under a temporary name and renames it into place,
+// so a collector watching the directory never picks up half a file. The
+// log and stacks carry listener addresses, so only the owner may read it.
+func save(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prune removes all but the newest bundles in directory. Bundle names sort
+// by time.
+func prune(directory string) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "crash-") && strings.HasSuffix(e.Name(), ".tar.gz") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(directory, names[0])); err != nil {
+			log.Printf("Could not remove old crash report %s: %v", names[0], err)
+		}
+		names = names[1:]
+	}
+}
+
+func post(url, name string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	client := &http.Client{Timeout: postTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
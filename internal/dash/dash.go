@@ -0,0 +1,283 @@
+// Package dash packages a live audio stream for MPEG-DASH. Like package
+// hls, it cuts the stream into segments on frame boundaries and keeps a
+// sliding window of them, here as fragmented MP4 described by a dynamic
+// MPD manifest, which dash.js, Shaka Player and ExoPlayer all play. MP3
+// and AAC frames go into the segments as they are; nothing is re-encoded.
+package dash
+
+import (
+	"fmt"
+	"html"
+	"nickcast/internal/aac"
+	"nickcast/internal/mp3"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is what a Packager is given.
+type Format int
+
+const (
+	MP3 Format = iota // MPEG audio frames
+	AAC               // ADTS AAC frames, which go in without their headers
+)
+
+// Segment is one piece of the stream.
+type Segment struct {
+	Seq      int64
+	Period   int64 // the ID of the period it belongs to
+	Time     int64 // when it starts in the period, in samples
+	Duration int64 // in samples
+	Data     []byte
+}
+
+// track is what a period's audio is. A change of any of it starts a new
+// period with its own initialization segment.
+type track struct {
+	codecs     string // as in the manifest
+	objectType byte   // MPEG-4 object type indication, for the esds box
+	config     string // AudioSpecificConfig, AAC only
+	rate       int
+	channels   int
+	samples    int // per frame
+}
+
+// period is a run of the stream with one track. The manifest has a Period
+// for each one still in the window.
+type period struct {
+	id    int64         // the sequence number of its first segment
+	start time.Duration // since the packager's availability start time
+	track track
+	init  []byte
+	next  int64 // the time of the next frame, in samples
+}
+
+// end is when the audio the period has so far ends.
+func (p *period) end() time.Duration {
+	return p.start + time.Duration(p.next)*time.Second/time.Duration(p.track.rate)
+}
+
+// Packager segments a stream as it arrives in arbitrary chunks. It is safe
+// for concurrent use: one goroutine writes while others serve segments.
+type Packager struct {
+	format Format
+	target time.Duration
+	window int
+	now    func() time.Time
+	start  time.Time // the manifest's availability start time
+
+	mu       sync.Mutex
+	frames   mp3.Analyzer // MP3 only
+	adts     aac.Splitter // AAC only
+	period   *period      // the one being written; nil after a Break
+	cur      []byte       // frames of the segment being cut
+	sizes    []uint32
+	curStart int64
+	nextSeq  int64
+	ended    bool
+	periods  []*period
+	segments []Segment
+}
+
+// New returns a packager cutting segments of about target long and
+// keeping the last window of them, with now telling the time. Sequence
+// numbers start at the current Unix time, so a restarted server doesn't
+// reuse the URLs of segments a CDN or player may still have cached.
+func New(format Format, target time.Duration, window int, now func() time.Time) *Packager {
+	start := now().Truncate(time.Second)
+	return &Packager{format: format, target: target, window: window, now: now, start: start, nextSeq: start.Unix()}
+}
+
+// Write feeds the next chunk of the stream to the packager. Data that
+// isn't part of a frame is dropped.
+func (p *Packager) Write(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ended = false
+	if p.format == AAC {
+		p.adts.Feed(data, p.addADTS)
+		return
+	}
+	p.frames.Feed(data, p.addMP3)
+}
+
+func (p *Packager) addMP3(frame []byte, h mp3.Header) {
+	t := track{codecs: "mp4a.6B", objectType: 0x6B, rate: h.SampleRate, channels: h.Channels, samples: h.Samples}
+	if h.Version != 1 {
+		t.codecs, t.objectType = "mp4a.69", 0x69
+	}
+	p.add(frame, t)
+}
+
+func (p *Packager) addADTS(frame []byte, h aac.Header) {
+	t := track{
+		codecs:     fmt.Sprintf("mp4a.40.%d", h.ObjectType),
+		objectType: 0x40,
+		config:     string(h.Config()),
+		rate:       h.SampleRate,
+		channels:   h.Channels,
+		samples:    h.Samples,
+	}
+	p.add(frame[h.HeaderSize:], t)
+}
+
+func (p *Packager) add(frame []byte, t track) {
+	if p.period == nil || p.period.track != t {
+		p.cut()
+		p.startPeriod(t)
+	}
+	p.cur = append(p.cur, frame...)
+	p.sizes = append(p.sizes, uint32(len(frame)))
+	p.period.next += int64(t.samples)
+	if p.samplesTime(p.period.next-p.curStart) >= p.target {
+		p.cut()
+	}
+}
+
+func (p *Packager) samplesTime(n int64) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(p.period.track.rate)
+}
+
+// startPeriod begins a period with track t now, or when the last one's
+// audio ends if that is later: periods may not overlap.
+func (p *Packager) startPeriod(t track) {
+	start := p.now().Sub(p.start).Round(time.Millisecond)
+	if n := len(p.periods); n > 0 {
+		if end := p.periods[n-1].end(); start < end {
+			start = end
+		}
+	}
+	p.period = &period{id: p.nextSeq, start: start, track: t, init: initSegment(t)}
+	p.periods = append(p.periods, p.period)
+	p.curStart = 0
+}
+
+// cut finishes the segment being cut, if it has any audio, and puts it in
+// the window.
+func (p *Packager) cut() {
+	if len(p.sizes) == 0 {
+		return
+	}
+	pd := p.period
+	p.segments = append(p.segments, Segment{
+		Seq:      p.nextSeq,
+		Period:   pd.id,
+		Time:     p.curStart,
+		Duration: pd.next - p.curStart,
+		Data:     fragment(p.nextSeq, uint64(p.curStart), uint32(pd.track.samples), p.sizes, p.cur),
+	})
+	p.nextSeq++
+	p.cur, p.sizes, p.curStart = p.cur[:0], p.sizes[:0], pd.next
+	if len(p.segments) > p.window {
+		p.segments = p.segments[len(p.segments)-p.window:]
+	}
+	for len(p.periods) > 1 && p.periods[0].id != p.segments[0].Period {
+		p.periods = p.periods[1:]
+	}
+}
+
+// Break marks the end of the stream: what is left is cut into a last
+// segment and the manifest ends, until the next Write starts it again in
+// a new period.
+func (p *Packager) Break() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cut()
+	p.frames = mp3.Analyzer{}
+	p.adts = aac.Splitter{}
+	p.period = nil
+	p.ended = len(p.segments) > 0
+}
+
+// Segment returns the segment numbered seq if it is still in the window.
+func (p *Packager) Segment(seq int64) (Segment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.segments) == 0 {
+		return Segment{}, false
+	}
+	i := seq - p.segments[0].Seq
+	if i < 0 || i >= int64(len(p.segments)) {
+		return Segment{}, false
+	}
+	return p.segments[i], true
+}
+
+// Init returns the initialization segment of the period with the given
+// ID, if it is still in the window.
+func (p *Packager) Init(id int64) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pd := range p.periods {
+		if pd.id == id {
+			return pd.init, true
+		}
+	}
+	return nil, false
+}
+
+// Manifest returns the live MPD, with initialization segments at base
+// followed by "init-", the period ID and ".mp4", and media segments at
+// base followed by the sequence number and ".m4s", and whether there is
+// anything in it yet.
+func (p *Packager) Manifest(base string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.segments) == 0 {
+		return "", false
+	}
+	target := int(p.target / time.Second)
+	base = html.EscapeString(base)
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="dynamic" availabilityStartTime="%s" publishTime="%s"`,
+		xsDateTime(p.start), xsDateTime(p.now()))
+	if p.ended {
+		last := p.periods[len(p.periods)-1]
+		fmt.Fprintf(&b, ` mediaPresentationDuration="%s"`, xsDuration(last.end()))
+	} else {
+		fmt.Fprintf(&b, ` minimumUpdatePeriod="PT%dS"`, target)
+	}
+	fmt.Fprintf(&b, ` minBufferTime="PT%dS" timeShiftBufferDepth="PT%dS" suggestedPresentationDelay="PT%dS">`+"\n",
+		target, target*p.window, 3*target)
+	for _, pd := range p.periods {
+		var segs []Segment
+		var bytes int
+		for _, s := range p.segments {
+			if s.Period == pd.id {
+				segs = append(segs, s)
+				bytes += len(s.Data)
+			}
+		}
+		if len(segs) == 0 {
+			continue
+		}
+		t := pd.track
+		last := segs[len(segs)-1]
+		bandwidth := int64(bytes) * 8 * int64(t.rate) / (last.Time + last.Duration - segs[0].Time)
+		fmt.Fprintf(&b, `  <Period id="%d" start="%s">`+"\n", pd.id, xsDuration(pd.start))
+		b.WriteString(`    <AdaptationSet contentType="audio" mimeType="audio/mp4" lang="und" segmentAlignment="true" startWithSAP="1">` + "\n")
+		fmt.Fprintf(&b, `      <Representation id="audio" codecs="%s" audioSamplingRate="%d" bandwidth="%d">`+"\n", t.codecs, t.rate, bandwidth)
+		if t.channels > 0 {
+			fmt.Fprintf(&b, `        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011" value="%d"/>`+"\n", t.channels)
+		}
+		fmt.Fprintf(&b, `        <SegmentTemplate timescale="%d" startNumber="%d" initialization="%sinit-%d.mp4" media="%s$Number$.m4s">`+"\n",
+			t.rate, segs[0].Seq, base, pd.id, base)
+		b.WriteString("          <SegmentTimeline>\n")
+		for _, s := range segs {
+			fmt.Fprintf(&b, `            <S t="%d" d="%d"/>`+"\n", s.Time, s.Duration)
+		}
+		b.WriteString("          </SegmentTimeline>\n        </SegmentTemplate>\n      </Representation>\n    </AdaptationSet>\n  </Period>\n")
+	}
+	b.WriteString("</MPD>\n")
+	return b.String(), true
+}
+
+func xsDateTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func xsDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
@@ -0,0 +1,126 @@
+package dash
+
+import "encoding/binary"
+
+// The boxes of fragmented MP4 (ISO/IEC 14496-12) that an audio-only live
+// stream needs, and no more.
+
+// identity is the unity transformation matrix of mvhd and tkhd.
+var identity = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func box(typ string, parts ...[]byte) []byte {
+	n := 8
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, n), uint32(n))
+	b = append(b, typ...)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func fullBox(typ string, version byte, flags uint32, parts ...[]byte) []byte {
+	head := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(typ, append([][]byte{head}, parts...)...)
+}
+
+func u16(v int) []byte    { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func matrix() []byte {
+	var b []byte
+	for _, v := range identity {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// descriptor encodes an MPEG-4 systems descriptor, as esds holds. None of
+// ours reach 128 bytes, so the length takes one byte.
+func descriptor(tag byte, parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := []byte{tag, byte(n)}
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// initSegment returns the initialization segment for t: the file header
+// and a single audio track, with its samples left to the fragments.
+func initSegment(t track) []byte {
+	rate := uint32(t.rate)
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6cmfcdash"))
+	mvhd := fullBox("mvhd", 0, 0, u32(0), u32(0), u32(1000), u32(0),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix(), make([]byte, 24), u32(2))
+	tkhd := fullBox("tkhd", 0, 3, u32(0), u32(0), u32(1), u32(0), u32(0),
+		make([]byte, 8), u16(0), u16(0), u16(0x0100), u16(0), matrix(), u32(0), u32(0))
+	// Language "und", packed as three five-bit letters.
+	mdhd := fullBox("mdhd", 0, 0, u32(0), u32(0), u32(rate), u32(0), u16(0x55C4), u16(0))
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+	smhd := fullBox("smhd", 0, 0, u16(0), u16(0))
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), sampleEntry(t)),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)))
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", smhd, dinf, stbl)))
+	mvex := box("mvex", fullBox("trex", 0, 0, u32(1), u32(1), u32(0), u32(0), u32(0)))
+	return append(ftyp, box("moov", mvhd, trak, mvex)...)
+}
+
+// sampleEntry describes t's audio in an mp4a box, whatever the codec: MP3
+// is told apart from AAC by its object type.
+func sampleEntry(t track) []byte {
+	channels := t.channels
+	if channels == 0 {
+		channels = 2 // given in-band; players go by the decoder config
+	}
+	rate := uint32(t.rate) << 16
+	if t.rate > 0xFFFF {
+		rate = 0
+	}
+	config := []byte{t.objectType, 0x15, 0, 0, 0} // audio stream, no buffer size
+	config = append(config, u32(0)...)            // maximum bitrate, unknown
+	config = append(config, u32(0)...)            // average bitrate, unknown
+	var specific []byte
+	if t.config != "" {
+		specific = descriptor(0x05, []byte(t.config))
+	}
+	esds := fullBox("esds", 0, 0, descriptor(0x03,
+		u16(0), []byte{0},
+		descriptor(0x04, config, specific),
+		descriptor(0x06, []byte{0x02})))
+	return box("mp4a", make([]byte, 6), u16(1), make([]byte, 8),
+		u16(channels), u16(16), u16(0), u16(0), u32(rate), esds)
+}
+
+// fragment returns a media segment holding frames, one sample each, that
+// decode from time decode on and each last duration.
+func fragment(seq int64, decode uint64, duration uint32, sizes []uint32, frames []byte) []byte {
+	samples := binary.BigEndian.AppendUint32(nil, uint32(len(sizes)))
+	samples = append(samples, 0, 0, 0, 0) // data offset, filled in below
+	for _, n := range sizes {
+		samples = binary.BigEndian.AppendUint32(samples, duration)
+		samples = binary.BigEndian.AppendUint32(samples, n)
+	}
+	// Sample durations and sizes given, data offset from the moof.
+	trun := fullBox("trun", 0, 0x000301, samples)
+	traf := box("traf",
+		fullBox("tfhd", 0, 0x020000, u32(1)),
+		fullBox("tfdt", 1, 0, u64(decode)),
+		trun)
+	moof := box("moof", fullBox("mfhd", 0, 0, u32(uint32(seq))), traf)
+	// trun ends the moof; its data offset follows the box and full box
+	// headers and the sample count, and points past the mdat header.
+	binary.BigEndian.PutUint32(moof[len(moof)-len(trun)+16:], uint32(len(moof)+8))
+	return append(moof, box("mdat", frames)...)
+}
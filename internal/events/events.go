@@ -0,0 +1,64 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"nickcast/internal/clock"
+)
+
+// Event types published by the server.
+const (
+	SourceConnect      = "source.connect"
+	SourceDisconnect   = "source.disconnect"
+	SourceTakeover     = "source.takeover"
+	SourceHandoff      = "source.handoff"
+	SourceSlotWarning  = "source.slot_warning"
+	SourceSlotEnd      = "source.slot_end"
+	ListenerConnect    = "listener.connect"
+	ListenerDisconnect = "listener.disconnect"
+	DeadAirStart       = "dead_air.start"
+	DeadAirEnd         = "dead_air.end"
+	SilenceStart       = "silence.start"
+	SilenceEnd         = "silence.end"
+	MetadataUpdate     = "metadata.update"
+)
+
+// Event is a single thing that happened on the server. SessionID ties it back
+// to the source or listener connection (and its log lines) that caused it.
+type Event struct {
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	SessionID  string            `json:"session_id,omitempty"`
+	Account    string            `json:"account,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+var (
+	subscribers   []func(Event)
+	subscribersMu sync.RWMutex
+)
+
+// Subscribe registers fn to be called for every published event. Subscribers
+// are called synchronously, so they must not block; anything slow (like a
+// webhook) should hand the event off to its own goroutine.
+func Subscribe(fn func(Event)) {
+	subscribersMu.Lock()
+	subscribers = append(subscribers, fn)
+	subscribersMu.Unlock()
+}
+
+// Publish stamps the event with the current time and delivers it to every
+// subscriber.
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = clock.Default.Now()
+	}
+	subscribersMu.RLock()
+	subs := subscribers
+	subscribersMu.RUnlock()
+	for _, fn := range subs {
+		fn(e)
+	}
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// webhookQueueSize bounds how many events can wait for delivery. Events past
+// this are dropped rather than blocking the stream path.
+const webhookQueueSize = 256
+
+// Webhook POSTs every event as JSON to a configured URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+	queue  chan Event
+}
+
+// NewWebhook creates a webhook notifier and subscribes it to all events. Call
+// Run (normally under the supervisor) to start delivering.
+func NewWebhook(url string) *Webhook {
+	w := &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, webhookQueueSize),
+	}
+	Subscribe(w.enqueue)
+	return w
+}
+
+func (w *Webhook) enqueue(e Event) {
+	select {
+	case w.queue <- e:
+	default:
+		log.Printf("[%s] Webhook queue full; dropping %s event", e.SessionID, e.Type)
+	}
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (w *Webhook) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-w.queue:
+			if err := w.send(ctx, e); err != nil {
+				log.Printf("[%s] Webhook delivery of %s failed: %v", e.SessionID, e.Type, err)
+			}
+		}
+	}
+}
+
+func (w *Webhook) send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NickCast/1.0")
+	if e.SessionID != "" {
+		req.Header.Set("X-Request-ID", e.SessionID)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package fingerprint recognizes what is on air from a short sample of the
+// stream, using either an external command or an HTTP API (typically a
+// wrapper around Chromaprint's fpcalc and an AcoustID-style lookup), so
+// nickcast doesn't have to bundle a fingerprinting library.
+package fingerprint
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// maxAnswer caps how much of a recognizer's answer is read.
+const maxAnswer = 4096
+
+// Recognizer identifies tracks. Exactly one of Command and URL is used,
+// Command taking precedence.
+type Recognizer struct {
+	// Command is run with the audio sample on stdin, and its content type in
+	// NICKCAST_CONTENT_TYPE, and prints the track as "Artist - Title" on
+	// the first line of stdout, or nothing if it doesn't know it. It is
+	// split on spaces; anything fancier belongs in a wrapper script.
+	Command []string
+	// URL receives the sample as a POST with the stream's Content-Type and
+	// answers 200 with the track as text/plain, or 204 or 404 if it doesn't
+	// know it.
+	URL string
+}
+
+// New builds a Recognizer from the fingerprint_command and fingerprint_url
+// settings. It returns nil if neither is set.
+func New(command, url string) *Recognizer {
+	if command == "" && url == "" {
+		return nil
+	}
+	return &Recognizer{Command: strings.Fields(command), URL: url}
+}
+
+// Identify returns the track playing in audio, or "" if it wasn't
+// recognized.
+func (r *Recognizer) Identify(ctx context.Context, audio []byte, contentType string) (string, error) {
+	var answer []byte
+	var err error
+	if len(r.Command) > 0 {
+		answer, err = r.runCommand(ctx, audio, contentType)
+	} else {
+		answer, err = r.post(ctx, audio, contentType)
+	}
+	if err != nil {
+		return "", err
+	}
+	line, _ := bufio.NewReader(bytes.NewReader(answer)).ReadString('\n')
+	return strings.TrimSpace(line), nil
+}
+
+func (r *Recognizer) runCommand(ctx context.Context, audio []byte, contentType string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, r.Command[0], r.Command[1:]...)
+	cmd.Env = append(os.Environ(), "NICKCAST_CONTENT_TYPE="+contentType)
+	cmd.Stdin = bytes.NewReader(audio)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("fingerprint command failed: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() > maxAnswer {
+		return nil, fmt.Errorf("fingerprint command printed more than %d bytes", maxAnswer)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (r *Recognizer) post(ctx context.Context, audio []byte, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(audio))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("fingerprint service returned %s", resp.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxAnswer+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) > maxAnswer {
+		return nil, fmt.Errorf("fingerprint service returned more than %d bytes", maxAnswer)
+	}
+	return answer, nil
+}
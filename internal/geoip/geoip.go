@@ -0,0 +1,100 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DB maps IP ranges to ISO 3166 country codes. It loads the plain CSV range
+// format published by DB-IP ("country lite") and IP2Location LITE:
+//
+//	start_ip,end_ip,country_code
+//
+// which avoids depending on a binary database reader.
+type DB struct {
+	ranges []ipRange // sorted by start
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// Open loads a CSV range database from path.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening GeoIP database: %w", err)
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load reads a CSV range database from r.
+func Load(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	db := &DB{}
+	line := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("error reading GeoIP database line %d: %w", line, err)
+		}
+		if len(rec) < 3 {
+			continue
+		}
+		start, err1 := netip.ParseAddr(strings.TrimSpace(rec[0]))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(rec[1]))
+		if err1 != nil || err2 != nil {
+			// Header rows and comments; skip rather than fail.
+			continue
+		}
+		db.ranges = append(db.ranges, ipRange{
+			start:   start.Unmap(),
+			end:     end.Unmap(),
+			country: strings.ToUpper(strings.TrimSpace(rec[2])),
+		})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+// Lookup returns the country code for addr, or "" if it isn't covered.
+func (db *DB) Lookup(addr netip.Addr) string {
+	if db == nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	// Find the last range starting at or before addr.
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return ""
+	}
+	r := db.ranges[i]
+	if r.start.BitLen() != addr.BitLen() || r.end.Less(addr) {
+		return ""
+	}
+	return r.country
+}
+
+// Len returns the number of ranges loaded.
+func (db *DB) Len() int {
+	return len(db.ranges)
+}
@@ -0,0 +1,23 @@
+package hls
+
+import "encoding/binary"
+
+// timestampTag appends the ID3 tag a packed audio segment starts with,
+// giving the MPEG timestamp of its first frame so players can line
+// segments up.
+func timestampTag(b []byte, pts uint64) []byte {
+	const owner = "com.apple.streaming.transportStreamTimestamp\x00"
+	frame := len(owner) + 8
+	b = append(b, 'I', 'D', '3', 4, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, syncsafe(10+frame))
+	b = append(b, 'P', 'R', 'I', 'V')
+	b = binary.BigEndian.AppendUint32(b, syncsafe(frame))
+	b = append(b, 0, 0)
+	b = append(b, owner...)
+	return binary.BigEndian.AppendUint64(b, pts)
+}
+
+// syncsafe encodes n as an ID3v2.4 size, seven bits to a byte.
+func syncsafe(n int) uint32 {
+	return uint32(n&0x7F | (n>>7&0x7F)<<8 | (n>>14&0x7F)<<16 | (n>>21&0x7F)<<24)
+}
@@ -0,0 +1,259 @@
+// Package hls packages a live audio stream for HTTP Live Streaming. It
+// cuts the stream into segments on frame boundaries and keeps a sliding
+// window of them for a live playlist: MP3 in MPEG-TS segments, AAC as
+// packed audio (ADTS frames behind an ID3 timestamp), both of which iOS
+// and smart TVs play natively. It never decodes or re-encodes audio.
+package hls
+
+import (
+	"fmt"
+	"math"
+	"nickcast/internal/aac"
+	"nickcast/internal/mp3"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is what a Packager is given and what its segments carry.
+type Format int
+
+const (
+	MP3 Format = iota // MPEG audio, in MPEG-TS segments
+	AAC               // ADTS AAC, in packed audio segments
+)
+
+// Ext is the file extension of the format's segments.
+func (f Format) Ext() string {
+	if f == AAC {
+		return ".aac"
+	}
+	return ".ts"
+}
+
+// ContentType is the media type of the format's segments.
+func (f Format) ContentType() string {
+	if f == AAC {
+		return "audio/aac"
+	}
+	return "video/mp2t"
+}
+
+// Segment is one piece of the stream.
+type Segment struct {
+	Seq           int64
+	Duration      time.Duration
+	Data          []byte
+	Discontinuity bool // it follows a break in the stream; see Packager.Break
+}
+
+// Packager segments a stream as it arrives in arbitrary chunks. It is safe
+// for concurrent use: one goroutine writes while others serve segments.
+type Packager struct {
+	format Format
+	target time.Duration
+	window int
+
+	mu        sync.Mutex
+	frames    mp3.Analyzer // MP3 only
+	adts      aac.Splitter // AAC only
+	ts        tsMuxer      // MP3 only
+	cur       []byte       // the segment being cut
+	curDur    time.Duration
+	pes       []byte // frames for the next PES packet, MP3 only
+	pesStart  time.Duration
+	pts       time.Duration // stream time of the next frame
+	nextSeq   int64
+	breakNext bool
+	ended     bool
+	segments  []Segment
+	discSeq   int64 // discontinuities that have left the window
+	changed   chan struct{}
+}
+
+// New returns a packager cutting segments of about target long and
+// keeping the last window of them. Sequence numbers start at the current
+// Unix time, so a restarted server doesn't reuse the URLs of segments a
+// CDN or player may still have cached.
+func New(format Format, target time.Duration, window int, now time.Time) *Packager {
+	return &Packager{format: format, target: target, window: window, nextSeq: now.Unix(), changed: make(chan struct{}, 1)}
+}
+
+// Changed receives once the playlist has changed since it was last
+// received from: a segment was cut, or the stream ended.
+func (p *Packager) Changed() <-chan struct{} {
+	return p.changed
+}
+
+func (p *Packager) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Segments returns the segments in the window, oldest first.
+func (p *Packager) Segments() []Segment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Segment(nil), p.segments...)
+}
+
+// Format returns the format the packager was made for.
+func (p *Packager) Format() Format {
+	return p.format
+}
+
+// Write feeds the next chunk of the stream to the packager. Data that
+// isn't part of a frame is dropped.
+func (p *Packager) Write(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ended {
+		p.ended = false
+		p.breakNext = true
+	}
+	if p.format == AAC {
+		p.adts.Feed(data, p.addADTS)
+		return
+	}
+	p.frames.Feed(data, p.addMP3)
+}
+
+func (p *Packager) addMP3(frame []byte, h mp3.Header) {
+	if len(p.cur) == 0 {
+		p.ts.streamType = mpegAudioType(h)
+		p.cur = p.ts.tables(p.cur)
+	}
+	if len(p.pes) == 0 {
+		p.pesStart = p.pts
+	}
+	p.pes = append(p.pes, frame...)
+	p.advance(h.Duration())
+	if len(p.pes) >= maxPES {
+		p.flushPES()
+	}
+	if p.curDur >= p.target {
+		p.cut()
+	}
+}
+
+func (p *Packager) addADTS(frame []byte, h aac.Header) {
+	if len(p.cur) == 0 {
+		p.cur = timestampTag(p.cur, ticks(p.pts))
+	}
+	p.cur = append(p.cur, frame...)
+	p.advance(h.Duration())
+	if p.curDur >= p.target {
+		p.cut()
+	}
+}
+
+func (p *Packager) advance(d time.Duration) {
+	p.pts += d
+	p.curDur += d
+}
+
+func (p *Packager) flushPES() {
+	if len(p.pes) > 0 {
+		p.cur = p.ts.pes(p.cur, p.pes, ticks(p.pesStart))
+		p.pes = p.pes[:0]
+	}
+}
+
+// cut finishes the segment being cut, if it has any audio, and puts it in
+// the window.
+func (p *Packager) cut() {
+	p.flushPES()
+	if p.curDur == 0 {
+		p.cur = p.cur[:0]
+		return
+	}
+	p.segments = append(p.segments, Segment{
+		Seq:           p.nextSeq,
+		Duration:      p.curDur,
+		Data:          append([]byte(nil), p.cur...),
+		Discontinuity: p.breakNext,
+	})
+	p.nextSeq++
+	p.breakNext = false
+	p.cur, p.curDur = p.cur[:0], 0
+	for len(p.segments) > p.window {
+		if p.segments[0].Discontinuity {
+			p.discSeq++
+		}
+		p.segments = p.segments[1:]
+	}
+	p.notify()
+}
+
+// Break marks the end of the stream: what is left is cut into a last
+// segment and the playlist ends, until the next Write starts it again
+// after a discontinuity.
+func (p *Packager) Break() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cut()
+	p.frames = mp3.Analyzer{}
+	p.adts = aac.Splitter{}
+	p.ended = len(p.segments) > 0
+	p.notify()
+}
+
+// Segment returns the segment numbered seq if it is still in the window.
+func (p *Packager) Segment(seq int64) (Segment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.segments) == 0 {
+		return Segment{}, false
+	}
+	i := seq - p.segments[0].Seq
+	if i < 0 || i >= int64(len(p.segments)) {
+		return Segment{}, false
+	}
+	return p.segments[i], true
+}
+
+// Playlist returns the live playlist, with segment URIs of base followed
+// by the sequence number and extension, and whether there is anything in
+// it yet.
+func (p *Packager) Playlist(base string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.segments) == 0 {
+		return "", false
+	}
+	target := int(math.Ceil(p.target.Seconds()))
+	for _, s := range p.segments {
+		// Segments run over the target by up to a frame; what counts is
+		// each one's duration rounded to the nearest second.
+		if d := int(math.Round(s.Duration.Seconds())); d > target {
+			target = d
+		}
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.segments[0].Seq)
+	if p.discSeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.discSeq)
+	}
+	for _, s := range p.segments {
+		if s.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s%s%s\n", s.Duration.Seconds(), base, strconv.FormatInt(s.Seq, 10), p.format.Ext())
+	}
+	if p.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String(), true
+}
+
+// ticks converts stream time to the 90 kHz clock of MPEG timestamps,
+// which wrap at 33 bits.
+func ticks(d time.Duration) uint64 {
+	s, ns := int64(d/time.Second), int64(d%time.Second)
+	return uint64(s*90000+ns*90000/int64(time.Second)) & (1<<33 - 1)
+}
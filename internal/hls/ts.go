@@ -0,0 +1,168 @@
+package hls
+
+import (
+	"encoding/binary"
+	"nickcast/internal/mp3"
+)
+
+const (
+	tsPacketSize = 188
+	tsPayload    = tsPacketSize - 4
+
+	pmtPID   = 0x1000
+	audioPID = 0x101
+
+	// maxPES is how much audio goes in one PES packet: about a fifth of a
+	// second at 128 kbit/s, so the headers don't add much to each frame.
+	maxPES = 16 * tsPayload
+)
+
+// mpegAudioType is the MPEG-TS stream type for the frames' MPEG version.
+func mpegAudioType(h mp3.Header) byte {
+	if h.Version == 1 {
+		return 0x03 // ISO/IEC 11172-3
+	}
+	return 0x04 // ISO/IEC 13818-3
+}
+
+// tsMuxer writes a single audio stream as MPEG-TS. Its continuity
+// counters carry on from segment to segment, as players expect of a live
+// stream.
+type tsMuxer struct {
+	streamType byte
+	cc         map[uint16]byte
+}
+
+// tables appends a PAT and a PMT to b, which every segment starts with so
+// it can be played on its own.
+func (t *tsMuxer) tables(b []byte) []byte {
+	pat := []byte{
+		0x00,       // table_id: program_association_section
+		0xB0, 0x0D, // section_syntax_indicator, length 13
+		0x00, 0x01, // transport_stream_id
+		0xC1,       // version 0, current
+		0x00, 0x00, // section 0 of 0
+		0x00, 0x01, // program 1...
+		0xE0 | pmtPID>>8, pmtPID & 0xFF, // ...has its PMT here
+	}
+	b = t.section(b, 0, pat)
+	pmt := []byte{
+		0x02,       // table_id: TS_program_map_section
+		0xB0, 0x12, // section_syntax_indicator, length 18
+		0x00, 0x01, // program 1
+		0xC1,       // version 0, current
+		0x00, 0x00, // section 0 of 0
+		0xE0 | audioPID>>8, audioPID & 0xFF, // PCR_PID
+		0xF0, 0x00, // no program info
+		t.streamType, 0xE0 | audioPID>>8, audioPID & 0xFF, 0xF0, 0x00,
+	}
+	return t.section(b, pmtPID, pmt)
+}
+
+// section appends a PSI section, with its CRC, as one packet.
+func (t *tsMuxer) section(b []byte, pid uint16, s []byte) []byte {
+	payload := append([]byte{0}, s...) // pointer_field
+	payload = binary.BigEndian.AppendUint32(payload, crc32MPEG(s))
+	for len(payload) < tsPayload {
+		payload = append(payload, 0xFF)
+	}
+	return t.packet(b, pid, true, -1, payload)
+}
+
+// pes appends a PES packet of frames starting at pts, with the stream's
+// clock reference in its first TS packet.
+func (t *tsMuxer) pes(b []byte, frames []byte, pts uint64) []byte {
+	hdr := []byte{0x00, 0x00, 0x01, 0xC0, 0, 0, 0x80, 0x80, 5}
+	if n := 3 + 5 + len(frames); n <= 0xFFFF {
+		binary.BigEndian.PutUint16(hdr[4:], uint16(n))
+	}
+	hdr = append(hdr,
+		byte(0x21|(pts>>29)&0x0E),
+		byte(pts>>22), byte(0x01|(pts>>14)&0xFE),
+		byte(pts>>7), byte(0x01|(pts<<1)&0xFE))
+	data := append(hdr, frames...)
+	pcr := int64(pts)
+	start := true
+	for len(data) > 0 {
+		n := fits(pcr, len(data))
+		b = t.packet(b, audioPID, start, pcr, data[:n])
+		data = data[n:]
+		start, pcr = false, -1
+	}
+	return b
+}
+
+// fits returns how much of n bytes of payload one packet takes.
+func fits(pcr int64, n int) int {
+	room := tsPayload
+	if pcr >= 0 {
+		room -= 8 // adaptation field length, flags and PCR
+	}
+	if n < room {
+		return n
+	}
+	return room
+}
+
+// packet appends one TS packet carrying payload, which must fit, padding
+// it out with adaptation field stuffing. pcr is the clock reference in
+// 90 kHz ticks, or -1 for none.
+func (t *tsMuxer) packet(b []byte, pid uint16, start bool, pcr int64, payload []byte) []byte {
+	if t.cc == nil {
+		t.cc = make(map[uint16]byte)
+	}
+	n := len(payload)
+	afc := byte(0x10) // payload only
+	var af []byte
+	if pcr >= 0 {
+		p := uint64(pcr)
+		af = []byte{0x50, // random access, PCR
+			byte(p >> 25), byte(p >> 17), byte(p >> 9), byte(p >> 1),
+			byte(p<<7) | 0x7E, 0x00}
+	}
+	if fill := tsPayload - n; fill > 0 {
+		afc = 0x30 // adaptation field and payload
+		switch {
+		case len(af) > 0:
+			for len(af)+1 < fill {
+				af = append(af, 0xFF)
+			}
+		case fill == 1:
+			// Just the length byte.
+		default:
+			af = append(af, 0x00)
+			for len(af)+1 < fill {
+				af = append(af, 0xFF)
+			}
+		}
+	}
+	cc := t.cc[pid]
+	t.cc[pid] = (cc + 1) & 0x0F
+	flags := byte(pid>>8) & 0x1F
+	if start {
+		flags |= 0x40
+	}
+	b = append(b, 0x47, flags, byte(pid), afc|cc)
+	if afc == 0x30 {
+		b = append(b, byte(len(af)))
+		b = append(b, af...)
+	}
+	return append(b, payload...)
+}
+
+// crc32MPEG is the CRC-32 of MPEG-2 sections: polynomial 0x04C11DB7,
+// not reflected, with no final XOR.
+func crc32MPEG(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, c := range b {
+		crc ^= uint32(c) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,178 @@
+// Package i18n translates the messages nickcast shows listeners: error
+// replies, pages and the notices it puts in stream titles. Messages are
+// looked up by key in per-language catalogs, with {name} placeholders
+// filled in, and fall back to the built-in English ones.
+//
+// A catalog is a file named after its language tag (de.txt, pt-br.txt)
+// holding lines of the form
+//
+//	key = message
+//
+// Blank lines and lines starting with # are ignored.
+package i18n
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Catalogs holds the messages for every language it knows.
+type Catalogs struct {
+	base  string // the built-in language
+	langs map[string]map[string]string
+}
+
+// New returns catalogs with messages, in language base, as the fallback
+// for every key.
+func New(base string, messages map[string]string) *Catalogs {
+	base = strings.ToLower(base)
+	return &Catalogs{base: base, langs: map[string]map[string]string{base: messages}}
+}
+
+// LoadDir adds a catalog for each *.txt file in dir. A catalog for the
+// built-in language overrides its messages.
+func (c *Catalogs) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(f), ".txt"))
+		msgs, err := readCatalog(f)
+		if err != nil {
+			return err
+		}
+		if lang == c.base {
+			merged := make(map[string]string, len(c.langs[lang]))
+			for k, v := range c.langs[lang] {
+				merged[k] = v
+			}
+			for k, v := range msgs {
+				merged[k] = v
+			}
+			msgs = merged
+		}
+		c.langs[lang] = msgs
+	}
+	return nil
+}
+
+func readCatalog(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	msgs := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, msg, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = message", path, n)
+		}
+		msgs[strings.TrimSpace(key)] = strings.TrimSpace(msg)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// Languages lists the languages there are catalogs for.
+func (c *Catalogs) Languages() []string {
+	list := make([]string, 0, len(c.langs))
+	for lang := range c.langs {
+		list = append(list, lang)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Has reports whether there is a catalog for lang.
+func (c *Catalogs) Has(lang string) bool {
+	_, ok := c.langs[strings.ToLower(lang)]
+	return ok
+}
+
+// Negotiate picks the language to answer an Accept-Language header in:
+// the one the client prefers most among those with a catalog, trying
+// "pt" for "pt-BR" too, or fallback if there is none.
+func (c *Catalogs) Negotiate(acceptLanguage, fallback string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v := strings.TrimSpace(params); strings.HasPrefix(v, "q=") {
+			if f, err := strconv.ParseFloat(v[2:], 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{tag, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if p.tag == "*" {
+			return fallback
+		}
+		if c.Has(p.tag) {
+			return p.tag
+		}
+		if i := strings.Index(p.tag, "-"); i > 0 && c.Has(p.tag[:i]) {
+			return p.tag[:i]
+		}
+	}
+	return fallback
+}
+
+// T returns the message for key in lang, with each {name} replaced by the
+// value following name in args. A key missing from lang's catalog is
+// looked up in its parent language's ("pt" for "pt-br") and then the base
+// language's; one missing everywhere comes back as it is.
+func (c *Catalogs) T(lang, key string, args ...string) string {
+	msg, ok := c.lookup(strings.ToLower(lang), key)
+	if !ok {
+		msg = key
+	}
+	if len(args) < 2 {
+		return msg
+	}
+	pairs := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, "{"+args[i]+"}", args[i+1])
+	}
+	return strings.NewReplacer(pairs...).Replace(msg)
+}
+
+func (c *Catalogs) lookup(lang, key string) (string, bool) {
+	for lang != "" {
+		if msg, ok := c.langs[lang][key]; ok {
+			return msg, true
+		}
+		i := strings.LastIndex(lang, "-")
+		if i < 0 {
+			break
+		}
+		lang = lang[:i]
+	}
+	msg, ok := c.langs[c.base][key]
+	return msg, ok
+}
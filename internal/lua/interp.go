@@ -0,0 +1,610 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// maxCallDepth bounds Lua call nesting, so runaway recursion is a
+	// "stack overflow" error rather than a crash.
+	maxCallDepth = 200
+
+	// maxStringSize bounds the strings a script can build, so a loop that
+	// doubles a string runs out of room long before the server does.
+	maxStringSize = 16 << 20
+
+	// checkEvery is how many steps run between checks of the context.
+	checkEvery = 1024
+)
+
+// State is one Lua interpreter: its globals and the script loaded into
+// them. A State is not safe for concurrent use.
+type State struct {
+	Globals *Table
+
+	// Print receives each line the script prints.
+	Print func(string)
+
+	strings *Table // the string library, which strings index into
+	ctx     context.Context
+	steps   int
+	depth   int
+	chunk   string
+	line    int
+}
+
+// scope holds the locals declared by one local statement, or a function's
+// parameters. Each declaration gets its own scope, so a closure keeps
+// seeing the variable it captured even if a later one shadows it.
+type scope struct {
+	parent  *scope
+	names   []string
+	vals    []Value
+	fn      bool    // a function's outermost scope
+	varargs []Value // when fn is set
+}
+
+func (sc *scope) lookup(name string) *Value {
+	for ; sc != nil; sc = sc.parent {
+		for i := len(sc.names) - 1; i >= 0; i-- {
+			if sc.names[i] == name {
+				return &sc.vals[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (sc *scope) varargList() []Value {
+	for ; sc != nil; sc = sc.parent {
+		if sc.fn {
+			return sc.varargs
+		}
+	}
+	return nil
+}
+
+// Control flow out of a block.
+const (
+	ctrlNone = iota
+	ctrlBreak
+	ctrlReturn
+)
+
+// New returns a State with the standard library loaded: the base
+// functions, string, table and math. There is no io, os or package
+// library, so scripts can't reach outside the interpreter.
+func New() *State {
+	s := &State{Globals: NewTable(), Print: func(string) {}, ctx: context.Background()}
+	openLibs(s)
+	return s
+}
+
+// Global returns the global variable name.
+func (s *State) Global(name string) Value {
+	return s.Globals.Get(name)
+}
+
+// SetGlobal sets the global variable name.
+func (s *State) SetGlobal(name string, v Value) {
+	s.Globals.Set(name, v)
+}
+
+// DoString compiles and runs a chunk. chunk names it in error messages.
+func (s *State) DoString(ctx context.Context, chunk, src string) error {
+	proto, err := parse(chunk, src)
+	if err != nil {
+		return err
+	}
+	fn := &Function{Name: chunk, proto: proto}
+	_, err = s.Call(ctx, fn)
+	return err
+}
+
+// Call calls fn with args, returning its results or the error it raised.
+// When ctx is done the script is stopped at its next step.
+func (s *State) Call(ctx context.Context, fn Value, args ...Value) (rets []Value, err error) {
+	saved := s.ctx
+	s.ctx, s.steps = ctx, 0
+	defer func() {
+		s.ctx = saved
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return s.call(fn, args), nil
+}
+
+// errorf raises a runtime error at the current line.
+func (s *State) errorf(format string, args ...interface{}) {
+	panic(&Error{Value: s.where() + fmt.Sprintf(format, args...)})
+}
+
+func (s *State) where() string {
+	if s.chunk == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d: ", s.chunk, s.line)
+}
+
+// tick counts a step, stopping the script once its context is done.
+func (s *State) tick() {
+	s.steps++
+	if s.steps%checkEvery == 0 {
+		if err := s.ctx.Err(); err != nil {
+			panic(&Error{Value: s.where() + "script interrupted: " + err.Error(), interrupt: true})
+		}
+	}
+}
+
+func (s *State) call(fv Value, args []Value) []Value {
+	f, ok := fv.(*Function)
+	if !ok {
+		s.errorf("attempt to call a %s value", TypeName(fv))
+	}
+	s.tick()
+	if s.depth >= maxCallDepth {
+		s.errorf("stack overflow")
+	}
+	s.depth++
+	chunk, line := s.chunk, s.line
+	defer func() {
+		s.depth--
+		s.chunk, s.line = chunk, line
+	}()
+	if f.fn != nil {
+		return f.fn(s, args)
+	}
+	p := f.proto
+	if p.chunk != "" {
+		s.chunk = p.chunk
+	}
+	sc := &scope{parent: f.env, names: p.params, vals: make([]Value, len(p.params)), fn: true}
+	copy(sc.vals, args)
+	if p.vararg && len(args) > len(p.params) {
+		sc.varargs = args[len(p.params):]
+	}
+	_, rets, _ := s.execBlock(p.body, sc)
+	return rets
+}
+
+// execBlock runs a block's statements, returning how control left it and
+// the innermost scope it declared, which repeat's condition can see.
+func (s *State) execBlock(body []stmt, sc *scope) (ctrl int, rets []Value, end *scope) {
+	s.tick() // even an empty loop body counts
+	for _, st := range body {
+		s.tick()
+		switch st := st.(type) {
+		case *localStmt:
+			s.line = st.line
+			vals := s.evalList(st.exprs, sc, len(st.names))
+			sc = &scope{parent: sc, names: st.names, vals: vals}
+		case *localFuncStmt:
+			sc = &scope{parent: sc, names: []string{st.name}, vals: make([]Value, 1)}
+			sc.vals[0] = &Function{Name: st.name, proto: st.proto, env: sc}
+		case *assignStmt:
+			s.line = st.line
+			s.assign(st, sc)
+		case *callStmt:
+			s.evalMulti(st.call, sc)
+		case *doStmt:
+			if ctrl, rets, _ := s.execBlock(st.body, sc); ctrl != ctrlNone {
+				return ctrl, rets, sc
+			}
+		case *whileStmt:
+			for s.line = st.line; Truthy(s.eval(st.cond, sc)); s.line = st.line {
+				ctrl, rets, _ := s.execBlock(st.body, sc)
+				if ctrl == ctrlBreak {
+					break
+				}
+				if ctrl == ctrlReturn {
+					return ctrl, rets, sc
+				}
+			}
+		case *repeatStmt:
+			for s.line = st.line; ; s.line = st.line {
+				ctrl, rets, inner := s.execBlock(st.body, sc)
+				if ctrl == ctrlBreak {
+					break
+				}
+				if ctrl == ctrlReturn {
+					return ctrl, rets, sc
+				}
+				if Truthy(s.eval(st.cond, inner)) {
+					break
+				}
+			}
+		case *ifStmt:
+			block := st.els
+			for i, cond := range st.conds {
+				if Truthy(s.eval(cond, sc)) {
+					block = st.blocks[i]
+					break
+				}
+			}
+			if ctrl, rets, _ := s.execBlock(block, sc); ctrl != ctrlNone {
+				return ctrl, rets, sc
+			}
+		case *numForStmt:
+			if ctrl, rets := s.numFor(st, sc); ctrl == ctrlReturn {
+				return ctrl, rets, sc
+			}
+		case *genForStmt:
+			if ctrl, rets := s.genFor(st, sc); ctrl == ctrlReturn {
+				return ctrl, rets, sc
+			}
+		case *returnStmt:
+			s.line = st.line
+			// A lone call is returned as is: no tail calls, but no copy.
+			if len(st.exprs) == 1 {
+				return ctrlReturn, s.evalMulti(st.exprs[0], sc), sc
+			}
+			return ctrlReturn, s.evalList(st.exprs, sc, -1), sc
+		case *breakStmt:
+			return ctrlBreak, nil, sc
+		}
+	}
+	return ctrlNone, nil, sc
+}
+
+func (s *State) numFor(st *numForStmt, sc *scope) (int, []Value) {
+	s.line = st.line
+	num := func(e expr, what string) float64 {
+		n, ok := toNumber(s.eval(e, sc))
+		if !ok {
+			s.errorf("'for' %s value must be a number", what)
+		}
+		return n
+	}
+	i, stop, step := num(st.start, "initial"), num(st.stop, "limit"), 1.0
+	if st.step != nil {
+		step = num(st.step, "step")
+	}
+	if step == 0 {
+		s.errorf("'for' step is zero")
+	}
+	for ; step > 0 && i <= stop || step < 0 && i >= stop; i += step {
+		inner := &scope{parent: sc, names: []string{st.name}, vals: []Value{i}}
+		ctrl, rets, _ := s.execBlock(st.body, inner)
+		if ctrl != ctrlNone {
+			return ctrl, rets
+		}
+	}
+	return ctrlNone, nil
+}
+
+func (s *State) genFor(st *genForStmt, sc *scope) (int, []Value) {
+	s.line = st.line
+	init := s.evalList(st.exprs, sc, 3)
+	f, state, control := init[0], init[1], init[2]
+	for {
+		s.line = st.line
+		rets := s.call(f, []Value{state, control})
+		if len(rets) == 0 || rets[0] == nil {
+			return ctrlNone, nil
+		}
+		control = rets[0]
+		vals := make([]Value, len(st.names))
+		copy(vals, rets)
+		inner := &scope{parent: sc, names: st.names, vals: vals}
+		ctrl, rets, _ := s.execBlock(st.body, inner)
+		if ctrl == ctrlBreak {
+			return ctrlNone, nil
+		}
+		if ctrl == ctrlReturn {
+			return ctrl, rets
+		}
+	}
+}
+
+func (s *State) assign(st *assignStmt, sc *scope) {
+	vals := s.evalList(st.exprs, sc, len(st.targets))
+	for i, t := range st.targets {
+		switch t := t.(type) {
+		case *nameExpr:
+			if v := sc.lookup(t.name); v != nil {
+				*v = vals[i]
+			} else {
+				s.Globals.Set(t.name, vals[i])
+			}
+		case *indexExpr:
+			obj := s.eval(t.obj, sc)
+			key := s.eval(t.key, sc)
+			tbl, ok := obj.(*Table)
+			if !ok {
+				s.errorf("attempt to index a %s value%s", TypeName(obj), describe(t.obj, sc))
+			}
+			s.setIndex(tbl, key, vals[i])
+		}
+	}
+}
+
+func (s *State) setIndex(t *Table, key, val Value) {
+	switch k := key.(type) {
+	case nil:
+		s.errorf("index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			s.errorf("index is NaN")
+		}
+	}
+	t.Set(key, val)
+}
+
+// describe names the variable or field e reads, for error messages.
+func describe(e expr, sc *scope) string {
+	switch e := e.(type) {
+	case *nameExpr:
+		if sc.lookup(e.name) != nil {
+			return fmt.Sprintf(" (local '%s')", e.name)
+		}
+		return fmt.Sprintf(" (global '%s')", e.name)
+	case *indexExpr:
+		if k, ok := e.key.(*constExpr); ok {
+			if name, ok := k.v.(string); ok {
+				return fmt.Sprintf(" (field '%s')", name)
+			}
+		}
+	case *methodExpr:
+		return fmt.Sprintf(" (method '%s')", e.name)
+	}
+	return ""
+}
+
+func (s *State) index(obj, key Value, e expr, sc *scope) Value {
+	switch o := obj.(type) {
+	case *Table:
+		return o.Get(key)
+	case string:
+		return s.strings.Get(key)
+	}
+	s.errorf("attempt to index a %s value%s", TypeName(obj), describe(e, sc))
+	return nil
+}
+
+// evalList evaluates a list of expressions, expanding the last one if it's
+// a call or ..., and pads or truncates the result to want values unless
+// want is negative.
+func (s *State) evalList(es []expr, sc *scope, want int) []Value {
+	var vals []Value
+	if want >= 0 {
+		vals = make([]Value, 0, want)
+	}
+	for i, e := range es {
+		if i == len(es)-1 {
+			vals = append(vals, s.evalMulti(e, sc)...)
+		} else {
+			vals = append(vals, s.eval(e, sc))
+		}
+	}
+	if want >= 0 {
+		for len(vals) < want {
+			vals = append(vals, nil)
+		}
+		vals = vals[:want]
+	}
+	return vals
+}
+
+// evalMulti evaluates e keeping every value a call or ... produces.
+func (s *State) evalMulti(e expr, sc *scope) []Value {
+	switch e := e.(type) {
+	case *callExpr:
+		fn := s.eval(e.fn, sc)
+		args := s.evalList(e.args, sc, -1)
+		s.line = e.line
+		if _, ok := fn.(*Function); !ok {
+			s.errorf("attempt to call a %s value%s", TypeName(fn), describe(e.fn, sc))
+		}
+		return s.call(fn, args)
+	case *methodExpr:
+		obj := s.eval(e.obj, sc)
+		s.line = e.line
+		fn := s.index(obj, e.name, e.obj, sc)
+		args := append([]Value{obj}, s.evalList(e.args, sc, -1)...)
+		s.line = e.line
+		if _, ok := fn.(*Function); !ok {
+			s.errorf("attempt to call a %s value%s", TypeName(fn), describe(e, sc))
+		}
+		return s.call(fn, args)
+	case *varargExpr:
+		return append([]Value(nil), sc.varargList()...)
+	}
+	return []Value{s.eval(e, sc)}
+}
+
+func (s *State) eval(e expr, sc *scope) Value {
+	switch e := e.(type) {
+	case *constExpr:
+		return e.v
+	case *nameExpr:
+		if v := sc.lookup(e.name); v != nil {
+			return *v
+		}
+		return s.Globals.Get(e.name)
+	case *indexExpr:
+		obj := s.eval(e.obj, sc)
+		key := s.eval(e.key, sc)
+		s.line = e.line
+		return s.index(obj, key, e.obj, sc)
+	case *callExpr, *methodExpr, *varargExpr:
+		if vals := s.evalMulti(e, sc); len(vals) > 0 {
+			return vals[0]
+		}
+		return nil
+	case *funcExpr:
+		return &Function{Name: e.proto.name, proto: e.proto, env: sc}
+	case *parenExpr:
+		return s.eval(e.e, sc)
+	case *andExpr:
+		if l := s.eval(e.l, sc); !Truthy(l) {
+			return l
+		}
+		return s.eval(e.r, sc)
+	case *orExpr:
+		if l := s.eval(e.l, sc); Truthy(l) {
+			return l
+		}
+		return s.eval(e.r, sc)
+	case *tableExpr:
+		return s.tableConstructor(e, sc)
+	case *unaryExpr:
+		v := s.eval(e.e, sc)
+		s.line = e.line
+		return s.unary(e.op, v, e.e, sc)
+	case *binaryExpr:
+		l := s.eval(e.l, sc)
+		r := s.eval(e.r, sc)
+		s.line = e.line
+		return s.binary(e.op, l, r)
+	}
+	panic(fmt.Sprintf("lua: unknown expression %T", e))
+}
+
+func (s *State) tableConstructor(e *tableExpr, sc *scope) *Table {
+	t := NewTable()
+	n := 0
+	for i, item := range e.items {
+		if item.key != nil {
+			k := s.eval(item.key, sc)
+			v := s.eval(item.val, sc)
+			s.line = e.line
+			s.setIndex(t, k, v)
+			continue
+		}
+		var vals []Value
+		if i == len(e.items)-1 {
+			vals = s.evalMulti(item.val, sc)
+		} else {
+			vals = []Value{s.eval(item.val, sc)}
+		}
+		for _, v := range vals {
+			n++
+			t.Set(float64(n), v)
+		}
+	}
+	return t
+}
+
+func (s *State) unary(op int, v Value, e expr, sc *scope) Value {
+	switch op {
+	case tokNot:
+		return !Truthy(v)
+	case '-':
+		n, ok := toNumber(v)
+		if !ok {
+			s.errorf("attempt to perform arithmetic on a %s value%s", TypeName(v), describe(e, sc))
+		}
+		return -n
+	case '#':
+		switch v := v.(type) {
+		case string:
+			return float64(len(v))
+		case *Table:
+			return float64(v.Len())
+		}
+		s.errorf("attempt to get length of a %s value%s", TypeName(v), describe(e, sc))
+	}
+	return nil
+}
+
+func (s *State) binary(op int, l, r Value) Value {
+	switch op {
+	case tokEq:
+		return l == r
+	case tokNe:
+		return l != r
+	case '<':
+		return s.less(l, r, false)
+	case tokLe:
+		return s.less(l, r, true)
+	case '>':
+		return s.less(r, l, false)
+	case tokGe:
+		return s.less(r, l, true)
+	case tokConcat:
+		return s.concat(l, r)
+	}
+	return s.arith(op, l, r)
+}
+
+func (s *State) arith(op int, l, r Value) Value {
+	x, ok := toNumber(l)
+	if !ok {
+		s.errorf("attempt to perform arithmetic on a %s value", TypeName(l))
+	}
+	y, ok := toNumber(r)
+	if !ok {
+		s.errorf("attempt to perform arithmetic on a %s value", TypeName(r))
+	}
+	switch op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	case '/':
+		return x / y
+	case tokIDiv:
+		return math.Floor(x / y)
+	case '%':
+		m := math.Mod(x, y)
+		if m != 0 && (m < 0) != (y < 0) {
+			m += y
+		}
+		return m
+	case '^':
+		return math.Pow(x, y)
+	}
+	panic(fmt.Sprintf("lua: unknown operator %s", tokenName(op)))
+}
+
+func (s *State) less(l, r Value, orEqual bool) bool {
+	switch x := l.(type) {
+	case float64:
+		if y, ok := r.(float64); ok {
+			return x < y || orEqual && x == y
+		}
+	case string:
+		if y, ok := r.(string); ok {
+			return x < y || orEqual && x == y
+		}
+	}
+	if a, b := TypeName(l), TypeName(r); a == b {
+		s.errorf("attempt to compare two %s values", a)
+	} else {
+		s.errorf("attempt to compare %s with %s", a, b)
+	}
+	return false
+}
+
+func (s *State) concat(l, r Value) Value {
+	var b strings.Builder
+	for _, v := range []Value{l, r} {
+		switch v := v.(type) {
+		case string:
+			b.WriteString(v)
+		case float64:
+			b.WriteString(numberToString(v))
+		default:
+			s.errorf("attempt to concatenate a %s value", TypeName(v))
+		}
+		s.checkSize(b.Len())
+	}
+	return b.String()
+}
+
+// checkSize refuses to build a string longer than maxStringSize.
+func (s *State) checkSize(n int) {
+	if n > maxStringSize {
+		s.errorf("string length overflow")
+	}
+}
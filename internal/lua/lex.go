@@ -0,0 +1,374 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Token kinds. Single-character punctuation is its own byte value; the rest
+// start past the byte range.
+const (
+	tokEOF = iota + 256
+	tokName
+	tokNumber
+	tokString
+
+	// Keywords.
+	tokAnd
+	tokBreak
+	tokDo
+	tokElse
+	tokElseif
+	tokEnd
+	tokFalse
+	tokFor
+	tokFunction
+	tokIf
+	tokIn
+	tokLocal
+	tokNil
+	tokNot
+	tokOr
+	tokRepeat
+	tokReturn
+	tokThen
+	tokTrue
+	tokUntil
+	tokWhile
+
+	// Multi-character operators.
+	tokConcat // ..
+	tokDots   // ...
+	tokEq     // ==
+	tokNe     // ~=
+	tokLe     // <=
+	tokGe     // >=
+	tokIDiv   // //
+)
+
+var keywords = map[string]int{
+	"and": tokAnd, "break": tokBreak, "do": tokDo, "else": tokElse,
+	"elseif": tokElseif, "end": tokEnd, "false": tokFalse, "for": tokFor,
+	"function": tokFunction, "if": tokIf, "in": tokIn, "local": tokLocal,
+	"nil": tokNil, "not": tokNot, "or": tokOr, "repeat": tokRepeat,
+	"return": tokReturn, "then": tokThen, "true": tokTrue, "until": tokUntil,
+	"while": tokWhile,
+}
+
+var tokNames = map[int]string{
+	tokEOF: "<eof>", tokName: "<name>", tokNumber: "<number>", tokString: "<string>",
+	tokConcat: "..", tokDots: "...", tokEq: "==", tokNe: "~=", tokLe: "<=",
+	tokGe: ">=", tokIDiv: "//",
+}
+
+func tokenName(t int) string {
+	if t < 256 {
+		return string(rune(t))
+	}
+	if s, ok := tokNames[t]; ok {
+		return s
+	}
+	for k, v := range keywords {
+		if v == t {
+			return k
+		}
+	}
+	return "?"
+}
+
+type token struct {
+	kind int
+	line int
+	str  string  // names and strings
+	num  float64 // numbers
+}
+
+// lexer splits a chunk into tokens.
+type lexer struct {
+	chunk string
+	src   string
+	pos   int
+	line  int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) {
+	panic(&Error{Value: fmt.Sprintf("%s:%d: %s", l.chunk, l.line, fmt.Sprintf(format, args...))})
+}
+
+func (l *lexer) peekByte(off int) byte {
+	if l.pos+off < len(l.src) {
+		return l.src[l.pos+off]
+	}
+	return 0
+}
+
+func (l *lexer) next() token {
+	l.skipSpace()
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}
+	}
+	line := l.line
+	c := l.src[l.pos]
+	switch {
+	case isAlpha(c):
+		start := l.pos
+		for l.pos < len(l.src) && isAlnum(l.src[l.pos]) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		if k, ok := keywords[word]; ok {
+			return token{kind: k, line: line}
+		}
+		return token{kind: tokName, line: line, str: word}
+	case isDigit(c), c == '.' && isDigit(l.peekByte(1)):
+		return token{kind: tokNumber, line: line, num: l.number()}
+	case c == '"', c == '\'':
+		return token{kind: tokString, line: line, str: l.shortString(c)}
+	case c == '[' && (l.peekByte(1) == '[' || l.peekByte(1) == '='):
+		if s, ok := l.longBracket(); ok {
+			return token{kind: tokString, line: line, str: s}
+		}
+		l.pos++
+		return token{kind: '[', line: line}
+	}
+
+	two := ""
+	if l.pos+1 < len(l.src) {
+		two = l.src[l.pos : l.pos+2]
+	}
+	switch two {
+	case "..":
+		if l.peekByte(2) == '.' {
+			l.pos += 3
+			return token{kind: tokDots, line: line}
+		}
+		l.pos += 2
+		return token{kind: tokConcat, line: line}
+	case "==", "~=", "<=", ">=", "//":
+		l.pos += 2
+		return token{kind: map[string]int{"==": tokEq, "~=": tokNe, "<=": tokLe, ">=": tokGe, "//": tokIDiv}[two], line: line}
+	}
+	if strings.IndexByte("+-*/%^#<>=(){}[];:,.", c) >= 0 {
+		l.pos++
+		return token{kind: int(c), line: line}
+	}
+	l.errorf("unexpected symbol near '%c'", c)
+	return token{}
+}
+
+// skipSpace skips whitespace and comments.
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ', c == '\t', c == '\r', c == '\v', c == '\f':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-':
+			l.pos += 2
+			if l.peekByte(0) == '[' {
+				if _, ok := l.longBracket(); ok {
+					continue
+				}
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// longBracket reads a [[...]] or [==[...]==] string or comment starting at
+// l.pos. ok is false, with nothing consumed, if l.pos isn't at one.
+func (l *lexer) longBracket() (s string, ok bool) {
+	level := 0
+	for l.peekByte(1+level) == '=' {
+		level++
+	}
+	if l.peekByte(1+level) != '[' {
+		return "", false
+	}
+	l.pos += 2 + level
+	// A newline straight after the opening bracket is skipped.
+	if l.peekByte(0) == '\r' {
+		l.pos++
+	}
+	if l.peekByte(0) == '\n' {
+		l.line++
+		l.pos++
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		l.errorf("unfinished long string")
+	}
+	s = l.src[l.pos : l.pos+end]
+	l.line += strings.Count(s, "\n")
+	l.pos += end + len(closing)
+	return s, true
+}
+
+func (l *lexer) shortString(quote byte) string {
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			l.errorf("unfinished string")
+		}
+		c := l.src[l.pos]
+		if c == quote {
+			l.pos++
+			return b.String()
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			l.pos++
+			continue
+		}
+		l.pos++
+		c = l.peekByte(0)
+		l.pos++
+		switch c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"', '\'':
+			b.WriteByte(c)
+		case '\n':
+			l.line++
+			b.WriteByte('\n')
+		case 'x':
+			if l.pos+2 > len(l.src) {
+				l.errorf("hexadecimal digit expected")
+			}
+			n, err := strconv.ParseUint(l.src[l.pos:l.pos+2], 16, 8)
+			if err != nil {
+				l.errorf("hexadecimal digit expected")
+			}
+			b.WriteByte(byte(n))
+			l.pos += 2
+		case 'z':
+			for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+				if l.src[l.pos] == '\n' {
+					l.line++
+				}
+				l.pos++
+			}
+		case 'u':
+			end := strings.IndexByte(l.src[l.pos:], '}')
+			if l.peekByte(0) != '{' || end < 0 {
+				l.errorf("missing '{' or '}' in \\u{xxxx}")
+			}
+			n, err := strconv.ParseUint(l.src[l.pos+1:l.pos+end], 16, 32)
+			if err != nil || n > utf8.MaxRune {
+				l.errorf("UTF-8 value too large")
+			}
+			b.WriteRune(rune(n))
+			l.pos += end + 1
+		default:
+			if !isDigit(c) {
+				l.errorf("invalid escape sequence '\\%c'", c)
+			}
+			n := int(c - '0')
+			for i := 0; i < 2 && isDigit(l.peekByte(0)); i++ {
+				n = n*10 + int(l.src[l.pos]-'0')
+				l.pos++
+			}
+			if n > 255 {
+				l.errorf("decimal escape too large")
+			}
+			b.WriteByte(byte(n))
+		}
+	}
+}
+
+func (l *lexer) number() float64 {
+	start := l.pos
+	hex := l.src[l.pos] == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X')
+	if hex {
+		l.pos += 2
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if isAlnum(c) || c == '.' {
+			l.pos++
+		} else if (c == '+' || c == '-') && strings.IndexByte("eEpP", l.src[l.pos-1]) >= 0 &&
+			(hex == (l.src[l.pos-1]|0x20 == 'p')) {
+			l.pos++
+		} else {
+			break
+		}
+	}
+	n, ok := parseNumber(l.src[start:l.pos])
+	if !ok {
+		l.errorf("malformed number near '%s'", l.src[start:l.pos])
+	}
+	return n
+}
+
+// parseNumber converts a Lua numeral, decimal or hexadecimal, to a number.
+func parseNumber(s string) (float64, bool) {
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		if strings.ContainsAny(s, ".pP") {
+			if !strings.ContainsAny(s, "pP") {
+				s += "p0" // strconv insists on the exponent
+			}
+			n, err := strconv.ParseFloat(s, 64)
+			return n, err == nil
+		}
+		var n float64
+		for _, c := range s[2:] {
+			d := strings.IndexRune("0123456789abcdef", c|0x20)
+			if d < 0 {
+				return 0, false
+			}
+			n = n*16 + float64(d)
+		}
+		return n, true
+	}
+	// strconv also takes "inf", "nan" and underscores, which Lua doesn't.
+	digits := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isDigit(c):
+			digits = true
+		case c == '.', c == 'e', c == 'E':
+		case (c == '+' || c == '-') && i > 0 && (s[i-1] == 'e' || s[i-1] == 'E'):
+		default:
+			return 0, false
+		}
+	}
+	if !digits {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return n, true
+		}
+		return 0, false
+	}
+	return n, true
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' }
+func isAlnum(c byte) bool { return isAlpha(c) || isDigit(c) }
+func isSpace(c byte) bool { return c == ' ' || c >= '\t' && c <= '\r' }
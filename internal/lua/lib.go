@@ -0,0 +1,808 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func openLibs(s *State) {
+	g := s.Globals
+	reg := func(t *Table, name string, fn func(s *State, args []Value) []Value) {
+		t.Set(name, NewFunction(name, fn))
+	}
+
+	for name, fn := range map[string]func(*State, []Value) []Value{
+		"assert": baseAssert, "error": baseError, "ipairs": baseIpairs,
+		"next": baseNext, "pairs": basePairs, "pcall": basePcall,
+		"print": basePrint, "rawequal": baseRawequal, "rawget": baseRawget,
+		"rawlen": baseRawlen, "rawset": baseRawset, "select": baseSelect,
+		"tonumber": baseTonumber, "tostring": baseTostring, "type": baseType,
+		"unpack": tableUnpack,
+	} {
+		reg(g, name, fn)
+	}
+	g.Set("_G", g)
+	g.Set("_VERSION", "Lua 5.3")
+
+	str := NewTable()
+	for name, fn := range map[string]func(*State, []Value) []Value{
+		"byte": strByte, "char": strChar, "find": strFind, "format": strFormat,
+		"gmatch": strGmatch, "gsub": strGsub, "len": strLen, "lower": strLower,
+		"match": strMatch, "rep": strRep, "reverse": strReverse, "sub": strSub,
+		"upper": strUpper,
+	} {
+		reg(str, name, fn)
+	}
+	g.Set("string", str)
+	s.strings = str
+
+	tbl := NewTable()
+	for name, fn := range map[string]func(*State, []Value) []Value{
+		"concat": tableConcat, "insert": tableInsert, "remove": tableRemove,
+		"sort": tableSort, "unpack": tableUnpack,
+	} {
+		reg(tbl, name, fn)
+	}
+	g.Set("table", tbl)
+
+	m := NewTable()
+	for name, fn := range map[string]func(*State, []Value) []Value{
+		"abs": mathFunc("abs", math.Abs), "ceil": mathFunc("ceil", math.Ceil),
+		"floor": mathFunc("floor", math.Floor), "sqrt": mathFunc("sqrt", math.Sqrt),
+		"exp": mathFunc("exp", math.Exp), "fmod": mathFmod, "log": mathLog,
+		"max": mathMax, "min": mathMin, "random": mathRandom,
+		"tointeger": mathTointeger,
+	} {
+		reg(m, name, fn)
+	}
+	m.Set("huge", math.Inf(1))
+	m.Set("pi", math.Pi)
+	m.Set("maxinteger", float64(1<<53))
+	m.Set("mininteger", -float64(1<<53))
+	g.Set("math", m)
+}
+
+// Argument helpers. n is 1-based, as in Lua's error messages.
+
+func arg(args []Value, n int) Value {
+	if n <= len(args) {
+		return args[n-1]
+	}
+	return nil
+}
+
+func (s *State) argError(n int, fname, msg string) {
+	s.errorf("bad argument #%d to '%s' (%s)", n, fname, msg)
+}
+
+func (s *State) checkAny(args []Value, n int, fname string) Value {
+	if n > len(args) {
+		s.argError(n, fname, "value expected")
+	}
+	return args[n-1]
+}
+
+func (s *State) checkTable(args []Value, n int, fname string) *Table {
+	t, ok := arg(args, n).(*Table)
+	if !ok {
+		s.argError(n, fname, "table expected, got "+typeNameArg(args, n))
+	}
+	return t
+}
+
+func (s *State) checkString(args []Value, n int, fname string) string {
+	switch v := arg(args, n).(type) {
+	case string:
+		return v
+	case float64:
+		return numberToString(v)
+	}
+	s.argError(n, fname, "string expected, got "+typeNameArg(args, n))
+	return ""
+}
+
+func (s *State) checkNumber(args []Value, n int, fname string) float64 {
+	v, ok := toNumber(arg(args, n))
+	if !ok {
+		s.argError(n, fname, "number expected, got "+typeNameArg(args, n))
+	}
+	return v
+}
+
+func (s *State) checkInt(args []Value, n int, fname string) int {
+	v := arg(args, n)
+	i, ok := toInteger(v)
+	if !ok {
+		if _, isNum := toNumber(v); isNum {
+			s.argError(n, fname, "number has no integer representation")
+		}
+		s.argError(n, fname, "number expected, got "+typeNameArg(args, n))
+	}
+	if i > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if i < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int(i)
+}
+
+func (s *State) optInt(args []Value, n int, fname string, def int) int {
+	if arg(args, n) == nil {
+		return def
+	}
+	return s.checkInt(args, n, fname)
+}
+
+func typeNameArg(args []Value, n int) string {
+	if n > len(args) {
+		return "no value"
+	}
+	return TypeName(args[n-1])
+}
+
+// Base library.
+
+func baseAssert(s *State, args []Value) []Value {
+	if !Truthy(arg(args, 1)) {
+		if len(args) < 2 {
+			s.errorf("assertion failed!")
+		}
+		panic(&Error{Value: args[1]})
+	}
+	return args
+}
+
+func baseError(s *State, args []Value) []Value {
+	v := arg(args, 1)
+	level := s.optInt(args, 2, "error", 1)
+	if msg, ok := v.(string); ok && level > 0 {
+		v = s.where() + msg
+	}
+	panic(&Error{Value: v})
+}
+
+func baseIpairs(s *State, args []Value) []Value {
+	s.checkAny(args, 1, "ipairs")
+	iter := NewFunction("ipairs_iterator", func(s *State, args []Value) []Value {
+		i := s.checkNumber(args, 2, "ipairs") + 1
+		v := s.index(arg(args, 1), i, nil, nil)
+		if v == nil {
+			return []Value{nil}
+		}
+		return []Value{i, v}
+	})
+	return []Value{iter, args[0], 0.0}
+}
+
+func baseNext(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "next")
+	k, v, ok := t.Next(arg(args, 2))
+	if !ok {
+		s.errorf("invalid key to 'next'")
+	}
+	if k == nil {
+		return []Value{nil}
+	}
+	return []Value{k, v}
+}
+
+var nextFunction = NewFunction("next", baseNext)
+
+func basePairs(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "pairs")
+	return []Value{nextFunction, t, nil}
+}
+
+func basePcall(s *State, args []Value) (rets []Value) {
+	fn := s.checkAny(args, 1, "pcall")
+	depth, chunk, line := s.depth, s.chunk, s.line
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok || e.interrupt {
+				panic(r)
+			}
+			s.depth, s.chunk, s.line = depth, chunk, line
+			rets = []Value{false, e.Value}
+		}
+	}()
+	return append([]Value{true}, s.call(fn, args[1:])...)
+}
+
+func basePrint(s *State, args []Value) []Value {
+	parts := make([]string, len(args))
+	for i, v := range args {
+		parts[i] = ToString(v)
+	}
+	s.Print(strings.Join(parts, "\t"))
+	return nil
+}
+
+func baseRawequal(s *State, args []Value) []Value {
+	return []Value{s.checkAny(args, 1, "rawequal") == s.checkAny(args, 2, "rawequal")}
+}
+
+func baseRawget(s *State, args []Value) []Value {
+	return []Value{s.checkTable(args, 1, "rawget").Get(arg(args, 2))}
+}
+
+func baseRawlen(s *State, args []Value) []Value {
+	switch v := arg(args, 1).(type) {
+	case *Table:
+		return []Value{float64(v.Len())}
+	case string:
+		return []Value{float64(len(v))}
+	}
+	s.argError(1, "rawlen", "table or string expected")
+	return nil
+}
+
+func baseRawset(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "rawset")
+	s.setIndex(t, arg(args, 2), arg(args, 3))
+	return []Value{t}
+}
+
+func baseSelect(s *State, args []Value) []Value {
+	if arg(args, 1) == "#" {
+		return []Value{float64(len(args) - 1)}
+	}
+	n := s.checkInt(args, 1, "select")
+	switch {
+	case n < 0:
+		n += len(args)
+		if n < 1 {
+			s.argError(1, "select", "index out of range")
+		}
+	case n == 0:
+		s.argError(1, "select", "index out of range")
+	case n >= len(args):
+		return nil
+	}
+	return args[n:]
+}
+
+func baseTonumber(s *State, args []Value) []Value {
+	if arg(args, 2) == nil {
+		v := s.checkAny(args, 1, "tonumber")
+		if n, ok := toNumber(v); ok {
+			return []Value{n}
+		}
+		return []Value{nil}
+	}
+	base := s.checkInt(args, 2, "tonumber")
+	if base < 2 || base > 36 {
+		s.argError(2, "tonumber", "base out of range")
+	}
+	str := strings.ToLower(strings.TrimSpace(s.checkString(args, 1, "tonumber")))
+	neg := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(str, "-")
+	n, err := strconv.ParseUint(str, base, 64)
+	if err != nil || str == "" {
+		return []Value{nil}
+	}
+	if neg {
+		return []Value{-float64(n)}
+	}
+	return []Value{float64(n)}
+}
+
+func baseTostring(s *State, args []Value) []Value {
+	return []Value{ToString(s.checkAny(args, 1, "tostring"))}
+}
+
+func baseType(s *State, args []Value) []Value {
+	return []Value{TypeName(s.checkAny(args, 1, "type"))}
+}
+
+// String library.
+
+// strRange converts Lua's 1-based, possibly negative, inclusive i and j to
+// a Go slice range of a string of length n.
+func strRange(i, j, n int) (int, int) {
+	if i < 0 {
+		i = n + i + 1
+	}
+	if j < 0 {
+		j = n + j + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > n {
+		j = n
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
+
+func strByte(s *State, args []Value) []Value {
+	str := s.checkString(args, 1, "byte")
+	i := s.optInt(args, 2, "byte", 1)
+	from, to := strRange(i, s.optInt(args, 3, "byte", i), len(str))
+	var rets []Value
+	for _, c := range []byte(str[from:to]) {
+		rets = append(rets, float64(c))
+	}
+	return rets
+}
+
+func strChar(s *State, args []Value) []Value {
+	b := make([]byte, len(args))
+	for i := range args {
+		c := s.checkInt(args, i+1, "char")
+		if c < 0 || c > 255 {
+			s.argError(i+1, "char", "value out of range")
+		}
+		b[i] = byte(c)
+	}
+	return []Value{string(b)}
+}
+
+func strLen(s *State, args []Value) []Value {
+	return []Value{float64(len(s.checkString(args, 1, "len")))}
+}
+
+func strLower(s *State, args []Value) []Value {
+	return []Value{strings.ToLower(s.checkString(args, 1, "lower"))}
+}
+
+func strUpper(s *State, args []Value) []Value {
+	return []Value{strings.ToUpper(s.checkString(args, 1, "upper"))}
+}
+
+func strRep(s *State, args []Value) []Value {
+	str := s.checkString(args, 1, "rep")
+	n := s.checkInt(args, 2, "rep")
+	sep := ""
+	if arg(args, 3) != nil {
+		sep = s.checkString(args, 3, "rep")
+	}
+	if n <= 0 {
+		return []Value{""}
+	}
+	s.checkSize((len(str) + len(sep)) * n)
+	if sep == "" {
+		return []Value{strings.Repeat(str, n)}
+	}
+	return []Value{strings.Repeat(str+sep, n-1) + str}
+}
+
+func strReverse(s *State, args []Value) []Value {
+	b := []byte(s.checkString(args, 1, "reverse"))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return []Value{string(b)}
+}
+
+func strSub(s *State, args []Value) []Value {
+	str := s.checkString(args, 1, "sub")
+	from, to := strRange(s.optInt(args, 2, "sub", 1), s.optInt(args, 3, "sub", -1), len(str))
+	return []Value{str[from:to]}
+}
+
+func strFind(s *State, args []Value) []Value { return strFindAux(s, args, true) }
+
+func strMatch(s *State, args []Value) []Value { return strFindAux(s, args, false) }
+
+func strFindAux(s *State, args []Value, find bool) []Value {
+	fname := "match"
+	if find {
+		fname = "find"
+	}
+	src := s.checkString(args, 1, fname)
+	pat := s.checkString(args, 2, fname)
+	init := s.optInt(args, 3, fname, 1)
+	if init < 0 {
+		init = len(src) + init + 1
+	}
+	if init < 1 {
+		init = 1
+	}
+	if init > len(src)+1 {
+		return []Value{nil}
+	}
+	if find && (Truthy(arg(args, 4)) || noSpecials(pat)) {
+		if i := strings.Index(src[init-1:], pat); i >= 0 {
+			start := init + i
+			return []Value{float64(start), float64(start + len(pat) - 1)}
+		}
+		return []Value{nil}
+	}
+	anchor := strings.HasPrefix(pat, "^")
+	if anchor {
+		pat = pat[1:]
+	}
+	ms := &matchState{s: s, src: src, pat: pat}
+	for start := init - 1; start <= len(src); start++ {
+		ms.reset()
+		if end := ms.match(start, 0); end >= 0 {
+			if find {
+				return append([]Value{float64(start + 1), float64(end)}, ms.captures(-1, -1, false)...)
+			}
+			return ms.captures(start, end, true)
+		}
+		if anchor {
+			break
+		}
+	}
+	return []Value{nil}
+}
+
+func strGmatch(s *State, args []Value) []Value {
+	src := s.checkString(args, 1, "gmatch")
+	pat := s.checkString(args, 2, "gmatch")
+	pos, last := 0, -1
+	iter := NewFunction("gmatch_iterator", func(s *State, _ []Value) []Value {
+		ms := &matchState{s: s, src: src, pat: pat}
+		for ; pos <= len(src); pos++ {
+			ms.reset()
+			if end := ms.match(pos, 0); end >= 0 && end != last {
+				start := pos
+				pos, last = end, end
+				return ms.captures(start, end, true)
+			}
+		}
+		return []Value{nil}
+	})
+	return []Value{iter}
+}
+
+func strGsub(s *State, args []Value) []Value {
+	src := s.checkString(args, 1, "gsub")
+	pat := s.checkString(args, 2, "gsub")
+	repl := arg(args, 3)
+	switch repl.(type) {
+	case string, float64, *Table, *Function:
+	default:
+		s.argError(3, "gsub", "string/function/table expected, got "+typeNameArg(args, 3))
+	}
+	maxN := s.optInt(args, 4, "gsub", len(src)+1)
+	anchor := strings.HasPrefix(pat, "^")
+	if anchor {
+		pat = pat[1:]
+	}
+
+	ms := &matchState{s: s, src: src, pat: pat}
+	var b strings.Builder
+	pos, last, n := 0, -1, 0
+	for n < maxN {
+		ms.reset()
+		if end := ms.match(pos, 0); end >= 0 && end != last {
+			n++
+			ms.addValue(&b, pos, end, repl)
+			pos, last = end, end
+		} else if pos < len(src) {
+			b.WriteByte(src[pos])
+			pos++
+		} else {
+			break
+		}
+		s.checkSize(b.Len())
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[pos:])
+	s.checkSize(b.Len())
+	return []Value{b.String(), float64(n)}
+}
+
+// addValue appends the replacement for the match from s to e.
+func (ms *matchState) addValue(b *strings.Builder, s, e int, repl Value) {
+	var v Value
+	switch r := repl.(type) {
+	case *Function:
+		if rets := ms.s.call(r, ms.captures(s, e, true)); len(rets) > 0 {
+			v = rets[0]
+		}
+	case *Table:
+		v = r.Get(ms.captureValue(0, s, e))
+	default:
+		tmpl := ToString(r)
+		for i := 0; i < len(tmpl); i++ {
+			c := tmpl[i]
+			if c != '%' {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			switch {
+			case i < len(tmpl) && tmpl[i] == '%':
+				b.WriteByte('%')
+			case i < len(tmpl) && tmpl[i] == '0':
+				b.WriteString(ms.src[s:e])
+			case i < len(tmpl) && isDigit(tmpl[i]):
+				b.WriteString(ToString(ms.captureValue(int(tmpl[i]-'1'), s, e)))
+			default:
+				ms.s.errorf("invalid use of '%%' in replacement string")
+			}
+		}
+		return
+	}
+	switch v := v.(type) {
+	case nil, bool:
+		if !Truthy(v) {
+			b.WriteString(ms.src[s:e])
+			return
+		}
+	case string:
+		b.WriteString(v)
+		return
+	case float64:
+		b.WriteString(numberToString(v))
+		return
+	}
+	ms.s.errorf("invalid replacement value (a %s)", TypeName(v))
+}
+
+func strFormat(s *State, args []Value) []Value {
+	format := s.checkString(args, 1, "format")
+	var b strings.Builder
+	n := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (isDigit(format[i]) || format[i] == '.') {
+			i++
+		}
+		if i >= len(format) || i-start > 6 {
+			s.errorf("invalid format string to 'format'")
+		}
+		spec := "%" + format[start:i]
+		n++
+		switch verb := format[i]; verb {
+		case 'd', 'i':
+			v, ok := toInteger(arg(args, n))
+			if !ok {
+				if _, isNum := toNumber(arg(args, n)); isNum {
+					s.argError(n, "format", "number has no integer representation")
+				}
+				s.argError(n, "format", "number expected, got "+typeNameArg(args, n))
+			}
+			fmt.Fprintf(&b, spec+"d", v)
+		case 'x', 'X', 'o':
+			v, ok := toInteger(arg(args, n))
+			if !ok {
+				s.argError(n, "format", "number has no integer representation")
+			}
+			fmt.Fprintf(&b, spec+string(verb), v)
+		case 'c':
+			b.WriteByte(byte(s.checkInt(args, n, "format")))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			fmt.Fprintf(&b, spec+string(verb), s.checkNumber(args, n, "format"))
+		case 'a', 'A':
+			fmt.Fprintf(&b, spec+map[byte]string{'a': "x", 'A': "X"}[verb], s.checkNumber(args, n, "format"))
+		case 's':
+			fmt.Fprintf(&b, spec+"s", ToString(s.checkAny(args, n, "format")))
+		case 'q':
+			b.WriteString(quoteString(s.checkString(args, n, "format")))
+		default:
+			s.errorf("invalid option '%%%c' to 'format'", verb)
+		}
+		s.checkSize(b.Len())
+	}
+	return []Value{b.String()}
+}
+
+// quoteString quotes str so Lua can read it back, as %q does.
+func quoteString(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; {
+		case c == '"', c == '\\', c == '\n':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\r':
+			b.WriteString("\\r")
+		case c == 0:
+			if i+1 < len(str) && isDigit(str[i+1]) {
+				b.WriteString("\\000")
+			} else {
+				b.WriteString("\\0")
+			}
+		case c < 0x20 || c == 0x7f:
+			if i+1 < len(str) && isDigit(str[i+1]) {
+				fmt.Fprintf(&b, "\\%03d", c)
+			} else {
+				fmt.Fprintf(&b, "\\%d", c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Table library.
+
+func tableConcat(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "concat")
+	sep := ""
+	if arg(args, 2) != nil {
+		sep = s.checkString(args, 2, "concat")
+	}
+	i := s.optInt(args, 3, "concat", 1)
+	j := s.optInt(args, 4, "concat", t.Len())
+	var b strings.Builder
+	for k := i; k <= j; k++ {
+		switch v := t.Get(float64(k)).(type) {
+		case string:
+			b.WriteString(v)
+		case float64:
+			b.WriteString(numberToString(v))
+		default:
+			s.errorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		if k < j {
+			b.WriteString(sep)
+		}
+		s.checkSize(b.Len())
+	}
+	return []Value{b.String()}
+}
+
+func tableInsert(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "insert")
+	n := t.Len()
+	switch len(args) {
+	case 2:
+		t.Set(float64(n+1), args[1])
+	case 3:
+		pos := s.checkInt(args, 2, "insert")
+		if pos < 1 || pos > n+1 {
+			s.argError(2, "insert", "position out of bounds")
+		}
+		for i := n; i >= pos; i-- {
+			t.Set(float64(i+1), t.Get(float64(i)))
+		}
+		t.Set(float64(pos), args[2])
+	default:
+		s.errorf("wrong number of arguments to 'insert'")
+	}
+	return nil
+}
+
+func tableRemove(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "remove")
+	n := t.Len()
+	pos := s.optInt(args, 2, "remove", n)
+	if arg(args, 2) != nil && n+1 != pos && (pos < 1 || pos > n+1) {
+		s.argError(2, "remove", "position out of bounds")
+	}
+	v := t.Get(float64(pos))
+	for i := pos; i < n; i++ {
+		t.Set(float64(i), t.Get(float64(i+1)))
+	}
+	if pos <= n {
+		t.Set(float64(n), nil)
+	}
+	return []Value{v}
+}
+
+func tableSort(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "sort")
+	less := func(a, b Value) bool { return s.less(a, b, false) }
+	if fn := arg(args, 2); fn != nil {
+		if _, ok := fn.(*Function); !ok {
+			s.argError(2, "sort", "function expected, got "+typeNameArg(args, 2))
+		}
+		less = func(a, b Value) bool {
+			rets := s.call(fn, []Value{a, b})
+			return len(rets) > 0 && Truthy(rets[0])
+		}
+	}
+	vals := make([]Value, t.Len())
+	for i := range vals {
+		vals[i] = t.Get(float64(i + 1))
+	}
+	sort.SliceStable(vals, func(i, j int) bool { return less(vals[i], vals[j]) })
+	for i, v := range vals {
+		t.Set(float64(i+1), v)
+	}
+	return nil
+}
+
+func tableUnpack(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "unpack")
+	i := s.optInt(args, 2, "unpack", 1)
+	j := 0
+	if arg(args, 3) == nil {
+		j = t.Len()
+	} else {
+		j = s.checkInt(args, 3, "unpack")
+	}
+	if j-i >= 1<<16 {
+		s.errorf("too many results to unpack")
+	}
+	var rets []Value
+	for k := i; k <= j; k++ {
+		rets = append(rets, t.Get(float64(k)))
+	}
+	return rets
+}
+
+// Math library.
+
+func mathFunc(name string, f func(float64) float64) func(*State, []Value) []Value {
+	return func(s *State, args []Value) []Value {
+		return []Value{f(s.checkNumber(args, 1, name))}
+	}
+}
+
+func mathFmod(s *State, args []Value) []Value {
+	return []Value{math.Mod(s.checkNumber(args, 1, "fmod"), s.checkNumber(args, 2, "fmod"))}
+}
+
+func mathLog(s *State, args []Value) []Value {
+	x := s.checkNumber(args, 1, "log")
+	if arg(args, 2) == nil {
+		return []Value{math.Log(x)}
+	}
+	return []Value{math.Log(x) / math.Log(s.checkNumber(args, 2, "log"))}
+}
+
+func mathMax(s *State, args []Value) []Value {
+	m := s.checkNumber(args, 1, "max")
+	for i := 2; i <= len(args); i++ {
+		m = math.Max(m, s.checkNumber(args, i, "max"))
+	}
+	return []Value{m}
+}
+
+func mathMin(s *State, args []Value) []Value {
+	m := s.checkNumber(args, 1, "min")
+	for i := 2; i <= len(args); i++ {
+		m = math.Min(m, s.checkNumber(args, i, "min"))
+	}
+	return []Value{m}
+}
+
+func mathRandom(s *State, args []Value) []Value {
+	switch len(args) {
+	case 0:
+		return []Value{rand.Float64()}
+	case 1:
+		m := s.checkInt(args, 1, "random")
+		if m < 1 {
+			s.argError(1, "random", "interval is empty")
+		}
+		return []Value{float64(rand.Intn(m) + 1)}
+	}
+	lo, hi := s.checkInt(args, 1, "random"), s.checkInt(args, 2, "random")
+	if lo > hi {
+		s.argError(2, "random", "interval is empty")
+	}
+	return []Value{float64(lo + rand.Intn(hi-lo+1))}
+}
+
+func mathTointeger(s *State, args []Value) []Value {
+	if i, ok := toInteger(arg(args, 1)); ok {
+		if _, isNum := arg(args, 1).(float64); isNum {
+			return []Value{float64(i)}
+		}
+	}
+	return []Value{nil}
+}
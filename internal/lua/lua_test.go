@@ -0,0 +1,140 @@
+package lua
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// run runs src and returns what it printed, one line per print.
+func run(t *testing.T, ctx context.Context, src string) (string, error) {
+	t.Helper()
+	s := New()
+	var out []string
+	s.Print = func(line string) { out = append(out, line) }
+	err := s.DoString(ctx, "test.lua", src)
+	return strings.Join(out, "\n"), err
+}
+
+func TestPrograms(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"arithmetic", `print(1 + 2, 10 / 4, 7 // 2, -7 % 3, 2 ^ 10, -2 ^ 2, 0.1 + 0.2)`, "3\t2.5\t3\t2\t1024\t-4\t0.3"},
+		{"concat", `print("a" .. "b" .. 1, #"hello")`, "ab1\t5"},
+		{"logic", `print(not nil, not 0, nil and 1, false or "d", 1 and 2)`, "true\tfalse\tnil\td\t2"},
+		{"tables", `local t = {1, 2, 3, x = "y", [10] = 5} print(#t, t.x, t[10])`, "3\ty\t5"},
+		{"pairs order", `local k = {} for key in pairs({1, 2, a = 1}) do k[#k + 1] = tostring(key) end print(table.concat(k, ","))`, "1,2,a"},
+		{"recursion", `local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end print(fib(15))`, "610"},
+		{"closures per iteration", `local fs = {} for i = 1, 3 do fs[i] = function() return i end end print(fs[1](), fs[3]())`, "1\t3"},
+		{"shadowed upvalue", `local x = 1 local f = function() return x end local x = 2 print(f(), x)`, "1\t2"},
+		{"varargs", `local function f(...) local a, b = ... return select("#", ...), a, b end print(f(1, nil, 3))`, "3\t1\tnil"},
+		{"methods", `local o = {n = 0} function o:inc(k) self.n = self.n + (k or 1) return self end o:inc():inc(5) print(o.n)`, "6"},
+		{"repeat scope", `local i = 0 repeat local j = i i = i + 1 until j >= 3 print(i)`, "4"},
+		{"break", `local n = 0 while true do n = n + 1 if n == 5 then break end end print(n)`, "5"},
+		{"numeric for", `local s = "" for i = 10, 1, -3 do s = s .. i .. " " end print(s)`, "10 7 4 1 "},
+		{"long strings", "print([[a\nb]], [==[c]]d]==])", "a\nb\tc]]d"},
+		{"escapes", `print("\65\x42\u{43}\z
+		     D", #"\0")`, "ABCD\t1"},
+		{"find", `print(string.find("hello world", "o w"), ("a.b"):find(".", 1, true), ("abc"):find("b", -1))`, "5\t2\tnil"},
+		{"match", `print(("key=value"):match("(%w+)=(%w+)"), ("  trim  "):match("^%s*(.-)%s*$"))`, "key\ttrim"},
+		{"position capture", `print(("hello"):match("()ll()"))`, "3\t5"},
+		{"balance and frontier", `print(("x[a[b]]y"):match("%b[]"), (("THE (quick) fox"):gsub("%f[%a]%a+", "w")))`, "[a[b]]\tw (w) w"},
+		{"gsub", `print(("hello world"):gsub("o", "0"), ("abc"):gsub("%w", "%0%0"), ("hello"):gsub("l+", function(s) return #s end))`, "hell0 w0rld\taabbcc\the2o\t1"},
+		{"gsub table", `print(("$name is $age"):gsub("%$(%w+)", {name = "bob", age = 42}))`, "bob is 42\t2"},
+		{"gmatch", `local w = {} for k, v in ("a=1, b=2"):gmatch("(%w+)=(%w+)") do w[#w + 1] = k .. v end print(table.concat(w, " "))`, "a1 b2"},
+		{"format", `print(string.format("%5.2f|%d|%s|%x|%-4s|%q", 3.14159, 42, "hi", 255, "ab", "a\"b"))`, " 3.14|42|hi|ff|ab  |\"a\\\"b\""},
+		{"tonumber", `print(tonumber("0x10"), tonumber(" 12 "), tonumber("z", 36), tonumber("1e2"), tonumber("abc"))`, "16\t12\t35\t100\tnil"},
+		{"table library", `local a = {5, 2, 8, 1} table.sort(a) table.insert(a, 1, 0) table.insert(a, 9) print(table.concat(a, " "), table.remove(a), #a)`, "0 1 2 5 8 9\t9\t5"},
+		{"sort comparator", `local a = {"b", "c", "a"} table.sort(a, function(x, y) return x > y end) print(table.concat(a))`, "cba"},
+		{"pcall", `print(pcall(error, "boom", 0)) print(select(2, pcall(error, {code = 1})).code)`, "false\tboom\n1"},
+		{"error position", `print(pcall(function() error("boom") end))`, "false\ttest.lua:1: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := run(t, context.Background(), tt.src)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"syntax", "x = = 1", "test.lua:1: unexpected symbol near '='"},
+		{"unclosed", "if x then\n\nprint(1)", "'end' expected (to close 'if' at line 1)"},
+		{"break outside loop", "break", "break outside a loop"},
+		{"vararg outside vararg function", "function f() return ... end", "cannot use '...' outside a vararg function"},
+		{"index nil", "local a = {}\na.b.c = 1", "test.lua:2: attempt to index a nil value (field 'b')"},
+		{"call nil", "nosuch()", "attempt to call a nil value (global 'nosuch')"},
+		{"compare", "return 1 < 'x'", "attempt to compare number with string"},
+		{"arithmetic", "return {} + 1", "attempt to perform arithmetic on a table value"},
+		{"nan key", "local t = {} t[0/0] = 1", "index is NaN"},
+		{"stack overflow", "local function f() return f() + 1 end f()", "stack overflow"},
+		{"string size", `local s = "x" while true do s = s .. s end`, "string length overflow"},
+		{"rep size", `string.rep("x", 1e9)`, "string length overflow"},
+		{"no os", "os.exit()", "attempt to index a nil value (global 'os')"},
+		{"error value", "error('plain', 0)", "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := run(t, context.Background(), tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestInterrupt checks that a cancelled context stops a script, even one
+// that swallows errors with pcall or spins in the pattern matcher.
+func TestInterrupt(t *testing.T) {
+	for _, src := range []string{
+		"while true do end",
+		"while true do pcall(function() while true do end end) end",
+		`string.find(string.rep("a", 40), string.rep("a*", 40) .. "b")`,
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := run(t, ctx, src)
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), "interrupted") {
+			t.Errorf("%s: got error %v, want interrupted", src, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	s := New()
+	if err := s.DoString(context.Background(), "test.lua", `
+		function listener(l)
+			if l.ip:find("^192%.0%.2%.") and not l.account then
+				return false, "need an account"
+			end
+		end`); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data  map[string]interface{}
+		allow bool
+	}{
+		{map[string]interface{}{"ip": "192.0.2.7"}, false},
+		{map[string]interface{}{"ip": "192.0.2.7", "account": "dj"}, true},
+		{map[string]interface{}{"ip": "198.51.100.1"}, true},
+	}
+	for _, tt := range tests {
+		rets, err := s.Call(context.Background(), s.Global("listener"), ToValue(tt.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow := len(rets) == 0 || rets[0] != false; allow != tt.allow {
+			t.Errorf("%v: got allow %v, want %v", tt.data, allow, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package lua
+
+// The parser turns a chunk into a tree that the interpreter walks directly;
+// policy scripts are small and their hooks short, so there is no bytecode.
+
+// maxNesting bounds how deeply blocks and expressions may nest, so a
+// hostile chunk can't exhaust the Go stack while being parsed.
+const maxNesting = 200
+
+type expr interface{}
+
+type (
+	constExpr  struct{ v Value } // nil, booleans, numbers and strings
+	varargExpr struct{}
+	nameExpr   struct{ name string }
+	indexExpr  struct {
+		obj, key expr
+		line     int
+	}
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+	methodExpr struct {
+		obj  expr
+		name string
+		args []expr
+		line int
+	}
+	funcExpr  struct{ proto *funcProto }
+	parenExpr struct{ e expr } // truncates e to one value
+	tableExpr struct {
+		items []tableItem
+		line  int
+	}
+	binaryExpr struct {
+		op   int
+		l, r expr
+		line int
+	}
+	unaryExpr struct {
+		op   int
+		e    expr
+		line int
+	}
+	andExpr struct{ l, r expr }
+	orExpr  struct{ l, r expr }
+)
+
+// tableItem is one field of a table constructor; key is nil for
+// positional items.
+type tableItem struct {
+	key, val expr
+}
+
+type funcProto struct {
+	chunk  string
+	name   string
+	params []string
+	vararg bool
+	body   []stmt
+	line   int
+}
+
+type stmt interface{}
+
+type (
+	localStmt struct {
+		names []string
+		exprs []expr
+		line  int
+	}
+	assignStmt struct {
+		targets []expr
+		exprs   []expr
+		line    int
+	}
+	callStmt  struct{ call expr }
+	doStmt    struct{ body []stmt }
+	whileStmt struct {
+		cond expr
+		body []stmt
+		line int
+	}
+	repeatStmt struct {
+		body []stmt
+		cond expr
+		line int
+	}
+	ifStmt struct {
+		conds  []expr
+		blocks [][]stmt
+		els    []stmt
+	}
+	numForStmt struct {
+		name              string
+		start, stop, step expr
+		body              []stmt
+		line              int
+	}
+	genForStmt struct {
+		names []string
+		exprs []expr
+		body  []stmt
+		line  int
+	}
+	localFuncStmt struct {
+		name  string
+		proto *funcProto
+	}
+	returnStmt struct {
+		exprs []expr
+		line  int
+	}
+	breakStmt struct{}
+)
+
+type parser struct {
+	lex    *lexer
+	tok    token
+	ahead  token
+	peeked bool
+	depth  int
+	loops  int    // loops enclosing the current statement in its function
+	vararg []bool // whether each enclosing function takes ...
+}
+
+// parse parses a whole chunk into the body of its main function.
+func parse(chunk, src string) (proto *funcProto, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	p := &parser{lex: &lexer{chunk: chunk, src: src, line: 1}}
+	if len(src) > 0 && src[0] == '#' { // shebang line
+		for p.lex.pos < len(src) && src[p.lex.pos] != '\n' {
+			p.lex.pos++
+		}
+	}
+	p.advance()
+	p.vararg = []bool{true}
+	body := p.block()
+	p.check(tokEOF)
+	return &funcProto{chunk: chunk, name: "main chunk", vararg: true, body: body}, nil
+}
+
+func (p *parser) advance() {
+	if p.peeked {
+		p.tok, p.peeked = p.ahead, false
+		return
+	}
+	p.tok = p.lex.next()
+}
+
+func (p *parser) peek() token {
+	if !p.peeked {
+		p.ahead, p.peeked = p.lex.next(), true
+	}
+	return p.ahead
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	p.lex.line = p.tok.line
+	p.lex.errorf(format, args...)
+}
+
+func (p *parser) near() string {
+	switch p.tok.kind {
+	case tokName, tokString:
+		return p.tok.str
+	case tokNumber:
+		return numberToString(p.tok.num)
+	}
+	return tokenName(p.tok.kind)
+}
+
+func (p *parser) check(kind int) {
+	if p.tok.kind != kind {
+		p.errorf("'%s' expected near '%s'", tokenName(kind), p.near())
+	}
+}
+
+func (p *parser) expect(kind int) {
+	p.check(kind)
+	p.advance()
+}
+
+// match consumes a closing token, naming the opener on the same line as
+// Lua does when they're far apart.
+func (p *parser) match(closing, opening, line int) {
+	if p.tok.kind == closing {
+		p.advance()
+		return
+	}
+	if line == p.tok.line {
+		p.check(closing)
+	}
+	p.errorf("'%s' expected (to close '%s' at line %d) near '%s'",
+		tokenName(closing), tokenName(opening), line, p.near())
+}
+
+func (p *parser) name() string {
+	p.check(tokName)
+	s := p.tok.str
+	p.advance()
+	return s
+}
+
+func (p *parser) enter() {
+	p.depth++
+	if p.depth > maxNesting {
+		p.errorf("chunk has too many syntax levels")
+	}
+}
+
+func (p *parser) leave() { p.depth-- }
+
+func blockEnds(kind int) bool {
+	switch kind {
+	case tokEOF, tokEnd, tokElse, tokElseif, tokUntil:
+		return true
+	}
+	return false
+}
+
+func (p *parser) block() []stmt {
+	p.enter()
+	defer p.leave()
+	var body []stmt
+	for !blockEnds(p.tok.kind) {
+		if p.tok.kind == tokReturn {
+			body = append(body, p.retstat())
+			break
+		}
+		if s := p.statement(); s != nil {
+			body = append(body, s)
+		}
+	}
+	return body
+}
+
+func (p *parser) loopBlock() []stmt {
+	p.loops++
+	defer func() { p.loops-- }()
+	return p.block()
+}
+
+func (p *parser) retstat() stmt {
+	line := p.tok.line
+	p.advance()
+	var exprs []expr
+	if !blockEnds(p.tok.kind) && p.tok.kind != ';' {
+		exprs = p.exprList()
+	}
+	if p.tok.kind == ';' {
+		p.advance()
+	}
+	if !blockEnds(p.tok.kind) {
+		p.errorf("'<eof>' expected near '%s'", p.near())
+	}
+	return &returnStmt{exprs: exprs, line: line}
+}
+
+func (p *parser) statement() stmt {
+	line := p.tok.line
+	switch p.tok.kind {
+	case ';':
+		p.advance()
+		return nil
+	case tokIf:
+		return p.ifStat(line)
+	case tokWhile:
+		p.advance()
+		cond := p.expr()
+		p.expect(tokDo)
+		body := p.loopBlock()
+		p.match(tokEnd, tokWhile, line)
+		return &whileStmt{cond: cond, body: body, line: line}
+	case tokDo:
+		p.advance()
+		body := p.block()
+		p.match(tokEnd, tokDo, line)
+		return &doStmt{body: body}
+	case tokFor:
+		return p.forStat(line)
+	case tokRepeat:
+		p.advance()
+		body := p.loopBlock()
+		p.match(tokUntil, tokRepeat, line)
+		return &repeatStmt{body: body, cond: p.expr(), line: line}
+	case tokFunction:
+		p.advance()
+		name := p.name()
+		var target expr = &nameExpr{name: name}
+		method := false
+		for p.tok.kind == '.' || p.tok.kind == ':' {
+			method = p.tok.kind == ':'
+			p.advance()
+			key := p.name()
+			name += "." + key
+			target = &indexExpr{obj: target, key: &constExpr{v: key}, line: line}
+			if method {
+				break
+			}
+		}
+		proto := p.funcBody(name, method, line)
+		return &assignStmt{targets: []expr{target}, exprs: []expr{&funcExpr{proto: proto}}, line: line}
+	case tokLocal:
+		p.advance()
+		if p.tok.kind == tokFunction {
+			p.advance()
+			name := p.name()
+			return &localFuncStmt{name: name, proto: p.funcBody(name, false, line)}
+		}
+		names := []string{p.name()}
+		for p.tok.kind == ',' {
+			p.advance()
+			names = append(names, p.name())
+		}
+		var exprs []expr
+		if p.tok.kind == '=' {
+			p.advance()
+			exprs = p.exprList()
+		}
+		return &localStmt{names: names, exprs: exprs, line: line}
+	case tokBreak:
+		if p.loops == 0 {
+			p.errorf("break outside a loop at line %d", line)
+		}
+		p.advance()
+		return &breakStmt{}
+	}
+	return p.exprStat(line)
+}
+
+func (p *parser) ifStat(line int) stmt {
+	s := &ifStmt{}
+	for {
+		p.advance() // if or elseif
+		s.conds = append(s.conds, p.expr())
+		p.expect(tokThen)
+		s.blocks = append(s.blocks, p.block())
+		if p.tok.kind != tokElseif {
+			break
+		}
+	}
+	if p.tok.kind == tokElse {
+		p.advance()
+		s.els = p.block()
+	}
+	p.match(tokEnd, tokIf, line)
+	return s
+}
+
+func (p *parser) forStat(line int) stmt {
+	p.advance()
+	first := p.name()
+	if p.tok.kind == '=' {
+		p.advance()
+		s := &numForStmt{name: first, line: line}
+		s.start = p.expr()
+		p.expect(',')
+		s.stop = p.expr()
+		if p.tok.kind == ',' {
+			p.advance()
+			s.step = p.expr()
+		}
+		p.expect(tokDo)
+		s.body = p.loopBlock()
+		p.match(tokEnd, tokFor, line)
+		return s
+	}
+	s := &genForStmt{names: []string{first}, line: line}
+	for p.tok.kind == ',' {
+		p.advance()
+		s.names = append(s.names, p.name())
+	}
+	p.expect(tokIn)
+	s.exprs = p.exprList()
+	p.expect(tokDo)
+	s.body = p.loopBlock()
+	p.match(tokEnd, tokFor, line)
+	return s
+}
+
+// exprStat parses an assignment or a function call statement.
+func (p *parser) exprStat(line int) stmt {
+	e := p.suffixedExpr()
+	if p.tok.kind != '=' && p.tok.kind != ',' {
+		switch e.(type) {
+		case *callExpr, *methodExpr:
+			return &callStmt{call: e}
+		}
+		p.errorf("syntax error near '%s'", p.near())
+	}
+	targets := []expr{e}
+	for p.tok.kind == ',' {
+		p.advance()
+		targets = append(targets, p.suffixedExpr())
+	}
+	for _, t := range targets {
+		switch t.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			p.errorf("syntax error near '%s'", p.near())
+		}
+	}
+	p.expect('=')
+	return &assignStmt{targets: targets, exprs: p.exprList(), line: line}
+}
+
+func (p *parser) exprList() []expr {
+	list := []expr{p.expr()}
+	for p.tok.kind == ',' {
+		p.advance()
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+func (p *parser) funcBody(name string, method bool, line int) *funcProto {
+	proto := &funcProto{chunk: p.lex.chunk, name: name, line: line}
+	if method {
+		proto.params = append(proto.params, "self")
+	}
+	p.expect('(')
+	for p.tok.kind != ')' {
+		if p.tok.kind == tokDots {
+			p.advance()
+			proto.vararg = true
+			break
+		}
+		proto.params = append(proto.params, p.name())
+		if p.tok.kind != ',' {
+			break
+		}
+		p.advance()
+	}
+	p.expect(')')
+	p.vararg = append(p.vararg, proto.vararg)
+	loops := p.loops
+	p.loops = 0
+	proto.body = p.block()
+	p.loops = loops
+	p.vararg = p.vararg[:len(p.vararg)-1]
+	p.match(tokEnd, tokFunction, line)
+	return proto
+}
+
+// Binary operator priorities, left and right, as in Lua's own parser.
+var binaryPriority = map[int][2]int{
+	tokOr: {1, 1}, tokAnd: {2, 2},
+	'<': {3, 3}, '>': {3, 3}, tokLe: {3, 3}, tokGe: {3, 3}, tokNe: {3, 3}, tokEq: {3, 3},
+	tokConcat: {9, 8}, // right associative
+	'+':       {10, 10}, '-': {10, 10},
+	'*': {11, 11}, '/': {11, 11}, tokIDiv: {11, 11}, '%': {11, 11},
+	'^': {14, 13}, // right associative
+}
+
+const unaryPriority = 12
+
+func (p *parser) expr() expr { return p.subExpr(0) }
+
+func (p *parser) subExpr(limit int) expr {
+	p.enter()
+	defer p.leave()
+	var e expr
+	switch op := p.tok.kind; op {
+	case tokNot, '-', '#':
+		line := p.tok.line
+		p.advance()
+		operand := p.subExpr(unaryPriority)
+		if c, ok := operand.(*constExpr); ok && op == '-' {
+			if n, ok := c.v.(float64); ok {
+				e = &constExpr{v: -n}
+				break
+			}
+		}
+		e = &unaryExpr{op: op, e: operand, line: line}
+	default:
+		e = p.simpleExpr()
+	}
+	for {
+		op := p.tok.kind
+		prio, ok := binaryPriority[op]
+		if !ok || prio[0] <= limit {
+			return e
+		}
+		line := p.tok.line
+		p.advance()
+		r := p.subExpr(prio[1])
+		switch op {
+		case tokAnd:
+			e = &andExpr{l: e, r: r}
+		case tokOr:
+			e = &orExpr{l: e, r: r}
+		default:
+			e = &binaryExpr{op: op, l: e, r: r, line: line}
+		}
+	}
+}
+
+func (p *parser) simpleExpr() expr {
+	var e expr
+	switch p.tok.kind {
+	case tokNumber:
+		e = &constExpr{v: p.tok.num}
+	case tokString:
+		e = &constExpr{v: p.tok.str}
+	case tokNil:
+		e = &constExpr{}
+	case tokTrue:
+		e = &constExpr{v: true}
+	case tokFalse:
+		e = &constExpr{v: false}
+	case tokDots:
+		if !p.vararg[len(p.vararg)-1] {
+			p.errorf("cannot use '...' outside a vararg function near '...'")
+		}
+		e = &varargExpr{}
+	case '{':
+		return p.tableConstructor()
+	case tokFunction:
+		line := p.tok.line
+		p.advance()
+		return &funcExpr{proto: p.funcBody("anonymous", false, line)}
+	default:
+		return p.suffixedExpr()
+	}
+	p.advance()
+	return e
+}
+
+func (p *parser) primaryExpr() expr {
+	switch p.tok.kind {
+	case tokName:
+		return &nameExpr{name: p.name()}
+	case '(':
+		line := p.tok.line
+		p.advance()
+		e := p.expr()
+		p.match(')', '(', line)
+		return &parenExpr{e: e}
+	}
+	p.errorf("unexpected symbol near '%s'", p.near())
+	return nil
+}
+
+func (p *parser) suffixedExpr() expr {
+	e := p.primaryExpr()
+	for {
+		line := p.tok.line
+		switch p.tok.kind {
+		case '.':
+			p.advance()
+			e = &indexExpr{obj: e, key: &constExpr{v: p.name()}, line: line}
+		case '[':
+			p.advance()
+			key := p.expr()
+			p.expect(']')
+			e = &indexExpr{obj: e, key: key, line: line}
+		case ':':
+			p.advance()
+			name := p.name()
+			e = &methodExpr{obj: e, name: name, args: p.callArgs(), line: line}
+		case '(', tokString, '{':
+			e = &callExpr{fn: e, args: p.callArgs(), line: line}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *parser) callArgs() []expr {
+	switch p.tok.kind {
+	case tokString:
+		s := p.tok.str
+		p.advance()
+		return []expr{&constExpr{v: s}}
+	case '{':
+		return []expr{p.tableConstructor()}
+	case '(':
+		line := p.tok.line
+		p.advance()
+		var args []expr
+		if p.tok.kind != ')' {
+			args = p.exprList()
+		}
+		p.match(')', '(', line)
+		return args
+	}
+	p.errorf("function arguments expected near '%s'", p.near())
+	return nil
+}
+
+func (p *parser) tableConstructor() expr {
+	line := p.tok.line
+	p.expect('{')
+	t := &tableExpr{line: line}
+	for p.tok.kind != '}' {
+		switch {
+		case p.tok.kind == '[':
+			p.advance()
+			key := p.expr()
+			p.expect(']')
+			p.expect('=')
+			t.items = append(t.items, tableItem{key: key, val: p.expr()})
+		case p.tok.kind == tokName && p.peek().kind == '=':
+			key := p.name()
+			p.advance()
+			t.items = append(t.items, tableItem{key: &constExpr{v: key}, val: p.expr()})
+		default:
+			t.items = append(t.items, tableItem{val: p.expr()})
+		}
+		if p.tok.kind != ',' && p.tok.kind != ';' {
+			break
+		}
+		p.advance()
+	}
+	p.match('}', '{', line)
+	return t
+}
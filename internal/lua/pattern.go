@@ -0,0 +1,366 @@
+package lua
+
+import "strings"
+
+// Lua patterns, as used by string.find, match, gmatch and gsub. This
+// follows the matcher in Lua 5.3's lstrlib.c closely, byte for byte, so
+// scripts written against the reference interpreter behave the same.
+
+const (
+	maxCaptures   = 32
+	maxMatchDepth = 200
+
+	capUnfinished = -1
+	capPosition   = -2
+)
+
+type matchState struct {
+	s       *State
+	src     string
+	pat     string
+	depth   int
+	level   int
+	capture [maxCaptures]struct{ init, len int }
+}
+
+func (ms *matchState) reset() {
+	ms.level = 0
+	ms.depth = maxMatchDepth
+}
+
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(ms.pat) {
+			ms.s.errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for { // the first character may be a literal ']'
+			if p >= len(ms.pat) {
+				ms.s.errorf("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' && p < len(ms.pat) {
+				p++
+			}
+			if p >= len(ms.pat) {
+				ms.s.errorf("malformed pattern (missing ']')")
+			}
+			if ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	case 'c':
+		res = c < 0x20 || c == 0x7f
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = c > 0x20 && c < 0x7f
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 0x20 && c < 0x7f && !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c))
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c)
+	case 'x':
+		res = isDigit(c) || c|0x20 >= 'a' && c|0x20 <= 'f'
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass matches c against the set from p, at its '[', to ec,
+// at its ']'.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		case ms.pat[p+1] == '-' && p+2 < ec:
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match matches the pattern from p against the source from s, returning
+// where the match ends or -1.
+func (ms *matchState) match(s, p int) int {
+	ms.s.tick()
+	ms.depth--
+	if ms.depth == 0 {
+		ms.s.errorf("pattern too complex")
+	}
+	defer func() { ms.depth++ }()
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 >= len(ms.pat) {
+				break
+			}
+			switch d := ms.pat[p+1]; {
+			case d == 'b':
+				s = ms.matchBalance(s, p+2)
+				if s < 0 {
+					return -1
+				}
+				p += 4
+				continue
+			case d == 'f':
+				p += 2
+				if p >= len(ms.pat) || ms.pat[p] != '[' {
+					ms.s.errorf("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p)
+				var prev, cur byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(cur, p, ep-1) {
+					p = ep
+					continue
+				}
+				return -1
+			case isDigit(d):
+				s = ms.matchCapture(s, d)
+				if s < 0 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		// A single character class, possibly repeated.
+		ep := ms.classEnd(p)
+		var rep byte
+		if ep < len(ms.pat) {
+			rep = ms.pat[ep]
+		}
+		if !ms.singleMatch(s, p, ep) {
+			if rep == '*' || rep == '?' || rep == '-' {
+				p = ep + 1
+				continue
+			}
+			return -1
+		}
+		switch rep {
+		case '?':
+			if res := ms.match(s+1, ep+1); res >= 0 {
+				return res
+			}
+			p = ep + 1
+		case '+':
+			return ms.maxExpand(s+1, p, ep)
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		default:
+			s++
+			p = ep
+		}
+	}
+	return s
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res >= 0 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res >= 0 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		ms.s.errorf("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.match(s, p)
+	if res < 0 {
+		ms.level--
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.capture[i].len == capUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		ms.s.errorf("invalid pattern capture")
+	}
+	ms.capture[l].len = s - ms.capture[l].init
+	res := ms.match(s, p)
+	if res < 0 {
+		ms.capture[l].len = capUnfinished
+	}
+	return res
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.s.errorf("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	depth := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case e:
+			depth--
+			if depth == 0 {
+				return s + 1
+			}
+		case b:
+			depth++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) matchCapture(s int, d byte) int {
+	l := int(d - '1')
+	if l < 0 || l >= ms.level || ms.capture[l].len == capUnfinished {
+		ms.s.errorf("invalid capture index %%%d", l+1)
+	}
+	c := ms.capture[l]
+	if len(ms.src)-s >= c.len && ms.src[c.init:c.init+c.len] == ms.src[s:s+c.len] {
+		return s + c.len
+	}
+	return -1
+}
+
+// captureValue returns capture i of a match from s to e; with no
+// captures, capture 0 is the whole match.
+func (ms *matchState) captureValue(i, s, e int) Value {
+	if i >= ms.level {
+		if i != 0 {
+			ms.s.errorf("invalid capture index %%%d", i+1)
+		}
+		return ms.src[s:e]
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnfinished:
+		ms.s.errorf("unfinished capture")
+	case capPosition:
+		return float64(c.init + 1)
+	}
+	return ms.src[c.init : c.init+c.len]
+}
+
+// captures returns every capture of a match, or the whole match if the
+// pattern has none and whole is set.
+func (ms *matchState) captures(s, e int, whole bool) []Value {
+	n := ms.level
+	if n == 0 && whole {
+		n = 1
+	}
+	vals := make([]Value, n)
+	for i := range vals {
+		vals[i] = ms.captureValue(i, s, e)
+	}
+	return vals
+}
+
+// noSpecials reports whether a pattern is plain text.
+func noSpecials(pat string) bool {
+	return !strings.ContainsAny(pat, "^$*+?.([%-")
+}
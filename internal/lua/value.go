@@ -0,0 +1,281 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Value is a Lua value: nil, bool, float64, string, *Table or *Function.
+// Numbers are always float64, as in Lua 5.1; integral ones print without
+// a fraction.
+type Value interface{}
+
+// Function is a Lua function, or a Go function exposed to scripts.
+type Function struct {
+	Name  string
+	proto *funcProto
+	env   *scope
+	fn    func(s *State, args []Value) []Value
+}
+
+// NewFunction wraps a Go function so scripts can call it.
+func NewFunction(name string, fn func(s *State, args []Value) []Value) *Function {
+	return &Function{Name: name, fn: fn}
+}
+
+// Error is a Lua error: a runtime error, a syntax error or whatever value
+// the script passed to error().
+type Error struct {
+	Value Value
+
+	interrupt bool // the context ran out; pcall can't catch it
+}
+
+func (e *Error) Error() string {
+	switch v := e.Value.(type) {
+	case string:
+		return v
+	case float64:
+		return numberToString(v)
+	case nil:
+		return "nil"
+	}
+	return fmt.Sprintf("(error object is a %s value)", TypeName(e.Value))
+}
+
+// Table is a Lua table. Entries keep the order they were added in, which
+// gives next() something stable to walk and pairs() a predictable order.
+type Table struct {
+	index   map[Value]int
+	entries []entry
+	dead    int // entries set to nil but still indexed
+	border  int // last length found, where the next search starts
+}
+
+type entry struct {
+	key, val Value
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+	return &Table{index: make(map[Value]int)}
+}
+
+// Get returns t[k], or nil.
+func (t *Table) Get(k Value) Value {
+	if i, ok := t.index[k]; ok {
+		return t.entries[i].val
+	}
+	return nil
+}
+
+// Set sets t[k] = v. k must not be nil or NaN; setting nil removes k.
+func (t *Table) Set(k, v Value) {
+	if i, ok := t.index[k]; ok {
+		switch {
+		case t.entries[i].val == nil && v != nil:
+			t.dead--
+		case t.entries[i].val != nil && v == nil:
+			t.dead++
+		}
+		t.entries[i].val = v
+		return
+	}
+	if v == nil {
+		return
+	}
+	if t.dead > 16 && t.dead > len(t.entries)/2 {
+		t.compact()
+	}
+	t.index[k] = len(t.entries)
+	t.entries = append(t.entries, entry{k, v})
+}
+
+// compact drops removed entries. It only runs when a new key is added,
+// which Lua doesn't allow during a traversal anyway.
+func (t *Table) compact() {
+	live := t.entries[:0]
+	for _, e := range t.entries {
+		if e.val == nil {
+			delete(t.index, e.key)
+			continue
+		}
+		t.index[e.key] = len(live)
+		live = append(live, e)
+	}
+	for i := len(live); i < len(t.entries); i++ {
+		t.entries[i] = entry{}
+	}
+	t.entries = live
+	t.dead = 0
+}
+
+// Len returns a border of t: n such that t[n] ~= nil and t[n+1] == nil,
+// which for a sequence is its length.
+func (t *Table) Len() int {
+	n := t.border
+	for n > 0 && t.Get(float64(n)) == nil {
+		n--
+	}
+	for t.Get(float64(n+1)) != nil {
+		n++
+	}
+	t.border = n
+	return n
+}
+
+// Next returns the entry after k, or the first one if k is nil. ok is
+// false if k isn't in the table; a nil key means the end.
+func (t *Table) Next(k Value) (key, val Value, ok bool) {
+	i := 0
+	if k != nil {
+		j, found := t.index[k]
+		if !found {
+			return nil, nil, false
+		}
+		i = j + 1
+	}
+	for ; i < len(t.entries); i++ {
+		if e := t.entries[i]; e.val != nil {
+			return e.key, e.val, true
+		}
+	}
+	return nil, nil, true
+}
+
+// TypeName returns the Lua type of v, as type() does.
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy reports whether v counts as true: anything but nil and false.
+func Truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// ToString converts v to a string as tostring() does.
+func ToString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return numberToString(v)
+	case string:
+		return v
+	case *Table:
+		return fmt.Sprintf("table: %p", v)
+	case *Function:
+		if v.fn != nil {
+			return fmt.Sprintf("builtin: %p", v)
+		}
+		return fmt.Sprintf("function: %p", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func numberToString(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	case n == math.Trunc(n) && math.Abs(n) < 1e15:
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// toNumber converts numbers and numeric strings to a number, as Lua does
+// for arithmetic.
+func toNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		return stringToNumber(v)
+	}
+	return 0, false
+}
+
+func stringToNumber(s string) (float64, bool) {
+	start, end := 0, len(s)
+	for start < end && isSpace(s[start]) {
+		start++
+	}
+	for end > start && isSpace(s[end-1]) {
+		end--
+	}
+	s = s[start:end]
+	neg := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	n, ok := parseNumber(s)
+	if neg {
+		n = -n
+	}
+	return n, ok
+}
+
+// toInteger converts v to an integer, which it must represent exactly.
+func toInteger(v Value) (int64, bool) {
+	n, ok := toNumber(v)
+	if !ok || n != math.Trunc(n) || math.Abs(n) > 1<<63 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+// ToValue converts a value decoded by encoding/json into the equivalent
+// Lua value: objects and arrays become tables.
+func ToValue(v interface{}) Value {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case map[string]interface{}:
+		t := NewTable()
+		for k, e := range v {
+			if e != nil {
+				t.Set(k, ToValue(e))
+			}
+		}
+		return t
+	case []interface{}:
+		t := NewTable()
+		for i, e := range v {
+			t.Set(float64(i+1), ToValue(e))
+		}
+		return t
+	}
+	return fmt.Sprint(v)
+}
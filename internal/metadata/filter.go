@@ -0,0 +1,99 @@
+// Package metadata cleans up stream titles before they reach listeners,
+// logs and recordings. Encoders often send whatever their playlist holds,
+// file paths and all.
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter is one step of a title pipeline.
+type Filter struct {
+	op   string
+	re   *regexp.Regexp
+	text string // replacement, prefix or suffix
+}
+
+// Pipeline is an ordered list of filters.
+type Pipeline []Filter
+
+// ParseFilter reads one title_filter setting:
+//
+//	replace <regexp> => <replacement>   regexp replace; $1 etc. expand groups
+//	mask <word>, <word>...              replace whole words with asterisks
+//	prefix <text>                       prepend text (quote it to keep spaces)
+//	suffix <text>                       append text
+//	drop <regexp>                       ignore title updates that match
+func ParseFilter(value string) (Filter, error) {
+	op, arg := value, ""
+	if i := strings.IndexAny(value, " \t"); i >= 0 {
+		op, arg = value[:i], strings.TrimSpace(value[i+1:])
+	}
+	f := Filter{op: op}
+	var err error
+	switch op {
+	case "replace":
+		pattern, repl, ok := strings.Cut(arg, "=>")
+		if !ok {
+			return f, fmt.Errorf("replace needs <regexp> => <replacement>")
+		}
+		if f.re, err = regexp.Compile(strings.TrimSpace(pattern)); err != nil {
+			return f, err
+		}
+		f.text, err = unquote(strings.TrimSpace(repl))
+	case "mask":
+		var words []string
+		for _, w := range strings.Split(arg, ",") {
+			if w = strings.TrimSpace(w); w != "" {
+				words = append(words, regexp.QuoteMeta(w))
+			}
+		}
+		if len(words) == 0 {
+			return f, fmt.Errorf("mask needs at least one word")
+		}
+		f.re, err = regexp.Compile(`(?i)\b(` + strings.Join(words, "|") + `)\b`)
+	case "prefix", "suffix":
+		f.text, err = unquote(arg)
+	case "drop":
+		f.re, err = regexp.Compile(arg)
+	default:
+		return f, fmt.Errorf("unknown title filter %q (expected replace, mask, prefix, suffix or drop)", op)
+	}
+	return f, err
+}
+
+// unquote allows "quoted" text so leading and trailing spaces survive the
+// config parser.
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
+
+// Apply runs title through the pipeline. It reports false if a drop filter
+// matched, in which case the update should be ignored.
+func (p Pipeline) Apply(title string) (string, bool) {
+	for _, f := range p {
+		switch f.op {
+		case "replace":
+			title = f.re.ReplaceAllString(title, f.text)
+		case "mask":
+			title = f.re.ReplaceAllStringFunc(title, func(w string) string {
+				return strings.Repeat("*", len([]rune(w)))
+			})
+		case "prefix":
+			title = f.text + title
+		case "suffix":
+			title += f.text
+		case "drop":
+			if f.re.MatchString(title) {
+				return title, false
+			}
+		}
+	}
+	return title, true
+}
@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything that can write itself in the Prometheus text format.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registry   = make(map[string]metric)
+	registryMu sync.Mutex
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[m.name()]; exists {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	n, help string
+	v       atomic.Int64
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{n: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc()         { c.v.Add(1) }
+func (c *Counter) Add(n int64)  { c.v.Add(n) }
+func (c *Counter) Value() int64 { return c.v.Load() }
+func (c *Counter) name() string { return c.n }
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.n, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.n, c.Value())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	n, help string
+	v       atomic.Int64
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{n: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(n int64)  { g.v.Store(n) }
+func (g *Gauge) Add(n int64)  { g.v.Add(n) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+func (g *Gauge) name() string { return g.n }
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.n, g.help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.n, g.Value())
+}
+
+// GaugeFunc reports the value returned by a callback at scrape time.
+type GaugeFunc struct {
+	n, help string
+	fn      func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{n: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.n }
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.n, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.fn()))
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	n, help string
+	labels  []string
+
+	mu       sync.Mutex
+	children map[string]*labeledCounter
+}
+
+type labeledCounter struct {
+	values []string
+	c      Counter
+}
+
+// NewCounterVec creates and registers a labelled counter family.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{n: name, help: help, labels: labels, children: make(map[string]*labeledCounter)}
+	register(v)
+	return v
+}
+
+// With returns the counter for the given label values, creating it on first use.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.n, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = &labeledCounter{values: append([]string(nil), values...)}
+		child.c.n = v.n
+		v.children[key] = child
+	}
+	return &child.c
+}
+
+func (v *CounterVec) name() string { return v.n }
+func (v *CounterVec) write(w io.Writer) {
+	writeHeader(w, v.n, v.help, "counter")
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := v.children[k]
+		fmt.Fprintf(w, "%s{%s} %d\n", v.n, formatLabels(v.labels, child.values), child.c.Value())
+	}
+	v.mu.Unlock()
+}
+
+// GaugeVec is a family of gauges partitioned by label values.
+type GaugeVec struct {
+	n, help string
+	labels  []string
+
+	mu       sync.Mutex
+	children map[string]*labeledGauge
+}
+
+type labeledGauge struct {
+	values []string
+	g      Gauge
+}
+
+// NewGaugeVec creates and registers a labelled gauge family.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{n: name, help: help, labels: labels, children: make(map[string]*labeledGauge)}
+	register(v)
+	return v
+}
+
+// With returns the gauge for the given label values, creating it on first use.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.n, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = &labeledGauge{values: append([]string(nil), values...)}
+		child.g.n = v.n
+		v.children[key] = child
+	}
+	return &child.g
+}
+
+func (v *GaugeVec) name() string { return v.n }
+func (v *GaugeVec) write(w io.Writer) {
+	writeHeader(w, v.n, v.help, "gauge")
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := v.children[k]
+		fmt.Fprintf(w, "%s{%s} %d\n", v.n, formatLabels(v.labels, child.values), child.g.Value())
+	}
+	v.mu.Unlock()
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i := range names {
+		parts[i] = names[i] + "=" + strconv.Quote(values[i])
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteAll writes every registered metric in the Prometheus text format.
+func WriteAll(w io.Writer) {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	ms := make([]metric, len(names))
+	for i, n := range names {
+		ms[i] = registry[n]
+	}
+	registryMu.Unlock()
+
+	for _, m := range ms {
+		m.write(w)
+	}
+}
+
+// Handler serves all registered metrics for Prometheus-style scrapers.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteAll(w)
+	})
+}
@@ -0,0 +1,117 @@
+package mp3
+
+import "time"
+
+// Stats summarises the frames an Analyzer has seen.
+type Stats struct {
+	Frames       int64         `json:"frames"`
+	Bytes        int64         `json:"bytes"`
+	SyncErrors   int64         `json:"sync_errors"`   // times the stream lost frame sync
+	SkippedBytes int64         `json:"skipped_bytes"` // bytes that weren't part of any frame
+	Version      float64       `json:"version"`
+	Layer        int           `json:"layer"`
+	Bitrate      int           `json:"bitrate"` // kbps of the latest frame
+	MinBitrate   int           `json:"min_bitrate"`
+	MaxBitrate   int           `json:"max_bitrate"`
+	SampleRate   int           `json:"sample_rate"`
+	Mode         string        `json:"mode"`
+	Duration     time.Duration `json:"-"` // audio carried by the frames seen
+	Silence      time.Duration `json:"-"` // audio in the latest run of silent frames; see Silent
+}
+
+// VBR reports whether the stream's bitrate has varied.
+func (s Stats) VBR() bool {
+	return s.MinBitrate != s.MaxBitrate
+}
+
+// Analyzer follows an MP3 stream as it arrives in arbitrary chunks, keeping
+// track of frame sync and format. It is not safe for concurrent use.
+type Analyzer struct {
+	pending []byte
+	synced  bool
+	stats   Stats
+}
+
+// maxPending bounds how much unparsed data is carried between writes; no
+// valid frame is anywhere near this long.
+const maxPending = 8192
+
+// Write feeds the next chunk of the stream to the analyzer.
+func (a *Analyzer) Write(p []byte) {
+	a.Feed(p, nil)
+}
+
+// Feed is Write, additionally calling fn (if not nil) with each complete
+// frame in the stream. Data that isn't part of a frame is not passed on.
+// The frame slice is only valid until fn returns.
+func (a *Analyzer) Feed(p []byte, fn func(frame []byte, h Header)) {
+	a.stats.Bytes += int64(len(p))
+	a.pending = append(a.pending, p...)
+	buf := a.pending
+	for len(buf) >= HeaderSize {
+		if !a.synced && len(buf) >= 10 {
+			if n := id3v2Size(buf); n > 0 {
+				if n > len(buf) {
+					break // wait for the rest of the tag
+				}
+				a.stats.SkippedBytes += int64(n)
+				buf = buf[n:]
+				continue
+			}
+		}
+		h, ok := ParseHeader(buf)
+		if !ok {
+			if a.synced {
+				a.synced = false
+				a.stats.SyncErrors++
+			}
+			a.stats.SkippedBytes++
+			buf = buf[1:]
+			continue
+		}
+		if h.FrameSize > len(buf) {
+			break // frame continues in the next chunk
+		}
+		a.synced = true
+		a.note(buf[:h.FrameSize], h)
+		if fn != nil {
+			fn(buf[:h.FrameSize], h)
+		}
+		buf = buf[h.FrameSize:]
+	}
+	if len(buf) > maxPending {
+		a.stats.SkippedBytes += int64(len(buf) - maxPending)
+		buf = buf[len(buf)-maxPending:]
+	}
+	a.pending = append(a.pending[:0], buf...)
+}
+
+func (a *Analyzer) note(frame []byte, h Header) {
+	s := &a.stats
+	if s.Frames == 0 || h.Bitrate < s.MinBitrate {
+		s.MinBitrate = h.Bitrate
+	}
+	if h.Bitrate > s.MaxBitrate {
+		s.MaxBitrate = h.Bitrate
+	}
+	s.Frames++
+	s.Version, s.Layer, s.Bitrate = h.Version, h.Layer, h.Bitrate
+	s.SampleRate, s.Mode = h.SampleRate, h.Mode
+	s.Duration += h.Duration()
+	if Silent(frame, h) {
+		s.Silence += h.Duration()
+	} else {
+		s.Silence = 0
+	}
+}
+
+// Pending returns how many bytes fed to the analyzer are held back as the
+// start of a frame that hasn't all arrived yet.
+func (a *Analyzer) Pending() int {
+	return len(a.pending)
+}
+
+// Stats returns what the analyzer has seen so far.
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
@@ -0,0 +1,288 @@
+// Package mp3 parses MPEG audio frame headers. It understands just enough of
+// the format to find frame boundaries, measure durations and describe what
+// an encoder is sending; it never decodes audio.
+package mp3
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+)
+
+// HeaderSize is the length of an MPEG audio frame header.
+const HeaderSize = 4
+
+// Header is a decoded MPEG audio frame header.
+type Header struct {
+	Version    float64 // 1, 2 or 2.5
+	Layer      int     // 1, 2 or 3
+	Bitrate    int     // kbps
+	SampleRate int     // Hz
+	Padding    bool
+	Mode       string // "stereo", "joint stereo", "dual channel" or "mono"
+	Channels   int    // 1 or 2
+	FrameSize  int    // bytes, including the header
+	Samples    int    // samples per channel in the frame
+}
+
+// Duration is how much audio one frame carries.
+func (h Header) Duration() time.Duration {
+	return time.Duration(h.Samples) * time.Second / time.Duration(h.SampleRate)
+}
+
+var bitrates = map[[2]int][16]int{
+	// {version class, layer}: MPEG-1 is class 1, MPEG-2 and 2.5 share class 2.
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+}
+
+var channelModes = [4]string{"stereo", "joint stereo", "dual channel", "mono"}
+
+var sampleRates = map[float64][3]int{
+	1:   {44100, 48000, 32000},
+	2:   {22050, 24000, 16000},
+	2.5: {11025, 12000, 8000},
+}
+
+// ParseHeader decodes the frame header at the start of b. It rejects free
+// format and reserved values, which in practice means "not a frame".
+func ParseHeader(b []byte) (Header, bool) {
+	if len(b) < HeaderSize || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return Header{}, false
+	}
+	var h Header
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		h.Version = 2.5
+	case 2:
+		h.Version = 2
+	case 3:
+		h.Version = 1
+	default:
+		return Header{}, false
+	}
+	switch (b[1] >> 1) & 3 {
+	case 1:
+		h.Layer = 3
+	case 2:
+		h.Layer = 2
+	case 3:
+		h.Layer = 1
+	default:
+		return Header{}, false
+	}
+
+	class := 1
+	if h.Version != 1 {
+		class = 2
+	}
+	h.Bitrate = bitrates[[2]int{class, h.Layer}][b[2]>>4]
+	if h.Bitrate <= 0 {
+		return Header{}, false
+	}
+	sr := (b[2] >> 2) & 3
+	if sr == 3 {
+		return Header{}, false
+	}
+	h.SampleRate = sampleRates[h.Version][sr]
+	h.Padding = b[2]&2 != 0
+	h.Mode = channelModes[b[3]>>6]
+	h.Channels = 2
+	if b[3]>>6 == 3 {
+		h.Channels = 1
+	}
+
+	pad := 0
+	if h.Padding {
+		pad = 1
+	}
+	switch {
+	case h.Layer == 1:
+		h.Samples = 384
+		h.FrameSize = (12*h.Bitrate*1000/h.SampleRate + pad) * 4
+	case h.Layer == 3 && h.Version != 1:
+		h.Samples = 576
+		h.FrameSize = 72*h.Bitrate*1000/h.SampleRate + pad
+	default:
+		h.Samples = 1152
+		h.FrameSize = 144*h.Bitrate*1000/h.SampleRate + pad
+	}
+	return h, true
+}
+
+// Sync returns the offset of the first frame in b that is followed by a
+// second valid frame header, or -1. Checking for two headers in a row avoids
+// locking on to stray 0xFF bytes in the middle of audio data. A frame that
+// ends exactly at the end of b is accepted on its own.
+func Sync(b []byte) int {
+	for i := 0; i+HeaderSize <= len(b); i++ {
+		h, ok := ParseHeader(b[i:])
+		if !ok {
+			continue
+		}
+		next := i + h.FrameSize
+		if next == len(b) {
+			return i
+		}
+		if next+HeaderSize <= len(b) {
+			if _, ok := ParseHeader(b[next:]); ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// SilentFrame returns a frame of silence in the format of the frame whose
+// header starts b: the same version, layer, bitrate, sample rate and
+// channel mode, without padding or CRC. Everything after the header is
+// zero, which every layer decodes as silence (Layer III reads it as side
+// information for empty granules).
+func SilentFrame(b []byte) ([]byte, bool) {
+	if _, ok := ParseHeader(b); !ok {
+		return nil, false
+	}
+	hdr := []byte{b[0], b[1] | 1, b[2] &^ 2, b[3]}
+	h, _ := ParseHeader(hdr)
+	frame := make([]byte, h.FrameSize)
+	copy(frame, hdr)
+	return frame, true
+}
+
+// Silent reports whether a frame carries silence, or very nearly: for
+// Layer III, no spectral values beyond ±1 in any granule or channel, which
+// is what encoders make of digital silence; for Layers I and II, a payload
+// of zeros. Only the side information is read, so it is cheap enough for
+// every frame of a live stream.
+func Silent(frame []byte, h Header) bool {
+	if len(frame) < h.FrameSize || len(frame) < HeaderSize {
+		return false
+	}
+	body := frame[HeaderSize:h.FrameSize]
+	if frame[1]&1 == 0 && len(body) >= 2 {
+		body = body[2:] // CRC
+	}
+	if h.Layer != 3 {
+		for _, c := range body {
+			if c != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	r := bitReader{b: body}
+	granules, rest := 2, 38
+	if h.Version == 1 {
+		r.skip(9) // main_data_begin
+		if h.Channels == 1 {
+			r.skip(5 + 4) // private bits, scfsi
+		} else {
+			r.skip(3 + 8)
+		}
+	} else {
+		granules, rest = 1, 42
+		r.skip(8 + h.Channels) // main_data_begin, private bits
+	}
+	for gr := 0; gr < granules; gr++ {
+		for ch := 0; ch < h.Channels; ch++ {
+			r.skip(12) // part2_3_length
+			if r.read(9) != 0 {
+				return false // big_values
+			}
+			r.skip(rest)
+		}
+	}
+	return !r.short
+}
+
+// bitReader reads big-endian bit fields of up to 32 bits.
+type bitReader struct {
+	b     []byte
+	pos   int  // in bits
+	short bool // read past the end
+}
+
+func (r *bitReader) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos>>3 >= len(r.b) {
+			r.short = true
+			return 0
+		}
+		v = v<<1 | uint32(r.b[r.pos>>3]>>(7-r.pos&7))&1
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos>>3 > len(r.b) {
+		r.short = true
+	}
+}
+
+// ErrNoFrames is returned when a stream contains no MPEG audio frames.
+var ErrNoFrames = errors.New("no MPEG audio frames found")
+
+// id3v2Size returns the length of an ID3v2 tag at the start of b, or 0.
+func id3v2Size(b []byte) int {
+	if len(b) < 10 || string(b[:3]) != "ID3" {
+		return 0
+	}
+	size := int(b[6]&0x7F)<<21 | int(b[7]&0x7F)<<14 | int(b[8]&0x7F)<<7 | int(b[9]&0x7F)
+	if b[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return 10 + size
+}
+
+// Scan reads a whole MP3 stream, skipping a leading ID3v2 tag and any junk
+// between frames, and returns the total audio duration and the first frame's
+// header. VBR files are measured exactly since every frame is counted.
+func Scan(r io.Reader) (time.Duration, Header, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if b, err := br.Peek(10); err == nil {
+		if n := id3v2Size(b); n > 0 {
+			if _, err := br.Discard(n); err != nil {
+				return 0, Header{}, ErrNoFrames
+			}
+		}
+	}
+
+	var total time.Duration
+	var first Header
+	frames := 0
+	for {
+		b, err := br.Peek(HeaderSize)
+		if len(b) < HeaderSize {
+			if frames == 0 {
+				return 0, Header{}, ErrNoFrames
+			}
+			if err == io.EOF {
+				return total, first, nil
+			}
+			return total, first, err
+		}
+		h, ok := ParseHeader(b)
+		if !ok {
+			br.Discard(1)
+			continue
+		}
+		if frames == 0 {
+			first = h
+		}
+		frames++
+		total += h.Duration()
+		if _, err := br.Discard(h.FrameSize); err != nil {
+			// A truncated last frame still counts; players play what's there.
+			return total, first, nil
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Package nowplaying pushes title updates to third-party playlist services
+// (Spinitron, Radio.co, TuneIn AIR and the like), so a station's public
+// playlist follows what is actually on air. Each service gets a request
+// built from templates, so nickcast needs no code per provider.
+package nowplaying
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"nickcast/internal/events"
+)
+
+const (
+	// queueSize bounds how many updates can wait for delivery to one
+	// service. Only the newest matters, so past this updates are dropped.
+	queueSize = 16
+	// maxRetryWait caps the backoff between attempts, whatever Retry-After
+	// asks for.
+	maxRetryWait = time.Minute
+)
+
+// DefaultTemplate is the body sent when a service doesn't set its own.
+const DefaultTemplate = `{"title":{{json .Title}},"artist":{{json .Artist}},"song":{{json .Song}},"mount":{{json .Mount}},"station":{{json .Station}},"account":{{json .Account}},"time":{{json .Time}}}`
+
+// Track is what templates see of a title update.
+type Track struct {
+	Title   string // as listeners see it
+	Artist  string // Title split on the first " - ", if it has one
+	Song    string
+	Mount   string
+	Station string
+	Account string // who sent the update, or the show's uploader
+	Time    time.Time
+}
+
+// Service is one configured [nowplaying] section.
+type Service struct {
+	Name        string
+	URL         *template.Template
+	Method      string // default POST
+	ContentType string // default application/json
+	Body        *template.Template
+	User        string // HTTP basic auth
+	Password    string
+	Token       string   // sent as a bearer token
+	Headers     []string // extra "Name: value" headers
+	Mounts      []string // mounts to report; empty means all
+	Retries     int      // further attempts after a failed delivery
+}
+
+// ParseTemplate parses a URL or body template, which may use json and
+// urlquery to escape values, e.g. {{json .Title}} or {{urlquery .Artist}}.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// Notifier delivers title updates to one service.
+type Notifier struct {
+	Service
+	Client *http.Client
+	queue  chan Track
+}
+
+// New creates a notifier for s and subscribes it to metadata events. Call
+// Run (normally under the supervisor) to start delivering.
+func New(s Service) *Notifier {
+	n := &Notifier{
+		Service: s,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan Track, queueSize),
+	}
+	events.Subscribe(n.enqueue)
+	return n
+}
+
+func (n *Notifier) wants(mount string) bool {
+	if len(n.Mounts) == 0 {
+		return true
+	}
+	for _, m := range n.Mounts {
+		if m == mount {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) enqueue(e events.Event) {
+	if e.Type != events.MetadataUpdate || !n.wants(e.Data["mount"]) {
+		return
+	}
+	t := Track{
+		Title:   e.Data["title"],
+		Mount:   e.Data["mount"],
+		Station: e.Data["station"],
+		Account: e.Account,
+		Time:    e.Time,
+	}
+	t.Song = t.Title
+	if i := strings.Index(t.Title, " - "); i >= 0 {
+		t.Artist, t.Song = t.Title[:i], t.Title[i+3:]
+	}
+	// The newest title is the one the service must end up showing, so a
+	// full queue makes room by dropping its oldest.
+	for {
+		select {
+		case n.queue <- t:
+			return
+		default:
+		}
+		select {
+		case old := <-n.queue:
+			log.Printf("Now-playing queue for %s full; dropping %q", n.Name, old.Title)
+		default:
+		}
+	}
+}
+
+// Run delivers queued updates until ctx is cancelled. A failed delivery is
+// retried with backoff until it succeeds, runs out of retries, or a newer
+// update makes it pointless.
+func (n *Notifier) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t := <-n.queue:
+			n.deliver(ctx, t)
+		}
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, t Track) {
+	wait := time.Second
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := n.send(ctx, t)
+		if err == nil {
+			return
+		}
+		if retryAfter < 0 || attempt >= n.Retries {
+			log.Printf("Now-playing update of %s to %q failed: %v", n.Name, t.Title, err)
+			return
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+		log.Printf("Now-playing update of %s failed, retrying in %s: %v", n.Name, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if len(n.queue) > 0 {
+			log.Printf("Now-playing update of %s to %q superseded by a newer title", n.Name, t.Title)
+			return
+		}
+		wait *= 2
+	}
+}
+
+// send makes one attempt. On failure retryAfter is how long the service
+// asked us to wait, zero if it didn't say, or negative if retrying won't
+// help.
+func (n *Notifier) send(ctx context.Context, t Track) (retryAfter time.Duration, err error) {
+	var target, body strings.Builder
+	if err := n.URL.Execute(&target, t); err != nil {
+		return -1, fmt.Errorf("url template: %w", err)
+	}
+	if err := n.Body.Execute(&body, t); err != nil {
+		return -1, fmt.Errorf("template: %w", err)
+	}
+
+	var req *http.Request
+	if n.Method == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, n.Method, target.String(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, n.Method, target.String(), strings.NewReader(body.String()))
+	}
+	if err != nil {
+		return -1, fmt.Errorf("failed to create request: %w", err)
+	}
+	if req.Body != nil {
+		req.Header.Set("Content-Type", n.ContentType)
+	}
+	req.Header.Set("User-Agent", "NickCast/1.0")
+	if n.User != "" {
+		req.SetBasicAuth(n.User, n.Password)
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	for _, h := range n.Headers {
+		if k, v, ok := strings.Cut(h, ":"); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return retryAfter, fmt.Errorf("service returned status %d", resp.StatusCode)
+	default:
+		// Bad credentials or a template the service doesn't understand
+		// won't get better by asking again.
+		return -1, fmt.Errorf("service returned status %d", resp.StatusCode)
+	}
+}
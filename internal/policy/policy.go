@@ -0,0 +1,289 @@
+// Package policy lets operators script admission, metadata and event
+// handling without recompiling nickcast. The script is Lua, run by the
+// interpreter in package lua, and defines any of three global functions:
+//
+//	function listener(l)  -- l.mount, l.station, l.ip, l.country, l.user_agent, l.account
+//	  if l.ip:find("^192%.0%.2%.") and not l.account then
+//	    return false, "Listeners from this network need an account"
+//	  end
+//	end
+//
+//	function metadata(m)  -- m.mount, m.account, m.title
+//	  return (m.title:gsub("%s*%(Radio Edit%)", ""))
+//	end
+//
+//	function event(e)     -- e.type, e.time, e.session_id, e.account, e.data...
+//	  if e.type == "source.connect" then print(e.account .. " is on air") end
+//	end
+//
+// listener turns a listener away by returning false and, optionally, the
+// reason; metadata returns the title to use, or nil to keep it. The tables
+// have the same fields as the JSON the HTTP API uses.
+//
+// A script that is slow or broken never takes the station down: each hook
+// is stopped once it runs past the timeout, and hooks fall back to the
+// built-in behaviour (admit, keep the title) when they get no answer. The
+// script has no io or os library, so it can't block on the outside world.
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"nickcast/internal/lua"
+)
+
+// queueSize bounds how many hook calls may wait for the script. Past this,
+// hooks fall back immediately rather than piling up.
+const queueSize = 256
+
+// Listener is what the listener hook is told about a connecting listener.
+type Listener struct {
+	Mount     string `json:"mount"`
+	Station   string `json:"station"`
+	IP        string `json:"ip"`
+	Country   string `json:"country,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Account   string `json:"account,omitempty"` // with listener_auth
+}
+
+// Metadata is a title update from a source.
+type Metadata struct {
+	Mount   string `json:"mount"`
+	Account string `json:"account"`
+	Title   string `json:"title"`
+}
+
+// request is one hook call. reply is nil for events, which get no answer.
+type request struct {
+	ctx   context.Context
+	hook  string
+	data  interface{}
+	reply chan []lua.Value
+}
+
+// Script runs the policy script and routes hook calls to it. The
+// interpreter runs one hook at a time, in Run's goroutine.
+type Script struct {
+	Path    string
+	Timeout time.Duration // how long a hook may run
+
+	queue chan request
+
+	mu      sync.Mutex
+	running bool
+}
+
+// New builds a Script from the policy_script setting. It returns nil if
+// path is empty.
+func New(path string, timeout time.Duration) *Script {
+	if path == "" {
+		return nil
+	}
+	s := &Script{
+		Path:    path,
+		Timeout: timeout,
+		queue:   make(chan request, queueSize),
+	}
+	events.Subscribe(s.notify)
+	return s
+}
+
+// Run loads the script and serves hook calls until ctx is cancelled. It
+// fails if the script doesn't load; the supervisor then retries, reading
+// the file afresh, so a fixed script is picked up without a restart.
+func (s *Script) Run(ctx context.Context) error {
+	src, err := os.ReadFile(s.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy script: %w", err)
+	}
+	L := lua.New()
+	L.Print = func(line string) { log.Printf("Policy script: %s", line) }
+	loadCtx, cancel := context.WithCancel(ctx)
+	stop := s.deadline(cancel)
+	err = L.DoString(loadCtx, filepath.Base(s.Path), string(src))
+	stop()
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to load policy script: %w", err)
+	}
+	log.Printf("Policy script %s loaded", s.Path)
+
+	// Anything queued while the script was down is stale; the hooks that
+	// sent it have long since fallen back.
+	for drained := false; !drained; {
+		select {
+		case <-s.queue:
+		default:
+			drained = true
+		}
+	}
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case req := <-s.queue:
+			s.serve(L, req)
+		}
+	}
+}
+
+// deadline calls cancel once the timeout passes, unless the returned stop
+// function is called first.
+func (s *Script) deadline(cancel func()) (stop func()) {
+	t := clock.Default.NewTimer(s.Timeout)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-t.C():
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		t.Stop()
+		close(done)
+	}
+}
+
+// serve runs one hook. A hook the script doesn't define, or one that
+// fails, answers nothing.
+func (s *Script) serve(L *lua.State, req request) {
+	var rets []lua.Value
+	defer func() {
+		if req.reply != nil {
+			req.reply <- rets
+		}
+	}()
+	if req.ctx.Err() != nil {
+		return // the caller gave up waiting
+	}
+	fn := L.Global(req.hook)
+	if fn == nil {
+		return
+	}
+	arg, err := toLua(req.data)
+	if err != nil {
+		log.Printf("Policy script %s hook: %v", req.hook, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(req.ctx)
+	defer cancel()
+	if req.reply == nil {
+		// Nobody waits for events, so nobody else stops a runaway one.
+		defer s.deadline(cancel)()
+	}
+	rets, err = L.Call(ctx, fn, arg)
+	if err != nil {
+		if req.ctx.Err() == nil {
+			log.Printf("Policy script %s hook failed: %v", req.hook, err)
+		}
+		rets = nil
+	}
+}
+
+// toLua converts hook data to a Lua table with the fields of its JSON
+// encoding.
+func toLua(data interface{}) (lua.Value, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return lua.ToValue(v), nil
+}
+
+// send queues a request without blocking, reporting whether it was queued.
+func (s *Script) send(req request) bool {
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if !running {
+		return false
+	}
+	select {
+	case s.queue <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+// call runs a hook and waits for what it returns. ok is false if the
+// script isn't running, is backed up, or didn't answer within the timeout;
+// a hook still running then is stopped.
+func (s *Script) call(ctx context.Context, hook string, data interface{}) ([]lua.Value, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reply := make(chan []lua.Value, 1)
+	if !s.send(request{ctx: ctx, hook: hook, data: data, reply: reply}) {
+		return nil, false
+	}
+	t := clock.Default.NewTimer(s.Timeout)
+	defer t.Stop()
+	select {
+	case rets := <-reply:
+		return rets, true
+	case <-t.C():
+		log.Printf("Policy script did not answer %s hook within %s", hook, s.Timeout)
+	case <-ctx.Done():
+	}
+	return nil, false
+}
+
+// Admit asks the script whether a listener may connect. Unless the
+// listener hook returns false the listener is admitted.
+func (s *Script) Admit(ctx context.Context, l Listener) (bool, string) {
+	rets, ok := s.call(ctx, "listener", l)
+	if !ok || len(rets) == 0 || rets[0] != false {
+		return true, ""
+	}
+	reason, _ := ret(rets, 1).(string)
+	return false, reason
+}
+
+// RewriteTitle lets the script change or clean up a title update. Unless
+// the metadata hook returns a string the title is used as sent.
+func (s *Script) RewriteTitle(ctx context.Context, md Metadata) string {
+	rets, ok := s.call(ctx, "metadata", md)
+	if !ok {
+		return md.Title
+	}
+	if title, ok := ret(rets, 0).(string); ok {
+		return title
+	}
+	return md.Title
+}
+
+func ret(rets []lua.Value, i int) lua.Value {
+	if i < len(rets) {
+		return rets[i]
+	}
+	return nil
+}
+
+// notify passes every server event to the script's event hook.
+func (s *Script) notify(e events.Event) {
+	s.send(request{ctx: context.Background(), hook: "event", data: e})
+}
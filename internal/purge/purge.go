@@ -0,0 +1,182 @@
+// Package purge tells the CDN in front of nickcast to drop its cached
+// copies of pages that have changed, such as the title on air or the show
+// schedule, so listeners don't see them stale until their cache lifetime
+// runs out. Cloudflare and Fastly are supported, purging by URL.
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Providers.
+const (
+	Cloudflare = "cloudflare"
+	Fastly     = "fastly"
+)
+
+const (
+	// batchDelay is how long changes are gathered before purging, so a
+	// burst of them (a source connecting and sending its first title)
+	// costs one API call.
+	batchDelay = time.Second
+
+	// maxPending bounds the URLs waiting to be purged. Past it new ones
+	// are dropped; they expire from the CDN in their own time.
+	maxPending = 1024
+
+	// cloudflareBatch is the most URLs one Cloudflare purge may list.
+	cloudflareBatch = 30
+
+	cloudflareAPI = "https://api.cloudflare.com/client/v4"
+	fastlyAPI     = "https://api.fastly.com"
+)
+
+// Purger purges URLs from one CDN account.
+type Purger struct {
+	Provider string
+	Zone     string // Cloudflare zone ID
+	Token    string // Cloudflare API token or Fastly API key
+	Client   *http.Client
+
+	mu      sync.Mutex
+	pending map[string]bool
+	wake    chan struct{}
+}
+
+// New creates a purger. Call Run (normally under the supervisor) to start
+// purging.
+func New(provider, zone, token string) *Purger {
+	return &Purger{
+		Provider: provider,
+		Zone:     zone,
+		Token:    token,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Purge queues urls to be purged. It doesn't block.
+func (p *Purger) Purge(urls ...string) {
+	p.mu.Lock()
+	for _, u := range urls {
+		if len(p.pending) >= maxPending {
+			log.Printf("CDN purge queue full; dropping %s", u)
+			continue
+		}
+		p.pending[u] = true
+	}
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run purges queued URLs until ctx is cancelled. Failures are logged and
+// not retried: the next change purges again, and meanwhile the pages
+// expire as usual.
+func (p *Purger) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-p.wake:
+		}
+		timer := time.NewTimer(batchDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		p.mu.Lock()
+		urls := make([]string, 0, len(p.pending))
+		for u := range p.pending {
+			urls = append(urls, u)
+		}
+		p.pending = make(map[string]bool)
+		p.mu.Unlock()
+		if err := p.send(ctx, urls); err != nil {
+			log.Printf("CDN purge of %d URLs failed: %v", len(urls), err)
+		}
+	}
+}
+
+func (p *Purger) send(ctx context.Context, urls []string) error {
+	switch p.Provider {
+	case Cloudflare:
+		for len(urls) > 0 {
+			n := len(urls)
+			if n > cloudflareBatch {
+				n = cloudflareBatch
+			}
+			if err := p.cloudflare(ctx, urls[:n]); err != nil {
+				return err
+			}
+			urls = urls[n:]
+		}
+	case Fastly:
+		for _, u := range urls {
+			if err := p.fastly(ctx, u); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown provider %q", p.Provider)
+	}
+	return nil
+}
+
+// cloudflare purges up to cloudflareBatch URLs in one call.
+func (p *Purger) cloudflare(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(map[string][]string{"files": urls})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cloudflareAPI+"/zones/"+p.Zone+"/purge_cache", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	return p.do(req)
+}
+
+// fastly purges one URL. Fastly names it without the scheme.
+func (p *Purger) fastly(ctx context.Context, u string) error {
+	target := u
+	if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+3:]
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fastlyAPI+"/purge/"+target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Fastly-Key", p.Token)
+	return p.do(req)
+}
+
+func (p *Purger) do(req *http.Request) error {
+	req.Header.Set("User-Agent", "NickCast/1.0")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", p.Provider, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
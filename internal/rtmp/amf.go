@@ -0,0 +1,181 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// AMF0 type markers.
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0A
+	amfDate        = 0x0B
+	amfLongString  = 0x0C
+)
+
+// maxAMFDepth bounds how deeply objects may nest in a command.
+const maxAMFDepth = 16
+
+var errBadAMF = errors.New("rtmp: malformed AMF0 data")
+
+// amfObj is an AMF0 object or ECMA array.
+type amfObj map[string]interface{}
+
+// decodeAMF decodes the AMF0 values in b: float64, bool, string, amfObj,
+// []interface{} or nil.
+func decodeAMF(b []byte) ([]interface{}, error) {
+	var vals []interface{}
+	for len(b) > 0 {
+		v, rest, err := decodeAMFValue(b, 0)
+		if err != nil {
+			return vals, err
+		}
+		vals = append(vals, v)
+		b = rest
+	}
+	return vals, nil
+}
+
+func decodeAMFValue(b []byte, depth int) (interface{}, []byte, error) {
+	if len(b) < 1 || depth > maxAMFDepth {
+		return nil, nil, errBadAMF
+	}
+	be := binary.BigEndian
+	marker, b := b[0], b[1:]
+	switch marker {
+	case amfNumber:
+		if len(b) < 8 {
+			return nil, nil, errBadAMF
+		}
+		return math.Float64frombits(be.Uint64(b)), b[8:], nil
+	case amfBoolean:
+		if len(b) < 1 {
+			return nil, nil, errBadAMF
+		}
+		return b[0] != 0, b[1:], nil
+	case amfString:
+		return decodeAMFString(b)
+	case amfLongString:
+		if len(b) < 4 || uint64(be.Uint32(b)) > uint64(len(b)-4) {
+			return nil, nil, errBadAMF
+		}
+		n := int(be.Uint32(b))
+		return string(b[4 : 4+n]), b[4+n:], nil
+	case amfNull, amfUndefined:
+		return nil, b, nil
+	case amfECMAArray:
+		// The count is only a hint; the pairs end like an object's.
+		if len(b) < 4 {
+			return nil, nil, errBadAMF
+		}
+		return decodeAMFObject(b[4:], depth)
+	case amfObject:
+		return decodeAMFObject(b, depth)
+	case amfStrictArray:
+		if len(b) < 4 {
+			return nil, nil, errBadAMF
+		}
+		n := be.Uint32(b)
+		b = b[4:]
+		var arr []interface{}
+		for i := uint32(0); i < n; i++ {
+			v, rest, err := decodeAMFValue(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+			b = rest
+		}
+		return arr, b, nil
+	case amfDate:
+		if len(b) < 10 {
+			return nil, nil, errBadAMF
+		}
+		return math.Float64frombits(be.Uint64(b)), b[10:], nil
+	}
+	return nil, nil, errBadAMF
+}
+
+func decodeAMFString(b []byte) (string, []byte, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) > len(b)-2 {
+		return "", nil, errBadAMF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func decodeAMFObject(b []byte, depth int) (interface{}, []byte, error) {
+	obj := make(amfObj)
+	for {
+		key, rest, err := decodeAMFString(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key == "" && len(rest) > 0 && rest[0] == amfObjectEnd {
+			return obj, rest[1:], nil
+		}
+		v, rest, err := decodeAMFValue(rest, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		obj[key] = v
+		b = rest
+	}
+}
+
+// amfProp is one property of an object being encoded; encodeAMF keeps
+// their order, which some clients care about.
+type amfProp struct {
+	key string
+	val interface{}
+}
+
+// encodeAMF encodes values, each a float64, bool, string, []amfProp or nil.
+func encodeAMF(vals ...interface{}) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = appendAMF(b, v)
+	}
+	return b
+}
+
+func appendAMF(b []byte, v interface{}) []byte {
+	be := binary.BigEndian
+	switch v := v.(type) {
+	case float64:
+		b = append(b, amfNumber)
+		return be.AppendUint64(b, math.Float64bits(v))
+	case bool:
+		if v {
+			return append(b, amfBoolean, 1)
+		}
+		return append(b, amfBoolean, 0)
+	case string:
+		b = append(b, amfString)
+		return appendAMFString(b, v)
+	case []amfProp:
+		b = append(b, amfObject)
+		for _, p := range v {
+			b = appendAMFString(b, p.key)
+			b = appendAMF(b, p.val)
+		}
+		return append(b, 0, 0, amfObjectEnd)
+	}
+	return append(b, amfNull)
+}
+
+func appendAMFString(b []byte, s string) []byte {
+	if len(s) > math.MaxUint16 {
+		s = s[:math.MaxUint16]
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,111 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unhex decodes hex written with spaces between bytes.
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestDecodeAMF(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []interface{}
+	}{
+		{"connect", `02 0007 636f6e6e656374  00 3ff0000000000000
+			03 0003 617070 02 0004 6c697665  0000 09`,
+			[]interface{}{"connect", 1.0, amfObj{"app": "live"}}},
+		{"boolean, null and undefined", "01 01 01 00 05 06", []interface{}{true, false, nil, nil}},
+		{"ECMA array", "08 00000001 0008 6475726174696f6e 00 405e200000000000 0000 09",
+			[]interface{}{amfObj{"duration": 120.5}}},
+		{"ECMA array with a wrong count", "08 00000000 0003 616263 01 01 0000 09",
+			[]interface{}{amfObj{"abc": true}}},
+		{"strict array", "0a 00000002 00 4000000000000000 05", []interface{}{[]interface{}{2.0, nil}}},
+		{"long string", "0c 00000003 616263", []interface{}{"abc"}},
+		{"date", "0b 3ff0000000000000 0000", []interface{}{1.0}},
+		{"nested object", "03 0001 61 03 0001 62 02 0000 0000 09 0000 09",
+			[]interface{}{amfObj{"a": amfObj{"b": ""}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeAMF(unhex(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAMFMalformed(t *testing.T) {
+	for _, in := range []string{
+		"00 3ff00000",           // short number
+		"02 0005 616263",        // string past the end
+		"0c ffffffff 616263",    // long string past the end
+		"03 0001 61 02 0001 62", // object without an end
+		"0a 00000002 05",        // strict array short of its count
+		"08 0000",               // ECMA array without its count
+		"0b 3ff0000000000000",   // date without its time zone
+		"11",                    // AMF3 switch, unsupported
+	} {
+		if _, err := decodeAMF(unhex(in)); err != errBadAMF {
+			t.Errorf("%.40s: got error %v, want %v", in, err, errBadAMF)
+		}
+	}
+}
+
+// nested is n objects, each the "a" property of the one before, the last
+// with a null.
+func nested(n int) string {
+	return strings.Repeat("03 0001 61 ", n) + "05" + strings.Repeat(" 0000 09", n)
+}
+
+func TestDecodeAMFDepth(t *testing.T) {
+	if _, err := decodeAMF(unhex(nested(maxAMFDepth))); err != nil {
+		t.Errorf("%d objects deep: %v", maxAMFDepth, err)
+	}
+	if _, err := decodeAMF(unhex(nested(maxAMFDepth + 1))); err != errBadAMF {
+		t.Errorf("%d objects deep: got error %v, want %v", maxAMFDepth+1, err, errBadAMF)
+	}
+}
+
+func TestEncodeAMF(t *testing.T) {
+	got := encodeAMF("_result", 1.0, nil, []amfProp{{"level", "status"}, {"ok", true}}, false)
+	want := unhex(`02 0007 5f726573756c74  00 3ff0000000000000  05
+		03 0005 6c6576656c 02 0006 737461747573  0002 6f6b 01 01  0000 09
+		01 00`)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % x\nwant % x", got, want)
+	}
+	// Properties keep their order.
+	got = encodeAMF([]amfProp{{"b", 1.0}, {"a", 2.0}})
+	want = unhex("03 0001 62 00 3ff0000000000000 0001 61 00 4000000000000000 0000 09")
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % x\nwant % x", got, want)
+	}
+}
+
+func TestAMFRoundTrip(t *testing.T) {
+	b := encodeAMF("onStatus", 0.0, nil, []amfProp{{"code", "NetStream.Publish.Start"}, {"nested", []amfProp{{"x", -1.5}}}})
+	got, err := decodeAMF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"onStatus", 0.0, nil, amfObj{"code": "NetStream.Publish.Start", "nested": amfObj{"x": -1.5}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package rtmp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Audio codecs Read can pass on, by the file extension of their stream.
+const (
+	CodecMP3 = "mp3"
+	CodecAAC = "aac"
+)
+
+// FLV sound formats, the top four bits of an audio message's first byte.
+const (
+	soundMP3    = 2
+	soundExHdr  = 9 // enhanced RTMP: a FourCC says what the codec is
+	soundAAC    = 10
+	soundMP3Low = 14 // MP3 at 8 kHz
+)
+
+var soundNames = map[byte]string{
+	0:  "linear PCM",
+	1:  "ADPCM",
+	3:  "linear PCM",
+	4:  "Nellymoser",
+	5:  "Nellymoser",
+	6:  "Nellymoser",
+	7:  "G.711 A-law",
+	8:  "G.711 mu-law",
+	11: "Speex",
+}
+
+// UnsupportedCodecError is returned by Read for audio that is neither MP3
+// nor AAC.
+type UnsupportedCodecError struct {
+	Codec string
+}
+
+func (e *UnsupportedCodecError) Error() string {
+	return "rtmp: the audio is " + e.Codec + ", not MP3 or AAC"
+}
+
+var errNoConfig = errors.New("rtmp: AAC audio before its AudioSpecificConfig")
+
+// demuxer turns audio messages into a stream a player can read: MP3 frames
+// as they are, and AAC with an ADTS header before each frame.
+type demuxer struct {
+	codec string
+	adts  [7]byte // ADTS header for this stream, less the frame length
+	ready bool    // adts has been set from the AudioSpecificConfig
+}
+
+// audio returns what the audio message p adds to the stream.
+func (d *demuxer) audio(p []byte) ([]byte, error) {
+	if len(p) < 1 {
+		return nil, nil
+	}
+	format := p[0] >> 4
+	switch format {
+	case soundMP3, soundMP3Low:
+		if err := d.setCodec(CodecMP3); err != nil {
+			return nil, err
+		}
+		return p[1:], nil
+	case soundAAC:
+		if err := d.setCodec(CodecAAC); err != nil {
+			return nil, err
+		}
+		if len(p) < 2 {
+			return nil, nil
+		}
+		if p[1] == 0 {
+			return nil, d.audioConfig(p[2:])
+		}
+		if !d.ready {
+			return nil, errNoConfig
+		}
+		return d.adtsFrame(p[2:]), nil
+	case soundExHdr:
+		if len(p) >= 5 {
+			return nil, &UnsupportedCodecError{Codec: fmt.Sprintf("%q (enhanced RTMP)", p[1:5])}
+		}
+	}
+	name, ok := soundNames[format]
+	if !ok {
+		name = fmt.Sprintf("sound format %d", format)
+	}
+	return nil, &UnsupportedCodecError{Codec: name}
+}
+
+func (d *demuxer) setCodec(codec string) error {
+	if d.codec != "" && d.codec != codec {
+		return fmt.Errorf("rtmp: the audio changed from %s to %s", d.codec, codec)
+	}
+	d.codec = codec
+	return nil
+}
+
+// audioConfig reads an AudioSpecificConfig into the ADTS header. HE-AAC's
+// explicit signalling is undone: ADTS describes the AAC-LC core, and
+// players find the SBR in it for themselves.
+func (d *demuxer) audioConfig(asc []byte) error {
+	if len(asc) < 2 {
+		return errors.New("rtmp: short AudioSpecificConfig")
+	}
+	bits := uint32(asc[0])<<8 | uint32(asc[1])
+	if len(asc) >= 4 {
+		bits = uint32(asc[0])<<24 | uint32(asc[1])<<16 | uint32(asc[2])<<8 | uint32(asc[3])
+	} else {
+		bits <<= 16
+	}
+	object := bits >> 27
+	rate := bits >> 23 & 0xF
+	channels := bits >> 19 & 0xF
+	if object == 5 || object == 29 {
+		// SBR or PS: the extension's rate, then the core's object type.
+		object = bits >> 10 & 0x1F
+	}
+	switch {
+	case rate == 0xF:
+		return &UnsupportedCodecError{Codec: "AAC with an explicit sample rate"}
+	case object < 1 || object > 4:
+		return &UnsupportedCodecError{Codec: fmt.Sprintf("AAC object type %d", object)}
+	}
+	d.adts = [7]byte{
+		0xFF,
+		0xF1, // MPEG-4, no CRC
+		byte(object-1)<<6 | byte(rate)<<2 | byte(channels>>2),
+		byte(channels&3) << 6,
+		0,
+		0x1F, // buffer fullness 0x7FF: variable bitrate
+		0xFC,
+	}
+	d.ready = true
+	return nil
+}
+
+// adtsFrame returns an AAC frame with its ADTS header.
+func (d *demuxer) adtsFrame(frame []byte) []byte {
+	n := len(frame) + len(d.adts)
+	if n >= 1<<13 {
+		return nil
+	}
+	b := make([]byte, 0, n)
+	b = append(b, d.adts[:]...)
+	b[3] |= byte(n >> 11)
+	b[4] = byte(n >> 3)
+	b[5] |= byte(n&7) << 5
+	return append(b, frame...)
+}
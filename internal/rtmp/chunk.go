@@ -0,0 +1,239 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Message types.
+const (
+	msgSetChunkSize = 1
+	msgAbort        = 2
+	msgAck          = 3
+	msgUserControl  = 4
+	msgWindowAck    = 5
+	msgPeerBW       = 6
+	msgAudio        = 8
+	msgVideo        = 9
+	msgDataAMF3     = 15
+	msgCommandAMF3  = 17
+	msgDataAMF0     = 18
+	msgCommandAMF0  = 20
+)
+
+// User control events.
+const (
+	eventStreamBegin = 0
+	eventPingRequest = 6
+	eventPingReply   = 7
+)
+
+// Chunk streams the server sends on.
+const (
+	csControl = 2
+	csCommand = 3
+	csStatus  = 5
+)
+
+const (
+	defaultChunkSize = 128
+
+	// outChunkSize is the chunk size the server sends with.
+	outChunkSize = 4096
+
+	// windowSize is the acknowledgement window asked of clients.
+	windowSize = 2500000
+
+	// maxMessageSize bounds the messages that are kept: commands, metadata
+	// and audio. Video, which is thrown away, may be any size.
+	maxMessageSize = 1 << 20
+
+	// maxChunkStreams bounds how many chunk streams a client may use at
+	// once.
+	maxChunkStreams = 64
+)
+
+var errTooLarge = errors.New("rtmp: message too large")
+
+// message is a complete message. Timestamps are not kept: audio is passed
+// on as it comes.
+type message struct {
+	typ     uint8
+	stream  uint32
+	payload []byte // nil for messages that are skipped
+}
+
+// chunkStream is what the chunks of one chunk stream have said so far,
+// which later chunks' headers leave out.
+type chunkStream struct {
+	length   uint32
+	typ      uint8
+	stream   uint32
+	extended bool // the last header had an extended timestamp
+
+	buf  []byte
+	read uint32 // of the message in progress
+}
+
+// chunkReader reads messages from their chunks.
+type chunkReader struct {
+	r         *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	keep      func(typ uint8) bool // whether a message's payload is wanted
+
+	// Bytes read, and the client's acknowledgement window, for acks.
+	received uint64
+	window   uint32
+	acked    uint64
+}
+
+func newChunkReader(r io.Reader, keep func(uint8) bool) *chunkReader {
+	return &chunkReader{
+		r:         bufio.NewReader(r),
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+		keep:      keep,
+	}
+}
+
+func (cr *chunkReader) readFull(b []byte) error {
+	n, err := io.ReadFull(cr.r, b)
+	cr.received += uint64(n)
+	return err
+}
+
+// readMessage reads chunks until a message is complete.
+func (cr *chunkReader) readMessage() (message, error) {
+	var hdr [11]byte
+	for {
+		if err := cr.readFull(hdr[:1]); err != nil {
+			return message{}, err
+		}
+		format, csid := hdr[0]>>6, uint32(hdr[0]&0x3F)
+		switch csid {
+		case 0:
+			if err := cr.readFull(hdr[:1]); err != nil {
+				return message{}, err
+			}
+			csid = 64 + uint32(hdr[0])
+		case 1:
+			if err := cr.readFull(hdr[:2]); err != nil {
+				return message{}, err
+			}
+			csid = 64 + uint32(hdr[0]) + uint32(hdr[1])<<8
+		}
+		cs := cr.streams[csid]
+		if cs == nil {
+			if format != 0 {
+				return message{}, fmt.Errorf("rtmp: chunk stream %d starts without a full header", csid)
+			}
+			if len(cr.streams) >= maxChunkStreams {
+				return message{}, errors.New("rtmp: too many chunk streams")
+			}
+			cs = &chunkStream{}
+			cr.streams[csid] = cs
+		}
+
+		sizes := [4]int{11, 7, 3, 0}
+		hdrLen := sizes[format]
+		if err := cr.readFull(hdr[:hdrLen]); err != nil {
+			return message{}, err
+		}
+		if hdrLen >= 3 {
+			cs.extended = hdr[0] == 0xFF && hdr[1] == 0xFF && hdr[2] == 0xFF
+		}
+		if hdrLen >= 7 {
+			cs.length = uint32(hdr[3])<<16 | uint32(hdr[4])<<8 | uint32(hdr[5])
+			cs.typ = hdr[6]
+		}
+		if hdrLen == 11 {
+			cs.stream = binary.LittleEndian.Uint32(hdr[7:])
+		}
+		if cs.extended {
+			if err := cr.readFull(hdr[:4]); err != nil {
+				return message{}, err
+			}
+		}
+		if cs.read == 0 {
+			// The first chunk of a message.
+			if cs.length > maxMessageSize && cr.keep(cs.typ) {
+				return message{}, errTooLarge
+			}
+			cs.buf = cs.buf[:0]
+		}
+
+		n := cs.length - cs.read
+		if n > cr.chunkSize {
+			n = cr.chunkSize
+		}
+		if cr.keep(cs.typ) {
+			start := len(cs.buf)
+			cs.buf = append(cs.buf, make([]byte, n)...)
+			if err := cr.readFull(cs.buf[start:]); err != nil {
+				return message{}, err
+			}
+		} else {
+			m, err := io.CopyN(io.Discard, cr.r, int64(n))
+			cr.received += uint64(m)
+			if err != nil {
+				return message{}, err
+			}
+		}
+		cs.read += n
+		if cs.read < cs.length {
+			continue
+		}
+		cs.read = 0
+		msg := message{typ: cs.typ, stream: cs.stream}
+		if cr.keep(cs.typ) {
+			msg.payload = append([]byte(nil), cs.buf...)
+		}
+		return msg, nil
+	}
+}
+
+// abort drops the partly read message on a chunk stream.
+func (cr *chunkReader) abort(csid uint32) {
+	if cs := cr.streams[csid]; cs != nil {
+		cs.read = 0
+		cs.buf = cs.buf[:0]
+	}
+}
+
+// ackDue reports whether the client is owed an acknowledgement, and if so
+// of how many bytes.
+func (cr *chunkReader) ackDue() (uint32, bool) {
+	if cr.window == 0 || cr.received-cr.acked < uint64(cr.window) {
+		return 0, false
+	}
+	cr.acked = cr.received
+	return uint32(cr.received), true
+}
+
+// writeMessage encodes a message as chunks of outChunkSize, which the
+// client has been told of before anything else. Nothing the server sends
+// needs a timestamp.
+func writeMessage(w io.Writer, csid uint32, typ uint8, stream uint32, payload []byte) error {
+	n := len(payload)
+	b := make([]byte, 0, 12+n+n/outChunkSize)
+	b = append(b, byte(csid), 0, 0, 0, byte(n>>16), byte(n>>8), byte(n), typ)
+	b = binary.LittleEndian.AppendUint32(b, stream)
+	for p := payload; ; {
+		k := len(p)
+		if k > outChunkSize {
+			k = outChunkSize
+		}
+		b = append(b, p[:k]...)
+		p = p[k:]
+		if len(p) == 0 {
+			break
+		}
+		b = append(b, 0xC0|byte(csid))
+	}
+	_, err := w.Write(b)
+	return err
+}
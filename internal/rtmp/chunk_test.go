@@ -0,0 +1,220 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func keepAll(uint8) bool { return true }
+
+// payload is n bytes counting up from first.
+func payload(first byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = first + byte(i)
+	}
+	return b
+}
+
+// join concatenates chunk headers and payloads.
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		keep func(uint8) bool
+		want []message
+	}{
+		{
+			// RTMP specification, 5.3.2.1: four audio messages of 32 bytes
+			// on chunk stream 3, the first with a full header, the second
+			// with a timestamp delta and the others with no header.
+			"header compression",
+			join(
+				unhex("03 0003e8 000020 08 39300000"), payload(0, 32),
+				unhex("83 000014"), payload(32, 32),
+				unhex("c3"), payload(64, 32),
+				unhex("c3"), payload(96, 32),
+			),
+			keepAll,
+			[]message{
+				{msgAudio, 12345, payload(0, 32)},
+				{msgAudio, 12345, payload(32, 32)},
+				{msgAudio, 12345, payload(64, 32)},
+				{msgAudio, 12345, payload(96, 32)},
+			},
+		},
+		{
+			// 5.3.2.2: a 307 byte video message split into 128 byte
+			// chunks on chunk stream 4.
+			"split message",
+			join(
+				unhex("04 0003e8 000133 09 3a300000"), payload(0, 128),
+				unhex("c4"), payload(128, 128),
+				unhex("c4"), payload(0, 51),
+			),
+			keepAll,
+			[]message{{msgVideo, 12346, join(payload(0, 128), payload(128, 128), payload(0, 51))}},
+		},
+		{
+			"skipped message",
+			join(
+				unhex("04 0003e8 000133 09 3a300000"), payload(0, 128),
+				unhex("c4"), payload(128, 128),
+				unhex("c4"), payload(0, 51),
+			),
+			func(typ uint8) bool { return typ != msgVideo },
+			[]message{{msgVideo, 12346, nil}},
+		},
+		{
+			"extended timestamp",
+			join(
+				unhex("03 ffffff 0000c8 08 01000000 0001e240"), payload(0, 128),
+				unhex("c3 0001e240"), payload(128, 72),
+			),
+			keepAll,
+			[]message{{msgAudio, 1, payload(0, 200)}},
+		},
+		{
+			"two byte and three byte chunk stream IDs",
+			join(
+				unhex("00 00 000000 000002 12 01000000"), payload(0, 2),
+				unhex("01 01 01 000000 000002 12 01000000"), payload(2, 2),
+			),
+			keepAll,
+			[]message{{msgDataAMF0, 1, payload(0, 2)}, {msgDataAMF0, 1, payload(2, 2)}},
+		},
+		{
+			// A message on another chunk stream completes between the
+			// chunks of one in progress.
+			"interleaved",
+			join(
+				unhex("04 000000 0000c8 08 01000000"), payload(0, 128),
+				unhex("03 000000 000003 14 00000000"), payload(0, 3),
+				unhex("c4"), payload(128, 72),
+			),
+			keepAll,
+			[]message{{msgCommandAMF0, 0, payload(0, 3)}, {msgAudio, 1, payload(0, 200)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newChunkReader(bytes.NewReader(tt.in), tt.keep)
+			for i, want := range tt.want {
+				got, err := cr.readMessage()
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if got.typ != want.typ || got.stream != want.stream || !bytes.Equal(got.payload, want.payload) {
+					t.Errorf("message %d: got type %d, stream %d, %d bytes; want type %d, stream %d, %d bytes",
+						i, got.typ, got.stream, len(got.payload), want.typ, want.stream, len(want.payload))
+				}
+				if (got.payload == nil) != (want.payload == nil) {
+					t.Errorf("message %d: payload kept %v, want %v", i, got.payload != nil, want.payload != nil)
+				}
+			}
+			if _, err := cr.readMessage(); err != io.EOF {
+				t.Errorf("got %v after the last message, want EOF", err)
+			}
+			if cr.received != uint64(len(tt.in)) {
+				t.Errorf("counted %d bytes received, want %d", cr.received, len(tt.in))
+			}
+		})
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		keep func(uint8) bool
+	}{
+		{"no full header", unhex("43 000000 000002 08"), keepAll},
+		{"too large", unhex("03 000000 100001 08 01000000"), keepAll},
+		{"truncated", join(unhex("03 000000 000010 08 01000000"), payload(0, 8)), keepAll},
+	} {
+		if _, err := newChunkReader(bytes.NewReader(tt.in), tt.keep).readMessage(); err == nil || err == io.EOF {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+	// Video, which isn't kept, may be any size.
+	in := join(unhex("03 000000 100001 09 01000000"), make([]byte, 1<<20+1+(1<<20)/128))
+	for i := 1; i <= (1<<20)/128; i++ {
+		in[11+i*129] = 0xc3
+	}
+	msg, err := newChunkReader(bytes.NewReader(in), func(typ uint8) bool { return typ != msgVideo }).readMessage()
+	if err != nil || msg.typ != msgVideo {
+		t.Errorf("large video: got type %d, error %v", msg.typ, err)
+	}
+}
+
+func TestChunkSizeAndAbort(t *testing.T) {
+	in := join(
+		// A 200 byte message started on chunk stream 4, then aborted.
+		unhex("04 000000 0000c8 08 01000000"), payload(0, 128),
+		unhex("02 000000 000004 02 00000000 00000004"),
+		// The chunk size raised to 256, and a new 200 byte message in one
+		// chunk.
+		unhex("02 000000 000004 01 00000000 00000100"),
+		unhex("04 000000 0000c8 08 01000000"), payload(50, 200),
+	)
+	cr := newChunkReader(bytes.NewReader(in), keepAll)
+	cr.window = 170
+	msg, err := cr.readMessage()
+	if err != nil || msg.typ != msgAbort {
+		t.Fatalf("got type %d, error %v; want abort", msg.typ, err)
+	}
+	cr.abort(4)
+	if n, due := cr.ackDue(); due {
+		t.Errorf("ack of %d bytes due before the window", n)
+	}
+	msg, err = cr.readMessage()
+	if err != nil || msg.typ != msgSetChunkSize {
+		t.Fatalf("got type %d, error %v; want set chunk size", msg.typ, err)
+	}
+	cr.chunkSize = 256
+	if n, due := cr.ackDue(); !due || n != 12+128+16+16 {
+		t.Errorf("ack of %d bytes, due %v; want 172", n, due)
+	}
+	msg, err = cr.readMessage()
+	if err != nil || !bytes.Equal(msg.payload, payload(50, 200)) {
+		t.Errorf("got %d bytes, error %v; want the new message", len(msg.payload), err)
+	}
+	if n, due := cr.ackDue(); !due || n != 172+12+200 {
+		t.Errorf("ack of %d bytes, due %v; want 384", n, due)
+	}
+	if _, due := cr.ackDue(); due {
+		t.Error("ack due again within the window")
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, csCommand, msgCommandAMF0, 1, payload(0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if want := join(unhex("03 000000 000005 14 01000000"), payload(0, 5)); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got  % x\nwant % x", buf.Bytes(), want)
+	}
+
+	// A message past the chunk size is split, and reads back whole.
+	buf.Reset()
+	big := bytes.Repeat(payload(0, 100), 100)
+	if err := writeMessage(&buf, csStatus, msgDataAMF0, 1, big); err != nil {
+		t.Fatal(err)
+	}
+	want := join(unhex("05 000000 002710 12 01000000"), big[:4096], unhex("c5"), big[4096:8192], unhex("c5"), big[8192:])
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Error("large message not split at the chunk size")
+	}
+	cr := newChunkReader(&buf, keepAll)
+	cr.chunkSize = outChunkSize
+	msg, err := cr.readMessage()
+	if err != nil || msg.typ != msgDataAMF0 || !bytes.Equal(msg.payload, big) {
+		t.Errorf("got type %d, %d bytes, error %v", msg.typ, len(msg.payload), err)
+	}
+}
@@ -0,0 +1,307 @@
+// Package rtmp receives streams published over RTMP, the protocol OBS and
+// most other broadcasting tools push live video and audio with. It does
+// only what a server taking one publish per connection needs: the
+// handshake, chunking, the commands leading up to publish, and turning the
+// audio track into a plain MP3 or ADTS AAC stream. Video is read and
+// thrown away.
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Status codes for Reject. Clients show the description with them.
+const (
+	StatusBadName = "NetStream.Publish.BadName" // the stream is taken
+	StatusDenied  = "NetStream.Publish.Denied"
+)
+
+// Publish is what a client asked to publish: rtmp://host/<App>/<Key>, as
+// the server URL and stream key are split in most tools.
+type Publish struct {
+	App string
+	Key string
+}
+
+// Conn is a client connection, which publishes at most one stream.
+type Conn struct {
+	nc     net.Conn
+	cr     *chunkReader
+	start  time.Time
+	app    string
+	stream uint32 // message stream the client publishes on
+
+	demux   demuxer
+	pending []byte // audio not yet read
+}
+
+// NewConn returns a connection for a client that has just connected to nc.
+func NewConn(nc net.Conn) *Conn {
+	c := &Conn{nc: nc, start: time.Now()}
+	c.cr = newChunkReader(nc, keepMessage)
+	return c
+}
+
+// keepMessage reports whether messages of a type are read rather than
+// skipped: everything but video and other data nickcast has no use for.
+func keepMessage(typ uint8) bool {
+	switch typ {
+	case msgSetChunkSize, msgAbort, msgAck, msgUserControl, msgWindowAck, msgPeerBW,
+		msgAudio, msgDataAMF0, msgCommandAMF0, msgCommandAMF3:
+		return true
+	}
+	return false
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error { return c.nc.Close() }
+
+// RemoteAddr returns the client's address.
+func (c *Conn) RemoteAddr() net.Addr { return c.nc.RemoteAddr() }
+
+// SetDeadline sets the deadline for reads and writes, as on a net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error { return c.nc.SetDeadline(t) }
+
+// SetReadDeadline sets the deadline for reads, as on a net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.nc.SetReadDeadline(t) }
+
+// ReadPublish runs the handshake and answers the client's commands until it
+// asks to publish, and returns what it asked for. The request must then be
+// answered with Accept or Reject.
+func (c *Conn) ReadPublish() (*Publish, error) {
+	if err := handshake(c.nc, c.start); err != nil {
+		return nil, err
+	}
+	connected := false
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		name, txn, args, ok := parseCommand(msg)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "connect":
+			obj, _ := args[0].(amfObj)
+			app, _ := obj["app"].(string)
+			c.app = strings.TrimSuffix(app, "/")
+			encoding, _ := obj["objectEncoding"].(float64)
+			if err := c.connected(txn, encoding); err != nil {
+				return nil, err
+			}
+			connected = true
+		case "releaseStream", "FCPublish":
+			if txn != 0 {
+				if err := c.command(csCommand, 0, "_result", txn, nil, nil); err != nil {
+					return nil, err
+				}
+			}
+		case "createStream":
+			if err := c.command(csCommand, 0, "_result", txn, nil, float64(1)); err != nil {
+				return nil, err
+			}
+		case "publish":
+			if !connected {
+				return nil, errors.New("rtmp: publish before connect")
+			}
+			key, _ := args[1].(string)
+			c.stream = msg.stream
+			return &Publish{App: c.app, Key: key}, nil
+		case "play":
+			return nil, errors.New("rtmp: the client wants to play, not publish")
+		case "deleteStream", "FCUnpublish", "closeStream":
+			return nil, io.EOF
+		}
+	}
+}
+
+// connected answers connect.
+func (c *Conn) connected(txn, encoding float64) error {
+	var b [5]byte
+	binary.BigEndian.PutUint32(b[:], outChunkSize)
+	if err := writeMessage(c.nc, csControl, msgSetChunkSize, 0, b[:4]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b[:], windowSize)
+	if err := writeMessage(c.nc, csControl, msgWindowAck, 0, b[:4]); err != nil {
+		return err
+	}
+	b[4] = 2 // dynamic
+	if err := writeMessage(c.nc, csControl, msgPeerBW, 0, b[:5]); err != nil {
+		return err
+	}
+	return c.command(csCommand, 0, "_result", txn,
+		[]amfProp{{"fmsVer", "FMS/3,5,7,7009"}, {"capabilities", float64(31)}, {"mode", float64(1)}},
+		[]amfProp{
+			{"level", "status"},
+			{"code", "NetConnection.Connect.Success"},
+			{"description", "Connection succeeded."},
+			{"objectEncoding", encoding},
+		})
+}
+
+// Accept lets the client start publishing.
+func (c *Conn) Accept() error {
+	var b [6]byte
+	binary.BigEndian.PutUint16(b[0:], eventStreamBegin)
+	binary.BigEndian.PutUint32(b[2:], c.stream)
+	if err := writeMessage(c.nc, csControl, msgUserControl, 0, b[:]); err != nil {
+		return err
+	}
+	return c.status("status", "NetStream.Publish.Start", "Publishing.")
+}
+
+// Reject turns down the client's publish request, or stops a stream it
+// has been publishing, with one of the Status codes and a description
+// for whoever runs it. The connection should be closed after.
+func (c *Conn) Reject(code, description string) error {
+	return c.status("error", code, description)
+}
+
+func (c *Conn) status(level, code, description string) error {
+	return c.command(csStatus, c.stream, "onStatus", float64(0), nil,
+		[]amfProp{{"level", level}, {"code", code}, {"description", description}})
+}
+
+func (c *Conn) command(csid, stream uint32, vals ...interface{}) error {
+	return writeMessage(c.nc, csid, msgCommandAMF0, stream, encodeAMF(vals...))
+}
+
+// Codec waits for the first audio and returns its codec, CodecMP3 or
+// CodecAAC.
+func (c *Conn) Codec() (string, error) {
+	for c.demux.codec == "" {
+		if err := c.next(); err != nil {
+			return "", err
+		}
+	}
+	return c.demux.codec, nil
+}
+
+// Read reads the published audio, as MP3 frames or ADTS AAC. It returns
+// io.EOF once the client stops publishing, and an *UnsupportedCodecError
+// for audio in any other codec.
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// next reads and handles one message of the published stream.
+func (c *Conn) next() error {
+	msg, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	switch msg.typ {
+	case msgAudio:
+		if msg.stream != c.stream {
+			return nil
+		}
+		b, err := c.demux.audio(msg.payload)
+		if err != nil {
+			return err
+		}
+		c.pending = b
+	case msgCommandAMF0, msgCommandAMF3:
+		switch name, _, _, _ := parseCommand(msg); name {
+		case "deleteStream", "FCUnpublish", "closeStream":
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+// readMessage reads the next message, dealing with protocol control
+// messages along the way, and acknowledges what has been read when the
+// client's window calls for it.
+func (c *Conn) readMessage() (message, error) {
+	for {
+		msg, err := c.cr.readMessage()
+		if err != nil {
+			return msg, err
+		}
+		if n, ok := c.cr.ackDue(); ok {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], n)
+			if err := writeMessage(c.nc, csControl, msgAck, 0, b[:]); err != nil {
+				return msg, err
+			}
+		}
+		p := msg.payload
+		switch msg.typ {
+		case msgSetChunkSize:
+			if len(p) < 4 {
+				return msg, errors.New("rtmp: short Set Chunk Size")
+			}
+			size := binary.BigEndian.Uint32(p) & 0x7FFFFFFF
+			if size < 1 || size > 1<<24 {
+				return msg, fmt.Errorf("rtmp: chunk size %d", size)
+			}
+			c.cr.chunkSize = size
+		case msgAbort:
+			if len(p) >= 4 {
+				c.cr.abort(binary.BigEndian.Uint32(p))
+			}
+		case msgWindowAck:
+			if len(p) >= 4 {
+				c.cr.window = binary.BigEndian.Uint32(p)
+			}
+		case msgUserControl:
+			if len(p) >= 6 && binary.BigEndian.Uint16(p) == eventPingRequest {
+				reply := append([]byte{0, eventPingReply}, p[2:6]...)
+				if err := writeMessage(c.nc, csControl, msgUserControl, 0, reply); err != nil {
+					return msg, err
+				}
+			}
+		case msgAck, msgPeerBW:
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// parseCommand splits a command message into its name, transaction ID and
+// arguments, the first of which is the command object. There are always at
+// least two arguments, nil if the client left them out.
+func parseCommand(msg message) (string, float64, []interface{}, bool) {
+	p := msg.payload
+	switch msg.typ {
+	case msgCommandAMF3:
+		// AMF3 commands start with a marker switching to AMF0, which is
+		// what all clients send them in.
+		if len(p) < 1 {
+			return "", 0, nil, false
+		}
+		p = p[1:]
+	case msgCommandAMF0:
+	default:
+		return "", 0, nil, false
+	}
+	// Whatever decodes is used: a value nickcast can't read in an
+	// argument it doesn't need is no reason to ignore the command.
+	vals, _ := decodeAMF(p)
+	if len(vals) < 2 {
+		return "", 0, nil, false
+	}
+	name, ok := vals[0].(string)
+	if !ok {
+		return "", 0, nil, false
+	}
+	txn, _ := vals[1].(float64)
+	args := append(vals[2:], nil, nil)
+	return name, txn, args, true
+}
@@ -0,0 +1,111 @@
+package rtmp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	rtmpVersion    = 3
+	handshakeSize  = 1536
+	digestSize     = sha256.Size
+	digestSpan     = 728 // where in its half of the packet a digest may go
+	serverVersion  = 0x04050001
+	clientKeyBytes = 30 // of clientKey, for the client's digest
+	serverKeyBytes = 36 // of serverKey, for the server's
+)
+
+// The keys of the "complex" handshake Flash Player introduced, which
+// clients such as OBS and ffmpeg use and some expect answered in kind.
+var (
+	keyTail   = []byte{0xF0, 0xEE, 0xC2, 0x4A, 0x80, 0x68, 0xBE, 0xE8, 0x2E, 0x00, 0xD0, 0xD1, 0x02, 0x9E, 0x7E, 0x57, 0x6E, 0xEC, 0x5D, 0x2D, 0x29, 0x80, 0x6F, 0xAB, 0x93, 0xB8, 0xE6, 0x36, 0xCF, 0xEB, 0x31, 0xAE}
+	clientKey = append([]byte("Genuine Adobe Flash Player 001"), keyTail...)
+	serverKey = append([]byte("Genuine Adobe Flash Media Server 001"), keyTail...)
+)
+
+var errVersion = errors.New("rtmp: unsupported protocol version")
+
+// handshake answers a client's C0 and C1 with S0, S1 and S2, and reads its
+// C2. A client whose C1 carries a digest gets the complex handshake; any
+// other gets the plain one, where S2 echoes C1.
+func handshake(rw io.ReadWriter, start time.Time) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(rw, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != rtmpVersion {
+		return errVersion
+	}
+	c1 := c0c1[1:]
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = rtmpVersion
+	s1, s2 := s0s1s2[1:1+handshakeSize], s0s1s2[1+handshakeSize:]
+	rand.Read(s1[8:])
+	binary.BigEndian.PutUint32(s1[0:], uint32(time.Since(start).Milliseconds()))
+
+	if scheme, digest := clientDigest(c1); digest != nil {
+		binary.BigEndian.PutUint32(s1[4:], serverVersion)
+		off := digestOffset(s1, scheme)
+		copy(s1[off:], hmacDigest(serverKey[:serverKeyBytes], s1, off))
+
+		rand.Read(s2)
+		key := hmacSum(serverKey, digest)
+		copy(s2[handshakeSize-digestSize:], hmacSum(key, s2[:handshakeSize-digestSize]))
+	} else {
+		copy(s2, c1)
+		binary.BigEndian.PutUint32(s2[4:], uint32(time.Since(start).Milliseconds()))
+	}
+	if _, err := rw.Write(s0s1s2); err != nil {
+		return err
+	}
+	// Clients vary in what they put in C2, so it is not checked.
+	_, err := io.ReadFull(rw, make([]byte, handshakeSize))
+	return err
+}
+
+// clientDigest finds the digest in c1 and returns it with the scheme (0 or
+// 1) it was placed by, or nil if c1 has none.
+func clientDigest(c1 []byte) (int, []byte) {
+	if binary.BigEndian.Uint32(c1[4:]) == 0 {
+		return 0, nil
+	}
+	for _, scheme := range []int{0, 1} {
+		off := digestOffset(c1, scheme)
+		if hmac.Equal(c1[off:off+digestSize], hmacDigest(clientKey[:clientKeyBytes], c1, off)) {
+			return scheme, c1[off : off+digestSize]
+		}
+	}
+	return 0, nil
+}
+
+// digestOffset is where scheme puts the digest of a C1 or S1 packet p: the
+// four bytes at the start of its half of the packet, summed, say how far
+// into the rest of that half.
+func digestOffset(p []byte, scheme int) int {
+	base := 8
+	if scheme == 1 {
+		base = 772
+	}
+	sum := int(p[base]) + int(p[base+1]) + int(p[base+2]) + int(p[base+3])
+	return base + 4 + sum%digestSpan
+}
+
+// hmacDigest is the digest of p, leaving out the digest itself at off.
+func hmacDigest(key, p []byte, off int) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(p[:off])
+	mac.Write(p[off+digestSize:])
+	return mac.Sum(nil)
+}
+
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
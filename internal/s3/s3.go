@@ -0,0 +1,156 @@
+// Package s3 writes objects to an S3-compatible bucket: Amazon S3, or the
+// likes of Cloudflare R2, Backblaze B2 and MinIO that speak its API. It
+// does only what pushing HLS to an origin takes, PUT and DELETE, signing
+// requests with AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyHash is the SHA-256 of an empty body, which DELETE sends.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Bucket is a place in a bucket to write objects under: keys are taken
+// relative to URL, which names the bucket, path-style
+// (https://s3.eu-west-1.amazonaws.com/my-bucket/radio) or virtual-hosted
+// (https://my-bucket.s3.eu-west-1.amazonaws.com/radio).
+type Bucket struct {
+	URL       *url.URL
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// New returns a bucket for rawURL, signing as accessKey in region.
+func New(rawURL, region, accessKey, secretKey string) (*Bucket, error) {
+	u, err := url.Parse(strings.TrimRight(rawURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("s3: %q is not an http(s) URL", rawURL)
+	}
+	return &Bucket{
+		URL:       u,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Put writes body to key, with the given Content-Type and Cache-Control,
+// which the bucket hands on to whoever fetches it.
+func (b *Bucket) Put(ctx context.Context, key, contentType, cacheControl string, body []byte) error {
+	req, err := b.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cacheControl != "" {
+		req.Header.Set("Cache-Control", cacheControl)
+	}
+	return b.do(req)
+}
+
+// Delete removes key. A key that isn't there is no error.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	req, err := b.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	return b.do(req)
+}
+
+func (b *Bucket) request(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u := *b.URL
+	u.Path = b.URL.Path + "/" + strings.TrimLeft(key, "/")
+	u.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	hash := emptyHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		hash = hex.EncodeToString(sum[:])
+	}
+	b.sign(req, hash, time.Now().UTC())
+	return req, nil
+}
+
+func (b *Bucket) do(req *http.Request) error {
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && !(req.Method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, whose
+// body has the SHA-256 hash. Host, the content hash and the date are
+// signed, which is all S3 insists on.
+func (b *Bucket) sign(req *http.Request, hash string, t time.Time) {
+	date := t.Format("20060102T150405Z")
+	day := date[:8]
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": hash,
+		"x-amz-date":           date,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		hash,
+	}, "\n")
+	scope := day + "/" + b.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), day)
+	for _, part := range []string{b.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,217 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a recurring weekly time range such as "Mon-Fri 18:00-24:00".
+// A window whose end is before its start runs past midnight, and belongs to
+// the day it starts on.
+type Window struct {
+	Days  [7]bool // indexed by time.Weekday
+	Start int     // minutes after midnight
+	End   int     // minutes after midnight, up to 24*60
+}
+
+// Windows is a set of windows; a time is inside the set if any window has it.
+type Windows []Window
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWindows parses a ";"-separated list of windows. Each window is an
+// optional day spec ("Mon-Fri", "Sat,Sun", "Wed") followed by HH:MM-HH:MM.
+// Without a day spec the window applies every day.
+//
+//	18:00-24:00
+//	Mon-Fri 07:00-09:30; Sat,Sun 10:00-02:00
+func ParseWindows(s string) (Windows, error) {
+	var ws Windows
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		w, err := parseWindow(part)
+		if err != nil {
+			return nil, err
+		}
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+func parseWindow(s string) (Window, error) {
+	var w Window
+	fields := strings.Fields(s)
+	var timeSpec string
+	switch len(fields) {
+	case 1:
+		timeSpec = fields[0]
+		for i := range w.Days {
+			w.Days[i] = true
+		}
+	case 2:
+		if err := parseDays(fields[0], &w.Days); err != nil {
+			return w, err
+		}
+		timeSpec = fields[1]
+	default:
+		return w, fmt.Errorf("invalid window %q", s)
+	}
+
+	times := strings.SplitN(timeSpec, "-", 2)
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.Start, err = parseClock(times[0]); err != nil {
+		return w, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.End, err = parseClock(times[1]); err != nil {
+		return w, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("invalid window %q: empty range", s)
+	}
+	return w, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		from, ok := dayNames[bounds[0]]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = dayNames[bounds[1]]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	hm := strings.SplitN(s, ":", 2)
+	if len(hm) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.Atoi(hm[0])
+	m, err2 := strconv.Atoi(hm[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// occurrence returns the start and end of the window instance that starts on
+// the same calendar day as day (in day's location).
+func (w Window) occurrence(day time.Time) (start, end time.Time) {
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	start = midnight.Add(time.Duration(w.Start) * time.Minute)
+	end = midnight.Add(time.Duration(w.End) * time.Minute)
+	if w.End < w.Start {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+// current returns the end of the window instance containing t, if any.
+func (w Window) current(t time.Time) (time.Time, bool) {
+	// An instance that started yesterday may still be running past midnight.
+	for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+		if !w.Days[day.Weekday()] {
+			continue
+		}
+		start, end := w.occurrence(day)
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Contains reports whether t falls inside any of the windows. An empty set
+// means "no restriction" and contains every time.
+func (ws Windows) Contains(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	_, ok := ws.CloseAt(t)
+	return ok
+}
+
+// CloseAt returns when the window containing t ends. If several overlap, the
+// latest end wins.
+func (ws Windows) CloseAt(t time.Time) (time.Time, bool) {
+	var latest time.Time
+	found := false
+	for _, w := range ws {
+		if end, ok := w.current(t); ok {
+			if !found || end.After(latest) {
+				latest = end
+			}
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// NextOpen returns the next time after t at which a window starts.
+func (ws Windows) NextOpen(t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, w := range ws {
+		for i := 0; i <= 7; i++ {
+			day := t.AddDate(0, 0, i)
+			if !w.Days[day.Weekday()] {
+				continue
+			}
+			start, _ := w.occurrence(day)
+			if start.After(t) {
+				if !found || start.Before(next) {
+					next = start
+				}
+				found = true
+				break
+			}
+		}
+	}
+	return next, found
+}
+
+// String renders the windows back in the configuration syntax.
+func (ws Windows) String() string {
+	parts := make([]string, len(ws))
+	for i, w := range ws {
+		var days []string
+		all := true
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if w.Days[d] {
+				days = append(days, d.String()[:3])
+			} else {
+				all = false
+			}
+		}
+		spec := fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+		if !all {
+			spec = strings.Join(days, ",") + " " + spec
+		}
+		parts[i] = spec
+	}
+	return strings.Join(parts, "; ")
+}
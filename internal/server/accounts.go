@@ -0,0 +1,145 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"nickcast/internal/clock"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// replaceWait bounds how long a source replacing its account's stale
+// session on another mount waits for that session to end.
+const replaceWait = 5 * time.Second
+
+// internalRemotes are the remote addresses of sessions the server runs
+// itself. They carry the account of whoever queued them but aren't that
+// account streaming.
+var internalRemotes = map[string]bool{"announcer": true, "autodj": true, "scheduler": true}
+
+var (
+	// accountHolds maps each account with a source on air, or claimed and
+	// on its way there, to the connection ID holding its one source slot.
+	// claim takes the hold before it takes the mount, so two connections
+	// from one account can't both get on air at once, whichever mounts
+	// they ask for; the session gives it up when it ends.
+	accountHolds   = make(map[string]string)
+	accountHoldsMu sync.Mutex
+)
+
+// holdAccount takes a's account's source slot, from the session a replaces
+// with takeover=1 if that holds it. Replicas were held on the primary.
+func (a *admission) holdAccount() *sourceRefusal {
+	if a.ask.replica {
+		return nil
+	}
+	accountHoldsMu.Lock()
+	defer accountHoldsMu.Unlock()
+	cur, ok := accountHolds[a.user]
+	if ok && !a.replaces(cur) {
+		return a.refuse(http.StatusConflict, "Account "+a.user+" is already streaming; disconnect that source first", "another connection of theirs (%s) is streaming or going on air", cur)
+	}
+	accountHolds[a.user] = a.id
+	a.held, a.prev = true, cur
+	return nil
+}
+
+// replaces reports whether hold belongs to the session a is asking to
+// replace, on another mount or on its own.
+func (a *admission) replaces(hold string) bool {
+	if !a.ask.replace {
+		return false
+	}
+	if a.stale != nil && a.stale.hold == hold {
+		return true
+	}
+	s := a.m.currentSession()
+	return s != nil && s.account == a.user && s.hold == hold
+}
+
+// dropHold gives up a's hold on its account's source slot, handing it back
+// to the session a was to replace if that is still on air. Calling it again
+// does nothing.
+func (a *admission) dropHold() {
+	if !a.held {
+		return
+	}
+	a.held = false
+	accountHoldsMu.Lock()
+	defer accountHoldsMu.Unlock()
+	if accountHolds[a.user] != a.id {
+		return
+	}
+	if s := accountSession(a.user); s != nil && a.prev != "" && s.hold == a.prev {
+		accountHolds[a.user] = a.prev
+		return
+	}
+	delete(accountHolds, a.user)
+}
+
+// releaseAccount frees account's source slot if hold still has it.
+func releaseAccount(account, hold string) {
+	if hold == "" {
+		return
+	}
+	accountHoldsMu.Lock()
+	defer accountHoldsMu.Unlock()
+	if accountHolds[account] == hold {
+		delete(accountHolds, account)
+	}
+}
+
+// accountSession returns the live session of account's own source on any
+// mount, or nil if it has none. Mono copies and the server's own sessions
+// don't count.
+func accountSession(account string) *sourceSession {
+	for _, m := range mounts() {
+		if m.cfg.DownmixOf != "" {
+			continue
+		}
+		if s := m.currentSession(); s != nil && s.account == account && !internalRemotes[s.remote] {
+			return s
+		}
+	}
+	return nil
+}
+
+// wantsReplace reports whether a source connection asks to replace its
+// account's live session, with the X-Source-Takeover header or the
+// takeover query parameter.
+func wantsReplace(r *http.Request) bool {
+	v := r.Header.Get("X-Source-Takeover")
+	if v == "" {
+		v = r.URL.Query().Get("takeover")
+	}
+	ok, _ := strconv.ParseBool(v)
+	return ok
+}
+
+// replaceStale ends the account's live session on another mount for an
+// admin connecting with takeover=1: one account, one source, but an
+// encoder that left a stale session behind shouldn't lock its admin out.
+// claim calls it only once it has a's mount, so a source turned away by
+// any check, claim's own included, leaves the old session be. On a's own
+// mount claim takes over from the session instead, keeping its listeners.
+// Closing cancel gives up waiting.
+func (a *admission) replaceStale(cancel <-chan struct{}) *sourceRefusal {
+	s := a.stale
+	if s == nil {
+		return nil
+	}
+
+	log.Printf("[%s] Streamer %s from %s is replacing their session on %s from %s", a.id, a.user, a.remote, s.m.cfg.Name, s.remote)
+	s.m.evictSource("replaced by a new connection from " + a.remote)
+	t := clock.Default.NewTimer(replaceWait)
+	defer t.Stop()
+	select {
+	case <-s.done:
+		return nil
+	case <-cancel:
+		return a.refuse(http.StatusConflict, "Gave up replacing the old session", "gave up waiting for their session on %s to end", s.m.cfg.Name)
+	case <-t.C():
+		return a.refuse(http.StatusConflict, "The old session on "+s.m.cfg.Name+" did not end in time", "their session on %s did not end in time", s.m.cfg.Name)
+	}
+}
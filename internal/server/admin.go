@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+)
+
+// requireUser authenticates the request against the station's NickServ. On
+// failure it writes the response itself.
+func requireUser(w http.ResponseWriter, r *http.Request, st *station) (string, bool) {
+	user, pass, ok := credentials(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="NickStream"`)
+		http.Error(w, "Unauthorized - no credentials", http.StatusUnauthorized)
+		return "", false
+	}
+	if valid, err := st.authenticate(user, pass); err != nil || !valid {
+		logf(r, "Auth failed for user %s from %s: %v", user, r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return user, true
+}
+
+// requireAdmin authenticates the request and checks the account is one of
+// the station's admins. On failure it writes the response itself.
+func requireAdmin(w http.ResponseWriter, r *http.Request, st *station) (string, bool) {
+	user, ok := requireUser(w, r, st)
+	if !ok {
+		return "", false
+	}
+	if !st.isAdmin(user) {
+		logf(r, "User %s from %s is not an admin", user, r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return user, true
+}
+
+// metadataHandler implements Icecast's /admin/metadata?mode=updinfo API, which
+// butt, Mixxx, liquidsoap and friends use to push the current song title.
+// Only the account currently streaming to that mount may update it. Titles
+// replicated from the primary are only set, as they are as it played them.
+func metadataHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if mode := q.Get("mode"); mode != "" && mode != "updinfo" {
+		http.Error(w, "Unsupported mode", http.StatusBadRequest)
+		return
+	}
+
+	m := requestMount(r, q.Get("mount"))
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+
+	user, replica := replicaAccount(r)
+	if !replica {
+		var pass string
+		var ok bool
+		if user, pass, ok = credentials(r); !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="NickStream"`)
+			http.Error(w, "Unauthorized - no credentials", http.StatusUnauthorized)
+			return
+		}
+		if valid, err := m.station.authenticate(user, pass); err != nil || !valid {
+			logf(r, "Metadata auth failed for user %s from %s: %v", user, r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if m.source() != user {
+		http.Error(w, "Only the current streamer can update metadata", http.StatusForbidden)
+		return
+	}
+
+	if replica {
+		// The primary filtered and published the title already; the
+		// standby only keeps up with it.
+		m.setTitle(q.Get("song"))
+	} else if title, keep := m.cfg.TitleFilters.Apply(q.Get("song")); keep {
+		title = policyTitle(r, m, user, title)
+		m.playTitle(requestID(r), user, title)
+		logf(r, "Metadata on %s updated by %s: %q", m.cfg.Name, user, title)
+	} else {
+		logf(r, "Metadata update on %s by %s dropped by title_filter: %q", m.cfg.Name, user, title)
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte("<?xml version=\"1.0\"?>\n<iceresponse><message>Metadata update successful</message><return>1</return></iceresponse>\n"))
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// sourceRefusal is why a source was turned away: the status an HTTP source
+// is answered with and the message for whoever set up the encoder. Front
+// ends that don't speak HTTP say what their protocol can of it.
+type sourceRefusal struct {
+	status     int
+	msg        string
+	retryAfter int // seconds until it is worth trying again, if known
+}
+
+// refuseHTTP answers an HTTP or WebSocket source with ref.
+func refuseHTTP(w http.ResponseWriter, ref *sourceRefusal) {
+	if ref.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ref.retryAfter))
+	}
+	http.Error(w, ref.msg, ref.status)
+}
+
+// sourceAsk is what a source connection asks for beyond going on air. Only
+// HTTP sources can ask for any of it.
+type sourceAsk struct {
+	priority int  // to take over a live source with a lower one; see sourcePriority
+	override bool // an admin overriding the mount's bookings
+	replace  bool // takeover=1: replace the account's own stale session
+	handoff  bool // to take over by handoff; see handOver
+	replica  bool // a push from the primary, which admitted it there
+}
+
+// httpSourceAsk reads what an HTTP source connection asks for: a priority
+// (see sourcePriority), an X-Source-Override header or ?override=1,
+// takeover=1 (see wantsReplace) and a handoff (see wantsHandoff).
+func httpSourceAsk(r *http.Request) (sourceAsk, error) {
+	priority, err := sourcePriority(r)
+	if err != nil {
+		return sourceAsk{}, err
+	}
+	override, _ := strconv.ParseBool(r.Header.Get("X-Source-Override"))
+	if !override {
+		override, _ = strconv.ParseBool(r.URL.Query().Get("override"))
+	}
+	return sourceAsk{priority: priority, override: override, replace: wantsReplace(r), handoff: wantsHandoff(r)}, nil
+}
+
+// admission is a source admitSource has let in, still to go on air with
+// claim.
+type admission struct {
+	m                *mount
+	id, user, remote string
+	ask              sourceAsk
+	stale            *sourceSession // the account's session on another mount, to end; see replaceStale
+
+	// The account's source slot, once claim holds it, and the hold it
+	// took over from a session being replaced; see holdAccount.
+	held bool
+	prev string
+}
+
+// admitSource runs the checks every source goes through once its account
+// is known, whatever protocol it came by: the mount's broadcast window, the
+// account's source_ip addresses, the priority it may ask for, the mount's
+// bookings (which admins may override), that the account's time slot on
+// the mount hasn't ended, that the account isn't streaming
+// already, and that the mount is to be had: free, not held for another
+// account to reconnect, or live with a source this one may take over from.
+// Nothing is disturbed yet; that waits for claim, once the front end has
+// made its own checks too. A replica was checked on the primary, so only
+// the mount's own checks apply to it. The refusal is logged under id.
+func (m *mount) admitSource(id, user, remote string, ask sourceAsk) (*admission, *sourceRefusal) {
+	a := &admission{m: m, id: id, user: user, remote: remote, ask: ask}
+	t := now()
+	if !m.cfg.Windows.Contains(t) {
+		ref := a.refuse(http.StatusForbidden, "This mount is off air right now (broadcast window: "+m.cfg.Windows.String()+")", "outside broadcast window (%s)", m.cfg.Windows)
+		if next, ok := m.cfg.Windows.NextOpen(t); ok {
+			ref.retryAfter = int(next.Sub(t).Seconds()) + 1
+		}
+		return nil, ref
+	}
+	if !ask.replica {
+		if !m.station.sourceAllowed(user, remote) {
+			return nil, a.refuse(http.StatusForbidden, "Forbidden - this account may not broadcast from your address", "not one of the account's source_ip addresses")
+		}
+		if max, capped := m.station.maxPriority(user); capped && ask.priority > max {
+			return nil, a.refuse(http.StatusForbidden, "Account "+user+" may ask for priority "+strconv.Itoa(max)+" at most", "asked for priority %d, above its max_priority %d", ask.priority, max)
+		}
+		holder, ok := m.bookingAllows(user, ask.override)
+		if !ok {
+			return nil, a.refuse(http.StatusForbidden, bookingRefusal(holder), "not their booking (booked for %q)", holder)
+		}
+		if holder != user && ask.override {
+			log.Printf("[%s] Admin %s overrode %s's booking of %s", id, user, holder, m.cfg.Name)
+		}
+		if slotEnded(m.cfg.Name, user) {
+			return nil, a.refuse(http.StatusForbidden, "Your time slot on "+m.cfg.Name+" has ended", "their time slot has ended")
+		}
+		if s := accountSession(user); s != nil {
+			if !ask.replace {
+				return nil, a.refuse(http.StatusConflict, "Account "+user+" is already streaming to "+s.m.cfg.Name+"; disconnect that source first", "already streaming to %s from %s", s.m.cfg.Name, s.remote)
+			}
+			if !m.station.isAdmin(user) {
+				return nil, a.refuse(http.StatusForbidden, "Only admins can replace their live session with takeover=1", "takeover=1 is for admins")
+			}
+			if s.m != m {
+				a.stale = s
+			}
+		}
+	}
+	if held := m.reservedFor(); held != "" && held != user {
+		return nil, a.refuse(http.StatusConflict, "Stream is held for its streamer to reconnect", "the stream is held for %s to reconnect", held)
+	}
+
+	switch state := m.loadState(); {
+	case state == stateIdle || state == stateGrace:
+	case state != stateLive:
+		return nil, a.refuse(http.StatusConflict, "Stream already active", "a stream is already active")
+	case ask.replace:
+		if src := m.source(); src != user {
+			return nil, a.refuse(http.StatusConflict, "Stream already active; takeover=1 only replaces your own session", "asked to replace their session, but %s is live", src)
+		}
+	case m.canTakeOver(ask.priority), m.handoffDue(user, ask.handoff):
+	default:
+		return nil, a.refuse(http.StatusConflict, "Stream already active", "a stream is already active")
+	}
+	return a, nil
+}
+
+// claim puts a on the mount: it holds the account's source slot (see
+// holdAccount), claims the mount if it is free, and otherwise takes over as
+// admitSource found it may, from the account's own stale session, by
+// priority, or by handoff once the live DJ's warning has run out. Only once
+// the mount is a's does it end the account's stale session on another
+// mount, if asked to (see replaceStale). Only now is any live source
+// disconnected, so one turned away by any check before this has not cut a
+// show. The mount may have changed hands since admitSource, and another
+// connection from the account may have got in first, so claim can still
+// refuse. Closing cancel gives up waiting. A successful claim must be
+// followed by startSession or releaseSource.
+func (a *admission) claim(cancel <-chan struct{}) (ref *sourceRefusal) {
+	m := a.m
+	if ref := a.holdAccount(); ref != nil {
+		return ref
+	}
+	defer func() {
+		if ref != nil {
+			a.dropHold()
+		}
+	}()
+	switch {
+	case m.claimSource():
+		m.setPriority(a.ask.priority)
+	case a.ask.replace && m.source() == a.user:
+		if !m.takeOver(a.id, a.remote, a.user, a.ask.priority, cancel, byAccount) {
+			return a.refuse(http.StatusConflict, "Stream already active", "could not replace their own session")
+		}
+	case m.canTakeOver(a.ask.priority):
+		if !m.takeOver(a.id, a.remote, a.user, a.ask.priority, cancel, byPriority) {
+			return a.refuse(http.StatusConflict, "Stream already active at the same or a higher priority", "could not take over at priority %d", a.ask.priority)
+		}
+	case m.handoffDue(a.user, a.ask.handoff):
+		if ref := a.handOver(cancel); ref != nil {
+			return ref
+		}
+	default:
+		return a.refuse(http.StatusConflict, "Stream already active", "a stream is already active")
+	}
+	m.stateMu.Lock()
+	m.claim = a
+	m.stateMu.Unlock()
+	if held, ok := m.admitResume(a.user); !ok {
+		m.releaseSource() // Release stream lock
+		return a.refuse(http.StatusConflict, "Stream is held for its streamer to reconnect", "the stream is held for %s to reconnect", held)
+	}
+	if ref := a.replaceStale(cancel); ref != nil {
+		m.releaseSource()
+		return ref
+	}
+	return nil
+}
+
+// refuse logs why a's source is turned away and returns what it is told.
+func (a *admission) refuse(status int, msg, why string, args ...interface{}) *sourceRefusal {
+	log.Printf("[%s] Streamer %s from %s refused on %s: "+why, append([]interface{}{a.id, a.user, a.remote, a.m.cfg.Name}, args...)...)
+	return &sourceRefusal{status: status, msg: msg}
+}
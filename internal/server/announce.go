@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/shows"
+	"nickcast/internal/tts"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// announceCheckInterval is how often due announcements are looked for.
+	announceCheckInterval = time.Second
+	// announceExpiry drops announcements that couldn't air this long after
+	// they were due; "coming up next" is worthless an hour later.
+	announceExpiry = 10 * time.Minute
+	// speakTimeout bounds one run of the speech synthesizer.
+	speakTimeout = time.Minute
+)
+
+// synth turns announcement text into audio; nil when TTS isn't configured.
+var synth *tts.Synth
+
+// announcement is a synthesized liner waiting for its moment on a mount.
+type announcement struct {
+	ID       string    `json:"id"`
+	Mount    string    `json:"mount"`
+	Account  string    `json:"account"`
+	Text     string    `json:"text"`
+	At       time.Time `json:"at"`
+	Duration float64   `json:"duration"` // seconds
+	audio    []byte
+}
+
+var announcements struct {
+	mu      sync.Mutex
+	pending []*announcement
+	seq     int
+}
+
+// queueAnnouncement adds a to the pending list, keeping it in due order.
+func queueAnnouncement(a *announcement) {
+	announcements.mu.Lock()
+	defer announcements.mu.Unlock()
+	if a.ID == "" {
+		announcements.seq++
+		a.ID = fmt.Sprintf("%s-%d", a.Mount, announcements.seq)
+	}
+	announcements.pending = append(announcements.pending, a)
+	sort.SliceStable(announcements.pending, func(i, j int) bool {
+		return announcements.pending[i].At.Before(announcements.pending[j].At)
+	})
+}
+
+// takeAnnouncement removes and returns the next due announcement for m.
+// Announcements overdue by more than announceExpiry are discarded.
+func takeAnnouncement(m *mount) *announcement {
+	t := clock.Default.Now()
+	announcements.mu.Lock()
+	defer announcements.mu.Unlock()
+	for i := 0; i < len(announcements.pending); i++ {
+		a := announcements.pending[i]
+		if a.Mount != m.cfg.Name || a.At.After(t) {
+			continue
+		}
+		announcements.pending = append(announcements.pending[:i], announcements.pending[i+1:]...)
+		if t.Sub(a.At) > announceExpiry {
+			log.Printf("Announcement %s on %s expired without airing", a.ID, a.Mount)
+			i--
+			continue
+		}
+		return a
+	}
+	return nil
+}
+
+// announceHandler serves /admin/announce:
+//
+//	GET  /admin/announce[?mount=]                           pending announcements
+//	POST /admin/announce?mount=&text=|template=[&lang=]&at=  synthesize and queue one
+//
+// Announcements air as soon as they're due (at defaults to now) and the
+// mount has no live source. Instead of text, template names one of the
+// announce.* messages, spoken in lang or the mount's language; its
+// {placeholders} are filled from parameters of the same names, with
+// {station} and {mount} filled in already.
+func announceHandler(w http.ResponseWriter, r *http.Request) {
+	var m *mount
+	if ref := r.FormValue("mount"); ref != "" || r.Method == http.MethodPost {
+		if m = requestMount(r, ref); m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+	}
+	st := defaultStation()
+	if m != nil {
+		st = m.station
+	}
+	user, ok := requireAdmin(w, r, st)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list := []*announcement{}
+		announcements.mu.Lock()
+		for _, a := range announcements.pending {
+			if m == nil || a.Mount == m.cfg.Name {
+				list = append(list, a)
+			}
+		}
+		announcements.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if synth == nil {
+		http.Error(w, "Text-to-speech is not configured", http.StatusNotImplemented)
+		return
+	}
+	text := r.FormValue("text")
+	if name := r.FormValue("template"); text == "" && name != "" {
+		if text = m.announcementText(r, name); text == "" {
+			http.Error(w, "Unknown template "+name, http.StatusBadRequest)
+			return
+		}
+	}
+	if text == "" {
+		http.Error(w, "Missing text or template", http.StatusBadRequest)
+		return
+	}
+	at := clock.Default.Now()
+	if s := r.FormValue("at"); s != "" {
+		t, err := parseShowTime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		at = t
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), speakTimeout)
+	defer cancel()
+	audio, err := synth.Speak(ctx, text)
+	if err != nil {
+		logf(r, "Speech synthesis failed: %v", err)
+		http.Error(w, "Speech synthesis failed", http.StatusBadGateway)
+		return
+	}
+	if ext, ok := shows.Sniff(audio); !ok || ext != extensionFor(m.cfg.ContentType) {
+		logf(r, "Speech synthesizer output is not %s", m.cfg.ContentType)
+		http.Error(w, "Speech synthesizer output doesn't match the mount's format", http.StatusBadGateway)
+		return
+	}
+	rate, err := audioRate(m, bytes.NewReader(audio), int64(len(audio)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	duration := time.Duration(float64(len(audio)) / rate * float64(time.Second))
+
+	a := &announcement{Mount: m.cfg.Name, Account: user, Text: text, At: at, Duration: duration.Seconds(), audio: audio}
+	queueAnnouncement(a)
+	logf(r, "Announcement %s queued by %s for %s at %s (%s): %q", a.ID, user, m.cfg.Name, at.Format(time.RFC3339), duration.Round(time.Millisecond), text)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(a)
+}
+
+// announcementText renders the announce.<name> message for a POST to
+// /admin/announce, or returns "" if there is no such message.
+func (m *mount) announcementText(r *http.Request, name string) string {
+	key := "announce." + name
+	lang := r.FormValue("lang")
+	if lang == "" {
+		lang = m.cfg.Language
+	}
+	station := m.station.cfg.Title
+	if station == "" {
+		station = m.station.cfg.Name
+	}
+	args := []string{"station", station, "mount", m.cfg.Name}
+	for k, v := range r.Form {
+		if k != "station" && k != "mount" && len(v) > 0 {
+			args = append(args, k, v[0])
+		}
+	}
+	text := catalogs.T(lang, key, args...)
+	if text == key {
+		return ""
+	}
+	return text
+}
+
+// playAnnouncement writes a into an existing session at its natural rate.
+func playAnnouncement(ctx context.Context, sess *sourceSession, a *announcement) error {
+	sess.logf("Announcement %s on %s: %q", a.ID, a.Mount, a.Text)
+	_, err := sess.pump(ctx, bytes.NewReader(a.audio), float64(len(a.audio))/a.Duration)
+	return err
+}
+
+// runAnnouncer airs due announcements on mounts that have no source,
+// holding the mount for as many announcements as are due back to back.
+func runAnnouncer(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	t := clock.Default.NewTicker(announceCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		for _, m := range mounts() {
+			if m.active() && !m.canTakeOver(0) {
+				continue
+			}
+			a := takeAnnouncement(m)
+			if a == nil {
+				continue
+			}
+			if !m.claimOrPreempt("announce-"+a.ID, "announcer", a.Account, ctx.Done()) {
+				// A source connected in the meantime; try again next tick.
+				queueAnnouncement(a)
+				continue
+			}
+			if _, ok := m.admitResume(""); !ok {
+				// The stream is held for its streamer to come back.
+				m.releaseSource()
+				queueAnnouncement(a)
+				continue
+			}
+			wg.Add(1)
+			go func(m *mount, a *announcement) {
+				defer wg.Done()
+				airAnnouncements(ctx, m, a)
+			}(m, a)
+		}
+	}
+}
+
+func airAnnouncements(ctx context.Context, m *mount, a *announcement) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id := "announce-" + a.ID
+	sess := m.startSession(a.Account, id, "announcer", "", func(reason string) {
+		log.Printf("[%s] Stopping announcements on %s: %s", id, m.cfg.Name, reason)
+		cancel()
+	})
+	defer sess.end()
+	for ; a != nil; a = takeAnnouncement(m) {
+		m.setTitle(a.Text)
+		if err := playAnnouncement(ctx, sess, a); err != nil {
+			return
+		}
+	}
+}
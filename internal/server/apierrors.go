@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxErrorMessage bounds the reason kept from a handler's error reply.
+const maxErrorMessage = 4096
+
+// errorCodes are the machine-readable codes API errors carry, by status.
+// They are part of the API: clients may switch on them, so keep them
+// stable and in step with the Error schema in openapi.json.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+// apiError is the body of an error reply from /api and /admin.
+type apiError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds, as in Retry-After
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+// errorCode returns the code for an error status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "server_error"
+	}
+	return "client_error"
+}
+
+// plainErrorPaths keep Icecast's plain-text errors: encoders and Icecast
+// tools call them and don't expect JSON.
+var plainErrorPaths = map[string]bool{
+	"/admin/metadata":    true,
+	"/admin/listclients": true,
+	"/admin/stats":       true,
+	"/admin/stats.xml":   true,
+}
+
+// apiErrorMiddleware turns the plain-text errors handlers reply with
+// through http.Error into JSON for the API and admin endpoints, so clients
+// get a code to act on rather than a sentence to parse. Replies that are
+// already JSON, and successful ones, pass through untouched.
+func apiErrorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if !strings.HasPrefix(p, "/api/") && !strings.HasPrefix(p, "/admin/") || plainErrorPaths[p] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &errorWriter{ResponseWriter: w, r: r}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// errorWriter holds back a reply written by http.Error, which it knows by
+// the headers http.Error sets, so finish can send it as JSON.
+type errorWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	started bool
+	status  int // of the held-back error, or 0
+	msg     bytes.Buffer
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	if w.started {
+		return
+	}
+	w.started = true
+	h := w.Header()
+	if code >= 400 && h.Get("Content-Type") == "text/plain; charset=utf-8" && h.Get("X-Content-Type-Options") == "nosniff" {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		if room := maxErrorMessage - w.msg.Len(); room > 0 {
+			if len(b) < room {
+				room = len(b)
+			}
+			w.msg.Write(b[:room])
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorWriter) Flush() {
+	if w.status != 0 {
+		return
+	}
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom keeps sendfile working for downloads, as statusWriter's does.
+func (w *errorWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status == 0 {
+		if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(r)
+		}
+	}
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends a held-back error as JSON.
+func (w *errorWriter) finish() {
+	if w.status == 0 {
+		return
+	}
+	e := apiError{
+		Code:      errorCode(w.status),
+		Message:   strings.TrimSpace(w.msg.String()),
+		RequestID: requestID(w.r),
+	}
+	h := w.Header()
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = secs
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	json.NewEncoder(w.ResponseWriter).Encode(e)
+}
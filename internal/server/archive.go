@@ -0,0 +1,283 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/archive"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var transcoder *archive.Transcoder
+
+// archiveHandler serves the recordings directory:
+//
+//	GET /archive/              JSON list of recordings with their sidecars
+//	GET /archive/<id>.<ext>    a recording, transcoded on demand if <ext>
+//	                           differs from the original or ?bitrate= is set
+//	GET /archive/<id>.peaks.json  audiowaveform-style peaks for web players
+//	GET /archive/<id>.chapters.json  chapters split at metadata changes
+//	GET /archive/<id>.vtt      WebVTT captions, for mounts with transcribe
+//	GET /archive/<id>/<n>      chapter n on its own, in the original format
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/archive")
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		archiveList(w, r)
+		return
+	}
+	w = shapedResponse(w, r, config.AppConfig.ArchiveMaxRate)
+
+	if strings.HasSuffix(name, archive.ChaptersExt) {
+		archiveChapters(w, r, strings.TrimSuffix(name, archive.ChaptersExt))
+		return
+	}
+	if i := strings.Index(name, "/"); i > 0 {
+		archiveChapter(w, r, name[:i], name[i+1:])
+		return
+	}
+	if strings.HasSuffix(name, archive.PeaksExt) {
+		archivePeaks(w, r, strings.TrimSuffix(name, archive.PeaksExt))
+		return
+	}
+	if strings.HasSuffix(name, archive.CaptionsExt) {
+		archiveCaptions(w, r, strings.TrimSuffix(name, archive.CaptionsExt))
+		return
+	}
+
+	ext := path.Ext(name)
+	id := strings.TrimSuffix(name, ext)
+	src, ok := archive.Find(config.AppConfig.RecordDir, id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	bitrate := r.URL.Query().Get("bitrate")
+	if ext == "" || (ext == filepath.Ext(src) && bitrate == "") {
+		// The original, with range support courtesy of ServeFile.
+		http.ServeFile(w, r, src)
+		return
+	}
+
+	archiveTranscoded(w, r, src, id, strings.TrimPrefix(ext, "."), bitrate)
+}
+
+// archiveList serves the archive index, paged as described at listQuery
+// and filtered by ?mount=, ?account= and ?since=/?until= (modification
+// time, i.e. when the recording ended).
+func archiveList(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, "-modified", "modified", "size", "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	since, until, err := timeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	all, err := archive.List(config.AppConfig.RecordDir)
+	if err != nil {
+		logf(r, "Error listing archive: %v", err)
+		http.Error(w, "Error listing archive", http.StatusInternalServerError)
+		return
+	}
+
+	mount, account := r.URL.Query().Get("mount"), r.URL.Query().Get("account")
+	entries := []archive.Entry{}
+	for _, e := range all {
+		if mount != "" && (e.Sidecar == nil || e.Sidecar.Mount != mount) {
+			continue
+		}
+		if account != "" && (e.Sidecar == nil || !strings.EqualFold(e.Sidecar.Account, account)) {
+			continue
+		}
+		if (!since.IsZero() && e.Modified.Before(since)) || (!until.IsZero() && !e.Modified.Before(until)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	q.sort(entries, func(i, j int) bool {
+		switch q.Sort {
+		case "size":
+			return entries[i].Size < entries[j].Size
+		case "id":
+			return entries[i].ID < entries[j].ID
+		default:
+			return entries[i].Modified.Before(entries[j].Modified)
+		}
+	})
+	lo, hi := q.page(w, r, len(entries))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries[lo:hi])
+}
+
+// loadChapters returns the recording and its chapters, writing an error
+// response if either is unavailable.
+func loadChapters(w http.ResponseWriter, r *http.Request, id string) (string, []archive.Chapter, bool) {
+	src, ok := archive.Find(config.AppConfig.RecordDir, id)
+	if !ok {
+		http.NotFound(w, r)
+		return "", nil, false
+	}
+	sc, err := archive.ReadSidecar(src)
+	if err != nil {
+		http.Error(w, "No track information for this recording", http.StatusNotFound)
+		return "", nil, false
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		http.NotFound(w, r)
+		return "", nil, false
+	}
+	return src, archive.Chapters(sc, info.Size()), true
+}
+
+func archiveChapters(w http.ResponseWriter, r *http.Request, id string) {
+	_, chapters, ok := loadChapters(w, r, id)
+	if !ok {
+		return
+	}
+	if chapters == nil {
+		chapters = []archive.Chapter{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chapters)
+}
+
+// archiveChapter serves one chapter as a byte slice of the original. MP3 and
+// ADTS AAC are sequences of self-contained frames, so players resync at the
+// first whole frame of the slice. Ogg and FLAC need the headers at the
+// start of the file, which a slice from the middle doesn't have, so their
+// chapters are refused; players can seek to them in the whole recording.
+func archiveChapter(w http.ResponseWriter, r *http.Request, id, num string) {
+	src, chapters, ok := loadChapters(w, r, id)
+	if !ok {
+		return
+	}
+	if ext := filepath.Ext(src); ext == ".ogg" || ext == ".flac" {
+		http.Error(w, "Chapters of "+strings.TrimPrefix(ext, ".")+" recordings can't be downloaded on their own; seek to the chapter's start in the whole recording", http.StatusUnsupportedMediaType)
+		return
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 1 || n > len(chapters) {
+		http.NotFound(w, r)
+		return
+	}
+	c := chapters[n-1]
+
+	f, err := os.Open(src)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ext := filepath.Ext(src)
+	filename := fmt.Sprintf("%s-%02d%s", id, n, ext)
+	w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	section := io.NewSectionReader(f, c.StartByte, c.EndByte-c.StartByte)
+	http.ServeContent(w, r, filename, info.ModTime(), section)
+}
+
+// archiveCaptions serves the captions made while a recording was on air.
+func archiveCaptions(w http.ResponseWriter, r *http.Request, id string) {
+	src, ok := archive.Find(config.AppConfig.RecordDir, id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	path := archive.CaptionsPath(src)
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "No captions for this recording", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	http.ServeFile(w, r, path)
+}
+
+// archivePeaks serves a recording's waveform peaks. Peaks are normally made
+// when the recording finishes; for older recordings they're generated on
+// first request, with a 202 telling the client to come back shortly.
+func archivePeaks(w http.ResponseWriter, r *http.Request, id string) {
+	src, ok := archive.Find(config.AppConfig.RecordDir, id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	peaks := archive.PeaksPath(filepath.Join(config.AppConfig.RecordDir, archive.CacheDirName), id)
+	if _, err := os.Stat(peaks); err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, peaks)
+		return
+	}
+	if config.AppConfig.FFmpegPath == "" {
+		http.Error(w, "Peaks are not available for this recording", http.StatusNotFound)
+		return
+	}
+	queuePeaks(src)
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Peaks are being generated; try again shortly", http.StatusAccepted)
+}
+
+func archiveTranscoded(w http.ResponseWriter, r *http.Request, src, id, format, bitrate string) {
+	if transcoder == nil {
+		http.Error(w, "Transcoding is not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+	f, ok := archive.Formats[format]
+	if !ok {
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+	kbps := config.AppConfig.ArchiveBitrate
+	if bitrate != "" {
+		var err error
+		if kbps, err = archive.ParseBitrate(bitrate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	out, err := transcoder.Get(r.Context(), src, id, f, kbps)
+	if err != nil {
+		if r.Context().Err() == nil {
+			logf(r, "Transcoding %s to %s at %dk failed: %v", id, format, kbps, err)
+			http.Error(w, "Transcoding failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	file, err := os.Open(out)
+	if err != nil {
+		http.Error(w, "Transcoding failed", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Transcoding failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", f.ContentType)
+	http.ServeContent(w, r, filepath.Base(out), info.ModTime(), file)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"nickcast/internal/clock"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// autoDJCheckInterval is how often idle mounts with an autodj_dir are
+// checked for having nobody on air.
+const autoDJCheckInterval = time.Second
+
+// autoDJPriority is the auto-DJ's source priority: below any streamer,
+// show or announcement, all of which take the mount over from it.
+const autoDJPriority = -1
+
+// runAutoDJ puts the auto-DJ on air on every mount with an autodj_dir
+// whenever nobody else is, so listeners never find the mount empty.
+func runAutoDJ(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	warned := make(map[*mount]bool) // mounts whose empty directory was logged
+	t := clock.Default.NewTicker(autoDJCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		for _, m := range mounts() {
+			if m.cfg.AutoDJDir == "" || m.active() || !m.cfg.Windows.Contains(now()) {
+				continue
+			}
+			files, err := autoDJFiles(m)
+			if err != nil || len(files) == 0 {
+				if !warned[m] {
+					log.Printf("Auto-DJ on %s has nothing to play in %s: %v", m.cfg.Name, m.cfg.AutoDJDir, err)
+					warned[m] = true
+				}
+				continue
+			}
+			delete(warned, m)
+			if !m.claimSource() {
+				continue // a source connected in the meantime
+			}
+			if _, ok := m.admitResume(""); !ok {
+				// The stream is held for its streamer to come back.
+				m.releaseSource()
+				continue
+			}
+			m.stateMu.Lock()
+			m.priority = autoDJPriority
+			m.stateMu.Unlock()
+			wg.Add(1)
+			go func(m *mount) {
+				defer wg.Done()
+				playAutoDJ(ctx, m)
+			}(m)
+		}
+	}
+}
+
+// autoDJFiles lists the files in the mount's autodj_dir that are in its
+// format, by extension.
+func autoDJFiles(m *mount) ([]string, error) {
+	entries, err := os.ReadDir(m.cfg.AutoDJDir)
+	if err != nil {
+		return nil, err
+	}
+	ext := extensionFor(m.cfg.ContentType)
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.EqualFold(filepath.Ext(e.Name()), ext) {
+			files = append(files, filepath.Join(m.cfg.AutoDJDir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// playAutoDJ plays the mount's autodj_dir in shuffled passes until someone
+// takes the mount over or the directory runs dry. The directory is listed
+// again for every pass, so tracks can be added or removed while it plays.
+// Each track's file name, less its extension, is its title.
+func playAutoDJ(ctx context.Context, m *mount) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id := "autodj-" + strings.TrimPrefix(m.cfg.Name, "/")
+	sess := m.startSession("autodj", id, "autodj", "", func(reason string) {
+		log.Printf("[%s] Stopping auto-DJ on %s: %s", id, m.cfg.Name, reason)
+		cancel()
+	})
+	defer sess.end()
+	sess.logf("Auto-DJ on air on %s from %s", m.cfg.Name, m.cfg.AutoDJDir)
+
+	for ctx.Err() == nil {
+		files, err := autoDJFiles(m)
+		if err != nil || len(files) == 0 {
+			sess.logf("Auto-DJ on %s stopping, nothing to play in %s: %v", m.cfg.Name, m.cfg.AutoDJDir, err)
+			return
+		}
+		rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+		played := 0
+		for _, path := range files {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := playAutoDJTrack(ctx, sess, path); err != nil {
+				sess.logf("Auto-DJ on %s skipping %s: %v", m.cfg.Name, path, err)
+				continue
+			}
+			played++
+		}
+		if played == 0 {
+			sess.logf("Auto-DJ on %s stopping, no track in %s would play", m.cfg.Name, m.cfg.AutoDJDir)
+			return
+		}
+	}
+}
+
+// playAutoDJTrack plays one file into the auto-DJ's session at its natural
+// rate.
+func playAutoDJTrack(ctx context.Context, sess *sourceSession, path string) error {
+	m := sess.m
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rate, err := audioRate(m, f, info.Size())
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	// The file name stands in for a title, and goes through the mount's
+	// title filters like any source's.
+	if title, keep := m.cfg.TitleFilters.Apply(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))); keep {
+		m.playTitle(sess.id, sess.account, title)
+	}
+	_, err = sess.pump(ctx, f, rate)
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
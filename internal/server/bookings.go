@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"nickcast/internal/clock"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bookingCheckInterval is how often live sources are checked against their
+// mount's bookings.
+const bookingCheckInterval = time.Second
+
+// booking reserves a mount for one account's broadcast. Weekly bookings
+// come from book lines in the configuration; one-off bookings, with From
+// and Until, are made through /admin/bookings and last until a restart, unless a
+// store is configured.
+type booking struct {
+	Mount    string     `json:"mount"`
+	Account  string     `json:"account"`
+	Weekly   string     `json:"weekly,omitempty"`
+	From     *time.Time `json:"from,omitempty"`
+	Until    *time.Time `json:"until,omitempty"`
+	BookedBy string     `json:"booked_by,omitempty"`
+}
+
+var bookings struct {
+	mu   sync.Mutex
+	list []*booking // one-off bookings, by From
+}
+
+// bookingAt returns who has the mount booked at t and when their booking
+// ends. One-off bookings come before weekly ones.
+func (m *mount) bookingAt(t time.Time) (account string, ends time.Time, ok bool) {
+	bookings.mu.Lock()
+	for _, b := range bookings.list {
+		if b.Mount == m.cfg.Name && !t.Before(*b.From) && t.Before(*b.Until) {
+			bookings.mu.Unlock()
+			return b.Account, *b.Until, true
+		}
+	}
+	bookings.mu.Unlock()
+	for _, b := range m.cfg.Bookings {
+		if end, ok := b.Windows.CloseAt(t); ok {
+			return b.Account, end, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// hasBookings reports whether the mount has any bookings at all.
+func (m *mount) hasBookings() bool {
+	if len(m.cfg.Bookings) > 0 || m.cfg.BookedOnly {
+		return true
+	}
+	bookings.mu.Lock()
+	defer bookings.mu.Unlock()
+	for _, b := range bookings.list {
+		if b.Mount == m.cfg.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// bookingAllows reports whether user may broadcast on the mount now: while
+// another account's booking is on, or outside any booking with booked_only
+// set, only an admin overriding it may. holder is who has it booked.
+func (m *mount) bookingAllows(user string, override bool) (holder string, ok bool) {
+	holder, _, booked := m.bookingAt(now())
+	if booked && holder == user || !booked && !m.cfg.BookedOnly {
+		return holder, true
+	}
+	return holder, override && m.station.isAdmin(user)
+}
+
+// bookingRefusal explains why bookingAllows turned a streamer away.
+func bookingRefusal(holder string) string {
+	if holder == "" {
+		return "This mount only takes booked broadcasts"
+	}
+	return "This mount is booked for " + holder + " right now"
+}
+
+// watchBookings keeps live sources to the mount's bookings. A streamer on
+// air when someone else's booking starts is taken off, unless they are an
+// admin. A streamer whose booking is followed by someone else's, or who
+// would be outside any booking with booked_only set, gets a time slot to
+// its end, so they are warned 10 and 2 minutes before and taken off air
+// then (see watchSlots).
+func watchBookings(ctx context.Context) error {
+	t := clock.Default.NewTicker(bookingCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		at := now()
+		expireBookings(at)
+		for _, m := range mounts() {
+			s := m.currentSession()
+			if s == nil || !m.hasBookings() {
+				continue
+			}
+			holder, ends, booked := m.bookingAt(at)
+			switch {
+			case booked && holder != s.account:
+				if !m.station.isAdmin(s.account) {
+					m.evictSource("mount booked for " + holder)
+				}
+			case booked:
+				if _, ok := slotEnds(m.cfg.Name, s.account); ok {
+					break
+				}
+				if next, _, ok := m.bookingAt(ends); m.cfg.BookedOnly && !ok || ok && next != s.account {
+					s.logf("Booking for %s on %s ends at %s", s.account, m.cfg.Name, ends.Format(time.RFC3339))
+					grantSlot(&slot{Mount: m.cfg.Name, Account: s.account, Ends: ends, GrantedBy: "booking"})
+				}
+			case m.cfg.BookedOnly:
+				if !m.station.isAdmin(s.account) {
+					m.evictSource("no booking on")
+				}
+			}
+		}
+	}
+}
+
+// expireBookings forgets one-off bookings that are over.
+func expireBookings(t time.Time) {
+	bookings.mu.Lock()
+	defer bookings.mu.Unlock()
+	kept := bookings.list[:0]
+	for _, b := range bookings.list {
+		if b.Until.After(t) {
+			kept = append(kept, b)
+		}
+	}
+	bookings.list = kept
+}
+
+// bookingsHandler serves /admin/bookings:
+//
+//	GET    /admin/bookings[?mount=]                                  weekly and one-off bookings
+//	POST   /admin/bookings?mount=&account=&from=&until=|minutes=     book a one-off
+//	DELETE /admin/bookings?mount=&account=[&from=]                  cancel one-offs
+//
+// Weekly bookings come from the configuration and can't be changed here.
+// One-off bookings may not overlap another account's.
+func bookingsHandler(w http.ResponseWriter, r *http.Request) {
+	var m *mount
+	if ref := r.FormValue("mount"); ref != "" || r.Method != http.MethodGet {
+		if m = requestMount(r, ref); m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+	}
+	st := defaultStation()
+	if m != nil {
+		st = m.station
+	}
+	user, ok := requireAdmin(w, r, st)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list := []booking{}
+		for _, mm := range mounts() {
+			if m != nil && mm != m {
+				continue
+			}
+			for _, b := range mm.cfg.Bookings {
+				list = append(list, booking{Mount: mm.cfg.Name, Account: b.Account, Weekly: b.Windows.String()})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Mount < list[j].Mount })
+		bookings.mu.Lock()
+		for _, b := range bookings.list {
+			if m == nil || b.Mount == m.cfg.Name {
+				list = append(list, *b)
+			}
+		}
+		bookings.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	case http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	account := r.FormValue("account")
+	if account == "" {
+		http.Error(w, "Missing account", http.StatusBadRequest)
+		return
+	}
+	var from time.Time
+	if v := r.FormValue("from"); v != "" {
+		var err error
+		if from, err = parseShowTime(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if r.Method == http.MethodDelete {
+		n := cancelBookings(m.cfg.Name, account, from)
+		if n == 0 {
+			http.Error(w, "No such booking", http.StatusNotFound)
+			return
+		}
+		logf(r, "%d bookings for %s on %s cancelled by %s", n, account, m.cfg.Name, user)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	at := clock.Default.Now()
+	if from.IsZero() {
+		from = at
+	}
+	var until time.Time
+	if v := r.FormValue("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "minutes must be a positive number", http.StatusBadRequest)
+			return
+		}
+		until = from.Add(time.Duration(n) * time.Minute)
+	} else if v := r.FormValue("until"); v != "" {
+		t, err := parseShowTime(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		until = t
+	} else {
+		http.Error(w, "Missing minutes or until", http.StatusBadRequest)
+		return
+	}
+	if !until.After(from) || !until.After(at) {
+		http.Error(w, "The booking would already be over", http.StatusBadRequest)
+		return
+	}
+
+	b := &booking{Mount: m.cfg.Name, Account: account, From: &from, Until: &until, BookedBy: user}
+	if clash := addBooking(b); clash != nil {
+		http.Error(w, "Overlaps "+clash.Account+"'s booking from "+clash.From.Format(time.RFC3339)+" to "+clash.Until.Format(time.RFC3339), http.StatusConflict)
+		return
+	}
+	logf(r, "Booking for %s on %s from %s to %s made by %s", account, m.cfg.Name, from.Format(time.RFC3339), until.Format(time.RFC3339), user)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(b)
+}
+
+// addBooking adds a one-off booking unless it overlaps another account's
+// on the same mount, which it returns instead.
+func addBooking(b *booking) *booking {
+	bookings.mu.Lock()
+	defer bookings.mu.Unlock()
+	for _, old := range bookings.list {
+		if old.Mount == b.Mount && old.Account != b.Account && old.From.Before(*b.Until) && b.From.Before(*old.Until) {
+			return old
+		}
+	}
+	bookings.list = append(bookings.list, b)
+	sort.SliceStable(bookings.list, func(i, j int) bool { return bookings.list[i].From.Before(*bookings.list[j].From) })
+	persist(storeBookings)
+	return nil
+}
+
+// cancelBookings removes account's one-off bookings on mount, or just the
+// one starting at from if it is set, returning how many went.
+func cancelBookings(mount, account string, from time.Time) int {
+	bookings.mu.Lock()
+	defer bookings.mu.Unlock()
+	kept := bookings.list[:0]
+	for _, b := range bookings.list {
+		if b.Mount == mount && b.Account == account && (from.IsZero() || b.From.Equal(from)) {
+			continue
+		}
+		kept = append(kept, b)
+	}
+	n := len(bookings.list) - len(kept)
+	bookings.list = kept
+	if n > 0 {
+		persist(storeBookings)
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"nickcast/config"
+	"nickcast/internal/schedule"
+)
+
+// resetBookings forgets every one-off booking and reads weekly ones in
+// UTC, for the rest of the test.
+func resetBookings(t *testing.T) {
+	loc := config.AppConfig.Location
+	config.AppConfig.Location = time.UTC
+	bookings.mu.Lock()
+	bookings.list = nil
+	bookings.mu.Unlock()
+	t.Cleanup(func() {
+		config.AppConfig.Location = loc
+		bookings.mu.Lock()
+		bookings.list = nil
+		bookings.mu.Unlock()
+	})
+}
+
+// oneOff books account on mount from from to until after start.
+func oneOff(mount, account string, from, until time.Duration) *booking {
+	f, u := start.Add(from), start.Add(until)
+	return &booking{Mount: mount, Account: account, From: &f, Until: &u}
+}
+
+func TestBookingAllows(t *testing.T) {
+	// From Friday 20:00: alice has a one-off from 20:30 to 21:00, and bob
+	// the mount every Friday from 21:00 to 22:00.
+	weekly, err := schedule.ParseWindows("Fri 21:00-22:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at         time.Duration
+		bookedOnly bool
+		user       string
+		override   bool
+		wantHolder string
+		wantOK     bool
+	}{
+		{0, false, "carol", false, "", true},
+		{0, true, "carol", false, "", false},
+		{0, true, "admin", true, "", true},
+		{30 * time.Minute, false, "alice", false, "alice", true},
+		{30 * time.Minute, false, "carol", false, "alice", false},
+		{30 * time.Minute, false, "carol", true, "alice", false}, // not an admin
+		{30 * time.Minute, false, "admin", true, "alice", true},
+		{59 * time.Minute, false, "alice", false, "alice", true},
+		{time.Hour, false, "alice", false, "bob", false},
+		{time.Hour, false, "bob", false, "bob", true},
+		{2 * time.Hour, false, "bob", false, "", true},
+		{2 * time.Hour, true, "bob", false, "", false},
+		{7*24*time.Hour + time.Hour, false, "bob", false, "bob", true}, // next Friday
+	}
+	f := fakeClock(t)
+	resetBookings(t)
+	addBooking(oneOff("/live", "alice", 30*time.Minute, time.Hour))
+	for _, tt := range tests {
+		m := newMount(config.MountConfig{
+			Name:       "/live",
+			Bookings:   []config.Booking{{Account: "bob", Windows: weekly}},
+			BookedOnly: tt.bookedOnly,
+		})
+		m.station = &station{cfg: config.Station{Admins: []string{"admin"}}}
+		f.Set(start.Add(tt.at))
+		holder, ok := m.bookingAllows(tt.user, tt.override)
+		if holder != tt.wantHolder || ok != tt.wantOK {
+			t.Errorf("%s in, booked_only %v, %s (override %v): got %q, %v, want %q, %v",
+				tt.at, tt.bookedOnly, tt.user, tt.override, holder, ok, tt.wantHolder, tt.wantOK)
+		}
+	}
+}
+
+func TestBookingAt(t *testing.T) {
+	fakeClock(t)
+	resetBookings(t)
+	addBooking(oneOff("/live", "alice", 0, time.Hour))
+	m := newMount(config.MountConfig{Name: "/live"})
+	other := newMount(config.MountConfig{Name: "/other"})
+
+	for _, tt := range []struct {
+		m       *mount
+		at      time.Duration
+		account string
+		ends    time.Time
+		ok      bool
+	}{
+		{m, -time.Second, "", time.Time{}, false},
+		{m, 0, "alice", start.Add(time.Hour), true},
+		{m, time.Hour - time.Second, "alice", start.Add(time.Hour), true},
+		{m, time.Hour, "", time.Time{}, false},
+		{other, 0, "", time.Time{}, false},
+	} {
+		account, ends, ok := tt.m.bookingAt(start.Add(tt.at))
+		if account != tt.account || !ends.Equal(tt.ends) || ok != tt.ok {
+			t.Errorf("%s at %s: got %q, %s, %v", tt.m.cfg.Name, tt.at, account, ends, ok)
+		}
+	}
+}
+
+func TestAddBookingOverlap(t *testing.T) {
+	fakeClock(t)
+	resetBookings(t)
+	addBooking(oneOff("/live", "alice", 0, time.Hour))
+	for _, tt := range []struct {
+		b       *booking
+		overlap bool
+	}{
+		{oneOff("/live", "bob", 30*time.Minute, 90*time.Minute), true},
+		{oneOff("/live", "bob", -time.Hour, time.Minute), true},
+		{oneOff("/live", "bob", time.Hour, 2*time.Hour), false}, // back to back
+		{oneOff("/live", "alice", 30*time.Minute, time.Hour), false},
+		{oneOff("/other", "bob", 0, time.Hour), false},
+	} {
+		if old := addBooking(tt.b); (old != nil) != tt.overlap {
+			t.Errorf("%s %s from %s: overlap %v, want %v", tt.b.Mount, tt.b.Account, tt.b.From.Sub(start), old != nil, tt.overlap)
+		}
+	}
+}
+
+func TestExpireBookings(t *testing.T) {
+	f := fakeClock(t)
+	resetBookings(t)
+	addBooking(oneOff("/live", "alice", 0, time.Hour))
+	addBooking(oneOff("/live", "bob", time.Hour, 2*time.Hour))
+
+	f.Advance(time.Hour)
+	expireBookings(f.Now())
+	bookings.mu.Lock()
+	n := len(bookings.list)
+	bookings.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("%d bookings left, want 1", n)
+	}
+	if account, _, _ := newMount(config.MountConfig{Name: "/live"}).bookingAt(f.Now()); account != "bob" {
+		t.Errorf("booked for %q, want bob", account)
+	}
+}
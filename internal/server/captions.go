@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/archive"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"nickcast/internal/transcribe"
+	"nickcast/internal/websocket"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// transcribeTimeout bounds one transcription.
+	transcribeTimeout = time.Minute
+
+	// maxCaptionAudio caps the audio waiting for the transcriber on a
+	// mount; while it is behind, the oldest is dropped, even from the
+	// piece still being added to.
+	maxCaptionAudio = 8 << 20
+
+	// captionBacklog is how many recent captions a new subscriber is sent
+	// first, so it joins mid-sentence with some context.
+	captionBacklog = 20
+
+	// captionQueue is how many captions a subscriber may fall behind by
+	// before it is dropped.
+	captionQueue = 64
+)
+
+var transcriptions = metrics.NewCounterVec("nickcast_transcriptions_total", "Speech-to-text attempts, by result (text, silence or error).", "mount", "result")
+
+// transcriber writes down what is said on mounts with transcribe set; nil
+// when transcription isn't configured.
+var transcriber *transcribe.Transcriber
+
+// caption is what was said on a mount between Start and End.
+type caption struct {
+	Mount string    `json:"mount"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Text  string    `json:"text"`
+}
+
+// captionAudio is a piece of the broadcast waiting to be transcribed,
+// with the recording it belongs in.
+type captionAudio struct {
+	data       []byte
+	start, end time.Time
+	rec        *recorder
+}
+
+// captionFeed collects a mount's broadcast for the transcriber and hands
+// the captions made of it to subscribers.
+type captionFeed struct {
+	mu      sync.Mutex
+	pending []captionAudio // the last one is still being added to
+	size    int
+	recent  []caption
+	subs    map[chan caption]struct{}
+}
+
+func newCaptionFeed() *captionFeed {
+	return &captionFeed{subs: make(map[chan caption]struct{})}
+}
+
+// write adds broadcast data going into rec (nil if the session isn't
+// recorded). A new recording starts a new piece, so no caption straddles
+// two sessions.
+func (f *captionFeed) write(data []byte, rec *recorder) {
+	now := clock.Default.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n := len(f.pending); n == 0 || f.pending[n-1].rec != rec {
+		f.pending = append(f.pending, captionAudio{start: now, rec: rec})
+	}
+	a := &f.pending[len(f.pending)-1]
+	a.data = append(a.data, data...)
+	a.end = now
+	f.size += len(data)
+	for f.size > maxCaptionAudio && len(f.pending) > 1 {
+		f.size -= len(f.pending[0].data)
+		f.pending = f.pending[1:]
+	}
+	if over := f.size - maxCaptionAudio; over > 0 {
+		// One piece, too long on its own: its start goes, and its start
+		// time moves on by the share of it that went.
+		a := &f.pending[0]
+		a.start = a.start.Add(a.end.Sub(a.start) * time.Duration(over) / time.Duration(len(a.data)))
+		a.data = a.data[over:]
+		f.size -= over
+	}
+}
+
+// take returns the audio waiting to be transcribed.
+func (f *captionFeed) take() []captionAudio {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := f.pending
+	f.pending, f.size = nil, 0
+	return pending
+}
+
+// publish sends c to every subscriber, dropping any that have fallen too
+// far behind.
+func (f *captionFeed) publish(c caption) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recent = append(f.recent, c)
+	if len(f.recent) > captionBacklog {
+		f.recent = f.recent[len(f.recent)-captionBacklog:]
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- c:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of new captions, closed if the subscriber
+// falls behind, along with the recent ones.
+func (f *captionFeed) subscribe() (chan caption, []caption) {
+	ch := make(chan caption, captionQueue)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[ch] = struct{}{}
+	return ch, append([]caption(nil), f.recent...)
+}
+
+func (f *captionFeed) unsubscribe(ch chan caption) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// runTranscriber transcribes each mount with transcribe set every
+// transcribe_interval seconds, mounts side by side so a slow one doesn't
+// hold up the rest.
+func runTranscriber(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, m := range mounts() {
+		if m.captions == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(m *mount) {
+			defer wg.Done()
+			m.runCaptions(ctx)
+		}(m)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (m *mount) runCaptions(ctx context.Context) {
+	t := clock.Default.NewTicker(time.Duration(config.AppConfig.TranscribeInterval) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+		for _, a := range m.captions.take() {
+			m.transcribe(ctx, a)
+		}
+	}
+}
+
+// transcribe has a piece of audio written down, from its first frame, and
+// passes the caption on to subscribers and the recording.
+func (m *mount) transcribe(ctx context.Context, a captionAudio) {
+	i := m.syncPoint(a.data)
+	if i < 0 {
+		return // not even a frame
+	}
+	ctx, cancel := context.WithTimeout(ctx, transcribeTimeout)
+	defer cancel()
+	text, err := transcriber.Transcribe(ctx, a.data[i:], m.cfg.ContentType, m.cfg.Language)
+	switch {
+	case err != nil:
+		transcriptions.With(m.cfg.Name, "error").Inc()
+		log.Printf("Transcription on %s failed: %v", m.cfg.Name, err)
+		return
+	case text == "":
+		transcriptions.With(m.cfg.Name, "silence").Inc()
+		return
+	}
+	transcriptions.With(m.cfg.Name, "text").Inc()
+	c := caption{Mount: m.cfg.Name, Start: a.start, End: a.end, Text: text}
+	m.captions.publish(c)
+	if a.rec != nil {
+		a.rec.addCaption(c)
+	}
+}
+
+// addCaption writes c to the recording's captions file.
+func (rec *recorder) addCaption(c caption) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	from, to := c.Start.Sub(rec.sidecar.Start), c.End.Sub(rec.sidecar.Start)
+	if err := archive.AppendCaption(rec.path, from, to, c.Text); err != nil {
+		log.Printf("Error writing captions for %s: %v", rec.path, err)
+	}
+}
+
+// captionsHandler serves GET /api/captions?mount=..., the live captions
+// of a mount with transcribe set: over WebSocket, as one JSON text message
+// per caption, or otherwise as server-sent events named caption. The
+// recent captions come first.
+func captionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref := r.FormValue("mount")
+	if ref == "" {
+		ref = "/stream"
+	}
+	m := requestMount(r, ref)
+	if m == nil || m.captions == nil {
+		http.Error(w, "No captions for "+ref, http.StatusNotFound)
+		return
+	}
+	if !m.admitCountry(w, r) {
+		return
+	}
+	// Caption subscribers count towards max_listeners_per_ip, as they hold
+	// a connection open as listeners do.
+	ipKey := clientPrefix(r)
+	if !acquireIP(ipKey) {
+		logf(r, "Caption subscriber from %s rejected: too many connections from %s", r.RemoteAddr, ipKey)
+		w.Header().Set("Retry-After", strconv.Itoa(m.cfg.RetryAfter))
+		http.Error(w, "Too many connections from your address", http.StatusTooManyRequests)
+		return
+	}
+	defer releaseIP(ipKey)
+	ch, recent := m.captions.subscribe()
+	defer m.captions.unsubscribe(ch)
+	if websocket.IsUpgrade(r) {
+		captionsWebSocket(w, r, ch, recent)
+		return
+	}
+	captionsSSE(w, r, ch, recent)
+}
+
+func captionsSSE(w http.ResponseWriter, r *http.Request, ch chan caption, recent []caption) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	send := func(c caption) error {
+		data, _ := json.Marshal(c)
+		_, err := fmt.Fprintf(w, "event: caption\ndata: %s\n\n", data)
+		flusher.Flush()
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for _, c := range recent {
+		if send(c) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case c, ok := <-ch:
+			if !ok || send(c) != nil {
+				return
+			}
+		}
+	}
+}
+
+func captionsWebSocket(w http.ResponseWriter, r *http.Request, ch chan caption, recent []caption) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		logf(r, "WebSocket upgrade from %s failed: %v", r.RemoteAddr, err)
+		return
+	}
+	// The client has nothing to say; reading only notices it leaving.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	send := func(c caption) error {
+		data, _ := json.Marshal(c)
+		return conn.WriteText(data)
+	}
+	for _, c := range recent {
+		if send(c) != nil {
+			conn.Close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+	for {
+		select {
+		case <-gone:
+			conn.Close(websocket.CloseNormal, "")
+			return
+		case c, ok := <-ch:
+			if !ok {
+				conn.Close(websocket.CloseGoingAway, "Fell behind")
+				return
+			}
+			if send(c) != nil {
+				conn.Close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/archive"
+	"nickcast/internal/clock"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCaptureSeconds = 10
+	maxCaptureSeconds     = 300
+)
+
+// captureIDTime is the layout of the start time in a capture's ID, which
+// follows the mount's file name.
+const captureIDTime = "20060102-150405"
+
+var errCaptureRunning = errors.New("a capture is already running on this mount")
+
+// capturesDir is where debug captures are written, under the recordings
+// directory.
+func capturesDir() string {
+	return filepath.Join(config.AppConfig.RecordDir, "captures")
+}
+
+// capture records, for a fixed time, every byte a mount's source sends
+// before any processing and, optionally, every byte written to one of its
+// listeners, ICY metadata included. Comparing the two shows whether a
+// corrupted stream was broken by the encoder or on the way out.
+type capture struct {
+	ID            string    `json:"id"`
+	Mount         string    `json:"mount"`
+	Started       time.Time `json:"started"`
+	Until         time.Time `json:"until"`
+	SourceFile    string    `json:"source_file"`
+	SourceBytes   int64     `json:"source_bytes"`
+	Listener      string    `json:"listener,omitempty"` // listener ID, as in /admin/listclients
+	ListenerFile  string    `json:"listener_file,omitempty"`
+	ListenerBytes int64     `json:"listener_bytes"`
+	// MetaInt is the ICY metadata interval in the listener file, 0 if the
+	// listener gets plain audio.
+	MetaInt int  `json:"icy_metaint,omitempty"`
+	Done    bool `json:"done"`
+
+	mu       sync.Mutex
+	source   *os.File
+	listener *os.File
+}
+
+// startCapture begins capturing the mount for d, including what is sent to
+// the listener with ID lid if that isn't empty.
+func (m *mount) startCapture(d time.Duration, lid string) (*capture, error) {
+	m.captureMu.Lock()
+	defer m.captureMu.Unlock()
+	if m.capture != nil {
+		return nil, errCaptureRunning
+	}
+	if err := os.MkdirAll(capturesDir(), 0o755); err != nil {
+		return nil, err
+	}
+	now := clock.Default.Now()
+	c := &capture{
+		ID:       fmt.Sprintf("%s-%s", m.fileName(), now.Format(captureIDTime)),
+		Mount:    m.cfg.Name,
+		Started:  now,
+		Until:    now.Add(d),
+		Listener: lid,
+	}
+	ext := extensionFor(m.cfg.ContentType)
+	c.SourceFile = c.ID + "-source" + ext
+	f, err := os.Create(filepath.Join(capturesDir(), c.SourceFile))
+	if err != nil {
+		return nil, err
+	}
+	c.source = f
+	if lid != "" {
+		c.ListenerFile = c.ID + "-listener" + ext
+		f, err := os.Create(filepath.Join(capturesDir(), c.ListenerFile))
+		if err != nil {
+			c.source.Close()
+			return nil, err
+		}
+		c.listener = f
+	}
+	m.capture = c
+
+	go func() {
+		t := clock.Default.NewTimer(d)
+		<-t.C()
+		m.finishCapture(c)
+	}()
+	return c, nil
+}
+
+// finishCapture closes the capture's files and writes its description next
+// to them as <id>.json.
+func (m *mount) finishCapture(c *capture) {
+	m.captureMu.Lock()
+	if m.capture == c {
+		m.capture = nil
+	}
+	m.captureMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range []*os.File{c.source, c.listener} {
+		if f != nil {
+			if err := f.Close(); err != nil {
+				log.Printf("Error closing capture file %s: %v", f.Name(), err)
+			}
+		}
+	}
+	c.source, c.listener = nil, nil
+	c.Done = true
+	if b, err := json.MarshalIndent(c, "", "  "); err == nil {
+		os.WriteFile(filepath.Join(capturesDir(), c.ID+".json"), b, 0o644)
+	}
+	log.Printf("Capture %s finished: %d source bytes, %d listener bytes", c.ID, c.SourceBytes, c.ListenerBytes)
+}
+
+// activeCapture returns the mount's running capture, or nil.
+func (m *mount) activeCapture() *capture {
+	m.captureMu.Lock()
+	defer m.captureMu.Unlock()
+	return m.capture
+}
+
+func (c *capture) writeSource(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.source == nil {
+		return
+	}
+	n, _ := c.source.Write(p)
+	c.SourceBytes += int64(n)
+}
+
+func (c *capture) writeListener(p []byte, metaInt int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listener == nil {
+		return
+	}
+	c.MetaInt = metaInt
+	n, _ := c.listener.Write(p)
+	c.ListenerBytes += int64(n)
+}
+
+// captureSource hands raw source input to a running capture.
+func (m *mount) captureSource(p []byte) {
+	if c := m.activeCapture(); c != nil {
+		c.writeSource(p)
+	}
+}
+
+// captureTap sits directly on a listener's connection and copies what is
+// written to it into a capture that has chosen the listener.
+type captureTap struct {
+	w       io.Writer
+	m       *mount
+	id      string // listener ID
+	metaInt int    // set once the listener is known to get ICY metadata
+}
+
+func (t *captureTap) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if c := t.m.activeCapture(); c != nil && c.Listener == t.id && n > 0 {
+		c.writeListener(p[:n], t.metaInt)
+	}
+	return n, err
+}
+
+// captureHandler serves /admin/capture for the mount's station admins:
+//
+//	POST ?mount=...&seconds=10[&listener=<id>]  start a capture
+//	GET  ?mount=...                             the running capture
+//	GET  ?mount=...&file=<name>                 download a capture file
+func captureHandler(w http.ResponseWriter, r *http.Request) {
+	m := requestMount(r, r.FormValue("mount"))
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+	user, ok := requireAdmin(w, r, m.station)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if name := r.FormValue("file"); name != "" {
+			if !archive.ValidID(name) || !m.isCaptureFile(name) {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			http.ServeFile(w, r, filepath.Join(capturesDir(), name))
+			return
+		}
+		c := m.activeCapture()
+		if c == nil {
+			http.Error(w, "No capture running", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		c.mu.Lock()
+		json.NewEncoder(w).Encode(c)
+		c.mu.Unlock()
+	case http.MethodPost:
+		seconds := defaultCaptureSeconds
+		if s := r.FormValue("seconds"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > maxCaptureSeconds {
+				http.Error(w, "seconds must be between 1 and "+strconv.Itoa(maxCaptureSeconds), http.StatusBadRequest)
+				return
+			}
+			seconds = n
+		}
+		lid := r.FormValue("listener")
+		if lid != "" && !m.hasListener(lid) {
+			http.Error(w, "No such listener on this mount", http.StatusNotFound)
+			return
+		}
+		c, err := m.startCapture(time.Duration(seconds)*time.Second, lid)
+		if errors.Is(err, errCaptureRunning) {
+			http.Error(w, "A capture is already running on this mount", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logf(r, "Error starting capture on %s: %v", m.cfg.Name, err)
+			http.Error(w, "Could not start capture", http.StatusInternalServerError)
+			return
+		}
+		if lid != "" {
+			logf(r, "%s started a %ds capture %s of %s and listener %s", user, seconds, c.ID, m.cfg.Name, lid)
+		} else {
+			logf(r, "%s started a %ds capture %s of %s", user, seconds, c.ID, m.cfg.Name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		c.mu.Lock()
+		json.NewEncoder(w).Encode(c)
+		c.mu.Unlock()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// isCaptureFile reports whether name is one of the files a capture of the
+// mount writes: <id>-source<ext>, <id>-listener<ext> or <id>.json, where
+// the ID is the mount's file name and the start time. Another mount's
+// file name can begin with this one's, so its captures must not match.
+func (m *mount) isCaptureFile(name string) bool {
+	prefix := m.fileName() + "-"
+	rest := strings.TrimPrefix(name, prefix)
+	if !strings.HasPrefix(name, prefix) || len(rest) < len(captureIDTime) {
+		return false
+	}
+	if _, err := time.Parse(captureIDTime, rest[:len(captureIDTime)]); err != nil {
+		return false
+	}
+	ext := extensionFor(m.cfg.ContentType)
+	switch rest[len(captureIDTime):] {
+	case "-source" + ext, "-listener" + ext, ".json":
+		return true
+	}
+	return false
+}
+
+// hasListener reports whether the listener with the given ID is on the
+// mount.
+func (m *mount) hasListener(id string) bool {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	for _, l := range m.listeners {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"nickcast/internal/metrics"
+	"nickcast/internal/mp3"
+	"sync/atomic"
+)
+
+var listenerResyncs = metrics.NewCounter("nickcast_listener_resyncs_total", "Listeners resynchronized from the burst buffer after missing live audio.")
+
+// resyncState tracks a listener that missed live audio, because its queue
+// was full or it was too far behind while memory was tight. Rather than
+// resume it from whatever chunk comes next, which splices the stream
+// mid-frame, broadcasts hold live audio back from it until its queue has
+// drained, and then catchUp sends it what it missed from the burst buffer.
+// from and skipTo are guarded by the mount's listenersMu.
+type resyncState struct {
+	pending atomic.Bool // live audio is held back until catchUp
+	from    int64       // stream offset of the first byte missed
+	skipTo  int64       // stream offset catchUp has already sent up to
+}
+
+// fallBehind marks l as having missed the audio from stream offset off
+// on. It must be called with listenersMu held.
+func (m *mount) fallBehind(l *listener, off int64) {
+	if l.resync.pending.Load() {
+		return
+	}
+	l.resync.from = off
+	l.resync.pending.Store(true)
+	log.Printf("[%s] Listener on %s fell behind; holding live audio until it catches up.", l.ID, m.cfg.Name)
+}
+
+// catchUp returns what l missed, from the burst buffer or the timeshift
+// buffer if that is bigger, and lets live audio through to it again. The
+// listener then hears the stream continue exactly where it stalled. If
+// what it missed is no longer all buffered, it resumes from the first
+// frame boundary in the buffer instead: it skips ahead, and at worst the
+// frame it stalled in is cut short, which players step over cleanly.
+func (m *mount) catchUp(l *listener) []byte {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	m.ringBufferMu.Lock()
+	buffered := m.ringBuffer.Bytes()
+	if m.history != nil && m.cfg.Timeshift > len(buffered) {
+		buffered = m.history.last(m.cfg.Timeshift)
+	}
+	start := m.written - int64(len(buffered))
+	from := l.resync.from
+	var data []byte
+	var skipped int64
+	if from >= start {
+		data = append([]byte(nil), buffered[from-start:]...)
+	} else {
+		data = append([]byte(nil), buffered...)
+		if i := m.syncPoint(data); i > 0 {
+			data = data[i:]
+		}
+		skipped = m.written - int64(len(data)) - from
+	}
+	// Broadcasts already under way hold the tail of data too; they must
+	// not send it again.
+	l.resync.skipTo = m.written
+	m.ringBufferMu.Unlock()
+	l.resync.pending.Store(false)
+
+	listenerResyncs.Inc()
+	if skipped > 0 {
+		log.Printf("[%s] Listener on %s resynchronized at a frame boundary, %d bytes ahead of where it stalled.", l.ID, m.cfg.Name, skipped)
+	} else {
+		log.Printf("[%s] Listener on %s caught up with %d buffered bytes.", l.ID, m.cfg.Name, len(data))
+	}
+	return data
+}
+
+// syncPoint finds the first place in data a decoder can start from: an
+// MP3 frame or an Ogg page. It returns -1 if there is none, and 0 for
+// formats it doesn't know.
+func (m *mount) syncPoint(data []byte) int {
+	switch extensionFor(m.cfg.ContentType) {
+	case ".mp3":
+		return mp3.Sync(data)
+	case ".ogg":
+		return bytes.Index(data, []byte("OggS"))
+	}
+	return 0
+}
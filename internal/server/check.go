@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// sourceCheck is one line of a pre-flight report.
+type sourceCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type sourceCheckReport struct {
+	OK      bool          `json:"ok"`
+	Account string        `json:"account,omitempty"`
+	Mount   string        `json:"mount,omitempty"`
+	Checks  []sourceCheck `json:"checks"`
+}
+
+// formatAliases folds the content types encoders commonly send for the same
+// format onto one name.
+var formatAliases = map[string]string{
+	"audio/mp3":       "audio/mpeg",
+	"audio/mpeg3":     "audio/mpeg",
+	"audio/x-mpeg":    "audio/mpeg",
+	"audio/aacp":      "audio/aac",
+	"audio/x-aac":     "audio/aac",
+	"application/ogg": "audio/ogg",
+	"audio/x-flac":    "audio/flac",
+}
+
+// canonicalFormat reduces a content type to its bare, canonical media type.
+func canonicalFormat(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if alias, ok := formatAliases[t]; ok {
+		return alias
+	}
+	return t
+}
+
+// sourceCheckHandler serves /api/source/check, which runs everything a
+// source connection would check (credentials, mount, broadcast window,
+// bookings, the account streaming elsewhere, required headers, whether someone is already live, declared format)
+// without starting a stream. The format is taken from ?content_type= or the
+// Content-Type header, as an encoder would send it. The response is always a JSON report;
+// the status is 200 only if the stream would be accepted.
+func sourceCheckHandler(w http.ResponseWriter, r *http.Request) {
+	report := sourceCheckReport{OK: true}
+	add := func(name string, ok bool, msg string) {
+		report.Checks = append(report.Checks, sourceCheck{Name: name, OK: ok, Message: msg})
+		report.OK = report.OK && ok
+	}
+
+	ref := r.FormValue("mount")
+	if ref == "" {
+		ref = "/stream"
+	}
+	m := requestMount(r, ref)
+	st := defaultStation()
+	if m != nil {
+		st = m.station
+	}
+
+	if user, pass, ok := credentials(r); !ok {
+		add("credentials", false, "no credentials supplied")
+	} else if valid, err := st.authenticate(user, pass); err != nil {
+		logf(r, "Source check: auth error for %s: %v", user, err)
+		add("credentials", false, "could not reach NickServ")
+	} else if !valid {
+		add("credentials", false, "NickServ rejected the credentials")
+	} else {
+		report.Account = user
+		add("credentials", true, "")
+		if _, bound := st.cfg.SourceIPs[user]; bound {
+			if st.sourceAllowed(user, r.RemoteAddr) {
+				add("address", true, "")
+			} else {
+				add("address", false, "this account may not broadcast from "+clientIP(r))
+			}
+		}
+	}
+
+	if m == nil {
+		add("mount", false, "unknown mount "+ref)
+	} else {
+		report.Mount = m.cfg.Name
+		add("mount", true, "source path "+m.cfg.SourcePath)
+
+		t := now()
+		if m.cfg.Windows.Contains(t) {
+			add("window", true, "")
+		} else {
+			msg := "off air (broadcast window: " + m.cfg.Windows.String() + ")"
+			if next, ok := m.cfg.Windows.NextOpen(t); ok {
+				msg += ", opens in " + strconv.Itoa(int(next.Sub(t).Seconds())+1) + "s"
+			}
+			add("window", false, msg)
+		}
+
+		if len(m.cfg.SourceHeaders) > 0 {
+			if name := m.missingSourceHeader(r); name != "" {
+				add("headers", false, "missing or wrong "+name+" header")
+			} else {
+				add("headers", true, "")
+			}
+		}
+
+		if report.Account != "" && m.hasBookings() {
+			if holder, ok := m.bookingAllows(report.Account, false); !ok {
+				add("booking", false, "not your booking: "+bookingRefusal(holder))
+			} else {
+				add("booking", true, "")
+			}
+		}
+
+		if report.Account != "" && slotEnded(m.cfg.Name, report.Account) {
+			add("slot", false, "your time slot on this mount has ended")
+		}
+
+		if report.Account != "" {
+			if s := accountSession(report.Account); s != nil && s.m != m {
+				add("account", false, "already streaming to "+s.m.cfg.Name)
+			}
+		}
+
+		if m.active() {
+			msg := "another source is live"
+			if src := m.source(); src != "" && src == report.Account {
+				msg = "you are already live on this mount"
+			}
+			add("available", false, msg)
+		} else if held := m.reservedFor(); held != "" && held != report.Account {
+			add("available", false, "held for "+held+" to reconnect")
+		} else {
+			add("available", true, "")
+		}
+
+		declared := r.FormValue("content_type")
+		if declared == "" {
+			declared = r.Header.Get("Content-Type")
+		}
+		have, want := canonicalFormat(declared), canonicalFormat(m.cfg.ContentType)
+		switch {
+		case declared == "":
+			add("format", true, "not declared; mount expects "+m.cfg.ContentType)
+		case have == want:
+			add("format", true, "")
+		case sniffedFormats[have] && sniffedFormats[want]:
+			// sniffSource will spot it and tell listeners.
+			add("format", true, "mount is "+m.cfg.ContentType+"; listeners will be sent "+have)
+		default:
+			add("format", false, "mount expects "+m.cfg.ContentType+", not "+declared)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.OK {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(report)
+}
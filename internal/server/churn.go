@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	churnWindow     = time.Minute
+	churnBaseDelay  = time.Second
+	churnJanitorInt = time.Minute
+)
+
+var (
+	churnDelays = metrics.NewCounter("nickcast_churn_delays_total", "Listener connections delayed for reconnecting too often.")
+	churnBans   = metrics.NewCounter("nickcast_churn_bans_total", "Temporary bans issued for reconnecting too often.")
+)
+
+// churnTracker spots players stuck in a reconnect loop. Each IP prefix and
+// User-Agent pair gets a sliding one-minute window of connection times; past the limit
+// every extra connection waits twice as long as the last, and once that wait
+// would exceed the configured maximum the client is banned for a while.
+type churnTracker struct {
+	mu      sync.Mutex
+	entries map[string]*churnEntry
+}
+
+type churnEntry struct {
+	times       []time.Time
+	bannedUntil time.Time
+}
+
+var churn = &churnTracker{entries: make(map[string]*churnEntry)}
+
+// check records a connection attempt and returns how long to delay it, or
+// the time until which the client is banned. newBan is true only for the
+// attempt that triggered the ban, so callers can log it once.
+func (c *churnTracker) check(key string) (delay time.Duration, bannedUntil time.Time, newBan bool) {
+	limit := config.AppConfig.ChurnLimit
+	if limit <= 0 {
+		return 0, time.Time{}, false
+	}
+	now := clock.Default.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entries[key]
+	if e == nil {
+		e = &churnEntry{}
+		c.entries[key] = e
+	}
+	if now.Before(e.bannedUntil) {
+		return 0, e.bannedUntil, false
+	}
+
+	e.times = append(pruneBefore(e.times, now.Add(-churnWindow)), now)
+	excess := len(e.times) - limit
+	if excess <= 0 {
+		return 0, time.Time{}, false
+	}
+
+	delay = churnBaseDelay << uint(excess-1)
+	maxDelay := time.Duration(config.AppConfig.ChurnMaxDelay) * time.Second
+	if excess > 30 || delay > maxDelay {
+		e.bannedUntil = now.Add(time.Duration(config.AppConfig.ChurnBan) * time.Second)
+		e.times = nil
+		churnBans.Inc()
+		return 0, e.bannedUntil, true
+	}
+	churnDelays.Inc()
+	return delay, time.Time{}, false
+}
+
+// pruneBefore drops timestamps older than cutoff from the sorted slice.
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// janitor periodically forgets clients that have gone quiet.
+func (c *churnTracker) janitor(ctx context.Context) error {
+	t := clock.Default.NewTicker(churnJanitorInt)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		now := clock.Default.Now()
+		c.mu.Lock()
+		for key, e := range c.entries {
+			e.times = pruneBefore(e.times, now.Add(-churnWindow))
+			if len(e.times) == 0 && !now.Before(e.bannedUntil) {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// admitChurn applies churn protection to a listener request. It reports false
+// if the request was rejected or the client went away while being delayed.
+func admitChurn(w http.ResponseWriter, r *http.Request) bool {
+	key := clientPrefix(r) + "|" + r.UserAgent()
+	delay, bannedUntil, newBan := churn.check(key)
+
+	if newBan {
+		logf(r, "Listener from %s (%s) banned until %s for reconnecting too often", r.RemoteAddr, r.UserAgent(), bannedUntil.Format(time.RFC3339))
+	}
+	if !bannedUntil.IsZero() {
+		retry := int(bannedUntil.Sub(clock.Default.Now()).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		requestError(w, r, http.StatusTooManyRequests, "listen.reconnects")
+		return false
+	}
+	if delay == 0 {
+		return true
+	}
+
+	logf(r, "Listener from %s is reconnecting too often; delaying %s", r.RemoteAddr, delay)
+	t := clock.Default.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// clientIP returns the IP part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"nickcast/config"
+)
+
+// churnConfig sets churn_limit, churn_max_delay and churn_ban for the rest
+// of the test.
+func churnConfig(t *testing.T, limit, maxDelay, ban int) {
+	c := &config.AppConfig
+	old := [3]int{c.ChurnLimit, c.ChurnMaxDelay, c.ChurnBan}
+	c.ChurnLimit, c.ChurnMaxDelay, c.ChurnBan = limit, maxDelay, ban
+	t.Cleanup(func() { c.ChurnLimit, c.ChurnMaxDelay, c.ChurnBan = old[0], old[1], old[2] })
+}
+
+func TestChurn(t *testing.T) {
+	// With a limit of 3 a minute, a 4s maximum delay and a 60s ban, each
+	// case connects at the given offsets and expects the last connection's
+	// delay, or a ban until the given offset.
+	tests := []struct {
+		name   string
+		at     []time.Duration
+		delay  time.Duration
+		banned time.Duration
+	}{
+		{"under the limit", []time.Duration{0, 1, 2}, 0, 0},
+		{"first over", []time.Duration{0, 1, 2, 3}, time.Second, 0},
+		{"doubling", []time.Duration{0, 1, 2, 3, 4, 5}, 4 * time.Second, 0},
+		{"banned", []time.Duration{0, 1, 2, 3, 4, 5, 6}, 0, 66},
+		{"still banned", []time.Duration{0, 1, 2, 3, 4, 5, 6, 65}, 0, 66},
+		{"ban over", []time.Duration{0, 1, 2, 3, 4, 5, 6, 66}, 0, 0},
+		{"window slides", []time.Duration{0, 1, 2, 60, 61}, time.Second, 0},
+		{"window passed", []time.Duration{0, 1, 2, 61, 62}, 0, 0},
+	}
+	churnConfig(t, 3, 4, 60)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fakeClock(t)
+			c := &churnTracker{entries: make(map[string]*churnEntry)}
+			var delay time.Duration
+			var bannedUntil time.Time
+			for _, at := range tt.at {
+				f.Set(start.Add(at * time.Second))
+				delay, bannedUntil, _ = c.check("192.0.2.0|player")
+			}
+			if delay != tt.delay {
+				t.Errorf("delay %s, want %s", delay, tt.delay)
+			}
+			var banned time.Duration
+			if !bannedUntil.IsZero() {
+				banned = bannedUntil.Sub(start) / time.Second
+			}
+			if banned != tt.banned {
+				t.Errorf("banned until %ds, want %ds", banned, tt.banned)
+			}
+		})
+	}
+}
+
+func TestChurnNewBan(t *testing.T) {
+	// With no delay allowed, the first connection over the limit is banned.
+	churnConfig(t, 1, 0, 60)
+	fakeClock(t)
+	c := &churnTracker{entries: make(map[string]*churnEntry)}
+	c.check("a")
+	if _, _, newBan := c.check("a"); !newBan {
+		t.Fatal("ban not reported")
+	}
+	if _, _, newBan := c.check("a"); newBan {
+		t.Error("ban reported twice")
+	}
+	if delay, until, _ := c.check("b"); delay != 0 || !until.IsZero() {
+		t.Error("another client held back")
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"nickcast/internal/clock"
+	"sync/atomic"
+	"time"
+)
+
+// listener describes one connected listener for /admin/listclients.
+type listener struct {
+	ID        string    `json:"id"` // request ID, as in the logs
+	Mount     string    `json:"mount"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Device    string    `json:"device,omitempty"` // matched [device] profile
+	Connected time.Time `json:"connected"`
+	Buffered  int64     `json:"buffered_bytes"` // queued and not yet sent
+
+	queued *atomic.Int64 // see bufferedBytes
+	resync *resyncState
+}
+
+func newListener(r *http.Request) *listener {
+	l := &listener{
+		ID:        requestID(r),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Connected: clock.Default.Now(),
+		queued:    new(atomic.Int64),
+		resync:    &resyncState{},
+	}
+	if p := deviceProfile(l.UserAgent); p != nil {
+		l.Device = p.Name
+	}
+	return l
+}
+
+// clients returns a snapshot of the mount's listeners.
+func (m *mount) clients() []listener {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	out := make([]listener, 0, len(m.listeners))
+	for _, l := range m.listeners {
+		c := *l
+		c.Mount = m.cfg.Name
+		c.Buffered = l.queued.Load()
+		out = append(out, c)
+	}
+	return out
+}
+
+// listClientsHandler serves /admin/listclients, Icecast's listener list, as
+// JSON. ?mount= limits it to one mount, which that station's admins may
+// see; without it, default station admins get every mount's listeners.
+// Paged as described at listQuery and filtered by ?ip= and ?device=.
+func listClientsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, "connected", "connected", "ip", "mount")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var list []listener
+	if ref := r.URL.Query().Get("mount"); ref != "" {
+		m := requestMount(r, ref)
+		if m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+		if _, ok := requireAdmin(w, r, m.station); !ok {
+			return
+		}
+		list = m.clients()
+	} else {
+		if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+			return
+		}
+		for _, m := range mounts() {
+			list = append(list, m.clients()...)
+		}
+	}
+
+	ip, device := r.URL.Query().Get("ip"), r.URL.Query().Get("device")
+	filtered := []listener{}
+	for _, l := range list {
+		if (ip != "" && l.IP != ip) || (device != "" && l.Device != device) {
+			continue
+		}
+		filtered = append(filtered, l)
+	}
+	q.sort(filtered, func(i, j int) bool {
+		switch q.Sort {
+		case "ip":
+			return filtered[i].IP < filtered[j].IP
+		case "mount":
+			return filtered[i].Mount < filtered[j].Mount
+		default:
+			return filtered[i].Connected.Before(filtered[j].Connected)
+		}
+	})
+	lo, hi := q.page(w, r, len(filtered))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filtered[lo:hi])
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/archive"
+	"nickcast/internal/clock"
+	"nickcast/internal/mp3"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClipSeconds = 30
+	maxClipSeconds     = 600
+)
+
+// clipsDir is where saved clips live, under the recordings directory.
+func clipsDir() string {
+	return filepath.Join(config.AppConfig.RecordDir, "clips")
+}
+
+// lastSeconds returns roughly the last n seconds on air, starting on a frame
+// boundary where the format allows. When the rate is unknown it returns all
+// the audio it has.
+func (m *mount) lastSeconds(n int) []byte {
+	want := len(m.burst())
+	if m.history != nil {
+		want = m.cfg.Timeshift
+	}
+	if rate := m.byteRate(m.burst()); rate > 0 && n*rate < want {
+		want = n * rate
+	}
+	data := m.recent(want)
+	if i := mp3.Sync(data); i > 0 {
+		data = data[i:]
+	}
+	return data
+}
+
+type clipResponse struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Bytes int    `json:"bytes"`
+}
+
+// clipHandler serves POST /admin/clip?mount=...&seconds=30&title=...,
+// saving the last few seconds on air as a clip with a shareable URL under
+// /clips/. Admins can clip any mount; DJs can clip their own show.
+func clipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := requestMount(r, r.FormValue("mount"))
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, m.station)
+	if !ok {
+		return
+	}
+	if !m.station.isAdmin(user) && m.source() != user {
+		http.Error(w, "Only admins and the current streamer can clip this mount", http.StatusForbidden)
+		return
+	}
+	seconds := defaultClipSeconds
+	if s := r.FormValue("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxClipSeconds {
+			http.Error(w, "seconds must be between 1 and "+strconv.Itoa(maxClipSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	if !m.active() {
+		http.Error(w, "Nothing on air", http.StatusNotFound)
+		return
+	}
+
+	data := m.lastSeconds(seconds)
+	if len(data) == 0 {
+		http.Error(w, "Nothing buffered yet", http.StatusConflict)
+		return
+	}
+
+	end := clock.Default.Now()
+	id := fmt.Sprintf("%s-%s", m.fileName(), end.Format("20060102-150405"))
+	ct := m.contentType()
+	ext := extensionFor(ct)
+	path := filepath.Join(clipsDir(), id+ext)
+	if err := os.MkdirAll(clipsDir(), 0o755); err != nil {
+		logf(r, "Error creating clips directory: %v", err)
+		http.Error(w, "Could not save clip", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		logf(r, "Error saving clip %s: %v", path, err)
+		http.Error(w, "Could not save clip", http.StatusInternalServerError)
+		return
+	}
+	start := end
+	if rate := m.byteRate(data); rate > 0 {
+		start = end.Add(-time.Duration(len(data)) * time.Second / time.Duration(rate))
+	}
+	archive.WriteSidecar(path, &archive.Sidecar{
+		Mount:       m.cfg.Name,
+		Account:     m.source(),
+		Show:        r.FormValue("title"),
+		SessionID:   requestID(r),
+		ContentType: ct,
+		Start:       start,
+		End:         end,
+		Bytes:       int64(len(data)),
+		Tracks:      []archive.Track{{Title: m.currentTitle()}},
+	})
+	logf(r, "%s clipped %d bytes of %s to %s", user, len(data), m.cfg.Name, path)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(clipResponse{
+		ID:    id,
+		URL:   scheme + "://" + r.Host + "/clips/" + id + ext,
+		Bytes: len(data),
+	})
+}
+
+// clipsFileHandler serves saved clips at /clips/<id>.<ext>.
+func clipsFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/clips/")
+	if !archive.ValidID(name) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(clipsDir(), name))
+}
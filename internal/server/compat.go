@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"nickcast/config"
+	"sort"
+	"strings"
+)
+
+// compatClient is a family of players and the formats they can play, most
+// preferred first.
+type compatClient struct {
+	Name     string
+	Match    []string // case-insensitive User-Agent substrings
+	Exclude  []string // ... that rule the family out again
+	Formats  []string // as returned by streamFormat
+	Metadata bool     // reads ICY metadata from the stream
+}
+
+// compatClients are tried in order. Safari and everything on iOS go
+// through Apple's media stack, which has no Ogg; hardware radios reliably
+// play MP3 and often AAC; other browsers and desktop players take anything.
+// Browsers' audio elements ignore ICY metadata, so the web player shows
+// titles from the API instead. Unknown clients get MP3, which plays
+// everywhere.
+var compatClients = []compatClient{
+	{Name: "apple", Match: []string{"iPhone", "iPad", "AppleCoreMedia", "Safari"}, Exclude: []string{"Chrome", "Chromium", "Firefox", "Android"}, Formats: []string{"aac", "mp3", "flac"}},
+	{Name: "radio", Match: []string{"Frontier Silicon", "Reciva", "vTuner", "Sonos", "Roku", "Grundig", "Bose", "Denon", "Yamaha", "Libratone", "Teufel", "NSPlayer", "WinampMPEG"}, Formats: []string{"mp3", "aac"}, Metadata: true},
+	{Name: "player", Match: []string{"VLC", "mpv", "foobar2000", "MPlayer", "Lavf", "GStreamer", "Winamp", "Kodi"}, Formats: []string{"ogg", "aac", "mp3", "flac"}, Metadata: true},
+	{Name: "browser", Match: []string{"Mozilla"}, Formats: []string{"ogg", "aac", "mp3", "flac"}},
+}
+
+var unknownClient = compatClient{Name: "unknown", Formats: []string{"mp3"}, Metadata: true}
+
+// classifyClient returns the family a User-Agent belongs to.
+func classifyClient(userAgent string) compatClient {
+	ua := strings.ToLower(userAgent)
+	has := func(subs []string) bool {
+		for _, sub := range subs {
+			if strings.Contains(ua, strings.ToLower(sub)) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range compatClients {
+		if has(c.Match) && !has(c.Exclude) {
+			return c
+		}
+	}
+	return unknownClient
+}
+
+// streamFormat names a mount's format as compatClient lists it.
+func streamFormat(contentType string) string {
+	return strings.TrimPrefix(extensionFor(contentType), ".")
+}
+
+// compatStream is a stream a client can be given.
+type compatStream struct {
+	Mount       string `json:"mount"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Format      string `json:"format"`
+	Bitrate     int    `json:"bitrate,omitempty"` // kbit/s, if configured
+	Live        bool   `json:"live"`
+}
+
+// compatInfo is the /api/compat answer.
+type compatInfo struct {
+	UserAgent    string         `json:"user_agent"`
+	Client       string         `json:"client"`
+	Device       string         `json:"device,omitempty"` // matched [device] profile
+	Formats      []string       `json:"formats"`
+	Metadata     bool           `json:"metadata"`  // the client reads ICY metadata
+	Supported    bool           `json:"supported"` // the recommendation is in a format it plays
+	Recommended  compatStream   `json:"recommended"`
+	Alternatives []compatStream `json:"alternatives"` // other playable mounts, best first
+}
+
+// compatHandler serves /api/compat: which of a station's streams to give a
+// player, so the web player and playlist generators don't each keep their
+// own list of what plays where. The player is identified by ?ua=, or by
+// the request's own User-Agent when a player asks for itself. ?mount= is
+// the stream the caller would like; without it, the station is the one
+// the request's host belongs to. If the client can't play the asked-for
+// stream, the recommendation is the station's best one it can, and if it
+// can play none, the asked-for stream with supported false.
+func compatHandler(w http.ResponseWriter, r *http.Request) {
+	ua := r.URL.Query().Get("ua")
+	if ua == "" {
+		ua = r.UserAgent()
+	}
+
+	var want *mount
+	var st string
+	if ref := r.URL.Query().Get("mount"); ref != "" {
+		if want = requestMount(r, ref); want == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+		st = want.cfg.Station
+	} else {
+		st = hostStation(r.Host)
+	}
+	candidates := stationMounts(st, want)
+	if len(candidates) == 0 {
+		http.Error(w, "Station has no mounts", http.StatusNotFound)
+		return
+	}
+
+	c := classifyClient(ua)
+	info := compatInfo{
+		UserAgent:    ua,
+		Client:       c.Name,
+		Formats:      c.Formats,
+		Metadata:     c.Metadata,
+		Alternatives: []compatStream{},
+	}
+	if p := deviceProfile(ua); p != nil {
+		info.Device = p.Name
+	}
+	var playable []compatStream
+	for _, f := range c.Formats {
+		for _, m := range candidates {
+			if streamFormat(m.contentType()) == f {
+				playable = append(playable, m.compatStream(r))
+			}
+		}
+	}
+	// The asked-for stream wins over better formats if it plays at all.
+	if want != nil {
+		for i, s := range playable {
+			if s.Mount == want.cfg.Name {
+				playable = append(append([]compatStream{s}, playable[:i]...), playable[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(playable) == 0 {
+		info.Recommended = candidates[0].compatStream(r)
+	} else {
+		info.Supported = true
+		info.Recommended = playable[0]
+		info.Alternatives = append(info.Alternatives, playable[1:]...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "User-Agent")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (m *mount) compatStream(r *http.Request) compatStream {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return compatStream{
+		Mount:       m.cfg.Name,
+		URL:         scheme + "://" + r.Host + m.cfg.ListenPath,
+		ContentType: m.contentType(),
+		Format:      streamFormat(m.contentType()),
+		Bitrate:     m.cfg.Bitrate,
+		Live:        m.active(),
+	}
+}
+
+// hostStation returns the station whose hosts include host, or the default
+// station.
+func hostStation(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, sc := range config.AppConfig.Stations {
+		for _, h := range sc.Hosts {
+			if strings.EqualFold(h, host) {
+				return sc.Name
+			}
+		}
+	}
+	return config.DefaultStationName
+}
+
+// stationMounts returns the station's mounts: first, if set, and then its
+// default mount, then the rest by name.
+func stationMounts(st string, first *mount) []*mount {
+	var list []*mount
+	for _, m := range mounts() {
+		if m.cfg.Station == st && m != first {
+			list = append(list, m)
+		}
+	}
+	isDefault := func(m *mount) bool {
+		return strings.HasSuffix(m.cfg.ListenPath, "/listen")
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if isDefault(list[i]) != isDefault(list[j]) {
+			return isDefault(list[i])
+		}
+		return list[i].cfg.Name < list[j].cfg.Name
+	})
+	if first != nil {
+		list = append([]*mount{first}, list...)
+	}
+	return list
+}
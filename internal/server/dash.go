@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/dash"
+	"nickcast/internal/metrics"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dashPrefix is where MPEG-DASH manifests and segments are served: a
+// mount listened to at /stream has its manifest at /dash/stream.mpd.
+const dashPrefix = "/dash"
+
+var dashRequests = metrics.NewCounterVec("nickcast_dash_requests_total", "MPEG-DASH requests answered, by kind (manifest, init or segment).", "mount", "kind")
+
+// newDASHPackager sets up MPEG-DASH for m, which must carry MP3 or AAC.
+func newDASHPackager(m *mount) (*dash.Packager, error) {
+	var format dash.Format
+	switch ct := canonicalFormat(m.cfg.ContentType); ct {
+	case "audio/mpeg":
+		format = dash.MP3
+	case "audio/aac":
+		format = dash.AAC
+	default:
+		return nil, fmt.Errorf("needs an MP3 or AAC mount, not %s", ct)
+	}
+	seg := time.Duration(m.cfg.DASHSegment) * time.Second
+	return dash.New(format, seg, m.cfg.DASHWindow, clock.Default.Now), nil
+}
+
+// dashMount returns the mount with DASH listened to at listenPath.
+func dashMount(listenPath string) *mount {
+	for _, m := range mounts() {
+		if m.dash != nil && m.cfg.ListenPath == listenPath {
+			return m
+		}
+	}
+	return nil
+}
+
+// dashHandler serves MPEG-DASH for mounts with dash set: the live manifest
+// at /dash<listen path>.mpd, and under /dash<listen path>/ each period's
+// initialization segment at init-<period>.mp4 and media segments at
+// <n>.m4s. Caching and CORS are as for HLS.
+func dashHandler(w http.ResponseWriter, r *http.Request) {
+	if !segmentRequest(w, r) {
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, dashPrefix)
+	if strings.HasSuffix(p, ".mpd") {
+		m := dashMount(strings.TrimSuffix(p, ".mpd"))
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		m.serveManifest(w, r)
+		return
+	}
+	dir, file := path.Split(p)
+	m := dashMount(strings.TrimSuffix(dir, "/"))
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case strings.HasPrefix(file, "init-") && strings.HasSuffix(file, ".mp4"):
+		id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(file, "init-"), ".mp4"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		m.serveDASHInit(w, r, id)
+	case strings.HasSuffix(file, ".m4s"):
+		seq, err := strconv.ParseInt(strings.TrimSuffix(file, ".m4s"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		m.serveDASHSegment(w, r, seq)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveManifest answers a request for the mount's live manifest.
+func (m *mount) serveManifest(w http.ResponseWriter, r *http.Request) {
+	if !m.admitCountry(w, r) || !m.admitWindow(w, r) {
+		return
+	}
+	mpd, ok := m.dash.Manifest(path.Base(m.cfg.ListenPath) + "/")
+	if !ok {
+		m.unavailable(w, r, "listen.no_stream")
+		return
+	}
+	dashRequests.With(m.cfg.Name, "manifest").Inc()
+	maxAge := m.cfg.DASHSegment / 2
+	if maxAge < 1 {
+		maxAge = 1
+	}
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(mpd))
+}
+
+// serveDASHInit answers a request for the initialization segment of the
+// period with the given ID. It is gone once the period's last segment has
+// left the window.
+func (m *mount) serveDASHInit(w http.ResponseWriter, r *http.Request, id int64) {
+	if !m.admitCountry(w, r) {
+		return
+	}
+	data, ok := m.dash.Init(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	dashRequests.With(m.cfg.Name, "init").Inc()
+	serveImmutable(w, r, "init-"+strconv.FormatInt(id, 36), data)
+}
+
+// serveDASHSegment answers a request for segment seq.
+func (m *mount) serveDASHSegment(w http.ResponseWriter, r *http.Request, seq int64) {
+	if !m.admitCountry(w, r) {
+		return
+	}
+	seg, ok := m.dash.Segment(seq)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	dashRequests.With(m.cfg.Name, "segment").Inc()
+	serveImmutable(w, r, strconv.FormatInt(seq, 36), seg.Data)
+}
+
+func serveImmutable(w http.ResponseWriter, r *http.Request, etag string, data []byte) {
+	w.Header().Set("Content-Type", "audio/mp4")
+	w.Header().Set("Cache-Control", hlsSegmentCache)
+	w.Header().Set("ETag", strconv.Quote(etag))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
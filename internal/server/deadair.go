@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"log"
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"nickcast/internal/metrics"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// deadAirCheckInterval is how often connected sources are checked for
+// having gone quiet.
+const deadAirCheckInterval = time.Second
+
+var deadAirTotal = metrics.NewCounterVec("nickcast_dead_air_total", "Times a connected source went quiet past dead_air_timeout.", "mount")
+
+// watchDeadAir looks for connected sources that have stopped sending audio,
+// typically an encoder whose sound card or input has hung while the TCP
+// connection stays up.
+func watchDeadAir(ctx context.Context) error {
+	t := clock.Default.NewTicker(deadAirCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, m := range mounts() {
+				m.endDeadAir()
+			}
+			return nil
+		case <-t.C():
+		}
+		at := clock.Default.Now()
+		for _, m := range mounts() {
+			limit := time.Duration(m.cfg.DeadAirTimeout) * time.Second
+			if limit <= 0 || !m.active() || m.deadAir.Load() {
+				continue
+			}
+			if at.Sub(time.Unix(0, m.lastData.Load())) >= limit {
+				m.startDeadAir(ctx)
+			}
+		}
+	}
+}
+
+// startDeadAir alerts operators and fills the silence: with the fallback
+// mount if it's live, otherwise with dead_air_file on a loop. It carries
+// on until the source sends data again or disconnects.
+func (m *mount) startDeadAir(ctx context.Context) {
+	if !m.deadAir.CompareAndSwap(false, true) {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	started := clock.Default.Now()
+	account := m.source()
+	m.infoMu.Lock()
+	m.deadAirEnd = func() {
+		cancel()
+		log.Printf("Dead air on %s over after %s", m.cfg.Name, clock.Default.Since(started).Round(time.Second))
+		events.Publish(events.Event{Type: events.DeadAirEnd, Account: account, Data: map[string]string{"mount": m.cfg.Name}})
+	}
+	m.infoMu.Unlock()
+
+	deadAirTotal.With(m.cfg.Name).Inc()
+	log.Printf("Dead air on %s: no data from %s for %ds", m.cfg.Name, account, m.cfg.DeadAirTimeout)
+	events.Publish(events.Event{Type: events.DeadAirStart, Account: account, Data: map[string]string{"mount": m.cfg.Name}})
+
+	if fb := mounts()[m.cfg.Fallback]; fb != nil && fb.loadState() == stateLive {
+		go m.relayDeadAir(ctx, fb)
+	} else if m.cfg.DeadAirFile != "" {
+		go m.loopDeadAir(ctx)
+	}
+}
+
+// endDeadAir stops any dead-air fill on the mount.
+func (m *mount) endDeadAir() {
+	if !m.deadAir.CompareAndSwap(true, false) {
+		return
+	}
+	m.infoMu.Lock()
+	end := m.deadAirEnd
+	m.deadAirEnd = nil
+	m.infoMu.Unlock()
+	if end != nil {
+		end()
+	}
+}
+
+// relayDeadAir forwards the fallback mount's audio to this mount's
+// listeners.
+func (m *mount) relayDeadAir(ctx context.Context, fb *mount) {
+	defer track(subsysRelays)()
+	ch := make(chan []byte, 100)
+	l := &listener{ID: "dead-air-" + m.cfg.Name, Connected: clock.Default.Now(), queued: new(atomic.Int64), resync: &resyncState{}}
+	_, st := fb.currentStream()
+	if err := fb.registerListener(ch, l, st); err != nil {
+		log.Printf("Dead air on %s: cannot relay fallback %s: %v", m.cfg.Name, fb.cfg.Name, err)
+		return
+	}
+	defer l.drop()
+	defer fb.unregisterListener(ch)
+	log.Printf("Dead air on %s: relaying fallback %s", m.cfg.Name, fb.cfg.Name)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-ch:
+			if !ok {
+				return
+			}
+			m.broadcast(data)
+			l.dequeue(len(data))
+			if len(ch) == 0 && l.resync.pending.Load() {
+				m.broadcast(fb.catchUp(l))
+			}
+		}
+	}
+}
+
+// loopDeadAir plays dead_air_file over and over at its natural rate.
+func (m *mount) loopDeadAir(ctx context.Context) {
+	path := m.cfg.DeadAirFile
+	rate, err := fileRate(m, path)
+	if err != nil {
+		log.Printf("Dead air on %s: cannot play %s: %v", m.cfg.Name, path, err)
+		return
+	}
+	log.Printf("Dead air on %s: looping %s", m.cfg.Name, path)
+	for ctx.Err() == nil {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Dead air on %s: cannot play %s: %v", m.cfg.Name, path, err)
+			return
+		}
+		_, err = pump(ctx, f, rate, m.broadcast)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// fileRate works out the byte rate to play a file of the mount's format at.
+func fileRate(m *mount, path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return audioRate(m, f, info.Size())
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"nickcast/config"
+	"strings"
+)
+
+// deviceProfile returns the buffering profile for a listener's User-Agent,
+// or nil if none matches.
+func deviceProfile(userAgent string) *config.DeviceProfile {
+	ua := strings.ToLower(userAgent)
+	for i := range config.AppConfig.Devices {
+		d := &config.AppConfig.Devices[i]
+		for _, sub := range d.Match {
+			if strings.Contains(ua, strings.ToLower(sub)) {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// bufferSize is how much recent audio the mount keeps for bursts: enough for
+// its own burst_size and, unless that was set explicitly, for the largest
+// device profile.
+func bufferSize(cfg config.MountConfig) int {
+	size := cfg.BurstSize
+	if cfg.BurstSizeSet {
+		return size
+	}
+	for _, d := range config.AppConfig.Devices {
+		if d.BurstSize > size {
+			size = d.BurstSize
+		}
+	}
+	return size
+}
+
+// listenerBurst returns the burst for a new listener, sized by the mount's
+// burst_size or, if that wasn't set, its device profile, and cut to a
+// quarter when memory is tight.
+func (m *mount) listenerBurst(p *config.DeviceProfile) []byte {
+	size := m.cfg.BurstSize
+	if p != nil && p.BurstSize > 0 && !m.cfg.BurstSizeSet {
+		size = p.BurstSize
+	}
+	if memoryTight() {
+		size /= 4
+	}
+	data := m.burst()
+	if len(data) > size {
+		data = data[len(data)-size:]
+	}
+	return data
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/mp3"
+)
+
+// sourceDiagnostics is what /admin/diagnostics reports about a source.
+type sourceDiagnostics struct {
+	Mount       string  `json:"mount"`
+	Account     string  `json:"account"`
+	Session     string  `json:"session_id"`
+	RemoteAddr  string  `json:"remote_addr"`
+	ContentType string  `json:"content_type"`
+	Connected   float64 `json:"connected_seconds"`
+	Bytes       int64   `json:"bytes"`
+	ByteRate    float64 `json:"bytes_per_second"`
+	// NominalRate is what the stream's bitrate says it should send.
+	NominalRate float64 `json:"nominal_bytes_per_second,omitempty"`
+
+	// MP3 streams only.
+	Frames *mp3.Stats `json:"frames,omitempty"`
+	VBR    bool       `json:"vbr,omitempty"`
+	// Drift is wall-clock time minus the audio received. It grows when
+	// the encoder sends slower than real time (buffer underruns for
+	// listeners) and goes negative when it sends faster.
+	Drift float64 `json:"drift_seconds,omitempty"`
+	// Trimmed counts frames dropped by drift compensation.
+	Trimmed int64 `json:"trimmed_frames,omitempty"`
+
+	// IngestLimit is the most the source may send (see ingest_limit), and
+	// HeldBack how long in all it has been made to wait for sending faster.
+	IngestLimit float64 `json:"ingest_limit_bytes_per_second,omitempty"`
+	HeldBack    float64 `json:"held_back_seconds,omitempty"`
+}
+
+func (s *sourceSession) diagnostics() sourceDiagnostics {
+	elapsed := clock.Default.Since(s.started).Seconds()
+	d := sourceDiagnostics{
+		Mount:       s.m.cfg.Name,
+		Account:     s.account,
+		Session:     s.id,
+		RemoteAddr:  s.remote,
+		ContentType: s.m.contentType(),
+		Connected:   elapsed,
+	}
+	if s.m.cfg.Bitrate > 0 {
+		d.NominalRate = float64(s.m.cfg.Bitrate) * 1000 / 8
+	}
+	d.IngestLimit = s.ingestRate()
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	d.Bytes = s.bytes
+	d.HeldBack = s.held.Seconds()
+	if elapsed > 0 {
+		d.ByteRate = float64(s.bytes) / elapsed
+	}
+	if s.frames != nil {
+		st := s.frames.Stats()
+		d.Frames = &st
+		if st.Frames > 0 {
+			d.VBR = st.VBR()
+			d.Drift = elapsed - st.Duration.Seconds()
+			if s.drift != nil {
+				d.Trimmed = s.drift.trimmed
+			}
+			if d.NominalRate == 0 && !d.VBR {
+				d.NominalRate = float64(st.Bitrate) * 1000 / 8
+			}
+		}
+	}
+	return d
+}
+
+// diagnosticsHandler serves /admin/diagnostics[?mount=...], describing what
+// each connected source is actually sending so "my stream sounds weird"
+// reports can be triaged without the DJ's encoder at hand.
+func diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	list := []sourceDiagnostics{}
+	if ref := r.FormValue("mount"); ref != "" {
+		m := requestMount(r, ref)
+		if m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+		if _, ok := requireAdmin(w, r, m.station); !ok {
+			return
+		}
+		if s := m.currentSession(); s != nil {
+			list = append(list, s.diagnostics())
+		}
+	} else {
+		if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+			return
+		}
+		for _, m := range mounts() {
+			if s := m.currentSession(); s != nil {
+				list = append(list, s.diagnostics())
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// downmixCheckInterval is how often a mono mount looks for its source
+	// mount going live.
+	downmixCheckInterval = time.Second
+
+	// downmixRetry is how long a mono mount waits after ffmpeg fails before
+	// trying again.
+	downmixRetry = 10 * time.Second
+
+	// downmixQueue is how many chunks of the live stream may wait for
+	// ffmpeg before they are dropped.
+	downmixQueue = 256
+)
+
+var downmixDrops = metrics.NewCounterVec("nickcast_downmix_dropped_bytes_total", "Live audio dropped because a mono mount's ffmpeg fell behind.", "mount")
+
+// downmixFeed hands a live mount's audio to the ffmpeg making its mono
+// copy. It never blocks the broadcast: if ffmpeg falls behind, audio is
+// dropped.
+type downmixFeed struct {
+	ch    chan []byte
+	mount string // the mono mount, for metrics
+}
+
+func (f *downmixFeed) write(data []byte) {
+	select {
+	case f.ch <- data:
+	default:
+		downmixDrops.With(f.mount).Add(int64(len(data)))
+	}
+}
+
+func (m *mount) setDownmix(f *downmixFeed) {
+	m.infoMu.Lock()
+	m.downmix = f
+	m.infoMu.Unlock()
+}
+
+func (m *mount) currentDownmix() *downmixFeed {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.downmix
+}
+
+// runDownmixes keeps every mono_bitrate mount's mono copy on air while the
+// mount is live.
+func runDownmixes(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, d := range mounts() {
+		src := mounts()[d.cfg.DownmixOf]
+		if src == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(d, src *mount) {
+			defer wg.Done()
+			d.runDownmix(ctx, src)
+		}(d, src)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// runDownmix puts a mono copy of each of src's sessions on air on d.
+func (d *mount) runDownmix(ctx context.Context, src *mount) {
+	for ctx.Err() == nil {
+		wait := downmixCheckInterval
+		if s := src.currentSession(); s != nil {
+			if err := d.downmixSession(ctx, src, s); err != nil && ctx.Err() == nil {
+				log.Printf("[%s] Mono copy of %s on %s failed: %v; retrying in %s", s.id, src.cfg.Name, d.cfg.Name, err, downmixRetry)
+				wait = downmixRetry
+			}
+		}
+		t := clock.Default.NewTimer(wait)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+}
+
+// downmixSession runs src's live session s through ffmpeg, down to mono at
+// d's bitrate, and broadcasts the result on d until s ends. d carries the
+// same streamer, stream details and titles as src.
+func (d *mount) downmixSession(ctx context.Context, src *mount, s *sourceSession) error {
+	if !d.claimSource() {
+		return nil
+	}
+	if _, ok := d.admitResume(""); !ok {
+		d.releaseSource() // Release stream lock
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, config.AppConfig.FFmpegPath,
+		"-hide_banner", "-nostats", "-loglevel", "error",
+		"-i", "pipe:0", "-vn", "-ac", "1",
+		"-c:a", "libmp3lame", "-b:a", strconv.Itoa(d.cfg.Bitrate)+"k", "-f", "mp3", "pipe:1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		d.releaseSource() // Release stream lock
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		d.releaseSource() // Release stream lock
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		d.releaseSource() // Release stream lock
+		return err
+	}
+
+	feed := &downmixFeed{ch: make(chan []byte, downmixQueue), mount: d.cfg.Name}
+	src.setDownmix(feed)
+	defer src.setDownmix(nil)
+
+	sess := d.startSession(s.account, "mono-"+s.id, s.remote, "", func(reason string) {
+		log.Printf("[%s] Stopping mono copy on %s: %s", s.id, d.cfg.Name, reason)
+		cancel()
+	})
+	sess.setIceInfo(s.iceInfo())
+	defer sess.end()
+	sess.logf("Mono copy of %s on air on %s at %dk", src.cfg.Name, d.cfg.Name, d.cfg.Bitrate)
+
+	// Feed ffmpeg, and follow src's titles, until the session ends. Closing
+	// stdin lets ffmpeg finish and the read below end.
+	go func() {
+		defer stdin.Close()
+		t := clock.Default.NewTicker(downmixCheckInterval)
+		defer t.Stop()
+		title := ""
+		for {
+			select {
+			case data := <-feed.ch:
+				if _, err := stdin.Write(data); err != nil {
+					return
+				}
+			case <-t.C():
+				if src.currentSession() != s {
+					return
+				}
+				if t := src.currentTitle(); t != title {
+					title = t
+					d.setTitle(t)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sess.read(stdout, func() error { cancel(); return nil })
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndex(msg, "\n"); i >= 0 {
+			msg = msg[i+1:]
+		}
+		return fmt.Errorf("ffmpeg: %w (%s)", err, msg)
+	}
+	return nil
+}
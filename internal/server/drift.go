@@ -0,0 +1,63 @@
+package server
+
+import (
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"nickcast/internal/mp3"
+	"time"
+)
+
+// driftTrimEvery is the most often a frame may be dropped: one in 50 speeds
+// playback up by at most 2%, which isn't noticeable on speech or music.
+const driftTrimEvery = 50
+
+var driftTrimmed = metrics.NewCounterVec("nickcast_drift_trimmed_frames_total", "MP3 frames dropped to stop a source running ahead of real time.", "mount")
+
+// driftCompensator keeps a long-running MP3 source within max_drift of real
+// time. Encoders whose sound card clock runs fast deliver more audio than
+// wall-clock time allows, which over a 12-hour marathon piles up minutes of
+// latency in every listener's buffer; the compensator gently drops frames
+// to keep that bounded. Slow sources can't be fixed here, but their deficit
+// is capped so a stall early in a stream doesn't let it run far ahead
+// later.
+type driftCompensator struct {
+	mount    string
+	maxDrift time.Duration
+	start    time.Time
+	audio    time.Duration // audio passed on to listeners
+	since    int           // frames since the last trim
+	trimmed  int64
+	out      []byte
+}
+
+func newDriftCompensator(mount string, maxDrift time.Duration) *driftCompensator {
+	return &driftCompensator{mount: mount, maxDrift: maxDrift, start: clock.Default.Now()}
+}
+
+// frames returns the callback that decides, for each frame parsed from the
+// source, whether it goes out to listeners.
+func (d *driftCompensator) frames() func([]byte, mp3.Header) {
+	return func(frame []byte, h mp3.Header) {
+		elapsed := clock.Default.Since(d.start)
+		d.since++
+		if d.audio-elapsed > d.maxDrift && d.since >= driftTrimEvery {
+			d.since = 0
+			d.trimmed++
+			driftTrimmed.With(d.mount).Inc()
+			return
+		}
+		if deficit := elapsed - d.audio; deficit > d.maxDrift {
+			// Bounded catch-up: forget any shortfall beyond max_drift.
+			d.start = d.start.Add(deficit - d.maxDrift)
+		}
+		d.audio += h.Duration()
+		d.out = append(d.out, frame...)
+	}
+}
+
+// take returns the frames kept since the last call.
+func (d *driftCompensator) take() []byte {
+	out := d.out
+	d.out = nil
+	return out
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/fingerprint"
+	"nickcast/internal/metrics"
+	"time"
+)
+
+const (
+	// fingerprintSeconds is how much of the stream a recognizer is given.
+	fingerprintSeconds = 12
+
+	// fingerprintTimeout bounds one recognition.
+	fingerprintTimeout = 30 * time.Second
+
+	// fingerprintAccount is who recognized titles are credited to in
+	// metadata events.
+	fingerprintAccount = "fingerprint"
+)
+
+var fingerprintLookups = metrics.NewCounterVec("nickcast_fingerprint_lookups_total", "Track recognition attempts, by result (recognized, unknown or error).", "mount", "result")
+
+// recognizer identifies tracks on air; nil when fingerprinting isn't
+// configured.
+var recognizer *fingerprint.Recognizer
+
+// fingerprinted is what recognition last put on a mount's title, so a
+// title the source sends itself can be told apart.
+type fingerprinted struct {
+	session string
+	title   string
+	titled  bool // the source sends its own titles
+}
+
+// runFingerprinter puts recognized tracks in the title of live sources
+// that send none themselves, every fingerprint_interval. A source that sets
+// a title is left alone for the rest of its session, so recognition never
+// overrides the DJ.
+func runFingerprinter(ctx context.Context) error {
+	last := make(map[*mount]fingerprinted)
+	t := clock.Default.NewTicker(time.Duration(config.AppConfig.FingerprintInterval) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		for _, m := range mounts() {
+			s := m.currentSession()
+			if s == nil {
+				delete(last, m)
+				continue
+			}
+			prev := last[m]
+			if prev.session != s.id {
+				prev = fingerprinted{session: s.id}
+			}
+			if title := m.currentTitle(); title != "" && title != prev.title {
+				prev.titled = true
+			}
+			if prev.titled {
+				last[m] = prev
+				continue
+			}
+			if title := m.identify(ctx, s); title != "" && title != prev.title {
+				s.logf("Recognized on %s: %q", m.cfg.Name, title)
+				m.playTitle(s.id, fingerprintAccount, title)
+				prev.title = title
+			}
+			last[m] = prev
+		}
+	}
+}
+
+// identify asks the recognizer what the last few seconds on m are,
+// returning "" if it doesn't know.
+func (m *mount) identify(ctx context.Context, s *sourceSession) string {
+	audio := m.lastSeconds(fingerprintSeconds)
+	if len(audio) == 0 {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, fingerprintTimeout)
+	defer cancel()
+	title, err := recognizer.Identify(ctx, audio, m.cfg.ContentType)
+	switch {
+	case err != nil:
+		fingerprintLookups.With(m.cfg.Name, "error").Inc()
+		s.logf("Track recognition on %s failed: %v", m.cfg.Name, err)
+		return ""
+	case title == "":
+		fingerprintLookups.With(m.cfg.Name, "unknown").Inc()
+		return ""
+	}
+	fingerprintLookups.With(m.cfg.Name, "recognized").Inc()
+	if title, keep := m.cfg.TitleFilters.Apply(title); keep {
+		return title
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/geoip"
+	"strings"
+)
+
+var (
+	geoDB      *geoip.DB
+	geoDenyTpl = template.Must(template.New("geo-deny").Parse(defaultGeoDenyPage))
+)
+
+// defaultGeoDenyPage is shown when geoip_deny_page isn't configured.
+const defaultGeoDenyPage = `<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.T "geo.title"}}</title></head>
+<body>
+<h1>{{.T "geo.title"}}</h1>
+<p>{{if .Country}}{{.T "geo.denied_country" "mount" .Mount "country" .Country}}{{else}}{{.T "geo.denied" "mount" .Mount}}{{end}}</p>
+</body></html>
+`
+
+// geoDenyPage is what the denial page template is given. T translates
+// a message into the listener's language, Lang.
+type geoDenyPage struct {
+	Mount, Country, Lang string
+}
+
+func (p geoDenyPage) T(key string, args ...string) string {
+	return catalogs.T(p.Lang, key, args...)
+}
+
+// loadGeo opens the GeoIP database and denial page template, if configured.
+func loadGeo() error {
+	cfg := config.AppConfig
+	if cfg.GeoIPDB != "" {
+		db, err := geoip.Open(cfg.GeoIPDB)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded %d GeoIP ranges from %s", db.Len(), cfg.GeoIPDB)
+		geoDB = db
+	}
+	if cfg.GeoIPDenyPage != "" {
+		tpl, err := template.ParseFiles(cfg.GeoIPDenyPage)
+		if err != nil {
+			return fmt.Errorf("error loading geoip_deny_page: %w", err)
+		}
+		geoDenyTpl = tpl
+	}
+	for _, m := range cfg.Mounts {
+		if (len(m.AllowCountries) > 0 || len(m.DenyCountries) > 0) && geoDB == nil && cfg.GeoIPHeader == "" {
+			return fmt.Errorf("mount %s has country restrictions but neither geoip_db nor geoip_header is set", m.Name)
+		}
+	}
+	return nil
+}
+
+// clientCountry resolves the listener's country, preferring a header set by
+// a trusted fronting CDN (e.g. CF-IPCountry) over the local database.
+func clientCountry(r *http.Request) string {
+	if h := config.AppConfig.GeoIPHeader; h != "" {
+		if c := strings.TrimSpace(r.Header.Get(h)); c != "" {
+			return strings.ToUpper(c)
+		}
+	}
+	if addr, ok := clientAddr(r); ok {
+		return geoDB.Lookup(addr)
+	}
+	return ""
+}
+
+// countryAllowed applies the mount's allow and deny lists. With an allow
+// list, listeners whose country can't be determined are refused, since
+// licensing terms are usually written as "only these territories".
+func (m *mount) countryAllowed(country string) bool {
+	for _, c := range m.cfg.DenyCountries {
+		if strings.EqualFold(c, country) {
+			return false
+		}
+	}
+	if len(m.cfg.AllowCountries) == 0 {
+		return true
+	}
+	for _, c := range m.cfg.AllowCountries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// admitCountry enforces the mount's country restrictions, rendering the
+// denial page when the listener isn't allowed.
+func (m *mount) admitCountry(w http.ResponseWriter, r *http.Request) bool {
+	if len(m.cfg.AllowCountries) == 0 && len(m.cfg.DenyCountries) == 0 {
+		return true
+	}
+	country := clientCountry(r)
+	if m.countryAllowed(country) {
+		return true
+	}
+
+	logf(r, "Listener from %s (country %q) denied on %s by country restrictions", r.RemoteAddr, country, m.cfg.Name)
+	data := geoDenyPage{Mount: m.cfg.Name, Country: country, Lang: m.lang(r)}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", data.Lang)
+	w.WriteHeader(http.StatusForbidden)
+	if err := geoDenyTpl.Execute(w, data); err != nil {
+		logf(r, "Error rendering GeoIP denial page: %v", err)
+	}
+	return false
+}
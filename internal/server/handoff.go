@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handoffExpiry is how long a handoff waits for the incoming DJ to connect
+// once its warning has run out.
+const handoffExpiry = 2 * time.Minute
+
+// handoff is a change of DJ on a mount with handoff set: from At, To's
+// source may replace the live one, listeners and all.
+type handoff struct {
+	Mount       string        `json:"mount"`
+	From        string        `json:"from"` // live when the handoff was asked for
+	To          string        `json:"to"`
+	At          time.Time     `json:"at"`
+	RequestedBy string        `json:"requested_by"`
+	title       string        // From's title, put back if the handoff is cancelled
+	done        chan struct{} // closed when it is cancelled or replaced
+}
+
+var handoffs = struct {
+	mu      sync.Mutex
+	byMount map[string]*handoff
+}{byMount: make(map[string]*handoff)}
+
+var (
+	errNobodyLive = errors.New("nobody is on air; just connect")
+	errAlreadyOn  = errors.New("already on air")
+)
+
+// wantsHandoff reports whether a source connection asks for a handoff, with
+// the X-Source-Handoff header or the handoff query parameter.
+func wantsHandoff(r *http.Request) bool {
+	v := r.Header.Get("X-Source-Handoff")
+	if v == "" {
+		v = r.URL.Query().Get("handoff")
+	}
+	ok, _ := strconv.ParseBool(v)
+	return ok
+}
+
+// requestHandoff arranges for to's source to take over from the live DJ
+// after the mount's handoff_warning, replacing any handoff already
+// pending. The live DJ is warned with a source.handoff event and in the
+// stream title, which they see in their encoder or player. The warning is
+// only set as the title, not played: it doesn't go to now-playing
+// services, the standby or the recording's track list.
+func (m *mount) requestHandoff(id, to, by string) (*handoff, error) {
+	s := m.currentSession()
+	if s == nil {
+		return nil, errNobodyLive
+	}
+	if s.account == to {
+		return nil, errAlreadyOn
+	}
+	warning := time.Duration(m.cfg.HandoffWarning) * time.Second
+	h := &handoff{
+		Mount:       m.cfg.Name,
+		From:        s.account,
+		To:          to,
+		At:          clock.Default.Now().Add(warning),
+		RequestedBy: by,
+		title:       m.currentTitle(),
+		done:        make(chan struct{}),
+	}
+	handoffs.mu.Lock()
+	if old := handoffs.byMount[m.cfg.Name]; old != nil {
+		close(old.done)
+		h.title = old.title
+	}
+	handoffs.byMount[m.cfg.Name] = h
+	handoffs.mu.Unlock()
+
+	log.Printf("[%s] Handoff of %s from %s to %s in %ds, asked for by %s", id, m.cfg.Name, s.account, to, m.cfg.HandoffWarning, by)
+	events.Publish(events.Event{Type: events.SourceHandoff, SessionID: s.id, Account: s.account, RemoteAddr: s.remote, Data: map[string]string{
+		"mount":        m.cfg.Name,
+		"to":           to,
+		"requested_by": by,
+		"seconds":      strconv.Itoa(m.cfg.HandoffWarning),
+	}})
+	title := h.title
+	if title == "" {
+		title = s.account
+	}
+	m.setTitle(m.trTitle("title.handing_over", "title", title, "to", to, "seconds", strconv.Itoa(m.cfg.HandoffWarning)))
+	return h, nil
+}
+
+// pendingHandoff returns the mount's handoff, or nil if it has none. One
+// whose incoming DJ never turned up is forgotten.
+func (m *mount) pendingHandoff() *handoff {
+	handoffs.mu.Lock()
+	defer handoffs.mu.Unlock()
+	h := handoffs.byMount[m.cfg.Name]
+	if h != nil && clock.Default.Since(h.At) > handoffExpiry {
+		log.Printf("Handoff of %s to %s expired: they never connected", m.cfg.Name, h.To)
+		delete(handoffs.byMount, m.cfg.Name)
+		close(h.done)
+		return nil
+	}
+	return h
+}
+
+// endHandoff forgets h once it has happened or been cancelled, reporting
+// whether it was still pending.
+func (m *mount) endHandoff(h *handoff) bool {
+	handoffs.mu.Lock()
+	defer handoffs.mu.Unlock()
+	if handoffs.byMount[m.cfg.Name] != h {
+		return false
+	}
+	delete(handoffs.byMount, m.cfg.Name)
+	close(h.done)
+	return true
+}
+
+// cancelHandoff calls off h, putting back the live DJ's title.
+func (m *mount) cancelHandoff(h *handoff) bool {
+	if !m.endHandoff(h) {
+		return false
+	}
+	if s := m.currentSession(); s != nil && s.account == h.From {
+		m.setTitle(h.title)
+	}
+	return true
+}
+
+// handoffDue reports whether user's source may take over the live mount
+// by handoff: the mount has handoff set, and one is arranged for user or
+// they are an admin asking for one.
+func (m *mount) handoffDue(user string, ask bool) bool {
+	if !m.cfg.Handoff {
+		return false
+	}
+	if ask && m.station.isAdmin(user) {
+		return true
+	}
+	h := m.pendingHandoff()
+	return h != nil && h.To == user
+}
+
+// handOver takes over the live mount for a's source by handoff: one
+// already arranged for it or, if it asks, one it asks for now. It waits
+// out the warning, then claims the mount or, with the live DJ still on,
+// takes over from them.
+func (a *admission) handOver(cancel <-chan struct{}) *sourceRefusal {
+	m := a.m
+	h := m.pendingHandoff()
+	if h == nil || h.To != a.user {
+		var err error
+		if h, err = m.requestHandoff(a.id, a.user, a.user); err == errNobodyLive {
+			// The live DJ left since admitSource.
+			if !m.claimSource() {
+				return a.refuse(http.StatusConflict, "Stream already active", "a stream is already active")
+			}
+			m.setPriority(a.ask.priority)
+			return nil
+		} else if err != nil {
+			return a.refuse(http.StatusConflict, "Handoff refused: "+err.Error(), "handoff refused: %v", err)
+		}
+	}
+	if err := waitHandoff(cancel, h); err != nil {
+		return a.refuse(http.StatusConflict, "Handoff "+err.Error(), "the handoff did not happen: %v", err)
+	}
+	if !m.endHandoff(h) {
+		return a.refuse(http.StatusConflict, "Handoff was cancelled", "the handoff was cancelled")
+	}
+	if m.claimSource() {
+		m.setPriority(a.ask.priority)
+		return nil
+	}
+	if !m.takeOver(a.id, a.remote, a.user, a.ask.priority, cancel, byHandoff) {
+		return a.refuse(http.StatusConflict, "Stream already active", "could not take over by handoff")
+	}
+	return nil
+}
+
+// waitHandoff waits until h is due, or cancel is closed.
+func waitHandoff(cancel <-chan struct{}, h *handoff) error {
+	wait := h.At.Sub(clock.Default.Now())
+	if wait <= 0 {
+		return nil
+	}
+	t := clock.Default.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-h.done:
+		return errors.New("was cancelled")
+	case <-cancel:
+		return errors.New("was given up")
+	}
+}
+
+// handoffHandler serves /api/source/handoff:
+//
+//	GET    /api/source/handoff?mount=           the pending handoff, if any
+//	POST   /api/source/handoff?mount=[&to=]     ask for one
+//	DELETE /api/source/handoff?mount=           call it off
+//
+// On a mount with handoff set, the live DJ may hand the mount to the next
+// one with to=, and admins may hand it to anyone, themselves included.
+// The incoming DJ then connects as usual, and their source takes over
+// once the live DJ's warning has run out. The live DJ, the incoming one,
+// whoever asked and admins may call a handoff off.
+func handoffHandler(w http.ResponseWriter, r *http.Request) {
+	m := requestMount(r, r.FormValue("mount"))
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := requireUser(w, r, m.station)
+	if !ok {
+		return
+	}
+	if !m.cfg.Handoff {
+		http.Error(w, "Mount "+m.cfg.Name+" doesn't take handoffs", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h := m.pendingHandoff()
+		if h == nil {
+			http.Error(w, "No handoff pending", http.StatusNotFound)
+			return
+		}
+		writeHandoff(w, http.StatusOK, h)
+	case http.MethodPost:
+		if user != m.source() && !m.station.isAdmin(user) {
+			http.Error(w, "Only the live DJ or an admin can start a handoff", http.StatusForbidden)
+			return
+		}
+		to := strings.TrimSpace(r.FormValue("to"))
+		if to == "" {
+			to = user
+		}
+		h, err := m.requestHandoff(requestID(r), to, user)
+		if err != nil {
+			http.Error(w, "Handoff refused: "+err.Error(), http.StatusConflict)
+			return
+		}
+		writeHandoff(w, http.StatusAccepted, h)
+	case http.MethodDelete:
+		h := m.pendingHandoff()
+		if h == nil {
+			http.Error(w, "No handoff pending", http.StatusNotFound)
+			return
+		}
+		if user != h.From && user != h.To && user != h.RequestedBy && !m.station.isAdmin(user) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if m.cancelHandoff(h) {
+			logf(r, "Handoff of %s to %s cancelled by %s", m.cfg.Name, h.To, user)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeHandoff(w http.ResponseWriter, code int, h *handoff) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"time"
+)
+
+const (
+	// heartbeatCheckInterval is how often sessions that send heartbeats are
+	// checked for having stopped.
+	heartbeatCheckInterval = time.Second
+
+	// minTokenLength keeps session tokens long enough not to be guessed.
+	minTokenLength = 16
+)
+
+var heartbeatTimeouts = metrics.NewCounterVec("nickcast_source_heartbeat_timeouts_total", "Sources disconnected for missing heartbeats.", "mount")
+
+// sessionToken returns the token a source connection opts into heartbeats
+// with, from the X-Session-Token header or ?session_token=.
+func sessionToken(r *http.Request) string {
+	if t := r.Header.Get("X-Session-Token"); t != "" {
+		return t
+	}
+	return r.URL.Query().Get("session_token")
+}
+
+// expectHeartbeats makes the session liable to be disconnected if pings to
+// /api/source/heartbeat with token stop for the mount's heartbeat_timeout.
+// The first is due that long after the session starts.
+func (s *sourceSession) expectHeartbeats(token string) {
+	s.statsMu.Lock()
+	s.token = token
+	s.lastBeat = clock.Default.Now()
+	s.statsMu.Unlock()
+}
+
+// beat records a heartbeat if token is the session's, reporting whether it
+// was.
+func (s *sourceSession) beat(token string) bool {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) != 1 {
+		return false
+	}
+	s.lastBeat = clock.Default.Now()
+	return true
+}
+
+// heartbeatOverdue reports whether a session that sends heartbeats has gone
+// longer than timeout without one. It reports true only once per session.
+func (s *sourceSession) heartbeatOverdue(at time.Time, timeout time.Duration) bool {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.token == "" || s.beatMissed || at.Sub(s.lastBeat) < timeout {
+		return false
+	}
+	s.beatMissed = true
+	return true
+}
+
+// watchHeartbeats disconnects sources whose heartbeats have stopped. A
+// headless encoder that has hung, or lost its network, can take minutes to
+// show up as a TCP timeout; in the meantime listeners hear dead air rather
+// than the fallback mount.
+func watchHeartbeats(ctx context.Context) error {
+	t := clock.Default.NewTicker(heartbeatCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		at := clock.Default.Now()
+		for _, m := range mounts() {
+			timeout := time.Duration(m.cfg.HeartbeatTimeout) * time.Second
+			s := m.currentSession()
+			if s == nil || timeout <= 0 || !s.heartbeatOverdue(at, timeout) {
+				continue
+			}
+			heartbeatTimeouts.With(m.cfg.Name).Inc()
+			s.logf("No heartbeat from %s on %s for %ds", s.account, m.cfg.Name, m.cfg.HeartbeatTimeout)
+			m.kickSource("missed heartbeats")
+		}
+	}
+}
+
+// heartbeatHandler serves /api/source/heartbeat. A source that connected
+// with a session token pings it with the same token (as ?token= or the
+// X-Session-Token header) to show it is still alive. The token is all it
+// takes, so encoders don't need their NickServ password for it.
+func heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.FormValue("token")
+	if token == "" {
+		token = r.Header.Get("X-Session-Token")
+	}
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+	for _, m := range mounts() {
+		if s := m.currentSession(); s != nil && s.beat(token) {
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, "No source session with that token", http.StatusNotFound)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/hls"
+	"nickcast/internal/metrics"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// hlsPrefix is where HLS playlists and segments are served: a mount
+	// listened to at /stream has its playlist at /hls/stream.m3u8.
+	hlsPrefix = "/hls"
+
+	// hlsSegmentMaxAge is how long segments may be cached. Their URLs are
+	// never reused, so that can be forever.
+	hlsSegmentMaxAge = 365 * 24 * 3600
+)
+
+// hlsSegmentCache is the Cache-Control of segments, HLS and DASH.
+var hlsSegmentCache = "public, max-age=" + strconv.Itoa(hlsSegmentMaxAge) + ", immutable"
+
+var hlsRequests = metrics.NewCounterVec("nickcast_hls_requests_total", "HLS requests answered, by kind (playlist or segment).", "mount", "kind")
+
+// newPackager sets up HLS for m, which must carry MP3 or AAC.
+func newPackager(m *mount) (*hls.Packager, error) {
+	var format hls.Format
+	switch ct := canonicalFormat(m.cfg.ContentType); ct {
+	case "audio/mpeg":
+		format = hls.MP3
+	case "audio/aac":
+		format = hls.AAC
+	default:
+		return nil, fmt.Errorf("needs an MP3 or AAC mount, not %s", ct)
+	}
+	seg := time.Duration(m.cfg.HLSSegment) * time.Second
+	return hls.New(format, seg, m.cfg.HLSWindow, clock.Default.Now()), nil
+}
+
+// hlsMount returns the mount with HLS listened to at listenPath.
+func hlsMount(listenPath string) *mount {
+	for _, m := range mounts() {
+		if m.hls != nil && m.cfg.ListenPath == listenPath {
+			return m
+		}
+	}
+	return nil
+}
+
+// hlsHandler serves HLS for mounts with hls set: the live playlist at
+// /hls<listen path>.m3u8 and its segments at /hls<listen path>/<n>.ts
+// (.aac for AAC mounts). It is made to sit behind a CDN: a segment's URL
+// always means the same audio, so segments are cached for good, while the
+// playlist is cached for no more than half a segment. Web players on
+// other sites may fetch both.
+func hlsHandler(w http.ResponseWriter, r *http.Request) {
+	if !segmentRequest(w, r) {
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, hlsPrefix)
+	if strings.HasSuffix(p, ".m3u8") {
+		m := hlsMount(strings.TrimSuffix(p, ".m3u8"))
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		m.servePlaylist(w, r)
+		return
+	}
+	dir, file := path.Split(p)
+	m := hlsMount(strings.TrimSuffix(dir, "/"))
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ext := m.hls.Format().Ext()
+	seq, err := strconv.ParseInt(strings.TrimSuffix(file, ext), 10, 64)
+	if !strings.HasSuffix(file, ext) || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.serveSegment(w, r, seq)
+}
+
+// segmentRequest sets the headers HLS and DASH responses share and
+// answers CORS preflights and methods other than GET and HEAD, reporting
+// whether the request is left to the caller.
+func segmentRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Range")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	default:
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	// Errors are only true for now.
+	w.Header().Set("Cache-Control", "no-cache")
+	return true
+}
+
+// servePlaylist answers a request for the mount's live playlist.
+func (m *mount) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	if !m.admitCountry(w, r) || !m.admitWindow(w, r) {
+		return
+	}
+	playlist, ok := m.hls.Playlist(path.Base(m.cfg.ListenPath) + "/")
+	if !ok {
+		m.unavailable(w, r, "listen.no_stream")
+		return
+	}
+	hlsRequests.With(m.cfg.Name, "playlist").Inc()
+	w.Header().Set("Content-Type", hlsPlaylistType)
+	w.Header().Set("Cache-Control", m.hlsPlaylistCache())
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(playlist))
+}
+
+// hlsPlaylistType is the media type of HLS playlists.
+const hlsPlaylistType = "application/vnd.apple.mpegurl"
+
+// hlsPlaylistCache is how the mount's playlist may be cached: for no more
+// than half a segment, so players hear of new ones in time.
+func (m *mount) hlsPlaylistCache() string {
+	maxAge := m.cfg.HLSSegment / 2
+	if maxAge < 1 {
+		maxAge = 1
+	}
+	return "public, max-age=" + strconv.Itoa(maxAge)
+}
+
+// serveSegment answers a request for segment seq. Segments that have left
+// the playlist are gone.
+func (m *mount) serveSegment(w http.ResponseWriter, r *http.Request, seq int64) {
+	if !m.admitCountry(w, r) {
+		return
+	}
+	seg, ok := m.hls.Segment(seq)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	hlsRequests.With(m.cfg.Name, "segment").Inc()
+	w.Header().Set("Content-Type", m.hls.Format().ContentType())
+	w.Header().Set("Cache-Control", hlsSegmentCache)
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(seq, 36)))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(seg.Data))
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"log"
+	"nickcast/config"
+	"nickcast/internal/metrics"
+	"nickcast/internal/s3"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsPushTimeout bounds one upload or delete.
+const hlsPushTimeout = 20 * time.Second
+
+var hlsPushes = metrics.NewCounterVec("nickcast_hls_push_total", "Objects written to or deleted from the hls_push_url bucket, by result (ok or error).", "mount", "result")
+
+// runHLSPush writes the HLS output of every mount with hls to the
+// hls_push_url bucket until ctx is cancelled, so a CDN can serve it from
+// there rather than from this server.
+func runHLSPush(ctx context.Context) error {
+	cfg := config.AppConfig
+	bucket, err := s3.New(cfg.HLSPushURL, cfg.HLSPushRegion, cfg.HLSPushAccessKey, cfg.HLSPushSecretKey)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, m := range mounts() {
+		if m.hls == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(m *mount) {
+			defer wg.Done()
+			m.pushHLS(ctx, bucket)
+		}(m)
+	}
+	wg.Wait()
+	return nil
+}
+
+// pushHLS keeps the mount's playlist and segments in bucket under the
+// mount's listen path, as /hls serves them: /stream.m3u8 and
+// /stream/<n>.ts for a mount listened to at /stream. Each new segment is
+// written before the playlist that lists it. Segments are deleted once
+// they have been out of the playlist for as long again, so players still
+// working through an older copy of it can finish.
+func (m *mount) pushHLS(ctx context.Context, bucket *s3.Bucket) {
+	name := strings.TrimPrefix(m.cfg.ListenPath, "/")
+	ext := m.hls.Format().Ext()
+	segmentKey := func(seq int64) string {
+		return name + "/" + strconv.FormatInt(seq, 10) + ext
+	}
+	var pushed []int64 // segments in the bucket, oldest first
+	failing := false
+	report := func(err error) bool {
+		result := "ok"
+		switch {
+		case err != nil && !failing:
+			log.Printf("Mount %s: pushing HLS to %s failing: %v", m.cfg.Name, bucket.URL.Redacted(), err)
+		case err == nil && failing:
+			log.Printf("Mount %s: pushing HLS to %s recovered", m.cfg.Name, bucket.URL.Redacted())
+		}
+		if failing = err != nil; failing {
+			result = "error"
+		}
+		hlsPushes.With(m.cfg.Name, result).Inc()
+		return err == nil
+	}
+	put := func(key, contentType, cache string, data []byte) bool {
+		pctx, cancel := context.WithTimeout(ctx, hlsPushTimeout)
+		defer cancel()
+		return report(bucket.Put(pctx, key, contentType, cache, data))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.hls.Changed():
+		}
+		segs := m.hls.Segments()
+		if len(segs) == 0 {
+			continue
+		}
+		// A segment that failed is tried again on the next change, and the
+		// playlist isn't updated past it until it is in the bucket.
+		ok := true
+		for _, seg := range segs {
+			if len(pushed) > 0 && seg.Seq <= pushed[len(pushed)-1] {
+				continue
+			}
+			if ok = put(segmentKey(seg.Seq), m.hls.Format().ContentType(), hlsSegmentCache, seg.Data); !ok {
+				break
+			}
+			pushed = append(pushed, seg.Seq)
+		}
+		if !ok || ctx.Err() != nil {
+			continue
+		}
+		if playlist, ok := m.hls.Playlist(path.Base(m.cfg.ListenPath) + "/"); ok {
+			put(name+".m3u8", hlsPlaylistType, m.hlsPlaylistCache(), []byte(playlist))
+		}
+		for len(pushed) > 0 && pushed[0] < segs[0].Seq-int64(len(segs)) {
+			dctx, cancel := context.WithTimeout(ctx, hlsPushTimeout)
+			ok := report(bucket.Delete(dctx, segmentKey(pushed[0])))
+			cancel()
+			if !ok {
+				break
+			}
+			pushed = pushed[1:]
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"strings"
+)
+
+// hostRoutes holds, for each station with its own domains, the paths its
+// mounts answer on there; see registerHosts.
+var hostRoutes = make(map[string]map[string]http.HandlerFunc)
+
+// registerHosts gives stations with their own domains Icecast's usual
+// layout on them: on radio2.example.org, /stream and /listen/late reach
+// [mount radio2/default] and [mount radio2/late], and so do the mounts'
+// aliases. The prefixed paths keep working on every host.
+func registerHosts() {
+	for _, m := range mounts() {
+		if m.cfg.Station == config.DefaultStationName || len(m.station.cfg.Hosts) == 0 {
+			continue
+		}
+		routes := hostRoutes[m.cfg.Station]
+		if routes == nil {
+			routes = make(map[string]http.HandlerFunc)
+			hostRoutes[m.cfg.Station] = routes
+		}
+		prefix := "/" + m.cfg.Station
+		if m.cfg.DownmixOf == "" {
+			source := strings.TrimPrefix(m.cfg.SourcePath, prefix)
+			routes[source] = m.streamHandler
+			routes[source+wsSourcePath] = m.wsSourceHandler
+		}
+		for _, listen := range append([]string{m.cfg.ListenPath}, m.cfg.Aliases...) {
+			routes[strings.TrimPrefix(listen, prefix)] = m.listenHandler
+		}
+		log.Printf("Mount %s: also on %v without the %s prefix", m.cfg.Name, m.station.cfg.Hosts, prefix)
+	}
+}
+
+// hostMiddleware sends requests for a station's own domains, with or
+// without a port, to that station's mounts; see registerHosts.
+func hostMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routes := hostRoutes[hostStation(r.Host)]; routes != nil {
+			if h, ok := routes[r.URL.Path]; ok {
+				h(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tlsConfig loads the global and per-station certificates and picks one
+// by SNI, falling back to the global certificate for unknown hosts.
+func tlsConfig() (*tls.Config, error) {
+	var fallback *tls.Certificate
+	if config.AppConfig.TLSCert != "" {
+		c, err := tls.LoadX509KeyPair(config.AppConfig.TLSCert, config.AppConfig.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls_cert: %w", err)
+		}
+		fallback = &c
+	}
+	byHost := make(map[string]*tls.Certificate)
+	for _, sc := range config.AppConfig.Stations {
+		if sc.TLSCert == "" {
+			continue
+		}
+		c, err := tls.LoadX509KeyPair(sc.TLSCert, sc.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("station %s: failed to load tls_cert: %w", sc.Name, err)
+		}
+		for _, host := range sc.Hosts {
+			byHost[host] = &c
+		}
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if c, ok := byHost[strings.ToLower(hello.ServerName)]; ok {
+				return c, nil
+			}
+			if fallback != nil {
+				return fallback, nil
+			}
+			return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+		},
+	}, nil
+}
+
+// serveHTTPS runs the TLS listener until ctx is cancelled, shutting down
+// the same way as the plain HTTP one.
+func serveHTTPS(ctx context.Context, srv *http.Server) error {
+	return serve(ctx, srv, func() error {
+		log.Printf("Listening for HTTPS on %s", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	})
+}
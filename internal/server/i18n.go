@@ -0,0 +1,110 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/i18n"
+)
+
+// messages are the built-in English texts of what listeners see. A
+// catalog in locale_dir translates them, or rewords them for English; see
+// the i18n package for the format.
+var messages = map[string]string{
+	"listen.no_stream":           "No active stream",
+	"listen.mount_full":          "Mount is full",
+	"listen.station_full":        "Station is full",
+	"listen.over_quota":          "Station is over its bandwidth quota",
+	"listen.busy":                "Server is busy",
+	"listen.too_many":            "Too many connections from your network",
+	"listen.reconnects":          "Too many reconnects; try again later",
+	"listen.unauthorized":        "Unauthorized",
+	"listen.no_credentials":      "Unauthorized - no credentials",
+	"listen.auth_unavailable":    "Listener authentication is unavailable",
+	"listen.not_allowed":         "Not allowed",
+	"listen.retired":             "This stream has been retired",
+	"window.off_air":             "This mount is off air right now (broadcast window: {windows})",
+	"geo.title":                  "Not available in your region",
+	"geo.denied":                 "Sorry, {mount} can't be streamed in your country due to licensing restrictions.",
+	"geo.denied_country":         "Sorry, {mount} can't be streamed in your country ({country}) due to licensing restrictions.",
+	"title.minutes_left":         "{title} ({minutes} minutes left)",
+	"title.handing_over":         "{title} (handing over to {to} in {seconds} seconds)",
+	"announce.station_id":        "You're listening to {station}.",
+	"announce.up_next":           "Coming up next: {show}.",
+	"announce.back_soon":         "We'll be right back.",
+	"announce.handing_over":      "{from} is handing over to {to}.",
+	"announce.thanks_for_tuning": "Thanks for listening to {station}.",
+	"player.controls":            "Player controls",
+	"player.play":                "Play",
+	"player.pause":               "Pause",
+	"player.mute":                "Mute",
+	"player.unmute":              "Unmute",
+	"player.volume":              "Volume",
+	"player.playing":             "Playing",
+	"player.paused":              "Paused",
+	"player.error":               "The stream could not be played. Try again in a moment.",
+	"player.no_audio":            "Your browser can't play audio here; open the stream in your media player instead.",
+	"player.now_playing":         "Now playing",
+	"player.nothing":             "Nothing announced",
+	"player.contrast":            "High contrast",
+	"player.shortcuts":           "Keyboard shortcuts",
+	"player.key_play":            "Space or K: play or pause",
+	"player.key_mute":            "M: mute or unmute",
+	"player.key_volume":          "Up and down arrows: volume",
+	"player.streams":             "Other streams",
+}
+
+// catalogs translates messages; loadLocales adds locale_dir's catalogs.
+var catalogs = i18n.New("en", messages)
+
+// loadLocales loads the catalogs in locale_dir, if it is set.
+func loadLocales() error {
+	cfg := config.AppConfig
+	if cfg.LocaleDir != "" {
+		if err := catalogs.LoadDir(cfg.LocaleDir); err != nil {
+			return err
+		}
+		log.Printf("Loaded message catalogs for %v from %s", catalogs.Languages(), cfg.LocaleDir)
+	}
+	for _, m := range cfg.Mounts {
+		if !catalogs.Has(m.Language) {
+			log.Printf("Warning: mount %s is in %s, but there is no catalog for it; listeners will see English", m.Name, m.Language)
+		}
+	}
+	return nil
+}
+
+// lang returns the language to answer r in on this mount: the listener's
+// preferred one among the catalogs, or else the mount's own.
+func (m *mount) lang(r *http.Request) string {
+	return catalogs.Negotiate(r.Header.Get("Accept-Language"), m.cfg.Language)
+}
+
+// tr translates key, with args as in i18n.Catalogs.T, for the listener
+// making r.
+func (m *mount) tr(r *http.Request, key string, args ...string) string {
+	return catalogs.T(m.lang(r), key, args...)
+}
+
+// trTitle translates a notice for the stream title, which every listener
+// sees alike, into the mount's language.
+func (m *mount) trTitle(key string, args ...string) string {
+	return catalogs.T(m.cfg.Language, key, args...)
+}
+
+// listenerError replies with the error key names, in the listener's
+// language.
+func (m *mount) listenerError(w http.ResponseWriter, r *http.Request, status int, key string, args ...string) {
+	lang := m.lang(r)
+	w.Header().Set("Content-Language", lang)
+	http.Error(w, catalogs.T(lang, key, args...), status)
+}
+
+// requestError is listenerError for requests not yet matched to a mount,
+// answered in the default mount language when the listener's has no
+// catalog.
+func requestError(w http.ResponseWriter, r *http.Request, status int, key string, args ...string) {
+	lang := catalogs.Negotiate(r.Header.Get("Accept-Language"), config.AppConfig.MountDefaults.Language)
+	w.Header().Set("Content-Language", lang)
+	http.Error(w, catalogs.T(lang, key, args...), status)
+}
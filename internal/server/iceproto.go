@@ -0,0 +1,203 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// iceInfo is what an Icecast source client says about its stream in ice-*
+// headers (or the SHOUTcast-era icy-* ones some still send).
+type iceInfo struct {
+	Name        string
+	Genre       string
+	Description string
+	URL         string
+	AudioInfo   string // e.g. "bitrate=128;samplerate=44100;channels=2"
+	Public      bool
+}
+
+func parseIceInfo(h http.Header) iceInfo {
+	get := func(name string) string {
+		if v := h.Get("ice-" + name); v != "" {
+			return v
+		}
+		return h.Get("icy-" + name)
+	}
+	info := iceInfo{
+		Name:        get("name"),
+		Genre:       get("genre"),
+		Description: get("description"),
+		URL:         get("url"),
+		AudioInfo:   h.Get("ice-audio-info"),
+	}
+	public := h.Get("ice-public")
+	if public == "" {
+		public = h.Get("icy-pub")
+	}
+	info.Public = public == "1"
+	if br := get("bitrate"); info.AudioInfo == "" && br != "" {
+		info.AudioInfo = "bitrate=" + br
+	}
+	if br := h.Get("icy-br"); info.AudioInfo == "" && br != "" {
+		info.AudioInfo = "bitrate=" + br
+	}
+	return info
+}
+
+func (s *sourceSession) setIceInfo(info iceInfo) {
+	s.statsMu.Lock()
+	s.ice = info
+	s.statsMu.Unlock()
+}
+
+func (s *sourceSession) iceInfo() iceInfo {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.ice
+}
+
+// setSourceBranding passes what the live source says about its stream on
+// to a listener, the way Icecast does: the encoder's stream name, genre,
+// description, URL and bitrate override the station's branding and the
+// mount's configured bitrate, so players show the show that is on.
+func (m *mount) setSourceBranding(w http.ResponseWriter) {
+	s := m.currentSession()
+	if s == nil {
+		return
+	}
+	ice := s.iceInfo()
+	for header, value := range map[string]string{
+		"icy-name":        ice.Name,
+		"icy-description": ice.Description,
+		"icy-url":         ice.URL,
+		"icy-genre":       ice.Genre,
+	} {
+		if value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	if br := ice.bitrate(); br > 0 {
+		w.Header().Set("icy-br", strconv.Itoa(br))
+	}
+}
+
+// sourceStream is what /api/stations says about a live mount's stream:
+// the encoder's own description of it, and the title on air.
+type sourceStream struct {
+	Name        string `json:"name,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Bitrate     int    `json:"bitrate,omitempty"` // kbit/s
+	AudioInfo   string `json:"audio_info,omitempty"`
+	Title       string `json:"title,omitempty"`
+}
+
+// sourceStream describes m's live stream, or returns nil if it has none.
+func (m *mount) sourceStream() *sourceStream {
+	s := m.currentSession()
+	if s == nil {
+		return nil
+	}
+	ice := s.iceInfo()
+	return &sourceStream{
+		Name:        ice.Name,
+		Genre:       ice.Genre,
+		Description: ice.Description,
+		URL:         ice.URL,
+		Bitrate:     ice.bitrate(),
+		AudioInfo:   ice.AudioInfo,
+		Title:       m.currentTitle(),
+	}
+}
+
+// sourceMethod reports whether method is one source clients stream with:
+// PUT (Icecast 2.4 and later), SOURCE (older Icecast and most encoders
+// still) or POST.
+func sourceMethod(method string) bool {
+	return method == http.MethodPut || method == "SOURCE" || method == http.MethodPost
+}
+
+// sourceConn is where a source connection's audio is read from.
+type sourceConn struct {
+	body io.Reader
+
+	// interrupt makes a read in progress fail, for kicking.
+	interrupt func() error
+	// fail turns the connection away with an HTTP error, if it can still
+	// be given one; it is only closed otherwise.
+	fail func(code int, msg string)
+	// accept tells the source it is live, once it can no longer fail.
+	accept func()
+	// release frees the connection once the handler is done with it.
+	release func()
+}
+
+// openSource answers a source connection and returns its audio. Encoders
+// that frame their upload, with a Content-Length, chunked encoding or
+// HTTP/2, are read through net/http as usual, and answered 200 OK as soon
+// as they are accepted rather than when the upload ends: reverse proxies
+// and HTTP/2 clients wait for that before they treat the upload as a
+// stream. HTTP/1 needs full duplex for it, as net/http would otherwise
+// throw the rest of the body away. Icecast clients don't frame the upload:
+// they send SOURCE, or PUT with Expect: 100-continue, and the audio runs
+// until the connection closes. net/http reads such a request as having no
+// body, so the connection is taken over from it and answered the way
+// Icecast does.
+func openSource(w http.ResponseWriter, r *http.Request) (*sourceConn, error) {
+	rc := http.NewResponseController(w)
+	if r.Header.Get("Content-Length") != "" || len(r.TransferEncoding) > 0 || r.ProtoMajor >= 2 {
+		return &sourceConn{
+			body:      r.Body,
+			interrupt: func() error { return rc.SetReadDeadline(time.Now()) },
+			fail:      func(code int, msg string) { http.Error(w, msg, code) },
+			accept: func() {
+				if r.ProtoMajor < 2 {
+					rc.EnableFullDuplex()
+				}
+				w.WriteHeader(http.StatusOK)
+				rc.Flush()
+			},
+			release: func() {},
+		}, nil
+	}
+
+	conn, brw, err := rc.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Clear any deadline the server set for reading the request itself.
+	conn.SetDeadline(time.Time{})
+	// After 100 Continue a final status can still follow; after 200 OK
+	// there is nothing left to say.
+	cont := strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+	reply := "HTTP/1.0 200 OK\r\n\r\n"
+	if cont {
+		reply = "HTTP/1.1 100 Continue\r\n\r\n"
+	}
+	if _, err := brw.WriteString(reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sourceConn{
+		body:      brw.Reader,
+		interrupt: func() error { return conn.SetReadDeadline(time.Now()) },
+		fail: func(code int, msg string) {
+			if cont {
+				fmt.Fprintf(brw, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s\n",
+					code, http.StatusText(code), len(msg)+1, msg)
+				brw.Flush()
+			}
+		},
+		accept:  func() {}, // answered already
+		release: func() { conn.Close() },
+	}, nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"io"
+	"strings"
+)
+
+const (
+	// endOfStreamTitle is the last title listeners see before the connection
+	// closes, so players show why the audio stopped instead of "network error".
+	endOfStreamTitle = "Stream ended"
+
+	// maxICYMetaLen is the largest metadata block the one-byte length prefix
+	// can describe (255 * 16 bytes).
+	maxICYMetaLen = 255 * 16
+)
+
+// icyWriter interleaves SHOUTcast-style metadata blocks into the audio stream
+// every metaint bytes, for listeners that asked for them with Icy-MetaData: 1.
+type icyWriter struct {
+	w         io.Writer
+	metaint   int
+	remaining int           // audio bytes left before the next metadata block
+	title     func() string // current title of the mount
+	sent      string        // last title actually sent to this listener
+}
+
+func newICYWriter(w io.Writer, metaint int, title func() string) *icyWriter {
+	return &icyWriter{w: w, metaint: metaint, remaining: metaint, title: title}
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > iw.remaining {
+			n = iw.remaining
+		}
+		if _, err := iw.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+		iw.remaining -= n
+
+		if iw.remaining == 0 {
+			if err := iw.writeMeta(iw.title()); err != nil {
+				return written, err
+			}
+			iw.remaining = iw.metaint
+		}
+	}
+	return written, nil
+}
+
+// writeMeta sends a metadata block, or the single zero byte meaning
+// "unchanged" if this listener already has the title.
+func (iw *icyWriter) writeMeta(title string) error {
+	if title == iw.sent {
+		_, err := iw.w.Write([]byte{0})
+		return err
+	}
+	if _, err := iw.w.Write(encodeICYMeta(title)); err != nil {
+		return err
+	}
+	iw.sent = title
+	return nil
+}
+
+// finish pads the current audio block out to the metadata boundary and sends
+// one last title. The padding is zero bytes, which MP3 and AAC decoders skip
+// while searching for the next frame sync.
+func (iw *icyWriter) finish(title string) error {
+	if _, err := iw.w.Write(make([]byte, iw.remaining)); err != nil {
+		return err
+	}
+	iw.remaining = iw.metaint
+	return iw.writeMeta(title)
+}
+
+// encodeICYMeta builds a length-prefixed StreamTitle block.
+func encodeICYMeta(title string) []byte {
+	// A quote would terminate the value early in most players' parsers.
+	title = strings.ReplaceAll(title, "'", "’")
+	meta := "StreamTitle='" + title + "';"
+	if len(meta) > maxICYMetaLen {
+		meta = meta[:maxICYMetaLen-2] + "';"
+	}
+	blocks := (len(meta) + 15) / 16
+	buf := make([]byte, 1+blocks*16)
+	buf[0] = byte(blocks)
+	copy(buf[1:], meta)
+	return buf
+}
+
+// icyReader strips the metadata blocks an upstream server interleaves into
+// its audio every metaint bytes, passing each StreamTitle it announces to
+// title. It is the reverse of icyWriter, for relaying.
+type icyReader struct {
+	r         io.Reader
+	metaint   int
+	remaining int // audio bytes left before the next metadata block
+	title     func(string)
+	last      string
+}
+
+func newICYReader(r io.Reader, metaint int, title func(string)) *icyReader {
+	return &icyReader{r: r, metaint: metaint, remaining: metaint, title: title}
+}
+
+func (ir *icyReader) Read(p []byte) (int, error) {
+	if ir.remaining == 0 {
+		if err := ir.readMeta(); err != nil {
+			return 0, err
+		}
+		ir.remaining = ir.metaint
+	}
+	if len(p) > ir.remaining {
+		p = p[:ir.remaining]
+	}
+	n, err := ir.r.Read(p)
+	ir.remaining -= n
+	return n, err
+}
+
+// readMeta reads one metadata block and reports its title if it changed.
+func (ir *icyReader) readMeta() error {
+	var size [1]byte
+	if _, err := io.ReadFull(ir.r, size[:]); err != nil {
+		return err
+	}
+	if size[0] == 0 {
+		return nil
+	}
+	meta := make([]byte, int(size[0])*16)
+	if _, err := io.ReadFull(ir.r, meta); err != nil {
+		return err
+	}
+	if title, ok := decodeICYMeta(string(meta)); ok && title != ir.last {
+		ir.last = title
+		ir.title(title)
+	}
+	return nil
+}
+
+// decodeICYMeta finds the StreamTitle in a metadata block.
+func decodeICYMeta(meta string) (string, bool) {
+	_, rest, ok := strings.Cut(meta, "StreamTitle='")
+	if !ok {
+		return "", false
+	}
+	title, _, ok := strings.Cut(rest, "';")
+	return title, ok
+}
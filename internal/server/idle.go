@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"nickcast/internal/metrics"
+	"os"
+	"time"
+)
+
+var idleDisconnects = metrics.NewCounterVec("nickcast_idle_listeners_total", "Listeners disconnected for not reading their stream for listener_idle_timeout.", "mount")
+
+// idleGuard notices listeners that have stopped reading: players paused
+// with the connection left open. Nothing they are sent is read, so once
+// the socket buffers fill, writes to them block, and their queue stays
+// full while they hold bandwidth accounting and memory. Each write gets
+// listener_idle_timeout to complete.
+type idleGuard struct {
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+// idleGuard returns the guard for a listener's writes, or nil if the mount
+// has no listener_idle_timeout; a nil guard does nothing.
+func (m *mount) idleGuard(w http.ResponseWriter) *idleGuard {
+	if m.cfg.IdleTimeout <= 0 {
+		return nil
+	}
+	return &idleGuard{rc: http.NewResponseController(w), timeout: time.Duration(m.cfg.IdleTimeout) * time.Second}
+}
+
+// arm gives the next write the idle timeout to complete.
+func (g *idleGuard) arm() {
+	if g != nil {
+		g.rc.SetWriteDeadline(time.Now().Add(g.timeout))
+	}
+}
+
+// release clears the deadline, for a connection that outlives the stream.
+func (g *idleGuard) release() {
+	if g != nil {
+		g.rc.SetWriteDeadline(time.Time{})
+	}
+}
+
+// tripped reports whether err is a write that timed out under the guard.
+func (g *idleGuard) tripped(err error) bool {
+	return g != nil && errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// writeFailed logs a failed write of what to a listener, counting it if
+// the listener had gone idle.
+func (m *mount) writeFailed(r *http.Request, g *idleGuard, what string, err error) {
+	if g.tripped(err) {
+		idleDisconnects.With(m.cfg.Name).Inc()
+		logf(r, "Listener from %s on %s stopped reading for %s; disconnecting.", r.RemoteAddr, m.cfg.Name, g.timeout)
+		return
+	}
+	logf(r, "Error writing %s to listener from %s: %v", what, r.RemoteAddr, err)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ingestFallbackBitrate (kbps) is the stream bitrate assumed when the
+	// mount, the encoder and the audio itself don't say: MP3's highest.
+	ingestFallbackBitrate = 320
+
+	// ingestCheckInterval is how often sources are checked against
+	// ingest_timeout.
+	ingestCheckInterval = time.Second
+)
+
+var (
+	ingestThrottled = metrics.NewCounterVec("nickcast_source_ingest_throttled_total", "Times a source was held back for sending faster than ingest_limit allows.", "mount")
+	ingestTimeouts  = metrics.NewCounterVec("nickcast_source_ingest_timeouts_total", "Sources disconnected for sending nothing for ingest_timeout.", "mount")
+)
+
+// ingestLimiter is a token bucket on the bytes a source sends. It holds a
+// source that sends faster than real time allows, which TCP passes back to
+// the encoder; a source flooding gigabits ends up sending at a few times
+// its bitrate instead of filling the buffers and knocking listeners over.
+// The bucket holds ingest_burst seconds at the limit's rate, for encoders
+// that send a few seconds of buffered audio on connecting or after a
+// network hiccup.
+type ingestLimiter struct {
+	credit    float64 // bytes that may be sent right away
+	last      time.Time
+	throttled bool // held back at the last take, so each episode counts once
+}
+
+// take accounts for n bytes from the source at rate bytes per second and
+// returns how long to wait before reading more.
+func (l *ingestLimiter) take(n int, rate float64, burst time.Duration) time.Duration {
+	at := clock.Default.Now()
+	limit := rate * burst.Seconds()
+	if l.last.IsZero() {
+		l.credit = limit
+	} else {
+		l.credit += at.Sub(l.last).Seconds() * rate
+	}
+	if l.credit > limit {
+		l.credit = limit
+	}
+	l.last = at
+	l.credit -= float64(n)
+	if l.credit >= 0 {
+		l.throttled = false
+		return 0
+	}
+	return time.Duration(-l.credit / rate * float64(time.Second))
+}
+
+// ingestRate is the most the session's source may send, in bytes per
+// second: ingest_limit times the stream's bitrate, or 0 for no limit. The
+// bitrate is the higher of what the mount is configured with and what its
+// MP3 frames say, so that a source sending a higher bitrate than the
+// mount's isn't held back below real time. What the encoder declares
+// doesn't count: a source could otherwise lift its own limit by
+// declaring a bitrate it never sends.
+func (s *sourceSession) ingestRate() float64 {
+	if s.m.cfg.IngestLimit <= 0 {
+		return 0
+	}
+	kbps := s.m.cfg.Bitrate
+	s.statsMu.Lock()
+	if s.frames != nil {
+		if b := s.frames.Stats().MaxBitrate; b > kbps {
+			kbps = b
+		}
+	}
+	s.statsMu.Unlock()
+	if kbps <= 0 {
+		kbps = ingestFallbackBitrate
+	}
+	return s.m.cfg.IngestLimit * float64(kbps) * 1000 / 8
+}
+
+// throttle returns how long to hold the source back after it sent n more
+// bytes.
+func (s *sourceSession) throttle(n int) time.Duration {
+	rate := s.ingestRate()
+	if rate <= 0 {
+		return 0
+	}
+	wait := s.ingest.take(n, rate, time.Duration(s.m.cfg.IngestBurst)*time.Second)
+	if wait <= 0 {
+		return 0
+	}
+	if !s.ingest.throttled {
+		s.ingest.throttled = true
+		ingestThrottled.With(s.m.cfg.Name).Inc()
+		s.logf("Streamer %s is sending faster than %g times the stream bitrate; holding it back", s.account, s.m.cfg.IngestLimit)
+	}
+	s.statsMu.Lock()
+	s.held += wait
+	s.statsMu.Unlock()
+	return wait
+}
+
+// bitrate returns the bitrate (kbps) the encoder declared in ice-audio-info
+// or icy-br, or 0.
+func (i iceInfo) bitrate() int {
+	for _, kv := range strings.Split(i.AudioInfo, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		if strings.TrimPrefix(strings.ToLower(k), "ice-") == "bitrate" {
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+	return 0
+}
+
+// ingestStalled reports whether the session's source has sent nothing
+// since before at minus timeout or, with min above 0, fewer than min bytes
+// in the latest window of timeout, returning what it did send. It reports
+// true only once per session.
+func (s *sourceSession) ingestStalled(at time.Time, timeout time.Duration, min int64) (got int64, stalled bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.stalled {
+		return 0, false
+	}
+	if at.Sub(time.Unix(0, s.m.lastData.Load())) >= timeout {
+		s.stalled = true
+		return 0, true
+	}
+	if min <= 0 {
+		return 0, false
+	}
+	if s.window.IsZero() {
+		s.window = s.started
+	}
+	if at.Sub(s.window) < timeout {
+		return 0, false
+	}
+	got = s.bytes - s.windowBytes
+	if got < min {
+		s.stalled = true
+		return got, true
+	}
+	s.window, s.windowBytes = at, s.bytes
+	return got, false
+}
+
+// watchIngest disconnects sources that have sent nothing for their mount's
+// ingest_timeout, or less than its ingest_min_bytes: an encoder that has
+// stalled with its connection still open would otherwise hold the mount
+// until TCP gives up on it. They leave as any source does, so listeners
+// move to the fallback and the disconnect is reported.
+func watchIngest(ctx context.Context) error {
+	t := clock.Default.NewTicker(ingestCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		at := clock.Default.Now()
+		for _, m := range mounts() {
+			timeout := time.Duration(m.cfg.IngestTimeout) * time.Second
+			s := m.currentSession()
+			if s == nil || timeout <= 0 {
+				continue
+			}
+			got, stalled := s.ingestStalled(at, timeout, int64(m.cfg.IngestMinBytes))
+			if !stalled {
+				continue
+			}
+			ingestTimeouts.With(m.cfg.Name).Inc()
+			if got > 0 {
+				s.logf("Only %d bytes from %s on %s in %ds, below ingest_min_bytes", got, s.account, m.cfg.Name, m.cfg.IngestTimeout)
+			} else {
+				s.logf("No data from %s on %s for %ds", s.account, m.cfg.Name, m.cfg.IngestTimeout)
+			}
+			m.evictSource("stalled")
+		}
+	}
+}
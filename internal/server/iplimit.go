@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Per-IP accounting works on prefixes rather than single addresses: an IPv6
+// host usually controls a whole /64 and can rotate through it at will, so
+// counting (and banning) individual v6 addresses would be pointless.
+
+// clientAddr parses the request's remote IP, unmapping IPv4-in-IPv6.
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// ipPrefix aggregates addr to the configured prefix length for its family.
+func ipPrefix(addr netip.Addr) netip.Prefix {
+	bits := config.AppConfig.IPv6Prefix
+	if addr.Is4() {
+		bits = config.AppConfig.IPv4Prefix
+	}
+	p, err := addr.Prefix(bits)
+	if err != nil {
+		return netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return p
+}
+
+// clientPrefix is the accounting key for a request: its aggregated prefix,
+// or the raw remote address if it couldn't be parsed.
+func clientPrefix(r *http.Request) string {
+	addr, ok := clientAddr(r)
+	if !ok {
+		return clientIP(r)
+	}
+	return ipPrefix(addr).String()
+}
+
+// ipConnections counts open listener connections per prefix.
+var (
+	ipConnections   = make(map[string]int)
+	ipConnectionsMu sync.Mutex
+)
+
+// acquireIP reserves a listener slot for the request's prefix, reporting
+// false if it is already at max_listeners_per_ip.
+func acquireIP(key string) bool {
+	limit := config.AppConfig.MaxListenersPerIP
+	ipConnectionsMu.Lock()
+	defer ipConnectionsMu.Unlock()
+	if limit > 0 && ipConnections[key] >= limit {
+		return false
+	}
+	ipConnections[key]++
+	return true
+}
+
+func releaseIP(key string) {
+	ipConnectionsMu.Lock()
+	defer ipConnectionsMu.Unlock()
+	if ipConnections[key] <= 1 {
+		delete(ipConnections, key)
+	} else {
+		ipConnections[key]--
+	}
+}
+
+// banList holds banned prefixes: the static ones from config, plus any added
+// at runtime through the admin API (optionally with an expiry).
+type banList struct {
+	mu      sync.Mutex
+	static  []netip.Prefix
+	dynamic map[netip.Prefix]time.Time // zero time means no expiry
+}
+
+var bans = &banList{dynamic: make(map[netip.Prefix]time.Time)}
+
+// loadStatic parses the bans configured in nickcast.conf.
+func (b *banList) loadStatic(entries []string) error {
+	var prefixes []netip.Prefix
+	for _, e := range entries {
+		p, err := parseBanEntry(e)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, p)
+	}
+	b.mu.Lock()
+	b.static = prefixes
+	b.mu.Unlock()
+	return nil
+}
+
+// parseBanEntry accepts either a CIDR prefix or a bare address. A bare
+// address is widened to the configured aggregation prefix, so banning one
+// IPv6 address bans its whole /64.
+func parseBanEntry(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ban entry %q", s)
+	}
+	return ipPrefix(addr.Unmap()), nil
+}
+
+// banned reports whether addr falls inside any active ban.
+func (b *banList) banned(addr netip.Addr) bool {
+	now := clock.Default.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range b.static {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	for p, until := range b.dynamic {
+		if !until.IsZero() && !now.Before(until) {
+			delete(b.dynamic, p)
+			continue
+		}
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *banList) add(p netip.Prefix, until time.Time) {
+	b.mu.Lock()
+	b.dynamic[p] = until
+	b.mu.Unlock()
+	persist(storeBans)
+}
+
+func (b *banList) remove(p netip.Prefix) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.dynamic[p]
+	delete(b.dynamic, p)
+	if ok {
+		persist(storeBans)
+	}
+	return ok
+}
+
+type banEntry struct {
+	Prefix  string     `json:"prefix"`
+	Until   *time.Time `json:"until,omitempty"`
+	FromCfg bool       `json:"from_config,omitempty"`
+}
+
+func (b *banList) list() []banEntry {
+	now := clock.Default.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []banEntry
+	for _, p := range b.static {
+		out = append(out, banEntry{Prefix: p.String(), FromCfg: true})
+	}
+	for p, until := range b.dynamic {
+		if !until.IsZero() && !now.Before(until) {
+			continue
+		}
+		e := banEntry{Prefix: p.String()}
+		if !until.IsZero() {
+			u := until
+			e.Until = &u
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out
+}
+
+// banMiddleware rejects every request from a banned prefix, sources and
+// listeners alike.
+func banMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := clientAddr(r); ok && bans.banned(addr) {
+			logf(r, "Rejected request from banned address %s", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bansHandler lets admins list (GET), add (POST ip=...&duration=<seconds>)
+// and lift (DELETE ip=...) runtime bans.
+func bansHandler(w http.ResponseWriter, r *http.Request) {
+	// Bans apply to the whole process, so only default station admins
+	// manage them.
+	if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := parseListQuery(r, "prefix", "prefix", "until")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list := bans.list()
+		if list == nil {
+			list = []banEntry{}
+		}
+		q.sort(list, func(i, j int) bool {
+			if q.Sort == "until" {
+				// Permanent bans sort last.
+				a, b := list[i].Until, list[j].Until
+				return a != nil && (b == nil || a.Before(*b))
+			}
+			return list[i].Prefix < list[j].Prefix
+		})
+		lo, hi := q.page(w, r, len(list))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list[lo:hi])
+	case http.MethodPost, http.MethodDelete:
+		p, err := parseBanEntry(r.FormValue("ip"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if !bans.remove(p) {
+				http.Error(w, "No such ban", http.StatusNotFound)
+				return
+			}
+			logf(r, "Ban on %s lifted", p)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var until time.Time
+		if d := r.FormValue("duration"); d != "" {
+			secs, err := strconv.Atoi(d)
+			if err != nil || secs <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+			until = clock.Default.Now().Add(time.Duration(secs) * time.Second)
+		}
+		bans.add(p, until)
+		if until.IsZero() {
+			logf(r, "Banned %s indefinitely", p)
+		} else {
+			logf(r, "Banned %s until %s", p, until.Format(time.RFC3339))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
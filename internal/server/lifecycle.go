@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"nickcast/internal/clock"
+	"sync"
+	"time"
+)
+
+// streamState is where a mount is in its source lifecycle. Transitions
+// happen under the mount's stateMu:
+//
+//	idle → authenticating   a source claims the mount (claimSource)
+//	authenticating → idle   it is turned away (releaseSource)
+//	authenticating → live   its session starts (startSession)
+//	live → draining         it disconnects (sourceSession.end)
+//	live → grace            it disconnects, with reconnect_grace set
+//	grace → authenticating  a source claims the mount within the grace
+//	                        period; if let in, it carries on the stream,
+//	                        and if turned away (with reconnect_reserve,
+//	                        because it isn't the source that dropped) the
+//	                        grace period resumes
+//	grace → draining        the grace period runs out
+//	live → authenticating   a source with a higher priority takes over
+//	                        (takeOver); the live one is disconnected and
+//	                        the new one carries on its stream
+//	authenticating → draining
+//	                        a takeover falls through
+//	draining → idle         its listeners are gone and the buffers reset
+//
+// Only an idle mount, or one in its grace period, can be claimed, and a
+// takeover waits for the live session to end, so a new source never starts
+// while the last one is still being torn down, and a listener can only join
+// the stream that is current.
+type streamState int32
+
+const (
+	stateIdle streamState = iota
+	stateAuthenticating
+	stateLive
+	stateGrace
+	stateDraining
+)
+
+func (s streamState) String() string {
+	switch s {
+	case stateIdle:
+		return "idle"
+	case stateAuthenticating:
+		return "authenticating"
+	case stateLive:
+		return "live"
+	case stateGrace:
+		return "grace"
+	case stateDraining:
+		return "draining"
+	}
+	return "unknown"
+}
+
+var (
+	errMountFull   = errors.New("mount is at its listener limit")
+	errStreamEnded = errors.New("stream has ended")
+)
+
+// stream is one source session as its listeners see it. The next session's
+// stream is made as soon as the last one has drained, so listeners that
+// connect while the mount is idle wait for that session's first data.
+type stream struct {
+	ctx       context.Context // cancelled when the session ends
+	cancel    context.CancelFunc
+	firstData chan struct{} // closed when the session sends its first data
+	firstOnce sync.Once
+}
+
+func newStream() *stream {
+	st := &stream{firstData: make(chan struct{})}
+	st.ctx, st.cancel = context.WithCancel(context.Background())
+	return st
+}
+
+// started closes firstData, reporting whether this call was the one that
+// did.
+func (st *stream) started() bool {
+	first := false
+	st.firstOnce.Do(func() {
+		close(st.firstData)
+		first = true
+	})
+	return first
+}
+
+func (m *mount) loadState() streamState {
+	return streamState(m.state.Load())
+}
+
+// setState must be called with stateMu held.
+func (m *mount) setState(s streamState) {
+	m.state.Store(int32(s))
+}
+
+// active reports whether a source holds the mount, whether it is still
+// authenticating or already live.
+func (m *mount) active() bool {
+	s := m.loadState()
+	return s == stateAuthenticating || s == stateLive
+}
+
+// claimSource takes the mount's single source slot, reporting false unless
+// the mount is idle or in its grace period. A successful claim must be
+// followed by either startSession or releaseSource.
+func (m *mount) claimSource() bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	state := m.loadState()
+	if state != stateIdle && state != stateGrace {
+		return false
+	}
+	m.claimedFrom = state
+	m.priority = 0
+	m.setState(stateAuthenticating)
+	return true
+}
+
+// releaseSource gives up a claim that never turned into a session. A mount
+// claimed during its grace period goes back to it, or ends the stream if
+// the grace period ran out meanwhile; a takeover that falls through ends
+// the stream, as its source is gone.
+func (m *mount) releaseSource() {
+	m.setFormat("")
+	m.stateMu.Lock()
+	if a := m.claim; a != nil {
+		m.claim = nil
+		defer a.dropHold()
+	}
+	if m.loadState() != stateAuthenticating {
+		m.stateMu.Unlock()
+		return
+	}
+	if m.claimedFrom == stateIdle {
+		m.setState(stateIdle)
+		m.stateMu.Unlock()
+		return
+	}
+	st := m.stream
+	if m.claimedFrom == stateGrace && clock.Default.Now().Before(m.graceUntil) {
+		m.setState(stateGrace)
+		m.stateMu.Unlock()
+		return
+	}
+	m.setState(stateDraining)
+	m.stateMu.Unlock()
+	m.endStream(st)
+}
+
+// goLive moves a claimed mount to live, returning the stream the new
+// session feeds and whether it is carrying on one whose source dropped or
+// was taken over.
+func (m *mount) goLive() (st *stream, resumed bool) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	m.setState(stateLive)
+	return m.stream, m.claimedFrom != stateIdle
+}
+
+// drain moves the mount to draining if st is current and the mount is in
+// state from, reporting whether it did. From then on no listener can join
+// st, so clearListeners reaches all of them.
+func (m *mount) drain(st *stream, from streamState) bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if m.stream != st || m.loadState() != from {
+		return false
+	}
+	m.setState(stateDraining)
+	return true
+}
+
+// endStream finishes a draining stream: its listeners play out what they
+// have queued and leave, and the mount goes idle with its buffers reset
+// and a fresh stream for the next session.
+func (m *mount) endStream(st *stream) {
+	// Close the listener channels before cancelling the context, so
+	// listeners drain what's queued and end cleanly rather than abruptly.
+	m.clearListeners()
+	st.cancel()
+	m.resetBuffers()
+	m.setSilence(nil)
+	if m.hls != nil {
+		m.hls.Break()
+	}
+	if m.dash != nil {
+		m.dash.Break()
+	}
+	m.stateMu.Lock()
+	m.stream = newStream()
+	m.setState(stateIdle)
+	m.stateMu.Unlock()
+}
+
+// holdForReconnect puts a mount whose source, streaming as account, has
+// just disconnected into its grace period, reporting false if it has none.
+// Listeners stay on st until a source claims the mount or reconnect_grace
+// runs out. offset is how much of the source's audio they were sent, for
+// the source to resume from.
+func (m *mount) holdForReconnect(st *stream, account string, offset int64) bool {
+	grace := time.Duration(m.cfg.ReconnectGrace) * time.Second
+	if grace <= 0 || st.ctx.Err() != nil {
+		return false
+	}
+	m.stateMu.Lock()
+	if m.stream != st || m.loadState() != stateLive {
+		m.stateMu.Unlock()
+		return false
+	}
+	m.setState(stateGrace)
+	m.graceUntil = clock.Default.Now().Add(grace)
+	m.graceFor = account
+	m.graceOffset = offset
+	m.stateMu.Unlock()
+	log.Printf("Holding %d listeners on %s for %ds in case the source reconnects", m.listenerCount(), m.cfg.Name, m.cfg.ReconnectGrace)
+
+	t := clock.Default.NewTimer(grace)
+	go func() {
+		select {
+		case <-t.C():
+		case <-st.ctx.Done():
+			t.Stop()
+		}
+		if m.drain(st, stateGrace) {
+			log.Printf("No source reconnected to %s within %ds", m.cfg.Name, m.cfg.ReconnectGrace)
+			m.endStream(st)
+		}
+	}()
+	return true
+}
+
+// admitResume reports whether a source streaming as account may have a
+// mount it has claimed. Only a claim made in a grace period on a mount
+// with reconnect_reserve can be refused: the stream is held for the
+// account whose source dropped. Internal sources (shows, announcements)
+// pass "" and so wait for the grace period to end. A refused claim must
+// be released.
+func (m *mount) admitResume(account string) (heldFor string, ok bool) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if !m.cfg.ReconnectReserve || m.claimedFrom != stateGrace || m.loadState() != stateAuthenticating {
+		return "", true
+	}
+	return m.graceFor, account != "" && account == m.graceFor
+}
+
+// reservedFor returns the account a mount in its grace period is held for,
+// or "" if it isn't held for anyone.
+func (m *mount) reservedFor() string {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if !m.cfg.ReconnectReserve || m.loadState() != stateGrace {
+		return ""
+	}
+	return m.graceFor
+}
+
+// currentStream returns the mount's state and the stream a listener
+// connecting now would join.
+func (m *mount) currentStream() (streamState, *stream) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.loadState(), m.stream
+}
+
+// onAir reports whether listeners can join st: it is the mount's current
+// stream, and live or in its grace period.
+func (m *mount) onAir(st *stream) bool {
+	state, cur := m.currentStream()
+	return cur == st && (state == stateLive || state == stateGrace)
+}
+
+// stop cancels the current stream, ending every listener.
+func (m *mount) stop() {
+	_, st := m.currentStream()
+	st.cancel()
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"nickcast/config"
+	"nickcast/internal/clock"
+)
+
+// start is a Friday evening, when the fake clocks in these tests begin.
+var start = time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+
+// fakeClock makes clock.Default a Fake starting at start for the rest of
+// the test.
+func fakeClock(t *testing.T) *clock.Fake {
+	t.Helper()
+	f := clock.NewFake(start)
+	old := clock.Default
+	clock.Default = f
+	t.Cleanup(func() { clock.Default = old })
+	return f
+}
+
+// waitState waits for m to reach want, which a timer firing on the fake
+// clock leaves to a goroutine.
+func waitState(t *testing.T, m *mount, want streamState) {
+	t.Helper()
+	for i := 0; i < 1000 && m.loadState() != want; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if got := m.loadState(); got != want {
+		t.Fatalf("state is %s, want %s", got, want)
+	}
+}
+
+func TestReconnectGrace(t *testing.T) {
+	// Each case holds a 30s grace period, has a source claim the mount at
+	// claimAt (if set), release the claim at releaseAt (if set; otherwise
+	// it goes live) and checks the state at end.
+	tests := []struct {
+		name                    string
+		claimAt, releaseAt, end time.Duration
+		want                    streamState
+	}{
+		{"waiting", 0, 0, 29 * time.Second, stateGrace},
+		{"nobody reconnects", 0, 0, 30 * time.Second, stateIdle},
+		{"source reconnects", 10 * time.Second, 0, time.Minute, stateLive},
+		{"turned away", 10 * time.Second, 15 * time.Second, 29 * time.Second, stateGrace},
+		{"turned away, then nobody reconnects", 10 * time.Second, 15 * time.Second, 30 * time.Second, stateIdle},
+		{"turned away after the grace period", 10 * time.Second, 35 * time.Second, 35 * time.Second, stateIdle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fakeClock(t)
+			m := newMount(config.MountConfig{Name: "/live", ReconnectGrace: 30})
+			if !m.claimSource() {
+				t.Fatal("idle mount can't be claimed")
+			}
+			st, _ := m.goLive()
+			if !m.holdForReconnect(st, "dj", 0) {
+				t.Fatal("mount with reconnect_grace not held")
+			}
+			at := time.Duration(0)
+			advance := func(to time.Duration) {
+				f.Advance(to - at)
+				at = to
+			}
+			if tt.claimAt > 0 {
+				advance(tt.claimAt)
+				if !m.claimSource() {
+					t.Fatal("mount in its grace period can't be claimed")
+				}
+				if tt.releaseAt > 0 {
+					advance(tt.releaseAt)
+					m.releaseSource()
+				} else if cur, resumed := m.goLive(); cur != st || !resumed {
+					t.Fatal("reconnected source doesn't carry on the stream")
+				}
+			}
+			advance(tt.end)
+			waitState(t, m, tt.want)
+			if ended := st.ctx.Err() != nil; ended != (tt.want == stateIdle) {
+				t.Errorf("stream ended: %v", ended)
+			}
+		})
+	}
+}
+
+func TestReconnectGraceOff(t *testing.T) {
+	fakeClock(t)
+	m := newMount(config.MountConfig{Name: "/live"})
+	m.claimSource()
+	st, _ := m.goLive()
+	if m.holdForReconnect(st, "dj", 0) {
+		t.Error("mount without reconnect_grace held")
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPageSize is how many entries a list endpoint returns when the
+	// client doesn't ask for a limit.
+	defaultPageSize = 100
+	// maxPageSize caps limit, so one request can't make the server encode
+	// a whole busy station's history.
+	maxPageSize = 1000
+)
+
+// listQuery holds the paging and sorting parameters every list endpoint
+// accepts:
+//
+//	limit=N        entries per page (default 100, at most 1000)
+//	offset=N       entries to skip
+//	sort=field     sort ascending by field; sort=-field for descending
+//
+// Filters are endpoint-specific query parameters. The body stays a plain
+// JSON array; the total number of matching entries is sent as
+// X-Total-Count, and Link headers point at the next and previous pages.
+type listQuery struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Desc   bool
+}
+
+// parseListQuery reads the paging parameters, accepting only the given
+// sort fields. def is the sort used when none is requested, with the same
+// "-" convention.
+func parseListQuery(r *http.Request, def string, fields ...string) (listQuery, error) {
+	q := listQuery{Limit: defaultPageSize}
+	v := r.URL.Query()
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = n
+	}
+	if s := v.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("offset must be a non-negative number")
+		}
+		q.Offset = n
+	}
+	s := v.Get("sort")
+	if s == "" {
+		s = def
+	}
+	q.Sort, q.Desc = strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+	for _, f := range fields {
+		if f == q.Sort {
+			return q, nil
+		}
+	}
+	return q, fmt.Errorf("sort must be one of %s", strings.Join(fields, ", "))
+}
+
+// sort orders slice with less, which compares by q.Sort ascending. Ties
+// keep their existing order.
+func (q listQuery) sort(slice interface{}, less func(i, j int) bool) {
+	sort.SliceStable(slice, func(i, j int) bool {
+		if q.Desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
+
+// page announces the total and neighbouring pages of a list of n entries
+// and returns the bounds of the requested page.
+func (q listQuery) page(w http.ResponseWriter, r *http.Request, n int) (lo, hi int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(n))
+	lo, hi = q.Offset, q.Offset+q.Limit
+	if lo > n {
+		lo = n
+	}
+	if hi > n {
+		hi = n
+	}
+	var links []string
+	if hi < n {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, hi)))
+	}
+	if lo > 0 {
+		prev := lo - q.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, prev)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return lo, hi
+}
+
+// pageURL is the request's own URL with a different offset.
+func pageURL(r *http.Request, offset int) string {
+	u := url.URL{Path: r.URL.Path}
+	v := r.URL.Query()
+	v.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = v.Encode()
+	return u.String()
+}
+
+// timeRange parses the optional since and until parameters list
+// endpoints filter by, in the formats parseShowTime accepts.
+func timeRange(r *http.Request) (since, until time.Time, err error) {
+	v := r.URL.Query()
+	if s := v.Get("since"); s != "" {
+		if since, err = parseShowTime(s); err != nil {
+			return since, until, fmt.Errorf("since: %w", err)
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if until, err = parseShowTime(s); err != nil {
+			return since, until, fmt.Errorf("until: %w", err)
+		}
+	}
+	return since, until, nil
+}
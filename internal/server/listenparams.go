@@ -0,0 +1,90 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// listenOptions are the startup choices a player's URL can make, for
+// embedding developers who know better than the User-Agent what their
+// player needs:
+//
+//	?burst=<bytes>  at most this much buffered audio up front (0 for none)
+//	?intro=0        skip the mount's intro_file
+//	?meta=0|1       ICY metadata off or on, whatever Icy-MetaData says
+//
+// Each works only if the mount's listener_params allows it, and none can
+// ask for more than the mount is configured to give.
+type listenOptions struct {
+	burst   int  // -1 if not limited
+	noIntro bool // skip the intro
+	meta    int  // -1 to go by the Icy-MetaData header
+}
+
+// listenOptions reads a listener's query parameters, ignoring those the
+// mount doesn't allow. It reports false, having replied 400, if an allowed
+// one is malformed.
+func (m *mount) listenOptions(w http.ResponseWriter, r *http.Request) (listenOptions, bool) {
+	opts := listenOptions{burst: -1, meta: -1}
+	q := r.URL.Query()
+	for _, p := range m.cfg.ListenerParams {
+		v := q.Get(p)
+		if v == "" {
+			continue
+		}
+		switch p {
+		case "burst":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "burst must be a number of bytes", http.StatusBadRequest)
+				return opts, false
+			}
+			opts.burst = n
+		case "intro":
+			if v != "0" && v != "1" {
+				http.Error(w, "intro must be 0 or 1", http.StatusBadRequest)
+				return opts, false
+			}
+			opts.noIntro = v == "0"
+		case "meta":
+			if v != "0" && v != "1" {
+				http.Error(w, "meta must be 0 or 1", http.StatusBadRequest)
+				return opts, false
+			}
+			opts.meta, _ = strconv.Atoi(v)
+		}
+	}
+	return opts, true
+}
+
+// wantsMetadata reports whether the listener gets ICY metadata.
+func (o listenOptions) wantsMetadata(r *http.Request) bool {
+	if o.meta >= 0 {
+		return o.meta == 1
+	}
+	return r.Header.Get("Icy-MetaData") == "1"
+}
+
+// limitBurst cuts a burst down to what the listener asked for.
+func (o listenOptions) limitBurst(data []byte) []byte {
+	if o.burst >= 0 && len(data) > o.burst {
+		return data[len(data)-o.burst:]
+	}
+	return data
+}
+
+// loadIntro reads the mount's intro_file, if it has one. A missing file is
+// logged and the mount goes without.
+func (m *mount) loadIntro() {
+	if m.cfg.IntroFile == "" {
+		return
+	}
+	data, err := os.ReadFile(m.cfg.IntroFile)
+	if err != nil {
+		log.Printf("Mount %s: not playing intro: %v", m.cfg.Name, err)
+		return
+	}
+	m.intro = data
+}
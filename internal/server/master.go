@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// relayTokenHeader carries the relay_token a slave shows its master.
+	relayTokenHeader = "X-Relay-Token"
+
+	// masterPollInterval is how often a slave checks its master for
+	// mounts it doesn't relay yet.
+	masterPollInterval = time.Minute
+
+	// masterTimeout bounds listing the master's mounts.
+	masterTimeout = 10 * time.Second
+)
+
+// relaySlave reports whether r comes from a slave with this master's
+// relay token.
+func relaySlave(r *http.Request) bool {
+	token, want := r.Header.Get(relayTokenHeader), config.AppConfig.RelayToken
+	return token != "" && want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// relayMount is one of a master's mounts, as much of its config as a slave
+// needs to serve it the same way.
+type relayMount struct {
+	Name        string   `json:"name"`
+	SourcePath  string   `json:"source_path"`
+	ListenPath  string   `json:"listen_path"`
+	Aliases     []string `json:"aliases,omitempty"`
+	ContentType string   `json:"content_type"`
+	Bitrate     int      `json:"bitrate,omitempty"`
+	Live        bool     `json:"live"`
+}
+
+// relayMountsHandler serves /api/relay/mounts, the mounts a master offers
+// its slaves, to requests carrying its relay_token.
+func relayMountsHandler(w http.ResponseWriter, r *http.Request) {
+	if config.AppConfig.RelayToken == "" {
+		http.Error(w, "This server has no relay_token, so it is not a master", http.StatusNotFound)
+		return
+	}
+	if !relaySlave(r) {
+		logf(r, "Relay mount list refused to %s: wrong or missing relay token", r.RemoteAddr)
+		http.Error(w, "Unauthorized - relay token required", http.StatusUnauthorized)
+		return
+	}
+	out := []relayMount{}
+	for _, m := range mounts() {
+		out = append(out, relayMount{
+			Name:        m.cfg.Name,
+			SourcePath:  m.cfg.SourcePath,
+			ListenPath:  m.cfg.ListenPath,
+			Aliases:     m.cfg.Aliases,
+			ContentType: m.cfg.ContentType,
+			Bitrate:     m.cfg.Bitrate,
+			Live:        m.active(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// fetchMasterMounts lists the master's mounts.
+func fetchMasterMounts(ctx context.Context) ([]relayMount, error) {
+	ctx, cancel := context.WithTimeout(ctx, masterTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.AppConfig.Master+"/api/relay/mounts", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(relayTokenHeader, config.AppConfig.RelayToken)
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("master answered %s", resp.Status)
+	}
+	var list []relayMount
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("reading the master's mounts: %w", err)
+	}
+	return list, nil
+}
+
+// discoverMaster sets a slave up to relay its master: every mount the
+// master has that isn't configured here is added, with the master's paths
+// and format and this server's mount defaults, and every mount the master
+// has is relayed from it unless it has a relay of its own. It runs once,
+// before the mounts are created; watchMaster adds the master's later
+// mounts. If the master can't be reached, the mounts configured here are
+// relayed from it as they are.
+func discoverMaster() {
+	cfg := &config.AppConfig
+	list, err := fetchMasterMounts(context.Background())
+	if err != nil {
+		log.Printf("Could not list the mounts of master %s: %v; relaying the mounts configured here", cfg.Master, err)
+		for i := range cfg.Mounts {
+			if cfg.Mounts[i].Relay == "" {
+				cfg.Mounts[i].Relay = cfg.Master + cfg.Mounts[i].ListenPath
+			}
+		}
+		return
+	}
+
+	paths := servedPaths(cfg.Mounts)
+	for _, rm := range list {
+		if cfg.Mount(rm.Name) != nil {
+			continue
+		}
+		if clash := pathClash(rm, paths); clash != "" {
+			log.Printf("Not relaying master mount %s: %s", rm.Name, clash)
+			continue
+		}
+		mc := masterMountConfig(rm)
+		for p, what := range servedPaths([]config.MountConfig{mc}) {
+			paths[p] = what
+		}
+		cfg.Mounts = append(cfg.Mounts, mc)
+	}
+	for _, rm := range list {
+		if mc := cfg.Mount(rm.Name); mc != nil && mc.Relay == "" {
+			mc.Relay = cfg.Master + rm.ListenPath
+		}
+	}
+	log.Printf("Relaying %d mounts from master %s", len(list), cfg.Master)
+}
+
+// masterMountConfig is the mount a slave sets up for the master's mount
+// rm: the master's paths and format, relayed from the master, with this
+// server's mount defaults for everything else.
+func masterMountConfig(rm relayMount) config.MountConfig {
+	mc := config.AppConfig.MountDefaults
+	mc.Name = rm.Name
+	mc.Station = config.DefaultStationName
+	mc.SourcePath, mc.ListenPath, mc.Aliases = rm.SourcePath, rm.ListenPath, rm.Aliases
+	mc.ContentType = rm.ContentType
+	mc.Bitrate = rm.Bitrate
+	mc.Variants = nil
+	mc.Relay = config.AppConfig.Master + rm.ListenPath
+	return mc
+}
+
+// servedPaths maps the paths the given mounts, and the config's moves,
+// take here to what takes them.
+func servedPaths(mcs []config.MountConfig) map[string]string {
+	paths := make(map[string]string)
+	for _, mv := range config.AppConfig.Moves {
+		paths[mv.From] = "a move"
+	}
+	for _, mc := range mcs {
+		for _, p := range append([]string{mc.SourcePath, mc.SourcePath + wsSourcePath, mc.ListenPath}, mc.Aliases...) {
+			paths[p] = "mount " + mc.Name
+		}
+	}
+	return paths
+}
+
+// pathClash says why the master's mount rm can't be served here, with
+// paths as servedPaths gives them, or returns "" if it can.
+func pathClash(rm relayMount, paths map[string]string) string {
+	for _, p := range append([]string{rm.SourcePath, rm.SourcePath + wsSourcePath, rm.ListenPath}, rm.Aliases...) {
+		if what, ok := paths[p]; ok {
+			return fmt.Sprintf("path %s is used by %s here", p, what)
+		}
+		if config.ReservedPath(p) {
+			return fmt.Sprintf("path %s is one of NickCast's own", p)
+		}
+		for _, px := range config.AppConfig.Proxies {
+			if strings.HasPrefix(p, px.Path) || p+"/" == px.Path {
+				return fmt.Sprintf("path %s is under proxy %s", p, px.Name)
+			}
+		}
+	}
+	return ""
+}
+
+// watchMaster relays mounts added to the master since startup, set up as
+// discoverMaster would have and served on mux, until ctx is cancelled.
+// Mounts the master drops stay here, their relay retrying. The loops
+// that serve rtp_output, hls_push_url and transcribe only take in the
+// mounts there are at startup, so those wait for a restart.
+func watchMaster(ctx context.Context, mux *http.ServeMux) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	refused := make(map[string]bool)
+	t := clock.Default.NewTicker(masterPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		list, err := fetchMasterMounts(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Could not list the mounts of master %s: %v", config.AppConfig.Master, err)
+			}
+			continue
+		}
+		for _, rm := range list {
+			if mounts()[rm.Name] != nil || refused[rm.Name] {
+				continue
+			}
+			m, err := addMasterMount(mux, rm)
+			if err != nil {
+				log.Printf("Not relaying master mount %s: %v", rm.Name, err)
+				refused[rm.Name] = true
+				continue
+			}
+			log.Printf("Master %s has a new mount %s; relaying it", config.AppConfig.Master, rm.Name)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.runRelay(ctx)
+			}()
+		}
+	}
+}
+
+// addMasterMount sets up the master's mount rm here while the server is
+// running, and serves it on mux.
+func addMasterMount(mux *http.ServeMux, rm relayMount) (*mount, error) {
+	var cfgs []config.MountConfig
+	for _, m := range mounts() {
+		cfgs = append(cfgs, m.cfg)
+	}
+	if clash := pathClash(rm, servedPaths(cfgs)); clash != "" {
+		return nil, errors.New(clash)
+	}
+	m := newMount(masterMountConfig(rm))
+	m.station = stations[config.DefaultStationName]
+	if err := m.startPackagers(); err != nil {
+		bufferedBytes.Add(-int64(m.bufferSize + m.cfg.Timeshift))
+		return nil, err
+	}
+	m.route(mux)
+	addMount(m)
+	return m, nil
+}
+
+// fromMaster reports whether url is on this slave's master, so requests
+// to it may carry the relay token.
+func fromMaster(url string) bool {
+	master := config.AppConfig.Master
+	return master != "" && strings.HasPrefix(url, master+"/")
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/metrics"
+	"sync/atomic"
+)
+
+// memoryLagLimit is how far behind a listener may fall while the server is
+// over its memory budget before broadcasts skip it.
+const memoryLagLimit = 64 * 1024
+
+// bufferedBytes is the audio nickcast holds in memory: every mount's burst
+// and timeshift buffers, plus what is queued for each listener and not yet
+// written to them. Listener queues share chunks with each other, so this
+// overstates the real figure, which is the safe direction for a budget.
+var bufferedBytes atomic.Int64
+
+var (
+	bufferedGauge = metrics.NewGaugeFunc("nickcast_buffered_bytes", "Audio held in memory for mount buffers and listener queues.", func() float64 {
+		return float64(bufferedBytes.Load())
+	})
+	memoryRejections = metrics.NewCounter("nickcast_memory_rejections_total", "Listeners turned away because the server was over memory_budget.")
+	memoryDrops      = metrics.NewCounter("nickcast_memory_dropped_chunks_total", "Audio chunks withheld from lagging listeners to stay within memory_budget.")
+)
+
+// queue accounts for n bytes handed to the listener.
+func (l *listener) queue(n int) {
+	l.queued.Add(int64(n))
+	bufferedBytes.Add(int64(n))
+}
+
+// dequeue accounts for n bytes written out to the listener.
+func (l *listener) dequeue(n int) {
+	l.queued.Add(-int64(n))
+	bufferedBytes.Add(-int64(n))
+}
+
+// drop accounts for whatever is still queued when the listener leaves. It
+// must be called once nothing can be queued for the listener any more.
+func (l *listener) drop() {
+	l.dequeue(int(l.queued.Load()))
+}
+
+// memoryUse reports how full the memory budget is, as a fraction; 0 when
+// there is no budget.
+func memoryUse() float64 {
+	budget := config.AppConfig.MemoryBudget
+	if budget <= 0 {
+		return 0
+	}
+	return float64(bufferedBytes.Load()) / float64(budget)
+}
+
+// memoryTight reports whether the server is within a quarter of its memory
+// budget. New listeners then get a smaller burst.
+func memoryTight() bool {
+	return memoryUse() >= 0.75
+}
+
+// lagging reports whether broadcasts should skip l to save memory: the
+// server is tight on memory and l is already well behind.
+func (l *listener) lagging() bool {
+	return l.queued.Load() > memoryLagLimit && memoryTight()
+}
+
+// admitMemory turns listeners away with a 503 while the server is at its
+// memory budget.
+func (m *mount) admitMemory(w http.ResponseWriter, r *http.Request) bool {
+	if memoryUse() < 1 {
+		return true
+	}
+	memoryRejections.Inc()
+	logf(r, "Listener from %s rejected: server is at its memory budget (%d bytes buffered)", r.RemoteAddr, bufferedBytes.Load())
+	m.unavailable(w, r, "listen.busy")
+	return false
+}
+
+// checkMemoryBudget makes sure the mounts' own buffers leave room for
+// listeners within memory_budget.
+func checkMemoryBudget() error {
+	budget := config.AppConfig.MemoryBudget
+	if budget <= 0 {
+		return nil
+	}
+	fixed := bufferedBytes.Load()
+	if fixed >= int64(budget) {
+		return fmt.Errorf("memory_budget of %d bytes is taken up by mount burst and timeshift buffers (%d bytes)", budget, fixed)
+	}
+	log.Printf("Memory budget %d bytes, %d of it for mount buffers", budget, fixed)
+	return nil
+}
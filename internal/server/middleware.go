@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/internal/crash"
+	"nickcast/internal/metrics"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	handlerPanics = metrics.NewCounter("nickcast_http_panics_total", "HTTP handler panics recovered by the server.")
+	sourcePanics  = metrics.NewCounterVec("nickcast_source_panics_total", "Panics recovered in source connections that don't come over HTTP, by protocol.", "protocol")
+)
+
+// reportPanic logs a panic recovered while doing what, with its stack
+// trace, under the connection ID id, counts it on c and sends a crash
+// report.
+func reportPanic(c *metrics.Counter, id, what string, rec interface{}) {
+	c.Inc()
+	stack := debug.Stack()
+	log.Printf("[%s] Panic %s: %v\n%s", id, what, rec, stack)
+	go crash.Report(fmt.Sprintf("panic %s: %v", what, rec), stack)
+}
+
+// recoverSource is deferred by the goroutine running each source
+// connection that doesn't come over HTTP, where recoverMiddleware can't
+// catch a panic: it reports the panic the same way, and ends only that
+// connection rather than the process.
+func recoverSource(protocol, id, remote string) {
+	if rec := recover(); rec != nil {
+		reportPanic(sourcePanics.With(protocol), id, "running "+protocol+" source from "+remote, rec)
+	}
+}
+
+// statusWriter remembers whether a response has been started so the recovery
+// middleware knows if it can still send a 500. It passes Flush through because
+// the listener loop relies on it to push audio out immediately.
+type statusWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom passes copies through to the underlying writer, so that copies
+// from a file can still use sendfile.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader = true
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recoverMiddleware turns a panic in a single handler into a logged stack
+// trace and a 500, rather than letting it escape and kill the process (and
+// with it the live broadcast for everyone else).
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer track(subsysRequests)()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// Deliberate abort; let net/http drop the connection quietly.
+				panic(rec)
+			}
+			reportPanic(handlerPanics, requestID(r), fmt.Sprintf("serving %s %s for %s", r.Method, r.URL.Path, r.RemoteAddr), rec)
+			if !sw.wroteHeader {
+				http.Error(sw, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+type requestIDKey struct{}
+
+// requestIDMiddleware tags every request with an ID, reusing a sane
+// X-Request-ID from a fronting proxy if there is one. The ID is echoed back
+// in the response and prefixed to every log line about the connection.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing is exceptional; fall back to something unique enough.
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID assigned to r by requestIDMiddleware.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs a line prefixed with the request's ID so all lines about one
+// connection can be grepped together.
+func logf(r *http.Request, format string, args ...interface{}) {
+	log.Printf("[%s] "+format, append([]interface{}{requestID(r)}, args...)...)
+}
@@ -0,0 +1,503 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/dash"
+	"nickcast/internal/events"
+	"nickcast/internal/hls"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mount is one independent stream: a single source feeding any number of
+// listeners. All of the state that used to be package-global lives here.
+type mount struct {
+	cfg     config.MountConfig
+	station *station
+
+	listeners   map[chan []byte]*listener // listener channel -> who is on it
+	listenersMu sync.Mutex
+
+	// stateMu guards transitions of state and which stream is current;
+	// state itself can be read without it. See streamState.
+	stateMu     sync.Mutex
+	state       atomic.Int32
+	stream      *stream
+	claimedFrom streamState // the state claimSource (or takeOver) found the mount in
+	claim       *admission  // the source that claimed the mount, until startSession or releaseSource
+	priority    int         // of the source holding the claim; see takeOver
+	graceUntil  time.Time   // when a grace period in progress runs out
+	graceFor    string      // account whose source dropped, at the last grace period
+	graceOffset int64       // how far into its audio that source got; see resumeOffset
+
+	lastData  atomic.Int64 // UnixNano of the last chunk from the source
+	deadAir   atomic.Bool  // the source has gone quiet past dead_air_timeout
+	filling   atomic.Bool  // a gap in the source is being filled with silence
+	filledGap atomic.Int64 // lastData of the last gap filled, so it isn't filled twice
+	bytesSent atomic.Int64 // listener traffic since startup, for /admin/stats
+
+	// ringBuffer stores the most recent audio data for new listeners.
+	ringBuffer   *bytes.Buffer
+	ringBufferMu sync.Mutex
+	bufferSize   int   // capacity of ringBuffer; see bufferSize()
+	written      int64 // bytes broadcast this session; the stream offset ringBuffer ends at
+
+	// intro is the mount's intro_file, played to each new listener; nil if
+	// it has none.
+	intro []byte
+
+	// offlineRate is the byte rate offline_file plays at; 0 if the mount
+	// has no offline file.
+	offlineRate float64
+	// offlineListeners counts listeners hearing the offline file, who
+	// count towards max_listeners though they aren't registered yet.
+	offlineListeners atomic.Int64
+
+	// rtpOut sends the broadcast to the mount's rtp_output address; nil
+	// when it has none.
+	rtpOut *rtpOutput
+
+	// hls cuts the broadcast into HLS segments; nil unless the mount has
+	// hls set.
+	hls *hls.Packager
+
+	// dash cuts the broadcast into MPEG-DASH segments; nil unless the
+	// mount has dash set.
+	dash *dash.Packager
+
+	// captions collects the broadcast for transcription and hands out the
+	// captions made of it; nil unless the mount has transcribe set.
+	captions *captionFeed
+
+	// history holds the last timeshift bytes of audio for clips; nil when
+	// the mount has no timeshift buffer.
+	history *history
+
+	sourceUser string              // NickServ account of the active streamer
+	title      string              // current ICY StreamTitle
+	format     string              // content type sniffed from the source, when not content_type
+	kickFn     func(reason string) // disconnects the active streamer
+	recorder   *recorder           // non-nil while a session is being recorded
+	deadAirEnd func()              // stops dead-air injection and reports the outage
+	session    *sourceSession      // the active source, nil when there is none
+	silence    []byte              // a silent frame in the stream's format, for silence_fill
+	downmix    *downmixFeed        // non-nil while a mono copy is being made
+	infoMu     sync.Mutex
+
+	capture   *capture // running debug capture, if any
+	captureMu sync.Mutex
+
+	tap atomic.Pointer[ingestTap] // the tap socket consumer, if any
+}
+
+var (
+	// mountTable holds every mount, keyed by name. The map is replaced
+	// whole by addMount, never changed in place, so one loaded by mounts
+	// can be read without a lock while a slave adds its master's new
+	// mounts.
+	mountTable atomic.Pointer[map[string]*mount]
+	mountsMu   sync.Mutex // serializes addMount
+)
+
+// mounts returns every mount, keyed by name. The map must not be changed.
+func mounts() map[string]*mount {
+	if p := mountTable.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// addMount puts m among the mounts.
+func addMount(m *mount) {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	old := mounts()
+	next := make(map[string]*mount, len(old)+1)
+	for name, o := range old {
+		next[name] = o
+	}
+	next[m.cfg.Name] = m
+	mountTable.Store(&next)
+}
+
+func newMount(cfg config.MountConfig) *mount {
+	m := &mount{
+		cfg:       cfg,
+		listeners: make(map[chan []byte]*listener),
+	}
+	m.bufferSize = bufferSize(cfg)
+	if cfg.Timeshift > 0 {
+		m.history = newHistory(cfg.Timeshift)
+	}
+	bufferedBytes.Add(int64(m.bufferSize + cfg.Timeshift))
+	m.stream = newStream()
+	m.resetBuffers()
+	m.loadIntro()
+	m.loadOffline()
+	return m
+}
+
+// resetBuffers empties the burst and timeshift buffers for a new stream
+// session.
+func (m *mount) resetBuffers() {
+	m.ringBufferMu.Lock()
+	m.ringBuffer = bytes.NewBuffer(make([]byte, 0, m.bufferSize)) // Initialize with capacity
+	m.written = 0
+	m.ringBufferMu.Unlock()
+	if m.history != nil {
+		m.history.reset()
+	}
+}
+
+// setSource records (or, with "", clears) the active streamer's account.
+// Clearing it also clears the title and format left over from that session.
+func (m *mount) setSource(user string) {
+	m.infoMu.Lock()
+	m.sourceUser = user
+	if user == "" {
+		m.title = ""
+		m.format = ""
+	}
+	m.infoMu.Unlock()
+}
+
+func (m *mount) source() string {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.sourceUser
+}
+
+func (m *mount) setTitle(title string) {
+	m.infoMu.Lock()
+	m.title = title
+	rec := m.recorder
+	m.infoMu.Unlock()
+	if rec != nil {
+		rec.addTrack(title)
+	}
+}
+
+// playTitle sets a new track title and publishes it, which is how
+// now-playing services hear about it. Announcements only use setTitle, as
+// they don't belong in a playlist.
+func (m *mount) playTitle(id, account, title string) {
+	m.setTitle(title)
+	if s := m.currentSession(); s != nil {
+		if r := s.replicator(); r != nil {
+			r.sendTitle(title)
+		}
+	}
+	events.Publish(events.Event{Type: events.MetadataUpdate, SessionID: id, Account: account, Data: map[string]string{"mount": m.cfg.Name, "station": m.station.cfg.Name, "title": title}})
+}
+
+func (m *mount) setRecorder(rec *recorder) {
+	m.infoMu.Lock()
+	m.recorder = rec
+	m.infoMu.Unlock()
+}
+
+func (m *mount) currentRecorder() *recorder {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.recorder
+}
+
+func (m *mount) currentTitle() string {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.title
+}
+
+// setFormat records the content type sniffed from a new source, or "" when
+// it is content_type's.
+func (m *mount) setFormat(format string) {
+	m.infoMu.Lock()
+	m.format = format
+	m.infoMu.Unlock()
+}
+
+// contentType is what the mount is sending: the format sniffed from its
+// source if that differs from content_type, which encoders left at the
+// default often do, or else content_type.
+func (m *mount) contentType() string {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	if m.format != "" {
+		return m.format
+	}
+	return m.cfg.ContentType
+}
+
+// fileName is the mount's name made safe for file names and archive IDs;
+// station mounts are named "<station>/<mount>".
+func (m *mount) fileName() string {
+	return strings.ReplaceAll(m.cfg.Name, "/", "-")
+}
+
+// matches reports whether ref names this mount, either by name or by any of
+// the paths it is served on. Icecast tools usually identify mounts by path.
+func (m *mount) matches(ref string) bool {
+	if ref == m.cfg.Name || ref == m.cfg.SourcePath || ref == m.cfg.ListenPath {
+		return true
+	}
+	for _, alias := range m.cfg.Aliases {
+		if ref == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// findMount looks up a mount by name or path.
+func findMount(ref string) *mount {
+	if m, ok := mounts()[ref]; ok {
+		return m
+	}
+	for _, m := range mounts() {
+		if m.matches(ref) {
+			return m
+		}
+	}
+	return nil
+}
+
+// requestMount looks up the mount a request names with ?mount= or the
+// like. On a station's own domain only that station's mounts are
+// candidates, named as there: /stream is its default mount, not the
+// default station's.
+func requestMount(r *http.Request, ref string) *mount {
+	st := hostStation(r.Host)
+	if st == config.DefaultStationName {
+		return findMount(ref)
+	}
+	prefix := "/" + st
+	for _, m := range mounts() {
+		if m.cfg.Station != st {
+			continue
+		}
+		if m.matches(ref) || m.matches(prefix+ref) || ref == strings.TrimPrefix(m.cfg.Name, st+"/") {
+			return m
+		}
+	}
+	return nil
+}
+
+func (m *mount) setSession(s *sourceSession) {
+	m.infoMu.Lock()
+	m.session = s
+	m.infoMu.Unlock()
+}
+
+// currentSession returns the active source session, or nil.
+func (m *mount) currentSession() *sourceSession {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.session
+}
+
+// setKick installs (or, with nil, removes) the function that disconnects the
+// active streamer.
+func (m *mount) setKick(fn func(reason string)) {
+	m.infoMu.Lock()
+	m.kickFn = fn
+	m.infoMu.Unlock()
+}
+
+// kickSource disconnects the active streamer, reporting false if there was
+// none. As when it drops, the mount is held for it for reconnect_grace;
+// see evictSource.
+func (m *mount) kickSource(reason string) bool {
+	m.infoMu.Lock()
+	fn := m.kickFn
+	m.infoMu.Unlock()
+	if fn == nil {
+		return false
+	}
+	fn(reason)
+	return true
+}
+
+// evictSource disconnects the active streamer as kickSource does, but for
+// the server's own reasons, so the mount isn't held for it to reconnect:
+// reconnect_grace is for sources that dropped.
+func (m *mount) evictSource(reason string) bool {
+	if s := m.currentSession(); s != nil {
+		s.evicted.Store(true)
+	}
+	return m.kickSource(reason)
+}
+
+func (m *mount) broadcast(data []byte) {
+	// Write to ring buffer
+	m.ringBufferMu.Lock()
+	off := m.written
+	m.written += int64(len(data))
+	max := m.bufferSize
+	if m.ringBuffer.Len()+len(data) > max {
+		// If adding new data exceeds buffer size, make room by dropping oldest data.
+		// A simple way is to reset the buffer and only keep the tail.
+		// For a true ring buffer, you'd manage an offset. For simplicity, we'll
+		// keep it simple here by trimming.
+		temp := make([]byte, 0, max)
+		// Copy only the part that fits and is newest
+		copyLen := max - len(data)
+		if copyLen < 0 { // If new data is larger than whole buffer
+			copyLen = 0
+		}
+		if m.ringBuffer.Len() > copyLen {
+			temp = append(temp, m.ringBuffer.Bytes()[m.ringBuffer.Len()-copyLen:]...)
+		} else {
+			temp = append(temp, m.ringBuffer.Bytes()...)
+		}
+		m.ringBuffer.Reset()
+		m.ringBuffer.Write(temp)
+	}
+	if len(data) > max {
+		m.ringBuffer.Write(data[len(data)-max:])
+	} else {
+		m.ringBuffer.Write(data)
+	}
+	// The timeshift buffer is written under the same lock so that it, too,
+	// ends at m.written for catchUp.
+	if m.history != nil {
+		m.history.write(data)
+	}
+	m.ringBufferMu.Unlock()
+
+	rec := m.currentRecorder()
+	if rec != nil {
+		rec.write(data)
+	}
+	if f := m.currentDownmix(); f != nil {
+		f.write(data)
+	}
+	if m.rtpOut != nil {
+		m.rtpOut.write(data)
+	}
+	if m.hls != nil {
+		m.hls.Write(data)
+	}
+	if m.dash != nil {
+		m.dash.Write(data)
+	}
+	if m.captions != nil {
+		m.captions.write(data, rec)
+	}
+
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	for ch, l := range m.listeners {
+		if l.resync.pending.Load() {
+			continue // it will catch up from the ring buffer
+		}
+		chunk, at := data, off
+		if skip := l.resync.skipTo - off; skip > 0 {
+			// Part or all of this chunk went out with a catch-up.
+			if skip >= int64(len(chunk)) {
+				continue
+			}
+			chunk, at = chunk[skip:], off+skip
+		}
+		if l.lagging() {
+			memoryDrops.Inc()
+			m.fallBehind(l, at)
+			continue
+		}
+		select {
+		case ch <- chunk:
+			l.queue(len(chunk))
+		default:
+			// The listener is slow, or has disconnected but its goroutine
+			// hasn't fully exited yet.
+			m.fallBehind(l, at)
+		}
+	}
+}
+
+// recent returns up to n of the most recent bytes on air, from the timeshift
+// buffer if the mount has one and the burst buffer otherwise.
+func (m *mount) recent(n int) []byte {
+	if m.history != nil {
+		return m.history.last(n)
+	}
+	data := m.burst()
+	if n < len(data) {
+		data = data[len(data)-n:]
+	}
+	return data
+}
+
+// burst returns a copy of the buffered recent audio for a new listener.
+func (m *mount) burst() []byte {
+	m.ringBufferMu.Lock()
+	defer m.ringBufferMu.Unlock()
+	return append([]byte(nil), m.ringBuffer.Bytes()...)
+}
+
+// registerListener adds ch to the mount's stream st. It fails with
+// errStreamEnded once listeners can no longer join st, and with errMountFull when the
+// mount is at its max_listeners limit.
+func (m *mount) registerListener(ch chan []byte, l *listener, st *stream) error {
+	m.stateMu.Lock()
+	if state := m.loadState(); m.stream != st || (state != stateLive && state != stateGrace) {
+		m.stateMu.Unlock()
+		return errStreamEnded
+	}
+	// Take listenersMu before letting go of stateMu: a drain that starts
+	// now only clears listeners after ch is in, so it closes ch as well.
+	m.listenersMu.Lock()
+	m.stateMu.Unlock()
+	if m.cfg.MaxListeners > 0 && len(m.listeners) >= m.cfg.MaxListeners {
+		m.listenersMu.Unlock()
+		return errMountFull
+	}
+	m.listeners[ch] = l
+	total := len(m.listeners)
+	m.listenersMu.Unlock()
+	if rec := m.currentRecorder(); rec != nil {
+		rec.noteListeners(total)
+	}
+	if s := m.currentSession(); s != nil {
+		s.noteListeners(total)
+	}
+	log.Printf("[%s] Registered new listener on %s. Total listeners: %d", l.ID, m.cfg.Name, total)
+	return nil
+}
+
+func (m *mount) unregisterListener(ch chan []byte) {
+	m.listenersMu.Lock()
+	var id string
+	if l := m.listeners[ch]; l != nil {
+		id = l.ID
+	}
+	delete(m.listeners, ch)
+	// Do NOT close(ch) here. It's either closed by clearListeners (streamer disconnects)
+	// or will be garbage collected when the listener goroutine exits and no
+	// other references to 'ch' remain. Closing here leads to "close of closed channel" panics.
+	total := len(m.listeners)
+	m.listenersMu.Unlock()
+	log.Printf("[%s] Unregistered listener on %s. Total listeners: %d", id, m.cfg.Name, total)
+}
+
+// listenerCount returns the number of connected listeners.
+func (m *mount) listenerCount() int {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	return len(m.listeners)
+}
+
+// clearListeners closes all active listener channels.
+func (m *mount) clearListeners() {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	for ch := range m.listeners {
+		close(ch)               // Close the channel to signal end of stream
+		delete(m.listeners, ch) // Remove from map
+	}
+	log.Printf("All listener channels on %s cleared due to streamer disconnection.", m.cfg.Name)
+}
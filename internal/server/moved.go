@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"nickcast/config"
+	"nickcast/internal/metrics"
+)
+
+var movedRequests = metrics.NewCounterVec("nickcast_moved_requests_total", "Requests for moved or retired paths, by path.", "path")
+
+// movedHandler answers requests for a path that is no longer served.
+// Players are sent on to its replacement with a permanent redirect, which
+// they follow and most remember, keeping their query (burst=0, meta=1 and
+// the like); without a replacement they are told the stream has been
+// retired rather than getting a bare 404. Encoders don't follow redirects,
+// so a source connecting to an old path is told where to go instead.
+func movedHandler(mv config.Move) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		movedRequests.With(mv.From).Inc()
+		if sourceMethod(r.Method) {
+			msg := "This mount has been retired"
+			if m := localMount(mv.To); m != nil {
+				msg = "This mount has moved; stream to " + m.cfg.SourcePath
+			} else if mv.To != "" {
+				msg = "This mount has moved to " + mv.To
+			}
+			logf(r, "Source from %s turned away from %s: %s", r.RemoteAddr, mv.From, msg)
+			http.Error(w, msg, http.StatusGone)
+			return
+		}
+		if mv.To == "" {
+			requestError(w, r, http.StatusGone, "listen.retired")
+			return
+		}
+
+		target, err := url.Parse(mv.To)
+		if err != nil {
+			// Checked when the config was loaded.
+			requestError(w, r, http.StatusGone, "listen.retired")
+			return
+		}
+		q := r.URL.Query()
+		for k, vals := range target.Query() {
+			q[k] = vals
+		}
+		target.RawQuery = q.Encode()
+		logf(r, "Listener from %s on %s sent on to %s", r.RemoteAddr, mv.From, target)
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	}
+}
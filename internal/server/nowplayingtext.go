@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// nowPlayingRate and nowPlayingBurst limit each network's requests to
+	// /nowplaying.txt: overlays poll every second or two, so this leaves
+	// room for a few on one network while stopping a runaway script.
+	nowPlayingRate  = 2 // per second
+	nowPlayingBurst = 20
+
+	// nowPlayingMaxAge is how long browsers and CDNs may cache the title.
+	nowPlayingMaxAge = 5
+)
+
+var nowPlayingLimited = metrics.NewCounter("nickcast_nowplaying_limited_total", "Requests for /nowplaying.txt refused for coming too fast.")
+
+// requestLimiter is a token bucket per client network.
+type requestLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*requestBucket
+}
+
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var nowPlayingLimiter = &requestLimiter{buckets: make(map[string]*requestBucket)}
+
+// allow takes a token from key's bucket, reporting whether there was one.
+func (l *requestLimiter) allow(key string, rate, burst float64) bool {
+	at := clock.Default.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		b = &requestBucket{tokens: burst}
+		l.buckets[key] = b
+	} else {
+		b.tokens += at.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = at
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// janitor forgets networks whose buckets have been full for a while.
+func (l *requestLimiter) janitor(ctx context.Context) error {
+	t := clock.Default.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		cutoff := clock.Default.Now().Add(-time.Minute)
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if b.last.Before(cutoff) {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// nowPlayingTextHandler serves /nowplaying.txt: the title on air and
+// nothing else, for OBS text sources and overlays that can only fetch
+// plain text. It is empty while nothing is titled. ?mount= picks the
+// mount; otherwise it is the default mount of the host's station.
+func nowPlayingTextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !nowPlayingLimiter.allow(clientPrefix(r), nowPlayingRate, nowPlayingBurst) {
+		nowPlayingLimited.Inc()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	var m *mount
+	if ref := r.URL.Query().Get("mount"); ref != "" {
+		m = requestMount(r, ref)
+	} else if list := stationMounts(hostStation(r.Host), nil); len(list) > 0 {
+		m = list[0]
+	}
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+
+	title := ""
+	if m.active() {
+		title = m.currentTitle()
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(nowPlayingMaxAge))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(h.Sum64(), 36)))
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(title))
+}
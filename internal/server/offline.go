@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"nickcast/internal/clock"
+	"os"
+)
+
+// loadOffline works out the byte rate to play the mount's offline_file at,
+// if it has one. A file that can't be played is logged and the mount goes
+// without.
+func (m *mount) loadOffline() {
+	if m.cfg.OfflineFile == "" {
+		return
+	}
+	rate, err := fileRate(m, m.cfg.OfflineFile)
+	if err != nil || rate <= 0 {
+		log.Printf("Mount %s: not serving offline file: %v", m.cfg.Name, err)
+		return
+	}
+	m.offlineRate = rate
+}
+
+// playOffline loops the mount's offline_file to a listener who arrived
+// while nobody was streaming, so embedded players have something to play
+// rather than an error. It reports true once st starts and the listener
+// should move on to the live stream, and false if the listener left or st
+// ended first.
+//
+// The file goes out without chunked encoding or ICY metadata, straight
+// from the file to the connection, which lets net/http use sendfile: a
+// downtime can draw a lot of listeners at once.
+func (m *mount) playOffline(w http.ResponseWriter, r *http.Request, st *stream) bool {
+	f, err := os.Open(m.cfg.OfflineFile)
+	if err != nil {
+		logf(r, "Cannot play offline file to %s: %v", r.RemoteAddr, err)
+		return true // Fall back to waiting silently
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", m.cfg.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tells net/http not to chunk the response; it closes the connection
+	// at the end instead, which an endless stream never reaches anyway.
+	w.Header().Set("Transfer-Encoding", "identity")
+	logf(r, "Playing offline file to %s until %s goes live", r.RemoteAddr, m.cfg.Name)
+
+	// Start with a burst's worth, like the live stream, so players begin
+	// at once.
+	lead := int64(m.cfg.BurstSize)
+	start := clock.Default.Now()
+	var sent int64
+	t := clock.Default.NewTicker(pumpTick)
+	defer t.Stop()
+	for {
+		due := lead + int64(clock.Default.Since(start).Seconds()*m.offlineRate) - sent
+		for due > 0 {
+			n, err := io.CopyN(w, f, due)
+			sent += n
+			due -= n
+			if err == io.EOF {
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return false
+				}
+				continue
+			}
+			if err != nil {
+				return false
+			}
+		}
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+		select {
+		case <-st.firstData:
+			logf(r, "%s is live; moving %s from the offline file to the stream", m.cfg.Name, r.RemoteAddr)
+			return true
+		case <-st.ctx.Done():
+			return false
+		case <-r.Context().Done():
+			logf(r, "Listener from %s disconnected during the offline file.", r.RemoteAddr)
+			return false
+		case <-t.C():
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes every endpoint; keep it in step with the handlers
+// and with pkg/client.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves /api/openapi.json.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"nickcast/internal/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// storeFlushInterval is how often changed state is written to the store;
+// changes in between go out together.
+const storeFlushInterval = time.Second
+
+// The state kept in the store, by key. Resume offsets and session tokens
+// aren't among it: they belong to live connections, which don't survive a
+// restart. Quotas are measured afresh from traffic and record_dir.
+const (
+	storeBans     = "bans"
+	storeBookings = "bookings"
+	storeSlots    = "slots"
+)
+
+var storeKeys = []string{storeBans, storeBookings, storeSlots}
+
+var storeErrors = metrics.NewCounterVec("nickcast_store_errors_total", "Failed reads and writes of the state store, by operation.", "op")
+
+// stateStore is where runtime state outlives the process; nil without a
+// store setting.
+var stateStore store.Store
+
+var storeDirty struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// persist marks the state under key as changed, for runStore to write out.
+func persist(key string) {
+	if stateStore == nil {
+		return
+	}
+	storeDirty.mu.Lock()
+	if storeDirty.keys == nil {
+		storeDirty.keys = make(map[string]bool)
+	}
+	storeDirty.keys[key] = true
+	storeDirty.mu.Unlock()
+}
+
+// takeDirty returns the keys changed since it was last called.
+func takeDirty() map[string]bool {
+	storeDirty.mu.Lock()
+	defer storeDirty.mu.Unlock()
+	keys := storeDirty.keys
+	storeDirty.keys = nil
+	return keys
+}
+
+func isDirty(key string) bool {
+	storeDirty.mu.Lock()
+	defer storeDirty.mu.Unlock()
+	return storeDirty.keys[key]
+}
+
+// openStore opens the store setting and restores what it holds.
+func openStore(spec string) error {
+	st, err := store.Open(spec)
+	if err != nil {
+		return err
+	}
+	stateStore = st
+	for _, key := range storeKeys {
+		if err := loadStored(key); err != nil {
+			return err
+		}
+	}
+	log.Printf("Runtime state kept in %s: %d bans, %d bookings, %d slots restored", redactURL(spec), len(runtimeBans()), len(bookingList()), len(slotList()))
+	return nil
+}
+
+// redactURL hides the password in a URL, such as a Redis store's or a
+// relay's upstream, from the logs.
+func redactURL(spec string) string {
+	if u, err := url.Parse(spec); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return spec
+}
+
+// stored is what the store held of the state under each key when it was
+// last read or written here, by entry, so saveStored writes only the
+// entries changed here and leaves those other servers changed meanwhile.
+// Only openStore and then runStore use it.
+var stored = make(map[string]map[string]string)
+
+// loadStored replaces the state under key with the store's copy.
+func loadStored(key string) error {
+	entries, err := stateStore.Entries(key)
+	if err != nil {
+		storeErrors.With("read").Inc()
+		return err
+	}
+	// An entry that doesn't decode is left out, not allowed to lose the
+	// rest.
+	bad := 0
+	switch key {
+	case storeBans:
+		var list []banEntry
+		for _, data := range entries {
+			var e banEntry
+			if json.Unmarshal(data, &e) != nil {
+				bad++
+				continue
+			}
+			list = append(list, e)
+		}
+		err = bans.replace(list)
+	case storeBookings:
+		var list []booking
+		for _, data := range entries {
+			var b booking
+			if json.Unmarshal(data, &b) != nil {
+				bad++
+				continue
+			}
+			list = append(list, b)
+		}
+		replaceBookings(list)
+	case storeSlots:
+		var list []slot
+		for _, data := range entries {
+			var s slot
+			if json.Unmarshal(data, &s) != nil {
+				bad++
+				continue
+			}
+			list = append(list, s)
+		}
+		replaceSlots(list)
+	}
+	if err == nil && bad > 0 {
+		err = fmt.Errorf("%d entries are not valid JSON", bad)
+	}
+	if err != nil {
+		storeErrors.With("read").Inc()
+		return fmt.Errorf("stored %s: %w", key, err)
+	}
+	// Entries that have run out are dropped above but stay in the store
+	// until this server next writes the key, which removes them.
+	held := make(map[string]string, len(entries))
+	for field, data := range entries {
+		held[field] = string(data)
+	}
+	stored[key] = held
+	return nil
+}
+
+// localEntries returns the state under key as the store keeps it: each
+// ban by its prefix, each booking by its mount, account and start, and
+// each slot by its mount and account.
+func localEntries(key string) (map[string]string, error) {
+	entries := make(map[string]string)
+	add := func(field string, v interface{}) error {
+		data, err := json.Marshal(v)
+		entries[field] = string(data)
+		return err
+	}
+	var err error
+	switch key {
+	case storeBans:
+		for _, e := range runtimeBans() {
+			if err = add(e.Prefix, e); err != nil {
+				break
+			}
+		}
+	case storeBookings:
+		for _, b := range bookingList() {
+			if err = add(b.Mount+" "+b.Account+" "+b.From.Format(time.RFC3339Nano), b); err != nil {
+				break
+			}
+		}
+	case storeSlots:
+		for _, s := range slotList() {
+			if err = add(s.Mount+" "+s.Account, s); err != nil {
+				break
+			}
+		}
+	}
+	return entries, err
+}
+
+// saveStored writes the entries under key that changed here since the
+// store was last read or written: new and changed ones are set, and those
+// gone here deleted. Entries other servers added meanwhile aren't among
+// them, so they stay.
+func saveStored(key string) error {
+	entries, err := localEntries(key)
+	if err != nil {
+		storeErrors.With("write").Inc()
+		return err
+	}
+	held := stored[key]
+	if held == nil {
+		held = make(map[string]string)
+		stored[key] = held
+	}
+	for field, data := range entries {
+		if held[field] == data {
+			continue
+		}
+		if err := stateStore.Set(key, field, []byte(data)); err != nil {
+			storeErrors.With("write").Inc()
+			return err
+		}
+		held[field] = data
+	}
+	for field := range held {
+		if _, ok := entries[field]; ok {
+			continue
+		}
+		if err := stateStore.Delete(key, field); err != nil {
+			storeErrors.With("write").Inc()
+			return err
+		}
+		delete(held, field)
+	}
+	return nil
+}
+
+// flushStore writes out what has changed. What can't be written is tried
+// again next time.
+func flushStore() {
+	for key := range takeDirty() {
+		if err := saveStored(key); err != nil {
+			log.Printf("Error saving %s to the state store: %v", key, err)
+			persist(key)
+		}
+	}
+}
+
+// runStore writes changed state to the store as it changes and, with
+// store_sync set, reads back what other servers sharing it have changed.
+// A key changed here and not yet written isn't read back until it has
+// been, so the change isn't lost; the write leaves the others' entries be.
+func runStore(ctx context.Context) error {
+	t := clock.Default.NewTicker(storeFlushInterval)
+	defer t.Stop()
+	every := time.Duration(config.AppConfig.StoreSync) * time.Second
+	lastSync := clock.Default.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			flushStore()
+			return stateStore.Close()
+		case <-t.C():
+		}
+		flushStore()
+		if every <= 0 || clock.Default.Since(lastSync) < every {
+			continue
+		}
+		lastSync = clock.Default.Now()
+		for _, key := range storeKeys {
+			if isDirty(key) {
+				continue
+			}
+			if err := loadStored(key); err != nil {
+				log.Printf("Error reading %s from the state store: %v", key, err)
+			}
+		}
+	}
+}
+
+// runtimeBans lists the bans made at runtime, which the store keeps;
+// configured ones come from nickcast.conf.
+func runtimeBans() []banEntry {
+	list := []banEntry{}
+	for _, e := range bans.list() {
+		if !e.FromCfg {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// replace sets the runtime bans to list, dropping any that have expired.
+func (b *banList) replace(list []banEntry) error {
+	t := clock.Default.Now()
+	dynamic := make(map[netip.Prefix]time.Time, len(list))
+	for _, e := range list {
+		p, err := netip.ParsePrefix(e.Prefix)
+		if err != nil {
+			return err
+		}
+		var until time.Time
+		if e.Until != nil {
+			if !t.Before(*e.Until) {
+				continue
+			}
+			until = *e.Until
+		}
+		dynamic[p] = until
+	}
+	b.mu.Lock()
+	b.dynamic = dynamic
+	b.mu.Unlock()
+	return nil
+}
+
+// bookingList copies the one-off bookings.
+func bookingList() []booking {
+	bookings.mu.Lock()
+	defer bookings.mu.Unlock()
+	list := make([]booking, 0, len(bookings.list))
+	for _, b := range bookings.list {
+		list = append(list, *b)
+	}
+	return list
+}
+
+// replaceBookings sets the one-off bookings to list, dropping any that are
+// over.
+func replaceBookings(list []booking) {
+	t := clock.Default.Now()
+	var kept []*booking
+	for i := range list {
+		b := &list[i]
+		if b.From != nil && b.Until != nil && b.Until.After(t) {
+			kept = append(kept, b)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].From.Before(*kept[j].From) })
+	bookings.mu.Lock()
+	bookings.list = kept
+	bookings.mu.Unlock()
+}
+
+// slotList copies the time slots.
+func slotList() []slot {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	list := make([]slot, 0, len(slots.list))
+	for _, s := range slots.list {
+		list = append(list, *s)
+	}
+	return list
+}
+
+// replaceSlots sets the time slots to list, dropping any that have ended.
+// Slots that were already here keep track of the warnings sent; for new
+// ones, warnings already past aren't sent.
+func replaceSlots(list []slot) {
+	t := clock.Default.Now()
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	var kept []*slot
+	for i := range list {
+		s := &list[i]
+		if !s.Ends.After(t) {
+			continue
+		}
+		s.warned = 0
+		for _, old := range slots.list {
+			if old.Mount == s.Mount && old.Account == s.Account && old.Ends.Equal(s.Ends) {
+				s.warned = old.warned
+			}
+		}
+		for s.warned < len(slotWarnings) && s.Ends.Sub(t) <= slotWarnings[s.warned] {
+			s.warned++
+		}
+		kept = append(kept, s)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Ends.Before(kept[j].Ends) })
+	slots.list = kept
+}
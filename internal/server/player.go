@@ -0,0 +1,275 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// contrastCookie remembers a listener's choice of high contrast on the
+// player page.
+const contrastCookie = "nickcast_contrast"
+
+var playerTpl = template.Must(template.New("player").Parse(defaultPlayerPage))
+
+// defaultPlayerPage is the player served at /player when the station has
+// no player_template. It works with the keyboard alone and with screen
+// readers: the controls are real buttons whose labels follow their state,
+// titles are read out as they change, and without JavaScript the browser's
+// own audio controls take over. High contrast is chosen here, so it
+// survives a reload and works without JavaScript too.
+const defaultPlayerPage = `<!DOCTYPE html>
+<html lang="{{.Lang}}"{{if .HighContrast}} class="contrast"{{end}}>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+<style>
+:root { --accent: {{.Accent}}; --bg: #fafafa; --fg: #1a1a1a; --muted: #555; }
+.contrast { --accent: #ffff00; --bg: #000; --fg: #fff; --muted: #fff; }
+@media (prefers-contrast: more) { :root:not(.normal) { --accent: #ffff00; --bg: #000; --fg: #fff; --muted: #fff; } }
+body { background: var(--bg); color: var(--fg); font: 1.1rem/1.5 system-ui, sans-serif; max-width: 36rem; margin: 2rem auto; padding: 0 1rem; }
+img { max-width: 12rem; }
+a { color: inherit; }
+p.description, .shortcuts { color: var(--muted); }
+button { background: var(--accent); color: var(--bg); border: 2px solid var(--fg); border-radius: .4rem; font: inherit; padding: .5rem 1rem; min-width: 3rem; cursor: pointer; }
+.contrast button { color: #000; }
+:focus-visible { outline: 3px solid var(--fg); outline-offset: 3px; }
+.controls { display: flex; flex-wrap: wrap; gap: .75rem; align-items: center; margin: 1rem 0; }
+input[type=range] { accent-color: var(--accent); }
+.visually-hidden { position: absolute; width: 1px; height: 1px; overflow: hidden; clip: rect(0 0 0 0); white-space: nowrap; }
+</style>
+</head>
+<body>
+<header>
+{{if .Logo}}<img src="{{.Logo}}" alt="">{{end}}
+<h1>{{if .URL}}<a href="{{.URL}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</h1>
+{{if .Description}}<p class="description">{{.Description}}</p>{{end}}
+</header>
+<main>
+<audio id="audio" src="{{.StreamURL}}" preload="none" controls>{{.T "player.no_audio"}}</audio>
+<div class="controls" role="group" aria-label="{{.T "player.controls"}}" hidden>
+<button type="button" id="play" aria-keyshortcuts="k" data-play="{{.T "player.play"}}" data-pause="{{.T "player.pause"}}">{{.T "player.play"}}</button>
+<button type="button" id="mute" aria-keyshortcuts="m" data-mute="{{.T "player.mute"}}" data-unmute="{{.T "player.unmute"}}">{{.T "player.mute"}}</button>
+<label for="volume">{{.T "player.volume"}}</label>
+<input type="range" id="volume" min="0" max="100" step="5" value="100">
+</div>
+<p id="status" role="status" class="visually-hidden" data-playing="{{.T "player.playing"}}" data-paused="{{.T "player.paused"}}" data-error="{{.T "player.error"}}"></p>
+<h2>{{.T "player.now_playing"}}</h2>
+<p id="title" aria-live="polite" data-nothing="{{.T "player.nothing"}}">{{if .Title}}{{.Title}}{{else}}{{.T "player.nothing"}}{{end}}</p>
+<form method="get" action="">
+<input type="hidden" name="mount" value="{{.Mount}}">
+<button type="submit" id="contrast" name="contrast" value="{{if .HighContrast}}normal{{else}}high{{end}}" aria-pressed="{{.HighContrast}}">{{.T "player.contrast"}}</button>
+</form>
+<details class="shortcuts">
+<summary>{{.T "player.shortcuts"}}</summary>
+<ul>
+<li>{{.T "player.key_play"}}</li>
+<li>{{.T "player.key_mute"}}</li>
+<li>{{.T "player.key_volume"}}</li>
+</ul>
+</details>
+{{if gt (len .Mounts) 1}}
+<nav aria-label="{{.T "player.streams"}}">
+<h2>{{.T "player.streams"}}</h2>
+<ul>
+{{range .Mounts}}<li><a href="?mount={{.Name}}"{{if .Current}} aria-current="page"{{end}}>{{.Name}}</a></li>
+{{end}}</ul>
+</nav>
+{{end}}
+</main>
+<script>
+(function () {
+  var audio = document.getElementById("audio"), play = document.getElementById("play"),
+      mute = document.getElementById("mute"), volume = document.getElementById("volume"),
+      status = document.getElementById("status"), title = document.getElementById("title"),
+      contrast = document.getElementById("contrast"), stream = audio.src;
+  audio.removeAttribute("controls");
+  document.querySelector(".controls").hidden = false;
+
+  function say(what) { status.textContent = status.dataset[what]; }
+  function toggle() {
+    if (audio.paused) {
+      // Reconnect rather than resume, so the listener hears what's on now.
+      audio.src = stream;
+      audio.play().catch(function () { say("error"); });
+    } else {
+      audio.pause();
+      audio.removeAttribute("src");
+      audio.load();
+    }
+  }
+  function toggleMute() { audio.muted = !audio.muted; }
+  function nudge(by) {
+    volume.value = Math.max(0, Math.min(100, +volume.value + by));
+    audio.volume = volume.value / 100;
+  }
+
+  audio.addEventListener("playing", function () { play.textContent = play.dataset.pause; say("playing"); });
+  audio.addEventListener("pause", function () { play.textContent = play.dataset.play; say("paused"); });
+  audio.addEventListener("volumechange", function () {
+    mute.textContent = audio.muted ? mute.dataset.unmute : mute.dataset.mute;
+  });
+  play.addEventListener("click", toggle);
+  mute.addEventListener("click", toggleMute);
+  volume.addEventListener("input", function () { audio.volume = volume.value / 100; });
+
+  document.addEventListener("keydown", function (e) {
+    if (e.ctrlKey || e.metaKey || e.altKey) return;
+    var t = e.target.tagName;
+    if (t === "INPUT" || t === "TEXTAREA" || t === "SELECT") return;
+    var onControl = t === "BUTTON" || t === "A" || t === "SUMMARY";
+    switch (e.key) {
+    case " ": if (onControl) return; // let Space press the focused control
+    case "k": case "K": toggle(); break;
+    case "m": case "M": toggleMute(); break;
+    case "ArrowUp": nudge(5); break;
+    case "ArrowDown": nudge(-5); break;
+    default: return;
+    }
+    e.preventDefault();
+  });
+
+  // Switch contrast without reloading, which would stop the audio; the
+  // cookie keeps the choice for the next visit.
+  contrast.form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var root = document.documentElement, high = contrast.value === "high";
+    root.classList.toggle("contrast", high);
+    root.classList.toggle("normal", !high);
+    contrast.setAttribute("aria-pressed", high);
+    contrast.value = high ? "normal" : "high";
+    document.cookie = "{{.Cookie}}=" + (high ? "high" : "normal") + "; path=/; max-age=31536000; samesite=lax";
+  });
+
+  setInterval(function () {
+    fetch({{.NowPlayingURL}}).then(function (r) { return r.ok ? r.text() : null; }).then(function (text) {
+      if (text === null) return;
+      text = text || title.dataset.nothing;
+      if (title.textContent !== text) title.textContent = text;
+    }).catch(function () {});
+  }, 15000);
+})();
+</script>
+</body>
+</html>
+`
+
+// playerPage is what the player template is given. T translates a message
+// into the listener's language, Lang.
+type playerPage struct {
+	Name, Description, URL string
+	Logo                   string
+	Accent                 template.CSS
+	Mount                  string
+	StreamURL              string
+	ContentType            string
+	NowPlayingURL          string
+	Title                  string
+	Live                   bool
+	HighContrast           bool
+	Cookie                 string
+	Lang                   string
+	Mounts                 []playerMount
+}
+
+// playerMount is one of the station's streams, for switching between them.
+type playerMount struct {
+	Name    string
+	Current bool
+}
+
+func (p playerPage) T(key string, args ...string) string {
+	return catalogs.T(p.Lang, key, args...)
+}
+
+// loadPlayers parses each station's player_template, if set.
+func loadPlayers() error {
+	for _, st := range stations {
+		if st.cfg.PlayerTemplate == "" {
+			continue
+		}
+		tpl, err := template.ParseFiles(st.cfg.PlayerTemplate)
+		if err != nil {
+			return fmt.Errorf("station %s: error loading player_template: %w", st.cfg.Name, err)
+		}
+		log.Printf("Station %s: player page from %s", st.cfg.Name, st.cfg.PlayerTemplate)
+		st.player = tpl
+	}
+	return nil
+}
+
+// playerHandler serves /player, a web player for a mount in the station's
+// branding. ?mount= picks the mount; otherwise it is the default mount of
+// the host's station. ?contrast=high or ?contrast=normal switches high
+// contrast and remembers it in a cookie; without either, the page follows
+// the cookie, and then the browser's own contrast preference.
+func playerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var m *mount
+	if ref := r.URL.Query().Get("mount"); ref != "" {
+		m = requestMount(r, ref)
+	} else if list := stationMounts(hostStation(r.Host), nil); len(list) > 0 {
+		m = list[0]
+	}
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+
+	contrast := r.URL.Query().Get("contrast")
+	switch contrast {
+	case "high", "normal":
+		http.SetCookie(w, &http.Cookie{Name: contrastCookie, Value: contrast, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+	default:
+		if c, err := r.Cookie(contrastCookie); err == nil {
+			contrast = c.Value
+		}
+	}
+
+	st := m.station
+	data := playerPage{
+		Name:          st.cfg.Title,
+		Description:   st.cfg.Description,
+		URL:           st.cfg.URL,
+		Logo:          st.cfg.PlayerLogo,
+		Accent:        template.CSS(st.cfg.PlayerAccent),
+		Mount:         m.cfg.Name,
+		StreamURL:     m.cfg.ListenPath,
+		ContentType:   m.contentType(),
+		NowPlayingURL: "/nowplaying.txt?mount=" + m.cfg.Name,
+		Live:          m.active(),
+		HighContrast:  contrast == "high",
+		Cookie:        contrastCookie,
+		Lang:          m.lang(r),
+	}
+	if data.Name == "" {
+		data.Name = m.cfg.Name
+	}
+	if data.Accent == "" {
+		data.Accent = "#6a1b9a"
+	}
+	if data.Live {
+		data.Title = m.currentTitle()
+	}
+	for _, mm := range stationMounts(st.cfg.Name, nil) {
+		data.Mounts = append(data.Mounts, playerMount{Name: mm.cfg.Name, Current: mm == m})
+	}
+
+	tpl := playerTpl
+	if st.player != nil {
+		tpl = st.player
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", data.Lang)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Accept-Language, Cookie")
+	if err := tpl.Execute(w, data); err != nil {
+		logf(r, "Error rendering player page: %v", err)
+	}
+}
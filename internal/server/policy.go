@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"nickcast/internal/policy"
+)
+
+// script is the operator's policy script, nil when policy_script is unset.
+var script *policy.Script
+
+// admitPolicy asks the policy script about a listener, answering 403 with
+// the script's reason if it says no. account is the listener's NickServ
+// account on mounts with listener_auth.
+func (m *mount) admitPolicy(w http.ResponseWriter, r *http.Request, account string) bool {
+	if script == nil {
+		return true
+	}
+	ok, reason := script.Admit(r.Context(), policy.Listener{
+		Mount:     m.cfg.Name,
+		Station:   m.cfg.Station,
+		IP:        clientIP(r),
+		Country:   clientCountry(r),
+		UserAgent: r.UserAgent(),
+		Account:   account,
+	})
+	if ok {
+		return true
+	}
+	if reason == "" {
+		reason = m.tr(r, "listen.not_allowed")
+	}
+	logf(r, "Listener from %s rejected by policy script: %s", r.RemoteAddr, reason)
+	http.Error(w, reason, http.StatusForbidden)
+	return false
+}
+
+// policyTitle passes a title update through the policy script.
+func policyTitle(r *http.Request, m *mount, account, title string) string {
+	if script == nil {
+		return title
+	}
+	return script.RewriteTitle(r.Context(), policy.Metadata{Mount: m.cfg.Name, Account: account, Title: title})
+}
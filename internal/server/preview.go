@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"nickcast/internal/mp3"
+	"strconv"
+)
+
+const (
+	defaultPreviewSeconds = 10
+	maxPreviewSeconds     = 60
+)
+
+// byteRate estimates the mount's stream rate in bytes per second from the
+// configured bitrate, or failing that from the MP3 frames in data. It
+// returns 0 when it can't tell.
+func (m *mount) byteRate(data []byte) int {
+	if m.cfg.Bitrate > 0 {
+		return m.cfg.Bitrate * 1000 / 8
+	}
+	if i := mp3.Sync(data); i >= 0 {
+		h, _ := mp3.ParseHeader(data[i:])
+		return h.Bitrate * 1000 / 8
+	}
+	return 0
+}
+
+// previewHandler serves /admin/preview?mount=...&seconds=10: the last few
+// seconds of what's on air, straight from the timeshift buffer, so a moderator
+// can check a stream without opening a player. The clip can't be longer
+// than the timeshift (or burst) buffer holds.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	m := requestMount(r, r.FormValue("mount"))
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+	if _, ok := requireAdmin(w, r, m.station); !ok {
+		return
+	}
+	seconds := defaultPreviewSeconds
+	if s := r.FormValue("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPreviewSeconds {
+			http.Error(w, "seconds must be between 1 and "+strconv.Itoa(maxPreviewSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	if !m.active() {
+		http.Error(w, "Nothing on air", http.StatusNotFound)
+		return
+	}
+
+	data := m.lastSeconds(seconds)
+
+	ct := m.contentType()
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `inline; filename="preview`+extensionFor(ct)+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
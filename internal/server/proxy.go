@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"nickcast/config"
+	"nickcast/internal/metrics"
+	"strings"
+)
+
+var proxyErrors = metrics.NewCounterVec("nickcast_proxy_errors_total", "Requests a [proxy] route could not pass on to its target.", "proxy")
+
+// registerProxies routes each [proxy] path to its service. Requests get
+// the usual X-Forwarded-For, -Host and -Proto headers, and
+// X-Forwarded-Prefix with the path, for apps that build their own links.
+// WebSocket upgrades (as a web chat uses) pass through.
+func registerProxies(mux *http.ServeMux) {
+	for _, p := range config.AppConfig.Proxies {
+		mux.Handle(p.Path, newProxy(p))
+		log.Printf("Proxy %s: %s -> %s", p.Name, p.Path, p.Target)
+	}
+}
+
+func newProxy(p config.Proxy) http.Handler {
+	// Checked when the config was loaded.
+	target, _ := url.Parse(p.Target)
+	prefix := strings.TrimSuffix(p.Path, "/")
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if p.StripPath {
+				pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, prefix)
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyErrors.With(p.Name).Inc()
+			logf(r, "Proxy %s could not reach %s: %v", p.Name, p.Target, err)
+			http.Error(w, "Service unavailable", http.StatusBadGateway)
+		},
+	}
+}
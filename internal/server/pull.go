@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"nickcast/internal/clock"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// pullAccount is who pulled streams are on air as.
+	pullAccount = "pull"
+
+	// pullGiveUp is how long a pull keeps retrying an upstream that
+	// doesn't answer, or drops, before it is given up.
+	pullGiveUp = 5 * time.Minute
+)
+
+// pull is a remote stream an admin put on air through /admin/pull, for
+// rebroadcasting a one-off event. Unlike a relay it isn't in the
+// configuration: it runs until the upstream ends the stream, it is taken
+// off air, or an admin stops it.
+type pull struct {
+	Mount     string    `json:"mount"`
+	URL       string    `json:"url"` // without its password
+	StartedBy string    `json:"started_by"`
+	Started   time.Time `json:"started"`
+	OnAir     bool      `json:"on_air"`
+	LastError string    `json:"last_error,omitempty"`
+
+	url      string // as given
+	takeover bool
+	cancel   context.CancelFunc
+}
+
+// pullClient fetches pulls. It only connects to public addresses, however
+// the name resolves by the time it connects and wherever it is redirected,
+// so an admin can't have the server fetch from its own network, and it
+// goes direct, not through a proxy.
+var pullClient = &http.Client{Transport: &http.Transport{
+	DialContext:         (&net.Dialer{Timeout: 30 * time.Second, Control: dialPublic}).DialContext,
+	TLSHandshakeTimeout: 10 * time.Second,
+}}
+
+// publicIP reports whether ip is an address on the internet at large, not
+// loopback, private, link-local, multicast or unspecified.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// dialPublic refuses connections to addresses that aren't public.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// checkPullHost resolves host and reports the first address it has that
+// isn't public, if any, so a pull that could never connect is refused up
+// front.
+func checkPullHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return fmt.Errorf("%s resolves to %s, which is not a public address", host, a.IP)
+		}
+	}
+	return nil
+}
+
+var pulls struct {
+	mu     sync.Mutex
+	active map[string]*pull // by mount name
+}
+
+// pullStarts hands new pulls to runPulls, which runs them in its context.
+var pullStarts = make(chan *pull, 16)
+
+// runPulls runs the pulls admins start until it is stopped.
+func runPulls(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p := <-pullStarts:
+			pctx, cancel := context.WithCancel(ctx)
+			pulls.mu.Lock()
+			p.cancel = cancel
+			stopped := pulls.active[p.Mount] != p
+			pulls.mu.Unlock()
+			if stopped {
+				// Stopped before it got going.
+				cancel()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.run(pctx)
+			}()
+		}
+	}
+}
+
+// run pulls p onto its mount, reconnecting after failures for up to
+// pullGiveUp, and forgets it when it is over.
+func (p *pull) run(ctx context.Context) {
+	defer p.cancel()
+	defer func() {
+		pulls.mu.Lock()
+		if pulls.active[p.Mount] == p {
+			delete(pulls.active, p.Mount)
+		}
+		pulls.mu.Unlock()
+	}()
+	m := findMount(p.Mount)
+	id := "pull-" + strings.TrimPrefix(m.cfg.Name, "/")
+	takeover := p.takeover
+	spec := pullSpec{
+		url:     p.url,
+		client:  pullClient,
+		account: pullAccount,
+		id:      id,
+		claim: func(remote string, cancel <-chan struct{}) bool {
+			if !takeover {
+				return m.claimOrPreempt(id, remote, pullAccount, cancel)
+			}
+			return m.claimSource() || m.takeOver(id, remote, pullAccount, 0, cancel, byAdmin)
+		},
+		onAir: func() {
+			// Only the first connection replaces whoever was live.
+			takeover = false
+			pulls.mu.Lock()
+			p.OnAir, p.LastError = true, ""
+			pulls.mu.Unlock()
+		},
+	}
+
+	wait := time.Second
+	last := clock.Default.Now() // last on air, or started
+	for {
+		err := m.pullStream(ctx, spec)
+		pulls.mu.Lock()
+		if p.OnAir {
+			last = clock.Default.Now()
+		}
+		p.OnAir = false
+		if err != nil && ctx.Err() == nil {
+			p.LastError = err.Error()
+		}
+		pulls.mu.Unlock()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == errUpstreamEnded:
+			log.Printf("Pull of %s onto %s is over: the upstream ended the stream", p.URL, m.cfg.Name)
+			return
+		case err == errRelayKicked:
+			log.Printf("Pull of %s onto %s is over: taken off air", p.URL, m.cfg.Name)
+			return
+		case err == errRelayBusy:
+			log.Printf("Pull of %s onto %s is over: someone else is on air", p.URL, m.cfg.Name)
+			return
+		case clock.Default.Since(last) > pullGiveUp:
+			log.Printf("Giving up pulling %s onto %s after %s: %v", p.URL, m.cfg.Name, pullGiveUp, err)
+			return
+		}
+		relayFailures.With(m.cfg.Name).Inc()
+		log.Printf("Pulling %s onto %s: %v; retrying in %s", p.URL, m.cfg.Name, err, wait)
+		t := clock.Default.NewTimer(wait)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		if wait *= 2; wait > relayRetryMax {
+			wait = relayRetryMax
+		}
+	}
+}
+
+// pullHandler serves /admin/pull, for station admins:
+//
+//	GET    /admin/pull[?mount=]                  pulls running
+//	POST   /admin/pull?mount=&url=[&takeover=1]  put url on air on mount
+//	DELETE /admin/pull?mount=                    stop the pull on mount
+//
+// A pull goes on air in place of the auto-DJ, but not of a streamer,
+// unless takeover=1 takes them off air. It must send the mount's format;
+// if it doesn't, or can't be reached, that shows in GET's last_error. Only
+// public addresses are pulled from; see pullClient.
+func pullHandler(w http.ResponseWriter, r *http.Request) {
+	var m *mount
+	if ref := r.FormValue("mount"); ref != "" || r.Method != http.MethodGet {
+		if m = requestMount(r, ref); m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+	}
+	st := defaultStation()
+	if m != nil {
+		st = m.station
+	}
+	user, ok := requireAdmin(w, r, st)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list := []pull{}
+		pulls.mu.Lock()
+		for _, p := range pulls.active {
+			if m == nil || p.Mount == m.cfg.Name {
+				list = append(list, *p)
+			}
+		}
+		pulls.mu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Mount < list[j].Mount })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		u, err := url.Parse(r.FormValue("url"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+		if err := checkPullHost(r.Context(), u.Hostname()); err != nil {
+			http.Error(w, "Cannot pull from "+u.Redacted()+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.cfg.DownmixOf != "" {
+			http.Error(w, "Nothing can stream to a mono copy", http.StatusConflict)
+			return
+		}
+		takeover, _ := strconv.ParseBool(r.FormValue("takeover"))
+		if s := m.currentSession(); s != nil && !takeover && !m.canTakeOver(0) {
+			http.Error(w, s.account+" is on air on "+m.cfg.Name+"; add takeover=1 to replace them", http.StatusConflict)
+			return
+		}
+		p := &pull{
+			Mount:     m.cfg.Name,
+			URL:       u.Redacted(),
+			StartedBy: user,
+			Started:   clock.Default.Now(),
+			url:       u.String(),
+			takeover:  takeover,
+		}
+		pulls.mu.Lock()
+		if pulls.active == nil {
+			pulls.active = make(map[string]*pull)
+		}
+		if old := pulls.active[m.cfg.Name]; old != nil {
+			pulls.mu.Unlock()
+			http.Error(w, "Already pulling "+old.URL+" onto "+m.cfg.Name+"; stop it first", http.StatusConflict)
+			return
+		}
+		select {
+		case pullStarts <- p:
+			pulls.active[m.cfg.Name] = p
+		default:
+			pulls.mu.Unlock()
+			http.Error(w, "Too many pulls starting; try again", http.StatusServiceUnavailable)
+			return
+		}
+		resp := *p
+		pulls.mu.Unlock()
+		logf(r, "Admin %s started pulling %s onto %s", user, p.URL, m.cfg.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodDelete:
+		pulls.mu.Lock()
+		p := pulls.active[m.cfg.Name]
+		var cancel context.CancelFunc
+		if p != nil {
+			delete(pulls.active, m.cfg.Name)
+			cancel = p.cancel
+		}
+		pulls.mu.Unlock()
+		if p == nil {
+			http.Error(w, "No pull on "+m.cfg.Name, http.StatusNotFound)
+			return
+		}
+		if cancel != nil {
+			cancel()
+		}
+		logf(r, "Admin %s stopped pulling %s onto %s", user, p.URL, m.cfg.Name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"nickcast/config"
+	"nickcast/internal/events"
+	"nickcast/internal/purge"
+)
+
+// purger purges changed pages from the CDN; nil when purging isn't
+// configured.
+var purger *purge.Purger
+
+// purgePaths has the CDN drop its copies of paths under every purge_base.
+func purgePaths(paths ...string) {
+	if purger == nil {
+		return
+	}
+	var urls []string
+	for _, base := range config.AppConfig.PurgeBase {
+		for _, p := range paths {
+			urls = append(urls, base+p)
+		}
+	}
+	purger.Purge(urls...)
+}
+
+// purgeMount purges the pages that show what is on m: its title and
+// whether it is live.
+func purgeMount(m *mount) {
+	purgePaths(
+		"/nowplaying.txt",
+		"/nowplaying.txt?mount="+m.cfg.Name,
+		"/nowplaying.txt?mount="+m.cfg.ListenPath,
+		"/api/stations",
+	)
+}
+
+// purgeOnEvent purges a mount's pages when its title changes or a source
+// comes or goes.
+func purgeOnEvent(e events.Event) {
+	switch e.Type {
+	case events.MetadataUpdate, events.SourceConnect, events.SourceDisconnect:
+		if m := findMount(e.Data["mount"]); m != nil {
+			purgeMount(m)
+		}
+	}
+}
+
+// purgeShows purges the show schedule after a show is added or withdrawn.
+func purgeShows(mount string) {
+	purgePaths("/api/shows", "/api/shows?mount="+mount)
+}
+
+// purgeArchive purges the recordings list once a recording is finished.
+func purgeArchive() {
+	if config.AppConfig.Archive {
+		purgePaths("/archive", "/archive/")
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/archive"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// quotaSampleInterval is how often station bandwidth is measured.
+	quotaSampleInterval = time.Second
+	// diskScanInterval is how often record_dir is rescanned to correct the
+	// running disk usage figures (deleted files, other processes).
+	diskScanInterval = time.Minute
+)
+
+var (
+	quotaRejections  = metrics.NewCounterVec("nickcast_quota_rejections_total", "Listeners and recordings refused because a station was over quota.", "station", "quota")
+	stationListeners = metrics.NewGaugeVec("nickcast_station_listeners", "Listeners connected to each station's mounts.", "station")
+	stationBandwidth = metrics.NewGaugeVec("nickcast_station_bandwidth_bits", "Outgoing listener traffic per station, bits per second.", "station")
+	stationDisk      = metrics.NewGaugeVec("nickcast_station_record_disk_bytes", "Recording disk space used by each station.", "station")
+)
+
+// usage is a station's share of the process's resources, kept up to date by
+// listeners, recorders and the quota sampler.
+type usage struct {
+	listeners atomic.Int64
+	bytesOut  atomic.Int64 // listener bytes sent since the last sample
+	bandwidth atomic.Int64 // outgoing rate at the last sample, bits per second
+	disk      atomic.Int64 // bytes of recordings in record_dir
+}
+
+// addListener reserves a listener slot for the station, reporting false if
+// it is at max_listeners.
+func (st *station) addListener() bool {
+	for {
+		n := st.usage.listeners.Load()
+		if st.cfg.MaxListeners > 0 && n >= int64(st.cfg.MaxListeners) {
+			return false
+		}
+		if st.usage.listeners.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (st *station) removeListener() {
+	st.usage.listeners.Add(-1)
+}
+
+// overBandwidth reports whether the station is already sending as much as
+// max_bandwidth allows. Connected listeners are never cut off; new ones
+// are turned away until the rate drops.
+func (st *station) overBandwidth() bool {
+	return st.cfg.MaxBandwidth > 0 && st.usage.bandwidth.Load() >= int64(st.cfg.MaxBandwidth)*1000
+}
+
+// overDisk reports whether the station's recordings fill max_record_disk.
+func (st *station) overDisk() bool {
+	return st.cfg.MaxRecordDisk > 0 && st.usage.disk.Load() >= st.cfg.MaxRecordDisk
+}
+
+// admitListener checks the station-wide quotas for a new listener to m and
+// takes a listener slot if they allow it. The caller must removeListener
+// when the listener goes.
+func (st *station) admitListener(w http.ResponseWriter, r *http.Request, m *mount) bool {
+	if st.overBandwidth() {
+		quotaRejections.With(st.cfg.Name, "bandwidth").Inc()
+		logf(r, "Listener from %s rejected: station %s is over its bandwidth quota.", r.RemoteAddr, st.cfg.Name)
+		m.listenerError(w, r, http.StatusServiceUnavailable, "listen.over_quota")
+		return false
+	}
+	if !st.addListener() {
+		quotaRejections.With(st.cfg.Name, "listeners").Inc()
+		logf(r, "Listener from %s rejected: station %s is at its limit of %d listeners.", r.RemoteAddr, st.cfg.Name, st.cfg.MaxListeners)
+		m.listenerError(w, r, http.StatusServiceUnavailable, "listen.station_full")
+		return false
+	}
+	return true
+}
+
+// meteredWriter counts listener traffic towards its station's bandwidth
+// and the mount's bytes sent.
+type meteredWriter struct {
+	w io.Writer
+	m *mount
+}
+
+func (mw meteredWriter) Write(p []byte) (int, error) {
+	n, err := mw.w.Write(p)
+	mw.m.station.usage.bytesOut.Add(int64(n))
+	mw.m.bytesSent.Add(int64(n))
+	return n, err
+}
+
+// sampleQuotas measures each station's outgoing bandwidth every second and
+// rescans record_dir for disk usage every minute.
+func sampleQuotas(ctx context.Context) error {
+	scanDisk()
+	t := clock.Default.NewTicker(quotaSampleInterval)
+	defer t.Stop()
+	last := clock.Default.Now()
+	lastScan := last
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		now := clock.Default.Now()
+		if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+			for _, st := range stations {
+				st.usage.bandwidth.Store(int64(float64(st.usage.bytesOut.Swap(0)*8) / elapsed))
+			}
+		}
+		last = now
+		if now.Sub(lastScan) >= diskScanInterval {
+			scanDisk()
+			lastScan = now
+		}
+		for name, st := range stations {
+			stationListeners.With(name).Set(st.usage.listeners.Load())
+			stationBandwidth.With(name).Set(st.usage.bandwidth.Load())
+			stationDisk.With(name).Set(st.usage.disk.Load())
+		}
+	}
+}
+
+// scanDisk totals the recordings in record_dir by the station of the mount
+// that made them. Recordings without a sidecar, or from mounts that no
+// longer exist, count towards the default station.
+func scanDisk() {
+	dir := config.AppConfig.RecordDir
+	if dir == "" {
+		return
+	}
+	entries, err := archive.List(dir)
+	if err != nil {
+		log.Printf("Error scanning %s for disk quotas: %v", dir, err)
+		return
+	}
+	totals := make(map[string]int64)
+	for _, e := range entries {
+		name := config.DefaultStationName
+		if e.Sidecar != nil {
+			if mc := config.AppConfig.Mount(e.Sidecar.Mount); mc != nil {
+				name = mc.Station
+			}
+		}
+		totals[name] += e.Size
+	}
+	for name, st := range stations {
+		st.usage.disk.Store(totals[name])
+	}
+}
+
+type quotaInfo struct {
+	Station string `json:"station"`
+	Mounts  struct {
+		Used  int `json:"used"`
+		Limit int `json:"limit"`
+	} `json:"mounts"`
+	Listeners struct {
+		Used  int64 `json:"used"`
+		Limit int   `json:"limit"`
+	} `json:"listeners"`
+	Bandwidth struct {
+		UsedKbps int64 `json:"used_kbps"`
+		Limit    int   `json:"limit_kbps"`
+	} `json:"bandwidth"`
+	RecordDisk struct {
+		Used  int64 `json:"used_bytes"`
+		Limit int64 `json:"limit_bytes"`
+	} `json:"record_disk"`
+}
+
+// quotas reports the station's usage against its limits; a limit of 0 is
+// unlimited.
+func (st *station) quotas() quotaInfo {
+	var q quotaInfo
+	q.Station = st.cfg.Name
+	for _, m := range mounts() {
+		if m.station == st {
+			q.Mounts.Used++
+		}
+	}
+	q.Mounts.Limit = st.cfg.MaxMounts
+	q.Listeners.Used = st.usage.listeners.Load()
+	q.Listeners.Limit = st.cfg.MaxListeners
+	q.Bandwidth.UsedKbps = st.usage.bandwidth.Load() / 1000
+	q.Bandwidth.Limit = st.cfg.MaxBandwidth
+	q.RecordDisk.Used = st.usage.disk.Load()
+	q.RecordDisk.Limit = st.cfg.MaxRecordDisk
+	return q
+}
+
+// quotasHandler serves /admin/quotas. Station admins see their own station
+// (?station=name); admins of the default station may list every station.
+func quotasHandler(w http.ResponseWriter, r *http.Request) {
+	var list []quotaInfo
+	if name := strings.Trim(r.URL.Query().Get("station"), "/"); name != "" {
+		st, ok := stations[name]
+		if !ok {
+			http.Error(w, "Unknown station", http.StatusNotFound)
+			return
+		}
+		if _, ok := requireAdmin(w, r, st); !ok {
+			return
+		}
+		list = append(list, st.quotas())
+	} else {
+		if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+			return
+		}
+		for _, st := range stations {
+			list = append(list, st.quotas())
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Station < list[j].Station })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
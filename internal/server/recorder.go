@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"nickcast/config"
+	"nickcast/internal/archive"
+	"nickcast/internal/clock"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// analysisTimeout bounds how long ffmpeg may spend on one post-processing
+// job (loudness analysis, peak generation) for a recording.
+const analysisTimeout = 10 * time.Minute
+
+// recorder writes one stream session of a mount to disk, along with a JSON
+// sidecar describing it.
+type recorder struct {
+	path    string
+	file    *os.File
+	station *station // charged for the disk space used
+	untrack func()
+
+	mu      sync.Mutex
+	err     error // first write error; further writes are skipped
+	sidecar archive.Sidecar
+}
+
+// errDiskQuota stops a recording once its station has used up
+// max_record_disk.
+var errDiskQuota = errors.New("record_disk quota exceeded")
+
+// extensionFor picks a file extension for recordings of the given content type.
+func extensionFor(contentType string) string {
+	switch strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])) {
+	case "audio/ogg", "application/ogg":
+		return ".ogg"
+	case "audio/aac", "audio/aacp":
+		return ".aac"
+	case "audio/flac":
+		return ".flac"
+	default:
+		return ".mp3"
+	}
+}
+
+// startRecorder opens a new recording file for the mount's current session.
+func startRecorder(m *mount, account, show, sessionID string) (*recorder, error) {
+	if m.station.overDisk() {
+		quotaRejections.With(m.station.cfg.Name, "record_disk").Inc()
+		return nil, fmt.Errorf("station %s is over its record_disk quota", m.station.cfg.Name)
+	}
+	dir := config.AppConfig.RecordDir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create record_dir: %w", err)
+	}
+	start := clock.Default.Now()
+	ct := m.contentType()
+	name := fmt.Sprintf("%s-%s%s", m.fileName(), start.Format("20060102-150405"), extensionFor(ct))
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	rec := &recorder{
+		path:    path,
+		file:    f,
+		station: m.station,
+		untrack: track(subsysRecorders),
+		sidecar: archive.Sidecar{
+			Mount:       m.cfg.Name,
+			Account:     account,
+			Show:        show,
+			SessionID:   sessionID,
+			ContentType: ct,
+			Start:       start,
+			Tracks:      []archive.Track{},
+		},
+	}
+	// Write an initial sidecar straight away so a crash mid-show still
+	// leaves the recording attributable.
+	if err := archive.WriteSidecar(path, &rec.sidecar); err != nil {
+		log.Printf("Error writing sidecar for %s: %v", path, err)
+	}
+	return rec, nil
+}
+
+func (rec *recorder) write(data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return
+	}
+	n, err := rec.file.Write(data)
+	rec.sidecar.Bytes += int64(n)
+	rec.station.usage.disk.Add(int64(n))
+	if err != nil {
+		rec.err = err
+		log.Printf("Recording to %s failed, no further data will be written: %v", rec.path, err)
+	} else if rec.station.overDisk() {
+		rec.err = errDiskQuota
+		quotaRejections.With(rec.station.cfg.Name, "record_disk").Inc()
+		log.Printf("Recording to %s stopped: station %s is over its record_disk quota", rec.path, rec.station.cfg.Name)
+	}
+}
+
+// addTrack notes a metadata change at the current position.
+func (rec *recorder) addTrack(title string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.sidecar.Tracks = append(rec.sidecar.Tracks, archive.Track{
+		Title:  title,
+		Offset: clock.Default.Since(rec.sidecar.Start).Seconds(),
+		Byte:   rec.sidecar.Bytes,
+	})
+}
+
+// noteListeners keeps track of the session's peak audience.
+func (rec *recorder) noteListeners(n int) {
+	rec.mu.Lock()
+	if n > rec.sidecar.PeakListeners {
+		rec.sidecar.PeakListeners = n
+	}
+	rec.mu.Unlock()
+}
+
+// close finishes the recording and its sidecar, and queues loudness analysis
+// if ffmpeg is configured.
+func (rec *recorder) close() error {
+	defer rec.untrack()
+	rec.mu.Lock()
+	rec.sidecar.End = clock.Default.Now()
+	sc := rec.sidecar
+	rec.mu.Unlock()
+
+	err := rec.file.Close()
+	if serr := archive.WriteSidecar(rec.path, &sc); serr != nil {
+		log.Printf("Error writing sidecar for %s: %v", rec.path, serr)
+	}
+
+	if ffmpeg := config.AppConfig.FFmpegPath; ffmpeg != "" && err == nil {
+		path := rec.path
+		queuePeaks(path)
+		queueArchiveJob(func(ctx context.Context) {
+			ctx, cancel := context.WithTimeout(ctx, analysisTimeout)
+			defer cancel()
+			l, err := archive.MeasureLoudness(ctx, ffmpeg, path)
+			if err != nil {
+				log.Printf("Loudness analysis of %s failed: %v", path, err)
+				return
+			}
+			sc.Loudness = l
+			if err := archive.WriteSidecar(path, &sc); err != nil {
+				log.Printf("Error writing sidecar for %s: %v", path, err)
+			}
+		})
+	}
+	return err
+}
+
+// peaksPending holds the recordings with peak generation queued or
+// running, so repeated requests for their peaks don't queue it again.
+var peaksPending = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+// queuePeaks schedules waveform peak generation for a recording, unless
+// it is already scheduled.
+func queuePeaks(path string) {
+	ffmpeg := config.AppConfig.FFmpegPath
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dst := archive.PeaksPath(filepath.Join(config.AppConfig.RecordDir, archive.CacheDirName), id)
+	peaksPending.Lock()
+	defer peaksPending.Unlock()
+	if peaksPending.ids[id] {
+		return
+	}
+	done := func() {
+		peaksPending.Lock()
+		delete(peaksPending.ids, id)
+		peaksPending.Unlock()
+	}
+	if queueArchiveJob(func(ctx context.Context) {
+		defer done()
+		ctx, cancel := context.WithTimeout(ctx, analysisTimeout)
+		defer cancel()
+		if err := archive.GeneratePeaks(ctx, ffmpeg, path, dst); err != nil {
+			log.Printf("Peaks generation for %s failed: %v", path, err)
+		}
+	}) {
+		peaksPending.ids[id] = true
+	}
+}
+
+// archiveJobs queues post-processing work (analysis, transcoding) so it runs
+// on the supervised archive worker instead of on stray goroutines.
+var archiveJobs = make(chan func(ctx context.Context), 64)
+
+// queueArchiveJob queues job, reporting false if the queue was full and
+// it was dropped.
+func queueArchiveJob(job func(ctx context.Context)) bool {
+	select {
+	case archiveJobs <- job:
+		return true
+	default:
+		log.Printf("Archive job queue is full; dropping a job")
+		return false
+	}
+}
+
+// runArchiveJobs executes queued archive jobs one at a time.
+func runArchiveJobs(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case job := <-archiveJobs:
+			job(ctx)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// relayRetryMax caps the wait between attempts to reach an upstream.
+	relayRetryMax = time.Minute
+
+	// relayBusyInterval is how often a relay whose mount has another
+	// source on air checks whether it is free again.
+	relayBusyInterval = time.Second
+
+	// relayTimeout is how long a relay waits for audio before giving the
+	// upstream up for dead and reconnecting.
+	relayTimeout = 10 * time.Second
+
+	// relayAccount is who relayed streams are on air as, in logs, events
+	// and recordings.
+	relayAccount = "relay"
+)
+
+var relayFailures = metrics.NewCounterVec("nickcast_relay_failures_total", "Connections to a relay's upstream that failed or ended.", "mount")
+
+var (
+	// errRelayBusy means the mount has another source on air, or is off
+	// air, so the relay waits without counting a failure.
+	errRelayBusy = errors.New("mount busy")
+	// errRelayKicked means the stream was taken off air, by a takeover or
+	// an admin.
+	errRelayKicked = errors.New("taken off air")
+	// errUpstreamEnded means the upstream ended the stream.
+	errUpstreamEnded = errors.New("upstream ended the stream")
+)
+
+// relayClient has no overall timeout: a relayed stream lasts as long as
+// the upstream's source does.
+var relayClient = &http.Client{}
+
+// runRelays keeps every mount with a relay URL pulling from its upstream.
+func runRelays(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, m := range mounts() {
+		if m.cfg.Relay == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(m *mount) {
+			defer wg.Done()
+			m.runRelay(ctx)
+		}(m)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// runRelay reconnects to the mount's upstream whenever the stream drops,
+// backing off from a second to relayRetryMax while it keeps failing.
+func (m *mount) runRelay(ctx context.Context) {
+	wait := time.Second
+	for ctx.Err() == nil {
+		start := clock.Default.Now()
+		err := m.pullRelay(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		delay := relayBusyInterval
+		if err != errRelayBusy && err != errRelayKicked {
+			relayFailures.With(m.cfg.Name).Inc()
+			if clock.Default.Since(start) > relayRetryMax {
+				wait = time.Second
+			}
+			delay = wait
+			log.Printf("Relaying %s from %s: %v; retrying in %s", m.cfg.Name, redactURL(m.cfg.Relay), err, wait)
+			if wait *= 2; wait > relayRetryMax {
+				wait = relayRetryMax
+			}
+		}
+		t := clock.Default.NewTimer(delay)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// pullRelay puts the mount's upstream on air. A relay is a source like any
+// other: it waits while a streamer is on air, and takes over from the
+// auto-DJ.
+func (m *mount) pullRelay(ctx context.Context) error {
+	if !m.cfg.Windows.Contains(now()) || (m.active() && !m.canTakeOver(0)) {
+		return errRelayBusy
+	}
+	id := "relay-" + strings.TrimPrefix(m.cfg.Name, "/")
+	return m.pullStream(ctx, pullSpec{
+		url:     m.cfg.Relay,
+		account: relayAccount,
+		id:      id,
+		claim: func(remote string, cancel <-chan struct{}) bool {
+			return m.claimOrPreempt(id, remote, relayAccount, cancel)
+		},
+	})
+}
+
+// pullSpec is a remote stream for pullStream to put on air.
+type pullSpec struct {
+	url     string
+	client  *http.Client // relayClient if nil
+	account string       // who it is on air as
+	id      string       // its session ID
+
+	// claim claims the mount for it, reporting whether it could.
+	claim func(remote string, cancel <-chan struct{}) bool
+	// onAir, if set, is called once it is on air.
+	onAir func()
+}
+
+// pullStream connects to a remote stream and, once it answers with audio
+// in the mount's format, puts it on air until either end stops. The
+// upstream's ICY titles become the mount's.
+func (m *mount) pullStream(ctx context.Context, p pullSpec) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Icy-MetaData", "1")
+	req.Header.Set("User-Agent", "NickCast relay")
+	if fromMaster(p.url) {
+		req.Header.Set(relayTokenHeader, config.AppConfig.RelayToken)
+	}
+	client := p.client
+	if client == nil {
+		client = relayClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); extensionFor(ct) != extensionFor(m.cfg.ContentType) {
+		return fmt.Errorf("upstream sends %s, but the mount is %s", ct, m.cfg.ContentType)
+	}
+	metaint := 0
+	if v := resp.Header.Get("icy-metaint"); v != "" {
+		if metaint, err = strconv.Atoi(v); err != nil || metaint <= 0 {
+			return fmt.Errorf("upstream sent an invalid icy-metaint %q", v)
+		}
+	}
+
+	id, account := p.id, p.account
+	remote := req.URL.Host
+	if !p.claim(remote, ctx.Done()) {
+		return errRelayBusy
+	}
+	if _, ok := m.admitResume(""); !ok {
+		// The stream is held for its streamer to come back.
+		m.releaseSource() // Release stream lock
+		return errRelayBusy
+	}
+
+	ice := parseIceInfo(resp.Header)
+	var kicked atomic.Bool
+	sess := m.startSession(account, id, remote, ice.Name, func(reason string) {
+		log.Printf("[%s] Stopping %s on %s: %s", id, account, m.cfg.Name, reason)
+		kicked.Store(true)
+		cancel()
+	})
+	sess.setIceInfo(ice)
+	sess.replicate()
+	defer sess.end()
+	sess.logf("Pulling %s onto %s as %s", req.URL.Redacted(), m.cfg.Name, account)
+	if p.onAir != nil {
+		p.onAir()
+	}
+
+	var body io.Reader = resp.Body
+	if metaint > 0 {
+		body = newICYReader(resp.Body, metaint, func(title string) {
+			if title, keep := m.cfg.TitleFilters.Apply(title); keep {
+				m.playTitle(id, account, title)
+			}
+		})
+	}
+	var stalled atomic.Bool
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		if m.watchRelay(done) {
+			stalled.Store(true)
+			cancel()
+		}
+	}()
+	err = sess.read(body, func() error { cancel(); return nil })
+	switch {
+	case stalled.Load():
+		return fmt.Errorf("upstream sent nothing for %s", relayTimeout)
+	case kicked.Load():
+		return errRelayKicked
+	case err == io.EOF:
+		return errUpstreamEnded
+	}
+	return err
+}
+
+// watchRelay reports true once the upstream has sent nothing for
+// relayTimeout, or false when done is closed.
+func (m *mount) watchRelay(done <-chan struct{}) bool {
+	t := clock.Default.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-t.C():
+		}
+		if clock.Default.Since(time.Unix(0, m.lastData.Load())) > relayTimeout {
+			return true
+		}
+	}
+}
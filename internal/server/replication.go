@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	// Headers a primary's pushes to its standby carry: the shared token,
+	// and the account the broadcaster authenticated as on the primary.
+	replicationTokenHeader   = "X-Replication-Token"
+	replicationAccountHeader = "X-Replication-Account"
+
+	// replicaQueue is how many chunks of audio wait for the standby before
+	// new ones are dropped: several seconds' worth at any bitrate.
+	replicaQueue = 512
+
+	// replicaRetryMax caps the wait between attempts to reach the standby.
+	replicaRetryMax = 30 * time.Second
+
+	// replicaTimeout is how long a standby waits for audio from a replica
+	// before dropping it. A primary whose host dies leaves the connection
+	// open without sending, and the broadcaster's encoder, failing over
+	// to the standby, needs the mount free.
+	replicaTimeout = 10 * time.Second
+)
+
+var (
+	replicationDrops = metrics.NewCounterVec("nickcast_replication_dropped_total", "Chunks of audio not sent to the standby because it fell behind.", "mount")
+	replicationFails = metrics.NewCounterVec("nickcast_replication_failures_total", "Pushes to the standby that failed or were refused.", "mount")
+)
+
+var replicaClient = &http.Client{}
+
+// replicaAccount returns the account a push from the primary is for, if r
+// is one with a valid replication token.
+func replicaAccount(r *http.Request) (string, bool) {
+	token := r.Header.Get(replicationTokenHeader)
+	want := config.AppConfig.ReplicationToken
+	if token == "" {
+		return "", false
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		logf(r, "Replication push from %s refused: wrong replication_token", r.RemoteAddr)
+		return "", false
+	}
+	account := r.Header.Get(replicationAccountHeader)
+	return account, account != ""
+}
+
+// replicator pushes one source session's audio to the standby for as long
+// as the session lasts, reconnecting if the standby drops or refuses it.
+type replicator struct {
+	s      *sourceSession
+	ch     chan []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	dropped bool // chunks have been dropped since the standby last kept up
+}
+
+// replicate starts pushing the session to the standby, if there is one.
+// It is for encoder sessions; a session that is itself a push from a
+// primary is never passed on.
+func (s *sourceSession) replicate() {
+	if config.AppConfig.ReplicateTo == "" {
+		return
+	}
+	r := &replicator{s: s, ch: make(chan []byte, replicaQueue)}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	s.statsMu.Lock()
+	s.standby = r
+	s.statsMu.Unlock()
+	go r.run()
+}
+
+func (s *sourceSession) replicator() *replicator {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.standby
+}
+
+// push queues a chunk for the standby, dropping it if the standby is too
+// far behind; listeners and the rest of the session never wait for it.
+func (r *replicator) push(data []byte) {
+	select {
+	case r.ch <- data:
+	default:
+		replicationDrops.With(r.s.m.cfg.Name).Inc()
+		r.mu.Lock()
+		first := !r.dropped
+		r.dropped = true
+		r.mu.Unlock()
+		if first {
+			r.s.logf("Standby is falling behind on %s; dropping audio for it", r.s.m.cfg.Name)
+		}
+	}
+}
+
+func (r *replicator) stop() {
+	r.cancel()
+}
+
+func (r *replicator) run() {
+	m := r.s.m
+	wait := time.Second
+	for {
+		start := clock.Default.Now()
+		err := r.upload()
+		if r.ctx.Err() != nil {
+			return
+		}
+		replicationFails.With(m.cfg.Name).Inc()
+		if clock.Default.Since(start) > replicaRetryMax {
+			wait = time.Second
+		}
+		r.s.logf("Replicating %s to %s: %v; retrying in %s", m.cfg.Name, config.AppConfig.ReplicateTo, err, wait)
+		t := clock.Default.NewTimer(wait)
+		select {
+		case <-t.C():
+		case <-r.ctx.Done():
+			t.Stop()
+			return
+		}
+		if wait *= 2; wait > replicaRetryMax {
+			wait = replicaRetryMax
+		}
+	}
+}
+
+// upload streams queued audio to the standby's source path in one chunked
+// PUT, until the session ends or the standby goes away.
+func (r *replicator) upload() error {
+	m, s := r.s.m, r.s
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPut, config.AppConfig.ReplicateTo+m.cfg.SourcePath, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", m.cfg.ContentType)
+	req.Header.Set(replicationTokenHeader, config.AppConfig.ReplicationToken)
+	req.Header.Set(replicationAccountHeader, s.account)
+	s.statsMu.Lock()
+	ice := s.ice
+	s.statsMu.Unlock()
+	setIceHeaders(req.Header, ice)
+
+	go func() {
+		for {
+			select {
+			case data := <-r.ch:
+				if _, err := pw.Write(data); err != nil {
+					return
+				}
+				r.mu.Lock()
+				r.dropped = false
+				r.mu.Unlock()
+			case <-r.ctx.Done():
+				// The session is over: end the standby's too.
+				pw.Close()
+				return
+			}
+		}
+	}()
+	resp, err := replicaClient.Do(req)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("standby answered %s", resp.Status)
+	}
+	return fmt.Errorf("standby ended the stream")
+}
+
+// sendTitle passes a title update on to the standby.
+func (r *replicator) sendTitle(title string) {
+	m, s := r.s.m, r.s
+	q := url.Values{"mount": {m.cfg.Name}, "mode": {"updinfo"}, "song": {title}}
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, config.AppConfig.ReplicateTo+"/admin/metadata?"+q.Encode(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set(replicationTokenHeader, config.AppConfig.ReplicationToken)
+	req.Header.Set(replicationAccountHeader, s.account)
+	go func() {
+		ctx, cancel := context.WithTimeout(r.ctx, 10*time.Second)
+		defer cancel()
+		resp, err := replicaClient.Do(req.WithContext(ctx))
+		if err != nil {
+			s.logf("Could not pass title on %s to the standby: %v", m.cfg.Name, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			s.logf("Standby refused title on %s: %s", m.cfg.Name, resp.Status)
+		}
+	}()
+}
+
+// setIceHeaders describes the stream to the standby the way an Icecast
+// source client would.
+func setIceHeaders(h http.Header, ice iceInfo) {
+	for name, v := range map[string]string{
+		"ice-name":        ice.Name,
+		"ice-genre":       ice.Genre,
+		"ice-description": ice.Description,
+		"ice-url":         ice.URL,
+		"ice-audio-info":  ice.AudioInfo,
+	} {
+		if v != "" {
+			h.Set(name, v)
+		}
+	}
+	if ice.Public {
+		h.Set("ice-public", "1")
+	}
+}
+
+// watchReplica drops the mount's replica session if the primary stops
+// sending, until done is closed.
+func (m *mount) watchReplica(done <-chan struct{}) {
+	t := clock.Default.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C():
+		}
+		if clock.Default.Since(time.Unix(0, m.lastData.Load())) > replicaTimeout {
+			m.kickSource("primary stopped sending")
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"nickcast/config"
+	"strings"
+)
+
+// inGroup reports whether a request for path belongs to a response_header
+// group.
+func inGroup(group, path string) bool {
+	switch group {
+	case "all":
+		return true
+	case "listen":
+		for _, m := range mounts() {
+			if path == m.cfg.ListenPath {
+				return true
+			}
+			for _, alias := range m.cfg.Aliases {
+				if path == alias {
+					return true
+				}
+			}
+		}
+		return false
+	case "api":
+		return strings.HasPrefix(path, "/api/")
+	case "admin":
+		return strings.HasPrefix(path, "/admin/") || path == "/admin.cgi" || path == "/metrics"
+	case "archive":
+		return path == "/archive" || strings.HasPrefix(path, "/archive/") || strings.HasPrefix(path, "/clips/")
+	}
+	return strings.HasPrefix(path, group)
+}
+
+// responseHeaderMiddleware applies the response_header lines matching each
+// request. They are set as the response goes out, so they win over the
+// handler's own headers, as a CDN's cache rules must.
+func responseHeaderMiddleware(next http.Handler) http.Handler {
+	if len(config.AppConfig.ResponseHeaders) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var set []config.ResponseHeader
+		for _, h := range config.AppConfig.ResponseHeaders {
+			if inGroup(h.Group, r.URL.Path) {
+				set = append(set, h)
+			}
+		}
+		if len(set) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, set: set}, r)
+	})
+}
+
+// headerWriter sets its headers just before the response is started.
+type headerWriter struct {
+	http.ResponseWriter
+	set  []config.ResponseHeader
+	done bool
+}
+
+func (w *headerWriter) apply() {
+	if w.done {
+		return
+	}
+	w.done = true
+	h := w.Header()
+	seen := make(map[string]bool)
+	for _, rh := range w.set {
+		// The first line for a header replaces the handler's; more lines
+		// add values.
+		if !seen[rh.Name] {
+			h.Del(rh.Name)
+			seen[rh.Name] = true
+		}
+		if rh.Value != "" {
+			h.Add(rh.Name, rh.Value)
+		}
+	}
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Flush() {
+	w.apply()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom keeps sendfile working for file downloads, as statusWriter's
+// does.
+func (w *headerWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.apply()
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
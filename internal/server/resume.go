@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"nickcast/internal/clock"
+	"strconv"
+	"time"
+)
+
+// resumeOffset returns how many bytes of the source's audio have been
+// passed on to listeners: where it should carry on from if it drops and
+// reconnects. In the modes that only pass on whole MP3 frames, a frame cut
+// short by the disconnect never reached anyone, so it isn't counted.
+func (s *sourceSession) resumeOffset() int64 {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	n := s.offset + s.bytes
+	if s.frames != nil && (s.drift != nil || s.m.cfg.SilenceFill > 0 || s.m.cfg.Handoff) {
+		n -= int64(s.frames.Pending())
+	}
+	return n
+}
+
+// resumeAt records that the session's audio carries on from offset bytes
+// into its source's.
+func (s *sourceSession) resumeAt(offset int64) {
+	s.statsMu.Lock()
+	s.offset = offset
+	s.statsMu.Unlock()
+	s.logf("Streamer %s resumed %s at byte %d", s.account, s.m.cfg.Name, offset)
+}
+
+// heldOffset returns the offset a mount in its grace period was left at,
+// if it is held for account.
+func (m *mount) heldOffset(account string) (offset int64, until time.Time, ok bool) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if m.loadState() != stateGrace || account == "" || account != m.graceFor {
+		return 0, time.Time{}, false
+	}
+	return m.graceOffset, m.graceUntil, true
+}
+
+// requestedOffset returns the offset a source asks to resume from, in the
+// X-Resume-Offset header or ?resume_offset=; ok is false if it didn't ask.
+func requestedOffset(r *http.Request) (offset int64, ok bool, err error) {
+	v := r.Header.Get("X-Resume-Offset")
+	if v == "" {
+		v = r.URL.Query().Get("resume_offset")
+	}
+	if v == "" {
+		return 0, false, nil
+	}
+	offset, err = strconv.ParseInt(v, 10, 64)
+	if err == nil && offset < 0 {
+		err = strconv.ErrRange
+	}
+	return offset, true, err
+}
+
+// resumableOffset returns the offset user's dropped session was left at,
+// and whether they may resume it: the mount is in that session's grace
+// period, or claimed from it.
+func (m *mount) resumableOffset(user string) (int64, bool) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	state := m.loadState()
+	held := state == stateGrace || state == stateAuthenticating && m.claimedFrom == stateGrace
+	return m.graceOffset, held && user == m.graceFor
+}
+
+// admitOffset checks the offset a source connection asks to resume from,
+// before it claims the mount, returning it, or 0 if it asked for none. The
+// offset must be the one its own dropped session was left at, with the
+// mount still in that session's grace period; otherwise the source is
+// turned away with a 409 and, if it could resume at all, the offset it
+// should have asked for in X-Resume-Offset.
+func (m *mount) admitOffset(w http.ResponseWriter, r *http.Request, user string) (int64, bool) {
+	offset, asked, err := requestedOffset(r)
+	if !asked {
+		return 0, true
+	}
+	if err != nil {
+		http.Error(w, "Resume offset must be a number of bytes", http.StatusBadRequest)
+		return 0, false
+	}
+	want, held := m.resumableOffset(user)
+	switch {
+	case !held:
+		logf(r, "Streamer %s from %s refused on %s: asked to resume at byte %d, but there is nothing to resume", user, r.RemoteAddr, m.cfg.Name, offset)
+		http.Error(w, "Nothing to resume on "+m.cfg.Name+"; connect without a resume offset", http.StatusConflict)
+		return 0, false
+	case offset != want:
+		logf(r, "Streamer %s from %s refused on %s: asked to resume at byte %d, not %d", user, r.RemoteAddr, m.cfg.Name, offset, want)
+		w.Header().Set("X-Resume-Offset", strconv.FormatInt(want, 10))
+		http.Error(w, "Listeners last heard byte "+strconv.FormatInt(want, 10)+"; resume from there", http.StatusConflict)
+		return 0, false
+	}
+	return offset, true
+}
+
+type resumeInfo struct {
+	Mount          string `json:"mount"`
+	Account        string `json:"account"`
+	Offset         int64  `json:"offset"`
+	GraceRemaining int    `json:"grace_remaining"` // seconds
+}
+
+// resumeHandler serves /api/source/resume?mount=, for a source whose
+// connection dropped: while the mount is held in its reconnect_grace
+// period, it tells the source how many bytes of its audio listeners were
+// sent, so a source playing a file can seek there and reconnect with that
+// offset in X-Resume-Offset, neither repeating nor skipping audio. It
+// takes the same credentials as streaming; there is nothing to resume,
+// and a 404, for any account but the one whose source dropped.
+func resumeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref := r.FormValue("mount")
+	if ref == "" {
+		ref = "/stream"
+	}
+	m := requestMount(r, ref)
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, m.station)
+	if !ok {
+		return
+	}
+	offset, until, ok := m.heldOffset(user)
+	if !ok {
+		http.Error(w, "Nothing to resume on "+m.cfg.Name, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resumeInfo{
+		Mount:          m.cfg.Name,
+		Account:        user,
+		Offset:         offset,
+		GraceRemaining: int(until.Sub(clock.Default.Now()).Seconds() + 0.5),
+	})
+}
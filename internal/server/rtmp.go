@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"nickcast/config"
+	"nickcast/internal/rtmp"
+	"strings"
+	"time"
+)
+
+// rtmpHandshakeTimeout is how long an RTMP client has to ask to publish and
+// then send its first audio.
+const rtmpHandshakeTimeout = 10 * time.Second
+
+// serveRTMP accepts RTMP publishers on rtmp_listen until ctx is cancelled.
+// Tools like OBS speak nothing else, and send video along with the audio;
+// only the audio track goes out.
+func serveRTMP(ctx context.Context) error {
+	addr := config.AppConfig.RTMPListen
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for RTMP sources on %s", addr)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("RTMP accept error: %v", err)
+			time.Sleep(acceptRetry)
+			continue
+		}
+		go func() {
+			id := newRequestID()
+			defer recoverSource("rtmp", id, conn.RemoteAddr().String())
+			rtmpSource(conn, id)
+		}()
+	}
+}
+
+// codecNames names the codecs RTMP sources can send, by file extension.
+var codecNames = map[string]string{
+	".mp3": "MP3",
+	".aac": "AAC",
+}
+
+// rtmpSource runs an RTMP connection. The client publishes to
+// rtmp://host/<mount> with the stream key <nick>:<password>; the mount may
+// be given by name or source path.
+func rtmpSource(nc net.Conn, id string) {
+	defer nc.Close()
+	remote := nc.RemoteAddr().String()
+	logf := func(format string, args ...interface{}) {
+		log.Printf("[%s] "+format, append([]interface{}{id}, args...)...)
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil && bans.banned(addr.Unmap()) {
+			logf("Rejected RTMP source from banned address %s", remote)
+			return
+		}
+	}
+
+	c := rtmp.NewConn(nc)
+	c.SetDeadline(time.Now().Add(rtmpHandshakeTimeout))
+	pub, err := c.ReadPublish()
+	if err != nil {
+		logf("RTMP source from %s did not publish: %v", remote, err)
+		return
+	}
+	app, _, _ := strings.Cut(pub.App, "?")
+	m := findMount(app)
+	if m == nil {
+		m = findMount("/" + app)
+	}
+	if m == nil || m.cfg.DownmixOf != "" {
+		logf("RTMP source from %s refused: no mount %q", remote, app)
+		c.Reject(rtmp.StatusDenied, "There is no mount "+app+"; publish to rtmp://<host>/<mount>")
+		return
+	}
+	ext := extensionFor(m.cfg.ContentType)
+	codec, ok := codecNames[ext]
+	if !ok {
+		logf("RTMP source from %s refused: %s broadcasts %s, which RTMP can't carry", remote, m.cfg.Name, m.cfg.ContentType)
+		c.Reject(rtmp.StatusDenied, "Mount "+m.cfg.Name+" broadcasts "+m.cfg.ContentType+", which can't be sent over RTMP")
+		return
+	}
+	if len(m.cfg.SourceHeaders) > 0 {
+		logf("RTMP source from %s refused: %s requires source_header, which RTMP can't send", remote, m.cfg.Name)
+		c.Reject(rtmp.StatusDenied, "Mount "+m.cfg.Name+" only takes HTTP sources")
+		return
+	}
+
+	user, pass, ok := strings.Cut(pub.Key, ":")
+	if !ok {
+		logf("RTMP source from %s refused: stream key is not <nick>:<password>", remote)
+		c.Reject(rtmp.StatusDenied, "Set the stream key to <nick>:<password>")
+		return
+	}
+	valid, err := m.station.authenticate(user, pass)
+	if err != nil || !valid {
+		logf("Auth failed for user %s from %s: %v", user, remote, err)
+		c.Reject(rtmp.StatusDenied, "Invalid nick or password")
+		return
+	}
+	a, ref := m.admitSource(id, user, remote, sourceAsk{})
+	if ref != nil {
+		rejectRTMP(c, ref)
+		return
+	}
+	if err := c.Accept(); err != nil {
+		return
+	}
+
+	// The client has been told to go ahead, but its audio may still be in
+	// a codec the mount doesn't broadcast: OBS sends AAC unless told
+	// otherwise.
+	got, err := c.Codec()
+	var unsupported *rtmp.UnsupportedCodecError
+	switch {
+	case errors.As(err, &unsupported):
+		logf("Streamer %s from %s refused on %s: %v", user, remote, m.cfg.Name, err)
+		c.Reject(rtmp.StatusDenied, "The audio is "+unsupported.Codec+"; set the encoder's audio codec to "+codec)
+		return
+	case err != nil:
+		logf("Streamer read error for %s from %s: %v", user, remote, err)
+		return
+	case "."+got != ext:
+		logf("Streamer %s from %s refused on %s: the audio is %s, not %s", user, remote, m.cfg.Name, codecNames["."+got], codec)
+		c.Reject(rtmp.StatusDenied, "Mount "+m.cfg.Name+" broadcasts "+codec+"; set the encoder's audio codec to "+codec)
+		return
+	}
+	// Only one streamer at a time, unless the new one takes over.
+	if ref := a.claim(nil); ref != nil {
+		rejectRTMP(c, ref)
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	logf("Streamer %s connected to %s from %s over RTMP", user, m.cfg.Name, remote)
+	kick := func(reason string) {
+		logf("Disconnecting streamer %s from %s: %s", user, m.cfg.Name, reason)
+		if err := c.SetReadDeadline(time.Now()); err != nil {
+			logf("Could not interrupt streamer %s: %v", user, err)
+		}
+	}
+	sess := m.startSession(user, id, remote, "", kick)
+	sess.replicate()
+	defer sess.end()
+
+	err = sess.read(c, func() error { return c.SetReadDeadline(time.Now()) })
+	logf("Streamer read error for %s from %s: %v", user, remote, err)
+}
+
+// rejectRTMP turns an RTMP publisher away as admitSource said: a mount
+// that's taken is a name in use, anything else a plain refusal.
+func rejectRTMP(c *rtmp.Conn, ref *sourceRefusal) {
+	code := rtmp.StatusDenied
+	if ref.status == http.StatusConflict {
+		code = rtmp.StatusBadName
+	}
+	c.Reject(code, ref.msg)
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"net"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"nickcast/internal/mp3"
+	"sync"
+	"time"
+)
+
+const (
+	// rtpPayloadDynamic is the payload type sent for formats RTP has no
+	// static type for; receivers are told the format out of band.
+	rtpPayloadDynamic = 96
+
+	// rtpMaxPayload keeps packets inside a 1500-byte Ethernet MTU.
+	rtpMaxPayload = 1400
+
+	// rtpQueue is how many chunks of the stream may wait to be sent before
+	// they are dropped.
+	rtpQueue = 256
+
+	// rtpClockRate is the RTP timestamp rate: 90 kHz, as RFC 2250 has it
+	// for MPEG audio.
+	rtpClockRate = 90000
+)
+
+var (
+	rtpPacketsSent = metrics.NewCounterVec("nickcast_rtp_output_packets_total", "RTP packets sent by mounts with rtp_output.", "mount")
+	rtpDropped     = metrics.NewCounterVec("nickcast_rtp_output_dropped_bytes_total", "Audio not sent on rtp_output because sending fell behind or failed.", "mount")
+)
+
+// rtpOutput sends what a mount broadcasts as RTP to its rtp_output
+// address. MP3 goes out as RFC 2250 MPEG audio (payload type 14), in whole
+// frames; other formats as payload type 96. Like the broadcast, it never
+// blocks: audio that can't be sent in time is dropped.
+type rtpOutput struct {
+	m       *mount
+	conn    *net.UDPConn
+	ch      chan []byte
+	pt      byte
+	seq     uint16
+	ssrc    uint32
+	start   time.Time
+	buf     []byte
+	partial []byte // the start of an MP3 frame the next chunk completes
+	next    uint32 // the timestamp of the next MP3 frame
+}
+
+// newRTPOutput opens m's rtp_output address for sending.
+func newRTPOutput(m *mount) (*rtpOutput, error) {
+	addr, err := net.ResolveUDPAddr("udp", m.cfg.RTPOutput)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP.IsMulticast() {
+		if err := setMulticastTTL(conn, addr.IP.To4() == nil, m.cfg.RTPTTL); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	o := &rtpOutput{
+		m:     m,
+		conn:  conn,
+		ch:    make(chan []byte, rtpQueue),
+		pt:    rtpPayloadDynamic,
+		seq:   uint16(rand.Uint32()),
+		ssrc:  rand.Uint32(),
+		start: clock.Default.Now(),
+		buf:   make([]byte, 0, 12+4+rtpMaxPayload),
+	}
+	if extensionFor(m.cfg.ContentType) == ".mp3" {
+		o.pt = rtpPayloadMPA
+	}
+	return o, nil
+}
+
+// write queues a chunk of the broadcast for sending.
+func (o *rtpOutput) write(data []byte) {
+	select {
+	case o.ch <- data:
+	default:
+		rtpDropped.With(o.m.cfg.Name).Add(int64(len(data)))
+	}
+}
+
+// run sends queued audio until ctx is cancelled.
+func (o *rtpOutput) run(ctx context.Context) {
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-o.ch:
+			err := o.send(data)
+			switch {
+			case err != nil && !failing:
+				log.Printf("Mount %s: RTP output to %s failing: %v", o.m.cfg.Name, o.m.cfg.RTPOutput, err)
+			case err == nil && failing:
+				log.Printf("Mount %s: RTP output to %s recovered", o.m.cfg.Name, o.m.cfg.RTPOutput)
+			}
+			failing = err != nil
+		}
+	}
+}
+
+// send packetizes data. MP3 is cut on frame boundaries, as RFC 2250 asks:
+// a packet carries whole frames, or a frame too big for one packet is
+// split across several with its fragment offset. Other formats are cut
+// wherever a packet is full, and receivers resynchronize as they do over
+// HTTP.
+func (o *rtpOutput) send(data []byte) error {
+	if o.pt != rtpPayloadMPA {
+		ts := o.timestamp()
+		for len(data) > 0 {
+			n := len(data)
+			if n > rtpMaxPayload {
+				n = rtpMaxPayload
+			}
+			if err := o.sendPacket(ts, nil, data[:n]); err != nil {
+				rtpDropped.With(o.m.cfg.Name).Add(int64(len(data)))
+				return err
+			}
+			data = data[n:]
+		}
+		return nil
+	}
+
+	if len(o.partial) > 0 {
+		data = append(o.partial, data...)
+		o.partial = nil
+	}
+	// Frames are timed by their samples, so the timestamps keep pace with
+	// the audio, except after a gap in the broadcast, when they catch up
+	// with the clock.
+	ts := o.next
+	if now := o.timestamp(); int32(now-ts) > rtpClockRate {
+		ts = now
+	}
+	defer func() { o.next = ts }()
+	var frames []byte // whole frames waiting to fill a packet
+	var start uint32  // the first of their timestamps
+	flush := func() error {
+		if len(frames) == 0 {
+			return nil
+		}
+		err := o.sendPacket(start, []byte{0, 0, 0, 0}, frames)
+		if err != nil {
+			o.dropped(err, frames)
+		}
+		frames = nil
+		return err
+	}
+	for len(data) >= mp3.HeaderSize {
+		h, ok := mp3.ParseHeader(data)
+		if !ok {
+			// Not a frame: skip to the next header, keeping what may be
+			// the start of one at the end.
+			i := 1
+			for ; i+mp3.HeaderSize <= len(data); i++ {
+				if _, ok := mp3.ParseHeader(data[i:]); ok {
+					break
+				}
+			}
+			data = data[i:]
+			continue
+		}
+		if len(data) < h.FrameSize {
+			break
+		}
+		frame := data[:h.FrameSize]
+		data = data[h.FrameSize:]
+		if len(frames)+len(frame) > rtpMaxPayload {
+			if err := flush(); err != nil {
+				return o.dropped(err, frame, data)
+			}
+		}
+		if len(frame) <= rtpMaxPayload {
+			if len(frames) == 0 {
+				start = ts
+			}
+			frames = append(frames, frame...)
+		} else {
+			// Bigger than a packet: fragments, each saying where in the
+			// frame it starts.
+			for off := 0; off < len(frame); off += rtpMaxPayload {
+				end := off + rtpMaxPayload
+				if end > len(frame) {
+					end = len(frame)
+				}
+				hdr := []byte{0, 0, byte(off >> 8), byte(off)}
+				if err := o.sendPacket(ts, hdr, frame[off:end]); err != nil {
+					return o.dropped(err, frame[off:], data)
+				}
+			}
+		}
+		ts += uint32(h.Samples * rtpClockRate / h.SampleRate)
+	}
+	if err := flush(); err != nil {
+		return o.dropped(err, nil, data)
+	}
+	if len(data) > 0 {
+		o.partial = append([]byte(nil), data...)
+	}
+	return nil
+}
+
+// timestamp is the RTP timestamp for now.
+func (o *rtpOutput) timestamp() uint32 {
+	// Whole seconds and the rest apart, so the sum wraps as RTP expects
+	// rather than overflowing.
+	since := clock.Default.Since(o.start)
+	return uint32(int64(since/time.Second)*rtpClockRate + int64(since%time.Second)*rtpClockRate/int64(time.Second))
+}
+
+// sendPacket sends one packet of payload, after hdr if there is one.
+func (o *rtpOutput) sendPacket(ts uint32, hdr, payload []byte) error {
+	pkt := append(o.buf[:0], 0x80, o.pt, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(pkt[2:], o.seq)
+	binary.BigEndian.PutUint32(pkt[4:], ts)
+	binary.BigEndian.PutUint32(pkt[8:], o.ssrc)
+	pkt = append(pkt, hdr...)
+	pkt = append(pkt, payload...)
+	o.seq++
+	if _, err := o.conn.Write(pkt); err != nil {
+		return err
+	}
+	rtpPacketsSent.With(o.m.cfg.Name).Inc()
+	return nil
+}
+
+// dropped counts what a failed send left unsent and returns err.
+func (o *rtpOutput) dropped(err error, unsent ...[]byte) error {
+	n := 0
+	for _, b := range unsent {
+		n += len(b)
+	}
+	rtpDropped.With(o.m.cfg.Name).Add(int64(n))
+	return err
+}
+
+// runRTPOutputs sends every rtp_output mount's broadcast until ctx is
+// cancelled.
+func runRTPOutputs(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, m := range mounts() {
+		if m.rtpOut == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(o *rtpOutput) {
+			defer wg.Done()
+			o.run(ctx)
+		}(m.rtpOut)
+	}
+	wg.Wait()
+	return nil
+}
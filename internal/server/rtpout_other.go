@@ -0,0 +1,11 @@
+//go:build !unix
+
+package server
+
+import "net"
+
+// setMulticastTTL leaves the system's multicast TTL, normally 1, where
+// setting it isn't supported.
+func setMulticastTTL(conn *net.UDPConn, ipv6 bool, ttl int) error {
+	return nil
+}
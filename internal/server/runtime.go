@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/crash"
+	"nickcast/internal/metrics"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// runtimeSampleInterval is how often goroutines and file descriptors are
+// checked against their soft limits.
+const runtimeSampleInterval = 30 * time.Second
+
+// Subsystems whose live goroutines are counted. Each is incremented when
+// one starts and decremented when it returns, so a count that keeps
+// growing points straight at the leak.
+const (
+	subsysRequests  = "http_requests"   // every in-flight HTTP handler
+	subsysListeners = "listeners"       // listener handlers, waiting or streaming
+	subsysSources   = "sources"         // encoders, shows and announcements
+	subsysRecorders = "recorders"       // open recordings
+	subsysRelays    = "dead_air_relays" // fallback audio relayed during dead air
+)
+
+var subsystems = []string{subsysRequests, subsysListeners, subsysSources, subsysRecorders, subsysRelays}
+
+var (
+	activeGauge = metrics.NewGaugeVec("nickcast_active", "Live goroutines or resources per subsystem.", "subsystem")
+	_           = metrics.NewGaugeFunc("nickcast_goroutines", "Goroutines in the process.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	_ = metrics.NewGaugeFunc("nickcast_open_fds", "Open file descriptors, or -1 where they can't be counted.", func() float64 {
+		return float64(openFDs())
+	})
+)
+
+// track counts one more of subsystem as running and returns the func that
+// counts it as gone, for use as defer track(subsysListeners)().
+func track(subsystem string) func() {
+	g := activeGauge.With(subsystem)
+	g.Add(1)
+	return func() { g.Add(-1) }
+}
+
+// openFDs counts the process's open file descriptors. It needs /proc, so
+// it returns -1 on systems without it.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// runtimeInfo is what /admin/runtime reports.
+type runtimeInfo struct {
+	Goroutines         int              `json:"goroutines"`
+	GoroutineSoftLimit int              `json:"goroutine_soft_limit,omitempty"`
+	OpenFDs            int              `json:"open_fds"` // -1 if unknown
+	FDSoftLimit        int              `json:"fd_soft_limit,omitempty"`
+	Subsystems         map[string]int64 `json:"subsystems"`
+	// Listeners registered with a mount; a listeners subsystem count far
+	// above this means handlers are hanging around without an audience.
+	RegisteredListeners int `json:"registered_listeners"`
+}
+
+func currentRuntime() runtimeInfo {
+	info := runtimeInfo{
+		Goroutines:         runtime.NumGoroutine(),
+		GoroutineSoftLimit: config.AppConfig.GoroutineSoftLimit,
+		OpenFDs:            openFDs(),
+		FDSoftLimit:        config.AppConfig.FDSoftLimit,
+		Subsystems:         make(map[string]int64),
+	}
+	for _, s := range subsystems {
+		info.Subsystems[s] = activeGauge.With(s).Value()
+	}
+	for _, m := range mounts() {
+		info.RegisteredListeners += m.listenerCount()
+	}
+	return info
+}
+
+// summary lists the subsystem counts for log lines, e.g.
+// "http_requests=3 listeners=1 ...".
+func (info runtimeInfo) summary() string {
+	parts := make([]string, len(subsystems))
+	for i, s := range subsystems {
+		parts[i] = fmt.Sprintf("%s=%d", s, info.Subsystems[s])
+	}
+	return strings.Join(parts, " ")
+}
+
+// runtimeHandler serves /admin/runtime for default station admins.
+func runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(currentRuntime())
+}
+
+// watchRuntime warns when goroutines or file descriptors pass their soft
+// limits, once per crossing, with the per-subsystem counts that say where
+// they went.
+func watchRuntime(ctx context.Context) error {
+	if config.AppConfig.GoroutineSoftLimit <= 0 && config.AppConfig.FDSoftLimit <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	t := clock.Default.NewTicker(runtimeSampleInterval)
+	defer t.Stop()
+	var overGoroutines, overFDs bool
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		info := currentRuntime()
+		if limit := info.GoroutineSoftLimit; limit > 0 {
+			over := info.Goroutines > limit
+			if over && !overGoroutines {
+				log.Printf("Warning: %d goroutines, over goroutine_soft_limit of %d; by subsystem %s, %d listeners registered", info.Goroutines, limit, info.summary(), info.RegisteredListeners)
+			} else if !over && overGoroutines {
+				log.Printf("Goroutines back under goroutine_soft_limit (%d of %d)", info.Goroutines, limit)
+			}
+			overGoroutines = over
+		}
+		if limit := info.FDSoftLimit; limit > 0 && info.OpenFDs >= 0 {
+			over := info.OpenFDs > limit
+			if over && !overFDs {
+				log.Printf("Warning: %d open file descriptors, over fd_soft_limit of %d; by subsystem %s", info.OpenFDs, limit, info.summary())
+			} else if !over && overFDs {
+				log.Printf("Open file descriptors back under fd_soft_limit (%d of %d)", info.OpenFDs, limit)
+			}
+			overFDs = over
+		}
+	}
+}
+
+// addCrashSections puts the server's state into crash bundles: the runtime
+// counts, every metric, and what each connected source is sending.
+func addCrashSections() {
+	crash.AddSection("runtime.json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(currentRuntime())
+	})
+	crash.AddSection("metrics.txt", func(w io.Writer) error {
+		metrics.WriteAll(w)
+		return nil
+	})
+	crash.AddSection("sources.json", func(w io.Writer) error {
+		list := []sourceDiagnostics{}
+		for _, m := range mounts() {
+			if s := m.currentSession(); s != nil {
+				list = append(list, s.diagnostics())
+			}
+		}
+		return json.NewEncoder(w).Encode(list)
+	})
+}
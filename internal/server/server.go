@@ -1,287 +1,1077 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"nickcast/config"
-	"nickcast/internal/NickServAuth"
+	"nickcast/internal/archive"
+	"nickcast/internal/events"
+	"nickcast/internal/fingerprint"
+	"nickcast/internal/metrics"
+	"nickcast/internal/nowplaying"
+	"nickcast/internal/policy"
+	"nickcast/internal/purge"
+	"nickcast/internal/shows"
+	"nickcast/internal/supervisor"
+	"nickcast/internal/transcribe"
+	"nickcast/internal/tts"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
 )
 
 const (
-	// maxRingBufferSize determines how much recent audio data to buffer for new listeners.
-	// This should be enough to satisfy a player's initial buffering requirements.
-	// A few kilobytes (e.g., 64KB, 128KB, 256KB) is often a good starting point for MP3.
-	// You might need to tune this based on your audio bitrate and player.
-	maxRingBufferSize = 128 * 1024 // 128 KB
+	// shutdownGrace is how long the HTTP server waits for in-flight requests
+	// before forcibly closing streaming connections on shutdown.
+	shutdownGrace = 5 * time.Second
 )
 
-var (
-	listeners   = make(map[chan []byte]struct{})
-	listenersMu sync.Mutex
+// Start registers the server's long-lived goroutines with the supervisor.
+// It returns immediately; the supervisor owns their lifecycle from here on.
+func Start(sup *supervisor.Supervisor) error {
+	if err := bans.loadStatic(config.AppConfig.Bans); err != nil {
+		return err
+	}
+	if err := loadGeo(); err != nil {
+		return err
+	}
+	if err := loadLocales(); err != nil {
+		return err
+	}
+
+	for _, sc := range config.AppConfig.Stations {
+		stations[sc.Name] = newStation(sc)
+	}
+	if err := loadPlayers(); err != nil {
+		return err
+	}
+
+	if config.AppConfig.Master != "" {
+		discoverMaster()
+	}
+
+	mux := http.NewServeMux()
+	for _, mc := range config.AppConfig.Mounts {
+		// Each mount starts idle, with an empty ring buffer and a stream
+		// ready for its first source.
+		m := newMount(mc)
+		m.station = stations[mc.Station]
+		addMount(m)
+		m.route(mux)
+	}
+	for _, mv := range config.AppConfig.Moves {
+		mux.HandleFunc(mv.From, movedHandler(mv))
+	}
+	if err := checkMemoryBudget(); err != nil {
+		return err
+	}
+	rtpOutputs := false
+	for _, m := range mounts() {
+		if m.cfg.RTPOutput == "" {
+			continue
+		}
+		var err error
+		if m.rtpOut, err = newRTPOutput(m); err != nil {
+			return fmt.Errorf("mount %s: rtp_output: %w", m.cfg.Name, err)
+		}
+		log.Printf("Mount %s: RTP output to %s", m.cfg.Name, m.cfg.RTPOutput)
+		rtpOutputs = true
+	}
+	for _, m := range mounts() {
+		if err := m.startPackagers(); err != nil {
+			return err
+		}
+	}
+	mux.HandleFunc(hlsPrefix+"/", hlsHandler)
+	mux.HandleFunc(dashPrefix+"/", dashHandler)
+	addCrashSections()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/admin/metadata", metadataHandler)
+	mux.HandleFunc("/admin/bans", bansHandler)
+	mux.HandleFunc("/api/stations", stationsHandler)
+	mux.HandleFunc("/api/compat", compatHandler)
+	mux.HandleFunc("/nowplaying.txt", nowPlayingTextHandler)
+	mux.HandleFunc("/player", playerHandler)
+	mux.HandleFunc("/api/relay/mounts", relayMountsHandler)
+	mux.HandleFunc("/api/openapi.json", openAPIHandler)
+	mux.HandleFunc("/admin/preview", previewHandler)
+	mux.HandleFunc("/admin/diagnostics", diagnosticsHandler)
+	mux.HandleFunc("/admin/quotas", quotasHandler)
+	mux.HandleFunc("/admin/listclients", listClientsHandler)
+	mux.HandleFunc("/admin/stats", statsHandler)
+	mux.HandleFunc("/admin/stats.xml", statsHandler)
+	mux.HandleFunc("/admin/runtime", runtimeHandler)
+	mux.HandleFunc("/admin/state", stateHandler)
+	mux.HandleFunc("/admin/variants", variantsHandler)
+	mux.HandleFunc("/admin/clip", clipHandler)
+	mux.HandleFunc("/admin/capture", captureHandler)
+	mux.HandleFunc("/clips/", clipsFileHandler)
+	mux.HandleFunc("/admin/announce", announceHandler)
+	mux.HandleFunc("/admin/slots", slotsHandler)
+	mux.HandleFunc("/admin/bookings", bookingsHandler)
+	mux.HandleFunc("/admin/pull", pullHandler)
+	synth = tts.New(config.AppConfig.TTSCommand, config.AppConfig.TTSURL)
+	script = policy.New(config.AppConfig.PolicyScript, time.Duration(config.AppConfig.PolicyTimeout)*time.Millisecond)
+	recognizer = fingerprint.New(config.AppConfig.FingerprintCommand, config.AppConfig.FingerprintURL)
+	transcriber = transcribe.New(config.AppConfig.TranscribeCommand, config.AppConfig.TranscribeURL)
+	for _, m := range mounts() {
+		if m.cfg.Transcribe {
+			m.captions = newCaptionFeed()
+		}
+	}
+	mux.HandleFunc("/api/captions", captionsHandler)
+	mux.HandleFunc("/api/source/check", sourceCheckHandler)
+	mux.HandleFunc("/api/source/heartbeat", heartbeatHandler)
+	mux.HandleFunc("/api/source/resume", resumeHandler)
+	mux.HandleFunc("/api/source/handoff", handoffHandler)
+	if config.AppConfig.ShoutcastMount != "" {
+		mux.HandleFunc("/admin.cgi", shoutcastMetadataHandler)
+	}
+	if config.AppConfig.Archive {
+		if ffmpeg := config.AppConfig.FFmpegPath; ffmpeg != "" {
+			transcoder = archive.NewTranscoder(ffmpeg, filepath.Join(config.AppConfig.RecordDir, archive.CacheDirName))
+		}
+		mux.HandleFunc("/archive", archiveHandler)
+		mux.HandleFunc("/archive/", archiveHandler)
+	}
 
-	firstData     chan struct{} // Closed when the first stream data is received.
-	firstDataOnce sync.Once     // Ensures firstData is closed only once per stream session.
+	if dir := config.AppConfig.ShowsDir; dir != "" {
+		st, err := shows.NewStore(dir)
+		if err != nil {
+			return err
+		}
+		showStore = st
+		mux.HandleFunc("/api/shows", showsHandler)
+	}
 
-	streamActive atomic.Bool // Atomic boolean to indicate if a streamer is actively sending data.
+	// Restored before an import, so imported state is stored in turn.
+	if spec := config.AppConfig.Store; spec != "" {
+		if err := openStore(spec); err != nil {
+			return err
+		}
+		// The store stops after everything that might still change what
+		// it keeps.
+		sup.Go(supervisor.Spec{
+			Name:    "store",
+			Order:   20,
+			Restart: supervisor.Always,
+			Run:     runStore,
+		})
+	}
 
-	streamCancelFn context.CancelFunc // Function to cancel the context for active listeners.
-	streamCtx      context.Context    // The context for the current stream.
-	streamCtxMu    sync.Mutex         // Protects streamCtx and streamCancelFn
+	// Imported last, once bans, mounts and the show store are in place.
+	if path := config.AppConfig.ImportState; path != "" {
+		if err := importState(path); err != nil {
+			return err
+		}
+	}
 
-	// ringBuffer stores the most recent audio data for new listeners.
-	ringBuffer    *bytes.Buffer
-	ringBufferMu  sync.Mutex
-)
+	registerHosts()
+	registerProxies(mux)
+
+	handler := requestIDMiddleware(responseHeaderMiddleware(apiErrorMiddleware(recoverMiddleware(banMiddleware(hostMiddleware(mux))))))
+	srv := &http.Server{
+		Addr:    config.AppConfig.ListenAddress,
+		Handler: handler,
+	}
+
+	// The HTTP server stops first so no new sources or listeners arrive
+	// while the rest of the application winds down.
+	sup.Go(supervisor.Spec{
+		Name:     "http",
+		Order:    0,
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			return serveHTTP(ctx, srv)
+		},
+	})
+
+	if path := config.AppConfig.TapSocket; path != "" {
+		sup.Go(supervisor.Spec{
+			Name:    "tap",
+			Order:   0,
+			Restart: supervisor.OnFailure,
+			Run: func(ctx context.Context) error {
+				return serveTap(ctx, path)
+			},
+		})
+	}
+
+	if addr := config.AppConfig.TLSListen; addr != "" {
+		tc, err := tlsConfig()
+		if err != nil {
+			return err
+		}
+		tlsSrv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tc}
+		sup.Go(supervisor.Spec{
+			Name:     "https",
+			Order:    0,
+			Critical: true,
+			Run: func(ctx context.Context) error {
+				return serveHTTPS(ctx, tlsSrv)
+			},
+		})
+	}
+
+	if config.AppConfig.ShoutcastMount != "" {
+		sup.Go(supervisor.Spec{
+			Name:     "shoutcast",
+			Order:    0,
+			Critical: true,
+			Run:      serveShoutcast,
+		})
+	}
+
+	if config.AppConfig.SRTListen != "" {
+		sup.Go(supervisor.Spec{
+			Name:     "srt",
+			Order:    0,
+			Critical: true,
+			Run:      serveSRT,
+		})
+	}
+
+	if config.AppConfig.RTMPListen != "" {
+		sup.Go(supervisor.Spec{
+			Name:     "rtmp",
+			Order:    0,
+			Critical: true,
+			Run:      serveRTMP,
+		})
+	}
+
+	if config.AppConfig.SNMPListen != "" {
+		sup.Go(supervisor.Spec{
+			Name:     "snmp",
+			Order:    0,
+			Critical: true,
+			Run:      serveSNMP,
+		})
+	}
+
+	if config.AppConfig.WebhookURL != "" {
+		hook := events.NewWebhook(config.AppConfig.WebhookURL)
+		// Notifiers stop last so they can still report the shutdown itself.
+		sup.Go(supervisor.Spec{
+			Name:    "webhook",
+			Order:   10,
+			Restart: supervisor.OnFailure,
+			Run:     hook.Run,
+		})
+	}
+
+	if p := config.AppConfig.PurgeProvider; p != "" {
+		purger = purge.New(p, config.AppConfig.PurgeZone, config.AppConfig.PurgeToken)
+		events.Subscribe(purgeOnEvent)
+		sup.Go(supervisor.Spec{
+			Name:    "purge",
+			Order:   10,
+			Restart: supervisor.OnFailure,
+			Run:     purger.Run,
+		})
+	}
+
+	if config.AppConfig.HLSPushURL != "" {
+		sup.Go(supervisor.Spec{
+			Name:    "hls-push",
+			Order:   1,
+			Restart: supervisor.Always,
+			Run:     runHLSPush,
+		})
+	}
+
+	for _, svc := range config.AppConfig.NowPlaying {
+		n := nowplaying.New(svc)
+		sup.Go(supervisor.Spec{
+			Name:    "nowplaying-" + svc.Name,
+			Order:   10,
+			Restart: supervisor.OnFailure,
+			Run:     n.Run,
+		})
+	}
+
+	if script != nil {
+		// The script hears about the shutdown too, so it stops with the
+		// notifiers.
+		sup.Go(supervisor.Spec{
+			Name:    "policy",
+			Order:   10,
+			Restart: supervisor.OnFailure,
+			Run:     script.Run,
+		})
+	}
+
+	// Recorders stop after the broadcast so in-flight sessions can finish
+	// writing before queued analysis is abandoned.
+	sup.Go(supervisor.Spec{
+		Name:    "archive",
+		Order:   3,
+		Restart: supervisor.Always,
+		Run:     runArchiveJobs,
+	})
+
+	if showStore != nil {
+		// Scheduled shows are sources like any other and end with the
+		// broadcast.
+		sup.Go(supervisor.Spec{
+			Name:    "scheduler",
+			Order:   1,
+			Restart: supervisor.Always,
+			Run:     runScheduler,
+		})
+	}
 
-func Start() {
-	// Initialize firstData channel and ring buffer at startup
-	resetStreamState()
+	sup.Go(supervisor.Spec{
+		Name:    "announcer",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     runAnnouncer,
+	})
 
-	http.HandleFunc("/stream", streamHandler)
-	http.HandleFunc("/listen", listenHandler)
-	log.Printf("Listening on %s", config.AppConfig.ListenAddress)
-	log.Fatal(http.ListenAndServe(config.AppConfig.ListenAddress, nil))
+	sup.Go(supervisor.Spec{
+		Name:    "autodj",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     runAutoDJ,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "relays",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     runRelays,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "pulls",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     runPulls,
+	})
+
+	for _, m := range mounts() {
+		if m.cfg.UDPListen != "" {
+			sup.Go(supervisor.Spec{
+				Name:    "udp",
+				Order:   1,
+				Restart: supervisor.Always,
+				Run:     serveUDP,
+			})
+			break
+		}
+	}
+
+	if rtpOutputs {
+		sup.Go(supervisor.Spec{
+			Name:    "rtp-output",
+			Order:   1,
+			Restart: supervisor.Always,
+			Run:     runRTPOutputs,
+		})
+	}
+
+	for _, m := range mounts() {
+		if m.cfg.DownmixOf != "" {
+			sup.Go(supervisor.Spec{
+				Name:    "downmix",
+				Order:   1,
+				Restart: supervisor.Always,
+				Run:     runDownmixes,
+			})
+			break
+		}
+	}
+
+	if config.AppConfig.Master != "" {
+		sup.Go(supervisor.Spec{
+			Name:    "master",
+			Order:   5,
+			Restart: supervisor.Always,
+			Run: func(ctx context.Context) error {
+				return watchMaster(ctx, mux)
+			},
+		})
+	}
+
+	sup.Go(supervisor.Spec{
+		Name:    "dead-air",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchDeadAir,
+	})
+
+	if recognizer != nil {
+		sup.Go(supervisor.Spec{
+			Name:    "fingerprint",
+			Order:   1,
+			Restart: supervisor.Always,
+			Run:     runFingerprinter,
+		})
+	}
+
+	if transcriber != nil {
+		sup.Go(supervisor.Spec{
+			Name:    "transcribe",
+			Order:   1,
+			Restart: supervisor.Always,
+			Run:     runTranscriber,
+		})
+	}
+
+	sup.Go(supervisor.Spec{
+		Name:    "slots",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchSlots,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "bookings",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchBookings,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "heartbeats",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchHeartbeats,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "ingest",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchIngest,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "silence-fill",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchSourceGaps,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "silence-detect",
+		Order:   1,
+		Restart: supervisor.Always,
+		Run:     watchSilentSources,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "windows",
+		Order:   5,
+		Restart: supervisor.Always,
+		Run:     enforceWindows,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "quotas",
+		Order:   5,
+		Restart: supervisor.Always,
+		Run:     sampleQuotas,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "runtime",
+		Order:   5,
+		Restart: supervisor.Always,
+		Run:     watchRuntime,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "churn-janitor",
+		Order:   5,
+		Restart: supervisor.Always,
+		Run:     churn.janitor,
+	})
+
+	sup.Go(supervisor.Spec{
+		Name:    "nowplaying-janitor",
+		Order:   5,
+		Restart: supervisor.Always,
+		Run:     nowPlayingLimiter.janitor,
+	})
+
+	// The broadcast service owns the stream contexts; stopping it ends any
+	// active streams so listeners and the streamer handlers unwind cleanly.
+	sup.Go(supervisor.Spec{
+		Name:  "broadcast",
+		Order: 1,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			for _, m := range mounts() {
+				m.stop()
+			}
+			return nil
+		},
+	})
+	return nil
+}
+
+// serveHTTP runs srv until ctx is cancelled, then shuts it down gracefully.
+// Streaming connections never finish on their own, so anything still open
+// after shutdownGrace is closed forcibly.
+func serveHTTP(ctx context.Context, srv *http.Server) error {
+	return serve(ctx, srv, func() error {
+		log.Printf("Listening on %s", srv.Addr)
+		return srv.ListenAndServe()
+	})
 }
 
-// resetStreamState resets the channels and buffers for a new stream session.
-// This should be called when a new stream is expected to start.
-func resetStreamState() {
-	firstDataOnce = sync.Once{}
-	firstData = make(chan struct{})
+// serve runs listen until ctx is cancelled, then shuts srv down gracefully.
+func serve(ctx context.Context, srv *http.Server, listen func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listen()
+	}()
 
-	ringBufferMu.Lock()
-	ringBuffer = bytes.NewBuffer(make([]byte, 0, maxRingBufferSize)) // Initialize with capacity
-	ringBufferMu.Unlock()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
 
-	// Ensure streamCtx and streamCancelFn are initialized for immediate use
-	// even before a streamer connects, to avoid nil pointer issues.
-	streamCtxMu.Lock()
-	if streamCancelFn != nil {
-		streamCancelFn() // Cancel any existing context
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server did not shut down cleanly: %v", err)
+		srv.Close()
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
-	streamCtx, streamCancelFn = context.WithCancel(context.Background())
-	streamCtxMu.Unlock()
+	return nil
 }
 
-func streamHandler(w http.ResponseWriter, r *http.Request) {
-	// Only one streamer at a time. If another streamer tries to connect, reject.
-	if !streamActive.CompareAndSwap(false, true) {
-		log.Printf("Another streamer tried to connect from %s, but a stream is already active.", r.RemoteAddr)
-		http.Error(w, "Stream already active", http.StatusConflict)
+func (m *mount) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if !sourceMethod(r.Method) {
+		w.Header().Set("Allow", "PUT, SOURCE, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	user, pass, ok := parseBasicAuth(r)
+	// Every check comes before the claim, which is what takes a live
+	// source off air if this one takes over: a source turned away never
+	// cuts the show.
+	a, ok := m.admitHTTPSource(w, r)
 	if !ok {
-		sourcePass := r.Header.Get("X-Source-Password")
-		if sourcePass == "" {
-			sourcePass = r.URL.Query().Get("password")
-		}
-		if sourcePass != "" {
-			parts := strings.SplitN(sourcePass, ":", 2)
-			if len(parts) == 2 {
-				user, pass, ok = parts[0], parts[1], true
-			}
+		return
+	}
+	user := a.user
+	offset, ok := m.admitOffset(w, r, user)
+	if !ok {
+		return
+	}
+
+	token := sessionToken(r)
+	if token != "" && len(token) < minTokenLength {
+		http.Error(w, "Session token must be at least "+strconv.Itoa(minTokenLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); strings.HasPrefix(strings.ToLower(ct), "video/") {
+		videoRejections.With(m.cfg.Name).Inc()
+		logf(r, "Streamer %s from %s refused on %s: sent Content-Type %s", user, r.RemoteAddr, m.cfg.Name, ct)
+		http.Error(w, m.videoMessage(ct), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	sc, err := openSource(w, r)
+	if err != nil {
+		logf(r, "Could not take over source connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer sc.release()
+
+	var format, video string
+	if sc.body, format, video, err = m.sniffSource(sc.body); err != nil {
+		logf(r, "Streamer read error for %s from %s: %v", user, r.RemoteAddr, err)
+		return
+	}
+	if video != "" {
+		videoRejections.With(m.cfg.Name).Inc()
+		logf(r, "Streamer %s from %s refused on %s: the stream is %s video", user, r.RemoteAddr, m.cfg.Name, video)
+		sc.fail(http.StatusUnsupportedMediaType, m.videoMessage(video))
+		return
+	}
+
+	// Only one streamer at a time, unless the new one takes over. Anyone
+	// else who tries to connect is rejected.
+	if ref := a.claim(r.Context().Done()); ref != nil {
+		sc.fail(ref.status, ref.msg)
+		return
+	}
+	if want, held := m.resumableOffset(user); offset > 0 && (!held || offset != want) {
+		// The grace period ran out while the source was let in.
+		logf(r, "Streamer %s from %s refused on %s: asked to resume at byte %d, but there is nothing to resume", user, r.RemoteAddr, m.cfg.Name, offset)
+		sc.fail(http.StatusConflict, "Nothing to resume on "+m.cfg.Name+"; connect without a resume offset")
+		m.releaseSource() // Release stream lock
+		return
+	}
+	m.setFormat(format)
+
+	sc.accept()
+	logf(r, "Streamer %s connected to %s from %s over %s", user, m.cfg.Name, r.RemoteAddr, r.Proto)
+
+	// Kicking works by expiring the read deadline, which unblocks the read
+	// loop below with an error and runs the normal disconnect path.
+	kick := func(reason string) {
+		logf(r, "Disconnecting streamer %s from %s: %s", user, m.cfg.Name, reason)
+		if err := sc.interrupt(); err != nil {
+			logf(r, "Could not interrupt streamer %s: %v", user, err)
 		}
-		if !ok {
+	}
+
+	ice := parseIceInfo(r.Header)
+	show := ice.Name
+	if show == "" {
+		show = r.URL.Query().Get("show")
+	}
+	sess := m.startSession(user, requestID(r), r.RemoteAddr, show, kick)
+	sess.setIceInfo(ice)
+	if offset > 0 {
+		sess.resumeAt(offset)
+	}
+	if token != "" {
+		sess.expectHeartbeats(token)
+	}
+	if a.ask.replica {
+		done := make(chan struct{})
+		defer close(done)
+		go m.watchReplica(done)
+	} else {
+		sess.replicate()
+	}
+	// Ensure the stream is cleaned up when the handler exits
+	defer sess.end()
+
+	err = sess.read(sc.body, sc.interrupt)
+	logf(r, "Streamer read error for %s from %s: %v", user, r.RemoteAddr, err)
+}
+
+// admitHTTPSource authenticates an HTTP or WebSocket source connection by
+// its NickServ credentials, checks any headers the mount requires and puts
+// it through admitSource, replying with the error if it is turned away. A
+// push from the primary was authenticated there; the replication token
+// vouches for it.
+func (m *mount) admitHTTPSource(w http.ResponseWriter, r *http.Request) (*admission, bool) {
+	ask, err := httpSourceAsk(r)
+	if err != nil {
+		http.Error(w, "Priority must be a whole number, 0 or more", http.StatusBadRequest)
+		return nil, false
+	}
+	user, replica := replicaAccount(r)
+	if replica {
+		ask.replica = true
+	} else {
+		var pass string
+		var ok bool
+		if user, pass, ok = credentials(r); !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="NickStream"`)
 			http.Error(w, "Unauthorized - no credentials", http.StatusUnauthorized)
-			streamActive.Store(false) // Release stream lock
-			return
+			return nil, false
+		}
+		valid, err := m.station.authenticate(user, pass)
+		if err != nil || !valid {
+			logf(r, "Auth failed for user %s from %s: %v", user, r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return nil, false
 		}
+		if !m.admitSourceHeaders(w, r, user) {
+			return nil, false
+		}
+	}
+	a, ref := m.admitSource(requestID(r), user, r.RemoteAddr, ask)
+	if ref != nil {
+		refuseHTTP(w, ref)
+		return nil, false
 	}
+	return a, true
+}
 
-	auth := NickServAuth.NewAuthClient(config.AppConfig.AuthURL, config.AppConfig.APIToken)
-	valid, err := auth.Authenticate(user, pass)
-	if err != nil || !valid {
-		log.Printf("Auth failed for user %s from %s: %v", user, r.RemoteAddr, err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		streamActive.Store(false) // Release stream lock
+func (m *mount) listenHandler(w http.ResponseWriter, r *http.Request) {
+	defer track(subsysListeners)()
+	if relaySlave(r) {
+		// A slave relaying the mount gets its stream as it is, past the
+		// checks meant for listeners.
+		logf(r, "Slave at %s relaying %s", r.RemoteAddr, m.cfg.Name)
+		m.serveListener(w, r)
+		return
+	}
+	if !admitChurn(w, r) {
+		return
+	}
+	if m.routeVariant(w, r) {
 		return
 	}
 
-	log.Printf("Streamer %s connected from %s", user, r.RemoteAddr)
+	if !m.admitCountry(w, r) {
+		return
+	}
+	if !m.admitWindow(w, r) {
+		return
+	}
 
-	// Set up new stream context for listeners
-	streamCtxMu.Lock()
-	if streamCancelFn != nil { // Cancel previous context if it exists
-		streamCancelFn()
+	ipKey := clientPrefix(r)
+	if !acquireIP(ipKey) {
+		logf(r, "Listener from %s rejected: too many connections from %s", r.RemoteAddr, ipKey)
+		w.Header().Set("Retry-After", strconv.Itoa(m.cfg.RetryAfter))
+		m.listenerError(w, r, http.StatusTooManyRequests, "listen.too_many")
+		return
 	}
-	streamCtx, streamCancelFn = context.WithCancel(context.Background())
-	streamCtxMu.Unlock()
+	defer releaseIP(ipKey)
 
-	// Ensure the stream is cleaned up when the handler exits
-	defer func() {
-		log.Printf("Streamer %s disconnected from %s", user, r.RemoteAddr)
-		streamActive.Store(false) // Mark stream as inactive
-		streamCancelFn()          // Signal listeners to stop
-		clearListeners()          // Close all listener channels
-		resetStreamState()        // Prepare for a new stream
-	}()
+	var account string
+	if m.cfg.ListenerAuth {
+		user, pass, ok := credentials(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="NickStream"`)
+			m.listenerError(w, r, http.StatusUnauthorized, "listen.no_credentials")
+			return
+		}
+		if valid, err := m.station.authenticate(user, pass); err != nil || !valid {
+			logf(r, "Listener auth failed for user %s from %s: %v", user, r.RemoteAddr, err)
+			m.listenerError(w, r, http.StatusUnauthorized, "listen.unauthorized")
+			return
+		}
+		account = user
+	}
+	r, done, ok := m.admitURLAuth(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+	if !m.admitPolicy(w, r, account) {
+		return
+	}
 
-	buf := make([]byte, 1024)
-	for {
-		n, err := r.Body.Read(buf)
-		if n > 0 {
-			firstDataOnce.Do(func() {
-				log.Println("First stream data received; unblocking listeners")
-				close(firstData) // Signal listeners that data has started
-			})
-			broadcast(buf[:n])
+	target := m
+	if !m.active() && m.cfg.Fallback != "" {
+		if fb := mounts()[m.cfg.Fallback]; fb != nil && fb.active() {
+			logf(r, "Mount %s has no source; serving fallback %s to %s", m.cfg.Name, fb.cfg.Name, r.RemoteAddr)
+			target = fb
 		}
-		if err != nil {
-			log.Printf("Streamer read error for %s from %s: %v", user, r.RemoteAddr, err)
-			break // Streamer disconnected or error
+	}
+	target.serveListener(w, r)
+}
+
+// route serves the mount's source and listen paths on mux.
+func (m *mount) route(mux *http.ServeMux) {
+	mc := m.cfg
+	// A mono copy's only source is its ffmpeg.
+	if mc.DownmixOf == "" {
+		mux.HandleFunc(mc.SourcePath, m.streamHandler)
+		mux.HandleFunc(mc.SourcePath+wsSourcePath, m.wsSourceHandler)
+	}
+	mux.HandleFunc(mc.ListenPath, m.listenHandler)
+	// Aliases are plain extra listen paths, for hardware radios and old
+	// playlist files that insist on SHOUTcast-era URLs like "/;".
+	for _, alias := range mc.Aliases {
+		mux.HandleFunc(alias, m.listenHandler)
+	}
+	log.Printf("Mount %s: source %s, listeners %s %v", mc.Name, mc.SourcePath, mc.ListenPath, mc.Aliases)
+}
+
+// startPackagers sets up the mount's HLS and MPEG-DASH output, if it has
+// them.
+func (m *mount) startPackagers() error {
+	var err error
+	if m.cfg.HLS {
+		if m.hls, err = newPackager(m); err != nil {
+			return fmt.Errorf("mount %s: hls: %w", m.cfg.Name, err)
 		}
+		log.Printf("Mount %s: HLS at %s%s.m3u8", m.cfg.Name, hlsPrefix, m.cfg.ListenPath)
 	}
+	if m.cfg.DASH {
+		if m.dash, err = newDASHPackager(m); err != nil {
+			return fmt.Errorf("mount %s: dash: %w", m.cfg.Name, err)
+		}
+		log.Printf("Mount %s: MPEG-DASH at %s%s.mpd", m.cfg.Name, dashPrefix, m.cfg.ListenPath)
+	}
+	return nil
 }
 
-func listenHandler(w http.ResponseWriter, r *http.Request) {
-	// Get the current stream context for this listener
-	streamCtxMu.Lock()
-	currentStreamCtx := streamCtx // Capture the current stream's context
-	streamCtxMu.Unlock()
+func (m *mount) serveListener(w http.ResponseWriter, r *http.Request) {
+	opts, ok := m.listenOptions(w, r)
+	if !ok {
+		return
+	}
+
+	// Join the live stream or, while the mount is idle or a source is
+	// still authenticating, the next one. A stream that is draining is
+	// already over.
+	state, st := m.currentStream()
+	if state == stateDraining {
+		m.unavailable(w, r, "listen.no_stream")
+		logf(r, "Listener from %s rejected: the stream on %s is ending.", r.RemoteAddr, m.cfg.Name)
+		return
+	}
 
-	// Wait for the current stream to start, or if no stream is active, continue.
+	// The station and the mount admit the listener before anything is
+	// played, the offline file included.
+	if !m.station.admitListener(w, r, m) {
+		return
+	}
+	defer m.station.removeListener()
+	if !m.admitMemory(w, r) {
+		return
+	}
+
+	// Wait for the stream to start, with the offline file playing
+	// meanwhile if there is one. Having heard it, the listener gets
+	// neither the intro nor ICY metadata: the response has already begun.
+	offline := false
+	if m.offlineRate > 0 {
+		select {
+		case <-st.firstData:
+		default:
+			offline = true
+			n := m.offlineListeners.Add(1)
+			if max := int64(m.cfg.MaxListeners); max > 0 && n+int64(m.listenerCount()) > max {
+				m.offlineListeners.Add(-1)
+				logf(r, "Listener from %s rejected: %s is at its limit of %d listeners.", r.RemoteAddr, m.cfg.Name, m.cfg.MaxListeners)
+				m.unavailable(w, r, "listen.mount_full")
+				return
+			}
+			played := m.playOffline(w, r, st)
+			m.offlineListeners.Add(-1)
+			if !played {
+				return
+			}
+		}
+	}
+	// Once the offline file has gone out there is no error status left to
+	// send; a listener turned away just gets the end of the response.
+	unavailable := func(key string) {
+		if !offline {
+			m.unavailable(w, r, key)
+		}
+	}
 	select {
-	case <-firstData:
+	case <-st.firstData:
 		// Stream has started, continue
 	case <-r.Context().Done():
 		// Client disconnected before stream started
-		log.Printf("Listener from %s disconnected before stream started.", r.RemoteAddr)
+		logf(r, "Listener from %s disconnected before stream started.", r.RemoteAddr)
 		return
-	case <-currentStreamCtx.Done():
+	case <-st.ctx.Done():
 		// Streamer disconnected before this listener received first data
-		log.Printf("Listener from %s disconnected because streamer ended before first data.", r.RemoteAddr)
-		http.Error(w, "No active stream", http.StatusServiceUnavailable)
+		logf(r, "Listener from %s disconnected because streamer ended before first data.", r.RemoteAddr)
+		unavailable("listen.no_stream")
 		return
 	}
 
-	// If no stream is active when a listener connects, inform them.
-	if !streamActive.Load() {
-		http.Error(w, "No active stream", http.StatusServiceUnavailable)
-		log.Printf("Listener from %s rejected: No active stream.", r.RemoteAddr)
+	// If the stream ended while the listener waited, inform them.
+	if !m.onAir(st) {
+		unavailable("listen.no_stream")
+		logf(r, "Listener from %s rejected: No active stream.", r.RemoteAddr)
 		return
 	}
 
-	w.Header().Set("Content-Type", "audio/mpeg")
+	ch := make(chan []byte, 100) // Buffer to prevent blocking broadcaster
+	l := newListener(r)
+	if err := m.registerListener(ch, l, st); err == errMountFull {
+		logf(r, "Listener from %s rejected: %s is at its limit of %d listeners.", r.RemoteAddr, m.cfg.Name, m.cfg.MaxListeners)
+		unavailable("listen.mount_full")
+		return
+	} else if err != nil {
+		logf(r, "Listener from %s rejected: No active stream.", r.RemoteAddr)
+		unavailable("listen.no_stream")
+		return
+	}
+	defer l.drop()                 // runs after unregistering
+	defer m.unregisterListener(ch) // Ensure listener is unregistered
+
+	events.Publish(events.Event{Type: events.ListenerConnect, SessionID: requestID(r), RemoteAddr: r.RemoteAddr, Data: map[string]string{"mount": m.cfg.Name}})
+	defer events.Publish(events.Event{Type: events.ListenerDisconnect, SessionID: requestID(r), RemoteAddr: r.RemoteAddr, Data: map[string]string{"mount": m.cfg.Name}})
+
+	w.Header().Set("Content-Type", m.contentType())
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive") // Keep the connection open
+	profile := deviceProfile(r.UserAgent())
+	m.setStreamHints(w, profile)
+	m.station.setBranding(w)
+	m.setSourceBranding(w)
 
-	ch := make(chan []byte, 100) // Buffer to prevent blocking broadcaster
-	registerListener(ch)
-	defer unregisterListener(ch) // Ensure listener is unregistered
+	// The intro and the buffered recent audio go to the new listener
+	// first, ahead of any shaping.
+	var intro []byte
+	if !opts.noIntro && !offline {
+		intro = m.intro
+	}
+	bufferedData := opts.limitBurst(m.listenerBurst(profile))
+	l.queue(len(bufferedData))
+	tap := &captureTap{w: w, m: m, id: l.ID}
+	var out io.Writer = meteredWriter{w: tap, m: m}
+	out = m.shapeListener(out, r, len(intro)+len(bufferedData))
 
-	// Send the buffered recent audio data to the new listener first
-	ringBufferMu.Lock()
-	bufferedData := ringBuffer.Bytes()
-	ringBufferMu.Unlock()
+	// Players that understand ICY metadata ask for it; everyone else gets
+	// plain audio.
+	var icy *icyWriter
+	if m.cfg.MetaInt > 0 && opts.wantsMetadata(r) && !offline {
+		w.Header().Set("icy-metaint", strconv.Itoa(m.cfg.MetaInt))
+		icy = newICYWriter(out, m.cfg.MetaInt, m.currentTitle)
+		out = icy
+		tap.metaInt = m.cfg.MetaInt
+	}
 
+	if len(intro) > 0 {
+		if _, err := out.Write(intro); err != nil {
+			logf(r, "Error writing intro to listener from %s: %v", r.RemoteAddr, err)
+			return
+		}
+	}
 	if len(bufferedData) > 0 {
-		if _, err := w.Write(bufferedData); err != nil {
-			log.Printf("Error writing buffered data to listener from %s: %v", r.RemoteAddr, err)
+		if _, err := out.Write(bufferedData); err != nil {
+			logf(r, "Error writing buffered data to listener from %s: %v", r.RemoteAddr, err)
 			return
 		}
+		l.dequeue(len(bufferedData))
 		if f, ok := w.(http.Flusher); ok {
 			f.Flush()
 		}
-		log.Printf("Sent %d bytes of buffered data to new listener from %s", len(bufferedData), r.RemoteAddr)
+		if profile != nil {
+			logf(r, "Sent %d bytes of buffered data to new %s listener from %s", len(bufferedData), profile.Name, r.RemoteAddr)
+		} else {
+			logf(r, "Sent %d bytes of buffered data to new listener from %s", len(bufferedData), r.RemoteAddr)
+		}
 	}
 
-	// Loop to send subsequent live data
+	// Loop to send subsequent live data. A listener that stops reading
+	// altogether is let go after listener_idle_timeout.
+	idle := m.idleGuard(w)
+	defer idle.release()
 	for {
 		select {
-		case data := <-ch:
-			if _, err := w.Write(data); err != nil {
-				log.Printf("Error writing live data to listener from %s: %v", r.RemoteAddr, err)
+		case data, ok := <-ch:
+			if !ok {
+				// Channel closed by clearListeners: the stream is over.
+				logf(r, "Listener from %s finished: streamer ended.", r.RemoteAddr)
+				endListener(w, icy)
+				return
+			}
+			idle.arm()
+			if _, err := out.Write(data); err != nil {
+				m.writeFailed(r, idle, "live data", err)
 				return // Client disconnected or error
 			}
+			l.dequeue(len(data))
+			if len(ch) == 0 && l.resync.pending.Load() {
+				// Everything queued is out; send what was held back.
+				idle.arm()
+				if _, err := out.Write(m.catchUp(l)); err != nil {
+					m.writeFailed(r, idle, "catch-up data", err)
+					return
+				}
+			}
 			if f, ok := w.(http.Flusher); ok {
 				f.Flush()
 			}
 		case <-r.Context().Done():
-			log.Printf("Listener from %s disconnected.", r.RemoteAddr)
+			logf(r, "Listener from %s disconnected.", r.RemoteAddr)
 			return // Client disconnected
-		case <-currentStreamCtx.Done():
-			log.Printf("Listener from %s disconnected due to streamer ending.", r.RemoteAddr)
+		case <-st.ctx.Done():
+			// Stream cancelled (e.g. shutdown); send whatever was already
+			// queued for this listener before signing off.
+			for drained := false; !drained; {
+				select {
+				case data, ok := <-ch:
+					if !ok {
+						drained = true
+					} else if _, err := out.Write(data); err != nil {
+						return
+					} else {
+						l.dequeue(len(data))
+					}
+				default:
+					drained = true
+				}
+			}
+			logf(r, "Listener from %s disconnected due to streamer ending.", r.RemoteAddr)
+			endListener(w, icy)
 			return // Streamer disconnected, context cancelled
 		}
 	}
 }
 
-func broadcast(data []byte) {
-	// Write to ring buffer
-	ringBufferMu.Lock()
-	if ringBuffer.Len()+len(data) > maxRingBufferSize {
-		// If adding new data exceeds buffer size, make room by dropping oldest data.
-		// A simple way is to reset the buffer and only keep the tail.
-		// For a true ring buffer, you'd manage an offset. For simplicity, we'll
-		// keep it simple here by trimming.
-		temp := make([]byte, 0, maxRingBufferSize)
-		// Copy only the part that fits and is newest
-		copyLen := maxRingBufferSize - len(data)
-		if copyLen < 0 { // If new data is larger than whole buffer
-			copyLen = 0
-		}
-		if ringBuffer.Len() > copyLen {
-			temp = append(temp, ringBuffer.Bytes()[ringBuffer.Len()-copyLen:]...)
-		} else {
-			temp = append(temp, ringBuffer.Bytes()...)
-		}
-		ringBuffer.Reset()
-		ringBuffer.Write(temp)
-	}
-	ringBuffer.Write(data)
-	ringBufferMu.Unlock()
-
-	listenersMu.Lock()
-	defer listenersMu.Unlock()
-	for ch := range listeners {
-		select {
-		case ch <- data:
-		default:
-			// Drop if listener is slow, but log it.
-			// This is expected if a client is very slow or has disconnected
-			// but its goroutine hasn't fully exited yet.
-			log.Printf("Dropped data for a slow listener.")
-		}
+// unavailable replies 503, with the message key names, and a Retry-After
+// hint, so well-behaved players back off during an outage instead of
+// reconnecting in a tight loop.
+func (m *mount) unavailable(w http.ResponseWriter, r *http.Request, key string) {
+	if m.cfg.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(m.cfg.RetryAfter))
 	}
+	m.listenerError(w, r, http.StatusServiceUnavailable, key)
 }
 
-func registerListener(ch chan []byte) {
-	listenersMu.Lock()
-	listeners[ch] = struct{}{}
-	listenersMu.Unlock()
-	log.Printf("Registered new listener. Total listeners: %d", len(listeners))
+// setStreamHints adds the optional headers that help players size their
+// buffers and decide how long to hold an idle connection.
+func (m *mount) setStreamHints(w http.ResponseWriter, p *config.DeviceProfile) {
+	if m.cfg.Bitrate > 0 {
+		w.Header().Set("icy-br", strconv.Itoa(m.cfg.Bitrate))
+	}
+	keepAlive := m.cfg.KeepAlive
+	if p != nil && p.KeepAlive > 0 {
+		keepAlive = p.KeepAlive
+	}
+	if keepAlive > 0 {
+		w.Header().Set("Keep-Alive", "timeout="+strconv.Itoa(keepAlive))
+	}
 }
 
-func unregisterListener(ch chan []byte) {
-	listenersMu.Lock()
-	delete(listeners, ch)
-	// Do NOT close(ch) here. It's either closed by clearListeners (streamer disconnects)
-	// or will be garbage collected when the listener goroutine exits and no
-	// other references to 'ch' remain. Closing here leads to "close of closed channel" panics.
-	listenersMu.Unlock()
-	log.Printf("Unregistered listener. Total listeners: %d", len(listeners))
+// endListener sends the final "stream ended" title to ICY listeners and
+// flushes. Returning normally from the handler afterwards lets net/http
+// terminate the chunked response properly, which players treat as a clean
+// end of stream rather than a network error worth retrying immediately.
+func endListener(w http.ResponseWriter, icy *icyWriter) {
+	if icy != nil {
+		if err := icy.finish(endOfStreamTitle); err != nil {
+			return
+		}
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
-// clearListeners closes all active listener channels.
-func clearListeners() {
-	listenersMu.Lock()
-	defer listenersMu.Unlock()
-	for ch := range listeners {
-		close(ch)             // Close the channel to signal end of stream
-		delete(listeners, ch) // Remove from map
+// credentials extracts a NickServ account and password from a request. Most
+// encoders only offer a password field, so besides basic auth we accept
+// "<nick>:<password>" as the password of the user "source", in the
+// X-Source-Password header or in the password query parameter.
+func credentials(r *http.Request) (user, pass string, ok bool) {
+	user, pass, ok = parseBasicAuth(r)
+	if ok {
+		// Icecast encoders send the username "source" unless told
+		// otherwise, with "<nick>:<password>" in the password field.
+		if parts := strings.SplitN(pass, ":", 2); user == "source" && len(parts) == 2 {
+			return parts[0], parts[1], true
+		}
+		return
+	}
+	sourcePass := r.Header.Get("X-Source-Password")
+	if sourcePass == "" {
+		sourcePass = r.URL.Query().Get("password")
 	}
-	log.Println("All listener channels cleared due to streamer disconnection.")
+	if sourcePass != "" {
+		parts := strings.SplitN(sourcePass, ":", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1], true
+		}
+	}
+	return "", "", false
 }
 
+// parseBasicAuth returns the credentials in r's Basic Authorization
+// header, if it has one.
 func parseBasicAuth(r *http.Request) (username, password string, ok bool) {
 	auth := r.Header.Get("Authorization")
 	if auth == "" || !strings.HasPrefix(auth, "Basic ") {
@@ -299,4 +1089,4 @@ func parseBasicAuth(r *http.Request) (username, password string, ok bool) {
 	}
 
 	return pair[0], pair[1], true
-}
\ No newline at end of file
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"nickcast/internal/NickServAuth"
+	"nickcast/internal/metrics"
+)
+
+// maxShadowChecks bounds the shadow backend lookups in flight, so a slow
+// shadow backend can't pile up goroutines; logins past it go unchecked.
+const maxShadowChecks = 32
+
+var (
+	shadowChecks = make(chan struct{}, maxShadowChecks)
+
+	shadowAuthResults = metrics.NewCounterVec("nickcast_auth_shadow_total", "Logins checked against the shadow auth backend, by result (agree, disagree, shadow_error, primary_error or skipped).", "station", "result")
+)
+
+// Verdicts of an auth backend.
+const (
+	authAccepted = "accepted"
+	authRejected = "rejected"
+	authFailed   = "failed" // it didn't answer
+)
+
+// authVerdict reads what a backend made of a login from Authenticate's
+// results.
+func authVerdict(valid bool, err error) string {
+	switch {
+	case err == nil && valid:
+		return authAccepted
+	case err == nil, errors.Is(err, NickServAuth.ErrRejected):
+		return authRejected
+	}
+	return authFailed
+}
+
+// compareShadow puts a login the station's backend has just decided to
+// its shadow backend, in the background, and records whether they agree.
+// Only the main backend's decision counts; this is for trying out a new
+// backend (an OIDC or LDAP bridge, say) on real logins before switching
+// auth_url over to it. Disagreements are logged with the account, never
+// the password.
+func (st *station) compareShadow(user, pass string, valid bool, err error) {
+	select {
+	case shadowChecks <- struct{}{}:
+	default:
+		shadowAuthResults.With(st.cfg.Name, "skipped").Inc()
+		return
+	}
+	primary := authVerdict(valid, err)
+	go func() {
+		defer func() { <-shadowChecks }()
+		svalid, serr := st.shadow.Authenticate(user, pass)
+		shadow := authVerdict(svalid, serr)
+		result := "agree"
+		switch {
+		case primary == authFailed:
+			result = "primary_error"
+		case shadow == authFailed:
+			result = "shadow_error"
+			log.Printf("Shadow auth for station %s failed for %s: %v", st.cfg.Name, user, serr)
+		case shadow != primary:
+			result = "disagree"
+			log.Printf("Shadow auth for station %s disagrees on %s: auth_url %s the login, the shadow backend %s it", st.cfg.Name, user, primary, shadow)
+		}
+		shadowAuthResults.With(st.cfg.Name, result).Inc()
+	}()
+}
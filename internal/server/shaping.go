@@ -0,0 +1,148 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"nickcast/internal/clock"
+	"time"
+)
+
+// shapeChunk is the most a shaped writer sends in one go, so a large write
+// (the listener burst, an archive download) is spread out rather than
+// paid for after the fact.
+const shapeChunk = 16 * 1024
+
+// tokenBucket meters bytes at rate per second, letting up to size bytes
+// through at once after a quiet spell. Credit is a one-off allowance spent
+// before the bucket itself.
+type tokenBucket struct {
+	rate   float64 // bytes per second
+	size   float64
+	tokens float64
+	credit float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, size float64, credit int) *tokenBucket {
+	return &tokenBucket{rate: rate, size: size, tokens: size, credit: float64(credit), last: clock.Default.Now()}
+}
+
+// take spends n bytes and returns how long to wait before sending them. A
+// bucket without a rate lets everything through.
+func (b *tokenBucket) take(n int) time.Duration {
+	now := clock.Default.Now()
+	if b.rate <= 0 {
+		b.last = now
+		if b.credit -= float64(n); b.credit < 0 {
+			b.credit = 0
+		}
+		return 0
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	b.last = now
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+	need := float64(n)
+	if b.credit > 0 {
+		spent := need
+		if spent > b.credit {
+			spent = b.credit
+		}
+		b.credit -= spent
+		need -= spent
+	}
+	b.tokens -= need
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// shapedWriter holds writes to w to the bucket's rate. rate, if set, is
+// consulted before each write so the cap can follow a stream whose bitrate
+// only becomes known once frames arrive; it returns 0 while unknown, which
+// lets data through unshaped.
+type shapedWriter struct {
+	w    io.Writer
+	b    *tokenBucket
+	rate func() float64
+	done <-chan struct{}
+}
+
+func (sw *shapedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > shapeChunk {
+			chunk = chunk[:shapeChunk]
+		}
+		if sw.rate != nil {
+			sw.b.rate = sw.rate()
+			sw.b.size = sw.b.rate * 2
+		}
+		if wait := sw.b.take(len(chunk)); wait > 0 {
+			t := clock.Default.NewTimer(wait)
+			select {
+			case <-t.C():
+			case <-sw.done:
+				t.Stop()
+				return written, io.ErrClosedPipe
+			}
+		}
+		n, err := sw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// shapedResponseWriter applies a shapedWriter to a response body, keeping
+// the rest of the ResponseWriter (headers, ServeContent's range support).
+type shapedResponseWriter struct {
+	http.ResponseWriter
+	out *shapedWriter
+}
+
+func (w shapedResponseWriter) Write(p []byte) (int, error) {
+	return w.out.Write(p)
+}
+
+// shapedResponse caps a response at kbps, for archive downloads. It returns
+// w unchanged when kbps is 0.
+func shapedResponse(w http.ResponseWriter, r *http.Request, kbps int) http.ResponseWriter {
+	if kbps <= 0 {
+		return w
+	}
+	rate := float64(kbps) * 1000 / 8
+	return shapedResponseWriter{w, &shapedWriter{w: w, b: newTokenBucket(rate, rate*2, 0), done: r.Context().Done()}}
+}
+
+// shapeListener caps a listener of the mount at its stream bitrate plus
+// shaping_headroom, so one client catching up after a stall can't take the
+// uplink from everyone else. The burst goes out at once, as without
+// shaping; what follows is held to the cap.
+func (m *mount) shapeListener(w io.Writer, r *http.Request, burst int) io.Writer {
+	if !m.cfg.Shaping {
+		return w
+	}
+	return &shapedWriter{w: w, b: newTokenBucket(0, 0, burst), rate: m.shapingRate, done: r.Context().Done()}
+}
+
+// shapingRate is the mount's listener cap in bytes per second, from its
+// configured bitrate or, failing that, the highest bitrate its MP3 source
+// has used. It is 0 while neither is known.
+func (m *mount) shapingRate() float64 {
+	kbps := m.cfg.Bitrate
+	if kbps == 0 {
+		if s := m.currentSession(); s != nil && s.frames != nil {
+			s.statsMu.Lock()
+			kbps = s.frames.Stats().MaxBitrate
+			s.statsMu.Unlock()
+		}
+	}
+	return float64(kbps) * 1000 / 8 * (1 + float64(m.cfg.ShapingHeadroom)/100)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/textproto"
+	"nickcast/config"
+	"strings"
+	"time"
+)
+
+const (
+	// shoutcastHandshakeTimeout is how long a SHOUTcast v1 encoder has to
+	// send its password and stream headers.
+	shoutcastHandshakeTimeout = 10 * time.Second
+
+	// acceptRetry is how long the SHOUTcast listener waits after a failed
+	// accept, such as running out of file descriptors, before trying again.
+	acceptRetry = 100 * time.Millisecond
+)
+
+// serveShoutcast accepts SHOUTcast v1 sources on shoutcast_listen until ctx
+// is cancelled. Older encoders (edcast, legacy SAM Broadcaster and the
+// like) don't speak HTTP to the server at all: they connect to the listen
+// port plus one, send a bare password line, wait for "OK2" and then send
+// icy-* header lines and the audio.
+func serveShoutcast(ctx context.Context) error {
+	addr := config.AppConfig.ShoutcastListen
+	m := mounts()[config.AppConfig.ShoutcastMount]
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for SHOUTcast sources on %s, for %s", addr, m.cfg.Name)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("SHOUTcast accept error: %v", err)
+			time.Sleep(acceptRetry)
+			continue
+		}
+		go func() {
+			c := &shoutcastConn{Conn: conn, id: newRequestID(), br: bufio.NewReader(conn)}
+			defer recoverSource("shoutcast", c.id, conn.RemoteAddr().String())
+			m.shoutcastSource(c)
+		}()
+	}
+}
+
+// shoutcastConn is one SHOUTcast v1 source connection.
+type shoutcastConn struct {
+	net.Conn
+	id string // logged like a request ID
+	br *bufio.Reader
+}
+
+func (c *shoutcastConn) logf(format string, args ...interface{}) {
+	log.Printf("[%s] "+format, append([]interface{}{c.id}, args...)...)
+}
+
+// refuse turns the encoder away with a one-line reason. SHOUTcast v1 has no
+// status codes; anything but OK2 is a refusal, and encoders show the line.
+func (c *shoutcastConn) refuse(msg string) {
+	c.Write([]byte(msg + "\r\n"))
+}
+
+// readLine reads one handshake line, without its line ending.
+func (c *shoutcastConn) readLine() (string, error) {
+	line, err := c.br.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
+
+func (c *shoutcastConn) banned() bool {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && bans.banned(addr.Unmap())
+}
+
+// shoutcastSource runs a SHOUTcast v1 source connection on m. Its password
+// is the same "<nick>:<password>" Icecast encoders send as their password,
+// since SHOUTcast v1 has no username.
+func (m *mount) shoutcastSource(c *shoutcastConn) {
+	defer c.Close()
+	remote := c.RemoteAddr().String()
+	if c.banned() {
+		c.logf("Rejected SHOUTcast source from banned address %s", remote)
+		return
+	}
+	c.SetDeadline(time.Now().Add(shoutcastHandshakeTimeout))
+	password, err := c.readLine()
+	if err != nil {
+		c.logf("SHOUTcast source from %s sent no password: %v", remote, err)
+		return
+	}
+
+	user, pass, ok := strings.Cut(password, ":")
+	if !ok {
+		c.logf("SHOUTcast source from %s refused: password is not <nick>:<password>", remote)
+		c.refuse("invalid password")
+		return
+	}
+	valid, err := m.station.authenticate(user, pass)
+	if err != nil || !valid {
+		c.logf("Auth failed for user %s from %s: %v", user, remote, err)
+		c.refuse("invalid password")
+		return
+	}
+	a, ref := m.admitSource(c.id, user, remote, sourceAsk{})
+	if ref != nil {
+		c.refuse(ref.msg)
+		return
+	}
+	if _, err := c.Write([]byte("OK2\r\nicy-caps:11\r\n\r\n")); err != nil {
+		return
+	}
+
+	// The encoder has been let in, so from here on there is no telling it
+	// why it is dropped; the log has to do.
+	mh, err := textproto.NewReader(c.br).ReadMIMEHeader()
+	if err != nil {
+		c.logf("Streamer %s from %s sent bad stream headers: %v", user, remote, err)
+		return
+	}
+	h := http.Header(mh)
+	if ct := h.Get("Content-Type"); strings.HasPrefix(strings.ToLower(ct), "video/") {
+		videoRejections.With(m.cfg.Name).Inc()
+		c.logf("Streamer %s from %s refused on %s: sent Content-Type %s", user, remote, m.cfg.Name, ct)
+		return
+	}
+	body, format, video, err := m.sniffSource(c.br)
+	if err != nil {
+		c.logf("Streamer read error for %s from %s: %v", user, remote, err)
+		return
+	}
+	if video != "" {
+		videoRejections.With(m.cfg.Name).Inc()
+		c.logf("Streamer %s from %s refused on %s: the stream is %s video", user, remote, m.cfg.Name, video)
+		return
+	}
+	// Only one streamer at a time, unless the new one takes over.
+	if a.claim(nil) != nil {
+		return
+	}
+	m.setFormat(format)
+	c.SetDeadline(time.Time{})
+
+	c.logf("Streamer %s connected to %s from %s over SHOUTcast", user, m.cfg.Name, remote)
+	kick := func(reason string) {
+		c.logf("Disconnecting streamer %s from %s: %s", user, m.cfg.Name, reason)
+		if err := c.SetReadDeadline(time.Now()); err != nil {
+			c.logf("Could not interrupt streamer %s: %v", user, err)
+		}
+	}
+	ice := parseIceInfo(h)
+	sess := m.startSession(user, c.id, remote, ice.Name, kick)
+	sess.setIceInfo(ice)
+	sess.replicate()
+	defer sess.end()
+
+	err = sess.read(body, func() error { return c.SetReadDeadline(time.Now()) })
+	c.logf("Streamer read error for %s from %s: %v", user, remote, err)
+}
+
+// shoutcastMetadataHandler serves SHOUTcast's /admin.cgi?mode=updinfo, which
+// the same encoders use to push song titles. It is /admin/metadata for the
+// shoutcast_mount, with the password in ?pass=.
+func shoutcastMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	r = r.Clone(r.Context())
+	q := r.URL.Query()
+	q.Set("mount", config.AppConfig.ShoutcastMount)
+	if pass := q.Get("pass"); pass != "" {
+		q.Set("password", pass)
+		q.Del("pass")
+		// Some send the password as Basic auth for user "admin" as well,
+		// which would win over it.
+		r.Header.Del("Authorization")
+	}
+	r.URL.RawQuery = q.Encode()
+	metadataHandler(w, r)
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/shows"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// showCheckInterval is how often the scheduler looks for shows due to air.
+	showCheckInterval = 5 * time.Second
+	// measureTimeout bounds how long measuring an upload's duration may take.
+	measureTimeout = 2 * time.Minute
+)
+
+// showStore holds uploaded shows; nil when shows_dir isn't configured.
+var showStore *shows.Store
+
+// showTimeLayouts are the slot formats accepted from uploaders. Times
+// without a zone are in the configured broadcast time zone.
+var showTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04"}
+
+func parseShowTime(s string) (time.Time, error) {
+	for _, layout := range showTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, config.AppConfig.Location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use e.g. 2025-01-31T20:00)", s)
+}
+
+// listShows serves the schedule, paged as described at listQuery and
+// filtered by ?mount=, ?account=, ?status= and ?since=/?until= (air time).
+func listShows(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, "at", "at", "title", "uploaded")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	since, until, err := timeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	all, err := showStore.List()
+	if err != nil {
+		logf(r, "Error listing shows: %v", err)
+		http.Error(w, "Could not list shows", http.StatusInternalServerError)
+		return
+	}
+
+	v := r.URL.Query()
+	list := []*shows.Show{}
+	for _, s := range all {
+		if (v.Get("mount") != "" && s.Mount != v.Get("mount")) ||
+			(v.Get("account") != "" && !strings.EqualFold(s.Account, v.Get("account"))) ||
+			(v.Get("status") != "" && s.Status != shows.Status(v.Get("status"))) {
+			continue
+		}
+		if (!since.IsZero() && s.At.Before(since)) || (!until.IsZero() && !s.At.Before(until)) {
+			continue
+		}
+		list = append(list, s)
+	}
+	q.sort(list, func(i, j int) bool {
+		switch q.Sort {
+		case "title":
+			return strings.ToLower(list[i].Title) < strings.ToLower(list[j].Title)
+		case "uploaded":
+			return list[i].Uploaded.Before(list[j].Uploaded)
+		default:
+			return list[i].At.Before(list[j].At)
+		}
+	})
+	lo, hi := q.page(w, r, len(list))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list[lo:hi])
+}
+
+// showsHandler serves the /api/shows upload API:
+//
+//	GET    /api/shows?mount=&status=&since=    the schedule, as a JSON list
+//	POST   /api/shows?mount=&at=&title=        upload a show (body is the audio)
+//	DELETE /api/shows?id=                      withdraw a show not yet aired
+func showsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listShows(w, r)
+	case http.MethodPost:
+		uploadShow(w, r)
+	case http.MethodDelete:
+		s, err := showStore.Get(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "No such show", http.StatusNotFound)
+			return
+		}
+		st := defaultStation()
+		if m := mounts()[s.Mount]; m != nil {
+			st = m.station
+		}
+		user, ok := requireUser(w, r, st)
+		if !ok {
+			return
+		}
+		if s.Account != user && !st.isAdmin(user) {
+			http.Error(w, "Only the uploader or an admin can withdraw a show", http.StatusForbidden)
+			return
+		}
+		if s.Status == shows.Airing {
+			http.Error(w, "Show is on air", http.StatusConflict)
+			return
+		}
+		if err := showStore.Remove(s); err != nil {
+			logf(r, "Error removing show %s: %v", s.ID, err)
+			http.Error(w, "Could not remove show", http.StatusInternalServerError)
+			return
+		}
+		logf(r, "Show %s withdrawn by %s", s.ID, user)
+		purgeShows(s.Mount)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func uploadShow(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	m := requestMount(r, q.Get("mount"))
+	if m == nil {
+		http.Error(w, "Unknown mount", http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, m.station)
+	if !ok {
+		return
+	}
+	at, err := parseShowTime(q.Get("at"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !at.After(clock.Default.Now()) {
+		http.Error(w, "Slot must be in the future", http.StatusBadRequest)
+		return
+	}
+	if !m.cfg.Windows.Contains(at) {
+		http.Error(w, "Slot is outside the mount's broadcast window ("+m.cfg.Windows.String()+")", http.StatusBadRequest)
+		return
+	}
+
+	// Spool the upload first; format and duration can only be checked once
+	// the whole file is here.
+	tmp, err := os.CreateTemp(showStore.Dir, ".upload-*")
+	if err != nil {
+		logf(r, "Error creating upload file: %v", err)
+		http.Error(w, "Could not store upload", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed into place
+	body := http.MaxBytesReader(w, r.Body, int64(config.AppConfig.UploadMaxSize))
+	size, err := io.Copy(tmp, body)
+	tmp.Close()
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, fmt.Sprintf("Upload is larger than %d bytes", tooBig.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		logf(r, "Upload from %s failed: %v", user, err)
+		http.Error(w, "Upload failed", http.StatusBadRequest)
+		return
+	}
+
+	head := make([]byte, 4096)
+	if f, err := os.Open(tmp.Name()); err == nil {
+		n, _ := io.ReadFull(f, head)
+		head = head[:n]
+		f.Close()
+	}
+	ext, ok := shows.Sniff(head)
+	if !ok {
+		http.Error(w, "Unrecognised audio format", http.StatusUnsupportedMediaType)
+		return
+	}
+	// The file goes out to listeners byte for byte, so it has to be in the
+	// format they were promised.
+	if want := extensionFor(m.cfg.ContentType); ext != want {
+		http.Error(w, fmt.Sprintf("Mount %s broadcasts %s; this upload is %s", m.cfg.Name, m.cfg.ContentType, strings.TrimPrefix(ext, ".")), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), measureTimeout)
+	defer cancel()
+	duration, err := shows.MeasureDuration(ctx, config.AppConfig.FFmpegPath, tmp.Name(), ext)
+	if err != nil || duration <= 0 {
+		logf(r, "Could not measure upload from %s: %v", user, err)
+		http.Error(w, "Could not determine the show's duration", http.StatusUnprocessableEntity)
+		return
+	}
+	if limit := time.Duration(config.AppConfig.UploadMaxDuration) * time.Second; duration > limit {
+		http.Error(w, fmt.Sprintf("Show is %s long; the limit is %s", duration.Round(time.Second), limit), http.StatusUnprocessableEntity)
+		return
+	}
+	if end, ok := m.cfg.Windows.CloseAt(at); ok && len(m.cfg.Windows) > 0 && at.Add(duration).After(end) {
+		http.Error(w, "Show would run past the end of the broadcast window at "+end.Format("15:04"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	s := &shows.Show{
+		ID:          fmt.Sprintf("%s-%s", m.fileName(), at.Format("20060102-1504")),
+		Mount:       m.cfg.Name,
+		Account:     user,
+		Title:       q.Get("title"),
+		At:          at,
+		Duration:    duration.Seconds(),
+		Size:        size,
+		ContentType: m.cfg.ContentType,
+		Ext:         ext,
+		Status:      shows.Scheduled,
+		Uploaded:    clock.Default.Now(),
+	}
+	if clash := scheduledOverlap(s); clash != nil {
+		http.Error(w, fmt.Sprintf("Slot overlaps %s by %s", clash.ID, clash.Account), http.StatusConflict)
+		return
+	}
+	if err := os.Rename(tmp.Name(), showStore.AudioPath(s)); err != nil {
+		logf(r, "Error storing show %s: %v", s.ID, err)
+		http.Error(w, "Could not store upload", http.StatusInternalServerError)
+		return
+	}
+	if err := showStore.Save(s); err != nil {
+		logf(r, "Error saving show %s: %v", s.ID, err)
+		http.Error(w, "Could not store upload", http.StatusInternalServerError)
+		return
+	}
+	logf(r, "Show %s uploaded by %s: %s, %d bytes, airs %s", s.ID, user, duration.Round(time.Second), size, at.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	purgeShows(s.Mount)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// scheduledOverlap returns a pending show on the same mount whose slot
+// overlaps s, if any.
+func scheduledOverlap(s *shows.Show) *shows.Show {
+	list, _ := showStore.List()
+	for _, o := range list {
+		if o.Mount != s.Mount || (o.Status != shows.Scheduled && o.Status != shows.Airing) {
+			continue
+		}
+		if o.At.Before(s.End()) && s.At.Before(o.End()) {
+			return o
+		}
+	}
+	return nil
+}
+
+// runScheduler airs uploaded shows when their slot comes round. A live
+// source always wins: a show waits for the mount to come free and is
+// marked missed if its slot ends first.
+func runScheduler(ctx context.Context) error {
+	// Shows left "airing" by a crash get another chance if their slot is
+	// still running.
+	if list, err := showStore.List(); err == nil {
+		for _, s := range list {
+			if s.Status == shows.Airing {
+				s.Status = shows.Scheduled
+				showStore.Save(s)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	t := clock.Default.NewTicker(showCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		list, err := showStore.List()
+		if err != nil {
+			log.Printf("Scheduler could not list shows: %v", err)
+			continue
+		}
+		at := clock.Default.Now()
+		for _, s := range list {
+			if s.Status != shows.Scheduled || at.Before(s.At) {
+				continue
+			}
+			m := mounts()[s.Mount]
+			if m == nil || !at.Before(s.End()) {
+				log.Printf("Show %s missed its slot", s.ID)
+				s.Status = shows.Missed
+				showStore.Save(s)
+				continue
+			}
+			if !m.claimOrPreempt("show-"+s.ID, "scheduler", s.Account, ctx.Done()) {
+				continue // live source on air; try again next tick
+			}
+			if _, ok := m.admitResume(""); !ok {
+				m.releaseSource()
+				continue // held for a live source to reconnect
+			}
+			s.Status = shows.Airing
+			showStore.Save(s)
+			wg.Add(1)
+			go func(m *mount, s *shows.Show) {
+				defer wg.Done()
+				err := playShow(ctx, m, s)
+				s.Status = shows.Aired
+				if err != nil {
+					log.Printf("Show %s failed: %v", s.ID, err)
+					s.Status = shows.Failed
+				}
+				showStore.Save(s)
+			}(m, s)
+		}
+	}
+}
+
+// playShow streams a show into a claimed mount at its natural byte rate, so
+// listeners see it exactly like a live encoder.
+func playShow(ctx context.Context, m *mount, s *shows.Show) error {
+	f, err := os.Open(showStore.AudioPath(s))
+	if err != nil {
+		m.releaseSource()
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id := "show-" + s.ID
+	sess := m.startSession(s.Account, id, "scheduler", s.Title, func(reason string) {
+		log.Printf("[%s] Stopping show on %s: %s", id, m.cfg.Name, reason)
+		cancel()
+	})
+	defer sess.end()
+	sess.logf("Airing show %s by %s on %s (%s)", s.ID, s.Account, m.cfg.Name, s.Length().Round(time.Second))
+	if s.Title != "" {
+		m.playTitle(id, s.Account, s.Title)
+	}
+
+	sent, err := sess.pump(ctx, f, float64(s.Size)/s.Duration)
+	if err == context.Canceled {
+		sess.logf("Show %s cut short after %d of %d bytes", s.ID, sent, s.Size)
+		return nil
+	}
+	if err == nil {
+		sess.logf("Show %s finished", s.ID)
+	}
+	return err
+}
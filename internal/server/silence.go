@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"nickcast/internal/mp3"
+	"time"
+)
+
+const (
+	// gapCheckInterval is how often live mounts are checked for a gap in
+	// their source's audio.
+	gapCheckInterval = 250 * time.Millisecond
+
+	// gapThreshold is how long a source may go without sending before the
+	// gap is filled. Encoders send several chunks a second, so anything
+	// longer is a stall or a dropped connection. Listeners have used up
+	// this much of their buffers by then, so the fill starts with as much
+	// silence at once to give it back.
+	gapThreshold = time.Second
+)
+
+var sourceGapsFilled = metrics.NewCounterVec("nickcast_source_gaps_filled_total", "Gaps in a source's audio filled with silence.", "mount")
+
+func (m *mount) setSilence(frame []byte) {
+	m.infoMu.Lock()
+	m.silence = frame
+	m.infoMu.Unlock()
+}
+
+func (m *mount) silentFrame() []byte {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.silence
+}
+
+// watchSourceGaps looks for mounts with silence_fill whose source has
+// paused or dropped, and keeps their listeners fed with silence until it
+// sends again, its reconnect grace period runs out, or dead air handling
+// takes over.
+func watchSourceGaps(ctx context.Context) error {
+	t := clock.Default.NewTicker(gapCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		at := clock.Default.Now()
+		for _, m := range mounts() {
+			if m.cfg.SilenceFill <= 0 || m.deadAir.Load() || m.filling.Load() {
+				continue
+			}
+			state, st := m.currentStream()
+			if state != stateLive && state != stateGrace {
+				continue
+			}
+			last := m.lastData.Load()
+			if last == m.filledGap.Load() || at.Sub(time.Unix(0, last)) < gapThreshold {
+				continue
+			}
+			frame := m.silentFrame()
+			if frame == nil {
+				continue
+			}
+			if m.filling.CompareAndSwap(false, true) {
+				go m.fillSilence(ctx, st, frame, last)
+			}
+		}
+	}
+}
+
+// fillSilence broadcasts silent frames at the stream's own pace for at
+// most silence_fill seconds, stopping as soon as the source sends again
+// (lastData moves on from last) or st goes off the air.
+func (m *mount) fillSilence(ctx context.Context, st *stream, frame []byte, last int64) {
+	defer m.filling.Store(false)
+	m.filledGap.Store(last)
+	h, _ := mp3.ParseHeader(frame)
+	limit := time.Duration(m.cfg.SilenceFill) * time.Second
+	start := clock.Default.Now()
+	var sent time.Duration
+	sourceGapsFilled.With(m.cfg.Name).Inc()
+	log.Printf("Source gap on %s: filling with silence", m.cfg.Name)
+
+	t := clock.Default.NewTicker(pumpTick)
+	defer t.Stop()
+	for {
+		if m.lastData.Load() != last || m.deadAir.Load() || !m.onAir(st) {
+			log.Printf("Source gap on %s over; filled %s with silence", m.cfg.Name, sent.Round(time.Millisecond))
+			return
+		}
+		if sent+h.Duration() > limit {
+			log.Printf("Source gap on %s outlasted silence_fill (%ds)", m.cfg.Name, m.cfg.SilenceFill)
+			return
+		}
+		due := clock.Default.Since(start) + gapThreshold
+		if due > limit {
+			due = limit
+		}
+		if n := int((due - sent) / h.Duration()); n > 0 {
+			m.broadcast(bytes.Repeat(frame, n))
+			sent += time.Duration(n) * h.Duration()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"nickcast/internal/metrics"
+	"time"
+)
+
+// silenceCheckInterval is how often live MP3 sources are checked for
+// sending nothing but silence.
+const silenceCheckInterval = time.Second
+
+var silentSources = metrics.NewCounterVec("nickcast_silent_sources_total", "Times a source sent only silence for silence_timeout.", "mount")
+
+// silentFor returns how long the session's source has sent nothing but
+// silent frames, and whether that is news: the session's silence starting
+// or ending since it was last asked with the same timeout. Sources that
+// aren't MP3 are never silent.
+func (s *sourceSession) silentFor(timeout time.Duration) (d time.Duration, started, ended bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.frames == nil {
+		return 0, false, false
+	}
+	d = s.frames.Stats().Silence
+	quiet := d >= timeout
+	started, ended = quiet && !s.quiet, !quiet && s.quiet
+	s.quiet = quiet
+	return d, started, ended
+}
+
+// watchSilentSources looks for MP3 sources that are connected and sending
+// in time but whose audio is silence, typically an encoder whose input has
+// come unplugged. Dead air handling can't see them, since data keeps
+// arriving. Each is reported with a silence.start event once silence_timeout
+// is reached and, with silence_action disconnect, dropped so the fallback
+// or the auto-DJ takes over; with alert, silence.end follows when the
+// audio comes back.
+func watchSilentSources(ctx context.Context) error {
+	t := clock.Default.NewTicker(silenceCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		for _, m := range mounts() {
+			timeout := time.Duration(m.cfg.SilenceTimeout) * time.Second
+			s := m.currentSession()
+			if s == nil || timeout <= 0 {
+				continue
+			}
+			d, started, ended := s.silentFor(timeout)
+			data := map[string]string{"mount": m.cfg.Name}
+			switch {
+			case started:
+				silentSources.With(m.cfg.Name).Inc()
+				s.logf("Only silence from %s on %s for %s", s.account, m.cfg.Name, d.Round(time.Second))
+				events.Publish(events.Event{Type: events.SilenceStart, SessionID: s.id, Account: s.account, RemoteAddr: s.remote, Data: data})
+				if m.cfg.SilenceAction == "disconnect" {
+					m.evictSource("sending only silence")
+				}
+			case ended:
+				s.logf("Audio from %s on %s is back", s.account, m.cfg.Name)
+				events.Publish(events.Event{Type: events.SilenceEnd, SessionID: s.id, Account: s.account, RemoteAddr: s.remote, Data: data})
+			}
+		}
+	}
+}
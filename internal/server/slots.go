@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// slotCheckInterval is how often live sources are checked against their
+// time slots.
+const slotCheckInterval = time.Second
+
+// slotWarnings are how long before a slot ends its DJ is warned.
+var slotWarnings = []time.Duration{10 * time.Minute, 2 * time.Minute}
+
+// slotWarningShown is how long a warning stays the stream title before
+// the DJ's own title is put back.
+const slotWarningShown = time.Minute
+
+// slot is a time-boxed session: while it lasts, Account may broadcast on
+// Mount, and at Ends it is taken off air so the next DJ, the fallback or
+// the auto-DJ can take over.
+type slot struct {
+	Mount     string    `json:"mount"`
+	Account   string    `json:"account"`
+	Ends      time.Time `json:"ends"`
+	GrantedBy string    `json:"granted_by"`
+	warned    int       // how many of slotWarnings have been sent
+}
+
+// slotKey is an account on a mount.
+type slotKey struct{ mount, account string }
+
+var slots struct {
+	mu    sync.Mutex
+	list  []*slot
+	ended map[slotKey]bool // whose slot ran out, until they are granted another
+}
+
+// grantSlot sets account's slot on mount to end at ends, replacing any it
+// had.
+func grantSlot(s *slot) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	for i, old := range slots.list {
+		if old.Mount == s.Mount && old.Account == s.Account {
+			slots.list = append(slots.list[:i], slots.list[i+1:]...)
+			break
+		}
+	}
+	delete(slots.ended, slotKey{s.Mount, s.Account})
+	// Warnings already past when the slot is granted aren't sent.
+	left := s.Ends.Sub(clock.Default.Now())
+	for s.warned < len(slotWarnings) && left <= slotWarnings[s.warned] {
+		s.warned++
+	}
+	slots.list = append(slots.list, s)
+	sort.SliceStable(slots.list, func(i, j int) bool { return slots.list[i].Ends.Before(slots.list[j].Ends) })
+	persist(storeSlots)
+}
+
+// revokeSlot removes account's slot on mount, reporting whether it had one.
+// An account whose slot has ended may stream on the mount again.
+func revokeSlot(mount, account string) bool {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	if k := (slotKey{mount, account}); slots.ended[k] {
+		delete(slots.ended, k)
+		return true
+	}
+	for i, s := range slots.list {
+		if s.Mount == mount && s.Account == account {
+			slots.list = append(slots.list[:i], slots.list[i+1:]...)
+			persist(storeSlots)
+			return true
+		}
+	}
+	return false
+}
+
+// slotEnds returns when account's slot on mount ends, if it has one.
+func slotEnds(mount, account string) (time.Time, bool) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	for _, s := range slots.list {
+		if s.Mount == mount && s.Account == account {
+			return s.Ends, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// slotEnded reports whether account's slot on mount has ended, which keeps
+// them off the mount until they are granted another.
+func slotEnded(mount, account string) bool {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	return slots.ended[slotKey{mount, account}]
+}
+
+// endSlot keeps the account of s, which is over, off its mount. A slot
+// granted for a booking needs no keeping: the bookings do that, and let
+// the account back on for its next one. slots.mu must be held.
+func endSlot(s *slot) {
+	if s.GrantedBy == "booking" {
+		return
+	}
+	if slots.ended == nil {
+		slots.ended = make(map[slotKey]bool)
+	}
+	slots.ended[slotKey{s.Mount, s.Account}] = true
+}
+
+// slotDue returns what is due for account's slot on mount at t: the
+// warning to send (how long is left), or that the slot is over. The slot
+// is forgotten once over, and the account kept off the mount.
+func slotDue(mount, account string, t time.Time) (warn time.Duration, over bool) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	for i, s := range slots.list {
+		if s.Mount != mount || s.Account != account {
+			continue
+		}
+		left := s.Ends.Sub(t)
+		if left <= 0 {
+			slots.list = append(slots.list[:i], slots.list[i+1:]...)
+			endSlot(s)
+			return 0, true
+		}
+		// Of the warnings due, only the latest is sent.
+		for s.warned < len(slotWarnings) && left <= slotWarnings[s.warned] {
+			warn = slotWarnings[s.warned]
+			s.warned++
+		}
+		return warn, false
+	}
+	return 0, false
+}
+
+// expireSlots forgets slots that ended while their DJ was off air.
+func expireSlots(t time.Time) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	for len(slots.list) > 0 && !slots.list[0].Ends.After(t) {
+		s := slots.list[0]
+		log.Printf("Slot for %s on %s ended with nobody on air", s.Account, s.Mount)
+		slots.list = slots.list[1:]
+		endSlot(s)
+	}
+}
+
+// slotWarningTitle is a warning showing as a mount's title, and the
+// DJ's own title to put back once it has been up for slotWarningShown.
+type slotWarningTitle struct {
+	warning, title string
+	until          time.Time
+}
+
+// watchSlots warns DJs whose slot is ending, 10 and 2 minutes before, and
+// takes them off air when it ends, with no reconnect_grace: they can't
+// come back on until granted another slot. Warnings go out as a
+// source.slot_warning event and for a minute as the stream title, which
+// the DJ sees in their encoder or player, but not as a track for
+// now-playing services; the end is a source.slot_end event.
+func watchSlots(ctx context.Context) error {
+	t := clock.Default.NewTicker(slotCheckInterval)
+	defer t.Stop()
+	shown := make(map[*mount]slotWarningTitle)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		at := clock.Default.Now()
+		for m, w := range shown {
+			if at.Before(w.until) {
+				continue
+			}
+			// Unless the DJ has sent a title of their own since.
+			if m.currentTitle() == w.warning {
+				m.setTitle(w.title)
+			}
+			delete(shown, m)
+		}
+		for _, m := range mounts() {
+			s := m.currentSession()
+			if s == nil {
+				continue
+			}
+			warn, over := slotDue(m.cfg.Name, s.account, at)
+			switch {
+			case over:
+				s.logf("Slot for %s on %s is over", s.account, m.cfg.Name)
+				events.Publish(events.Event{Type: events.SourceSlotEnd, SessionID: s.id, Account: s.account, RemoteAddr: s.remote, Data: map[string]string{"mount": m.cfg.Name}})
+				delete(shown, m)
+				m.evictSource("time slot ended")
+			case warn > 0:
+				minutes := int(warn.Minutes())
+				s.logf("Slot for %s on %s ends in %d minutes", s.account, m.cfg.Name, minutes)
+				events.Publish(events.Event{Type: events.SourceSlotWarning, SessionID: s.id, Account: s.account, RemoteAddr: s.remote, Data: map[string]string{
+					"mount":   m.cfg.Name,
+					"minutes": strconv.Itoa(minutes),
+				}})
+				w, ok := shown[m]
+				if !ok || m.currentTitle() != w.warning {
+					w.title = m.currentTitle()
+				}
+				name := w.title
+				if name == "" {
+					name = s.account
+				}
+				w.warning = m.trTitle("title.minutes_left", "title", name, "minutes", strconv.Itoa(minutes))
+				w.until = at.Add(slotWarningShown)
+				shown[m] = w
+				m.setTitle(w.warning)
+			}
+		}
+		expireSlots(at)
+	}
+}
+
+// slotsHandler serves /admin/slots:
+//
+//	GET    /admin/slots[?mount=]                         current slots
+//	POST   /admin/slots?mount=&account=&minutes=|until=  grant one
+//	DELETE /admin/slots?mount=&account=                  revoke one
+//
+// A slot bounds the account's sessions on the mount, whether it is on air
+// already or connects later; other accounts aren't affected. Once it ends
+// the account can't stream on the mount until granted another, or until
+// the ended slot is revoked.
+func slotsHandler(w http.ResponseWriter, r *http.Request) {
+	var m *mount
+	if ref := r.FormValue("mount"); ref != "" || r.Method != http.MethodGet {
+		if m = requestMount(r, ref); m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+	}
+	st := defaultStation()
+	if m != nil {
+		st = m.station
+	}
+	user, ok := requireAdmin(w, r, st)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list := []slot{}
+		slots.mu.Lock()
+		for _, s := range slots.list {
+			if m == nil || s.Mount == m.cfg.Name {
+				list = append(list, *s)
+			}
+		}
+		slots.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	case http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	account := r.FormValue("account")
+	if account == "" {
+		http.Error(w, "Missing account", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if !revokeSlot(m.cfg.Name, account) {
+			http.Error(w, "No slot for that account on that mount", http.StatusNotFound)
+			return
+		}
+		logf(r, "Slot for %s on %s revoked by %s", account, m.cfg.Name, user)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	at := clock.Default.Now()
+	var ends time.Time
+	if v := r.FormValue("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "minutes must be a positive number", http.StatusBadRequest)
+			return
+		}
+		ends = at.Add(time.Duration(n) * time.Minute)
+	} else if v := r.FormValue("until"); v != "" {
+		t, err := parseShowTime(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ends = t
+	} else {
+		http.Error(w, "Missing minutes or until", http.StatusBadRequest)
+		return
+	}
+	if !ends.After(at) {
+		http.Error(w, "The slot would already be over", http.StatusBadRequest)
+		return
+	}
+
+	s := &slot{Mount: m.cfg.Name, Account: account, Ends: ends, GrantedBy: user}
+	grantSlot(s)
+	logf(r, "Slot for %s on %s granted by %s until %s", account, m.cfg.Name, user, ends.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// resetSlots forgets every slot, before and after the test.
+func resetSlots(t *testing.T) {
+	clear := func() {
+		slots.mu.Lock()
+		slots.list = nil
+		slots.ended = nil
+		slots.mu.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+func TestSlotDue(t *testing.T) {
+	// A 30 minute slot, checked every minute from when it is granted.
+	type due struct {
+		warn time.Duration
+		over bool
+	}
+	tests := []struct {
+		name   string
+		length time.Duration
+		want   map[time.Duration]due // by minutes into the slot; the rest are quiet
+	}{
+		{"both warnings", 30 * time.Minute, map[time.Duration]due{
+			20 * time.Minute: {warn: 10 * time.Minute},
+			28 * time.Minute: {warn: 2 * time.Minute},
+			30 * time.Minute: {over: true},
+		}},
+		{"granted inside the first warning", 5 * time.Minute, map[time.Duration]due{
+			3 * time.Minute: {warn: 2 * time.Minute},
+			5 * time.Minute: {over: true},
+		}},
+		{"granted inside the last warning", time.Minute, map[time.Duration]due{
+			time.Minute: {over: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fakeClock(t)
+			resetSlots(t)
+			grantSlot(&slot{Mount: "/live", Account: "dj", Ends: start.Add(tt.length)})
+			for at := time.Duration(0); at <= tt.length+time.Minute; at += time.Minute {
+				warn, over := slotDue("/live", "dj", f.Now())
+				if got := (due{warn, over}); got != tt.want[at] {
+					t.Errorf("at %s: got %+v, want %+v", at, got, tt.want[at])
+				}
+				f.Advance(time.Minute)
+			}
+			if !slotEnded("/live", "dj") {
+				t.Error("account not kept off the mount once its slot is over")
+			}
+		})
+	}
+}
+
+func TestSlotWarningSkippedCheck(t *testing.T) {
+	// A check that misses both warnings sends only the latest.
+	f := fakeClock(t)
+	resetSlots(t)
+	grantSlot(&slot{Mount: "/live", Account: "dj", Ends: start.Add(15 * time.Minute)})
+	f.Advance(14 * time.Minute)
+	if warn, _ := slotDue("/live", "dj", f.Now()); warn != 2*time.Minute {
+		t.Errorf("got warning %s, want 2m", warn)
+	}
+	if warn, _ := slotDue("/live", "dj", f.Now()); warn != 0 {
+		t.Errorf("warning %s sent twice", warn)
+	}
+}
+
+func TestExpireSlots(t *testing.T) {
+	f := fakeClock(t)
+	resetSlots(t)
+	grantSlot(&slot{Mount: "/live", Account: "a", Ends: start.Add(time.Hour)})
+	grantSlot(&slot{Mount: "/live", Account: "b", Ends: start.Add(2 * time.Hour)})
+	grantSlot(&slot{Mount: "/live", Account: "c", Ends: start.Add(time.Hour), GrantedBy: "booking"})
+
+	f.Advance(time.Hour)
+	expireSlots(f.Now())
+	for _, tt := range []struct {
+		account     string
+		left, ended bool
+	}{
+		{"a", false, true},
+		{"b", true, false},
+		{"c", false, false}, // the bookings keep it off, not the slot
+	} {
+		if _, left := slotEnds("/live", tt.account); left != tt.left {
+			t.Errorf("%s: slot left %v, want %v", tt.account, left, tt.left)
+		}
+		if ended := slotEnded("/live", tt.account); ended != tt.ended {
+			t.Errorf("%s: slot ended %v, want %v", tt.account, ended, tt.ended)
+		}
+	}
+
+	// Another slot lets the account back on.
+	grantSlot(&slot{Mount: "/live", Account: "a", Ends: start.Add(3 * time.Hour)})
+	if slotEnded("/live", "a") {
+		t.Error("account kept off the mount after another slot")
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/netip"
+	"nickcast/internal/clock"
+	"nickcast/internal/shows"
+	"os"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is the format of state snapshots. Imports of any other
+// version are refused.
+const snapshotVersion = 1
+
+// snapshot is the server's runtime state, everything nickcast.conf doesn't
+// already say, for moving to another host.
+type snapshot struct {
+	Version       int                    `json:"version"`
+	Taken         time.Time              `json:"taken"`
+	Mounts        []mountSnapshot        `json:"mounts"`
+	Bans          []banEntry             `json:"bans"` // runtime bans; configured ones are left out
+	Announcements []announcementSnapshot `json:"announcements"`
+	Shows         []*shows.Show          `json:"shows,omitempty"`
+	Quotas        []quotaInfo            `json:"quotas"`
+}
+
+type mountSnapshot struct {
+	Name      string           `json:"name"`
+	Station   string           `json:"station"`
+	Windows   string           `json:"windows,omitempty"`
+	OnAir     bool             `json:"on_air"` // inside its broadcast windows
+	BytesSent int64            `json:"bytes_sent"`
+	Listeners int              `json:"listeners"`
+	Session   *sessionSnapshot `json:"session,omitempty"`
+}
+
+// sessionSnapshot describes a live source. Connections can't move hosts,
+// so it is only for the record; the broadcaster reconnects to the new
+// host.
+type sessionSnapshot struct {
+	Account string    `json:"account"`
+	Remote  string    `json:"remote"`
+	Started time.Time `json:"started"`
+	Title   string    `json:"title,omitempty"`
+}
+
+// announcementSnapshot is a pending announcement with its audio, so it
+// can air on the new host without being synthesized again.
+type announcementSnapshot struct {
+	announcement
+	Audio []byte `json:"audio"`
+}
+
+// takeSnapshot copies the server's state. Each part (bans, announcements,
+// every mount) is copied under its own lock, so each is consistent in
+// itself.
+func takeSnapshot() (*snapshot, error) {
+	snap := &snapshot{Version: snapshotVersion, Taken: clock.Default.Now()}
+	t := now()
+
+	names := make([]string, 0, len(mounts()))
+	for name := range mounts() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := mounts()[name]
+		ms := mountSnapshot{
+			Name:      name,
+			Station:   m.station.cfg.Name,
+			OnAir:     m.cfg.Windows.Contains(t),
+			BytesSent: m.bytesSent.Load(),
+			Listeners: m.listenerCount(),
+		}
+		if len(m.cfg.Windows) > 0 {
+			ms.Windows = m.cfg.Windows.String()
+		}
+		m.infoMu.Lock()
+		if s := m.session; s != nil {
+			ms.Session = &sessionSnapshot{Account: s.account, Remote: s.remote, Started: s.started, Title: m.title}
+		}
+		m.infoMu.Unlock()
+		snap.Mounts = append(snap.Mounts, ms)
+	}
+
+	snap.Bans = []banEntry{}
+	for _, e := range bans.list() {
+		if !e.FromCfg {
+			snap.Bans = append(snap.Bans, e)
+		}
+	}
+
+	snap.Announcements = []announcementSnapshot{}
+	announcements.mu.Lock()
+	for _, a := range announcements.pending {
+		snap.Announcements = append(snap.Announcements, announcementSnapshot{announcement: *a, Audio: a.audio})
+	}
+	announcements.mu.Unlock()
+
+	if showStore != nil {
+		list, err := showStore.List()
+		if err != nil {
+			return nil, fmt.Errorf("listing shows: %w", err)
+		}
+		snap.Shows = list
+	}
+
+	stationNames := make([]string, 0, len(stations))
+	for name := range stations {
+		stationNames = append(stationNames, name)
+	}
+	sort.Strings(stationNames)
+	for _, name := range stationNames {
+		snap.Quotas = append(snap.Quotas, stations[name].quotas())
+	}
+	return snap, nil
+}
+
+// stateHandler serves /admin/state, a download of the server's runtime
+// state for import_state on another host. It covers every station, so
+// only default station admins may take it.
+func stateHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snap, err := takeSnapshot()
+	if err != nil {
+		logf(r, "Error taking state snapshot: %v", err)
+		http.Error(w, "Could not take snapshot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="nickcast-state-`+snap.Taken.UTC().Format("20060102-150405")+`.json"`)
+	json.NewEncoder(w).Encode(snap)
+}
+
+// importState restores a snapshot taken with /admin/state on the old host:
+// runtime bans, pending announcements and traffic counters. Live sessions
+// can't be restored, and scheduled shows live in shows_dir, which has to
+// be copied over; any missing from it are logged. Once imported the file
+// is renamed, so a restart doesn't apply it again; a missing file is
+// taken to have been imported already.
+func importState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No state snapshot at %s to import", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("state snapshot %s: %w", path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("state snapshot %s is version %d; this nickcast reads version %d", path, snap.Version, snapshotVersion)
+	}
+
+	t := clock.Default.Now()
+	restored := 0
+	for _, e := range snap.Bans {
+		p, err := netip.ParsePrefix(e.Prefix)
+		if err != nil {
+			log.Printf("State snapshot: skipping ban on %q: %v", e.Prefix, err)
+			continue
+		}
+		var until time.Time
+		if e.Until != nil {
+			if !t.Before(*e.Until) {
+				continue
+			}
+			until = *e.Until
+		}
+		bans.add(p, until)
+		restored++
+	}
+
+	queued := 0
+	for i := range snap.Announcements {
+		a := snap.Announcements[i].announcement
+		if findMount(a.Mount) == nil {
+			log.Printf("State snapshot: dropping announcement %s for %s, which isn't configured here", a.ID, a.Mount)
+			continue
+		}
+		if t.Sub(a.At) > announceExpiry || a.Duration <= 0 || len(snap.Announcements[i].Audio) == 0 {
+			continue
+		}
+		a.audio = snap.Announcements[i].Audio
+		// IDs are handed out afresh, as this host's may already be taken.
+		a.ID = ""
+		queueAnnouncement(&a)
+		queued++
+	}
+
+	for _, ms := range snap.Mounts {
+		m := mounts()[ms.Name]
+		if m == nil {
+			log.Printf("State snapshot: mount %s isn't configured here", ms.Name)
+			continue
+		}
+		m.bytesSent.Add(ms.BytesSent)
+	}
+
+	missing := 0
+	if showStore != nil {
+		for _, s := range snap.Shows {
+			if s.Status != shows.Scheduled {
+				continue
+			}
+			if _, err := showStore.Get(s.ID); err != nil {
+				log.Printf("State snapshot: show %s (%s on %s at %s) is not in %s", s.ID, s.Title, s.Mount, s.At.Format(time.RFC3339), showStore.Dir)
+				missing++
+			}
+		}
+	} else if len(snap.Shows) > 0 {
+		log.Printf("State snapshot: %d shows not restored, as shows_dir isn't set", len(snap.Shows))
+	}
+
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return fmt.Errorf("state snapshot imported, but renaming it failed: %w", err)
+	}
+	log.Printf("Imported state snapshot taken %s: %d bans, %d announcements; %d scheduled shows missing",
+		snap.Taken.Format(time.RFC3339), restored, queued, missing)
+	return nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"nickcast/internal/metrics"
+	"nickcast/internal/mp3"
+	"strings"
+)
+
+// sniffSize is how much of a source's stream is looked at before it goes
+// out: enough for three MPEG-TS packets.
+const sniffSize = 512
+
+var videoRejections = metrics.NewCounterVec("nickcast_video_sources_rejected_total", "Source connections turned away for sending video.", "mount")
+
+// videoFormat names the video container b starts with, or returns "" if
+// it doesn't look like one. Audio in containers that can hold either (MP4,
+// Matroska) is let through when the mount is configured for that
+// container.
+func (m *mount) videoFormat(b []byte) string {
+	ct := strings.ToLower(m.cfg.ContentType)
+	switch {
+	case bytes.HasPrefix(b, []byte("NSVf")), bytes.HasPrefix(b, []byte("NSVs")):
+		return "NSV (Nullsoft Streaming Video)"
+	case bytes.HasPrefix(b, []byte("FLV\x01")):
+		return "FLV"
+	case len(b) >= 12 && bytes.Equal(b[4:8], []byte("ftyp")):
+		brand := string(b[8:12])
+		if brand == "M4A " || brand == "M4B " || strings.Contains(ct, "mp4") || strings.Contains(ct, "aac") {
+			return ""
+		}
+		return "MP4/QuickTime"
+	case bytes.HasPrefix(b, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		if strings.Contains(ct, "webm") || strings.Contains(ct, "matroska") {
+			return ""
+		}
+		return "Matroska/WebM"
+	case len(b) >= 12 && bytes.HasPrefix(b, []byte("RIFF")) && bytes.Equal(b[8:12], []byte("AVI ")):
+		return "AVI"
+	case len(b) > 376 && b[0] == 0x47 && b[188] == 0x47 && b[376] == 0x47:
+		return "MPEG transport stream"
+	case bytes.HasPrefix(b, []byte("OggS")) && bytes.Contains(b, []byte("\x80theora")):
+		return "Ogg Theora"
+	}
+	return ""
+}
+
+// sniffedFormats are the content types audioFormat can tell apart.
+var sniffedFormats = map[string]bool{"audio/mpeg": true, "audio/ogg": true, "audio/aac": true, "audio/flac": true}
+
+// audioFormat returns the content type of the audio b starts with, for the
+// formats sources commonly send, or "" if it doesn't recognize it. MP3 may
+// start mid-frame, as relayed streams often do.
+func audioFormat(b []byte) string {
+	switch {
+	case len(b) >= 8 && bytes.Equal(b[4:8], []byte("ftyp")), bytes.HasPrefix(b, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		// MP4 and Matroska are content_type's business; their headers
+		// can look like MP3 frames.
+		return ""
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return "audio/ogg"
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(b, []byte("ID3")):
+		return "audio/mpeg"
+	case len(b) >= 2 && b[0] == 0xFF && b[1]&0xF6 == 0xF0:
+		// ADTS sync word with layer 00 is AAC, not MPEG audio.
+		return "audio/aac"
+	case mp3.Sync(b) >= 0:
+		return "audio/mpeg"
+	}
+	return ""
+}
+
+// sniffSource reads the start of a source's stream and reports the audio
+// format it finds, unless content_type already names the same format, and
+// the video container it is in, if any. The returned reader gives back the
+// whole stream, sniffed bytes included. Once the source has the mount, the
+// format becomes the mount's content type for listeners (see setFormat).
+func (m *mount) sniffSource(body io.Reader) (io.Reader, string, string, error) {
+	buf := make([]byte, sniffSize)
+	n, err := io.ReadAtLeast(body, buf, sniffSize)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, "", "", err
+	}
+	format := audioFormat(buf[:n])
+	if canonicalFormat(format) == canonicalFormat(m.cfg.ContentType) {
+		format = ""
+	}
+	return io.MultiReader(bytes.NewReader(buf[:n]), body), format, m.videoFormat(buf[:n]), nil
+}
+
+// videoMessage tells whoever set up the encoder what to change.
+func (m *mount) videoMessage(format string) string {
+	return "This is an audio-only server, but the source is sending video (" + format + "). " +
+		"Set your encoder to send audio only, as " + m.cfg.ContentType + "; OBS, for one, needs a custom output with video turned off rather than a streaming service."
+}
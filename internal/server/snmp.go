@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"nickcast/internal/snmp"
+	"os"
+	"sort"
+)
+
+// The system group from MIB-II, which pollers read to identify the device.
+var (
+	sysDescr    = snmp.OID{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	sysObjectID = snmp.OID{1, 3, 6, 1, 2, 1, 1, 2, 0}
+	sysUpTime   = snmp.OID{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	sysName     = snmp.OID{1, 3, 6, 1, 2, 1, 1, 5, 0}
+)
+
+// serveSNMP answers SNMP polls on snmp_listen, from snmp_allow addresses
+// if it is set, until ctx is cancelled.
+func serveSNMP(ctx context.Context) error {
+	addr := config.AppConfig.SNMPListen
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for SNMP on %s (UDP)", addr)
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	agent := &snmp.Agent{Community: config.AppConfig.SNMPCommunity, Vars: snmpVars}
+	if allow := config.AppConfig.SNMPAllow; len(allow) > 0 {
+		agent.Allow = func(addr net.Addr) bool {
+			ua, ok := addr.(*net.UDPAddr)
+			return ok && addrAllowed(allow, ua.AddrPort().Addr())
+		}
+	}
+	if err := agent.Serve(pc); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// snmpVars is what the SNMP agent serves, under snmp_oid (base):
+//
+//	base.1.1.0      listeners now (Gauge32)
+//	base.1.2.0      live sources now (Gauge32)
+//	base.1.3.0      listener connections since startup (Counter64)
+//	base.1.4.0      source connections since startup (Counter64)
+//	base.1.5.0      bytes sent to listeners since startup (Counter64)
+//	base.1.6.0      uptime (TimeTicks)
+//	base.2.1.C.N    one row per mount, N from 1 in name order, column C:
+//	                1 index, 2 name, 3 listeners (Gauge32), 4 live
+//	                (1 true, 2 false), 5 bytes sent (Counter64), 6 bytes
+//	                from the live source (Counter64), 7 title
+//
+// along with sysDescr, sysObjectID (base), sysUpTime and sysName.
+func snmpVars() []snmp.Var {
+	base, _ := snmp.ParseOID(config.AppConfig.SNMPOID)
+	uptime := snmp.TimeTicks(clock.Default.Since(serverStart).Milliseconds() / 10)
+	host, _ := os.Hostname()
+	vars := []snmp.Var{
+		{OID: sysDescr, Value: "NickCast"},
+		{OID: sysObjectID, Value: base},
+		{OID: sysUpTime, Value: uptime},
+		{OID: sysName, Value: host},
+	}
+
+	list := make([]*mount, 0, len(mounts()))
+	for _, m := range mounts() {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].cfg.Name < list[j].cfg.Name })
+
+	var listeners, live int
+	var sent int64
+	for i, m := range list {
+		n, s := m.listenerCount(), m.currentSession()
+		var read int64
+		status := 2
+		if s != nil {
+			live++
+			status = 1
+			s.statsMu.Lock()
+			read = s.bytes
+			s.statsMu.Unlock()
+		}
+		listeners += n
+		sent += m.bytesSent.Load()
+		row := uint32(i + 1)
+		for col, v := range []interface{}{
+			int(row),
+			m.cfg.Name,
+			snmp.Gauge32(n),
+			status,
+			snmp.Counter64(m.bytesSent.Load()),
+			snmp.Counter64(read),
+			m.currentTitle(),
+		} {
+			vars = append(vars, snmp.Var{OID: base.Append(2, 1, uint32(col+1), row), Value: v})
+		}
+	}
+	return append(vars,
+		snmp.Var{OID: base.Append(1, 1, 0), Value: snmp.Gauge32(listeners)},
+		snmp.Var{OID: base.Append(1, 2, 0), Value: snmp.Gauge32(live)},
+		snmp.Var{OID: base.Append(1, 3, 0), Value: snmp.Counter64(listenerConnections.Value())},
+		snmp.Var{OID: base.Append(1, 4, 0), Value: snmp.Counter64(sourceConnections.Value())},
+		snmp.Var{OID: base.Append(1, 5, 0), Value: snmp.Counter64(sent)},
+		snmp.Var{OID: base.Append(1, 6, 0), Value: uptime},
+	)
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"nickcast/internal/mp3"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pumpTick is how often internally played sources push out the next slice
+// of audio.
+const pumpTick = 100 * time.Millisecond
+
+// sourceSession is one source feeding a mount. Encoders connecting over HTTP
+// are the usual kind, but nickcast also plays some sources itself (scheduled
+// shows); both go through the same lifecycle so listeners, recordings and
+// events can't tell the difference.
+type sourceSession struct {
+	m       *mount
+	account string
+	id      string // request or session ID used in logs and events
+	remote  string
+	started time.Time
+	untrack func()
+	stream  *stream // what its listeners joined
+
+	statsMu sync.Mutex
+	bytes   int64
+	offset  int64         // where in the source's audio bytes started, if it resumed; see admitOffset
+	peak    int           // most listeners at once, for /admin/stats
+	frames  *mp3.Analyzer // nil for formats other than MP3
+	drift   *driftCompensator
+	silent  bool // the mount's silent frame has been made from this source's audio
+	ice     iceInfo
+	held    time.Duration // how long throttle has held the source back
+	ingest  ingestLimiter // used only by read
+	standby *replicator   // pushing the session to the standby, if any
+	done    chan struct{} // closed once end has finished
+	hold    string        // the account's source slot it holds; see holdAccount
+
+	// Heartbeats, for sources that opt in with a session token; see
+	// expectHeartbeats.
+	token      string
+	lastBeat   time.Time
+	beatMissed bool
+
+	evicted atomic.Bool // taken off air by the server; see evictSource
+	stalled bool        // disconnected for ingest_timeout; see watchIngest
+	quiet   bool        // reported as sending silence; see watchSilentSources
+
+	// The ingest_min_bytes window: when it started, and bytes by then.
+	window      time.Time
+	windowBytes int64
+}
+
+// startSession begins a source session on a claimed mount. kick is called
+// to disconnect the source early; it must make the source stop writing and
+// call end.
+func (m *mount) startSession(account, id, remote, show string, kick func(reason string)) *sourceSession {
+	s := &sourceSession{m: m, account: account, id: id, remote: remote, started: clock.Default.Now(), untrack: track(subsysSources), done: make(chan struct{})}
+	ct := m.contentType()
+	if ct != m.cfg.ContentType {
+		s.logf("Source on %s is sending %s rather than its content_type %s; listeners are sent %s", m.cfg.Name, ct, m.cfg.ContentType, ct)
+	}
+	if extensionFor(ct) == ".mp3" {
+		s.frames = &mp3.Analyzer{}
+		if m.cfg.MaxDrift > 0 {
+			s.drift = newDriftCompensator(m.cfg.Name, time.Duration(m.cfg.MaxDrift)*time.Second)
+		}
+	}
+	events.Publish(events.Event{Type: events.SourceConnect, SessionID: id, Account: account, RemoteAddr: remote, Data: map[string]string{"mount": m.cfg.Name}})
+
+	// Listeners already waiting for a source are on this stream, as are
+	// those kept through the last source's reconnect grace period.
+	var resumed bool
+	s.stream, resumed = m.goLive()
+	if resumed {
+		s.logf("Streamer %s picked up %s with %d listeners waiting", account, m.cfg.Name, m.listenerCount())
+	}
+	m.setSource(account)
+	m.setKick(kick)
+	m.stateMu.Lock()
+	if a := m.claim; a != nil {
+		if a.held {
+			s.hold = a.id
+		}
+		m.claim = nil
+	}
+	m.stateMu.Unlock()
+	m.setSession(s)
+	m.lastData.Store(clock.Default.Now().UnixNano())
+
+	if m.cfg.Record {
+		rec, err := startRecorder(m, account, show, id)
+		if err != nil {
+			s.logf("Not recording %s: %v", m.cfg.Name, err)
+		} else {
+			s.logf("Recording %s to %s", m.cfg.Name, rec.path)
+			m.setRecorder(rec)
+		}
+	}
+	return s
+}
+
+// write broadcasts one chunk of source data. The caller may reuse data
+// afterwards; listeners get their own copy. With drift compensation,
+// silence fill or handoffs on, only whole frames go out, so a frame split
+// across chunks waits for the rest of it.
+func (s *sourceSession) write(data []byte) {
+	m := s.m
+	m.captureSource(data)
+	m.tapSource(data)
+	m.lastData.Store(clock.Default.Now().UnixNano())
+	m.endDeadAir()
+	if s.stream.started() {
+		s.logf("First stream data received; unblocking listeners")
+	}
+	s.statsMu.Lock()
+	s.bytes += int64(len(data))
+	switch {
+	case s.drift != nil:
+		s.frames.Feed(data, s.drift.frames())
+		data = s.drift.take()
+	case s.frames != nil && (m.cfg.SilenceFill > 0 || m.cfg.Handoff):
+		// Silent frames, or the next DJ's, can then go in between any
+		// two chunks.
+		var whole []byte
+		s.frames.Feed(data, func(frame []byte, _ mp3.Header) {
+			whole = append(whole, frame...)
+		})
+		data = whole
+	default:
+		if s.frames != nil {
+			s.frames.Write(data)
+		}
+		data = append([]byte(nil), data...)
+	}
+	if !s.silent && m.cfg.SilenceFill > 0 && s.frames != nil && len(data) > 0 {
+		if frame, ok := mp3.SilentFrame(data); ok {
+			m.setSilence(frame)
+			s.silent = true
+		}
+	}
+	standby := s.standby
+	s.statsMu.Unlock()
+	if len(data) > 0 {
+		m.broadcast(data)
+		if standby != nil {
+			standby.push(data)
+		}
+	}
+}
+
+// pump plays r into the session at rate bytes per second, the way a live
+// encoder would send it. It returns the bytes sent, with a nil error at the
+// end of r and context.Canceled if ctx ends first.
+func (s *sourceSession) pump(ctx context.Context, r io.Reader, rate float64) (int64, error) {
+	return pump(ctx, r, rate, s.write)
+}
+
+// pump feeds r to write at rate bytes per second.
+func pump(ctx context.Context, r io.Reader, rate float64, write func([]byte)) (int64, error) {
+	start := clock.Default.Now()
+	var sent int64
+	buf := make([]byte, 64*1024)
+	t := clock.Default.NewTicker(pumpTick)
+	defer t.Stop()
+	for {
+		due := int64(clock.Default.Since(start).Seconds()*rate) - sent
+		for due > 0 {
+			n := len(buf)
+			if int64(n) > due {
+				n = int(due)
+			}
+			n, err := r.Read(buf[:n])
+			if n > 0 {
+				write(buf[:n])
+				sent += int64(n)
+				due -= int64(n)
+			}
+			if err == io.EOF {
+				return sent, nil
+			}
+			if err != nil {
+				return sent, err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return sent, context.Canceled
+		case <-t.C():
+		}
+	}
+}
+
+// audioRate works out the byte rate at which size bytes of the mount's
+// audio from r should be played: by counting frames for MP3, or from the
+// mount's nominal bitrate otherwise.
+func audioRate(m *mount, r io.Reader, size int64) (float64, error) {
+	if extensionFor(m.cfg.ContentType) == ".mp3" {
+		d, _, err := mp3.Scan(r)
+		if err != nil {
+			return 0, err
+		}
+		return float64(size) / d.Seconds(), nil
+	}
+	if m.cfg.Bitrate <= 0 {
+		return 0, fmt.Errorf("mount %s needs a bitrate setting to play %s audio", m.cfg.Name, m.cfg.ContentType)
+	}
+	return float64(m.cfg.Bitrate * 1000 / 8), nil
+}
+
+// read feeds the session from an encoder's connection until it closes or
+// fails, returning the error, and holds the source to its ingest_limit.
+// Each read takes whatever has arrived, so chunk and HTTP/2 frame sizes
+// don't matter, and holding back is just not reading: over HTTP/2 that
+// lets flow control slow the encoder down rather than buffering here. A
+// connection taken over from net/http isn't closed by its shutdown, so
+// interrupt is called to stop reading if the stream is stopped.
+func (s *sourceSession) read(body io.Reader, interrupt func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stream.ctx.Done():
+			interrupt()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, s.m.cfg.ReadSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			s.write(buf[:n])
+			if wait := s.throttle(n); wait > 0 {
+				t := clock.Default.NewTimer(wait)
+				select {
+				case <-t.C():
+				case <-s.stream.ctx.Done():
+					t.Stop()
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// end tears the session down and frees the mount for the next source.
+func (s *sourceSession) end() {
+	defer close(s.done)
+	defer s.untrack()
+	m := s.m
+	m.endDeadAir()
+	s.logf("Streamer %s disconnected from %s", s.account, s.remote)
+	events.Publish(events.Event{Type: events.SourceDisconnect, SessionID: s.id, Account: s.account, RemoteAddr: s.remote, Data: map[string]string{"mount": m.cfg.Name}})
+	if rec := m.currentRecorder(); rec != nil {
+		m.setRecorder(nil)
+		if err := rec.close(); err != nil {
+			s.logf("Error closing recording %s: %v", rec.path, err)
+		}
+		purgeArchive()
+	}
+	if r := s.replicator(); r != nil {
+		r.stop()
+	}
+	m.setKick(nil)
+	m.setSession(nil)
+	m.setSource("")
+	releaseAccount(s.account, s.hold)
+	if !s.evicted.Load() && m.holdForReconnect(s.stream, s.account, s.resumeOffset()) {
+		return
+	}
+	if m.drain(s.stream, stateLive) {
+		m.endStream(s.stream) // Ready for the next source
+	}
+}
+
+func (s *sourceSession) logf(format string, args ...interface{}) {
+	log.Printf("[%s] "+format, append([]interface{}{s.id}, args...)...)
+}
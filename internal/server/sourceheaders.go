@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// missingSourceHeader returns the name of the first source_header the
+// request doesn't carry with an accepted value, or "" if it has them all.
+func (m *mount) missingSourceHeader(r *http.Request) string {
+	accepted := make(map[string][]string)
+	var names []string
+	for _, line := range m.cfg.SourceHeaders {
+		i := strings.Index(line, ":")
+		name := http.CanonicalHeaderKey(strings.TrimSpace(line[:i]))
+		if _, ok := accepted[name]; !ok {
+			names = append(names, name)
+		}
+		accepted[name] = append(accepted[name], strings.TrimSpace(line[i+1:]))
+	}
+	for _, name := range names {
+		got := []byte(r.Header.Get(name))
+		ok := false
+		for _, want := range accepted[name] {
+			if subtle.ConstantTimeCompare(got, []byte(want)) == 1 {
+				ok = true
+			}
+		}
+		if !ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// admitSourceHeaders turns away source connections that lack the mount's
+// source_header values, which automation systems send as a second factor
+// next to the streamer's NickServ credentials.
+func (m *mount) admitSourceHeaders(w http.ResponseWriter, r *http.Request, user string) bool {
+	name := m.missingSourceHeader(r)
+	if name == "" {
+		return true
+	}
+	logf(r, "Streamer %s from %s refused on %s: missing or wrong %s header", user, r.RemoteAddr, m.cfg.Name, name)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
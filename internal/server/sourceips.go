@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+)
+
+// sourceAllowed reports whether user may broadcast from remote, an
+// address or host:port. Accounts with source_ip lines may only broadcast
+// from those addresses, so a stolen password is no use elsewhere; other
+// accounts may broadcast from anywhere.
+func (st *station) sourceAllowed(user, remote string) bool {
+	allowed, ok := st.cfg.SourceIPs[user]
+	if !ok {
+		return true
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	addr, err := netip.ParseAddr(remote)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range allowed {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"nickcast/config"
+	"nickcast/internal/srt"
+	"strings"
+	"time"
+)
+
+// serveSRT accepts SRT sources on srt_listen until ctx is cancelled. SRT
+// resends what a lossy link drops, so a broadcaster on hotel Wi-Fi or a
+// mobile connection can get through where a TCP stream would stall.
+func serveSRT(ctx context.Context) error {
+	addr := config.AppConfig.SRTListen
+	ln, err := srt.Listen(addr, time.Duration(config.AppConfig.SRTLatency)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for SRT sources on %s (UDP)", addr)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		req, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			id := newRequestID()
+			defer recoverSource("srt", id, req.RemoteAddr.String())
+			srtSource(req, id)
+		}()
+	}
+}
+
+// parseStreamID reads a caller's stream ID, in SRT's access control syntax:
+//
+//	#!::r=<mount>,u=<nick>,pass=<password>[,m=publish]
+func parseStreamID(sid string) (map[string]string, bool) {
+	if !strings.HasPrefix(sid, "#!::") {
+		return nil, false
+	}
+	keys := make(map[string]string)
+	for _, kv := range strings.Split(sid[len("#!::"):], ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, false
+		}
+		keys[k] = v
+	}
+	return keys, true
+}
+
+// srtSource answers an SRT caller and, if it is let in, feeds its mount
+// from it the way streamHandler does for HTTP sources.
+func srtSource(req *srt.Request, id string) {
+	remote := req.RemoteAddr.String()
+	logf := func(format string, args ...interface{}) {
+		log.Printf("[%s] "+format, append([]interface{}{id}, args...)...)
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil && bans.banned(addr.Unmap()) {
+			logf("Rejected SRT source from banned address %s", remote)
+			req.Reject(srt.RejectForbidden)
+			return
+		}
+	}
+
+	keys, ok := parseStreamID(req.StreamID)
+	if !ok || keys["r"] == "" || keys["u"] == "" || keys["pass"] == "" {
+		logf("SRT source from %s refused: stream ID %q is not #!::r=<mount>,u=<nick>,pass=<password>", remote, req.StreamID)
+		req.Reject(srt.RejectBadRequest)
+		return
+	}
+	if mode := keys["m"]; mode != "" && mode != "publish" {
+		req.Reject(srt.RejectBadMode)
+		return
+	}
+	m := findMount(keys["r"])
+	if m == nil || m.cfg.DownmixOf != "" {
+		req.Reject(srt.RejectNotFound)
+		return
+	}
+	if len(m.cfg.SourceHeaders) > 0 {
+		logf("SRT source from %s refused: %s requires source_header, which SRT can't send", remote, m.cfg.Name)
+		req.Reject(srt.RejectForbidden)
+		return
+	}
+
+	user := keys["u"]
+	valid, err := m.station.authenticate(user, keys["pass"])
+	if err != nil || !valid {
+		logf("Auth failed for user %s from %s: %v", user, remote, err)
+		req.Reject(srt.RejectUnauthorized)
+		return
+	}
+	a, ref := m.admitSource(id, user, remote, sourceAsk{})
+	if ref != nil {
+		req.Reject(srtRejection(ref))
+		return
+	}
+
+	conn := req.Accept()
+	defer conn.Close()
+
+	// From here on the caller only learns of a refusal by being
+	// disconnected.
+	body, format, video, err := m.sniffSource(conn)
+	if err != nil {
+		logf("Streamer read error for %s from %s: %v", user, remote, err)
+		return
+	}
+	if video != "" {
+		videoRejections.With(m.cfg.Name).Inc()
+		logf("Streamer %s from %s refused on %s: the stream is %s video", user, remote, m.cfg.Name, video)
+		return
+	}
+	// Only one streamer at a time, unless the new one takes over.
+	if a.claim(nil) != nil {
+		return
+	}
+	m.setFormat(format)
+
+	logf("Streamer %s connected to %s from %s over SRT (latency %s)", user, m.cfg.Name, remote, conn.Latency())
+	kick := func(reason string) {
+		logf("Disconnecting streamer %s from %s: %s", user, m.cfg.Name, reason)
+		conn.Close()
+	}
+	sess := m.startSession(user, id, remote, "", kick)
+	sess.replicate()
+	defer sess.end()
+
+	err = sess.read(body, conn.Close)
+	logf("Streamer read error for %s from %s: %v (%d packets lost)", user, remote, err, conn.Dropped())
+}
+
+// srtRejection is the SRT rejection reason for ref: SRT's access control
+// codes are HTTP's statuses plus 1000.
+func srtRejection(ref *sourceRefusal) int {
+	return 1000 + ref.status
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/NickServAuth"
+	"sort"
+)
+
+// station is one tenant sharing the process: its mounts authenticate
+// against its own NickServ backend and are administered by its own admins.
+type station struct {
+	cfg    config.Station
+	auth   authenticator
+	usage  usage
+	player *template.Template // from player_template, if set
+
+	// shadow is consulted alongside auth without being enforced; see
+	// shadowauth.go.
+	shadow authenticator
+}
+
+// authenticator checks logins against an auth backend. A refusal with a
+// reason is an error wrapping NickServAuth.ErrRejected; other errors mean
+// the backend couldn't be asked. NickServAuth.AuthClient, which speaks
+// NickServ's check_auth API, is the only one so far; a station's main and
+// shadow backends may be any.
+type authenticator interface {
+	Authenticate(user, pass string) (bool, error)
+}
+
+var _ authenticator = (*NickServAuth.AuthClient)(nil)
+
+var (
+	// stations holds every configured station, keyed by name.
+	stations = make(map[string]*station)
+)
+
+func newStation(cfg config.Station) *station {
+	st := &station{cfg: cfg, auth: NickServAuth.NewAuthClient(cfg.AuthURL, cfg.APIToken)}
+	if cfg.ShadowAuthURL != "" {
+		st.shadow = NickServAuth.NewAuthClient(cfg.ShadowAuthURL, cfg.ShadowAPIToken)
+	}
+	return st
+}
+
+// defaultStation is the station for requests that aren't about any
+// particular mount.
+func defaultStation() *station {
+	return stations[config.DefaultStationName]
+}
+
+// authenticate checks an account against the station's NickServ API,
+// comparing the shadow backend's answer if there is one.
+func (st *station) authenticate(user, pass string) (bool, error) {
+	valid, err := st.auth.Authenticate(user, pass)
+	if st.shadow != nil {
+		st.compareShadow(user, pass, valid, err)
+	}
+	return valid, err
+}
+
+func (st *station) isAdmin(user string) bool {
+	for _, a := range st.cfg.Admins {
+		if a == user {
+			return true
+		}
+	}
+	return false
+}
+
+// setBranding sends the station's name and links to listeners the way
+// Icecast does, so players and directories show the right station.
+func (st *station) setBranding(w http.ResponseWriter) {
+	for header, value := range map[string]string{
+		"icy-name":        st.cfg.Title,
+		"icy-description": st.cfg.Description,
+		"icy-url":         st.cfg.URL,
+		"icy-genre":       st.cfg.Genre,
+	} {
+		if value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+}
+
+type stationMountInfo struct {
+	Name       string        `json:"name"`
+	ListenPath string        `json:"listen_path"`
+	Live       bool          `json:"live"`
+	Listeners  int           `json:"listeners"`
+	Stream     *sourceStream `json:"stream,omitempty"` // while a source is live
+}
+
+type stationInfo struct {
+	Name        string             `json:"name"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	URL         string             `json:"url,omitempty"`
+	Genre       string             `json:"genre,omitempty"`
+	Hosts       []string           `json:"hosts,omitempty"`
+	Listeners   int                `json:"listeners"`
+	Mounts      []stationMountInfo `json:"mounts"`
+}
+
+// stationsHandler serves /api/stations, the public directory of stations
+// with their mounts, listener counts and what is on air.
+func stationsHandler(w http.ResponseWriter, r *http.Request) {
+	var list []stationInfo
+	for _, sc := range config.AppConfig.Stations {
+		info := stationInfo{
+			Name:        sc.Name,
+			Title:       sc.Title,
+			Description: sc.Description,
+			URL:         sc.URL,
+			Genre:       sc.Genre,
+			Hosts:       sc.Hosts,
+			Mounts:      []stationMountInfo{},
+		}
+		for _, m := range mounts() {
+			if m.cfg.Station != sc.Name {
+				continue
+			}
+			n := m.listenerCount()
+			info.Listeners += n
+			info.Mounts = append(info.Mounts, stationMountInfo{
+				Name:       m.cfg.Name,
+				ListenPath: m.cfg.ListenPath,
+				Live:       m.active(),
+				Listeners:  n,
+				Stream:     m.sourceStream(),
+			})
+		}
+		sort.Slice(info.Mounts, func(i, j int) bool { return info.Mounts[i].Name < info.Mounts[j].Name })
+		list = append(list, info)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
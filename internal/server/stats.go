@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/xml"
+	"net"
+	"net/http"
+	"nickcast/internal/clock"
+	"nickcast/internal/events"
+	"nickcast/internal/metrics"
+	"sort"
+	"strconv"
+)
+
+// Icecast's date formats, which /admin/stats parsers expect verbatim.
+const (
+	icecastTime    = "Mon, 02 Jan 2006 15:04:05 -0700"
+	icecastISOTime = "2006-01-02T15:04:05-0700"
+)
+
+var (
+	serverStart = clock.Default.Now()
+
+	listenerConnections = metrics.NewCounter("nickcast_listener_connections_total", "Listeners that have connected since startup.")
+	sourceConnections   = metrics.NewCounter("nickcast_source_connections_total", "Sources that have connected since startup.")
+)
+
+func init() {
+	events.Subscribe(func(e events.Event) {
+		switch e.Type {
+		case events.ListenerConnect:
+			listenerConnections.Inc()
+		case events.SourceConnect:
+			sourceConnections.Inc()
+		}
+	})
+}
+
+// icestats is Icecast's /admin/stats document. Counters nickcast doesn't
+// have (file serving, relays, the stats protocol) are always zero, but
+// present, because scripts look them up unconditionally.
+type icestats struct {
+	XMLName                 xml.Name       `xml:"icestats"`
+	Admin                   string         `xml:"admin"`
+	ClientConnections       int64          `xml:"client_connections"`
+	Clients                 int            `xml:"clients"`
+	Connections             int64          `xml:"connections"`
+	FileConnections         int            `xml:"file_connections"`
+	Host                    string         `xml:"host"`
+	ListenerConnections     int64          `xml:"listener_connections"`
+	Listeners               int            `xml:"listeners"`
+	Location                string         `xml:"location"`
+	ServerID                string         `xml:"server_id"`
+	ServerStart             string         `xml:"server_start"`
+	ServerStartISO          string         `xml:"server_start_iso8601"`
+	SourceClientConnections int64          `xml:"source_client_connections"`
+	SourceRelayConnections  int            `xml:"source_relay_connections"`
+	SourceTotalConnections  int64          `xml:"source_total_connections"`
+	Sources                 int            `xml:"sources"`
+	Stats                   int            `xml:"stats"`
+	StatsConnections        int            `xml:"stats_connections"`
+	Source                  []icecastMount `xml:"source"`
+}
+
+// icecastMount is one <source> element: a live mount.
+type icecastMount struct {
+	Mount             string `xml:"mount,attr"`
+	AudioInfo         string `xml:"audio_info,omitempty"`
+	Bitrate           int    `xml:"bitrate,omitempty"`
+	Genre             string `xml:"genre"`
+	ListenerPeak      int    `xml:"listener_peak"`
+	Listeners         int    `xml:"listeners"`
+	ListenURL         string `xml:"listenurl"`
+	MaxListeners      string `xml:"max_listeners"`
+	Public            int    `xml:"public"`
+	ServerDescription string `xml:"server_description"`
+	ServerName        string `xml:"server_name"`
+	ServerType        string `xml:"server_type"`
+	ServerURL         string `xml:"server_url"`
+	SlowListeners     int    `xml:"slow_listeners"`
+	SourceIP          string `xml:"source_ip"`
+	StreamStart       string `xml:"stream_start"`
+	StreamStartISO    string `xml:"stream_start_iso8601"`
+	Title             string `xml:"title"`
+	TotalBytesRead    int64  `xml:"total_bytes_read"`
+	TotalBytesSent    int64  `xml:"total_bytes_sent"`
+}
+
+// noteListeners keeps track of the session's peak audience.
+func (s *sourceSession) noteListeners(n int) {
+	s.statsMu.Lock()
+	if n > s.peak {
+		s.peak = n
+	}
+	s.statsMu.Unlock()
+}
+
+func (m *mount) icecastStats(r *http.Request, s *sourceSession) icecastMount {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	st := m.station.cfg
+	im := icecastMount{
+		Mount:             m.cfg.ListenPath,
+		Genre:             st.Genre,
+		Listeners:         m.listenerCount(),
+		ListenURL:         scheme + "://" + r.Host + m.cfg.ListenPath,
+		MaxListeners:      "unlimited",
+		ServerDescription: st.Description,
+		ServerName:        st.Title,
+		ServerType:        m.contentType(),
+		ServerURL:         st.URL,
+		StreamStart:       s.started.Format(icecastTime),
+		StreamStartISO:    s.started.Format(icecastISOTime),
+		Title:             m.currentTitle(),
+		TotalBytesSent:    m.bytesSent.Load(),
+	}
+	if m.cfg.MaxListeners > 0 {
+		im.MaxListeners = strconv.Itoa(m.cfg.MaxListeners)
+	}
+	if m.cfg.Bitrate > 0 {
+		im.Bitrate = m.cfg.Bitrate
+		im.AudioInfo = "bitrate=" + strconv.Itoa(m.cfg.Bitrate)
+	}
+	im.SourceIP = s.remote
+	if host, _, err := net.SplitHostPort(s.remote); err == nil {
+		im.SourceIP = host
+	}
+	s.statsMu.Lock()
+	im.ListenerPeak = s.peak
+	// What the encoder says about its stream wins, as on Icecast.
+	if s.ice.Name != "" {
+		im.ServerName = s.ice.Name
+	}
+	if s.ice.Genre != "" {
+		im.Genre = s.ice.Genre
+	}
+	if s.ice.Description != "" {
+		im.ServerDescription = s.ice.Description
+	}
+	if s.ice.URL != "" {
+		im.ServerURL = s.ice.URL
+	}
+	if s.ice.AudioInfo != "" {
+		im.AudioInfo = s.ice.AudioInfo
+	}
+	if s.ice.Public {
+		im.Public = 1
+	}
+	im.TotalBytesRead = s.bytes
+	s.statsMu.Unlock()
+	return im
+}
+
+// statsHandler serves Icecast's /admin/stats XML, so monitoring scripts and
+// dashboards written against Icecast work unchanged. Like Icecast it lists
+// live mounts only. ?mount= limits it to one mount, which that station's
+// admins may see; without it, default station admins get every mount.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	var list []*mount
+	if ref := r.URL.Query().Get("mount"); ref != "" {
+		m := requestMount(r, ref)
+		if m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+		if _, ok := requireAdmin(w, r, m.station); !ok {
+			return
+		}
+		list = append(list, m)
+	} else {
+		if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+			return
+		}
+		for _, m := range mounts() {
+			list = append(list, m)
+		}
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	stats := icestats{
+		Host:                    host,
+		ListenerConnections:     listenerConnections.Value(),
+		SourceClientConnections: sourceConnections.Value(),
+		SourceTotalConnections:  sourceConnections.Value(),
+		ServerID:                "NickCast",
+		ServerStart:             serverStart.Format(icecastTime),
+		ServerStartISO:          serverStart.Format(icecastISOTime),
+		Source:                  []icecastMount{},
+	}
+	stats.ClientConnections = stats.ListenerConnections + stats.SourceClientConnections
+	stats.Connections = stats.ClientConnections
+	for _, m := range mounts() {
+		if m.currentSession() != nil {
+			stats.Sources++
+		}
+		stats.Listeners += m.listenerCount()
+	}
+	stats.Clients = stats.Listeners + stats.Sources
+	for _, m := range list {
+		if s := m.currentSession(); s != nil {
+			stats.Source = append(stats.Source, m.icecastStats(r, s))
+		}
+	}
+	sort.Slice(stats.Source, func(i, j int) bool { return stats.Source[i].Mount < stats.Source[j].Mount })
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(stats)
+	w.Write([]byte("\n"))
+}
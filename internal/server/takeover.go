@@ -0,0 +1,130 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"nickcast/internal/events"
+	"strconv"
+)
+
+// sourcePriority reads the priority a source connection asks for, from the
+// X-Source-Priority header or the priority query parameter. Sources that
+// don't ask have priority 0.
+func sourcePriority(r *http.Request) (int, error) {
+	v := r.Header.Get("X-Source-Priority")
+	if v == "" {
+		v = r.URL.Query().Get("priority")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
+
+// maxPriority returns the highest priority user may ask for, and false if
+// they may ask for any: admins aren't capped, and other accounts may ask
+// for what their max_priority line allows, or 0 without one.
+func (st *station) maxPriority(user string) (int, bool) {
+	if st.isAdmin(user) {
+		return 0, false
+	}
+	return st.cfg.MaxPriorities[user], true
+}
+
+// setPriority records the priority of the source that has just claimed
+// the mount.
+func (m *mount) setPriority(priority int) {
+	m.stateMu.Lock()
+	m.priority = priority
+	m.stateMu.Unlock()
+}
+
+// canTakeOver reports whether a source at priority would take over the
+// mount's live source: its priority is higher, and either the mount has
+// takeover set or the live source is the auto-DJ, which makes way for
+// anyone.
+func (m *mount) canTakeOver(priority int) bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.loadState() == stateLive && priority > m.priority && (m.cfg.Takeover || m.priority < 0)
+}
+
+// Ways a source can take over a live mount.
+type takeoverKind int
+
+const (
+	byPriority takeoverKind = iota // canTakeOver allows it
+	byHandoff                      // the live DJ was warned and the handoff is due
+	byAccount                      // it replaces its own account's stale session
+	byAdmin                        // an admin put it on air in place of the live source
+)
+
+// takeOver claims a live mount for user's source at priority, reporting
+// false unless canTakeOver allows it or, for a handoff, a session
+// replacing its own or an admin's say-so, someone is live to take over
+// from. The live source is disconnected, and its session ends as usual
+// (its recording closed, its disconnect published) before takeOver
+// returns; its listeners stay on the stream for the new session, which
+// must be started or released as after claimSource. Closing cancel gives
+// up waiting for the old session, which leaves the mount with no source
+// and ends the stream.
+func (m *mount) takeOver(id, remote, user string, priority int, cancel <-chan struct{}, kind takeoverKind) bool {
+	m.stateMu.Lock()
+	s := m.currentSession()
+	if m.loadState() != stateLive || s == nil || kind == byPriority && (priority <= m.priority || (!m.cfg.Takeover && m.priority >= 0)) {
+		m.stateMu.Unlock()
+		return false
+	}
+	from := m.priority
+	m.claimedFrom = stateLive
+	m.priority = priority
+	m.setState(stateAuthenticating)
+	m.stateMu.Unlock()
+
+	data := map[string]string{
+		"mount":    m.cfg.Name,
+		"previous": s.account,
+		"priority": strconv.Itoa(priority),
+	}
+	reason := "taken over by " + user + " at priority " + strconv.Itoa(priority)
+	switch kind {
+	case byHandoff:
+		log.Printf("[%s] Streamer %s from %s is taking over %s from %s by handoff", id, user, remote, m.cfg.Name, s.account)
+		data["handoff"] = "true"
+		reason = "handed over to " + user
+	case byAccount:
+		log.Printf("[%s] Streamer %s from %s is replacing their own session on %s from %s", id, user, remote, m.cfg.Name, s.remote)
+		data["replaced"] = "true"
+		reason = "replaced by a new connection from " + remote
+	case byAdmin:
+		log.Printf("[%s] %s from %s is taking over %s from %s at an admin's request", id, user, remote, m.cfg.Name, s.account)
+		data["admin"] = "true"
+		reason = "replaced by " + user + " from " + remote
+	default:
+		log.Printf("[%s] Streamer %s from %s is taking over %s from %s (priority %d over %d)", id, user, remote, m.cfg.Name, s.account, priority, from)
+	}
+	events.Publish(events.Event{Type: events.SourceTakeover, SessionID: id, Account: user, RemoteAddr: remote, Data: data})
+	m.kickSource(reason)
+	select {
+	case <-s.done:
+		return true
+	case <-cancel:
+		// The new source gave up while the old one was still ending, which
+		// leaves the stream with neither.
+		m.releaseSource()
+		return false
+	}
+}
+
+// claimOrPreempt claims the mount for an internal source (a show or an
+// announcement) or, if the auto-DJ is on air, takes over from it.
+func (m *mount) claimOrPreempt(id, remote, account string, cancel <-chan struct{}) bool {
+	if m.claimSource() {
+		return true
+	}
+	return m.takeOver(id, remote, account, 0, cancel, byPriority)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"nickcast/internal/metrics"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// tapPath is where the tap socket serves a mount's raw ingest.
+	tapPath = "/internal/tap"
+
+	// tapQueue is how many chunks a tap may fall behind by before chunks
+	// are dropped. The source is never held up for it.
+	tapQueue = 256
+)
+
+var (
+	tapBytes   = metrics.NewCounterVec("nickcast_tap_bytes_total", "Raw source bytes mirrored to a tap socket consumer.", "mount")
+	tapDropped = metrics.NewCounterVec("nickcast_tap_dropped_bytes_total", "Raw source bytes dropped because the tap socket consumer fell behind.", "mount")
+)
+
+// ingestTap mirrors what a mount's source sends, before any processing,
+// to one consumer on the tap socket, such as an analyzer or transcriber.
+// It is not a listener: it isn't counted, limited or reported as one.
+type ingestTap struct {
+	ch chan []byte
+}
+
+// tapSource hands raw source input to the mount's tap, if it has one.
+func (m *mount) tapSource(p []byte) {
+	t := m.tap.Load()
+	if t == nil {
+		return
+	}
+	select {
+	case t.ch <- append([]byte(nil), p...):
+		tapBytes.With(m.cfg.Name).Add(int64(len(p)))
+	default:
+		tapDropped.With(m.cfg.Name).Add(int64(len(p)))
+	}
+}
+
+// tapHandler streams ?mount='s raw ingest until the consumer goes away,
+// across source sessions: between them it just waits. A mount has one
+// tap at a time; while it is taken, others get 409.
+func tapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := requestMount(r, r.URL.Query().Get("mount"))
+	if m == nil {
+		http.Error(w, "No such mount", http.StatusNotFound)
+		return
+	}
+	t := &ingestTap{ch: make(chan []byte, tapQueue)}
+	if !m.tap.CompareAndSwap(nil, t) {
+		http.Error(w, "Mount already has a tap", http.StatusConflict)
+		return
+	}
+	defer m.tap.Store(nil)
+	log.Printf("Mount %s: tap connected", m.cfg.Name)
+	defer log.Printf("Mount %s: tap disconnected", m.cfg.Name)
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", m.cfg.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case p := <-t.ch:
+			if _, err := w.Write(p); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// serveTap serves the tap on the Unix socket at path until ctx is
+// cancelled. The socket is made readable and writable by its owner only:
+// whoever can connect to it hears every source unfiltered. It is created
+// in a directory of its own, which only the owner may enter, and moved to
+// path once it is 0600, so nobody can connect while the umask's mode is
+// still on it.
+func serveTap(ctx context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".tap-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "tap.sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return err
+	}
+	// Closing the listener would remove tmp, not path.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return err
+	}
+	defer os.Remove(path)
+	mux := http.NewServeMux()
+	mux.HandleFunc(tapPath, tapHandler)
+	srv := &http.Server{Handler: recoverMiddleware(mux)}
+	return serve(ctx, srv, func() error {
+		log.Printf("Tap socket listening on %s", path)
+		return srv.Serve(ln)
+	})
+}
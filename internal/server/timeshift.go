@@ -0,0 +1,64 @@
+package server
+
+import "sync"
+
+// history is a fixed-size ring of the most recent audio on a mount. Unlike
+// the burst buffer it is never copied wholesale on write, so it can be
+// sized to minutes of audio for clipping.
+type history struct {
+	mu   sync.Mutex
+	buf  []byte
+	pos  int  // next write position
+	full bool // buf has wrapped at least once
+}
+
+func newHistory(size int) *history {
+	return &history{buf: make([]byte, size)}
+}
+
+func (h *history) write(data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(data) >= len(h.buf) {
+		copy(h.buf, data[len(data)-len(h.buf):])
+		h.pos, h.full = 0, true
+		return
+	}
+	n := copy(h.buf[h.pos:], data)
+	if n < len(data) {
+		copy(h.buf, data[n:])
+		h.full = true
+	}
+	h.pos = (h.pos + len(data)) % len(h.buf)
+	if h.pos == 0 {
+		h.full = true
+	}
+}
+
+// last returns a copy of up to n of the most recent bytes.
+func (h *history) last(n int) []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	avail := h.pos
+	if h.full {
+		avail = len(h.buf)
+	}
+	if n > avail {
+		n = avail
+	}
+	out := make([]byte, n)
+	start := h.pos - n
+	if start >= 0 {
+		copy(out, h.buf[start:h.pos])
+	} else {
+		k := copy(out, h.buf[len(h.buf)+start:])
+		copy(out[k:], h.buf[:h.pos])
+	}
+	return out
+}
+
+func (h *history) reset() {
+	h.mu.Lock()
+	h.pos, h.full = 0, false
+	h.mu.Unlock()
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"nickcast/internal/clock"
+	"nickcast/internal/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// udpTimeout is how long a UDP source may go without sending before
+	// its stream ends. UDP has no disconnect, so silence is the only sign.
+	udpTimeout = 5 * time.Second
+
+	// rtpPayloadMPA is RTP's static payload type for MPEG audio (RFC 2250),
+	// which puts four bytes of its own header before the frames.
+	rtpPayloadMPA = 14
+)
+
+var udpLost = metrics.NewCounterVec("nickcast_udp_packets_lost_total", "RTP packets that never arrived, or arrived too late, on UDP sources.", "mount")
+
+// errUDPStopped ends a UDP source's stream when it is kicked.
+var errUDPStopped = errors.New("source stopped")
+
+// serveUDP takes UDP sources on every mount with a udp_listen address,
+// until ctx is cancelled.
+func serveUDP(ctx context.Context) error {
+	var conns []*net.UDPConn
+	for _, m := range mounts() {
+		if m.cfg.UDPListen == "" {
+			continue
+		}
+		conn, err := listenUDP(m.cfg.UDPListen)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return fmt.Errorf("mount %s: %w", m.cfg.Name, err)
+		}
+		conns = append(conns, conn)
+		log.Printf("Mount %s: UDP source on %s (%s)", m.cfg.Name, m.cfg.UDPListen, m.cfg.UDPFormat)
+	}
+
+	var wg sync.WaitGroup
+	i := 0
+	for _, m := range mounts() {
+		if m.cfg.UDPListen == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(m *mount, conn *net.UDPConn) {
+			defer wg.Done()
+			m.serveUDPSource(ctx, conn)
+		}(m, conns[i])
+		i++
+	}
+	<-ctx.Done()
+	for _, c := range conns {
+		c.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+// listenUDP opens addr, joining its group if it is a multicast address.
+func listenUDP(addr string) (*net.UDPConn, error) {
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if ua.IP.IsMulticast() {
+		return net.ListenMulticastUDP("udp", nil, ua)
+	}
+	return net.ListenUDP("udp", ua)
+}
+
+// udpAllowed reports whether the mount takes UDP audio from addr.
+func (m *mount) udpAllowed(addr netip.Addr) bool {
+	return addrAllowed(m.cfg.UDPAllow, addr)
+}
+
+// addrAllowed reports whether addr is one of allow's addresses or CIDR
+// ranges.
+func addrAllowed(allow []string, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, a := range allow {
+		if p, err := netip.ParsePrefix(a); err == nil && p.Contains(addr) {
+			return true
+		}
+		if a, err := netip.ParseAddr(a); err == nil && a.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// serveUDPSource waits for audio to arrive on conn and puts each stream
+// of it on air, one sender at a time. A stream starts with the first
+// packet from an allowed sender and ends when that sender has been silent
+// for udpTimeout. A stream that can't go on air (another source is live,
+// say), or that is kicked, is ignored until its sender has paused for
+// udpTimeout, so a sender that keeps going isn't let straight back on.
+func (m *mount) serveUDPSource(ctx context.Context, conn *net.UDPConn) {
+	buf := make([]byte, 64*1024)
+	var ignoring netip.AddrPort
+	var ignoredAt time.Time
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Time{})
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !m.udpAllowed(from.Addr()) {
+			continue
+		}
+		if from == ignoring && clock.Default.Since(ignoredAt) < udpTimeout {
+			ignoredAt = clock.Default.Now()
+			continue
+		}
+		r := &udpReader{conn: conn, from: from, buf: buf, rtp: m.cfg.UDPFormat == "rtp", mount: m.cfg.Name}
+		r.pending = r.payload(buf[:n])
+		ignoring = netip.AddrPort{}
+		if !m.runUDPSource(ctx, r) {
+			ignoring, ignoredAt = from, clock.Default.Now()
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// runUDPSource runs udpSource for one sender's stream. A panic in it is
+// reported by recoverSource and ends only that stream, which counts as
+// refused.
+func (m *mount) runUDPSource(ctx context.Context, r *udpReader) (ok bool) {
+	id := newRequestID()
+	defer recoverSource("udp", id, r.from.String())
+	return m.udpSource(ctx, r, id)
+}
+
+// udpSource puts one sender's UDP stream on the mount, if it may go on
+// air, until the sender falls silent or the source is kicked. It reports
+// false if the stream was refused or kicked rather than falling silent.
+func (m *mount) udpSource(ctx context.Context, r *udpReader, id string) bool {
+	user, remote := m.cfg.UDPAccount, r.from.String()
+	logf := func(format string, args ...interface{}) {
+		log.Printf("[%s] "+format, append([]interface{}{id}, args...)...)
+	}
+	if bans.banned(r.from.Addr().Unmap()) {
+		logf("Rejected UDP source from banned address %s", remote)
+		return false
+	}
+	a, ref := m.admitSource(id, user, remote, sourceAsk{})
+	if ref != nil {
+		return false
+	}
+
+	body, format, video, err := m.sniffSource(r)
+	if err != nil {
+		logf("Streamer read error for %s from %s: %v", user, remote, err)
+		return false
+	}
+	if video != "" {
+		videoRejections.With(m.cfg.Name).Inc()
+		logf("UDP source from %s refused on %s: the stream is %s video", remote, m.cfg.Name, video)
+		return false
+	}
+	// Only one streamer at a time, unless the new one takes over.
+	if a.claim(ctx.Done()) != nil {
+		return false
+	}
+	m.setFormat(format)
+
+	logf("Streamer %s connected to %s from %s over UDP (%s)", user, m.cfg.Name, remote, m.cfg.UDPFormat)
+	kick := func(reason string) {
+		logf("Disconnecting streamer %s from %s: %s", user, m.cfg.Name, reason)
+		r.stop()
+	}
+	sess := m.startSession(user, id, remote, "", kick)
+	sess.replicate()
+	defer sess.end()
+
+	err = sess.read(body, func() error { r.stop(); return nil })
+	logf("Streamer read error for %s from %s: %v (%d packets lost)", user, remote, err, r.lost)
+	return !r.stopped.Load()
+}
+
+// udpReader reads one sender's audio from a UDP socket as a stream,
+// dropping everyone else's packets. It fails once the sender has been
+// silent for udpTimeout, or once stopped.
+type udpReader struct {
+	conn    *net.UDPConn
+	from    netip.AddrPort
+	mount   string
+	buf     []byte
+	pending []byte // audio from the last packet not yet read
+	stopped atomic.Bool
+
+	rtp      bool
+	seq      uint16 // last RTP sequence number
+	seqValid bool
+	lost     int64
+}
+
+func (r *udpReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.stopped.Load() {
+			return 0, errUDPStopped
+		}
+		r.conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, from, err := r.conn.ReadFromUDPAddrPort(r.buf)
+		if err != nil {
+			if isTimeout(err) && !r.stopped.Load() {
+				return 0, fmt.Errorf("nothing received for %s", udpTimeout)
+			}
+			if r.stopped.Load() {
+				return 0, errUDPStopped
+			}
+			return 0, err
+		}
+		if from == r.from {
+			r.pending = r.payload(r.buf[:n])
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// stop ends the stream, interrupting a Read in progress.
+func (r *udpReader) stop() {
+	r.stopped.Store(true)
+	r.conn.SetReadDeadline(time.Now())
+}
+
+// payload returns the audio in a packet. RTP packets are stripped of their
+// headers, and ones that arrive out of order or twice are dropped: a late
+// packet's audio can't be put back where it belonged, so it counts as lost.
+func (r *udpReader) payload(pkt []byte) []byte {
+	if !r.rtp {
+		return pkt
+	}
+	if len(pkt) < 12 || pkt[0]>>6 != 2 {
+		return nil // not RTP version 2
+	}
+	hdr := 12 + 4*int(pkt[0]&0x0f)
+	if pkt[0]&0x10 != 0 && len(pkt) >= hdr+4 {
+		hdr += 4 + 4*int(binary.BigEndian.Uint16(pkt[hdr+2:]))
+	}
+	if len(pkt) < hdr {
+		return nil
+	}
+	body := pkt[hdr:]
+	if pkt[0]&0x20 != 0 && len(body) > 0 {
+		if pad := int(body[len(body)-1]); pad <= len(body) {
+			body = body[:len(body)-pad]
+		}
+	}
+
+	seq := binary.BigEndian.Uint16(pkt[2:])
+	if r.seqValid {
+		d := seq - r.seq
+		if d == 0 || d >= 0x8000 {
+			return nil
+		}
+		if d > 1 {
+			r.lost += int64(d - 1)
+			udpLost.With(r.mount).Add(int64(d - 1))
+		}
+	}
+	r.seq, r.seqValid = seq, true
+
+	if pkt[1]&0x7f == rtpPayloadMPA {
+		if len(body) < 4 {
+			return nil
+		}
+		body = body[4:]
+	}
+	return body
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"nickcast/internal/clock"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// urlAuthTimeout bounds one call to an auth gateway.
+const urlAuthTimeout = 5 * time.Second
+
+// urlAuthClient talks to listener_add_url and listener_remove_url gateways.
+var urlAuthClient = &http.Client{Timeout: urlAuthTimeout}
+
+// admitURLAuth implements Icecast's URL listener authentication: the
+// mount's listener_add_url is POSTed the listener's details and admits them
+// by answering with an "icecast-auth-user: 1" header. It may also set
+// icecast-auth-message (shown to refused listeners) and
+// icecast-auth-timelimit (seconds the listener may stay). On success the
+// returned request carries any time limit, and done must be called when
+// the listener leaves, which reports it to listener_remove_url.
+func (m *mount) admitURLAuth(w http.ResponseWriter, r *http.Request) (admitted *http.Request, done func(), ok bool) {
+	if m.cfg.ListenerAddURL == "" {
+		return r, func() {}, true
+	}
+	form := m.urlAuthForm(r, "listener_add")
+	resp, err := urlAuthClient.PostForm(m.cfg.ListenerAddURL, form)
+	if err != nil {
+		logf(r, "Listener auth gateway for %s failed: %v", m.cfg.Name, err)
+		m.unavailable(w, r, "listen.auth_unavailable")
+		return nil, nil, false
+	}
+	resp.Body.Close()
+	// Icecast's gateways answer 200 either way and use the header to
+	// decide, so only a missing header or a server error means trouble.
+	if resp.StatusCode >= 500 {
+		logf(r, "Listener auth gateway for %s returned status %d", m.cfg.Name, resp.StatusCode)
+		m.unavailable(w, r, "listen.auth_unavailable")
+		return nil, nil, false
+	}
+	if strings.TrimSpace(resp.Header.Get("icecast-auth-user")) != "1" {
+		msg := resp.Header.Get("icecast-auth-message")
+		logf(r, "Listener from %s refused by auth gateway for %s: %q", r.RemoteAddr, m.cfg.Name, msg)
+		if msg == "" {
+			msg = m.tr(r, "listen.unauthorized")
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="NickStream"`)
+		http.Error(w, msg, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	start := clock.Default.Now()
+	cancel := func() {}
+	if secs, err := strconv.Atoi(resp.Header.Get("icecast-auth-timelimit")); err == nil && secs > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(r.Context(), time.Duration(secs)*time.Second)
+		r = r.WithContext(ctx)
+		logf(r, "Auth gateway for %s limits listener from %s to %ds", m.cfg.Name, r.RemoteAddr, secs)
+	}
+	done = func() {
+		cancel()
+		if m.cfg.ListenerRemoveURL == "" {
+			return
+		}
+		form["action"] = []string{"listener_remove"}
+		form.Set("duration", strconv.Itoa(int(clock.Default.Since(start).Seconds())))
+		// The listener is already gone; don't hold the handler up for it.
+		go func() {
+			resp, err := urlAuthClient.PostForm(m.cfg.ListenerRemoveURL, form)
+			if err != nil {
+				logf(r, "Listener remove notification for %s failed: %v", m.cfg.Name, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	return r, done, true
+}
+
+// urlAuthForm builds the POST body Icecast sends its auth gateways. mount
+// includes the query string, which is where gateways usually find tokens.
+func (m *mount) urlAuthForm(r *http.Request, action string) url.Values {
+	user, pass, _ := credentials(r)
+	server, port := r.Host, ""
+	if h, p, err := net.SplitHostPort(r.Host); err == nil {
+		server, port = h, p
+	}
+	return url.Values{
+		"action":  {action},
+		"server":  {server},
+		"port":    {port},
+		"client":  {requestID(r)},
+		"mount":   {r.URL.RequestURI()},
+		"user":    {user},
+		"pass":    {pass},
+		"ip":      {clientIP(r)},
+		"agent":   {r.UserAgent()},
+		"referer": {r.Referer()},
+	}
+}
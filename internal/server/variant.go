@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"nickcast/config"
+	"nickcast/internal/metrics"
+	"sort"
+	"strings"
+)
+
+// variantParam pins a listener to one arm of a mount's variants. Redirects
+// carry it, so the target doesn't route them again, and testers can use it
+// to pick an arm.
+const variantParam = "variant"
+
+var variantListeners = metrics.NewCounterVec("nickcast_variant_listeners_total", "Listeners routed to each arm of a mount's variants, control included.", "mount", "variant")
+
+// variantBucket places a listener in one of 100 buckets. It depends only
+// on the mount, the listener's network and their player, so a listener
+// who reconnects lands in the same arm.
+func (m *mount) variantBucket(r *http.Request) int {
+	h := fnv.New32a()
+	h.Write([]byte(m.cfg.Name + "\x00" + clientPrefix(r) + "\x00" + r.UserAgent()))
+	return int(h.Sum32() % 100)
+}
+
+// pickVariant returns the variant a listener is routed to, or nil to keep
+// them on the mount. ?variant= overrides the draw.
+func (m *mount) pickVariant(r *http.Request) (v *config.Variant, pinned bool) {
+	if name := r.URL.Query().Get(variantParam); name != "" {
+		for i := range m.cfg.Variants {
+			if m.cfg.Variants[i].Name == name {
+				return &m.cfg.Variants[i], true
+			}
+		}
+		return nil, true
+	}
+	b := m.variantBucket(r)
+	for i := range m.cfg.Variants {
+		if b < m.cfg.Variants[i].Weight {
+			return &m.cfg.Variants[i], false
+		}
+		b -= m.cfg.Variants[i].Weight
+	}
+	return nil, false
+}
+
+// routeVariant redirects a listener to the variant they are drawn for,
+// reporting whether it did. Listeners arriving with ?variant= already set
+// were routed before, or chose their arm, and are only redirected if they
+// asked for a variant by name.
+func (m *mount) routeVariant(w http.ResponseWriter, r *http.Request) bool {
+	if len(m.cfg.Variants) == 0 {
+		return false
+	}
+	v, pinned := m.pickVariant(r)
+	if name := r.URL.Query().Get(variantParam); v == nil && pinned && name != config.ControlVariant {
+		// A trial that has ended, most likely; the control arm carries on.
+		logf(r, "Listener from %s on %s asked for variant %q, which doesn't exist; kept on the mount", r.RemoteAddr, m.cfg.Name, name)
+	}
+	if v == nil || (pinned && localMount(v.Target) == m) {
+		if !pinned {
+			variantListeners.With(m.cfg.Name, config.ControlVariant).Inc()
+		}
+		return false
+	}
+	if !pinned {
+		variantListeners.With(m.cfg.Name, v.Name).Inc()
+	}
+
+	target, err := url.Parse(v.Target)
+	if err != nil {
+		// Checked when the config was loaded.
+		return false
+	}
+	q := r.URL.Query()
+	q.Set(variantParam, v.Name)
+	for k, vals := range target.Query() {
+		q[k] = vals
+	}
+	target.RawQuery = q.Encode()
+	logf(r, "Listener from %s on %s routed to variant %s (%s)", r.RemoteAddr, m.cfg.Name, v.Name, v.Target)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+	return true
+}
+
+// variantInfo is one arm of a mount's trial in /admin/variants.
+type variantInfo struct {
+	Mount   string `json:"mount"`
+	Variant string `json:"variant"`
+	Weight  int    `json:"weight"`
+	Target  string `json:"target"`
+	Routed  int64  `json:"routed"`              // listeners sent to the arm since startup
+	Current *int   `json:"listeners,omitempty"` // connected now, for arms served by a mount here
+}
+
+// variantsHandler serves /admin/variants: how each mount's listeners are
+// being split, and how many each arm has had. ?mount= limits it to one
+// mount, which that station's admins may see; without it, default station
+// admins get every mount with variants.
+func variantsHandler(w http.ResponseWriter, r *http.Request) {
+	var list []*mount
+	if ref := r.URL.Query().Get("mount"); ref != "" {
+		m := requestMount(r, ref)
+		if m == nil {
+			http.Error(w, "Unknown mount", http.StatusNotFound)
+			return
+		}
+		if _, ok := requireAdmin(w, r, m.station); !ok {
+			return
+		}
+		list = append(list, m)
+	} else {
+		if _, ok := requireAdmin(w, r, defaultStation()); !ok {
+			return
+		}
+		for _, m := range mounts() {
+			if len(m.cfg.Variants) > 0 {
+				list = append(list, m)
+			}
+		}
+	}
+
+	out := []variantInfo{}
+	for _, m := range list {
+		if len(m.cfg.Variants) == 0 {
+			continue
+		}
+		control := 100
+		for _, v := range m.cfg.Variants {
+			control -= v.Weight
+			out = append(out, variantInfo{
+				Mount:   m.cfg.Name,
+				Variant: v.Name,
+				Weight:  v.Weight,
+				Target:  v.Target,
+				Routed:  variantListeners.With(m.cfg.Name, v.Name).Value(),
+				Current: localListeners(v.Target),
+			})
+		}
+		n := m.listenerCount()
+		out = append(out, variantInfo{
+			Mount:   m.cfg.Name,
+			Variant: config.ControlVariant,
+			Weight:  control,
+			Target:  m.cfg.ListenPath,
+			Routed:  variantListeners.With(m.cfg.Name, config.ControlVariant).Value(),
+			Current: &n,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Mount < out[j].Mount })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// localMount returns the mount serving a variant's target, or nil if it
+// isn't served here.
+func localMount(target string) *mount {
+	if !strings.HasPrefix(target, "/") {
+		return nil
+	}
+	path, _, _ := strings.Cut(target, "?")
+	return findMount(path)
+}
+
+// localListeners returns the listener count of the mount serving target, or
+// nil if no mount here does.
+func localListeners(target string) *int {
+	m := localMount(target)
+	if m == nil {
+		return nil
+	}
+	n := m.listenerCount()
+	return &n
+}
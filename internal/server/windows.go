@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/clock"
+	"strconv"
+	"time"
+)
+
+// windowCheckInterval is how often active streams are checked against their
+// mount's broadcast windows.
+const windowCheckInterval = 15 * time.Second
+
+// now returns the current time in the configured broadcast time zone.
+func now() time.Time {
+	return clock.Default.Now().In(config.AppConfig.Location)
+}
+
+// admitWindow refuses sources and listeners outside the mount's broadcast
+// windows, telling them when to come back.
+func (m *mount) admitWindow(w http.ResponseWriter, r *http.Request) bool {
+	t := now()
+	if m.cfg.Windows.Contains(t) {
+		return true
+	}
+	if next, ok := m.cfg.Windows.NextOpen(t); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(next.Sub(t).Seconds())+1))
+	}
+	logf(r, "Request from %s to %s refused: outside broadcast window (%s)", r.RemoteAddr, m.cfg.Name, m.cfg.Windows)
+	m.listenerError(w, r, http.StatusForbidden, "window.off_air", "windows", m.cfg.Windows.String())
+	return false
+}
+
+// enforceWindows disconnects streams that are still running when their
+// mount's window closes. Listeners go with them via the normal end-of-stream
+// path.
+func enforceWindows(ctx context.Context) error {
+	t := clock.Default.NewTicker(windowCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+		}
+		at := now()
+		for _, m := range mounts() {
+			if len(m.cfg.Windows) > 0 && m.active() && !m.cfg.Windows.Contains(at) {
+				m.evictSource("broadcast window closed")
+			}
+		}
+	}
+}
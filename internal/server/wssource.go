@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"nickcast/config"
+	"nickcast/internal/websocket"
+	"strconv"
+	"time"
+)
+
+// wsSourcePath is where a mount takes WebSocket sources, under its source
+// path.
+const wsSourcePath = config.WSSourcePath
+
+// wsSourceHandler takes a source over WebSocket, for broadcasting from a
+// browser page with MediaRecorder or WebAudio, which can't stream a request
+// body. Each binary message is the next piece of the stream. Browsers
+// can't set headers on a WebSocket, so credentials come as
+// ?password=<nick>:<password> and a heartbeat token as ?session_token=.
+// Refusals before the upgrade are plain HTTP errors; after it they close
+// the socket with the reason, which the page can show.
+func (m *mount) wsSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsUpgrade(r) {
+		http.Error(w, "This is the WebSocket source endpoint; connect with new WebSocket(url)", http.StatusBadRequest)
+		return
+	}
+
+	// As for HTTP sources, a live source is only taken off air once this
+	// one has passed every check.
+	a, ok := m.admitHTTPSource(w, r)
+	if !ok {
+		return
+	}
+	user := a.user
+	token := sessionToken(r)
+	if token != "" && len(token) < minTokenLength {
+		http.Error(w, "Session token must be at least "+strconv.Itoa(minTokenLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		logf(r, "WebSocket upgrade from %s failed: %v", r.RemoteAddr, err)
+		return
+	}
+	// Whatever ends the stream, the page is told it has been let go.
+	closeCode, closeReason := websocket.CloseGoingAway, "Stream ended"
+	defer func() { conn.Close(closeCode, closeReason) }()
+
+	body, format, video, err := m.sniffSource(conn)
+	if err != nil {
+		logf(r, "Streamer read error for %s from %s: %v", user, r.RemoteAddr, err)
+		return
+	}
+	if video != "" {
+		videoRejections.With(m.cfg.Name).Inc()
+		logf(r, "Streamer %s from %s refused on %s: the stream is %s video", user, r.RemoteAddr, m.cfg.Name, video)
+		// A close reason has to fit in a control frame, so the page gets
+		// the short version.
+		closeCode, closeReason = websocket.CloseUnsupported, "Audio only: the source is sending "+video+" video"
+		return
+	}
+
+	// Only one streamer at a time, unless the new one takes over.
+	if ref := a.claim(r.Context().Done()); ref != nil {
+		closeCode, closeReason = websocket.CloseGoingAway, ref.msg
+		return
+	}
+	m.setFormat(format)
+
+	logf(r, "Streamer %s connected to %s from %s over WebSocket", user, m.cfg.Name, r.RemoteAddr)
+	kick := func(reason string) {
+		logf(r, "Disconnecting streamer %s from %s: %s", user, m.cfg.Name, reason)
+		if err := conn.SetReadDeadline(time.Now()); err != nil {
+			logf(r, "Could not interrupt streamer %s: %v", user, err)
+		}
+	}
+	sess := m.startSession(user, requestID(r), r.RemoteAddr, r.URL.Query().Get("show"), kick)
+	if token != "" {
+		sess.expectHeartbeats(token)
+	}
+	sess.replicate()
+	defer sess.end()
+
+	err = sess.read(body, func() error { return conn.SetReadDeadline(time.Now()) })
+	var closed *websocket.CloseError
+	if errors.As(err, &closed) {
+		closeCode, closeReason = websocket.CloseNormal, ""
+	}
+	logf(r, "Streamer read error for %s from %s: %v", user, r.RemoteAddr, err)
+}
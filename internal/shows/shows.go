@@ -0,0 +1,146 @@
+// Package shows stores pre-recorded shows uploaded by DJs until the
+// scheduler airs them in their slot.
+package shows
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is where a show is in its life.
+type Status string
+
+const (
+	Scheduled Status = "scheduled"
+	Airing    Status = "airing"
+	Aired     Status = "aired"
+	Missed    Status = "missed" // a live source held the mount for the whole slot
+	Failed    Status = "failed"
+)
+
+// Show is one uploaded recording and the slot it airs in.
+type Show struct {
+	ID          string    `json:"id"`
+	Mount       string    `json:"mount"`
+	Account     string    `json:"account"`
+	Title       string    `json:"title,omitempty"`
+	At          time.Time `json:"at"`
+	Duration    float64   `json:"duration"` // seconds
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Ext         string    `json:"ext"`
+	Status      Status    `json:"status"`
+	Uploaded    time.Time `json:"uploaded"`
+}
+
+// Length is the show's running time.
+func (s *Show) Length() time.Duration {
+	return time.Duration(s.Duration * float64(time.Second))
+}
+
+// End is when the show's slot finishes.
+func (s *Show) End() time.Time {
+	return s.At.Add(s.Length())
+}
+
+// Store keeps shows as audio files with a JSON description next to each.
+type Store struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewStore returns a store rooted at dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create shows directory: %w", err)
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// AudioPath is where the show's audio lives.
+func (st *Store) AudioPath(s *Show) string {
+	return filepath.Join(st.Dir, s.ID+s.Ext)
+}
+
+func (st *Store) metaPath(id string) string {
+	return filepath.Join(st.Dir, id+".json")
+}
+
+// List returns every show, soonest first.
+func (st *Store) List() ([]*Show, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(st.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []*Show
+	for _, f := range files {
+		s, err := readShow(f)
+		if err != nil {
+			continue
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out, nil
+}
+
+// Get returns the show with the given ID.
+func (st *Store) Get(id string) (*Show, error) {
+	if !validID(id) {
+		return nil, os.ErrNotExist
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return readShow(st.metaPath(id))
+}
+
+// Save writes the show's description atomically.
+func (st *Store) Save(s *Show) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	path := st.metaPath(s.ID)
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the show and its audio.
+func (st *Store) Remove(s *Show) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := os.Remove(st.metaPath(s.ID)); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(st.Dir, s.ID+s.Ext))
+	return nil
+}
+
+func readShow(path string) (*Show, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Show
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func validID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\`)
+}
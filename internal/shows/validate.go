@@ -0,0 +1,68 @@
+package shows
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"nickcast/internal/mp3"
+)
+
+// Sniff identifies an upload's format from its first bytes, returning the
+// file extension it should be stored under.
+func Sniff(head []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return ".ogg", true
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return ".flac", true
+	case bytes.HasPrefix(head, []byte("ID3")):
+		// Tagged files are nearly always MP3; Scan checks the frames later.
+		return ".mp3", true
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		// ADTS sync word with layer 00 is AAC, not MPEG audio.
+		return ".aac", true
+	case mp3.Sync(head) == 0:
+		return ".mp3", true
+	}
+	return "", false
+}
+
+var ffmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d\d):(\d\d(?:\.\d+)?)`)
+
+// MeasureDuration returns the length of the audio at path. MP3 is measured
+// natively by counting frames; anything else needs ffmpeg.
+func MeasureDuration(ctx context.Context, ffmpeg, path, ext string) (time.Duration, error) {
+	if ext == ".mp3" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		d, _, err := mp3.Scan(f)
+		return d, err
+	}
+	if ffmpeg == "" {
+		return 0, fmt.Errorf("only MP3 uploads can be measured without ffmpeg")
+	}
+
+	// ffmpeg exits non-zero when given no output, but prints the input's
+	// duration first, which is all we're after.
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-i", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Run()
+	m := ffmpegDuration.FindStringSubmatch(stderr.String())
+	if m == nil {
+		return 0, fmt.Errorf("ffmpeg could not read the file")
+	}
+	h, _ := strconv.Atoi(m[1])
+	mins, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.ParseFloat(m[3], 64)
+	return time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute + time.Duration(sec*float64(time.Second)), nil
+}
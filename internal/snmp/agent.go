@@ -0,0 +1,247 @@
+package snmp
+
+import (
+	"crypto/subtle"
+	"net"
+	"sort"
+)
+
+// SNMP versions as they appear on the wire.
+const (
+	versionV1  = 0
+	versionV2c = 1
+)
+
+// PDU types.
+const (
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+)
+
+// Error statuses.
+const (
+	errNoSuchName  = 2
+	errReadOnly    = 4
+	errNotWritable = 17
+)
+
+const (
+	// maxMessage bounds both requests read and responses sent.
+	maxMessage = 8192
+
+	// maxRepetitions caps what a GETBULK may ask for.
+	maxRepetitions = 64
+)
+
+// Var is one object the agent serves.
+type Var struct {
+	OID   OID
+	Value interface{}
+}
+
+// Agent is a read-only SNMP v1 and v2c agent. It answers GET, GETNEXT and
+// GETBULK from whatever Vars returns at the time, and refuses SET.
+// Requests with the wrong community get no answer, as on any agent, and
+// nor do those from addresses Allow refuses, if it is set.
+type Agent struct {
+	Community string
+	Vars      func() []Var
+	Allow     func(addr net.Addr) bool
+}
+
+// Serve answers requests on pc until it is closed.
+func (a *Agent) Serve(pc net.PacketConn) error {
+	buf := make([]byte, maxMessage)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if a.Allow != nil && !a.Allow(addr) {
+			continue
+		}
+		if resp := a.Handle(buf[:n]); resp != nil {
+			pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+// request is a decoded GET, GETNEXT, GETBULK or SET.
+type request struct {
+	version   int64
+	community []byte
+	pdu       byte
+	id        int64
+	a, b      int64 // error status and index, or non-repeaters and max-repetitions
+	oids      []OID
+}
+
+func parseRequest(msg []byte) (*request, error) {
+	body, _, err := expect(msg, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	var req request
+	v, body, err := expect(body, tagInteger)
+	if err != nil {
+		return nil, err
+	}
+	if req.version, err = decodeInt(v); err != nil {
+		return nil, err
+	}
+	if req.community, body, err = expect(body, tagOctetString); err != nil {
+		return nil, err
+	}
+	var pdu []byte
+	if req.pdu, pdu, _, err = readTLV(body); err != nil {
+		return nil, err
+	}
+	for _, n := range []*int64{&req.id, &req.a, &req.b} {
+		if v, pdu, err = expect(pdu, tagInteger); err != nil {
+			return nil, err
+		}
+		if *n, err = decodeInt(v); err != nil {
+			return nil, err
+		}
+	}
+	list, _, err := expect(pdu, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(list) > 0 {
+		var vb []byte
+		if vb, list, err = expect(list, tagSequence); err != nil {
+			return nil, err
+		}
+		if v, _, err = expect(vb, tagOID); err != nil {
+			return nil, err
+		}
+		oid, err := decodeOID(v)
+		if err != nil {
+			return nil, err
+		}
+		req.oids = append(req.oids, oid)
+	}
+	return &req, nil
+}
+
+// Handle answers one request message, returning nil for those that get no
+// answer.
+func (a *Agent) Handle(msg []byte) []byte {
+	req, err := parseRequest(msg)
+	if err != nil || req.version != versionV1 && req.version != versionV2c {
+		return nil
+	}
+	if subtle.ConstantTimeCompare(req.community, []byte(a.Community)) != 1 {
+		return nil
+	}
+
+	vars := a.Vars()
+	sort.Slice(vars, func(i, j int) bool { return vars[i].OID.Compare(vars[j].OID) < 0 })
+	get := func(oid OID) ([]byte, OID, bool) {
+		i := sort.Search(len(vars), func(i int) bool { return vars[i].OID.Compare(oid) >= 0 })
+		if i < len(vars) && vars[i].OID.Compare(oid) == 0 {
+			return varBind(oid, encodeValue(vars[i].Value)), oid, true
+		}
+		return varBind(oid, tlv(tagNoSuchObject, nil)), oid, false
+	}
+	next := func(oid OID) ([]byte, OID, bool) {
+		i := sort.Search(len(vars), func(i int) bool { return vars[i].OID.Compare(oid) > 0 })
+		if i < len(vars) {
+			return varBind(vars[i].OID, encodeValue(vars[i].Value)), vars[i].OID, true
+		}
+		return varBind(oid, tlv(tagEndOfMIBView, nil)), oid, false
+	}
+
+	var binds [][]byte
+	status, index := 0, 0
+	switch req.pdu {
+	case pduGet, pduGetNext:
+		lookup := get
+		if req.pdu == pduGetNext {
+			lookup = next
+		}
+		for i, oid := range req.oids {
+			vb, _, ok := lookup(oid)
+			if !ok && req.version == versionV1 {
+				// v1 has no exceptions in the bindings: the request fails.
+				status, index = errNoSuchName, i+1
+				binds = nil
+				break
+			}
+			binds = append(binds, vb)
+		}
+	case pduGetBulk:
+		if req.version == versionV1 {
+			return nil
+		}
+		nonRep, reps := clamp(req.a, 0, int64(len(req.oids))), clamp(req.b, 0, maxRepetitions)
+		for _, oid := range req.oids[:nonRep] {
+			vb, _, _ := next(oid)
+			binds = append(binds, vb)
+		}
+		cursors := append([]OID(nil), req.oids[nonRep:]...)
+		more := len(cursors) > 0
+		for r := int64(0); r < reps && more; r++ {
+			more = false
+			for i, oid := range cursors {
+				vb, at, ok := next(oid)
+				binds = append(binds, vb)
+				cursors[i] = at
+				more = more || ok
+			}
+		}
+	case pduSet:
+		status, index = errNotWritable, 1
+		if req.version == versionV1 {
+			status = errReadOnly
+		}
+	default:
+		return nil
+	}
+	if status != 0 {
+		// An error response echoes the request's bindings.
+		binds = nil
+		for _, oid := range req.oids {
+			binds = append(binds, varBind(oid, tlv(tagNull, nil)))
+		}
+	}
+
+	resp := encode(req, status, index, binds)
+	for len(resp) > maxMessage && len(binds) > 1 && req.pdu == pduGetBulk {
+		// Too big for one datagram: GETBULK answers may be cut short.
+		binds = binds[:len(binds)/2]
+		resp = encode(req, status, index, binds)
+	}
+	return resp
+}
+
+func encode(req *request, status, index int, binds [][]byte) []byte {
+	var list []byte
+	for _, vb := range binds {
+		list = append(list, vb...)
+	}
+	pdu := append(encodeInt(tagInteger, req.id), encodeInt(tagInteger, int64(status))...)
+	pdu = append(pdu, encodeInt(tagInteger, int64(index))...)
+	pdu = append(pdu, tlv(tagSequence, list)...)
+	msg := append(encodeInt(tagInteger, req.version), tlv(tagOctetString, req.community)...)
+	msg = append(msg, tlv(pduResponse, pdu)...)
+	return tlv(tagSequence, msg)
+}
+
+func varBind(oid OID, value []byte) []byte {
+	return tlv(tagSequence, append(encodeOID(oid), value...))
+}
+
+func clamp(n, lo, hi int64) int64 {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
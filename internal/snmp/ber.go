@@ -0,0 +1,247 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMP.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+
+	tagCounter32 = 0x41
+	tagGauge32   = 0x42
+	tagTimeTicks = 0x43
+	tagCounter64 = 0x46
+
+	tagNoSuchObject = 0x80
+	tagEndOfMIBView = 0x82
+)
+
+var errMalformed = errors.New("snmp: malformed message")
+
+// OID is an object identifier, one arc per element.
+type OID []uint32
+
+// ParseOID parses a dotted OID such as "1.3.6.1.2.1.1.1.0"; a leading dot
+// is allowed.
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(s, ".")
+	var o OID
+	for _, arc := range strings.Split(s, ".") {
+		n, err := strconv.ParseUint(arc, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("snmp: bad OID %q", s)
+		}
+		o = append(o, uint32(n))
+	}
+	if len(o) < 2 || o[0] > 2 || o[0] < 2 && o[1] >= 40 {
+		return nil, fmt.Errorf("snmp: bad OID %q", s)
+	}
+	return o, nil
+}
+
+func (o OID) String() string {
+	parts := make([]string, len(o))
+	for i, arc := range o {
+		parts[i] = strconv.FormatUint(uint64(arc), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Append returns o with arcs added, leaving o itself alone.
+func (o OID) Append(arcs ...uint32) OID {
+	return append(append(OID(nil), o...), arcs...)
+}
+
+// Compare orders OIDs the way GETNEXT walks them, returning -1, 0 or 1.
+func (o OID) Compare(p OID) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		switch {
+		case o[i] < p[i]:
+			return -1
+		case o[i] > p[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(p):
+		return -1
+	case len(o) > len(p):
+		return 1
+	}
+	return 0
+}
+
+// SNMP's application types. Var values are these, int (INTEGER), string
+// (OCTET STRING) or OID.
+type (
+	Counter32 uint32
+	Gauge32   uint32
+	TimeTicks uint32 // hundredths of a second
+	Counter64 uint64
+)
+
+// readTLV splits the first BER element off b.
+func readTLV(b []byte) (tag byte, val, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag, n := b[0], int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || len(b) < n {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// expect reads an element that must have the given tag.
+func expect(b []byte, tag byte) (val, rest []byte, err error) {
+	t, val, rest, err := readTLV(b)
+	if err == nil && t != tag {
+		err = errMalformed
+	}
+	return val, rest, err
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errMalformed
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errMalformed
+	}
+	var o OID
+	var arc uint64
+	for i, c := range b {
+		arc = arc<<7 | uint64(c&0x7f)
+		if arc > 1<<33 {
+			return nil, errMalformed
+		}
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errMalformed
+			}
+			continue
+		}
+		if o == nil {
+			// The first two arcs share one number.
+			if arc < 80 {
+				o = OID{uint32(arc / 40), uint32(arc % 40)}
+			} else if arc-80 < 1<<32 {
+				o = OID{2, uint32(arc - 80)}
+			} else {
+				return nil, errMalformed
+			}
+		} else if arc < 1<<32 {
+			o = append(o, uint32(arc))
+		} else {
+			return nil, errMalformed
+		}
+		arc = 0
+	}
+	return o, nil
+}
+
+// tlv encodes one BER element.
+func tlv(tag byte, val []byte) []byte {
+	n := len(val)
+	var b []byte
+	switch {
+	case n < 0x80:
+		b = append(make([]byte, 0, 2+n), tag, byte(n))
+	case n < 0x100:
+		b = append(make([]byte, 0, 3+n), tag, 0x81, byte(n))
+	default:
+		b = append(make([]byte, 0, 4+n), tag, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, val...)
+}
+
+func encodeInt(tag byte, n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		if n >= -0x80 && n < 0x80 {
+			break
+		}
+		n >>= 8
+	}
+	return tlv(tag, b)
+}
+
+func encodeUint(tag byte, n uint64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return tlv(tag, b)
+}
+
+func encodeOID(o OID) []byte {
+	if len(o) < 2 {
+		return tlv(tagOID, []byte{0})
+	}
+	var b []byte
+	for _, arc := range append(OID{o[0]*40 + o[1]}, o[2:]...) {
+		part := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			part = append([]byte{byte(arc&0x7f) | 0x80}, part...)
+		}
+		b = append(b, part...)
+	}
+	return tlv(tagOID, b)
+}
+
+// encodeValue encodes one of the types a Var may hold.
+func encodeValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		return encodeInt(tagInteger, int64(v))
+	case string:
+		return tlv(tagOctetString, []byte(v))
+	case OID:
+		return encodeOID(v)
+	case Counter32:
+		return encodeUint(tagCounter32, uint64(v))
+	case Gauge32:
+		return encodeUint(tagGauge32, uint64(v))
+	case TimeTicks:
+		return encodeUint(tagTimeTicks, uint64(v))
+	case Counter64:
+		return encodeUint(tagCounter64, uint64(v))
+	}
+	return tlv(tagNull, nil)
+}
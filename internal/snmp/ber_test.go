@@ -0,0 +1,211 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unhex decodes hex written with spaces between bytes.
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestOIDEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		oid, ber string
+	}{
+		{"1.3.6.1.2.1.1.1.0", "06 08 2b 06 01 02 01 01 01 00"}, // sysDescr.0
+		{"1.3.6.1.4.1.311", "06 07 2b 06 01 04 01 82 37"},
+		{"2.999.3", "06 03 88 37 03"}, // X.690, 8.19.5
+		{"1.3.6.1.4.1.2147483648", "06 0a 2b 06 01 04 01 88 80 80 80 00"},
+		{"1.3.6.1.4.1.4294967295", "06 0a 2b 06 01 04 01 8f ff ff ff 7f"},
+	} {
+		o, err := ParseOID(tt.oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := encodeOID(o); !bytes.Equal(got, unhex(tt.ber)) {
+			t.Errorf("%s: encoded as % x, want %s", tt.oid, got, tt.ber)
+		}
+		val, rest, err := expect(unhex(tt.ber), tagOID)
+		if err != nil || len(rest) > 0 {
+			t.Fatalf("%s: %v", tt.ber, err)
+		}
+		if got, err := decodeOID(val); err != nil || got.String() != tt.oid {
+			t.Errorf("%s: decoded as %s, %v; want %s", tt.ber, got, err, tt.oid)
+		}
+	}
+}
+
+func TestDecodeOIDMalformed(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"2b 86",             // ends inside an arc
+		"2b 90 80 80 80 00", // arc past 32 bits
+		"90 80 80 81 00",    // first two arcs past 32 bits
+	} {
+		if o, err := decodeOID(unhex(in)); err != errMalformed {
+			t.Errorf("%q: got %v, %v", in, o, err)
+		}
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want OID
+	}{
+		{"1.3.6.1", OID{1, 3, 6, 1}},
+		{".1.3.6.1", OID{1, 3, 6, 1}},
+		{"2.100", OID{2, 100}},
+		{"1.40", nil},
+		{"3.1", nil},
+		{"1", nil},
+		{"1.3.x", nil},
+		{"1.3.4294967296", nil},
+	} {
+		got, err := ParseOID(tt.in)
+		if (err == nil) != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestOIDCompare(t *testing.T) {
+	for _, tt := range []struct {
+		a, b OID
+		want int
+	}{
+		{OID{1, 3, 6}, OID{1, 3, 6}, 0},
+		{OID{1, 3, 6}, OID{1, 3, 6, 1}, -1},
+		{OID{1, 3, 7}, OID{1, 3, 6, 1}, 1},
+		{OID{1, 3, 6, 2}, OID{1, 3, 6, 10}, -1},
+	} {
+		if got := tt.a.Compare(tt.b); got != tt.want {
+			t.Errorf("%s vs %s: got %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIntEncoding(t *testing.T) {
+	// X.690, 8.3: the fewest octets, in two's complement.
+	for _, tt := range []struct {
+		n   int64
+		ber string
+	}{
+		{0, "02 01 00"},
+		{127, "02 01 7f"},
+		{128, "02 02 00 80"},
+		{256, "02 02 01 00"},
+		{-1, "02 01 ff"},
+		{-128, "02 01 80"},
+		{-129, "02 02 ff 7f"},
+		{1<<63 - 1, "02 08 7f ff ff ff ff ff ff ff"},
+		{-1 << 63, "02 08 80 00 00 00 00 00 00 00"},
+	} {
+		if got := encodeInt(tagInteger, tt.n); !bytes.Equal(got, unhex(tt.ber)) {
+			t.Errorf("%d: encoded as % x, want %s", tt.n, got, tt.ber)
+		}
+		if got, err := decodeInt(unhex(tt.ber)[2:]); err != nil || got != tt.n {
+			t.Errorf("%s: decoded as %d, %v; want %d", tt.ber, got, err, tt.n)
+		}
+	}
+	for _, in := range []string{"", "01 02 03 04 05 06 07 08 09"} {
+		if _, err := decodeInt(unhex(in)); err != errMalformed {
+			t.Errorf("%q: got error %v", in, err)
+		}
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	for _, tt := range []struct {
+		v   interface{}
+		ber string
+	}{
+		{42, "02 01 2a"},
+		{"nickcast", "04 08 6e 69 63 6b 63 61 73 74"},
+		{OID{1, 3, 6, 1}, "06 03 2b 06 01"},
+		{Counter32(0), "41 01 00"},
+		{Counter32(0xffffffff), "41 05 00 ff ff ff ff"},
+		{Gauge32(128), "42 02 00 80"},
+		{TimeTicks(100), "43 01 64"},
+		{Counter64(1 << 63), "46 09 00 80 00 00 00 00 00 00 00"},
+		{nil, "05 00"},
+	} {
+		if got := encodeValue(tt.v); !bytes.Equal(got, unhex(tt.ber)) {
+			t.Errorf("%T %v: encoded as % x, want %s", tt.v, tt.v, got, tt.ber)
+		}
+	}
+}
+
+func TestLengths(t *testing.T) {
+	for _, tt := range []struct {
+		n      int
+		header string
+	}{
+		{0, "04 00"},
+		{127, "04 7f"},
+		{128, "04 81 80"},
+		{255, "04 81 ff"},
+		{256, "04 82 01 00"},
+		{1500, "04 82 05 dc"},
+	} {
+		val := bytes.Repeat([]byte{'x'}, tt.n)
+		b := tlv(tagOctetString, val)
+		if !bytes.HasPrefix(b, unhex(tt.header)) || len(b) != len(unhex(tt.header))+tt.n {
+			t.Errorf("%d bytes: header % x, want %s", tt.n, b[:len(b)-tt.n], tt.header)
+		}
+		tag, got, rest, err := readTLV(append(b, 0x05, 0x00))
+		if err != nil || tag != tagOctetString || !bytes.Equal(got, val) || !bytes.Equal(rest, []byte{0x05, 0x00}) {
+			t.Errorf("%d bytes: read back tag %#x, %d bytes, rest % x, %v", tt.n, tag, len(got), rest, err)
+		}
+	}
+	// Long forms that tlv doesn't write but others may.
+	if _, val, _, err := readTLV(unhex("04 84 00 00 00 01 41")); err != nil || string(val) != "A" {
+		t.Errorf("four length octets: got %q, %v", val, err)
+	}
+	for _, in := range []string{
+		"04",
+		"04 80 41 00 00",          // indefinite length
+		"04 85 00 00 00 00 01 41", // five length octets
+		"04 82 01",                // length cut short
+		"04 05 41",                // value cut short
+	} {
+		if _, _, _, err := readTLV(unhex(in)); err != errMalformed {
+			t.Errorf("%q: got error %v", in, err)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	// snmpget -v2c -c public host sysDescr.0, with request ID 1.
+	msg := unhex(`30 26 02 01 01 04 06 70 75 62 6c 69 63
+		a0 19 02 01 01 02 01 00 02 01 00
+		30 0e 30 0c 06 08 2b 06 01 02 01 01 01 00 05 00`)
+	req, err := parseRequest(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &request{
+		version:   versionV2c,
+		community: []byte("public"),
+		pdu:       0xa0,
+		id:        1,
+		oids:      []OID{{1, 3, 6, 1, 2, 1, 1, 1, 0}},
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("got  %+v\nwant %+v", req, want)
+	}
+	for i := 1; i < len(msg); i++ {
+		if _, err := parseRequest(msg[:i]); err == nil {
+			t.Errorf("parsed when cut to %d bytes", i)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBuffered is how many packets a connection holds, out of order or
+	// not yet read, and the flow window callers are given: about 11 MB of
+	// full packets, minutes of audio at any bitrate.
+	maxBuffered = 8192
+
+	// tickInterval is how often connections send their ACK, chase losses
+	// and check on the caller.
+	tickInterval = 10 * time.Millisecond
+
+	keepaliveInterval = time.Second
+
+	// peerTimeout is how long a caller may go without sending anything,
+	// keepalives included, before its connection is considered broken.
+	peerTimeout = 5 * time.Second
+
+	// minNAKInterval is the least time between reports of the same losses.
+	minNAKInterval = 20 * time.Millisecond
+
+	// maxNAKWords keeps a loss report within one packet.
+	maxNAKWords = (maxPacketSize - 28 - headerSize) / 4
+)
+
+// ErrTimeout is returned by Read once the caller has gone silent.
+var ErrTimeout = errors.New("srt: connection timed out")
+
+// Conn is an accepted SRT connection. Read returns the caller's payload in
+// order; packets lost and not recovered within the latency are skipped.
+type Conn struct {
+	l        *Listener
+	id       uint32 // our socket ID
+	peer     uint32 // the caller's
+	addr     net.Addr
+	streamID string
+	latency  time.Duration
+	start    time.Time
+
+	readable  chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	err      error             // why the connection closed, once it has
+	next     uint32            // sequence number of the next packet to deliver
+	highest  uint32            // highest sequence number received
+	buf      map[uint32][]byte // packets received ahead of next
+	ready    [][]byte          // delivered payloads not yet read
+	gapSince time.Time         // when next went missing, if it has
+	lastRecv time.Time
+	lastSent time.Time
+	lastNAK  time.Time
+	acked    uint32 // next, as of the last ACK
+	ackNo    uint32
+	ackSent  map[uint32]time.Time // ACK numbers awaiting ACKACK
+	rtt      time.Duration
+	rttVar   time.Duration
+
+	// Receive rates, measured over the last second, for ACKs.
+	rateStart   time.Time
+	ratePackets int
+	rateBytes   int
+	pktRate     uint32
+	byteRate    uint32
+
+	dropped int64 // packets given up on
+}
+
+func newConn(l *Listener, addr net.Addr, hs handshake, latency time.Duration) *Conn {
+	if peer := time.Duration(hs.hsreq[2]&0xFFFF) * time.Millisecond; peer > latency {
+		latency = peer
+	}
+	now := time.Now()
+	return &Conn{
+		l:         l,
+		id:        randomID(),
+		peer:      hs.socket,
+		addr:      addr,
+		streamID:  hs.streamID,
+		latency:   latency,
+		start:     now,
+		readable:  make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+		next:      hs.isn,
+		highest:   (hs.isn - 1) & seqMask,
+		acked:     hs.isn,
+		buf:       make(map[uint32][]byte),
+		ackSent:   make(map[uint32]time.Time),
+		lastRecv:  now,
+		lastSent:  now,
+		rateStart: now,
+		rtt:       100 * time.Millisecond,
+		rttVar:    50 * time.Millisecond,
+	}
+}
+
+// Read reads the caller's payload, as it arrived in order.
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.ready) > 0 {
+			n := copy(p, c.ready[0])
+			if n < len(c.ready[0]) {
+				c.ready[0] = c.ready[0][n:]
+			} else {
+				c.ready[0] = nil
+				c.ready = c.ready[1:]
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		err := c.err
+		c.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-c.readable:
+		case <-c.closed:
+		}
+	}
+}
+
+// Close closes the connection, telling the caller.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked(net.ErrClosed, true)
+	return nil
+}
+
+// RemoteAddr returns the caller's address.
+func (c *Conn) RemoteAddr() net.Addr { return c.addr }
+
+// StreamID returns what the caller sent as its stream ID.
+func (c *Conn) StreamID() string { return c.streamID }
+
+// Latency returns how long lost packets are waited for.
+func (c *Conn) Latency() time.Duration { return c.latency }
+
+// Dropped returns how many packets were lost for good.
+func (c *Conn) Dropped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+func (c *Conn) closeLocked(err error, notify bool) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		if notify {
+			c.sendLocked(ctrlShutdown, 0, make([]byte, 4))
+		}
+		c.l.remove(c)
+	})
+}
+
+// sendLocked sends a control packet to the caller.
+func (c *Conn) sendLocked(typ uint16, info uint32, cif []byte) {
+	now := time.Now()
+	c.l.send(controlPacket(typ, info, uint32(now.Sub(c.start).Microseconds()), c.peer, cif), c.addr)
+	c.lastSent = now
+}
+
+func (c *Conn) handle(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.lastRecv = time.Now()
+	be := binary.BigEndian
+	if p[0]&0x80 == 0 {
+		c.data(be.Uint32(p[0:])&seqMask, p[headerSize:])
+		return
+	}
+	switch be.Uint16(p[0:]) & 0x7FFF {
+	case ctrlACKACK:
+		if sent, ok := c.ackSent[be.Uint32(p[4:])]; ok {
+			delete(c.ackSent, be.Uint32(p[4:]))
+			c.sampleRTT(c.lastRecv.Sub(sent))
+		}
+	case ctrlDropReq:
+		// The caller has given up on resending these.
+		if len(p) >= headerSize+8 {
+			first, last := be.Uint32(p[headerSize:])&seqMask, be.Uint32(p[headerSize+4:])&seqMask
+			if !seqLess(c.next, first) && !seqLess(last, c.next) {
+				c.skipTo(seqNext(last))
+			}
+		}
+	case ctrlShutdown:
+		c.closeLocked(io.EOF, false)
+	}
+}
+
+// data takes in one data packet.
+func (c *Conn) data(seq uint32, payload []byte) {
+	c.ratePackets++
+	c.rateBytes += len(payload)
+	d := seqDiff(seq, c.next)
+	if d < 0 || d >= maxBuffered || len(c.ready)+len(c.buf) >= maxBuffered {
+		// Already delivered, absurdly far ahead, or no room: in the last
+		// case the caller resends it once the reader catches up.
+		return
+	}
+	if _, dup := c.buf[seq]; dup {
+		return
+	}
+	c.buf[seq] = append([]byte(nil), payload...)
+	if seqLess(c.highest, seq) {
+		if first := seqNext(c.highest); first != seq {
+			// Report the newly missing packets straight away.
+			c.sendLocked(ctrlNAK, 0, lossList([][2]uint32{{first, (seq - 1) & seqMask}}))
+		}
+		c.highest = seq
+	}
+	c.deliver()
+}
+
+// deliver moves packets from next onwards to ready for as long as there is
+// no gap.
+func (c *Conn) deliver() {
+	delivered := false
+	for {
+		p, ok := c.buf[c.next]
+		if !ok {
+			break
+		}
+		delete(c.buf, c.next)
+		c.ready = append(c.ready, p)
+		c.next = seqNext(c.next)
+		delivered = true
+	}
+	switch {
+	case len(c.buf) == 0:
+		c.gapSince = time.Time{}
+	case delivered || c.gapSince.IsZero():
+		c.gapSince = time.Now()
+	}
+	if delivered {
+		select {
+		case c.readable <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// skipTo gives up on every packet before seq.
+func (c *Conn) skipTo(seq uint32) {
+	for seqLess(c.next, seq) {
+		if _, ok := c.buf[c.next]; ok {
+			break
+		}
+		c.dropped++
+		c.next = seqNext(c.next)
+	}
+	if seqLess(c.highest, c.next) {
+		c.highest = (c.next - 1) & seqMask
+	}
+	c.gapSince = time.Time{}
+	c.deliver()
+}
+
+func (c *Conn) sampleRTT(sample time.Duration) {
+	diff := c.rtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	c.rttVar = (3*c.rttVar + diff) / 4
+	c.rtt = (7*c.rtt + sample) / 8
+}
+
+// run sends ACKs and NAKs, drops packets that are too late and watches for
+// the caller going away, until the connection closes.
+func (c *Conn) run() {
+	t := time.NewTicker(tickInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-t.C:
+		}
+		c.tick(time.Now())
+	}
+}
+
+func (c *Conn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if now.Sub(c.lastRecv) > peerTimeout {
+		c.closeLocked(ErrTimeout, true)
+		return
+	}
+	if now.Sub(c.rateStart) >= time.Second {
+		secs := now.Sub(c.rateStart).Seconds()
+		c.pktRate = uint32(float64(c.ratePackets) / secs)
+		c.byteRate = uint32(float64(c.rateBytes) / secs)
+		c.rateStart, c.ratePackets, c.rateBytes = now, 0, 0
+	}
+
+	// Too late to be worth waiting for: skip to the first packet there is.
+	if !c.gapSince.IsZero() && now.Sub(c.gapSince) > c.latency {
+		first := c.highest
+		for seq := range c.buf {
+			if seqLess(seq, first) {
+				first = seq
+			}
+		}
+		c.skipTo(first)
+	}
+
+	switch {
+	case c.next != c.acked:
+		c.ack(now)
+	case now.Sub(c.lastSent) >= keepaliveInterval:
+		c.sendLocked(ctrlKeepalive, 0, make([]byte, 4))
+	}
+
+	nakEvery := (c.rtt + 4*c.rttVar) / 2
+	if nakEvery < minNAKInterval {
+		nakEvery = minNAKInterval
+	}
+	if len(c.buf) > 0 && now.Sub(c.lastNAK) >= nakEvery {
+		c.lastNAK = now
+		if losses := c.losses(); len(losses) > 0 {
+			c.sendLocked(ctrlNAK, 0, lossList(losses))
+		}
+	}
+}
+
+// ack sends a full ACK of everything before next.
+func (c *Conn) ack(now time.Time) {
+	c.ackNo++
+	if len(c.ackSent) > 64 {
+		c.ackSent = make(map[uint32]time.Time)
+	}
+	c.ackSent[c.ackNo] = now
+	c.acked = c.next
+	room := maxBuffered - len(c.ready) - len(c.buf)
+	if room < 2 {
+		room = 2
+	}
+	cif := make([]byte, 28)
+	be := binary.BigEndian
+	be.PutUint32(cif[0:], c.next)
+	be.PutUint32(cif[4:], uint32(c.rtt.Microseconds()))
+	be.PutUint32(cif[8:], uint32(c.rttVar.Microseconds()))
+	be.PutUint32(cif[12:], uint32(room))
+	be.PutUint32(cif[16:], c.pktRate)
+	be.PutUint32(cif[20:], c.pktRate)
+	be.PutUint32(cif[24:], c.byteRate)
+	c.sendLocked(ctrlACK, c.ackNo, cif)
+}
+
+// losses returns the ranges of packets still missing between next and
+// highest.
+func (c *Conn) losses() [][2]uint32 {
+	var out [][2]uint32
+	words := 0
+	for seq := c.next; seqLess(seq, c.highest) && words < maxNAKWords-1; seq = seqNext(seq) {
+		if _, ok := c.buf[seq]; ok {
+			continue
+		}
+		if n := len(out); n > 0 && seqNext(out[n-1][1]) == seq {
+			if out[n-1][0] == out[n-1][1] {
+				words++
+			}
+			out[n-1][1] = seq
+			continue
+		}
+		out = append(out, [2]uint32{seq, seq})
+		words++
+	}
+	return out
+}
+
+// lossList encodes loss ranges for a NAK: a lone packet as its sequence
+// number, a run as its first (with the top bit set) and last.
+func lossList(ranges [][2]uint32) []byte {
+	var b []byte
+	for _, r := range ranges {
+		if r[0] == r[1] {
+			b = binary.BigEndian.AppendUint32(b, r[0])
+			continue
+		}
+		b = binary.BigEndian.AppendUint32(b, r[0]|1<<31)
+		b = binary.BigEndian.AppendUint32(b, r[1])
+	}
+	return b
+}
@@ -0,0 +1,307 @@
+package srt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPending bounds the handshakes waiting for Accept or Reject, and
+	// answered ones kept for callers that repeat their conclusion.
+	maxPending = 128
+
+	// answerKept is how long an answered handshake is remembered.
+	answerKept = 10 * time.Second
+
+	// cookieEpoch is how long a SYN cookie stays valid, give or take one
+	// epoch.
+	cookieEpoch = time.Minute
+)
+
+// Listener accepts SRT callers on a UDP socket, which all its connections
+// share.
+type Listener struct {
+	pc       net.PacketConn
+	latency  time.Duration
+	id       uint32
+	secret   []byte // for SYN cookies
+	requests chan *Request
+	done     chan struct{}
+	doneOnce sync.Once
+
+	mu      sync.Mutex
+	err     error
+	conns   map[uint32]*Conn    // by our socket ID
+	pending map[string]*Request // by caller address and socket ID
+}
+
+// Listen listens for SRT callers on the UDP address addr. latency is the
+// least time lost packets are waited for; a caller asking for more gets it.
+func Listen(addr string, latency time.Duration) (*Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Like libsrt, ask for room for a whole flow window of packets, so a
+	// burst isn't dropped by the kernel before it can be read. The system
+	// may give less.
+	if uc, ok := pc.(*net.UDPConn); ok {
+		uc.SetReadBuffer(maxBuffered * maxPacketSize)
+	}
+	l := &Listener{
+		pc:       pc,
+		latency:  latency,
+		id:       randomID(),
+		secret:   make([]byte, 32),
+		requests: make(chan *Request, maxPending),
+		done:     make(chan struct{}),
+		conns:    make(map[uint32]*Conn),
+		pending:  make(map[string]*Request),
+	}
+	rand.Read(l.secret)
+	go l.read()
+	return l, nil
+}
+
+// Addr returns the address the listener is on.
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// Accept waits for the next caller's connection request.
+func (l *Listener) Accept() (*Request, error) {
+	select {
+	case r := <-l.requests:
+		return r, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return nil, l.err
+	}
+}
+
+// Close stops the listener and closes its connections.
+func (l *Listener) Close() error {
+	l.stop(net.ErrClosed)
+	l.mu.Lock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (l *Listener) stop(err error) {
+	l.doneOnce.Do(func() {
+		l.mu.Lock()
+		l.err = err
+		l.mu.Unlock()
+		close(l.done)
+		l.pc.Close()
+	})
+}
+
+// read passes each datagram on to its connection, or handles it as a new
+// caller's handshake.
+func (l *Listener) read() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.stop(err)
+			return
+		}
+		p := buf[:n]
+		if n < headerSize {
+			continue
+		}
+		dest := binary.BigEndian.Uint32(p[12:])
+		if dest == 0 {
+			if p[0]&0x80 != 0 && binary.BigEndian.Uint16(p[0:])&0x7FFF == ctrlHandshake {
+				l.handshake(p, addr)
+			}
+			continue
+		}
+		l.mu.Lock()
+		c := l.conns[dest]
+		l.mu.Unlock()
+		if c != nil && c.addr.String() == addr.String() {
+			c.handle(p)
+		}
+	}
+}
+
+func (l *Listener) send(p []byte, addr net.Addr) {
+	l.pc.WriteTo(p, addr)
+}
+
+// cookie is the SYN cookie for addr in the given epoch. Callers must echo
+// it from the induction response in their conclusion, which shows they
+// really are at the address they send from before any state is kept for
+// them.
+func (l *Listener) cookie(addr net.Addr, epoch int64) uint32 {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(addr.String() + "/" + strconv.FormatInt(epoch, 10)))
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+func (l *Listener) validCookie(addr net.Addr, cookie uint32) bool {
+	epoch := time.Now().Unix() / int64(cookieEpoch.Seconds())
+	return cookie == l.cookie(addr, epoch) || cookie == l.cookie(addr, epoch-1)
+}
+
+func (l *Listener) handshake(p []byte, addr net.Addr) {
+	hs, err := parseHandshake(p[headerSize:])
+	if err != nil {
+		return
+	}
+	switch hs.typ {
+	case hsInduction:
+		resp := hs
+		resp.version = 5
+		resp.encryption = 0
+		resp.extension = srtMagic
+		resp.socket = l.id
+		resp.cookie = l.cookie(addr, time.Now().Unix()/int64(cookieEpoch.Seconds()))
+		l.send(resp.marshal(hs.socket, nil), addr)
+	case hsConclusion:
+		if !l.validCookie(addr, hs.cookie) {
+			return
+		}
+		l.conclusion(hs, addr)
+	}
+}
+
+// conclusion handles a caller's conclusion request, which it repeats until
+// answered: the first becomes a Request, and repeats get the same answer.
+func (l *Listener) conclusion(hs handshake, addr net.Addr) {
+	key := addr.String() + "/" + strconv.FormatUint(uint64(hs.socket), 10)
+	now := time.Now()
+	l.mu.Lock()
+	if r := l.pending[key]; r != nil {
+		reply := r.reply
+		l.mu.Unlock()
+		if reply != nil {
+			l.send(reply, addr)
+		}
+		return
+	}
+	for k, r := range l.pending {
+		if r.reply != nil && now.Sub(r.answered) > answerKept {
+			delete(l.pending, k)
+		}
+	}
+	if len(l.pending) >= maxPending {
+		l.mu.Unlock()
+		l.send(rejection(hs, rejectBacklog), addr)
+		return
+	}
+	r := &Request{StreamID: hs.streamID, RemoteAddr: addr, l: l, hs: hs}
+	l.pending[key] = r
+	l.mu.Unlock()
+
+	switch {
+	case hs.version < 5 || len(hs.hsreq) < 3:
+		r.Reject(rejectVersion)
+	case hs.encryption != 0 || hs.kmreq:
+		r.Reject(rejectUnsecure)
+	default:
+		select {
+		case l.requests <- r:
+		default:
+			r.Reject(rejectBacklog)
+		}
+	}
+}
+
+// rejection is the handshake turning the caller of hs away.
+func rejection(hs handshake, reason int) []byte {
+	resp := hs
+	resp.typ = uint32(hsRejectBase + reason)
+	return resp.marshal(hs.socket, nil)
+}
+
+// Request is a caller asking to connect. It must be answered with Accept
+// or Reject within a few seconds, before the caller gives up.
+type Request struct {
+	StreamID   string // what the caller sent in its stream ID, if anything
+	RemoteAddr net.Addr
+
+	l        *Listener
+	hs       handshake
+	reply    []byte    // under l.mu; nil until answered
+	answered time.Time // under l.mu
+}
+
+// Reject turns the caller away with one of the Reject reasons.
+func (r *Request) Reject(reason int) {
+	r.answer(rejection(r.hs, reason))
+}
+
+// Accept lets the caller in and returns its connection.
+func (r *Request) Accept() *Conn {
+	l, hs := r.l, r.hs
+	c := newConn(l, r.RemoteAddr, hs, l.latency)
+	l.mu.Lock()
+	for l.conns[c.id] != nil {
+		c.id = randomID()
+	}
+	l.conns[c.id] = c
+	l.mu.Unlock()
+
+	// Answer the caller's HSREQ: we are the receiver, with at least the
+	// latency it asked of us.
+	resp := hs
+	resp.encryption = 0
+	resp.extension = extFlagHSReq
+	if resp.mtu > maxPacketSize {
+		resp.mtu = maxPacketSize
+	}
+	if resp.window > maxBuffered {
+		resp.window = maxBuffered
+	}
+	resp.socket = c.id
+	flags := uint32(flagTSBPDRcv|flagTLPktDrop|flagPeriodicNAK|flagRexmit) | hs.hsreq[1]&(flagCrypt|flagStream)
+	delays := uint32(c.latency.Milliseconds())<<16 | hs.hsreq[2]>>16
+	r.answer(resp.marshal(hs.socket, extensionBlock(extHSRsp, version, flags, delays)))
+	go c.run()
+	return c
+}
+
+func (r *Request) answer(reply []byte) {
+	r.l.mu.Lock()
+	r.reply = reply
+	r.answered = time.Now()
+	r.l.mu.Unlock()
+	r.l.send(reply, r.RemoteAddr)
+}
+
+func (l *Listener) remove(c *Conn) {
+	l.mu.Lock()
+	if l.conns[c.id] == c {
+		delete(l.conns, c.id)
+	}
+	l.mu.Unlock()
+}
+
+// randomID returns a socket ID, which is never 0: that is the destination
+// of handshakes from callers that don't know theirs yet.
+func randomID() uint32 {
+	var b [4]byte
+	for {
+		rand.Read(b[:])
+		if id := binary.BigEndian.Uint32(b[:]) & seqMask; id != 0 {
+			return id
+		}
+	}
+}
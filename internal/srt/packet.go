@@ -0,0 +1,210 @@
+// Package srt receives streams over SRT (Secure Reliable Transport), the
+// UDP protocol remote broadcasters use to get audio across lossy links. It
+// implements only what a receiving listener in live mode needs: the
+// version 5 handshake, acknowledgements, NAK-driven retransmission, and
+// dropping packets that are still missing once the latency has passed.
+// Encryption is not supported; callers that set a passphrase are turned
+// away.
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	headerSize    = 16
+	handshakeSize = 48
+
+	// maxPacketSize is the largest datagram read: a full 1500-byte MTU.
+	maxPacketSize = 1500
+)
+
+// Control packet types.
+const (
+	ctrlHandshake = 0x0000
+	ctrlKeepalive = 0x0001
+	ctrlACK       = 0x0002
+	ctrlNAK       = 0x0003
+	ctrlShutdown  = 0x0005
+	ctrlACKACK    = 0x0006
+	ctrlDropReq   = 0x0007
+)
+
+// Handshake types. A rejection's type is hsRejectBase plus its reason.
+const (
+	hsInduction  uint32 = 0x00000001
+	hsConclusion uint32 = 0xFFFFFFFF
+	hsRejectBase        = 1000
+)
+
+// srtMagic in an induction response's extension field tells the caller the
+// listener speaks handshake version 5.
+const srtMagic = 0x4A17
+
+// Handshake extension flags (in the extension field) and block types.
+const (
+	extFlagHSReq = 0x1
+	extFlagKMReq = 0x2
+
+	extHSReq = 1
+	extHSRsp = 2
+	extKMReq = 3
+	extSID   = 5
+)
+
+// SRT option flags, exchanged in HSREQ and HSRSP.
+const (
+	flagTSBPDSnd    = 0x01
+	flagTSBPDRcv    = 0x02
+	flagCrypt       = 0x04
+	flagTLPktDrop   = 0x08
+	flagPeriodicNAK = 0x10
+	flagRexmit      = 0x20
+	flagStream      = 0x40
+)
+
+// version is the SRT version announced to callers, 1.5.0.
+const version = 0x010500
+
+// Reasons for turning a caller away, from SRT's access control codes, which
+// SRT tools show by name.
+const (
+	RejectBadRequest   = 1400
+	RejectUnauthorized = 1401
+	RejectForbidden    = 1403
+	RejectNotFound     = 1404
+	RejectBadMode      = 1405
+	RejectConflict     = 1409
+
+	rejectVersion  = 8  // caller can't do handshake version 5
+	rejectUnsecure = 11 // caller wants encryption
+	rejectBacklog  = 5  // too many handshakes waiting for an answer
+)
+
+var errShortPacket = errors.New("srt: packet too short")
+
+// handshake is the control information of a handshake packet, with what
+// the extensions nickcast cares about say.
+type handshake struct {
+	version    uint32
+	encryption uint16
+	extension  uint16
+	isn        uint32 // initial packet sequence number
+	mtu        uint32
+	window     uint32 // flow window, in packets
+	typ        uint32
+	socket     uint32
+	cookie     uint32
+	peerIP     [16]byte
+
+	hsreq    []uint32 // SRT version, flags and TSBPD delays; nil if absent
+	kmreq    bool     // the caller sent key material, so wants encryption
+	streamID string
+}
+
+func parseHandshake(cif []byte) (handshake, error) {
+	var hs handshake
+	if len(cif) < handshakeSize {
+		return hs, errShortPacket
+	}
+	be := binary.BigEndian
+	hs.version = be.Uint32(cif[0:])
+	hs.encryption = be.Uint16(cif[4:])
+	hs.extension = be.Uint16(cif[6:])
+	hs.isn = be.Uint32(cif[8:]) & seqMask
+	hs.mtu = be.Uint32(cif[12:])
+	hs.window = be.Uint32(cif[16:])
+	hs.typ = be.Uint32(cif[20:])
+	hs.socket = be.Uint32(cif[24:])
+	hs.cookie = be.Uint32(cif[28:])
+	copy(hs.peerIP[:], cif[32:48])
+
+	ext := cif[handshakeSize:]
+	for len(ext) >= 4 {
+		typ := be.Uint16(ext[0:])
+		size := int(be.Uint16(ext[2:])) * 4
+		ext = ext[4:]
+		if size > len(ext) {
+			return hs, errShortPacket
+		}
+		block := ext[:size]
+		ext = ext[size:]
+		switch typ {
+		case extHSReq:
+			for i := 0; i+4 <= len(block); i += 4 {
+				hs.hsreq = append(hs.hsreq, be.Uint32(block[i:]))
+			}
+		case extKMReq:
+			hs.kmreq = true
+		case extSID:
+			hs.streamID = decodeStreamID(block)
+		}
+	}
+	return hs, nil
+}
+
+// marshal returns a handshake packet to socket dest, carrying hs and the
+// extension block given, if any.
+func (hs *handshake) marshal(dest uint32, ext []byte) []byte {
+	cif := make([]byte, handshakeSize, handshakeSize+len(ext))
+	be := binary.BigEndian
+	be.PutUint32(cif[0:], hs.version)
+	be.PutUint16(cif[4:], hs.encryption)
+	be.PutUint16(cif[6:], hs.extension)
+	be.PutUint32(cif[8:], hs.isn)
+	be.PutUint32(cif[12:], hs.mtu)
+	be.PutUint32(cif[16:], hs.window)
+	be.PutUint32(cif[20:], hs.typ)
+	be.PutUint32(cif[24:], hs.socket)
+	be.PutUint32(cif[28:], hs.cookie)
+	copy(cif[32:48], hs.peerIP[:])
+	cif = append(cif, ext...)
+	return controlPacket(ctrlHandshake, 0, 0, dest, cif)
+}
+
+// extensionBlock encodes one handshake extension.
+func extensionBlock(typ uint16, words ...uint32) []byte {
+	b := make([]byte, 4+4*len(words))
+	binary.BigEndian.PutUint16(b[0:], typ)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(words)))
+	for i, w := range words {
+		binary.BigEndian.PutUint32(b[4+4*i:], w)
+	}
+	return b
+}
+
+// decodeStreamID undoes the stream ID extension's encoding: the string is
+// zero-padded to whole words and each word's bytes are reversed.
+func decodeStreamID(b []byte) string {
+	s := make([]byte, 0, len(b))
+	for i := 0; i+4 <= len(b); i += 4 {
+		s = append(s, b[i+3], b[i+2], b[i+1], b[i])
+	}
+	for len(s) > 0 && s[len(s)-1] == 0 {
+		s = s[:len(s)-1]
+	}
+	return string(s)
+}
+
+// controlPacket returns a control packet of type typ.
+func controlPacket(typ uint16, info, timestamp, dest uint32, cif []byte) []byte {
+	p := make([]byte, headerSize+len(cif))
+	be := binary.BigEndian
+	be.PutUint16(p[0:], 0x8000|typ)
+	be.PutUint32(p[4:], info)
+	be.PutUint32(p[8:], timestamp)
+	be.PutUint32(p[12:], dest)
+	copy(p[headerSize:], cif)
+	return p
+}
+
+// Packet sequence numbers are 31 bits and wrap around.
+const seqMask = 1<<31 - 1
+
+func seqNext(s uint32) uint32 { return (s + 1) & seqMask }
+
+// seqDiff returns a-b, allowing for wrap-around.
+func seqDiff(a, b uint32) int32 { return int32((a-b)<<1) >> 1 }
+
+func seqLess(a, b uint32) bool { return seqDiff(a, b) < 0 }
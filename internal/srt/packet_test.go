@@ -0,0 +1,127 @@
+package srt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unhex decodes hex written with spaces between fields.
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// conclusion is the control information of a caller's conclusion
+// handshake from 127.0.0.1, with an HSREQ and the stream ID #!::r=live.
+const conclusion = `
+	00000005 0000 0005 fa2b3c4d 000005dc 00002000 ffffffff 1234abcd 0badf00d
+	0100007f 00000000 00000000 00000000
+	0001 0003  00010500 0000003f 00780078
+	0005 0003  3a3a2123 696c3d72 00006576`
+
+func TestParseHandshake(t *testing.T) {
+	want := handshake{
+		version:   5,
+		extension: extFlagHSReq | 0x4,
+		isn:       0x7a2b3c4d,
+		mtu:       1500,
+		window:    8192,
+		typ:       hsConclusion,
+		socket:    0x1234abcd,
+		cookie:    0x0badf00d,
+		peerIP:    [16]byte{1, 0, 0, 127},
+		hsreq:     []uint32{version, 0x3f, 0x00780078},
+		streamID:  "#!::r=live",
+	}
+	hs, err := parseHandshake(unhex(conclusion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hs, want) {
+		t.Errorf("got  %+v\nwant %+v", hs, want)
+	}
+
+	hs, err = parseHandshake(unhex(conclusion + "0003 0001 12345678"))
+	if err != nil || !hs.kmreq {
+		t.Errorf("key material request not seen: %v", err)
+	}
+	if _, err := parseHandshake(unhex(conclusion + "0003 0002 12345678")); err != errShortPacket {
+		t.Errorf("extension past the end: got error %v", err)
+	}
+	if _, err := parseHandshake(unhex(conclusion)[:handshakeSize-1]); err != errShortPacket {
+		t.Errorf("short handshake: got error %v", err)
+	}
+}
+
+func TestMarshalHandshake(t *testing.T) {
+	hs := handshake{
+		version:   5,
+		extension: srtMagic,
+		isn:       0x7a2b3c4d,
+		mtu:       1500,
+		window:    8192,
+		typ:       hsInduction,
+		socket:    0x00c0ffee,
+		cookie:    0x0badf00d,
+	}
+	got := hs.marshal(0x1234abcd, extensionBlock(extHSRsp, version, flagTSBPDRcv|flagTLPktDrop, 0x00780000))
+	want := unhex(`
+		8000 0000 00000000 00000000 1234abcd
+		00000005 0000 4a17 7a2b3c4d 000005dc 00002000 00000001 00c0ffee 0badf00d
+		00000000 00000000 00000000 00000000
+		0002 0003  00010500 0000000a 00780000`)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % x\nwant % x", got, want)
+	}
+	back, err := parseHandshake(got[headerSize:])
+	if err != nil || back.typ != hsInduction || back.cookie != hs.cookie {
+		t.Errorf("doesn't parse back: %+v, %v", back, err)
+	}
+}
+
+func TestDecodeStreamID(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"74736574", "test"},
+		{"74736574 00000031", "test1"},
+		{"3a3a2123 6c2f3d72 2c657669 75703d6d 73696c62 00000068", "#!::r=/live,m=publish"},
+		{"74736574 00", "test"}, // a partial word is dropped
+	} {
+		if got := decodeStreamID(unhex(tt.in)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSeq(t *testing.T) {
+	const max = seqMask
+	for _, tt := range []struct {
+		a, b uint32
+		diff int32
+	}{
+		{5, 3, 2},
+		{3, 5, -2},
+		{0, max, 1}, // wrapped
+		{max, 0, -1},
+		{10, max - 9, 20},
+		{0, 1 << 30, -(1 << 30)},
+	} {
+		if got := seqDiff(tt.a, tt.b); got != tt.diff {
+			t.Errorf("seqDiff(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.diff)
+		}
+		if got := seqLess(tt.a, tt.b); got != (tt.diff < 0) {
+			t.Errorf("seqLess(%d, %d) = %v", tt.a, tt.b, got)
+		}
+	}
+	if got := seqNext(max); got != 0 {
+		t.Errorf("seqNext(%d) = %d, want 0", uint32(max), got)
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File is a Store keeping each map in a directory of its own, and each
+// entry in a file there named after its field. Writes replace the file in
+// one rename, so a crash leaves the old value or the new one, never half
+// of each.
+type File struct {
+	Dir string
+}
+
+// OpenFile opens a File store in dir, creating it if need be.
+func OpenFile(dir string) (*File, error) {
+	if dir == "" {
+		return nil, errors.New("store: no directory given")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &File{Dir: dir}, nil
+}
+
+// fileExt ends entry file names; the field is hex-encoded before it, as
+// it may hold anything.
+const fileExt = ".json"
+
+func (f *File) path(key, field string) string {
+	return filepath.Join(f.Dir, key, hex.EncodeToString([]byte(field))+fileExt)
+}
+
+func (f *File) Entries(key string) (map[string][]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(f.Dir, key)
+	names, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]byte, len(names))
+	for _, e := range names {
+		field, err := hex.DecodeString(strings.TrimSuffix(e.Name(), fileExt))
+		if err != nil || !strings.HasSuffix(e.Name(), fileExt) {
+			continue // a write in progress, or not ours
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted since the listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[string(field)] = data
+	}
+	return entries, nil
+}
+
+func (f *File) Set(key, field string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	dir := filepath.Join(f.Dir, key)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key, field))
+}
+
+func (f *File) Delete(key, field string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(f.path(key, field)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	return nil
+}
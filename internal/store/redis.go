@@ -0,0 +1,277 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTimeout bounds each Redis command, connecting included.
+const redisTimeout = 5 * time.Second
+
+// Redis is a Store in a Redis server, which any number of NickCast servers
+// can share, keeping each map in a hash. It speaks just enough of the
+// protocol for HGETALL, HSET and HDEL over a single connection, redialled
+// after a failure.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// OpenRedis connects to the Redis server at a redis:// URL; see Open.
+func OpenRedis(spec string) (*Redis, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	c := &Redis{addr: u.Host, prefix: "nickcast:"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		// redis://:password@host, or the password alone as the user.
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			c.password = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("store: invalid Redis database %q", db)
+		}
+	}
+	if p, ok := u.Query()["prefix"]; ok {
+		c.prefix = p[0]
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.dial(); err != nil {
+		return nil, fmt.Errorf("store: connecting to Redis at %s: %w", c.addr, err)
+	}
+	return c, nil
+}
+
+func (c *Redis) Entries(key string) (map[string][]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	vs, err := c.doArray("HGETALL", c.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs)%2 != 0 {
+		return nil, errors.New("store: HGETALL reply from Redis is not field, value pairs")
+	}
+	entries := make(map[string][]byte, len(vs)/2)
+	for i := 0; i < len(vs); i += 2 {
+		entries[string(vs[i])] = vs[i+1]
+	}
+	return entries, nil
+}
+
+func (c *Redis) Set(key, field string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	_, err := c.do("HSET", c.prefix+key, field, string(value))
+	return err
+}
+
+func (c *Redis) Delete(key, field string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	_, err := c.do("HDEL", c.prefix+key, field)
+	return err
+}
+
+func (c *Redis) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// dial connects, logs in and selects the database. c.mu must be held.
+func (c *Redis) dial() error {
+	conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.roundTrip("AUTH", c.password); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip("SELECT", strconv.Itoa(c.db)); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Redis) drop() {
+	c.conn.Close()
+	c.conn, c.r = nil, nil
+}
+
+// do runs a command, connecting first if need be. A nil reply with no
+// error is Redis's nil.
+func (c *Redis) do(args ...string) ([]byte, error) {
+	var v []byte
+	err := c.run(args, func() (err error) {
+		v, err = c.readReply()
+		return err
+	})
+	return v, err
+}
+
+// doArray runs a command whose reply is an array of bulk strings.
+func (c *Redis) doArray(args ...string) ([][]byte, error) {
+	var vs [][]byte
+	err := c.run(args, func() (err error) {
+		vs, err = c.readArray()
+		return err
+	})
+	return vs, err
+}
+
+// run sends a command and reads its reply with read, connecting first if
+// need be.
+func (c *Redis) run(args []string, read func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return fmt.Errorf("store: connecting to Redis at %s: %w", c.addr, err)
+		}
+	}
+	err := c.send(args...)
+	if err == nil {
+		err = read()
+	}
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		// The connection is in an unknown state; start afresh next time.
+		c.drop()
+	}
+	return err
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "store: Redis: " + string(e)
+}
+
+// roundTrip sends a command and reads its reply. c.mu must be held.
+func (c *Redis) roundTrip(args ...string) ([]byte, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// send writes a command. c.mu must be held.
+func (c *Redis) send(args ...string) error {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// readLine reads one line of a reply, without its CRLF. c.mu must be held.
+func (c *Redis) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", errors.New("store: empty reply from Redis")
+	}
+	return line, nil
+}
+
+// readArray reads an array reply of bulk strings; Redis's nil array is
+// empty. c.mu must be held.
+func (c *Redis) readArray() ([][]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '-':
+		return nil, redisError(line[1:])
+	case '*':
+	default:
+		return nil, fmt.Errorf("store: unexpected reply from Redis: %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("store: bad reply from Redis: %q", line)
+	}
+	var vs [][]byte
+	for i := 0; i < n; i++ {
+		v, err := c.readReply()
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// readReply reads a reply that isn't an array. c.mu must be held.
+func (c *Redis) readReply() ([]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("store: bad reply from Redis: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("store: unexpected reply from Redis: %q", line)
+}
@@ -0,0 +1,46 @@
+// Package store keeps runtime state that should outlive the process, such
+// as bans and bookings made through the admin API, as named maps of
+// entries. A directory of files does for one server; Redis lets several
+// share it.
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Store holds maps of entries by key: the bans by prefix, say. Entries are
+// written and removed one at a time, so servers sharing a store only
+// overwrite the entries they changed, and keep each other's.
+// Implementations are safe for concurrent use.
+type Store interface {
+	// Entries returns the map under key, empty if it has none.
+	Entries(key string) (map[string][]byte, error)
+	// Set writes one entry of the map under key.
+	Set(key, field string, value []byte) error
+	// Delete removes one entry of the map under key, if it is there.
+	Delete(key, field string) error
+	Close() error
+}
+
+// validKey keeps keys usable as file names.
+var validKey = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func checkKey(key string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("store: invalid key %q", key)
+	}
+	return nil
+}
+
+// Open opens the store spec describes: redis://[:password@]host[:port][/db]
+// for Redis, with ?prefix= for the keys' prefix (nickcast: by default),
+// or otherwise a directory, optionally written file:<dir>, which is
+// created if need be.
+func Open(spec string) (Store, error) {
+	if strings.HasPrefix(spec, "redis://") {
+		return OpenRedis(spec)
+	}
+	return OpenFile(strings.TrimPrefix(spec, "file:"))
+}
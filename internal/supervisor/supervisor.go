@@ -0,0 +1,251 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"nickcast/internal/clock"
+)
+
+// RestartPolicy decides what happens when a service's Run function returns.
+type RestartPolicy int
+
+const (
+	// Never lets the service stay stopped once Run returns.
+	Never RestartPolicy = iota
+	// OnFailure restarts the service if Run returned an error or panicked.
+	OnFailure
+	// Always restarts the service whenever Run returns, until shutdown.
+	Always
+)
+
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Spec describes one long-lived goroutine owned by the supervisor.
+type Spec struct {
+	Name    string
+	Run     func(ctx context.Context) error
+	Restart RestartPolicy
+
+	// Backoff is the delay before the first restart; it doubles after each
+	// consecutive failure up to MaxBackoff. Zero values use sane defaults.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Order controls shutdown sequencing: services with a lower Order are
+	// stopped (and waited for) before services with a higher one. Ingress
+	// such as the HTTP server should stop first, notifiers last.
+	Order int
+
+	// Critical services take the whole process down (via Shutdown) when they
+	// stop with an error and will not be restarted.
+	Critical bool
+}
+
+type service struct {
+	spec   Spec
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Supervisor starts, restarts and stops the application's long-lived goroutines.
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services []*service
+	stopping bool
+	err      error
+
+	shutdownOnce sync.Once
+	finished     chan struct{}
+
+	onPanic func(service string, v interface{}, stack []byte)
+}
+
+// New returns a supervisor whose services live until parent is cancelled or
+// Shutdown is called.
+func New(parent context.Context) *Supervisor {
+	ctx, cancel := context.WithCancel(parent)
+	s := &Supervisor{
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+	go func() {
+		<-ctx.Done()
+		s.Shutdown()
+	}()
+	return s
+}
+
+// OnPanic sets a function to be told about every panic recovered from a
+// service, before the service is restarted. It is called on the service's
+// goroutine, so it should not block for long.
+func (s *Supervisor) OnPanic(fn func(service string, v interface{}, stack []byte)) {
+	s.mu.Lock()
+	s.onPanic = fn
+	s.mu.Unlock()
+}
+
+// Go starts a new supervised service.
+func (s *Supervisor) Go(spec Spec) {
+	if spec.Backoff <= 0 {
+		spec.Backoff = defaultBackoff
+	}
+	if spec.MaxBackoff <= 0 {
+		spec.MaxBackoff = defaultMaxBackoff
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		log.Printf("Supervisor: not starting %s, shutdown in progress", spec.Name)
+		return
+	}
+
+	// Each service gets its own context so shutdown can stop them one
+	// ordering group at a time.
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := &service{spec: spec, cancel: cancel, done: make(chan struct{})}
+	s.services = append(s.services, svc)
+	go s.supervise(ctx, svc)
+}
+
+func (s *Supervisor) supervise(ctx context.Context, svc *service) {
+	defer close(svc.done)
+	backoff := svc.spec.Backoff
+
+	for {
+		err := s.runProtected(ctx, svc.spec)
+		if ctx.Err() != nil {
+			// Stopped on purpose; nothing to restart.
+			return
+		}
+
+		restart := false
+		switch svc.spec.Restart {
+		case Always:
+			restart = true
+		case OnFailure:
+			restart = err != nil
+		}
+
+		if !restart {
+			if err != nil {
+				log.Printf("Supervisor: %s stopped: %v", svc.spec.Name, err)
+				if svc.spec.Critical {
+					s.fail(fmt.Errorf("%s: %w", svc.spec.Name, err))
+				}
+			}
+			return
+		}
+
+		if err != nil {
+			log.Printf("Supervisor: %s failed: %v; restarting in %s", svc.spec.Name, err, backoff)
+		} else {
+			log.Printf("Supervisor: %s exited; restarting in %s", svc.spec.Name, backoff)
+		}
+
+		t := clock.Default.NewTimer(backoff)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+
+		if err != nil {
+			backoff *= 2
+			if backoff > svc.spec.MaxBackoff {
+				backoff = svc.spec.MaxBackoff
+			}
+		} else {
+			backoff = svc.spec.Backoff
+		}
+	}
+}
+
+// runProtected calls spec.Run, converting a panic into an error so that one
+// misbehaving service can't take the process down with it.
+func (s *Supervisor) runProtected(ctx context.Context, spec Spec) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			log.Printf("Supervisor: panic in %s: %v\n%s", spec.Name, r, stack)
+			err = fmt.Errorf("panic: %v", r)
+			s.mu.Lock()
+			fn := s.onPanic
+			s.mu.Unlock()
+			if fn != nil {
+				fn(spec.Name, r, stack)
+			}
+		}
+	}()
+	return spec.Run(ctx)
+}
+
+func (s *Supervisor) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	go s.Shutdown()
+}
+
+// Shutdown stops all services in ascending Order, waiting for each group to
+// exit before moving on to the next. It is safe to call more than once.
+func (s *Supervisor) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		s.mu.Lock()
+		s.stopping = true
+		services := append([]*service(nil), s.services...)
+		s.mu.Unlock()
+
+		sort.SliceStable(services, func(i, j int) bool {
+			return services[i].spec.Order < services[j].spec.Order
+		})
+
+		for i := 0; i < len(services); {
+			j := i
+			for j < len(services) && services[j].spec.Order == services[i].spec.Order {
+				services[j].cancel()
+				j++
+			}
+			for _, svc := range services[i:j] {
+				<-svc.done
+				log.Printf("Supervisor: %s stopped", svc.spec.Name)
+			}
+			i = j
+		}
+
+		s.cancel()
+		close(s.finished)
+	})
+}
+
+// ErrShutdown is returned by Wait when the supervisor was stopped normally.
+var ErrShutdown = errors.New("supervisor shut down")
+
+// Wait blocks until every service has stopped. It returns the error of the
+// critical service that caused the shutdown, or ErrShutdown otherwise.
+func (s *Supervisor) Wait() error {
+	<-s.finished
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return ErrShutdown
+}
@@ -0,0 +1,105 @@
+// Package transcribe turns the speech in short pieces of a stream into
+// text, using either an external command or an HTTP API (typically a
+// wrapper around Whisper or a cloud speech-to-text service), so nickcast
+// doesn't have to bundle a speech recognizer.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// maxAnswer caps how much of a transcriber's answer is read.
+const maxAnswer = 64 * 1024
+
+// Transcriber writes down what is said. Exactly one of Command and URL is
+// used, Command taking precedence.
+type Transcriber struct {
+	// Command is run with the audio on stdin, its content type in
+	// NICKCAST_CONTENT_TYPE and the mount's language in NICKCAST_LANGUAGE,
+	// and prints what was said to stdout, or nothing if no one spoke. It is
+	// split on spaces; anything fancier belongs in a wrapper script.
+	Command []string
+	// URL receives the audio as a POST with the stream's Content-Type and
+	// the language as Content-Language, and answers 200 with the text as
+	// text/plain, or 204 if no one spoke.
+	URL string
+}
+
+// New builds a Transcriber from the transcribe_command and transcribe_url
+// settings. It returns nil if neither is set.
+func New(command, url string) *Transcriber {
+	if command == "" && url == "" {
+		return nil
+	}
+	return &Transcriber{Command: strings.Fields(command), URL: url}
+}
+
+// Transcribe returns what is said in audio, on one line, or "" if nothing
+// is.
+func (t *Transcriber) Transcribe(ctx context.Context, audio []byte, contentType, language string) (string, error) {
+	var answer []byte
+	var err error
+	if len(t.Command) > 0 {
+		answer, err = t.runCommand(ctx, audio, contentType, language)
+	} else {
+		answer, err = t.post(ctx, audio, contentType, language)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(string(answer)), " "), nil
+}
+
+func (t *Transcriber) runCommand(ctx context.Context, audio []byte, contentType, language string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, t.Command[0], t.Command[1:]...)
+	cmd.Env = append(os.Environ(), "NICKCAST_CONTENT_TYPE="+contentType, "NICKCAST_LANGUAGE="+language)
+	cmd.Stdin = bytes.NewReader(audio)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("transcribe command failed: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() > maxAnswer {
+		return nil, fmt.Errorf("transcribe command printed more than %d bytes", maxAnswer)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (t *Transcriber) post(ctx context.Context, audio []byte, contentType, language string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(audio))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if language != "" {
+		req.Header.Set("Content-Language", language)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("transcribe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("transcribe service returned %s", resp.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxAnswer+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) > maxAnswer {
+		return nil, fmt.Errorf("transcribe service returned more than %d bytes", maxAnswer)
+	}
+	return answer, nil
+}
@@ -0,0 +1,93 @@
+// Package tts turns announcement text into audio using either an external
+// command or an HTTP API, so nickcast doesn't have to bundle a speech engine.
+package tts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// maxAudio caps how much audio one announcement may produce.
+const maxAudio = 16 << 20
+
+// Synth synthesizes speech. Exactly one of Command and URL is used, Command
+// taking precedence.
+type Synth struct {
+	// Command is run with the text on stdin and must write audio in the
+	// mount's format to stdout. It is split on spaces, so pipelines such as
+	// espeak-ng into ffmpeg belong in a small wrapper script.
+	Command []string
+	// URL receives the text as a text/plain POST and answers with audio.
+	URL string
+}
+
+// New builds a Synth from the tts_command and tts_url settings. It returns
+// nil if neither is set.
+func New(command, url string) *Synth {
+	if command == "" && url == "" {
+		return nil
+	}
+	return &Synth{Command: strings.Fields(command), URL: url}
+}
+
+// Speak returns the audio for text.
+func (s *Synth) Speak(ctx context.Context, text string) ([]byte, error) {
+	var audio []byte
+	var err error
+	if len(s.Command) > 0 {
+		audio, err = s.runCommand(ctx, text)
+	} else {
+		audio, err = s.post(ctx, text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(audio) == 0 {
+		return nil, fmt.Errorf("speech synthesizer produced no audio")
+	}
+	return audio, nil
+}
+
+func (s *Synth) runCommand(ctx context.Context, text string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("tts command failed: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() > maxAudio {
+		return nil, fmt.Errorf("tts command produced more than %d bytes", maxAudio)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *Synth) post(ctx context.Context, text string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tts request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tts service returned %s", resp.Status)
+	}
+	audio, err := io.ReadAll(io.LimitReader(resp.Body, maxAudio+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(audio) > maxAudio {
+		return nil, fmt.Errorf("tts service returned more than %d bytes", maxAudio)
+	}
+	return audio, nil
+}
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxControlPayload is the most a control frame may carry.
+const maxControlPayload = 125
+
+var (
+	errUnmasked     = errors.New("websocket: client frame is not masked")
+	errReserved     = errors.New("websocket: reserved bits set")
+	errControl      = errors.New("websocket: invalid control frame")
+	errContinuation = errors.New("websocket: unexpected continuation frame")
+	errInterleaved  = errors.New("websocket: new message before the last one ended")
+	errText         = errors.New("websocket: text messages are not accepted; send the audio as binary")
+)
+
+// nextFrame reads frame headers until one starts binary message data,
+// leaving its payload to Read. Control frames are dealt with on the way.
+func (c *Conn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return err
+	}
+	fin := hdr[0]&0x80 != 0
+	op := hdr[0] & 0x0F
+	if hdr[0]&0x70 != 0 {
+		return c.fail(CloseProtocol, errReserved)
+	}
+	// Clients must mask everything they send.
+	if hdr[1]&0x80 == 0 {
+		return c.fail(CloseProtocol, errUnmasked)
+	}
+	length := int64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return c.fail(CloseProtocol, errors.New("websocket: invalid frame length"))
+		}
+	}
+	if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+
+	if op >= opClose {
+		if !fin || length > maxControlPayload {
+			return c.fail(CloseProtocol, errControl)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		c.unmask(payload)
+		return c.control(op, payload)
+	}
+
+	switch op {
+	case opBinary:
+		if c.inMessage {
+			return c.fail(CloseProtocol, errInterleaved)
+		}
+	case opContinuation:
+		if !c.inMessage {
+			return c.fail(CloseProtocol, errContinuation)
+		}
+	case opText:
+		return c.fail(CloseUnsupported, errText)
+	default:
+		return c.fail(CloseProtocol, errors.New("websocket: unknown opcode"))
+	}
+	c.inMessage = !fin
+	c.remaining = length
+	return nil
+}
+
+// control answers a ping or close frame; pongs are ignored. A close
+// returns the client's *CloseError, after echoing its code as the
+// protocol asks.
+func (c *Conn) control(op byte, payload []byte) error {
+	switch op {
+	case opPing:
+		return c.writeControl(opPong, payload)
+	case opClose:
+		e := &CloseError{Code: CloseNormal}
+		switch {
+		case len(payload) == 1:
+			return c.fail(CloseProtocol, errControl)
+		case len(payload) >= 2:
+			e.Code = int(binary.BigEndian.Uint16(payload))
+			e.Reason = string(payload[2:])
+		}
+		c.writeClose(e.Code, "")
+		return e
+	case opPong:
+		return nil
+	}
+	return c.fail(CloseProtocol, errors.New("websocket: unknown opcode"))
+}
+
+// unmask applies the current frame's mask to the next len(p) bytes of its
+// payload.
+func (c *Conn) unmask(p []byte) {
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+// writeFrame writes an unmasked frame, as servers send them, in one write.
+func writeFrame(w io.Writer, op byte, payload []byte) error {
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,200 @@
+// Package websocket receives streams sent over WebSocket, which is how
+// browser-based broadcasting tools (WebAudio and MediaRecorder) get audio
+// out of a page. It does only what a server reading one stream per
+// connection needs: the opening handshake, binary messages read as one
+// continuous stream, pings, and the closing handshake. Text messages are
+// refused; the server only sends them, for feeds such as live captions.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to make Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Close codes, for Close and CloseError.
+const (
+	CloseNormal      = 1000
+	CloseGoingAway   = 1001
+	CloseProtocol    = 1002
+	CloseUnsupported = 1003 // the data is of a kind the server won't take
+)
+
+// CloseError is returned by Read once the client has closed the
+// connection, with the code and reason it gave.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return "websocket: closed by client (" + strconv.Itoa(e.Code) + ")"
+	}
+	return "websocket: closed by client (" + strconv.Itoa(e.Code) + " " + e.Reason + ")"
+}
+
+// IsUpgrade reports whether r asks to open a WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerHas(r.Header, "Connection", "upgrade") && headerHas(r.Header, "Upgrade", "websocket")
+}
+
+// Conn is a client connection. Read returns the payloads of its binary
+// messages back to back; Close ends it.
+type Conn struct {
+	nc net.Conn
+	br *bufio.Reader
+
+	wmu    sync.Mutex // serializes control frames written by Read and Close
+	closed bool       // a close frame has gone out
+
+	remaining int64 // unread payload of the current frame
+	mask      [4]byte
+	maskPos   int
+	inMessage bool // a binary message has started and not finished
+}
+
+// Upgrade answers a WebSocket opening handshake and takes the connection
+// over from net/http. Requests that aren't a valid handshake get an error
+// status and a nil Conn.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version " + r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: invalid key")
+	}
+
+	nc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Clear any deadline the server set for reading the request itself.
+	nc.SetDeadline(time.Time{})
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	reply := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := brw.WriteString(reply); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &Conn{nc: nc, br: brw.Reader}, nil
+}
+
+// RemoteAddr returns the client's address.
+func (c *Conn) RemoteAddr() net.Addr { return c.nc.RemoteAddr() }
+
+// SetReadDeadline sets the deadline for reads, as on a net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.nc.SetReadDeadline(t) }
+
+// Read reads binary message data, answering pings and skipping pongs on
+// the way. It returns a *CloseError once the client closes the connection.
+// A protocol violation or a text message closes the connection with the
+// matching code, and is returned as an error.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// WriteText sends p as one text message, which must be valid UTF-8.
+func (c *Conn) WriteText(p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return writeFrame(c.nc, opText, p)
+}
+
+// Close sends a close frame with code and reason, unless one has already
+// gone out, and closes the connection. Browsers hand the reason to the
+// page, so it can say what went wrong.
+func (c *Conn) Close(code int, reason string) error {
+	c.nc.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.writeClose(code, reason)
+	return c.nc.Close()
+}
+
+// closeTimeout bounds how long Close waits to send the close frame to a
+// client that has stopped reading.
+const closeTimeout = 5 * time.Second
+
+func (c *Conn) writeClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := append([]byte{byte(code >> 8), byte(code)}, reason...)
+	return writeFrame(c.nc, opClose, payload)
+}
+
+func (c *Conn) writeControl(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	return writeFrame(c.nc, op, payload)
+}
+
+// fail closes the connection over a protocol violation and returns err.
+func (c *Conn) fail(code int, err error) error {
+	c.writeClose(code, err.Error())
+	return err
+}
+
+// headerHas reports whether any comma-separated value of header name is
+// token, ignoring case.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,666 @@
+// Package client talks to a NickCast server's HTTP API, for bots and
+// automation written in Go. It follows the OpenAPI document the server
+// publishes at /api/openapi.json; the types here mirror its schemas.
+//
+// Requests authenticate with the NickServ account given to New. Requests
+// that are safe to repeat are retried when the server is briefly
+// unavailable or the connection fails.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Client is a NickCast API client. The zero value is not usable; call New.
+type Client struct {
+	BaseURL  string // e.g. https://radio.example.org:8000
+	User     string // NickServ account for authenticated endpoints
+	Password string
+	HTTP     *http.Client
+
+	// Retries is how many times a failed idempotent request is repeated;
+	// RetryWait is the first pause, doubling each time. A Retry-After from
+	// the server takes precedence, up to MaxRetryWait.
+	Retries      int
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration
+}
+
+// New returns a client for the server at baseURL, authenticating as user.
+// user and password may be empty for public endpoints only.
+func New(baseURL, user, password string) *Client {
+	return &Client{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		User:     user,
+		Password: password,
+		HTTP:     &http.Client{Timeout: time.Minute},
+
+		Retries:      2,
+		RetryWait:    500 * time.Millisecond,
+		MaxRetryWait: 30 * time.Second,
+	}
+}
+
+// Error is a non-2xx response. Code is one of the codes the OpenAPI
+// document's Error schema lists, such as "not_found" or "rate_limited";
+// Message is the server's reason, for people.
+type Error struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter time.Duration // how long the server asked to be left alone, if it did
+	RequestID  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nickcast: %d %s", e.Status, e.Message)
+}
+
+// Errors to test for with errors.Is.
+var (
+	ErrUnauthorized = errors.New("nickcast: bad or missing credentials")
+	ErrForbidden    = errors.New("nickcast: not allowed")
+	ErrNotFound     = errors.New("nickcast: not found")
+
+	// ErrStreamEnded is returned by Stream when the server ends a stream
+	// before its audio has run out, e.g. because an admin kicked it.
+	ErrStreamEnded = errors.New("nickcast: the server ended the stream")
+)
+
+// Is lets errors.Is match an *Error against ErrUnauthorized, ErrForbidden
+// and ErrNotFound by status.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	}
+	return false
+}
+
+// ListOptions are the paging and sorting parameters every list endpoint
+// accepts. Sort is a field name, prefixed with "-" for descending order.
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Sort   string
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	if o.Limit > 0 {
+		v.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		v.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Sort != "" {
+		v.Set("sort", o.Sort)
+	}
+	return v
+}
+
+// send makes a request and returns the response whatever its status,
+// retrying idempotent requests without a body on connection errors and on
+// 429, 502, 503 and 504.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	retry := body == nil && (method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete)
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		if c.User != "" {
+			req.SetBasicAuth(c.User, c.Password)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.HTTP.Do(req)
+		if !retry || attempt >= c.Retries || ctx.Err() != nil {
+			return resp, err
+		}
+		pause := wait
+		if err == nil {
+			if !retryable(resp.StatusCode) {
+				return resp, nil
+			}
+			if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs >= 0 {
+				pause = time.Duration(secs) * time.Second
+			}
+			resp.Body.Close()
+		}
+		if c.MaxRetryWait > 0 && pause > c.MaxRetryWait {
+			pause = c.MaxRetryWait
+		}
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do is send for callers that only expect success: any other status is
+// returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	resp, err := c.send(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError reads a failed response's error. The server answers in
+// JSON; anything else, from a proxy in front of it say, becomes the
+// Message as it is.
+func responseError(resp *http.Response) *Error {
+	e := &Error{Status: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var v struct {
+		Code       string `json:"code"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after"`
+		RequestID  string `json:"request_id"`
+	}
+	if json.Unmarshal(body, &v) == nil && v.Code != "" {
+		e.Code, e.Message, e.RequestID = v.Code, v.Message, v.RequestID
+		e.RetryAfter = time.Duration(v.RetryAfter) * time.Second
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return e
+}
+
+// call sends a request and decodes a JSON response into out, if non-nil.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, out interface{}) (*http.Response, error) {
+	resp, err := c.do(ctx, method, path, query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("nickcast: decoding %s response: %w", path, err)
+		}
+	}
+	return resp, nil
+}
+
+// list fetches one page of a list endpoint into out and returns the total
+// number of matching entries.
+func (c *Client) list(ctx context.Context, path string, query url.Values, out interface{}) (int, error) {
+	resp, err := c.call(ctx, http.MethodGet, path, query, out)
+	if err != nil {
+		return 0, err
+	}
+	total, _ := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	return total, nil
+}
+
+func merge(v url.Values, kv ...string) url.Values {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			v.Set(kv[i], kv[i+1])
+		}
+	}
+	return v
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Stations lists the stations with their mounts and listener counts.
+func (c *Client) Stations(ctx context.Context) ([]Station, error) {
+	var out []Station
+	_, err := c.call(ctx, http.MethodGet, "/api/stations", nil, &out)
+	return out, err
+}
+
+// Compat recommends which stream to give the player with the User-Agent
+// ua, starting from mount; either may be empty for the server's defaults.
+func (c *Client) Compat(ctx context.Context, ua, mount string) (*Compat, error) {
+	var out Compat
+	_, err := c.call(ctx, http.MethodGet, "/api/compat", merge(url.Values{}, "ua", ua, "mount", mount), &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// NowPlayingText returns the title on air from /nowplaying.txt, "" while
+// nothing titled is; mount may be empty for the host's station.
+func (c *Client) NowPlayingText(ctx context.Context, mount string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/nowplaying.txt", merge(url.Values{}, "mount", mount), nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	title, err := io.ReadAll(resp.Body)
+	return string(title), err
+}
+
+// CheckSource runs a source pre-flight check. A stream that would be
+// rejected is not an error: see the report's OK and Checks.
+func (c *Client) CheckSource(ctx context.Context, mount, contentType string) (*SourceCheckReport, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/api/source/check", merge(url.Values{}, "mount", mount, "content_type", contentType), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return nil, responseError(resp)
+	}
+	var report SourceCheckReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Heartbeat tells the server the source that connected with the session
+// token token is still alive; see the mount's heartbeat_timeout.
+func (c *Client) Heartbeat(ctx context.Context, token string) error {
+	_, err := c.call(ctx, http.MethodPost, "/api/source/heartbeat", url.Values{"token": {token}}, nil)
+	return err
+}
+
+// Resume returns where a source streaming to mount as the client's user
+// should carry on from after its connection dropped, while the server
+// holds the mount for it to reconnect. There is nothing to resume, and a
+// 404 *Error, once the mount's reconnect_grace has run out.
+func (c *Client) Resume(ctx context.Context, mount string) (*Resume, error) {
+	var out Resume
+	_, err := c.call(ctx, http.MethodGet, "/api/source/resume", url.Values{"mount": {mount}}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StreamOptions are what a source tells the server about its stream.
+// Empty fields are left out.
+type StreamOptions struct {
+	ContentType string // of the audio; audio/mpeg if empty
+
+	// Shown to listeners and directories, as an encoder's ice-* headers.
+	Name, Description, Genre, URL string
+
+	// Takeover replaces the account's own live session, as takeover=1
+	// does; Override broadcasts over someone else's booking. Both are for
+	// admins only.
+	Takeover, Override bool
+
+	// ResumeOffset, if not zero, carries on a dropped stream from the
+	// Offset Resume returned; audio must start that far into the source.
+	ResumeOffset int64
+}
+
+// Stream broadcasts audio to the mount at path (its source path, such as
+// /stream) as the client's user, the way an Icecast source client does,
+// until audio runs out, ctx is cancelled or the server ends the stream.
+// A refusal, such as a 409 while someone else is on air, is an *Error.
+// Streams are never retried, and aren't subject to the client's timeout.
+func (c *Client) Stream(ctx context.Context, path string, audio io.Reader, opts StreamOptions) error {
+	src := &sourceReader{r: audio}
+	// Wrapped so the transport sends it chunked and doesn't close it.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.BaseURL+path, io.NopCloser(src))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.User, c.Password)
+	if opts.ContentType == "" {
+		opts.ContentType = "audio/mpeg"
+	}
+	req.Header.Set("Content-Type", opts.ContentType)
+	for name, v := range map[string]string{
+		"Ice-Name":        opts.Name,
+		"Ice-Description": opts.Description,
+		"Ice-Genre":       opts.Genre,
+		"Ice-Url":         opts.URL,
+	} {
+		if v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	if opts.Takeover {
+		req.Header.Set("X-Source-Takeover", "1")
+	}
+	if opts.Override {
+		req.Header.Set("X-Source-Override", "1")
+	}
+	if opts.ResumeOffset > 0 {
+		req.Header.Set("X-Resume-Offset", strconv.FormatInt(opts.ResumeOffset, 10))
+	}
+
+	hc := *c.HTTP
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	// The server answers as soon as the stream is on air and ends the
+	// reply when the stream is over, however it ends.
+	_, err = io.Copy(io.Discard, resp.Body)
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case src.done.Load():
+		return nil
+	case err != nil:
+		return err
+	}
+	return ErrStreamEnded
+}
+
+// sourceReader notes when a stream's audio has run out.
+type sourceReader struct {
+	r    io.Reader
+	done atomic.Bool // read by Stream while the transport reads r
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		s.done.Store(true)
+	}
+	return n, err
+}
+
+// ShowFilter narrows Shows. Empty fields don't filter.
+type ShowFilter struct {
+	Mount, Account, Status string
+	Since, Until           time.Time
+}
+
+// Shows lists the schedule. Sort by at, title or uploaded.
+// The total number of matching shows is returned alongside the page.
+func (c *Client) Shows(ctx context.Context, f ShowFilter, o ListOptions) ([]Show, int, error) {
+	var out []Show
+	total, err := c.list(ctx, "/api/shows", merge(o.values(),
+		"mount", f.Mount, "account", f.Account, "status", f.Status,
+		"since", formatTime(f.Since), "until", formatTime(f.Until)), &out)
+	return out, total, err
+}
+
+// UploadShow schedules a pre-recorded show on mount at the given time.
+func (c *Client) UploadShow(ctx context.Context, mount string, at time.Time, title string, audio io.Reader, contentType string) (*Show, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/api/shows", merge(url.Values{}, "mount", mount, "at", formatTime(at), "title", title), audio, contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var s Show
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteShow withdraws a show that hasn't aired.
+func (c *Client) DeleteShow(ctx context.Context, id string) error {
+	_, err := c.call(ctx, http.MethodDelete, "/api/shows", url.Values{"id": {id}}, nil)
+	return err
+}
+
+// ArchiveFilter narrows Archive. Empty fields don't filter.
+type ArchiveFilter struct {
+	Mount, Account string
+	Since, Until   time.Time
+}
+
+// Archive lists recordings. Sort by modified, size or id.
+// The total number of matching recordings is returned alongside the page.
+func (c *Client) Archive(ctx context.Context, f ArchiveFilter, o ListOptions) ([]ArchiveEntry, int, error) {
+	var out []ArchiveEntry
+	total, err := c.list(ctx, "/archive", merge(o.values(),
+		"mount", f.Mount, "account", f.Account,
+		"since", formatTime(f.Since), "until", formatTime(f.Until)), &out)
+	return out, total, err
+}
+
+// Chapters returns a recording split at its metadata changes.
+func (c *Client) Chapters(ctx context.Context, id string) ([]Chapter, error) {
+	var out []Chapter
+	_, err := c.call(ctx, http.MethodGet, "/archive/"+url.PathEscape(id)+".chapters.json", nil, &out)
+	return out, err
+}
+
+// Download opens a recording. format is an extension such as "mp3" or
+// "opus" (empty for the original) and bitrate e.g. "96k" (empty for the
+// server default). The caller closes the returned body.
+func (c *Client) Download(ctx context.Context, id, format, bitrate string) (io.ReadCloser, error) {
+	path := "/archive/" + url.PathEscape(id)
+	if format != "" {
+		path += "." + format
+	}
+	resp, err := c.do(ctx, http.MethodGet, path, merge(url.Values{}, "bitrate", bitrate), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// UpdateMetadata sets the title on the mount the caller is streaming to.
+func (c *Client) UpdateMetadata(ctx context.Context, mount, title string) error {
+	_, err := c.call(ctx, http.MethodGet, "/admin/metadata", url.Values{"mount": {mount}, "mode": {"updinfo"}, "song": {title}}, nil)
+	return err
+}
+
+// ListenerFilter narrows Listeners. Empty fields don't filter.
+type ListenerFilter struct {
+	Mount, IP, Device string
+}
+
+// Listeners lists connected listeners. Sort by connected, ip or mount.
+// The total number of matching listeners is returned alongside the page.
+func (c *Client) Listeners(ctx context.Context, f ListenerFilter, o ListOptions) ([]Listener, int, error) {
+	var out []Listener
+	total, err := c.list(ctx, "/admin/listclients", merge(o.values(), "mount", f.Mount, "ip", f.IP, "device", f.Device), &out)
+	return out, total, err
+}
+
+// Bans lists active bans. Sort by prefix or until.
+// The total number of bans is returned alongside the page.
+func (c *Client) Bans(ctx context.Context, o ListOptions) ([]Ban, int, error) {
+	var out []Ban
+	total, err := c.list(ctx, "/admin/bans", o.values(), &out)
+	return out, total, err
+}
+
+// Ban bans an address or CIDR prefix; a zero duration bans indefinitely.
+func (c *Client) Ban(ctx context.Context, prefix string, d time.Duration) error {
+	v := url.Values{"ip": {prefix}}
+	if d > 0 {
+		v.Set("duration", strconv.Itoa(int(d.Seconds())))
+	}
+	_, err := c.call(ctx, http.MethodPost, "/admin/bans", v, nil)
+	return err
+}
+
+// Unban lifts a runtime ban.
+func (c *Client) Unban(ctx context.Context, prefix string) error {
+	_, err := c.call(ctx, http.MethodDelete, "/admin/bans", url.Values{"ip": {prefix}}, nil)
+	return err
+}
+
+// Variants reports how listeners are split between the arms of one mount's
+// variants, or of every mount's if mount is empty.
+func (c *Client) Variants(ctx context.Context, mount string) ([]Variant, error) {
+	var out []Variant
+	_, err := c.call(ctx, http.MethodGet, "/admin/variants", merge(url.Values{}, "mount", mount), &out)
+	return out, err
+}
+
+// Quotas reports usage against quotas for one station, or for all of them
+// if station is empty.
+func (c *Client) Quotas(ctx context.Context, station string) ([]Quota, error) {
+	var out []Quota
+	_, err := c.call(ctx, http.MethodGet, "/admin/quotas", merge(url.Values{}, "station", station), &out)
+	return out, err
+}
+
+// Diagnostics analyses the live sources, on one mount or all of them.
+func (c *Client) Diagnostics(ctx context.Context, mount string) ([]SourceDiagnostics, error) {
+	var out []SourceDiagnostics
+	_, err := c.call(ctx, http.MethodGet, "/admin/diagnostics", merge(url.Values{}, "mount", mount), &out)
+	return out, err
+}
+
+// Runtime reports goroutines, file descriptors and per-subsystem counts.
+func (c *Client) Runtime(ctx context.Context) (*Runtime, error) {
+	var out Runtime
+	if _, err := c.call(ctx, http.MethodGet, "/admin/runtime", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ExportState downloads a snapshot of the server's runtime state, as
+// JSON to be saved and named in import_state on the new host.
+func (c *Client) ExportState(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/admin/state", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Preview returns the last seconds of what's on air on mount.
+func (c *Client) Preview(ctx context.Context, mount string, seconds int) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/admin/preview", url.Values{"mount": {mount}, "seconds": {strconv.Itoa(seconds)}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Clip saves the last seconds of mount as a clip.
+func (c *Client) Clip(ctx context.Context, mount string, seconds int, title string) (*Clip, error) {
+	var out Clip
+	v := merge(url.Values{"mount": {mount}}, "title", title)
+	if seconds > 0 {
+		v.Set("seconds", strconv.Itoa(seconds))
+	}
+	if _, err := c.call(ctx, http.MethodPost, "/admin/clip", v, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartCapture captures the next seconds of mount's raw source input and,
+// if listener is not empty, of what is sent to that listener.
+func (c *Client) StartCapture(ctx context.Context, mount string, seconds int, listener string) (*Capture, error) {
+	var out Capture
+	v := merge(url.Values{"mount": {mount}}, "listener", listener)
+	if seconds > 0 {
+		v.Set("seconds", strconv.Itoa(seconds))
+	}
+	if _, err := c.call(ctx, http.MethodPost, "/admin/capture", v, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Capture returns the capture running on mount.
+func (c *Client) Capture(ctx context.Context, mount string) (*Capture, error) {
+	var out Capture
+	if _, err := c.call(ctx, http.MethodGet, "/admin/capture", url.Values{"mount": {mount}}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CaptureFile opens one of the files a capture on mount wrote. The caller
+// closes the returned body.
+func (c *Client) CaptureFile(ctx context.Context, mount, name string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/admin/capture", url.Values{"mount": {mount}, "file": {name}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Announcements lists queued announcements, on one mount or all of them.
+func (c *Client) Announcements(ctx context.Context, mount string) ([]Announcement, error) {
+	var out []Announcement
+	_, err := c.call(ctx, http.MethodGet, "/admin/announce", merge(url.Values{}, "mount", mount), &out)
+	return out, err
+}
+
+// Slots lists time-boxed DJ sessions, on one mount or all of them.
+func (c *Client) Slots(ctx context.Context, mount string) ([]Slot, error) {
+	var out []Slot
+	_, err := c.call(ctx, http.MethodGet, "/admin/slots", merge(url.Values{}, "mount", mount), &out)
+	return out, err
+}
+
+// GrantSlot gives account a session on mount that ends at ends.
+func (c *Client) GrantSlot(ctx context.Context, mount, account string, ends time.Time) (*Slot, error) {
+	var out Slot
+	v := url.Values{"mount": {mount}, "account": {account}, "until": {formatTime(ends)}}
+	if _, err := c.call(ctx, http.MethodPost, "/admin/slots", v, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokeSlot removes account's slot on mount.
+func (c *Client) RevokeSlot(ctx context.Context, mount, account string) error {
+	_, err := c.call(ctx, http.MethodDelete, "/admin/slots", url.Values{"mount": {mount}, "account": {account}}, nil)
+	return err
+}
+
+// Announce queues a text-to-speech announcement; a zero at means now.
+func (c *Client) Announce(ctx context.Context, mount, text string, at time.Time) (*Announcement, error) {
+	var out Announcement
+	v := merge(url.Values{"mount": {mount}, "text": {text}}, "at", formatTime(at))
+	if _, err := c.call(ctx, http.MethodPost, "/admin/announce", v, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
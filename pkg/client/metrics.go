@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Sample is one value from /metrics.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Metrics fetches the server's Prometheus metrics, for bots that want a
+// number or two without running Prometheus.
+func (c *Client) Metrics(ctx context.Context) ([]Sample, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/metrics", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out []Sample
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if s, ok := parseSample(sc.Text()); ok {
+			out = append(out, s)
+		}
+	}
+	return out, sc.Err()
+}
+
+// Metric returns the value of the named metric whose labels include all of
+// the given ones, e.g. Metric(samples, "nickcast_station_listeners",
+// "station", "default").
+func Metric(samples []Sample, name string, labels ...string) (float64, bool) {
+next:
+	for _, s := range samples {
+		if s.Name != name {
+			continue
+		}
+		for i := 0; i+1 < len(labels); i += 2 {
+			if s.Labels[labels[i]] != labels[i+1] {
+				continue next
+			}
+		}
+		return s.Value, true
+	}
+	return 0, false
+}
+
+// parseSample reads one line of the Prometheus text format.
+func parseSample(line string) (Sample, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Sample{}, false
+	}
+	var s Sample
+	sp := strings.LastIndexByte(line, ' ')
+	if sp < 0 {
+		return Sample{}, false
+	}
+	v, err := strconv.ParseFloat(line[sp+1:], 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	s.Value = v
+	s.Name = line[:sp]
+	if i := strings.IndexByte(s.Name, '{'); i >= 0 && strings.HasSuffix(s.Name, "}") {
+		s.Labels = parseLabels(s.Name[i+1 : len(s.Name)-1])
+		s.Name = s.Name[:i]
+	}
+	return s, true
+}
+
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := s[:eq]
+		rest := s[eq+1:]
+		// The value is a Go-style quoted string, which may contain commas.
+		value, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			break
+		}
+		labels[key], _ = strconv.Unquote(value)
+		s = strings.TrimPrefix(rest[len(value):], ",")
+	}
+	return labels
+}
@@ -0,0 +1,281 @@
+package client
+
+import "time"
+
+// Station is one tenant on the server, from /api/stations.
+type Station struct {
+	Name        string         `json:"name"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Genre       string         `json:"genre,omitempty"`
+	Hosts       []string       `json:"hosts,omitempty"`
+	Listeners   int            `json:"listeners"`
+	Mounts      []StationMount `json:"mounts"`
+}
+
+// StationMount is one of a station's mounts.
+type StationMount struct {
+	Name       string        `json:"name"`
+	ListenPath string        `json:"listen_path"`
+	Live       bool          `json:"live"`
+	Listeners  int           `json:"listeners"`
+	Stream     *SourceStream `json:"stream,omitempty"`
+}
+
+// SourceStream is how a live source describes its stream, and its title.
+type SourceStream struct {
+	Name        string `json:"name,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Bitrate     int    `json:"bitrate,omitempty"` // kbit/s
+	AudioInfo   string `json:"audio_info,omitempty"`
+	Title       string `json:"title,omitempty"`
+}
+
+// Compat is the stream /api/compat recommends for a player.
+type Compat struct {
+	UserAgent    string         `json:"user_agent"`
+	Client       string         `json:"client"` // apple, radio, player, browser or unknown
+	Device       string         `json:"device,omitempty"`
+	Formats      []string       `json:"formats"`
+	Metadata     bool           `json:"metadata"`
+	Supported    bool           `json:"supported"`
+	Recommended  CompatStream   `json:"recommended"`
+	Alternatives []CompatStream `json:"alternatives"`
+}
+
+// CompatStream is a stream a player can be given.
+type CompatStream struct {
+	Mount       string `json:"mount"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Format      string `json:"format"`
+	Bitrate     int    `json:"bitrate,omitempty"`
+	Live        bool   `json:"live"`
+}
+
+// SourceCheckReport is the result of a source pre-flight check.
+type SourceCheckReport struct {
+	OK      bool          `json:"ok"`
+	Account string        `json:"account,omitempty"`
+	Mount   string        `json:"mount,omitempty"`
+	Checks  []SourceCheck `json:"checks"`
+}
+
+// SourceCheck is one line of a SourceCheckReport.
+type SourceCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// Resume is where a dropped source can carry on from.
+type Resume struct {
+	Mount          string `json:"mount"`
+	Account        string `json:"account"`
+	Offset         int64  `json:"offset"`          // bytes of the source's audio listeners were sent
+	GraceRemaining int    `json:"grace_remaining"` // seconds left to reconnect
+}
+
+// Show is an uploaded, pre-recorded show.
+type Show struct {
+	ID          string    `json:"id"`
+	Mount       string    `json:"mount"`
+	Account     string    `json:"account"`
+	Title       string    `json:"title,omitempty"`
+	At          time.Time `json:"at"`
+	Duration    float64   `json:"duration"` // seconds
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Ext         string    `json:"ext"`
+	Status      string    `json:"status"` // scheduled, airing, aired, missed or failed
+	Uploaded    time.Time `json:"uploaded"`
+}
+
+// ArchiveEntry is one recording in the archive.
+type ArchiveEntry struct {
+	ID       string    `json:"id"`
+	Format   string    `json:"format"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Sidecar  *Sidecar  `json:"sidecar,omitempty"`
+	Peaks    bool      `json:"peaks"`
+	Captions bool      `json:"captions"`
+}
+
+// Sidecar describes who and what was on air during a recording.
+type Sidecar struct {
+	Mount         string    `json:"mount"`
+	Account       string    `json:"account"`
+	Show          string    `json:"show,omitempty"`
+	SessionID     string    `json:"session_id,omitempty"`
+	ContentType   string    `json:"content_type"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end,omitempty"`
+	Bytes         int64     `json:"bytes"`
+	PeakListeners int       `json:"peak_listeners"`
+	Tracks        []Track   `json:"tracks"`
+	Loudness      *Loudness `json:"loudness,omitempty"`
+}
+
+// Track is a metadata change during a recording.
+type Track struct {
+	Title  string  `json:"title"`
+	Offset float64 `json:"offset"` // seconds since the recording started
+	Byte   int64   `json:"byte"`
+}
+
+// Loudness holds EBU R128 measurements of a recording.
+type Loudness struct {
+	Integrated float64 `json:"integrated_lufs"`
+	Range      float64 `json:"range_lu"`
+	TruePeak   float64 `json:"true_peak_dbfs"`
+}
+
+// Chapter is the stretch of a recording between two metadata changes.
+type Chapter struct {
+	Number    int     `json:"number"`
+	Title     string  `json:"title"`
+	Start     float64 `json:"start"`
+	End       float64 `json:"end"`
+	StartByte int64   `json:"start_byte"`
+	EndByte   int64   `json:"end_byte"`
+}
+
+// Listener is a connected listener, from /admin/listclients.
+type Listener struct {
+	ID        string    `json:"id"`
+	Mount     string    `json:"mount"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Device    string    `json:"device,omitempty"`
+	Connected time.Time `json:"connected"`
+	Buffered  int64     `json:"buffered_bytes"` // queued and not yet sent
+}
+
+// Ban is an active ban. Until is nil for indefinite bans.
+type Ban struct {
+	Prefix  string     `json:"prefix"`
+	Until   *time.Time `json:"until,omitempty"`
+	FromCfg bool       `json:"from_config,omitempty"`
+}
+
+// Runtime is the server's goroutine and file descriptor usage.
+type Runtime struct {
+	Goroutines          int              `json:"goroutines"`
+	GoroutineSoftLimit  int              `json:"goroutine_soft_limit,omitempty"`
+	OpenFDs             int              `json:"open_fds"` // -1 if unknown
+	FDSoftLimit         int              `json:"fd_soft_limit,omitempty"`
+	Subsystems          map[string]int64 `json:"subsystems"`
+	RegisteredListeners int              `json:"registered_listeners"`
+}
+
+// Variant is one arm of a mount's listener trial. The control arm is the
+// listeners the mount keeps. Listeners is set for arms served by a mount
+// on the same server.
+type Variant struct {
+	Mount     string `json:"mount"`
+	Variant   string `json:"variant"`
+	Weight    int    `json:"weight"`
+	Target    string `json:"target"`
+	Routed    int64  `json:"routed"`
+	Listeners *int   `json:"listeners,omitempty"`
+}
+
+// Quota is a station's usage against its limits; a limit of 0 is unlimited.
+type Quota struct {
+	Station string `json:"station"`
+	Mounts  struct {
+		Used  int `json:"used"`
+		Limit int `json:"limit"`
+	} `json:"mounts"`
+	Listeners struct {
+		Used  int64 `json:"used"`
+		Limit int   `json:"limit"`
+	} `json:"listeners"`
+	Bandwidth struct {
+		UsedKbps int64 `json:"used_kbps"`
+		Limit    int   `json:"limit_kbps"`
+	} `json:"bandwidth"`
+	RecordDisk struct {
+		Used  int64 `json:"used_bytes"`
+		Limit int64 `json:"limit_bytes"`
+	} `json:"record_disk"`
+}
+
+// SourceDiagnostics is the analysis of a live source.
+type SourceDiagnostics struct {
+	Mount       string      `json:"mount"`
+	Account     string      `json:"account"`
+	Session     string      `json:"session_id"`
+	RemoteAddr  string      `json:"remote_addr"`
+	ContentType string      `json:"content_type"`
+	Connected   float64     `json:"connected_seconds"`
+	Bytes       int64       `json:"bytes"`
+	ByteRate    float64     `json:"bytes_per_second"`
+	NominalRate float64     `json:"nominal_bytes_per_second,omitempty"`
+	Frames      *FrameStats `json:"frames,omitempty"`
+	VBR         bool        `json:"vbr,omitempty"`
+	Drift       float64     `json:"drift_seconds,omitempty"`
+	Trimmed     int64       `json:"trimmed_frames,omitempty"`
+	IngestLimit float64     `json:"ingest_limit_bytes_per_second,omitempty"`
+	HeldBack    float64     `json:"held_back_seconds,omitempty"`
+}
+
+// FrameStats describes the MP3 frames a source has sent.
+type FrameStats struct {
+	Frames       int64   `json:"frames"`
+	Bytes        int64   `json:"bytes"`
+	SyncErrors   int64   `json:"sync_errors"`
+	SkippedBytes int64   `json:"skipped_bytes"`
+	Version      float64 `json:"version"`
+	Layer        int     `json:"layer"`
+	Bitrate      int     `json:"bitrate"`
+	MinBitrate   int     `json:"min_bitrate"`
+	MaxBitrate   int     `json:"max_bitrate"`
+	SampleRate   int     `json:"sample_rate"`
+	Mode         string  `json:"mode"`
+}
+
+// Clip is a saved excerpt of a mount.
+type Clip struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Bytes int    `json:"bytes"`
+}
+
+// Capture is a debug capture of a mount's source input and, optionally,
+// one listener's output.
+type Capture struct {
+	ID            string    `json:"id"`
+	Mount         string    `json:"mount"`
+	Started       time.Time `json:"started"`
+	Until         time.Time `json:"until"`
+	SourceFile    string    `json:"source_file"`
+	SourceBytes   int64     `json:"source_bytes"`
+	Listener      string    `json:"listener,omitempty"`
+	ListenerFile  string    `json:"listener_file,omitempty"`
+	ListenerBytes int64     `json:"listener_bytes"`
+	MetaInt       int       `json:"icy_metaint,omitempty"` // ICY interval in the listener file
+	Done          bool      `json:"done"`
+}
+
+// Slot is a time-boxed DJ session.
+type Slot struct {
+	Mount     string    `json:"mount"`
+	Account   string    `json:"account"`
+	Ends      time.Time `json:"ends"`
+	GrantedBy string    `json:"granted_by"`
+}
+
+// Announcement is a queued text-to-speech announcement.
+type Announcement struct {
+	ID       string    `json:"id"`
+	Mount    string    `json:"mount"`
+	Account  string    `json:"account"`
+	Text     string    `json:"text"`
+	At       time.Time `json:"at"`
+	Duration float64   `json:"duration"` // seconds
+}